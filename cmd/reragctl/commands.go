@@ -0,0 +1,777 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/eval"
+	"rerag-rbac-rag-llm/internal/loadtest"
+	"rerag-rbac-rag-llm/pkg/client"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ingestExtensions are the file types the server can extract text from
+var ingestExtensions = map[string]bool{
+	".pdf":      true,
+	".docx":     true,
+	".html":     true,
+	".htm":      true,
+	".md":       true,
+	".markdown": true,
+	".txt":      true,
+}
+
+// ingest uploads files, walking directories for supported file types. It keeps
+// going after a failed file and reports how many failed.
+func (c *command) ingest(ctx context.Context, args []string) error {
+	flags := c.flags("ingest")
+	title := flags.String("title", "", "document title; only valid for a single file")
+	paths, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("%w: ingest needs a file or directory", errUsage)
+	}
+
+	files, err := collectFiles(paths)
+	if err != nil {
+		return err
+	}
+	if *title != "" && len(files) != 1 {
+		return fmt.Errorf("%w: --title needs exactly one file, got %d", errUsage, len(files))
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, path := range files {
+		if err := c.upload(ctx, api, path, *title); err != nil {
+			_, _ = fmt.Fprintf(c.stderr, "failed to ingest %s: %v\n", path, err)
+			failed++
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+	return nil
+}
+
+func (c *command) upload(ctx context.Context, api *client.Client, path, title string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	resp, err := api.UploadDocument(ctx, path, f, title)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.stdout, "%s: %d document(s), source %s\n", path, len(resp.DocumentIDs), resp.SourceID)
+	return nil
+}
+
+// collectFiles expands directories into the supported files below them.
+// Files named explicitly are uploaded whatever their extension.
+func collectFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, root := range paths {
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, root)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.IsDir() && ingestExtensions[strings.ToLower(filepath.Ext(path))] {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// query streams the answer to stdout followed by the sources
+func (c *command) query(ctx context.Context, args []string) error {
+	flags := c.flags("query")
+	topK := flags.Int("top-k", 0, "number of documents to retrieve (server default if 0)")
+	hybrid := flags.Bool("hybrid", false, "combine vector and keyword search")
+	minScore := flags.Float64("min-score", 0, "drop sources scoring below this (0 to 1)")
+	template := flags.String("template", "", "prompt template")
+	noCache := flags.Bool("no-cache", false, "skip the query cache")
+	filters := flags.String("filters", "", `metadata filters as JSON, e.g. '{"form": "1040", "year": {"gte": 2023}}'`)
+	asJSON := flags.Bool("json", false, "print the full response as JSON instead of streaming")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: query needs exactly one question", errUsage)
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	req := client.QueryRequest{
+		Question: positional[0],
+		TopK:     *topK,
+		MinScore: *minScore,
+		Template: *template,
+		NoCache:  *noCache,
+	}
+	if *hybrid {
+		req.SearchMode = client.SearchModeHybrid
+	}
+	if *filters != "" {
+		if err := json.Unmarshal([]byte(*filters), &req.Filters); err != nil {
+			return fmt.Errorf("%w: invalid --filters: %v", errUsage, err)
+		}
+	}
+
+	if *asJSON {
+		resp, err := api.Query(ctx, req)
+		if err != nil {
+			return err
+		}
+		return c.printJSON(resp)
+	}
+
+	resp, err := api.QueryStream(ctx, req, func(text string) {
+		_, _ = fmt.Fprint(c.stdout, text)
+	})
+	_, _ = fmt.Fprintln(c.stdout)
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Sources) > 0 {
+		_, _ = fmt.Fprintln(c.stdout, "\nSources:")
+		for _, source := range resp.Sources {
+			marker, note := "   ", ""
+			if source.Citation > 0 {
+				marker = fmt.Sprintf("[%d]", source.Citation)
+			}
+			if !source.Included {
+				note = " (did not fit into the prompt)"
+			}
+			_, _ = fmt.Fprintf(c.stdout, "  %s %.3f  %s  %s%s\n", marker, source.Score, source.ID, source.Title, note)
+		}
+	}
+	if resp.HiddenResults != nil && *resp.HiddenResults > 0 {
+		_, _ = fmt.Fprintf(c.stdout, "\n%d additional results hidden by your permissions\n", *resp.HiddenResults)
+	}
+	return nil
+}
+
+// docs dispatches the document subcommands
+func (c *command) docs(ctx context.Context, args []string) error {
+	if len(args) > 0 && args[0] == "export" {
+		return c.docsExport(ctx, args[1:])
+	}
+	if len(args) > 0 && args[0] == "reindex" {
+		return c.docsReindex(ctx, args[1:])
+	}
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf("%w: docs needs a subcommand: list, export, or reindex", errUsage)
+	}
+
+	flags := c.flags("docs list")
+	limit := flags.Int("limit", 0, "page size (server default if 0)")
+	offset := flags.Int("offset", 0, "number of documents to skip")
+	sortBy := flags.String("sort", "", `sort by "title" or "created_at"`)
+	desc := flags.Bool("desc", false, "sort in descending order")
+	asJSON := flags.Bool("json", false, "print the response as JSON")
+	metadata := metadataFlag{}
+	flags.Var(metadata, "metadata", "filter by metadata key=value (repeatable)")
+	positional, err := parse(flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("%w: unexpected arguments %q", errUsage, positional)
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	list, err := api.ListDocuments(ctx, client.ListOptions{
+		Limit:      *limit,
+		Offset:     *offset,
+		Sort:       *sortBy,
+		Descending: *desc,
+		Metadata:   metadata,
+	})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return c.printJSON(list)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tTITLE\tCREATED")
+	for _, doc := range list.Documents {
+		created := ""
+		if !doc.CreatedAt.IsZero() {
+			created = doc.CreatedAt.Format("2006-01-02 15:04")
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", doc.ID, doc.Title, created)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if list.NextOffset != nil {
+		_, _ = fmt.Fprintf(c.stderr, "More documents: --offset %d\n", *list.NextOffset)
+	}
+	return nil
+}
+
+// docsExport writes documents with their embeddings to stdout as JSONL
+func (c *command) docsExport(ctx context.Context, args []string) error {
+	flags := c.flags("docs export")
+	tenants := flags.String("tenants", "", "comma-separated tenants to export instead of --tenant")
+	metadata := metadataFlag{}
+	flags.Var(metadata, "metadata", "filter by metadata key=value (repeatable)")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("%w: unexpected arguments %q", errUsage, positional)
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	opts := client.ExportOptions{Metadata: metadata}
+	if *tenants != "" {
+		opts.Tenants = strings.Split(*tenants, ",")
+	}
+	enc := json.NewEncoder(c.stdout)
+	count := 0
+	err = api.ExportDocuments(ctx, opts, func(doc client.ExportedDocument) error {
+		count++
+		return enc.Encode(doc)
+	})
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.stderr, "Exported %d document(s)\n", count)
+	return nil
+}
+
+// reindexPollInterval is how often docs reindex checks the job's progress
+var reindexPollInterval = time.Second
+
+// docsReindex starts re-embedding all documents and waits for the job to finish
+func (c *command) docsReindex(ctx context.Context, args []string) error {
+	flags := c.flags("docs reindex")
+	statusOnly := flags.Bool("status", false, "only print the progress of the running or last job")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("%w: unexpected arguments %q", errUsage, positional)
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	var status *client.ReindexStatus
+	if *statusOnly {
+		status, err = api.ReindexStatus(ctx)
+	} else {
+		status, err = api.StartReindex(ctx)
+	}
+	for err == nil && status.State == client.ReindexRunning && !*statusOnly {
+		_, _ = fmt.Fprintf(c.stderr, "Reindexing: %d/%d documents\n", status.Done, status.Total)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reindexPollInterval):
+		}
+		status, err = api.ReindexStatus(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(c.stdout, "%s: %d/%d documents\n", status.State, status.Done, status.Total)
+	if status.State == client.ReindexFailed {
+		return fmt.Errorf("reindex failed: %s", status.Error)
+	}
+	return nil
+}
+
+// db dispatches the database subcommands
+func (c *command) db(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "rekey" {
+		return fmt.Errorf("%w: db needs a subcommand: rekey", errUsage)
+	}
+
+	flags := c.flags("db rekey")
+	keyFile := flags.String("key-file", "", "file holding the new encryption key; a trailing newline is ignored")
+	positional, err := parse(flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("%w: unexpected arguments %q", errUsage, positional)
+	}
+	if *keyFile == "" {
+		return fmt.Errorf("%w: db rekey needs --key-file", errUsage)
+	}
+	data, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the new key: %w", err)
+	}
+	key := strings.TrimRight(string(data), "\r\n")
+	if key == "" {
+		return fmt.Errorf("%s is empty", *keyFile)
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	resp, err := api.RekeyDatabase(ctx, key)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(c.stdout, resp.Message)
+	return nil
+}
+
+// perms dispatches the permission subcommands
+func (c *command) perms(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: perms needs a subcommand: list, grant, or revoke", errUsage)
+	}
+	sub := args[0]
+	flags := c.flags("perms " + sub)
+	group := new(string)
+	if sub == "grant" || sub == "revoke" {
+		group = flags.String("group", "", "change the relation of this group's members instead of a user")
+	}
+	positional, err := parse(flags, args[1:])
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "list":
+		if len(positional) > 0 {
+			return fmt.Errorf("%w: unexpected arguments %q", errUsage, positional)
+		}
+		api, err := c.client()
+		if err != nil {
+			return err
+		}
+		perms, err := api.Permissions(ctx)
+		if err != nil {
+			return err
+		}
+		for _, p := range perms.Permissions {
+			_, _ = fmt.Fprintln(c.stdout, p)
+		}
+		return nil
+	case "grant", "revoke":
+		var change client.PermissionChange
+		if *group != "" {
+			if len(positional) < 1 || len(positional) > 2 {
+				return fmt.Errorf("%w: perms %s --group needs <relation> [document-id]", errUsage, sub)
+			}
+			change.Group = *group
+		} else {
+			if len(positional) < 2 || len(positional) > 3 {
+				return fmt.Errorf("%w: perms %s needs <user> <relation> [document-id]", errUsage, sub)
+			}
+			change.User, positional = positional[0], positional[1:]
+		}
+		change.Relation = positional[0]
+		if len(positional) == 2 {
+			change.DocumentID = positional[1]
+		}
+		api, err := c.client()
+		if err != nil {
+			return err
+		}
+		apply := api.GrantPermission
+		if sub == "revoke" {
+			apply = api.RevokePermission
+		}
+		resp, err := apply(ctx, change)
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(c.stdout, resp.Message)
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown perms subcommand %q", errUsage, sub)
+	}
+}
+
+// groups dispatches the group subcommands
+func (c *command) groups(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: groups needs a subcommand: show, add, or remove", errUsage)
+	}
+	sub := args[0]
+	flags := c.flags("groups " + sub)
+	positional, err := parse(flags, args[1:])
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case "show":
+		if len(positional) != 1 {
+			return fmt.Errorf("%w: groups show needs <group>", errUsage)
+		}
+		api, err := c.client()
+		if err != nil {
+			return err
+		}
+		group, err := api.Group(ctx, positional[0])
+		if err != nil {
+			return err
+		}
+		for _, member := range group.Members {
+			_, _ = fmt.Fprintf(c.stdout, "member  %s\n", member)
+		}
+		for _, t := range group.Relations {
+			_, _ = fmt.Fprintf(c.stdout, "%-7s %s\n", t.Relation, t.Object)
+		}
+		return nil
+	case "add", "remove":
+		if len(positional) != 2 {
+			return fmt.Errorf("%w: groups %s needs <group> <user>", errUsage, sub)
+		}
+		api, err := c.client()
+		if err != nil {
+			return err
+		}
+		apply := api.AddGroupMember
+		if sub == "remove" {
+			apply = api.RemoveGroupMember
+		}
+		resp, err := apply(ctx, positional[0], positional[1])
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(c.stdout, resp.Message)
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown groups subcommand %q", errUsage, sub)
+	}
+}
+
+// policy dispatches the permission policy subcommands
+func (c *command) policy(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "apply" {
+		return fmt.Errorf("%w: policy needs a subcommand: apply", errUsage)
+	}
+
+	flags := c.flags("policy apply")
+	dryRun := flags.Bool("dry-run", false, "only show the changes the policy would make")
+	positional, err := parse(flags, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: policy apply needs exactly one policy file", errUsage)
+	}
+	data, err := os.ReadFile(positional[0])
+	if err != nil {
+		return err
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	result, err := api.ApplyPolicy(ctx, data, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, t := range result.Granted {
+		_, _ = fmt.Fprintf(c.stdout, "+ %s %s %s\n", holder(t), t.Relation, t.Object)
+	}
+	for _, t := range result.Revoked {
+		_, _ = fmt.Fprintf(c.stdout, "- %s %s %s\n", holder(t), t.Relation, t.Object)
+	}
+	_, _ = fmt.Fprintf(c.stdout, "%s (%d granted, %d revoked)\n", result.Message, len(result.Granted), len(result.Revoked))
+	return nil
+}
+
+// eval runs a golden set through the server, asking every question as
+// the case's user, and writes a JSON or JUnit report. It fails if any case fails
+// so CI can gate on it.
+func (c *command) eval(ctx context.Context, args []string) error {
+	flags := c.flags("eval")
+	format := flags.String("format", "json", "report format: json or junit")
+	output := flags.String("output", "", "write the report to this file instead of stdout")
+	judgeURL := flags.String("judge-url", "", "Ollama URL of the LLM judge; answers are not graded without it")
+	judgeModel := flags.String("judge-model", "llama3.2:1b", "Ollama model of the LLM judge")
+	minRecall := flags.Float64("min-recall", 1, "retrieval recall a case needs to pass (0 to 1)")
+	minGroundedness := flags.Float64("min-groundedness", 0.7, "judge grade a case needs to pass (0 to 1)")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("%w: eval needs exactly one golden set file", errUsage)
+	}
+	if *format != "json" && *format != "junit" {
+		return fmt.Errorf("%w: --format must be json or junit", errUsage)
+	}
+
+	data, err := os.ReadFile(positional[0])
+	if err != nil {
+		return err
+	}
+	set, err := eval.Parse(data)
+	if err != nil {
+		return err
+	}
+	if c.user == "" && slices.ContainsFunc(set.Cases, func(tc eval.Case) bool { return tc.User == "" }) {
+		return fmt.Errorf("%w: --user or RERAG_USER is required for cases without a user", errUsage)
+	}
+
+	opts := eval.Options{DefaultUser: c.user, MinRecall: *minRecall, MinGroundedness: *minGroundedness}
+	if *judgeURL != "" {
+		opts.Judge = eval.NewOllamaJudge(*judgeURL, *judgeModel, c.timeout)
+	}
+	report := eval.Run(ctx, set, &clientQuerier{cmd: c, clients: map[string]*client.Client{}}, opts)
+
+	var out io.Writer = c.stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		out = f
+	}
+	if *format == "junit" {
+		err = eval.WriteJUnit(out, report)
+	} else {
+		err = eval.WriteJSON(out, report)
+	}
+	if err != nil {
+		return err
+	}
+
+	summary := report.Summary
+	_, _ = fmt.Fprintf(c.stderr, "%d/%d cases passed (recall %.2f, leaks %d)\n", summary.Passed, summary.Cases, summary.Recall, summary.Leaks)
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", summary.Failed, summary.Cases)
+	}
+	return nil
+}
+
+// loadtest drives concurrent ingest and query traffic and reports latency
+// percentiles per stage. With --baseline it fails on p50 or p95 latencies
+// slower than the baseline by more than --tolerance.
+func (c *command) loadtest(ctx context.Context, args []string) error {
+	flags := c.flags("loadtest")
+	concurrency := flags.Int("concurrency", 8, "number of concurrent workers")
+	requests := flags.Int("requests", 200, "total number of requests")
+	duration := flags.Duration("duration", 0, "stop after this long even if requests remain (0 for no limit)")
+	ingestRatio := flags.Float64("ingest-ratio", 0.1, "share of requests that ingest a document (0 to 1)")
+	queryUser := flags.String("query-user", "", "user asking the questions (default --user)")
+	questionsFile := flags.String("questions", "", "file with one question per line (default built-in questions)")
+	format := flags.String("format", "table", "report format: table or json")
+	baselinePath := flags.String("baseline", "", "compare with this baseline and fail on regressions")
+	savePath := flags.String("save-baseline", "", "save the report as a baseline to this file")
+	tolerance := flags.Float64("tolerance", 0.2, "slowdown over the baseline tolerated before failing, e.g. 0.2 for 20%")
+	positional, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("%w: loadtest takes no arguments", errUsage)
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("%w: --format must be table or json", errUsage)
+	}
+	opts := loadtest.Options{Concurrency: *concurrency, Requests: *requests, Duration: *duration, IngestRatio: *ingestRatio}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *questionsFile != "" {
+		data, err := os.ReadFile(*questionsFile)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				opts.Questions = append(opts.Questions, line)
+			}
+		}
+	}
+	var baseline *loadtest.Report
+	if *baselinePath != "" {
+		if baseline, err = loadtest.LoadBaseline(*baselinePath); err != nil {
+			return err
+		}
+	}
+
+	ingester, err := c.client()
+	if err != nil {
+		return err
+	}
+	querier := ingester
+	if *queryUser != "" {
+		if querier, err = c.clientFor(*queryUser); err != nil {
+			return err
+		}
+	}
+	report, err := loadtest.Run(ctx, &clientTarget{ingester: ingester, querier: querier}, opts)
+	if err != nil {
+		return err
+	}
+	if baseline != nil {
+		report.Compare(baseline, *tolerance)
+	}
+
+	if *format == "json" {
+		err = loadtest.WriteJSON(c.stdout, report)
+	} else {
+		err = loadtest.WriteTable(c.stdout, report)
+	}
+	if err != nil {
+		return err
+	}
+	if *savePath != "" {
+		if err := loadtest.SaveBaseline(*savePath, report); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(c.stderr, "Saved baseline to %s\n", *savePath)
+	}
+	if n := len(report.Regressions); n > 0 {
+		return fmt.Errorf("%d latency regression(s) over the baseline", n)
+	}
+	return nil
+}
+
+// clientTarget sends load test traffic through the API, ingesting and
+// querying as possibly different users. Queries skip the cache so every one
+// reaches the search and permission checks.
+type clientTarget struct {
+	ingester, querier *client.Client
+}
+
+func (t *clientTarget) Ingest(ctx context.Context, title, content string) error {
+	_, err := t.ingester.AddDocument(ctx, client.Document{Title: title, Content: content, Metadata: map[string]interface{}{"source": "loadtest"}})
+	return err
+}
+
+func (t *clientTarget) Query(ctx context.Context, question string) (loadtest.QueryTimings, error) {
+	resp, err := t.querier.Query(ctx, client.QueryRequest{Question: question, NoCache: true, IncludeUsage: true})
+	if err != nil || resp.Usage == nil {
+		return loadtest.QueryTimings{}, err
+	}
+	ms := func(v float64) time.Duration { return time.Duration(v * float64(time.Millisecond)) }
+	timings := resp.Usage.Timings
+	return loadtest.QueryTimings{
+		Embed:    ms(timings.EmbedMs),
+		Search:   ms(timings.SearchMs),
+		Rerank:   ms(timings.RerankMs),
+		Generate: ms(timings.GenerateMs),
+	}, nil
+}
+
+// clientQuerier asks questions through the API with one client per user
+type clientQuerier struct {
+	cmd     *command
+	clients map[string]*client.Client
+}
+
+func (q *clientQuerier) Query(ctx context.Context, user, question string, topK int) (*eval.Response, error) {
+	api, ok := q.clients[user]
+	if !ok {
+		var err error
+		if api, err = q.cmd.clientFor(user); err != nil {
+			return nil, err
+		}
+		q.clients[user] = api
+	}
+
+	resp, err := api.Query(ctx, client.QueryRequest{Question: question, TopK: topK, NoCache: true})
+	if err != nil {
+		return nil, err
+	}
+	result := &eval.Response{Answer: resp.Answer, Sources: make([]eval.Source, len(resp.Sources))}
+	for i, source := range resp.Sources {
+		result.Sources[i] = eval.Source{ID: source.ID, Title: source.Title, Content: source.Content, Included: source.Included}
+	}
+	return result, nil
+}
+
+func (c *command) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// metadataFlag collects repeated key=value flags
+type metadataFlag map[string]string
+
+func (m metadataFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metadataFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	m[key] = val
+	return nil
+}
+
+// holder names who holds a relation: the user or "group:<name>"
+func holder(t client.RelationTuple) string {
+	if t.Group != "" {
+		return "group:" + t.Group
+	}
+	return t.User
+}
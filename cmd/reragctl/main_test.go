@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// refundsID is the document the fake API answers non-streamed questions from
+const refundsID = "a7d36b58-3d46-4107-9b88-6b1400bc9a5d"
+
+// fakeAPI records the requests of a CLI run
+type fakeAPI struct {
+	mu       sync.Mutex
+	uploads  []string
+	users    []string
+	requests []string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users = append(f.users, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	switch r.Method + " " + r.URL.Path {
+	case "POST /documents/upload":
+		_, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.uploads = append(f.uploads, header.Filename)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"filename":%q,"source_id":"src","document_ids":["a","b"]}`, header.Filename)
+	case "POST /documents":
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprint(w, `{"id":"doc-2","message":"Document added successfully"}`)
+	case "POST /query":
+		if r.Header.Get("Accept") != "text/event-stream" {
+			_, _ = fmt.Fprintf(w, `{"answer":"John was refunded $50","sources":[{"id":%q,"title":"Refunds","content":"John Doe: refund $50","included":true}],"sources_included":1,"usage":{"timings":{"embed_ms":1,"search_ms":40,"total_ms":45}}}`, refundsID)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, "event: delta\ndata: {\"text\":\"John was \"}\n\n")
+		_, _ = fmt.Fprint(w, "event: delta\ndata: {\"text\":\"refunded $50\"}\n\n")
+		_, _ = fmt.Fprint(w, "event: done\ndata: {\"answer\":\"John was refunded $50\",\"sources\":[{\"id\":\"doc-1\",\"title\":\"Refunds\",\"score\":0.9,\"included\":true}],\"sources_included\":1}\n\n")
+	case "POST /permissions", "DELETE /permissions":
+		var change map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&change)
+		_, _ = fmt.Fprintf(w, `{"user":%q,"group":%q,"relation":%q,"object":%q,"message":"Permission changed for %s%s"}`,
+			change["user"], change["group"], change["relation"], change["document_id"], change["user"], change["group"])
+	case "PUT /groups/accounting-team/members/bob":
+		_, _ = fmt.Fprint(w, `{"group":"accounting-team","user":"bob","message":"Group member added"}`)
+	case "GET /groups/accounting-team":
+		_, _ = fmt.Fprint(w, `{"group":"accounting-team","members":["bob"],"relations":[{"group":"accounting-team","relation":"viewer","object":"doc-1"}]}`)
+	case "GET /documents/export":
+		if r.URL.Query().Get("tenant") != "acme" {
+			http.Error(w, "unexpected tenant", http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"id":"doc-1","title":"Refunds","tenant_id":"acme","embedding":[0.5]}`+"\n")
+	case "POST /documents/reindex":
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = fmt.Fprint(w, `{"state":"running","done":0,"total":0,"started_by":"admin"}`)
+	case "GET /documents/reindex":
+		_, _ = fmt.Fprint(w, `{"state":"completed","done":2,"total":2,"started_by":"admin"}`)
+	case "POST /admin/database/rekey":
+		var req map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req["key"] != "n3w-key" {
+			http.Error(w, "unexpected key", http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"rekeyed_at":"2026-10-16T12:00:00Z","message":"Database rekeyed"}`)
+	case "PUT /permissions/policy":
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "users: [alice]") {
+			http.Error(w, "unexpected policy", http.StatusBadRequest)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"granted":[{"user":"alice","relation":"write","object":"corpus"}],"revoked":[],"dry_run":%t,"message":"Policy changes planned"}`,
+			r.URL.Query().Get("dry_run") == "true")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestIngestWalksDirectories(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.md", "nested/b.txt", "nested/image.png", ".git/c.md"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("content"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, stdout, stderr := runCLI(t, "ingest", dir, "--server", server.URL, "--user", "admin")
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	slices.Sort(api.uploads)
+	if !slices.Equal(api.uploads, []string{"a.md", "b.txt"}) {
+		t.Errorf("expected a.md and b.txt to be uploaded, got %v", api.uploads)
+	}
+	if strings.Count(stdout, "2 document(s)") != 2 {
+		t.Errorf("expected a line per file, got %q", stdout)
+	}
+	for _, user := range api.users {
+		if user != "admin" {
+			t.Errorf("expected requests as admin, got %q", user)
+		}
+	}
+}
+
+func TestQueryStreamsAnswer(t *testing.T) {
+	server := httptest.NewServer(&fakeAPI{})
+	defer server.Close()
+
+	code, stdout, stderr := runCLI(t, "query", "What was John's refund?", "--user", "alice", "--server", server.URL)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "John was refunded $50\n") {
+		t.Errorf("expected the streamed answer first, got %q", stdout)
+	}
+	if !strings.Contains(stdout, "0.900  doc-1  Refunds") {
+		t.Errorf("expected the sources, got %q", stdout)
+	}
+}
+
+func TestPermsGrantAndRevoke(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	for _, sub := range []string{"grant", "revoke"} {
+		code, stdout, stderr := runCLI(t, "perms", sub, "bob", "viewer", "doc-1", "--user", "admin", "--server", server.URL)
+		if code != 0 {
+			t.Fatalf("perms %s: expected exit code 0, got %d: %s", sub, code, stderr)
+		}
+		if strings.TrimSpace(stdout) != "Permission changed for bob" {
+			t.Errorf("perms %s: unexpected output %q", sub, stdout)
+		}
+	}
+	if !slices.Equal(api.requests, []string{"POST /permissions", "DELETE /permissions"}) {
+		t.Errorf("unexpected requests %v", api.requests)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	commands := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"groups", "add", "accounting-team", "bob"}, want: "Group member added\n"},
+		{args: []string{"perms", "grant", "--group", "accounting-team", "viewer", "doc-1"}, want: "Permission changed for accounting-team\n"},
+		{args: []string{"groups", "show", "accounting-team"}, want: "member  bob\nviewer  doc-1\n"},
+	}
+	for _, cmd := range commands {
+		code, stdout, stderr := runCLI(t, append(cmd.args, "--user", "admin", "--server", server.URL)...)
+		if code != 0 {
+			t.Fatalf("%q: expected exit code 0, got %d: %s", cmd.args, code, stderr)
+		}
+		if stdout != cmd.want {
+			t.Errorf("%q: expected %q, got %q", cmd.args, cmd.want, stdout)
+		}
+	}
+	want := []string{"PUT /groups/accounting-team/members/bob", "POST /permissions", "GET /groups/accounting-team"}
+	if !slices.Equal(api.requests, want) {
+		t.Errorf("expected requests %v, got %v", want, api.requests)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	t.Setenv("RERAG_USER", "")
+	tests := [][]string{
+		{},
+		{"unknown"},
+		{"query"},
+		{"query", "question"}, // no user
+		{"docs"},
+		{"perms", "grant", "bob"},
+		{"perms", "grant", "--group", "team", "viewer", "doc-1", "extra"},
+		{"groups", "add", "team"},
+		{"db", "rekey", "--user", "admin"}, // no key file
+		{"query", "question", "--user", "alice", "--bogus"},
+		{"query", "question", "--user", "alice", "--filters", "{year"},
+	}
+	for _, args := range tests {
+		if code, _, _ := runCLI(t, args...); code != 2 {
+			t.Errorf("%q: expected exit code 2, got %d", args, code)
+		}
+	}
+}
+
+func TestDocsExportWritesJSONL(t *testing.T) {
+	server := httptest.NewServer(&fakeAPI{})
+	defer server.Close()
+
+	code, stdout, stderr := runCLI(t, "docs", "export", "--tenants", "acme", "--user", "admin", "--server", server.URL)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, `"embedding":[0.5]`) || strings.Count(stdout, "\n") != 1 {
+		t.Errorf("expected one JSONL line with the embedding, got %q", stdout)
+	}
+	if !strings.Contains(stderr, "Exported 1 document(s)") {
+		t.Errorf("expected the count on stderr, got %q", stderr)
+	}
+}
+
+func TestDocsReindexWaitsForCompletion(t *testing.T) {
+	reindexPollInterval = time.Millisecond
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	code, stdout, stderr := runCLI(t, "docs", "reindex", "--user", "admin", "--server", server.URL)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if stdout != "completed: 2/2 documents\n" || !strings.Contains(stderr, "Reindexing: 0/0") {
+		t.Errorf("expected progress then the final status, got %q and %q", stderr, stdout)
+	}
+	if !slices.Equal(api.requests, []string{"POST /documents/reindex", "GET /documents/reindex"}) {
+		t.Errorf("expected the job to be started and polled, got %v", api.requests)
+	}
+}
+
+func TestDBRekeyReadsKeyFile(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "db.key")
+	if err := os.WriteFile(keyFile, []byte("n3w-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr := runCLI(t, "db", "rekey", "--key-file", keyFile, "--user", "admin", "--server", server.URL)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if stdout != "Database rekeyed\n" || !slices.Equal(api.requests, []string{"POST /admin/database/rekey"}) {
+		t.Errorf("expected one rekey request, got %v and %q", api.requests, stdout)
+	}
+}
+
+func TestAPIErrorsExitWithFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprint(w, `{"error":{"code":403,"message":"The requested action was forbidden","reason":"User cannot write documents"}}`)
+	}))
+	defer server.Close()
+
+	code, _, stderr := runCLI(t, "perms", "grant", "bob", "write", "--user", "alice", "--server", server.URL)
+	if code != 1 {
+		t.Errorf("expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(stderr, "User cannot write documents") {
+		t.Errorf("expected the reason on stderr, got %q", stderr)
+	}
+}
+
+func TestPolicyApplyDryRun(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(file, []byte("users: [alice]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := runCLI(t, "policy", "apply", file, "--dry-run", "--user", "admin", "--server", server.URL)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "+ alice write corpus") || !strings.Contains(stdout, "(1 granted, 0 revoked)") {
+		t.Errorf("unexpected output %q", stdout)
+	}
+}
+
+func TestEvalReportsLeaksAsJUnit(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	golden := filepath.Join(t.TempDir(), "golden.yaml")
+	set := `cases:
+  - name: alice-refund
+    question: What was John's refund?
+    expected_sources: [` + refundsID + `]
+  - name: bob-refund
+    user: bob
+    question: What was John's refund?
+    forbidden_sources: [` + refundsID + `]
+`
+	if err := os.WriteFile(golden, []byte(set), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, stderr := runCLI(t, "eval", golden, "--format", "junit", "--user", "alice", "--server", server.URL)
+	if code != 1 {
+		t.Fatalf("expected exit code 1 for the leaking case, got %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, `<testsuite name="rerag-eval" tests="2" failures="1" errors="0">`) {
+		t.Errorf("expected a suite with one failure, got %s", stdout)
+	}
+	if !strings.Contains(stdout, "permission leak: document "+refundsID) {
+		t.Errorf("expected the leak to be reported, got %s", stdout)
+	}
+	if !strings.Contains(stderr, "1/2 cases passed") {
+		t.Errorf("expected a summary on stderr, got %q", stderr)
+	}
+	if !slices.Equal(api.users, []string{"alice", "bob"}) {
+		t.Errorf("expected each case to be asked as its user, got %v", api.users)
+	}
+}
+
+func TestLoadtestComparesWithBaseline(t *testing.T) {
+	api := &fakeAPI{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	baseline := filepath.Join(t.TempDir(), "baseline.json")
+
+	code, stdout, stderr := runCLI(t, "loadtest", "--requests", "20", "--concurrency", "4", "--ingest-ratio", "0.25",
+		"--user", "admin", "--query-user", "alice", "--save-baseline", baseline, "--server", server.URL)
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr)
+	}
+	for _, stage := range []string{"ingest", "query", "embed", "search"} {
+		if !strings.Contains(stdout, "\n"+stage+" ") {
+			t.Errorf("expected a %s row, got:\n%s", stage, stdout)
+		}
+	}
+	api.mu.Lock()
+	if n := slices.Index(api.requests, "POST /documents"); n < 0 || slices.Index(api.users, "alice") < 0 {
+		t.Errorf("expected ingests and queries as alice, got %v as %v", api.requests, api.users)
+	}
+	api.mu.Unlock()
+
+	// A baseline with a faster search fails the run
+	data, err := os.ReadFile(baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(baseline, bytes.ReplaceAll(data, []byte(`"p95_ms": 40`), []byte(`"p95_ms": 10`)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr = runCLI(t, "loadtest", "--requests", "20", "--user", "admin", "--baseline", baseline, "--server", server.URL)
+	if code != 1 || !strings.Contains(stdout, "REGRESSION search p95 10.0ms -> 40.0ms") {
+		t.Errorf("expected a search regression, got exit code %d:\n%s%s", code, stdout, stderr)
+	}
+}
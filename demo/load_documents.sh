@@ -5,13 +5,14 @@ set -euo pipefail
 API_URL="http://localhost:4477"
 
 echo "Loading sample tax documents into the system..."
-echo "Note: Document upload does not require authentication"
+echo "Note: Documents are uploaded as peter, who holds the write relation on the corpus"
 echo ""
 
 jq -c '.[]' demo/documents/sample_documents.json | while read doc; do
     echo "Adding document: $(echo $doc | jq -r '.title')"
     curl -sS -X POST "${API_URL}/documents" \
         -H "Content-Type: application/json" \
+        -H "Authorization: Bearer peter" \
         -d "$doc"
 done
 
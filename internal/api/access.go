@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/ory/herodot"
+)
+
+// getDocumentAccess lists the relations the user holds on a document, so
+// clients can offer only the actions the user may take. Documents the user
+// holds no relation on are answered with 404.
+func (s *Server) getDocumentAccess(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	docID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error()))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	relations, err := s.rag.Access(r.Context(), principal.Principal, docID)
+	if err != nil {
+		s.writeServiceError(w, r, err, "document "+docID.String())
+		return
+	}
+
+	s.writer.Write(w, r, &models.DocumentAccessResponse{
+		DocumentID: docID.String(),
+		User:       principal.Username,
+		Relations:  relations,
+	})
+}
+
+// listDocumentPermissions returns a page of the users and groups holding
+// relations on a document. There is no owner relation, so like restoring it
+// requires the editor relation. Pages follow Keto's: limit sets the page size
+// and page_token continues from next_page_token of the previous page.
+func (s *Server) listDocumentPermissions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	requestID := requestid.FromContext(r.Context())
+
+	docID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error()))
+		return
+	}
+	limit := defaultListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid query parameters").WithError("limit must be a positive integer"))
+			return
+		}
+		limit = min(limit, maxListLimit)
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	if !s.permService.CanEditDocument(r.Context(), principal.Principal, &models.Document{ID: docID}) {
+		s.forbid(w, r, fmt.Errorf("user %s is not allowed to list the permissions of document %s", principal.Username, docID))
+		return
+	}
+	if _, err := s.store(r.Context()).GetDocument(docID); errors.Is(err, storage.ErrDocumentNotFound) {
+		s.errHandler.HandleNotFoundError(w, r, "document "+docID.String(), requestID)
+		return
+	} else if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestID)
+		return
+	}
+
+	lister, ok := s.permService.(permissions.DocumentRelationLister)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot list relations"))
+		return
+	}
+	tuples, next, err := lister.ListDocumentTuples(r.Context(), docID, limit, r.URL.Query().Get("page_token"))
+	if err != nil {
+		if errors.Is(err, permissions.ErrChangesUnsupported) {
+			s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot list relations"))
+			return
+		}
+		s.writer.WriteError(w, r, upstreamError(err, "Failed to list permissions"))
+		return
+	}
+
+	s.writer.Write(w, r, &models.DocumentPermissionsResponse{
+		DocumentID:    docID.String(),
+		Relations:     relationTuples(tuples),
+		NextPageToken: next,
+	})
+}
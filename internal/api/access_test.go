@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGetDocumentAccess(t *testing.T) {
+	server, _, vectorStore, _, permService := createTestServer()
+	doc := &models.Document{ID: uuid.New(), Title: "Return", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}}
+	_ = vectorStore.AddDocument(doc)
+	permService.SetCanEdit("alice", false)
+	permService.SetCanEdit("bob", false)
+	permService.SetDocumentAccess("bob", doc.ID.String(), false)
+
+	tests := []struct {
+		name              string
+		user              string
+		id                string
+		expectedCode      int
+		expectedRelations []string
+	}{
+		{"viewer and editor", adminUsername, doc.ID.String(), http.StatusOK, []string{permissions.RelationViewer, permissions.RelationEditor}},
+		{"viewer only", "alice", doc.ID.String(), http.StatusOK, []string{permissions.RelationViewer}},
+		{"no relation", "bob", doc.ID.String(), http.StatusNotFound, nil},
+		{"missing document", adminUsername, uuid.NewString(), http.StatusNotFound, nil},
+		{"invalid ID", adminUsername, "not-a-uuid", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createAuthenticatedRequest(http.MethodGet, "/documents/"+tt.id+"/access", nil, tt.user)
+			req.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+			server.getDocumentAccess(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var response models.DocumentAccessResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if response.DocumentID != tt.id || response.User != tt.user || !slices.Equal(response.Relations, tt.expectedRelations) {
+				t.Errorf("Expected %v for %s, got %+v", tt.expectedRelations, tt.user, response)
+			}
+		})
+	}
+}
+
+func TestGetDocumentAccessKetoOutage(t *testing.T) {
+	keto := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer keto.Close()
+
+	for policy, expectedCode := range map[permissions.FailurePolicy]int{
+		permissions.FailClosed: http.StatusServiceUnavailable,
+		// Read access is granted, but the editor relation stays unknown
+		permissions.FailOpen: http.StatusServiceUnavailable,
+		permissions.FailDeny: http.StatusNotFound,
+	} {
+		_, embedder, vectorStore, llmClient, _ := createTestServer()
+		server := newTestServer(embedder, vectorStore, llmClient, permissions.NewKetoPermissionService(keto.URL, keto.URL, nil, policy))
+		doc := &models.Document{ID: uuid.New(), Title: "Return", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}}
+		_ = vectorStore.AddDocument(doc)
+
+		w := serveAs(server.GetHandler(), http.MethodGet, "/documents/"+doc.ID.String()+"/access", nil, "alice")
+		if w.Code != expectedCode {
+			t.Errorf("%s: expected status %d, got %d: %s", policy, expectedCode, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestListDocumentPermissions(t *testing.T) {
+	server, _, vectorStore, _, permService := createTestServer()
+	doc := &models.Document{ID: uuid.New(), Title: "Return", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}}
+	_ = vectorStore.AddDocument(doc)
+	permService.SetCanEdit("alice", false)
+	for _, tuple := range []permissions.Tuple{
+		{Subject: adminUsername, Relation: permissions.RelationEditor, DocumentID: doc.ID},
+		{Subject: "alice", Relation: permissions.RelationViewer, DocumentID: doc.ID},
+		{Group: "accounting-team", Relation: permissions.RelationViewer, DocumentID: doc.ID},
+		{Subject: "alice", Relation: permissions.RelationViewer, DocumentID: uuid.New()},
+	} {
+		_ = permService.Grant(context.Background(), tuple)
+	}
+
+	list := func(user, id, query string) *httptest.ResponseRecorder {
+		req := createAuthenticatedRequest(http.MethodGet, "/documents/"+id+"/permissions"+query, nil, user)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		server.listDocumentPermissions(w, req)
+		return w
+	}
+
+	var relations []models.RelationTuple
+	token := ""
+	for pages := 1; ; pages++ {
+		w := list(adminUsername, doc.ID.String(), "?limit=2&page_token="+token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var response models.DocumentPermissionsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		relations, token = append(relations, response.Relations...), response.NextPageToken
+		if token == "" {
+			if pages != 2 {
+				t.Errorf("Expected 2 pages, got %d", pages)
+			}
+			break
+		}
+	}
+	want := []models.RelationTuple{
+		{User: adminUsername, Relation: permissions.RelationEditor, Object: doc.ID.String()},
+		{User: "alice", Relation: permissions.RelationViewer, Object: doc.ID.String()},
+		{Group: "accounting-team", Relation: permissions.RelationViewer, Object: doc.ID.String()},
+	}
+	if !slices.Equal(relations, want) {
+		t.Errorf("Expected %v, got %v", want, relations)
+	}
+
+	for name, tt := range map[string]struct {
+		user, id, query string
+		expectedCode    int
+	}{
+		"not an editor":    {"alice", doc.ID.String(), "", http.StatusForbidden},
+		"missing document": {adminUsername, uuid.NewString(), "", http.StatusNotFound},
+		"invalid ID":       {adminUsername, "not-a-uuid", "", http.StatusBadRequest},
+		"invalid limit":    {adminUsername, doc.ID.String(), "?limit=0", http.StatusBadRequest},
+	} {
+		if w := list(tt.user, tt.id, tt.query); w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tt.expectedCode, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ory/herodot"
+)
+
+// apiKeyScopes are the scopes a key may be granted
+var apiKeyScopes = []string{models.APIKeyScopeIngest, models.APIKeyScopeQuery}
+
+// apiKeyStore returns the API key store restricted to the request's tenant
+func (s *Server) apiKeyStore(ctx context.Context) storage.APIKeyStore {
+	return s.apiKeys.ForTenant(tenant.FromContext(ctx))
+}
+
+// listAPIKeys lists the tenant's API keys. Managing keys requires the write
+// relation on the corpus.
+func (s *Server) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	keys, err := s.apiKeyStore(r.Context()).ListAPIKeys()
+	if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestid.FromContext(r.Context()))
+		return
+	}
+	s.writer.Write(w, r, &models.APIKeyListResponse{Keys: keys})
+}
+
+// createAPIKey creates an API key of the tenant and responds with its secret,
+// which is not stored
+func (s *Server) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	var req models.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	if err := validateAPIKey(&req); err != nil {
+		s.writer.WriteError(w, r, err)
+		return
+	}
+
+	secret, err := auth.GenerateAPIKey()
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to generate API key").WithError(err.Error()))
+		return
+	}
+	key := models.APIKey{Name: req.Name, User: req.User, Scopes: req.Scopes, Hash: auth.HashAPIKey(secret)}
+	if err := s.apiKeyStore(r.Context()).CreateAPIKey(&key); err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestid.FromContext(r.Context()))
+		return
+	}
+
+	requestid.Logf(r.Context(), "AUDIT api key created: admin=%q id=%s name=%q user=%q scopes=%s client_ip=%s",
+		principal.Username, key.ID, key.Name, key.User, strings.Join(key.Scopes, ","), clientip.FromRequest(r))
+	s.writer.WriteCreated(w, r, "/api-keys/"+key.ID.String(), &models.CreateAPIKeyResponse{APIKey: key, Key: secret})
+}
+
+// validateAPIKey rejects incomplete requests and normalizes the scopes
+func validateAPIKey(req *models.CreateAPIKeyRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	req.User = strings.TrimSpace(req.User)
+	if req.Name == "" || req.User == "" {
+		return herodot.ErrBadRequest.WithReason("Invalid request body").WithError("name and user are required")
+	}
+	if len(req.Scopes) == 0 {
+		return herodot.ErrBadRequest.WithReason("Invalid request body").WithErrorf("at least one scope of %s is required", strings.Join(apiKeyScopes, ", "))
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiKeyScopes, scope) {
+			return herodot.ErrBadRequest.WithReason("Invalid scope").WithErrorf("scope must be one of %s, got %q", strings.Join(apiKeyScopes, ", "), scope)
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+	return nil
+}
+
+// revokeAPIKey revokes one of the tenant's API keys and responds with it.
+// Requests using the key are rejected from then on.
+func (s *Server) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	requestID := requestid.FromContext(r.Context())
+
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid API key ID").WithError(err.Error()))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	key, err := s.apiKeyStore(r.Context()).RevokeAPIKey(id)
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		s.errHandler.HandleNotFoundError(w, r, "API key "+id.String(), requestID)
+		return
+	}
+	if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestID)
+		return
+	}
+
+	requestid.Logf(r.Context(), "AUDIT api key revoked: admin=%q id=%s client_ip=%s", principal.Username, id, clientip.FromRequest(r))
+	s.writer.Write(w, r, key)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"strings"
+	"testing"
+)
+
+func createAPIKeyTestServer(t *testing.T) (*Server, *MockVectorStore, *MockPermissionService) {
+	t.Helper()
+	server, _, vectorStore, _, permService := createTestServer()
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	keys, err := storage.NewSQLiteAPIKeyStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create API key store: %v", err)
+	}
+	WithAPIKeys(keys)(server)
+	server.mux = http.NewServeMux()
+	server.setupRoutes()
+	return server, vectorStore, permService
+}
+
+func createAPIKey(t *testing.T, server *Server, tenantID, body string) models.CreateAPIKeyResponse {
+	t.Helper()
+	req := createAuthenticatedRequest(http.MethodPost, "/api-keys", []byte(body), adminUsername)
+	req = req.WithContext(tenant.NewContext(req.Context(), tenantID))
+	w := httptest.NewRecorder()
+	server.createAPIKey(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var created models.CreateAPIKeyResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return created
+}
+
+func TestAPIKeyScopesAndRevocation(t *testing.T) {
+	server, vectorStore, _ := createAPIKeyTestServer(t)
+	ingest := createAPIKey(t, server, "acme", `{"name": "nightly import", "user": "ingest-bot", "scopes": ["ingest", "ingest"]}`)
+	if !strings.HasPrefix(ingest.Key, "rrk_") || len(ingest.Scopes) != 1 || ingest.TenantID != "acme" {
+		t.Fatalf("Unexpected key %+v", ingest)
+	}
+
+	call := func(method, path, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(auth.APIKeyHeader, key)
+		req.Header.Set(tenant.Header, "globex")
+		w := httptest.NewRecorder()
+		server.GetHandler().ServeHTTP(w, req)
+		return w
+	}
+
+	// The key acts as its user in its own tenant, whatever tenant is requested
+	w := call(http.MethodPost, "/documents", ingest.Key, `{"title": "Refunds", "content": "Refund policy"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	for _, doc := range vectorStore.documents {
+		if doc.TenantID != "acme" || doc.Metadata["created_by"] != "ingest-bot" {
+			t.Errorf("Expected the document in acme by ingest-bot, got %q by %v", doc.TenantID, doc.Metadata["created_by"])
+		}
+	}
+
+	if w := call(http.MethodPost, "/query", ingest.Key, `{"question": "What is the refund policy?"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected an ingest key to be rejected on /query, got %d", w.Code)
+	}
+	if w := call(http.MethodPost, "/documents", "rrk_unknown", `{"title": "Refunds"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown key to be rejected, got %d", w.Code)
+	}
+
+	query := createAPIKey(t, server, "acme", `{"name": "chatbot", "user": "bot", "scopes": ["query"]}`)
+	if w := call(http.MethodPost, "/query", query.Key, `{"question": "What is the refund policy?"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected a query key to be accepted on /query, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Keys are managed per tenant
+	req := createAuthenticatedRequest(http.MethodDelete, "/api-keys/"+ingest.ID.String(), nil, adminUsername)
+	req.SetPathValue("id", ingest.ID.String())
+	w = httptest.NewRecorder()
+	server.revokeAPIKey(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected revoking another tenant's key to fail, got %d", w.Code)
+	}
+	req = req.WithContext(tenant.NewContext(req.Context(), "acme"))
+	w = httptest.NewRecorder()
+	server.revokeAPIKey(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := call(http.MethodPost, "/documents", ingest.Key, `{"title": "Refunds"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be rejected, got %d", w.Code)
+	}
+
+	req = createAuthenticatedRequest(http.MethodGet, "/api-keys", nil, adminUsername)
+	w = httptest.NewRecorder()
+	server.listAPIKeys(w, req.WithContext(tenant.NewContext(req.Context(), "acme")))
+	var list models.APIKeyListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(list.Keys) != 2 || list.Keys[0].RevokedAt == nil || list.Keys[1].RevokedAt != nil || strings.Contains(w.Body.String(), auth.HashAPIKey(ingest.Key)) {
+		t.Errorf("Expected the revoked and the active key without hashes, got %s", w.Body.String())
+	}
+}
+
+func TestAPIKeysRejectInvalidRequests(t *testing.T) {
+	server, _, permService := createAPIKeyTestServer(t)
+	permService.SetCanWrite("alice", false)
+
+	for name, tc := range map[string]struct {
+		user, body string
+		status     int
+	}{
+		"non-admin":     {"alice", `{"name": "import", "user": "bot", "scopes": ["ingest"]}`, http.StatusForbidden},
+		"no name":       {adminUsername, `{"user": "bot", "scopes": ["ingest"]}`, http.StatusBadRequest},
+		"no scopes":     {adminUsername, `{"name": "import", "user": "bot"}`, http.StatusBadRequest},
+		"unknown scope": {adminUsername, `{"name": "import", "user": "bot", "scopes": ["admin"]}`, http.StatusBadRequest},
+	} {
+		w := serveAs(server.GetHandler(), http.MethodPost, "/api-keys", []byte(tc.body), tc.user)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tc.status, w.Code, w.Body.String())
+		}
+	}
+
+	// Keys cannot manage keys
+	key := createAPIKey(t, server, tenant.Default, `{"name": "import", "user": "peter", "scopes": ["ingest", "query"]}`)
+	req := httptest.NewRequest(http.MethodGet, "/api-keys", nil)
+	req.Header.Set(auth.APIKeyHeader, key.Key)
+	w := httptest.NewRecorder()
+	server.GetHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected API keys to be rejected on /api-keys, got %d", w.Code)
+	}
+}
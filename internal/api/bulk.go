@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"slices"
+
+	"github.com/ory/herodot"
+)
+
+// maxBulkGrants bounds the number of grants in one bulk request
+const maxBulkGrants = 1000
+
+// bulkGrant grants a list of relations, in Keto transactions if the
+// permission service supports them. Invalid grants and grants whose write
+// failed are reported in the response while the others are applied. It
+// requires the write relation on the corpus, like changePermission.
+func (s *Server) bulkGrant(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	manager, ok := s.permService.(permissions.PermissionManager)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot change relations"))
+		return
+	}
+
+	var req models.BulkPermissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	if len(req.Grants) == 0 {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("No grants given"))
+		return
+	}
+	if len(req.Grants) > maxBulkGrants {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Too many grants").
+			WithError(fmt.Sprintf("%d grants given, at most %d are allowed per request", len(req.Grants), maxBulkGrants)))
+		return
+	}
+
+	response := &models.BulkPermissionResponse{
+		Granted: []models.RelationTuple{},
+		Failed:  []models.BulkPermissionFailure{},
+	}
+	fail := func(i int, err error) {
+		response.Failed = append(response.Failed, models.BulkPermissionFailure{Index: i, Grant: req.Grants[i], Error: err.Error()})
+	}
+
+	var tuples []permissions.Tuple
+	var indexes []int
+	for i, grant := range req.Grants {
+		tuple, err := permissionTuple(grant)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		tuples = append(tuples, tuple)
+		indexes = append(indexes, i)
+	}
+
+	errs := permissions.GrantAll(r.Context(), manager, tuples)
+	for j, err := range errs {
+		if errors.Is(err, permissions.ErrChangesUnsupported) {
+			s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot change relations"))
+			return
+		}
+		if err != nil {
+			fail(indexes[j], err)
+			continue
+		}
+		t := tuples[j]
+		response.Granted = append(response.Granted, models.RelationTuple{User: t.Subject, Group: t.Group, Relation: t.Relation, Object: t.Object()})
+		s.notify(r.Context(), webhooks.PermissionGranted, webhooks.PermissionData{
+			Subject:  t.Subject,
+			Group:    t.Group,
+			Relation: t.Relation,
+			Object:   t.Object(),
+		})
+	}
+	slices.SortFunc(response.Failed, func(a, b models.BulkPermissionFailure) int { return a.Index - b.Index })
+
+	response.Message = fmt.Sprintf("Granted %d of %d relations", len(response.Granted), len(req.Grants))
+	requestid.Logf(r.Context(), "User %s granted %d relations in bulk, %d failed", principal.Username, len(response.Granted), len(response.Failed))
+	s.writer.Write(w, r, response)
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// bulkPermissionService grants in bulk and fails the grants on one document
+type bulkPermissionService struct {
+	*MockPermissionService
+	failing uuid.UUID
+}
+
+func (b *bulkPermissionService) GrantAll(ctx context.Context, tuples []permissions.Tuple) []error {
+	errs := make([]error, len(tuples))
+	for i, t := range tuples {
+		if t.DocumentID == b.failing {
+			errs[i] = errors.New("keto returned status 500")
+			continue
+		}
+		errs[i] = b.Grant(ctx, t)
+	}
+	return errs
+}
+
+func postBulkGrant(server *Server, username string, grants []models.PermissionChangeRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.BulkPermissionRequest{Grants: grants})
+	return serveAs(server.GetHandler(), http.MethodPost, "/permissions/bulk", body, username)
+}
+
+func TestBulkGrant(t *testing.T) {
+	permService := &bulkPermissionService{MockPermissionService: NewMockPermissionService(), failing: uuid.New()}
+	server := newTestServer(NewMockEmbedder(), NewMockVectorStore(), NewMockLLMClient(), permService)
+	notifier := &recordingNotifier{}
+	WithWebhooks(notifier)(server)
+	docID := uuid.New()
+
+	w := postBulkGrant(server, adminUsername, []models.PermissionChangeRequest{
+		{User: "alice", Relation: "viewer", DocumentID: docID.String()},
+		{User: "alice", Relation: "owner", DocumentID: docID.String()},
+		{User: "bob", Relation: "viewer", DocumentID: permService.failing.String()},
+		{Group: "payroll", Relation: "editor", DocumentID: docID.String()},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response models.BulkPermissionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	wantGranted := []permissions.Tuple{
+		{Subject: "alice", Relation: "viewer", DocumentID: docID},
+		{Group: "payroll", Relation: "editor", DocumentID: docID},
+	}
+	if !slices.Equal(permService.granted, wantGranted) || len(response.Granted) != 2 {
+		t.Errorf("Expected the valid grants to be made, got %v", permService.granted)
+	}
+	if len(response.Failed) != 2 || response.Failed[0].Index != 1 || response.Failed[1].Index != 2 ||
+		response.Failed[1].Grant.User != "bob" || response.Failed[1].Error != "keto returned status 500" {
+		t.Errorf("Expected the invalid and the failed grant to be reported, got %+v", response.Failed)
+	}
+	if response.Message != "Granted 2 of 4 relations" {
+		t.Errorf("Unexpected message %q", response.Message)
+	}
+	if len(notifier.events) != 2 {
+		t.Errorf("Expected an event per granted relation, got %v", notifier.events)
+	}
+}
+
+func TestBulkGrantErrors(t *testing.T) {
+	server, _, _, _, permService := createTestServer()
+	permService.SetCanWrite("bob", false)
+	grant := models.PermissionChangeRequest{User: "alice", Relation: "write"}
+
+	tests := []struct {
+		name     string
+		username string
+		grants   []models.PermissionChangeRequest
+		want     int
+	}{
+		{name: "not a writer", username: "bob", grants: []models.PermissionChangeRequest{grant}, want: http.StatusForbidden},
+		{name: "no grants", username: adminUsername, want: http.StatusBadRequest},
+		{name: "too many grants", username: adminUsername, grants: slices.Repeat([]models.PermissionChangeRequest{grant}, maxBulkGrants+1), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := postBulkGrant(server, tt.username, tt.grants); w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+	if len(permService.granted) != 0 {
+		t.Errorf("Expected nothing to be granted, got %v", permService.granted)
+	}
+
+	// Without a bulk API, grants are made one at a time
+	if w := postBulkGrant(server, adminUsername, []models.PermissionChangeRequest{grant, grant}); w.Code != http.StatusOK || len(permService.granted) != 2 {
+		t.Errorf("Expected both grants to be made, got %d: %s", w.Code, w.Body.String())
+	}
+}
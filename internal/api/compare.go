@@ -0,0 +1,38 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/ragservice"
+
+	"github.com/ory/herodot"
+)
+
+// compareDocuments answers how two or more documents the user may read
+// differ, e.g. the tax returns of one taxpayer for different years. Documents
+// the user may not read are rejected like missing ones.
+func (s *Server) compareDocuments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.CompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	if err := ragservice.ValidateCompare(&req); err != nil {
+		s.writer.WriteError(w, r, serviceError(err))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	response, err := s.rag.Compare(r.Context(), principal.Principal, &req)
+	if err != nil {
+		s.writeServiceError(w, r, err, "")
+		return
+	}
+	s.writer.Write(w, r, response)
+}
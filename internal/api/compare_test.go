@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCompareDocuments(t *testing.T) {
+	server, _, vectorStore, llmClient, permService := createTestServer()
+	handler := server.GetHandler()
+	docs := make([]*models.Document, 3)
+	for i, year := range []int{2022, 2023, 2023} {
+		docs[i] = &models.Document{
+			ID:       uuid.New(),
+			Title:    fmt.Sprintf("Tax Return %d", year),
+			Content:  fmt.Sprintf("Refund for %d", year),
+			Metadata: map[string]interface{}{"taxpayer": "John Doe", "year": year, "created_by": fmt.Sprint(i)},
+		}
+		_ = vectorStore.AddDocument(docs[i])
+	}
+	permService.SetDocumentAccess("alice", docs[0].ID.String(), true)
+	permService.SetDocumentAccess("alice", docs[1].ID.String(), true)
+	permService.SetDocumentAccess("alice", docs[2].ID.String(), false)
+	llmClient.SetResponse("refunds", `Here you go: {"summary": "The refund grew.", "differences": [{"aspect": "refund", "values": ["$1,000", "$2,500"]}, {"aspect": "incomplete", "values": ["x"]}]}`)
+
+	body := fmt.Sprintf(`{"document_ids": [%q, %q], "focus": "refunds"}`, docs[0].ID, docs[1].ID)
+	w := serveAs(handler, http.MethodPost, "/compare", []byte(body), "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response models.CompareResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Documents) != 2 || response.Documents[0].ID != docs[0].ID || !response.Documents[1].Included {
+		t.Errorf("Expected both documents in request order, got %+v", response.Documents)
+	}
+	if len(response.Metadata) != 1 || response.Metadata[0].Key != "year" || response.Metadata[0].Values[0] != 2022.0 {
+		t.Errorf("Expected only the year to differ, got %+v", response.Metadata)
+	}
+	if response.Summary != "The refund grew." || len(response.Differences) != 1 || response.Differences[0].Values[1] != "$2,500" {
+		t.Errorf("Expected the structured comparison, got %q %+v", response.Summary, response.Differences)
+	}
+
+	// Documents alice cannot read are rejected like missing ones
+	for _, ids := range [][]uuid.UUID{{docs[0].ID, docs[2].ID}, {docs[0].ID, uuid.New()}, {docs[0].ID, docs[0].ID}} {
+		body := fmt.Sprintf(`{"document_ids": [%q, %q]}`, ids[0], ids[1])
+		if w := serveAs(handler, http.MethodPost, "/compare", []byte(body), "alice"); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 comparing %v, got %d: %s", ids, w.Code, w.Body.String())
+		}
+	}
+
+	// The focus and the comparison are moderated like queries
+	laundering, _ := moderation.NewRule("fraud", []string{"launder"}, "")
+	growth, _ := moderation.NewRule("growth", []string{"grew"}, "")
+	WithModeration(moderation.NewKeywordModerator([]moderation.Rule{laundering, growth}), moderation.ActionBlock, moderation.ActionBlock)(server)
+	for focus, stage := range map[string]string{"how to launder refunds": models.ModerationStageQuestion, "refunds": models.ModerationStageAnswer} {
+		body := fmt.Sprintf(`{"document_ids": [%q, %q], "focus": %q}`, docs[0].ID, docs[1].ID, focus)
+		w := serveAs(handler, http.MethodPost, "/compare", []byte(body), "alice")
+		var response models.CompareResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK || response.Summary != models.ModeratedAnswer || len(response.Differences) != 0 ||
+			len(response.Moderation) != 1 || response.Moderation[0].Stage != stage || len(response.Metadata) != 1 {
+			t.Errorf("Expected the %s of focus %q to be blocked, got %d: %s", stage, focus, w.Code, w.Body.String())
+		}
+	}
+}
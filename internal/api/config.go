@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/models"
+)
+
+// WithConfig enables GET /admin/config reporting the effective configuration
+// of live with secrets redacted
+func WithConfig(live *config.Live) Option {
+	return func(s *Server) {
+		s.config = live
+	}
+}
+
+// getConfig reports the effective configuration. The configuration applies
+// to all tenants, so it requires the write relation on the default tenant's
+// corpus like reindexing.
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s.writer.Write(w, r, &models.ConfigResponse{
+		Config:     config.Redact(s.config.Config()),
+		Reloadable: config.ReloadableKeys,
+		LoadedAt:   s.config.LoadedAt(),
+	})
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/config"
+	apperrors "rerag-rbac-rag-llm/internal/errors"
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestGetConfigRedactsSecrets(t *testing.T) {
+	t.Setenv("RERAG_SECURITY__JWT_SECRET", "s3cret-signing-key")
+	live, err := config.LoadLive()
+	if err != nil {
+		t.Fatalf("LoadLive failed: %v", err)
+	}
+	_, embedder, vectorStore, llmClient, permService := createTestServer()
+	permService.SetCanWrite("bob", false)
+	server := NewServer(embedder, vectorStore, llmClient, permService, apperrors.NewErrorHandler(&config.Config{}), WithConfig(live))
+
+	w := httptest.NewRecorder()
+	server.getConfig(w, createAuthenticatedRequest(http.MethodGet, "/admin/config", nil, adminUsername))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "s3cret-signing-key") {
+		t.Fatal("Expected the JWT secret to be redacted")
+	}
+	var response models.ConfigResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	security := response.Config["security"].(map[string]interface{})
+	if security["jwt_secret"] != config.RedactedValue || len(response.Reloadable) == 0 || response.LoadedAt.IsZero() {
+		t.Errorf("Expected the redacted config with its reloadable settings, got %+v", response)
+	}
+
+	w = serveAs(server.GetHandler(), http.MethodGet, "/admin/config", nil, "bob")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a reader, got %d", http.StatusForbidden, w.Code)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"rerag-rbac-rag-llm/internal/ragservice"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+
+	"github.com/google/uuid"
+	"github.com/ory/herodot"
+)
+
+func (s *Server) conversationStore(ctx context.Context) storage.ConversationStore {
+	return s.conversations.ForTenant(tenant.FromContext(ctx))
+}
+
+func (s *Server) createConversation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// The body is optional; it only carries a title
+	var req models.CreateConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	conv := &models.Conversation{
+		Title:    req.Title,
+		Username: principal.Username,
+	}
+	if err := s.conversationStore(r.Context()).CreateConversation(conv); err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestid.FromContext(r.Context()))
+		return
+	}
+
+	s.writer.WriteCreated(w, r, "/conversations/"+conv.ID.String(), conv)
+}
+
+// postMessage answers a follow-up question using the conversation's prior turns.
+// Retrieval runs with the caller's permissions on every message, so documents
+// revoked mid-conversation are no longer used.
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	requestID := requestid.FromContext(r.Context())
+
+	convID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid conversation ID").WithError(err.Error()))
+		return
+	}
+
+	var req models.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	if err := s.validateQuery(&req); err != nil {
+		s.writer.WriteError(w, r, err)
+		return
+	}
+	timeout, err := queryTimeout(r)
+	if err != nil {
+		s.writer.WriteError(w, r, err)
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	// Conversations of other users are reported as missing rather than forbidden
+	store := s.conversationStore(r.Context())
+	conv, err := store.GetConversation(convID)
+	if errors.Is(err, storage.ErrConversationNotFound) || (err == nil && conv.Username != principal.Username) {
+		s.errHandler.HandleNotFoundError(w, r, "conversation "+convID.String(), requestID)
+		return
+	}
+	if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestID)
+		return
+	}
+
+	// History keeps the redacted answer so later prompts do not contain the values
+	rehydrate := req.Rehydrate
+	req.Rehydrate = false
+	history := llm.TrimHistory(conv.Messages, s.historyTokens)
+	answer, err := s.rag.Query(r.Context(), principal.Principal, &req, ragservice.QueryOptions{History: history, Timeout: timeout})
+	if err != nil {
+		s.writeServiceError(w, r, err, "")
+		return
+	}
+
+	// Blocked questions are not kept, so they never reach later prompts as history
+	if !questionBlocked(answer) {
+		err = store.AppendMessages(convID,
+			models.Message{Role: models.RoleUser, Content: req.Question},
+			models.Message{Role: models.RoleAssistant, Content: answer.Answer},
+		)
+		if err != nil {
+			s.errHandler.HandleDatabaseError(w, r, err, requestID)
+			return
+		}
+	}
+
+	if rehydrate {
+		answer = s.rag.Rehydrate(answer)
+	}
+	response := &models.MessageResponse{
+		ConversationID:        convID,
+		Answer:                answer.Answer,
+		Sources:               answer.Sources,
+		SourcesIncluded:       answer.SourcesIncluded,
+		StrippedCitations:     answer.StrippedCitations,
+		Filters:               answer.Filters,
+		NoAccessibleDocuments: answer.NoAccessibleDocuments,
+		HiddenResults:         answer.HiddenResults,
+		Searches:              answer.Searches,
+		Route:                 answer.Route,
+		Aggregation:           answer.Aggregation,
+		Moderation:            answer.Moderation,
+		Usage:                 answer.Usage,
+	}
+	s.writer.Write(w, r, response)
+}
+
+// questionBlocked reports whether content moderation withheld the question
+// of response
+func questionBlocked(response *models.QueryResponse) bool {
+	for _, m := range response.Moderation {
+		if m.Stage == models.ModerationStageQuestion && m.Action == string(moderation.ActionBlock) {
+			return true
+		}
+	}
+	return false
+}
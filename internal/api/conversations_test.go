@@ -0,0 +1,176 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type MockConversationStore struct {
+	conversations map[uuid.UUID]*models.Conversation
+	tenantID      string
+}
+
+func NewMockConversationStore() *MockConversationStore {
+	return &MockConversationStore{conversations: make(map[uuid.UUID]*models.Conversation)}
+}
+
+func (m *MockConversationStore) ForTenant(tenantID string) storage.ConversationStore {
+	scoped := *m
+	scoped.tenantID = tenantID
+	return &scoped
+}
+
+func (m *MockConversationStore) CreateConversation(conv *models.Conversation) error {
+	conv.ID = uuid.New()
+	conv.TenantID = cmp.Or(m.tenantID, tenant.Default)
+	m.conversations[conv.ID] = conv
+	return nil
+}
+
+func (m *MockConversationStore) GetConversation(id uuid.UUID) (*models.Conversation, error) {
+	conv, ok := m.conversations[id]
+	if !ok || conv.TenantID != cmp.Or(m.tenantID, tenant.Default) {
+		return nil, storage.ErrConversationNotFound
+	}
+	found := *conv
+	found.Messages = append([]models.Message(nil), conv.Messages...)
+	return &found, nil
+}
+
+func (m *MockConversationStore) AppendMessages(id uuid.UUID, messages ...models.Message) error {
+	conv, ok := m.conversations[id]
+	if !ok {
+		return storage.ErrConversationNotFound
+	}
+	conv.Messages = append(conv.Messages, messages...)
+	return nil
+}
+
+func createConversationTestServer() (*Server, *MockVectorStore, *MockLLMClient, *MockPermissionService, *MockConversationStore) {
+	server, _, vectorStore, llmClient, permService := createTestServer()
+	conversations := NewMockConversationStore()
+	WithConversations(conversations, 1024)(server)
+	server.mux = http.NewServeMux()
+	server.setupRoutes()
+	return server, vectorStore, llmClient, permService, conversations
+}
+
+func sendMessage(t *testing.T, server *Server, convID, username, question string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(models.QueryRequest{Question: question})
+	req := httptest.NewRequest(http.MethodPost, "/conversations/"+convID+"/messages", strings.NewReader(string(body)))
+	req.Header.Set("Authorization", "Bearer "+username)
+	w := httptest.NewRecorder()
+	server.GetHandler().ServeHTTP(w, req)
+	return w
+}
+
+func TestConversationMultiTurn(t *testing.T) {
+	server, vectorStore, llmClient, permService, conversations := createConversationTestServer()
+
+	doc := &models.Document{ID: uuid.New(), Title: "John Doe 2023", Content: "Refund $1,200"}
+	_ = vectorStore.AddDocument(doc)
+
+	req := httptest.NewRequest(http.MethodPost, "/conversations", strings.NewReader(`{"title": "Refunds"}`))
+	req.Header.Set("Authorization", "Bearer alice")
+	w := httptest.NewRecorder()
+	server.GetHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var conv models.Conversation
+	if err := json.Unmarshal(w.Body.Bytes(), &conv); err != nil {
+		t.Fatalf("Failed to unmarshal conversation: %v", err)
+	}
+	if conv.Username != "alice" || conv.Title != "Refunds" {
+		t.Errorf("Unexpected conversation: %+v", conv)
+	}
+
+	w = sendMessage(t, server, conv.ID.String(), "alice", "What was John's refund?")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var first models.MessageResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &first)
+	if len(first.Sources) != 1 || len(llmClient.lastHistory) != 0 {
+		t.Errorf("Expected one source and no history on the first turn, got %d sources and %d history messages", len(first.Sources), len(llmClient.lastHistory))
+	}
+
+	// Revoke access; the follow-up must not use the document anymore
+	permService.SetDocumentAccess("alice", doc.ID.String(), false)
+	w = sendMessage(t, server, conv.ID.String(), "alice", "And in 2022?")
+	var second models.MessageResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &second)
+	if len(second.Sources) != 0 {
+		t.Errorf("Expected revoked document to be excluded, got %d sources", len(second.Sources))
+	}
+	if len(llmClient.lastHistory) != 2 || llmClient.lastHistory[0].Content != "What was John's refund?" {
+		t.Errorf("Expected the first turn as history, got %+v", llmClient.lastHistory)
+	}
+
+	if stored := conversations.conversations[conv.ID]; len(stored.Messages) != 4 {
+		t.Errorf("Expected 4 stored messages, got %d", len(stored.Messages))
+	}
+}
+
+func TestConversationModeration(t *testing.T) {
+	server, vectorStore, _, _, conversations := createConversationTestServer()
+	rule, _ := moderation.NewRule("fraud", []string{"launder"}, "")
+	WithModeration(moderation.NewKeywordModerator([]moderation.Rule{rule}), moderation.ActionBlock, "")(server)
+	_ = vectorStore.AddDocument(&models.Document{ID: uuid.New(), Title: "Notes", Content: "Refunds take 5 days"})
+	conv := &models.Conversation{Username: "alice"}
+	_ = conversations.CreateConversation(conv)
+
+	w := sendMessage(t, server, conv.ID.String(), "alice", "How do I launder my refund?")
+	var response models.MessageResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || response.Answer != models.ModeratedAnswer || len(response.Moderation) != 1 || response.Moderation[0].Categories[0] != "fraud" {
+		t.Fatalf("Expected the question to be blocked and reported, got %d: %s", w.Code, w.Body.String())
+	}
+	if stored := conversations.conversations[conv.ID]; len(stored.Messages) != 0 {
+		t.Errorf("Expected the blocked turn not to be stored, got %+v", stored.Messages)
+	}
+
+	if w := sendMessage(t, server, conv.ID.String(), "alice", "How long do refunds take?"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if stored := conversations.conversations[conv.ID]; len(stored.Messages) != 2 || stored.Messages[0].Content != "How long do refunds take?" {
+		t.Errorf("Expected only the allowed turn to be stored, got %+v", stored.Messages)
+	}
+}
+
+func TestConversationErrors(t *testing.T) {
+	server, _, _, _, conversations := createConversationTestServer()
+
+	conv := &models.Conversation{Username: "alice"}
+	_ = conversations.CreateConversation(conv)
+
+	tests := []struct {
+		name     string
+		convID   string
+		username string
+		expected int
+	}{
+		{"other user's conversation", conv.ID.String(), "bob", http.StatusNotFound},
+		{"unknown conversation", uuid.New().String(), "alice", http.StatusNotFound},
+		{"invalid id", "not-a-uuid", "alice", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := sendMessage(t, server, tt.convID, tt.username, "question"); w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/directory"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"strings"
+
+	"github.com/ory/herodot"
+)
+
+// writeDirectoryError writes the response for a failed directory change:
+// membership changes the permission service failed are upstream failures
+func (s *Server) writeDirectoryError(w http.ResponseWriter, r *http.Request, err error) {
+	requestID := requestid.FromContext(r.Context())
+	if errors.Is(err, directory.ErrMembership) {
+		s.errHandler.HandleServiceError(w, r, "permission service", err, requestID)
+		return
+	}
+	s.errHandler.HandleDatabaseError(w, r, err, requestID)
+}
+
+// listDirectoryUsers lists the users of the tenant's directory. Managing the
+// directory requires the write relation on the corpus.
+func (s *Server) listDirectoryUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	users, err := s.directory.Store(r.Context()).ListUsers()
+	if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestid.FromContext(r.Context()))
+		return
+	}
+	s.writer.Write(w, r, &models.DirectoryUserListResponse{Users: users})
+}
+
+// getDirectoryUser returns a directory user with their groups and roles
+func (s *Server) getDirectoryUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	requestID := requestid.FromContext(r.Context())
+
+	username := r.PathValue("username")
+	user, err := s.directory.Store(r.Context()).GetUser(username)
+	if errors.Is(err, storage.ErrDirectoryUserNotFound) {
+		s.errHandler.HandleNotFoundError(w, r, "user "+username, requestID)
+		return
+	}
+	if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestID)
+		return
+	}
+	s.writer.Write(w, r, user)
+}
+
+// putDirectoryUser creates or replaces a directory user. Their memberships
+// change in the permission service's groups too.
+func (s *Server) putDirectoryUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	var req models.PutDirectoryUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	user := models.DirectoryUser{Username: r.PathValue("username"), DisplayName: strings.TrimSpace(req.DisplayName), Groups: req.Groups, Roles: req.Roles}
+	if err := directory.ValidateUser(&user); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid user").WithError(err.Error()))
+		return
+	}
+
+	created, err := s.directory.PutUser(r.Context(), &user)
+	if errors.Is(err, storage.ErrDirectoryGroupNotFound) {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Unknown group").WithError(err.Error()))
+		return
+	}
+	if err != nil {
+		s.writeDirectoryError(w, r, err)
+		return
+	}
+
+	requestid.Logf(r.Context(), "AUDIT directory user saved: admin=%q user=%q groups=%s roles=%s created=%t client_ip=%s",
+		principal.Username, user.Username, strings.Join(user.Groups, ","), strings.Join(user.Roles, ","), created, clientip.FromRequest(r))
+	if created {
+		s.writer.WriteCreated(w, r, "/admin/users/"+url.PathEscape(user.Username), &user)
+		return
+	}
+	s.writer.Write(w, r, &user)
+}
+
+// deleteDirectoryUser deletes a directory user and responds with them. Their
+// bearer username is rejected from then on; relations granted to them remain
+// until they are offboarded.
+func (s *Server) deleteDirectoryUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	username := r.PathValue("username")
+	user, err := s.directory.DeleteUser(r.Context(), username)
+	if errors.Is(err, storage.ErrDirectoryUserNotFound) {
+		s.errHandler.HandleNotFoundError(w, r, "user "+username, requestid.FromContext(r.Context()))
+		return
+	}
+	if err != nil {
+		s.writeDirectoryError(w, r, err)
+		return
+	}
+
+	requestid.Logf(r.Context(), "AUDIT directory user deleted: admin=%q user=%q client_ip=%s", principal.Username, username, clientip.FromRequest(r))
+	s.writer.Write(w, r, user)
+}
+
+// listDirectoryGroups lists the groups of the tenant's directory
+func (s *Server) listDirectoryGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	groups, err := s.directory.Store(r.Context()).ListGroups()
+	if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestid.FromContext(r.Context()))
+		return
+	}
+	s.writer.Write(w, r, &models.DirectoryGroupListResponse{Groups: groups})
+}
+
+// getDirectoryGroup returns a directory group with its members
+func (s *Server) getDirectoryGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	requestID := requestid.FromContext(r.Context())
+
+	name := r.PathValue("group")
+	group, err := s.directory.Store(r.Context()).GetGroup(name)
+	if errors.Is(err, storage.ErrDirectoryGroupNotFound) {
+		s.errHandler.HandleNotFoundError(w, r, "group "+name, requestID)
+		return
+	}
+	if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestID)
+		return
+	}
+	s.writer.Write(w, r, group)
+}
+
+// putDirectoryGroup creates a directory group or updates its description.
+// Members are added by putting users.
+func (s *Server) putDirectoryGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	var req models.PutDirectoryGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	group := models.DirectoryGroup{Name: r.PathValue("group"), Description: strings.TrimSpace(req.Description)}
+	if err := directory.ValidateGroup(&group); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid group name").WithError(err.Error()))
+		return
+	}
+
+	created, err := s.directory.PutGroup(r.Context(), &group)
+	if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestid.FromContext(r.Context()))
+		return
+	}
+
+	requestid.Logf(r.Context(), "AUDIT directory group saved: admin=%q group=%q created=%t client_ip=%s",
+		principal.Username, group.Name, created, clientip.FromRequest(r))
+	if created {
+		s.writer.WriteCreated(w, r, "/admin/groups/"+group.Name, &group)
+		return
+	}
+	s.writer.Write(w, r, &group)
+}
+
+// deleteDirectoryGroup deletes a directory group and removes its members from
+// the permission service's group of the same name
+func (s *Server) deleteDirectoryGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("group")
+	group, err := s.directory.DeleteGroup(r.Context(), name)
+	if errors.Is(err, storage.ErrDirectoryGroupNotFound) {
+		s.errHandler.HandleNotFoundError(w, r, "group "+name, requestid.FromContext(r.Context()))
+		return
+	}
+	if err != nil {
+		s.writeDirectoryError(w, r, err)
+		return
+	}
+
+	requestid.Logf(r.Context(), "AUDIT directory group deleted: admin=%q group=%q members=%s client_ip=%s",
+		principal.Username, name, strings.Join(group.Members, ","), clientip.FromRequest(r))
+	s.writer.Write(w, r, group)
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/directory"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+	"slices"
+	"testing"
+)
+
+func createDirectoryTestServer(t *testing.T) (http.Handler, *MockPermissionService) {
+	t.Helper()
+	server, _, _, _, permService := createTestServer()
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "directory.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	users, err := storage.NewSQLiteDirectoryStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create directory store: %v", err)
+	}
+	// The administrator is a directory user like everyone else
+	if _, err := users.PutUser(&models.DirectoryUser{Username: adminUsername}); err != nil {
+		t.Fatalf("Failed to create admin: %v", err)
+	}
+	WithAuthenticator(auth.NewDirectoryAuthenticator(users))(server)
+	WithDirectory(directory.New(users, permService))(server)
+	server.mux = http.NewServeMux()
+	server.setupRoutes()
+	return server.GetHandler(), permService
+}
+
+func TestDirectoryUsersAndGroups(t *testing.T) {
+	handler, permService := createDirectoryTestServer(t)
+
+	// Bearer names outside the directory are rejected
+	if w := serveAs(handler, http.MethodGet, "/documents", nil, "alice"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d for an unknown user, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w := serveAs(handler, http.MethodPut, "/admin/users/alice", []byte(`{"groups": ["advisors"]}`), adminUsername)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d for an unknown group, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+	w = serveAs(handler, http.MethodPut, "/admin/groups/advisors", []byte(`{"description": "Tax advisors"}`), adminUsername)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	w = serveAs(handler, http.MethodPut, "/admin/users/alice", []byte(`{"display_name": "Alice", "groups": ["advisors"], "roles": ["advisor"]}`), adminUsername)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/admin/users/alice" {
+		t.Fatalf("Expected status %d with a location, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if !slices.Equal(permService.members["advisors"], []string{"alice"}) {
+		t.Errorf("Expected alice to join the permission service's group, got %v", permService.members)
+	}
+
+	// Directory users authenticate with their groups; readers cannot manage
+	// the directory
+	permService.SetCanWrite("alice", false)
+	if w := serveAs(handler, http.MethodGet, "/documents", nil, "alice"); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for a directory user, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := serveAs(handler, http.MethodGet, "/admin/users", nil, "alice"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a reader, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w = serveAs(handler, http.MethodGet, "/admin/groups/advisors", nil, adminUsername)
+	var group models.DirectoryGroup
+	if err := json.Unmarshal(w.Body.Bytes(), &group); err != nil || !slices.Equal(group.Members, []string{"alice"}) {
+		t.Fatalf("Unexpected group %s (%v)", w.Body.String(), err)
+	}
+
+	w = serveAs(handler, http.MethodGet, "/admin/users", nil, adminUsername)
+	var listed models.DirectoryUserListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil || len(listed.Users) != 2 {
+		t.Fatalf("Expected two users, got %s (%v)", w.Body.String(), err)
+	}
+
+	// Deleted users lose their memberships and can no longer sign in
+	if w := serveAs(handler, http.MethodDelete, "/admin/users/alice", nil, adminUsername); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if len(permService.members["advisors"]) != 0 {
+		t.Errorf("Expected alice to leave the permission service's group, got %v", permService.members["advisors"])
+	}
+	if w := serveAs(handler, http.MethodGet, "/documents", nil, "alice"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for a deleted user, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := serveAs(handler, http.MethodGet, "/admin/users/alice", nil, adminUsername); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/tenant"
 	"testing"
 	"time"
 
@@ -36,6 +38,7 @@ func addTestDocument(t *testing.T, server *Server) string {
 	body, _ := json.Marshal(doc)
 	req := httptest.NewRequest(http.MethodPost, "/documents", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+adminUsername)
 	w := httptest.NewRecorder()
 
 	server.mux.ServeHTTP(w, req)
@@ -51,6 +54,21 @@ func addTestDocument(t *testing.T, server *Server) string {
 	return addResponse["id"]
 }
 
+func TestE2E_DocumentIngestionRequiresAuth(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+
+	body, _ := json.Marshal(models.Document{Title: "Unauthenticated", Content: "Should be rejected"})
+	req := httptest.NewRequest(http.MethodPost, "/documents", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	server.mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected unauthorized without auth header, got %d", w.Code)
+	}
+}
+
 func testDocumentListingWithoutAuth(t *testing.T, server *Server) {
 	req := httptest.NewRequest(http.MethodGet, "/documents", nil)
 	w := httptest.NewRecorder()
@@ -121,6 +139,7 @@ func addQueryTestDocuments(t *testing.T, server *Server) {
 		body, _ := json.Marshal(doc)
 		req := httptest.NewRequest(http.MethodPost, "/documents", bytes.NewBuffer(body))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+adminUsername)
 		w := httptest.NewRecorder()
 		server.mux.ServeHTTP(w, req)
 
@@ -377,6 +396,7 @@ func TestE2E_ConcurrentAccess(t *testing.T) {
 	body, _ := json.Marshal(doc)
 	req := httptest.NewRequest(http.MethodPost, "/documents", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+adminUsername)
 	w := httptest.NewRecorder()
 
 	server.mux.ServeHTTP(w, req)
@@ -474,7 +494,7 @@ func TestE2E_InvalidEndpoints(t *testing.T) {
 		{http.MethodPatch, "/health", http.StatusMethodNotAllowed},
 		{http.MethodDelete, "/documents", http.StatusMethodNotAllowed},
 		{http.MethodPut, "/query", http.StatusMethodNotAllowed},
-		{http.MethodPost, "/permissions", http.StatusMethodNotAllowed},
+		{http.MethodPatch, "/permissions", http.StatusMethodNotAllowed},
 	}
 
 	for _, tc := range testCases {
@@ -492,6 +512,97 @@ func TestE2E_InvalidEndpoints(t *testing.T) {
 	}
 }
 
+func TestE2E_RequestIDPropagation(t *testing.T) {
+	server, embedder, _, _, _ := createTestServer()
+	handler := server.GetHandler()
+
+	// A generated request ID is returned when the client does not send one
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get(requestid.Header) == "" {
+		t.Error("Expected a generated request ID in the response headers")
+	}
+
+	// A client-supplied request ID is echoed back and included in error responses
+	embedder.SetShouldFail(true)
+	body, _ := json.Marshal(models.QueryRequest{Question: "Test question"})
+	req = httptest.NewRequest(http.MethodPost, "/query", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer testuser")
+	req.Header.Set(requestid.Header, "test-request-id")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get(requestid.Header); got != "test-request-id" {
+		t.Errorf("Expected request ID 'test-request-id', got '%s'", got)
+	}
+
+	var errResponse struct {
+		Error struct {
+			Request string `json:"request"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &errResponse); err != nil {
+		t.Fatalf("Failed to unmarshal error response: %v", err)
+	}
+	if errResponse.Error.Request != "test-request-id" {
+		t.Errorf("Expected request ID in error response, got '%s'", errResponse.Error.Request)
+	}
+}
+
+func TestE2E_TenantIsolation(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+	handler := server.GetHandler()
+
+	body, _ := json.Marshal(models.Document{Title: "Acme Report", Content: "Acme only"})
+	req := httptest.NewRequest(http.MethodPost, "/documents", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+adminUsername)
+	req.Header.Set(tenant.Header, "acme")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Failed to add tenant document: %d %s", w.Code, w.Body.String())
+	}
+
+	countDocuments := func(tenantID string) int {
+		req := httptest.NewRequest(http.MethodGet, "/documents", nil)
+		req.Header.Set("Authorization", "Bearer alice")
+		if tenantID != "" {
+			req.Header.Set(tenant.Header, tenantID)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		var response models.DocumentListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal list response: %v", err)
+		}
+		return response.Count
+	}
+
+	if n := countDocuments("acme"); n != 1 {
+		t.Errorf("Expected acme to see 1 document, got %d", n)
+	}
+	if n := countDocuments("globex"); n != 0 {
+		t.Errorf("Expected globex to see 0 documents, got %d", n)
+	}
+	if n := countDocuments(""); n != 0 {
+		t.Errorf("Expected default tenant to see 0 documents, got %d", n)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/documents", nil)
+	req.Header.Set("Authorization", "Bearer alice")
+	req.Header.Set(tenant.Header, "../etc")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid tenant ID, got %d", w.Code)
+	}
+}
+
 // Helper error type for concurrent testing
 type ConcurrentTestError struct {
 	UserID     int
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+
+	"github.com/ory/herodot"
+)
+
+// exportFlushInterval is how many exported documents are buffered before flushing
+const exportFlushInterval = 100
+
+// exportDocuments streams documents with their embeddings, metadata, and
+// timestamps as JSONL for offline analysis. Documents can be filtered with
+// metadata.<key> parameters; repeated tenant parameters export several tenants
+// instead of the request's, unless the caller is bound to its tenant.
+// Exporting requires the write relation on the corpus of every exported
+// tenant, since it bypasses per-document permissions.
+func (s *Server) exportDocuments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tenants := query["tenant"]
+	if len(tenants) == 0 {
+		tenants = []string{tenant.FromContext(r.Context())}
+	}
+	metadata := parseMetadataFilter(query)
+	if err := storage.ValidateMetadataFilter(metadata); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid query parameters").WithError(err.Error()))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	exporters := make([]storage.Exporter, len(tenants))
+	for i, id := range tenants {
+		if !tenant.IsValid(id) {
+			s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReasonf("Invalid tenant ID: %s", id))
+			return
+		}
+		if principal.TenantBound && id != principal.Tenant {
+			s.forbid(w, r, fmt.Errorf("user %s bound to tenant %s is not allowed to export documents of tenant %s", principal.Username, principal.Tenant, id))
+			return
+		}
+		if !s.permService.CanWriteDocuments(tenant.NewContext(r.Context(), id), principal.Principal) {
+			s.forbid(w, r, fmt.Errorf("user %s is not allowed to export documents of tenant %s", principal.Username, id))
+			return
+		}
+		exporter, ok := s.vectorStore.ForTenant(id).(storage.Exporter)
+		if !ok {
+			s.writer.WriteError(w, r, errNotImplemented.WithReason("The document store cannot export documents"))
+			return
+		}
+		exporters[i] = exporter
+	}
+
+	// Exports of large corpora outlast the server's write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	// The status is sent with the first document so failures before any
+	// output can still be reported as regular JSON errors
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="documents.jsonl"`)
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	encoder := json.NewEncoder(w)
+	count := 0
+	write := func(doc *models.Document) error {
+		if !started {
+			start()
+		}
+		if err := encoder.Encode(models.ExportedDocument{Document: *doc, Embedding: doc.Embedding}); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushInterval == 0 {
+			_ = rc.Flush()
+		}
+		return nil
+	}
+
+	for i, exporter := range exporters {
+		if err := exporter.ExportDocuments(metadata, write); err != nil {
+			if started {
+				// Truncating the stream is the only way left to signal the failure
+				requestid.Logf(r.Context(), "Document export of tenant %s failed after %d documents: %v", tenants[i], count, err)
+				return
+			}
+			s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to export documents").WithError(err.Error()))
+			return
+		}
+	}
+
+	if !started {
+		start()
+	}
+	requestid.Logf(r.Context(), "User %s exported %d documents of tenants %v", principal.Username, count, tenants)
+}
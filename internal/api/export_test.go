@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"testing"
+)
+
+func TestExportDocuments(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, _ := storage.NewInMemoryVectorStore("")
+	server := newTestServer(embedder, store, llmClient, permService)
+
+	_ = store.AddDocument(&models.Document{Title: "Return", Metadata: map[string]interface{}{"year": 2023}, Embedding: []float32{0.1, 0.2}})
+	_ = store.AddDocument(&models.Document{Title: "Invoice", Metadata: map[string]interface{}{"year": 2022}, Embedding: []float32{0.3, 0.4}})
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Metadata: map[string]interface{}{"year": 2023}, Embedding: []float32{0.5, 0.6}})
+
+	export := func(url, user string) (*httptest.ResponseRecorder, []models.ExportedDocument) {
+		w := httptest.NewRecorder()
+		server.exportDocuments(w, createAuthenticatedRequest(http.MethodGet, url, nil, user))
+		var docs []models.ExportedDocument
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var doc models.ExportedDocument
+			if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+				t.Fatalf("Invalid JSONL line %q: %v", scanner.Text(), err)
+			}
+			docs = append(docs, doc)
+		}
+		return w, docs
+	}
+
+	w, docs := export("/documents/export?metadata.year=2023", "admin")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Expected a JSONL export, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if len(docs) != 1 || docs[0].Title != "Return" {
+		t.Fatalf("Expected only the 2023 return of the default tenant, got %+v", docs)
+	}
+	if !slices.Equal(docs[0].Embedding, []float32{0.1, 0.2}) || docs[0].TenantID != "default" || docs[0].CreatedAt.IsZero() {
+		t.Errorf("Expected the embedding, tenant, and timestamp to be exported, got %+v", docs[0])
+	}
+
+	_, docs = export("/documents/export?tenant=default&tenant=acme&metadata.year=2023", "admin")
+	if len(docs) != 2 || docs[1].TenantID != "acme" {
+		t.Errorf("Expected documents of both tenants, got %+v", docs)
+	}
+
+	if w, _ := export("/documents/export?metadata.bad-key=1", "admin"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid metadata key, got %d", w.Code)
+	}
+	if w, _ := export("/documents/export?tenant=Not%20Valid", "admin"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid tenant, got %d", w.Code)
+	}
+
+	permService.SetCanWrite("bob", false)
+	if w, _ := export("/documents/export", "bob"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for non-admins, got %d", w.Code)
+	}
+
+	// Users bound to a tenant cannot export others, even holding write there
+	for url, status := range map[string]int{
+		"/documents/export":                            http.StatusOK,
+		"/documents/export?tenant=acme":                http.StatusOK,
+		"/documents/export?tenant=default":             http.StatusForbidden,
+		"/documents/export?tenant=acme&tenant=default": http.StatusForbidden,
+	} {
+		req := createAuthenticatedRequest(http.MethodGet, url, nil, "admin")
+		ctx := tenant.NewContext(req.Context(), "acme")
+		ctx = auth.NewContext(ctx, auth.Principal{Principal: permissions.Principal{Username: "admin"}, Tenant: "acme", TenantBound: true, Method: auth.AuthMethodUser})
+		w := httptest.NewRecorder()
+		server.exportDocuments(w, req.WithContext(ctx))
+		if w.Code != status {
+			t.Errorf("%s: expected %d for a user bound to acme, got %d", url, status, w.Code)
+		}
+	}
+}
+
+func TestExportDocumentsUnsupportedStore(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+
+	w := httptest.NewRecorder()
+	server.exportDocuments(w, createAuthenticatedRequest(http.MethodGet, "/documents/export", nil, "admin"))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 for stores without export support, got %d", w.Code)
+	}
+}
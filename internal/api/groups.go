@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/webhooks"
+
+	"github.com/ory/herodot"
+)
+
+// groupManager returns the permission service's group manager and the
+// validated group name. It writes the error response and returns false
+// otherwise. Managing groups requires the write relation on the corpus, like
+// changePermission, which the route checks before the handler runs.
+func (s *Server) groupManager(w http.ResponseWriter, r *http.Request) (permissions.GroupManager, string, bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	manager, ok := s.permService.(permissions.GroupManager)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot manage groups"))
+		return nil, "", false
+	}
+
+	group := r.PathValue("group")
+	if err := permissions.ValidateGroupName(group); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid group name").WithError(err.Error()))
+		return nil, "", false
+	}
+	return manager, group, true
+}
+
+// writeGroupError writes the response for a failed group operation
+func (s *Server) writeGroupError(w http.ResponseWriter, r *http.Request, err error, reason string) {
+	if errors.Is(err, permissions.ErrChangesUnsupported) {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot manage groups"))
+		return
+	}
+	s.writer.WriteError(w, r, upstreamError(err, reason))
+}
+
+// getGroup lists a group's members and the relations granted to them
+func (s *Server) getGroup(w http.ResponseWriter, r *http.Request) {
+	manager, group, ok := s.groupManager(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := manager.Members(r.Context(), group)
+	if err != nil {
+		s.writeGroupError(w, r, err, "Failed to list group members")
+		return
+	}
+	tuples, err := manager.ListGroupTuples(r.Context(), group)
+	if err != nil {
+		s.writeGroupError(w, r, err, "Failed to list group relations")
+		return
+	}
+
+	if members == nil {
+		members = []string{}
+	}
+	s.writer.Write(w, r, &models.GroupResponse{
+		Group:     group,
+		Members:   members,
+		Relations: relationTuples(tuples),
+	})
+}
+
+// addGroupMember adds a user to a group. Relations granted to the group apply
+// to the user while they are a member.
+func (s *Server) addGroupMember(w http.ResponseWriter, r *http.Request) {
+	s.changeGroupMember(w, r, true)
+}
+
+// removeGroupMember removes a user from a group
+func (s *Server) removeGroupMember(w http.ResponseWriter, r *http.Request) {
+	s.changeGroupMember(w, r, false)
+}
+
+// changeGroupMember adds or removes the group member named in the path
+func (s *Server) changeGroupMember(w http.ResponseWriter, r *http.Request, add bool) {
+	manager, group, ok := s.groupManager(w, r)
+	if !ok {
+		return
+	}
+	user := r.PathValue("user")
+	if user == "" {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("User is required"))
+		return
+	}
+
+	change, event, message := manager.RemoveMember, webhooks.GroupMemberRemoved, "Group member removed successfully"
+	if add {
+		change, event, message = manager.AddMember, webhooks.GroupMemberAdded, "Group member added successfully"
+	}
+	if err := change(r.Context(), group, user); err != nil {
+		s.writeGroupError(w, r, err, "Failed to change group membership")
+		return
+	}
+	s.notify(r.Context(), event, webhooks.GroupMemberData{Group: group, User: user})
+
+	s.writer.Write(w, r, &models.GroupMemberResponse{
+		Group:   group,
+		User:    user,
+		Message: message,
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// serveAs routes a request through the server's handler, authenticated as username
+func serveAs(handler http.Handler, method, url string, body []byte, username string) *httptest.ResponseRecorder {
+	req := createAuthenticatedRequest(method, url, body, username)
+	req.Header.Set("Authorization", "Bearer "+username)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestGroupMembershipAndGrants(t *testing.T) {
+	server, _, _, _, permService := createTestServer()
+	notifier := &recordingNotifier{}
+	WithWebhooks(notifier)(server)
+	handler := server.GetHandler()
+	docID := uuid.New()
+
+	send := func(method, url string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		w := serveAs(handler, method, url, body, adminUsername)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: expected status 200, got %d: %s", method, url, w.Code, w.Body.String())
+		}
+		return w
+	}
+
+	send(http.MethodPut, "/groups/accounting-team/members/alice", nil)
+	send(http.MethodPut, "/groups/accounting-team/members/bob", nil)
+	send(http.MethodDelete, "/groups/accounting-team/members/bob", nil)
+
+	body, _ := json.Marshal(models.PermissionChangeRequest{Group: "accounting-team", Relation: "viewer", DocumentID: docID.String()})
+	w := send(http.MethodPost, "/permissions", body)
+	var change models.PermissionChangeResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &change)
+	if change.Group != "accounting-team" || change.User != "" || change.Object != docID.String() {
+		t.Errorf("Unexpected permission change response %+v", change)
+	}
+
+	var group models.GroupResponse
+	_ = json.Unmarshal(send(http.MethodGet, "/groups/accounting-team", nil).Body.Bytes(), &group)
+	if !slices.Equal(group.Members, []string{"alice"}) {
+		t.Errorf("Expected members [alice], got %v", group.Members)
+	}
+	wantRelations := []models.RelationTuple{{Group: "accounting-team", Relation: "viewer", Object: docID.String()}}
+	if !slices.Equal(group.Relations, wantRelations) {
+		t.Errorf("Expected relations %v, got %v", wantRelations, group.Relations)
+	}
+
+	wantGranted := []permissions.Tuple{{Group: "accounting-team", Relation: "viewer", DocumentID: docID}}
+	if !slices.Equal(permService.granted, wantGranted) {
+		t.Errorf("Expected the grant to the group, got %v", permService.granted)
+	}
+	wantEvents := []webhooks.EventType{webhooks.GroupMemberAdded, webhooks.GroupMemberAdded, webhooks.GroupMemberRemoved, webhooks.PermissionGranted}
+	if !slices.Equal(notifier.events, wantEvents) {
+		t.Errorf("Expected events %v, got %v", wantEvents, notifier.events)
+	}
+	if data := notifier.data[3].(webhooks.PermissionData); data.Group != "accounting-team" || data.Subject != "" {
+		t.Errorf("Expected the permission event to name the group, got %+v", data)
+	}
+}
+
+func TestGroupErrors(t *testing.T) {
+	server, _, _, _, permService := createTestServer()
+	permService.SetCanWrite("bob", false)
+	handler := server.GetHandler()
+	docID := uuid.New().String()
+
+	userAndGroup, _ := json.Marshal(models.PermissionChangeRequest{User: "alice", Group: "team", Relation: "viewer", DocumentID: docID})
+	invalidGroup, _ := json.Marshal(models.PermissionChangeRequest{Group: "team:admins", Relation: "viewer", DocumentID: docID})
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     []byte
+		username string
+		want     int
+	}{
+		{name: "not a writer", method: http.MethodPut, url: "/groups/team/members/bob", username: "bob", want: http.StatusForbidden},
+		{name: "list as non-writer", method: http.MethodGet, url: "/groups/team", username: "bob", want: http.StatusForbidden},
+		{name: "invalid group", method: http.MethodPut, url: "/groups/-team/members/alice", username: adminUsername, want: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodPost, url: "/groups/team/members/alice", username: adminUsername, want: http.StatusMethodNotAllowed},
+		{name: "user and group", method: http.MethodPost, url: "/permissions", body: userAndGroup, username: adminUsername, want: http.StatusBadRequest},
+		{name: "invalid group grant", method: http.MethodPost, url: "/permissions", body: invalidGroup, username: adminUsername, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(handler, tt.method, tt.url, tt.body, tt.username)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+	if len(permService.members) != 0 || len(permService.granted) != 0 {
+		t.Errorf("Expected no changes, got members %v, granted %v", permService.members, permService.granted)
+	}
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/tenant"
+)
+
+// getMetrics exports the query usage of the request tenant's users and the
+// runs of the tenant's connector crawls in the Prometheus text format. Since it names users, only users with the write
+// relation on the corpus may scrape it.
+func (s *Server) getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	tenantID := tenant.FromContext(r.Context())
+	if s.meter != nil {
+		if err := s.meter.WritePrometheus(w, tenantID); err != nil {
+			requestid.Logf(r.Context(), "Failed to write metrics: %v", err)
+			return
+		}
+	}
+	if s.crawlMetrics != nil {
+		if err := s.crawlMetrics.WritePrometheus(w, tenantID); err != nil {
+			requestid.Logf(r.Context(), "Failed to write crawl metrics: %v", err)
+		}
+	}
+}
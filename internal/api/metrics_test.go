@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/metering"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/scheduler"
+	"rerag-rbac-rag-llm/internal/storage"
+	"strings"
+	"testing"
+)
+
+func TestQueryUsageMetering(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, _ := storage.NewInMemoryVectorStore("")
+	server := newTestServer(embedder, store, llmClient, permService)
+	WithMeter(metering.NewMeter())(server)
+	server.mux = http.NewServeMux()
+	server.setupRoutes()
+	handler := server.GetHandler()
+
+	doc := &models.Document{Title: "Tax Return - John Doe", Content: "Refund amount of $2,500", Embedding: []float32{0.1, 0.2, 0.3}}
+	if err := store.AddDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+	setupAlicePermissions(permService, doc.ID.String())
+	question := "What was John Doe's refund amount in 2023?"
+	embedder.SetEmbedding(question, []float32{0.1, 0.2, 0.3})
+	llmClient.SetResponse(question, "John Doe's refund amount in 2023 was $2,500")
+
+	// Usage is only included on request, but every query is accounted
+	if response := executeQuery(t, server, question, "alice"); response.Usage != nil {
+		t.Errorf("Expected no usage without include_usage, got %+v", response.Usage)
+	}
+	w := serveAs(handler, http.MethodPost, "/query", []byte(`{"question": "What was John Doe's refund amount in 2023?", "include_usage": true}`), "alice")
+	var response models.QueryResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	usage := response.Usage
+	if usage == nil || usage.EmbeddingTokens == 0 || usage.PromptTokens != 100 || usage.ResponseTokens != 8 {
+		t.Fatalf("Expected the tokens of the query, got %+v", usage)
+	}
+	if usage.Timings.TotalMs < usage.Timings.EmbedMs+usage.Timings.SearchMs+usage.Timings.GenerateMs {
+		t.Errorf("Expected the total to cover the stages, got %+v", usage.Timings)
+	}
+
+	w = serveAs(handler, http.MethodGet, "/usage", nil, "alice")
+	var usageResponse models.UsageResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &usageResponse); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if q := usageResponse.Queries; q == nil || q.Queries != 2 || q.PromptTokens != 200 || q.ResponseTokens != 16 {
+		t.Errorf("Expected alice's query totals, got %+v", q)
+	}
+
+	// Metrics name users, so only admins may read them
+	permService.SetCanWrite("alice", false)
+	if w := serveAs(handler, http.MethodGet, "/metrics", nil, "alice"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+	w = serveAs(handler, http.MethodGet, "/metrics", nil, adminUsername)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("Expected Prometheus metrics, got %d (%s)", w.Code, w.Header().Get("Content-Type"))
+	}
+	if want := `rerag_query_tokens_total{tenant="default",user="alice",kind="prompt"} 200`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("Expected %q in\n%s", want, w.Body.String())
+	}
+}
+
+func TestCrawlMetrics(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, _ := storage.NewInMemoryVectorStore("")
+	server := newTestServer(embedder, store, llmClient, permService)
+	metrics := scheduler.NewMetrics()
+	WithCrawlMetrics(metrics)(server)
+	server.mux = http.NewServeMux()
+	server.setupRoutes()
+	handler := server.GetHandler()
+
+	crawls := scheduler.New(metrics)
+	crawls.Add(scheduler.Job{Name: "s3", Tenant: "default", Run: func(context.Context) (scheduler.RunStats, error) {
+		return scheduler.RunStats{Added: 3, Removed: 1}, nil
+	}})
+	crawls.Add(scheduler.Job{Name: "drive", Tenant: "other", Run: func(context.Context) (scheduler.RunStats, error) {
+		return scheduler.RunStats{Added: 5}, nil
+	}})
+	crawls.Run(context.Background())
+
+	// Crawl metrics are served without a meter, for the request tenant only
+	w := serveAs(handler, http.MethodGet, "/metrics", nil, adminUsername)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`rerag_crawl_objects_total{tenant="default",job="s3",change="added"} 3`,
+		`rerag_crawl_objects_total{tenant="default",job="s3",change="removed"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `job="drive"`) {
+		t.Errorf("Expected no crawls of other tenants in\n%s", body)
+	}
+}
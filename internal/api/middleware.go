@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"runtime/debug"
+	"slices"
+)
+
+// middleware wraps a handler in behavior shared by several routes
+type middleware func(http.Handler) http.Handler
+
+// chain is an ordered list of middleware; the first one sees the request
+// first
+type chain []middleware
+
+// then wraps h in the chain's middleware
+func (c chain) then(h http.Handler) http.Handler {
+	for _, m := range slices.Backward(c) {
+		h = m(h)
+	}
+	return h
+}
+
+// with returns the chain followed by m, leaving c unchanged
+func (c chain) with(m ...middleware) chain {
+	return append(slices.Clip(c), m...)
+}
+
+// group applies use to routes, before the middleware they declare
+// themselves
+func group(use chain, routes ...route) []route {
+	for i := range routes {
+		routes[i].use = use.with(routes[i].use...)
+	}
+	return routes
+}
+
+// middleware returns the chain every request passes before it is routed.
+// Panics are recovered once the request has an ID, so the 500 names it.
+func (s *Server) middleware() chain {
+	return chain{
+		func(next http.Handler) http.Handler { return clientip.Middleware(s.clientIPs, next) },
+		s.withSecurityHeaders,
+		requestid.Middleware,
+		s.recoverPanics,
+		func(next http.Handler) http.Handler { return tenant.Middleware(s.writer, next) },
+		permissions.Middleware,
+		loggingMiddleware,
+	}
+}
+
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestid.Logf(r.Context(), "%s %s %s", r.Method, r.RequestURI, clientip.FromRequest(r))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverPanics answers requests whose handler panicked with a 500 written by
+// the ErrorHandler, and logs the stack. If the response was already started,
+// it can only be cut short. http.ErrAbortHandler is passed on, since it
+// aborts the response on purpose.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoveryWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			requestid.Logf(r.Context(), "Recovered from panic: %v\n%s", v, debug.Stack())
+			if rw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			s.errHandler.HandleInternalError(w, r, fmt.Errorf("panic: %v", v), requestid.FromContext(r.Context()))
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// recoveryWriter records whether the response was started
+type recoveryWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoveryWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recoveryWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush supports streamed responses
+func (w *recoveryWriter) Flush() {
+	w.wroteHeader = true
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *recoveryWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"slices"
+	"testing"
+)
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	record := func(name string) middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	base := chain{record("outer")}
+	routes := group(chain{record("group")}, route{use: chain{record("route")}})
+	handler := base.with(routes[0].use...).then(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		calls = append(calls, "handler")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := []string{"outer", "group", "route", "handler"}; !slices.Equal(calls, want) {
+		t.Errorf("Expected %v, got %v", want, calls)
+	}
+	if len(base) != 1 {
+		t.Errorf("Expected with to leave the chain unchanged, got %d middleware", len(base))
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+	handler := server.middleware().then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/documents", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusInternalServerError, w.Code, w.Body.String())
+	}
+	var response struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Request string `json:"request"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Expected a JSON error, got %s", w.Body.String())
+	}
+	if response.Error.Code != http.StatusInternalServerError || response.Error.Request == "" || response.Error.Request != w.Header().Get(requestid.Header) {
+		t.Errorf("Expected the 500 to name the request, got %+v", response.Error)
+	}
+
+	// A started response cannot be replaced and is aborted
+	started := server.recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic("boom")
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("Expected the response to be aborted, got %v", v)
+		}
+	}()
+	started.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
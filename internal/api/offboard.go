@@ -0,0 +1,80 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"strconv"
+
+	"github.com/ory/herodot"
+)
+
+// offboardUser removes every relation the user holds directly, including
+// group memberships, and logs each revocation to the audit log. With
+// ?invalidate_cache=true the user's cached decisions are dropped too, so the
+// revocation takes effect on this replica at once instead of when they
+// expire. It requires the write relation on the corpus, like
+// changePermission.
+func (s *Server) offboardUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	remover, ok := s.permService.(permissions.UserRelationRemover)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot remove relations"))
+		return
+	}
+
+	user := r.PathValue("username")
+	if user == "" {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Username is required"))
+		return
+	}
+	invalidate := false
+	if v := r.URL.Query().Get("invalidate_cache"); v != "" {
+		var err error
+		if invalidate, err = strconv.ParseBool(v); err != nil {
+			s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid invalidate_cache parameter"))
+			return
+		}
+	}
+
+	removed, err := remover.RemoveUserRelations(r.Context(), user)
+	for _, rel := range removed {
+		requestid.Logf(r.Context(), "AUDIT permission revoked: user=%q relation=%s object=%s namespace=%s by=%q client_ip=%s",
+			user, rel.Relation, rel.Object, rel.Namespace, principal.Username, clientip.FromContext(r.Context()))
+	}
+	// Relations removed before a failure are revoked too
+	invalidated := false
+	if cache, ok := s.permService.(interface{ InvalidateUser(string) }); ok && invalidate {
+		cache.InvalidateUser(user)
+		invalidated = true
+	}
+	if err != nil {
+		if errors.Is(err, permissions.ErrChangesUnsupported) {
+			s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot remove relations"))
+			return
+		}
+		s.writer.WriteError(w, r, upstreamError(err, fmt.Sprintf("Failed to remove all relations; %d were removed before the failure", len(removed))))
+		return
+	}
+
+	response := &models.UserOffboardResponse{
+		User:             user,
+		Removed:          make([]models.RemovedRelation, len(removed)),
+		CacheInvalidated: invalidated,
+		Message:          fmt.Sprintf("Removed %d relations", len(removed)),
+	}
+	for i, rel := range removed {
+		response.Removed[i] = models.RemovedRelation{Namespace: rel.Namespace, Object: rel.Object, Relation: rel.Relation}
+	}
+	requestid.Logf(r.Context(), "User %s offboarded %s: %d relations removed", principal.Username, user, len(removed))
+	s.writer.Write(w, r, response)
+}
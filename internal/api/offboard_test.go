@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// offboardPermissionService removes a user's relations and memberships from
+// the mock and records invalidated users
+type offboardPermissionService struct {
+	*MockPermissionService
+	invalidated []string
+	err         error
+}
+
+func (o *offboardPermissionService) RemoveUserRelations(_ context.Context, username string) ([]permissions.UserRelation, error) {
+	var removed []permissions.UserRelation
+	for _, t := range o.tuples[username] {
+		removed = append(removed, permissions.UserRelation{Namespace: "documents", Object: t.Object(), Relation: t.Relation})
+	}
+	delete(o.tuples, username)
+	for group, members := range o.members {
+		if slices.Contains(members, username) {
+			removed = append(removed, permissions.UserRelation{Namespace: "groups", Object: group, Relation: permissions.RelationMember})
+			_ = o.RemoveMember(context.Background(), group, username)
+		}
+	}
+	return removed, o.err
+}
+
+func (o *offboardPermissionService) InvalidateUser(username string) {
+	o.invalidated = append(o.invalidated, username)
+}
+
+func offboard(server *Server, username, target, query string) *httptest.ResponseRecorder {
+	return serveAs(server.GetHandler(), http.MethodDelete, "/admin/users/"+target+"/permissions"+query, nil, username)
+}
+
+func TestOffboardUser(t *testing.T) {
+	permService := &offboardPermissionService{MockPermissionService: NewMockPermissionService()}
+	docID := uuid.New()
+	permService.tuples = map[string][]permissions.Tuple{
+		"alice": {{Subject: "alice", Relation: permissions.RelationViewer, DocumentID: docID}},
+	}
+	permService.members = map[string][]string{"payroll": {"alice", "bob"}}
+	server := newTestServer(NewMockEmbedder(), NewMockVectorStore(), NewMockLLMClient(), permService)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	w := offboard(server, adminUsername, "alice", "?invalidate_cache=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response models.UserOffboardResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.User != "alice" || len(response.Removed) != 2 || !response.CacheInvalidated {
+		t.Errorf("Unexpected response %+v", response)
+	}
+	if !slices.Equal(permService.invalidated, []string{"alice"}) {
+		t.Errorf("Expected alice's cached decisions to be dropped, got %v", permService.invalidated)
+	}
+	if !slices.Equal(permService.members["payroll"], []string{"bob"}) {
+		t.Errorf("Expected only alice to leave the group, got %v", permService.members["payroll"])
+	}
+	for _, want := range []string{
+		`AUDIT permission revoked: user="alice" relation=viewer object=` + docID.String(),
+		`AUDIT permission revoked: user="alice" relation=member object=payroll namespace=groups by="` + adminUsername + `"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Expected the audit log to contain %q, got:\n%s", want, logs.String())
+		}
+	}
+
+	// Without the parameter, cached decisions are left to expire
+	if w := offboard(server, adminUsername, "bob", ""); w.Code != http.StatusOK || len(permService.invalidated) != 1 {
+		t.Errorf("Expected bob's cached decisions to be kept, got %d: %v", w.Code, permService.invalidated)
+	}
+}
+
+func TestOffboardUserErrors(t *testing.T) {
+	permService := &offboardPermissionService{MockPermissionService: NewMockPermissionService()}
+	permService.SetCanWrite("bob", false)
+	server := newTestServer(NewMockEmbedder(), NewMockVectorStore(), NewMockLLMClient(), permService)
+	plain, _, _, _, _ := createTestServer()
+
+	tests := []struct {
+		name     string
+		server   *Server
+		username string
+		query    string
+		want     int
+	}{
+		{name: "not a writer", server: server, username: "bob", want: http.StatusForbidden},
+		{name: "invalid invalidate_cache", server: server, username: adminUsername, query: "?invalidate_cache=maybe", want: http.StatusBadRequest},
+		{name: "unsupported backend", server: plain, username: adminUsername, want: http.StatusNotImplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := offboard(tt.server, tt.username, "alice", tt.query); w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	permService.err = errors.New("keto returned status 500")
+	if w := offboard(server, adminUsername, "alice", "?invalidate_cache=true"); w.Code != http.StatusInternalServerError || len(permService.invalidated) != 1 {
+		t.Errorf("Expected a failed removal to be reported, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/policy"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"strconv"
+
+	"github.com/ory/herodot"
+)
+
+// maxPolicySize bounds the size of an uploaded policy file
+const maxPolicySize = 1 << 20
+
+// applyPolicy reconciles a YAML permission policy sent as the request body
+// into Keto for the request's tenant. With ?dry_run=true it only reports the
+// planned changes. It requires the write relation on the corpus, like
+// changePermission.
+func (s *Server) applyPolicy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	manager, ok := s.permService.(permissions.PermissionManager)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot change relations"))
+		return
+	}
+
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid dry_run parameter"))
+			return
+		}
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPolicySize))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Failed to read the policy").WithError(err.Error()))
+		return
+	}
+	p, err := policy.Parse(data)
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid policy").WithError(err.Error()))
+		return
+	}
+
+	plan, err := policy.NewPlan(r.Context(), p, s.store(r.Context()), manager)
+	if err != nil {
+		if errors.Is(err, permissions.ErrChangesUnsupported) {
+			s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot change relations"))
+			return
+		}
+		s.writer.WriteError(w, r, upstreamError(err, "Failed to plan the policy changes"))
+		return
+	}
+
+	response := &models.PolicyResponse{
+		Granted: relationTuples(plan.Grant),
+		Revoked: relationTuples(plan.Revoke),
+		DryRun:  dryRun,
+		Message: "Policy applied successfully",
+	}
+	if dryRun {
+		response.Message = "Policy changes planned"
+		s.writer.Write(w, r, response)
+		return
+	}
+
+	err = plan.Apply(r.Context(), manager, func(t permissions.Tuple, granted bool) {
+		event := webhooks.PermissionRevoked
+		if granted {
+			event = webhooks.PermissionGranted
+		}
+		s.notify(r.Context(), event, webhooks.PermissionData{
+			Subject:  t.Subject,
+			Relation: t.Relation,
+			Object:   t.Object(),
+		})
+	})
+	if err != nil {
+		s.writer.WriteError(w, r, upstreamError(err, "Failed to apply the policy; changes made before the failure remain"))
+		return
+	}
+	requestid.Logf(r.Context(), "User %s applied a permission policy: %d granted, %d revoked", principal.Username, len(plan.Grant), len(plan.Revoke))
+	s.writer.Write(w, r, response)
+}
+
+func relationTuples(tuples []permissions.Tuple) []models.RelationTuple {
+	out := make([]models.RelationTuple, len(tuples))
+	for i, t := range tuples {
+		out[i] = models.RelationTuple{User: t.Subject, Group: t.Group, Relation: t.Relation, Object: t.Object()}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const testPolicy = `
+users: [alice, bob]
+groups:
+  advisors: [alice, bob]
+relations:
+  - subjects: [group:advisors]
+    relation: viewer
+    taxpayers: [John Doe]
+prune: true
+`
+
+func TestApplyPolicy(t *testing.T) {
+	server, _, vectorStore, _, permService := createTestServer()
+	notifier := &recordingNotifier{}
+	WithWebhooks(notifier)(server)
+
+	johnReturn := &models.Document{ID: uuid.New(), Title: "Return", Metadata: map[string]interface{}{"taxpayer": "John Doe"}}
+	_ = vectorStore.AddDocument(johnReturn)
+	_ = vectorStore.AddDocument(&models.Document{ID: uuid.New(), Title: "Other", Metadata: map[string]interface{}{"taxpayer": "Jane Smith"}})
+
+	stale := permissions.Tuple{Subject: "bob", Relation: permissions.RelationWrite}
+	permService.tuples = map[string][]permissions.Tuple{
+		"alice": {{Subject: "alice", Relation: permissions.RelationViewer, DocumentID: johnReturn.ID}},
+		"bob":   {stale},
+	}
+
+	apply := func(url string) (*httptest.ResponseRecorder, models.PolicyResponse) {
+		req := createAuthenticatedRequest(http.MethodPut, url, []byte(testPolicy), adminUsername)
+		w := httptest.NewRecorder()
+		server.applyPolicy(w, req)
+		var resp models.PolicyResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := apply("/permissions/policy?dry_run=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !resp.DryRun || len(permService.granted) != 0 || len(permService.revoked) != 0 {
+		t.Fatalf("Expected a dry run without changes, got %+v", resp)
+	}
+
+	w, resp = apply("/permissions/policy")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	wantGranted := []permissions.Tuple{{Subject: "bob", Relation: permissions.RelationViewer, DocumentID: johnReturn.ID}}
+	if !slices.Equal(permService.granted, wantGranted) || !slices.Equal(permService.revoked, []permissions.Tuple{stale}) {
+		t.Errorf("Unexpected changes: granted %v, revoked %v", permService.granted, permService.revoked)
+	}
+	if len(resp.Granted) != 1 || len(resp.Revoked) != 1 || resp.Revoked[0].Object != permissions.CorpusObject {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+	if !slices.Equal(notifier.events, []webhooks.EventType{webhooks.PermissionGranted, webhooks.PermissionRevoked}) {
+		t.Errorf("Expected granted and revoked events, got %v", notifier.events)
+	}
+}
+
+func TestApplyPolicyErrors(t *testing.T) {
+	server, _, _, _, permService := createTestServer()
+	permService.SetCanWrite("bob", false)
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		body     string
+		username string
+		want     int
+	}{
+		{name: "not a writer", method: http.MethodPut, url: "/permissions/policy", body: testPolicy, username: "bob", want: http.StatusForbidden},
+		{name: "invalid policy", method: http.MethodPut, url: "/permissions/policy", body: "users: [alice]\nrelations:\n  - subjects: [mallory]\n    relation: write", username: adminUsername, want: http.StatusBadRequest},
+		{name: "invalid dry run", method: http.MethodPut, url: "/permissions/policy?dry_run=maybe", body: testPolicy, username: adminUsername, want: http.StatusBadRequest},
+		{name: "wrong method", method: http.MethodPost, url: "/permissions/policy", body: testPolicy, username: adminUsername, want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(server.GetHandler(), tt.method, tt.url, []byte(tt.body), tt.username)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+	if len(permService.granted) != 0 || len(permService.revoked) != 0 {
+		t.Errorf("Expected no changes, got granted %v, revoked %v", permService.granted, permService.revoked)
+	}
+}
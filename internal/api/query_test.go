@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"rerag-rbac-rag-llm/internal/models"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -216,6 +217,40 @@ func setupPeterPermissions(permService *MockPermissionService, docIDs ...string)
 	}
 }
 
+func TestQueryValidatesQuestion(t *testing.T) {
+	server, embedder, vectorStore, llmClient, permService := createTestServer()
+	WithMaxQuestionLength(50)(server)
+
+	johnDoeDoc := setupJohnDoeDocument(vectorStore)
+	setupAlicePermissions(permService, johnDoeDoc.ID.String())
+	// The question is embedded and answered after normalization
+	question := "What was John Doe's refund?"
+	embedder.SetEmbedding(question, []float32{0.1, 0.2, 0.3})
+	llmClient.SetResponse(question, "John Doe's refund amount in 2023 was $2,500")
+	if response := executeQuery(t, server, "  What was John Doe's\x00 refund?\r ", "alice"); response.Answer != "John Doe's refund amount in 2023 was $2,500" {
+		t.Errorf("Expected the normalized question to be answered, got %q", response.Answer)
+	}
+
+	for name, question := range map[string]string{
+		"empty":    "   ",
+		"too long": strings.Repeat("refund ", 10),
+	} {
+		body, _ := json.Marshal(models.QueryRequest{Question: question})
+		w := httptest.NewRecorder()
+		server.queryDocuments(w, createAuthenticatedRequest(http.MethodPost, "/query", body, "alice"))
+		var resp struct {
+			Error struct {
+				Reason  string            `json:"reason"`
+				Details map[string]string `json:"details"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusBadRequest || resp.Error.Reason != "Invalid question" || resp.Error.Details["field"] != "question" {
+			t.Errorf("%s: expected a 400 naming the question field, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+}
+
 func executeQuery(t *testing.T, server *Server, question, username string) models.QueryResponse {
 	query := models.QueryRequest{
 		Question: question,
@@ -285,7 +320,7 @@ func TestUserPermissions(t *testing.T) {
 			req := createAuthenticatedRequest(http.MethodGet, "/permissions", nil, tt.username)
 			w := httptest.NewRecorder()
 
-			server.handlePermissions(w, req)
+			server.listPermissions(w, req)
 
 			if w.Code != tt.wantCode {
 				t.Errorf("Expected status %d, got %d", tt.wantCode, w.Code)
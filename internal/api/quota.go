@@ -0,0 +1,91 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/quota"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+
+	"github.com/ory/herodot"
+)
+
+// Errors of writes exceeding a quota
+var (
+	errDocumentQuota = herodot.DefaultError{
+		StatusField: http.StatusText(http.StatusTooManyRequests),
+		ErrorField:  "The document quota is exhausted",
+		CodeField:   http.StatusTooManyRequests,
+	}
+	errContentQuota = herodot.DefaultError{
+		StatusField: http.StatusText(http.StatusRequestEntityTooLarge),
+		ErrorField:  "The content quota is exhausted",
+		CodeField:   http.StatusRequestEntityTooLarge,
+	}
+)
+
+// quotaError maps a write exceeding a quota to an API error. Writes over a
+// document limit get a 429 and writes over a content limit a 413.
+func quotaError(exceeded *quota.ExceededError) error {
+	if errors.Is(exceeded, quota.ErrDocumentLimit) {
+		return errDocumentQuota.WithReasonf("The %s may store at most %d documents", exceeded.Scope, exceeded.Limit).WithError(exceeded.Error())
+	}
+	return errContentQuota.WithReasonf("The %s may store at most %d bytes of content", exceeded.Scope, exceeded.Limit).WithError(exceeded.Error())
+}
+
+// getUsage reports the documents and content bytes the user has ingested in
+// the request's tenant with the configured limits. The tenant's total is
+// only included for users with the write relation on the corpus.
+func (s *Server) getUsage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	counter, ok := s.store(r.Context()).(storage.UsageCounter)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The document store cannot measure usage"))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	own, err := counter.Usage(map[string]string{quota.MetadataCreatedBy: principal.Username})
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to measure usage").WithError(err.Error()))
+		return
+	}
+	response := &models.UsageResponse{User: principal.Username, Usage: usageOf(own)}
+	if s.quotas != nil {
+		tenantLimits, userLimits := s.quotas.Limits()
+		response.Limits = limitsOf(userLimits)
+		response.TenantLimits = limitsOf(tenantLimits)
+	}
+	if s.meter != nil {
+		queries := s.meter.Totals(tenant.FromContext(r.Context()), principal.Username)
+		response.Queries = &queries
+	}
+
+	if s.permService.CanWriteDocuments(r.Context(), principal.Principal) {
+		tenant, err := counter.Usage(nil)
+		if err != nil {
+			s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to measure usage").WithError(err.Error()))
+			return
+		}
+		tenantUsage := usageOf(tenant)
+		response.TenantUsage = &tenantUsage
+	}
+	s.writer.Write(w, r, response)
+}
+
+func usageOf(u storage.Usage) models.Usage {
+	return models.Usage{Documents: u.Documents, ContentBytes: u.ContentBytes}
+}
+
+// limitsOf converts limits for a response; nil if l is unlimited
+func limitsOf(l quota.Limits) *models.QuotaLimits {
+	if l == (quota.Limits{}) {
+		return nil
+	}
+	return &models.QuotaLimits{MaxDocuments: l.MaxDocuments, MaxContentBytes: l.MaxContentBytes}
+}
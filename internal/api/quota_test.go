@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/quota"
+	"rerag-rbac-rag-llm/internal/storage"
+	"strings"
+	"testing"
+)
+
+func TestIngestionQuotas(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, _ := storage.NewInMemoryVectorStore("")
+	server := newTestServer(embedder, store, llmClient, permService)
+	WithIngestion(100, 0, defaultUploadLimit)(server)
+	WithQuotas(quota.NewEnforcer(quota.Limits{MaxContentBytes: 250}, quota.Limits{MaxDocuments: 2}))(server)
+
+	add := func(username, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.addDocument(w, createAuthenticatedRequest(http.MethodPost, "/documents", []byte(body), username))
+		return w
+	}
+
+	// The client cannot attribute documents to someone else
+	if w := add(adminUsername, `{"title": "Return", "content": "Refund", "metadata": {"created_by": "alice"}}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := add(adminUsername, `{"title": "Invoice", "content": "Total"}`); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := add(adminUsername, `{"title": "Receipt", "content": "Paid"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d over the user's document quota, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	// Another user has room for documents, but the tenant has not for the chunks
+	w := httptest.NewRecorder()
+	server.uploadDocument(w, createUploadRequest(t, "policy.txt", "text/plain", strings.Repeat("Refunds take ten days. ", 12), "", "alice"))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d over the tenant's content quota, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+
+	usage := func(username string) models.UsageResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.getUsage(w, createAuthenticatedRequest(http.MethodGet, "/usage", nil, username))
+		var response models.UsageResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+	admin := usage(adminUsername)
+	if admin.Usage != (models.Usage{Documents: 2, ContentBytes: 11}) || admin.TenantUsage == nil || *admin.TenantUsage != admin.Usage {
+		t.Errorf("Unexpected usage %+v", admin)
+	}
+	if admin.Limits == nil || admin.Limits.MaxDocuments != 2 || admin.TenantLimits == nil || admin.TenantLimits.MaxContentBytes != 250 {
+		t.Errorf("Expected the configured limits, got %+v and %+v", admin.Limits, admin.TenantLimits)
+	}
+	permService.SetCanWrite("alice", false)
+	if alice := usage("alice"); alice.Usage.Documents != 0 || alice.TenantUsage != nil {
+		t.Errorf("Expected only alice's own usage, got %+v", alice)
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/eval"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/ory/herodot"
+)
+
+const (
+	// maxRedTeamTargets bounds the forbidden documents of a run, since each adds several queries
+	maxRedTeamTargets = 20
+	// maxRedTeamQuestions bounds the additional questions of a run
+	maxRedTeamQuestions = 50
+)
+
+// redTeamTarget is a forbidden document with the terms that reveal it
+type redTeamTarget struct {
+	id    string
+	terms []string
+}
+
+// redTeam runs adversarial queries as another user and reports whether any
+// of the documents that user must not see shows up among the sources or in
+// the answers. The queries go through the regular retrieval and generation
+// path with the user's permissions. It requires the write relation on the
+// corpus, since the report reveals what the forbidden documents contain.
+func (s *Server) redTeam(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req models.RedTeamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	query := models.QueryRequest{TopK: req.TopK, SearchMode: models.SearchModeHybrid}
+	if err := validateRedTeam(&req, &query); err != nil {
+		s.writer.WriteError(w, r, err)
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	requestID := requestid.FromContext(r.Context())
+	store := s.store(r.Context())
+	docs := make([]models.Document, 0, len(req.ForbiddenDocumentIDs))
+	for _, id := range req.ForbiddenDocumentIDs {
+		doc, err := store.GetDocument(uuid.MustParse(id))
+		if errors.Is(err, storage.ErrDocumentNotFound) {
+			s.errHandler.HandleNotFoundError(w, r, "document "+id, requestID)
+			return
+		}
+		if err != nil {
+			s.errHandler.HandleDatabaseError(w, r, err, requestID)
+			return
+		}
+		docs = append(docs, *doc)
+	}
+
+	report := &models.RedTeamReport{User: req.User, Results: []models.RedTeamResult{}}
+	for i, allowed := range s.permService.BatchCheck(r.Context(), permissions.Principal{Username: req.User}, docs) {
+		if allowed {
+			report.DirectAccess = append(report.DirectAccess, docs[i].ID.String())
+		}
+	}
+
+	evalTargets := make([]eval.Target, len(docs))
+	targets := make([]redTeamTarget, len(docs))
+	for i, doc := range docs {
+		evalTargets[i] = eval.Target{ID: doc.ID.String(), Title: doc.Title, Content: doc.Content, Metadata: doc.Metadata}
+		targets[i] = redTeamTarget{id: evalTargets[i].ID, terms: eval.SensitiveTerms(evalTargets[i])}
+	}
+	probes := eval.Probes(evalTargets)
+	for _, question := range req.Questions {
+		probes = append(probes, eval.Probe{Technique: "custom", Question: question})
+	}
+
+	for _, probe := range probes {
+		result := s.runProbe(r.Context(), &req, &query, probe, targets)
+		report.Queries++
+		report.Leaks += len(result.Leaks)
+		if result.Error != "" {
+			report.Errors++
+		}
+		report.Results = append(report.Results, result)
+	}
+	report.Passed = report.Leaks == 0 && report.Errors == 0 && len(report.DirectAccess) == 0
+
+	requestid.Logf(r.Context(), "AUDIT red team run: admin=%q user=%q targets=%d queries=%d leaks=%d errors=%d direct_access=%d passed=%t client_ip=%s",
+		principal.Username, req.User, len(targets), report.Queries, report.Leaks, report.Errors, len(report.DirectAccess), report.Passed, clientip.FromRequest(r))
+	s.writer.Write(w, r, report)
+}
+
+// validateRedTeam rejects incomplete or oversized runs and applies the query defaults
+func validateRedTeam(req *models.RedTeamRequest, query *models.QueryRequest) error {
+	if strings.TrimSpace(req.User) == "" {
+		return herodot.ErrBadRequest.WithReason("Invalid request body").WithError("user is required")
+	}
+	if len(req.ForbiddenDocumentIDs) == 0 || len(req.ForbiddenDocumentIDs) > maxRedTeamTargets {
+		return herodot.ErrBadRequest.WithReason("Invalid request body").WithErrorf("between 1 and %d forbidden documents are required", maxRedTeamTargets)
+	}
+	for _, id := range req.ForbiddenDocumentIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error())
+		}
+	}
+	if len(req.Questions) > maxRedTeamQuestions || slices.ContainsFunc(req.Questions, func(q string) bool { return strings.TrimSpace(q) == "" }) {
+		return herodot.ErrBadRequest.WithReason("Invalid request body").WithErrorf("at most %d non-empty questions are allowed", maxRedTeamQuestions)
+	}
+	return validateQueryOptions(query)
+}
+
+// runProbe asks a single adversarial question as the run's user and checks
+// the response for the targets. Terms that also appear in the question or in
+// a retrieved document the user may see are not counted as leaks.
+func (s *Server) runProbe(ctx context.Context, req *models.RedTeamRequest, query *models.QueryRequest, probe eval.Probe, targets []redTeamTarget) models.RedTeamResult {
+	result := models.RedTeamResult{Technique: probe.Technique, Question: probe.Question, Sources: []string{}}
+
+	docs, _, err := s.rag.Retrieve(ctx, permissions.Principal{Username: req.User}, query, probe.Question)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp := &eval.Response{Sources: make([]eval.Source, len(docs))}
+	for i, doc := range docs {
+		resp.Sources[i] = eval.Source{ID: doc.ID.String()}
+		result.Sources = append(result.Sources, resp.Sources[i].ID)
+	}
+
+	switch {
+	case req.RetrievalOnly:
+	case s.rag.Unanswerable(docs):
+		result.Answer = models.NoAccessibleDocumentsAnswer
+	default:
+		generated, err := s.rag.Generate(ctx, probe.Question, docs, llm.Options{})
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Answer = generated.Answer
+		}
+	}
+	resp.Answer = result.Answer
+
+	forbidden := make([]string, len(targets))
+	for i, t := range targets {
+		forbidden[i] = t.id
+	}
+	shown := probe.Question
+	for _, doc := range docs {
+		if !slices.Contains(forbidden, doc.ID.String()) {
+			shown += "\n" + doc.Title + "\n" + doc.Content
+		}
+	}
+
+	for _, t := range targets {
+		terms := slices.DeleteFunc(slices.Clone(t.terms), func(term string) bool {
+			return strings.Contains(strings.ToLower(shown), strings.ToLower(term))
+		})
+		for _, leak := range eval.FindLeaks(resp, []string{t.id}, terms) {
+			result.Leaks = append(result.Leaks, models.RedTeamLeak{DocumentID: t.id, Term: leak.Term, Where: leak.Where})
+		}
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRedTeamReportsLeaks(t *testing.T) {
+	server, _, vectorStore, llmClient, permService := createTestServer()
+	johnDoe := &models.Document{ID: uuid.New(), Title: "Tax Return 2023 - John Doe", Content: "Refund Amount: $1,200", Metadata: map[string]interface{}{"taxpayer": "John Doe"}}
+	abcCorp := &models.Document{ID: uuid.New(), Title: "Tax Return 2023 - ABC Corporation", Content: "Gross Receipts: $2,500,000\nRefund Due: $1,200", Metadata: map[string]interface{}{"taxpayer": "ABC Corporation"}}
+	_ = vectorStore.AddDocument(johnDoe)
+	_ = vectorStore.AddDocument(abcCorp)
+	permService.SetDocumentAccess("alice", abcCorp.ID.String(), false)
+
+	// The title is part of the question and $1,200 is in John Doe's return, so
+	// only the gross receipts give ABC Corporation's return away
+	llmClient.SetResponse(`What does the document "Tax Return 2023 - ABC Corporation" say?`, "Tax Return 2023 - ABC Corporation lists $2,500,000 in gross receipts and a $1,200 refund.")
+
+	run := func(body string) models.RedTeamReport {
+		t.Helper()
+		w := httptest.NewRecorder()
+		server.redTeam(w, createAuthenticatedRequest(http.MethodPost, "/permissions/redteam", []byte(body), adminUsername))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var report models.RedTeamReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return report
+	}
+
+	body := `{"user": "alice", "forbidden_document_ids": ["` + abcCorp.ID.String() + `"], "questions": ["Who filed the largest return?"]}`
+	report := run(body)
+	if report.Passed || report.Leaks != 1 || report.Errors != 0 || len(report.DirectAccess) != 0 {
+		t.Fatalf("Expected a single leak, got %+v", report)
+	}
+	for _, result := range report.Results {
+		for _, id := range result.Sources {
+			if id == abcCorp.ID.String() {
+				t.Errorf("Expected the forbidden document to be filtered from %q", result.Question)
+			}
+		}
+		if len(result.Leaks) > 0 && (result.Technique != "title-lookup" || result.Leaks[0] != (models.RedTeamLeak{DocumentID: abcCorp.ID.String(), Term: "$2,500,000", Where: "answer"})) {
+			t.Errorf("Unexpected leak %+v for %q", result.Leaks, result.Question)
+		}
+	}
+	if last := report.Results[len(report.Results)-1]; last.Technique != "custom" || report.Queries != len(report.Results) {
+		t.Errorf("Expected the custom question to run last, got %+v", last)
+	}
+
+	// A user who may read the document sees it among the sources of every query;
+	// the first run generated an answer for each of its queries, one more than here
+	report = run(`{"user": "bob", "forbidden_document_ids": ["` + abcCorp.ID.String() + `"], "retrieval_only": true}`)
+	if report.Passed || len(report.DirectAccess) != 1 || report.Leaks != report.Queries {
+		t.Errorf("Expected direct access and a leak per query, got %+v", report)
+	}
+	if llmClient.calls != len(report.Results)+1 {
+		t.Errorf("Expected no generation for a retrieval-only run, got %d calls", llmClient.calls)
+	}
+}
+
+func TestRedTeamRejectsInvalidRuns(t *testing.T) {
+	server, _, vectorStore, _, permService := createTestServer()
+	doc := &models.Document{ID: uuid.New(), Title: "Return"}
+	_ = vectorStore.AddDocument(doc)
+	permService.SetCanWrite("alice", false)
+
+	for name, tc := range map[string]struct {
+		user, body string
+		status     int
+	}{
+		"non-admin":      {"alice", `{"user": "bob", "forbidden_document_ids": ["` + doc.ID.String() + `"]}`, http.StatusForbidden},
+		"no user":        {adminUsername, `{"forbidden_document_ids": ["` + doc.ID.String() + `"]}`, http.StatusBadRequest},
+		"no documents":   {adminUsername, `{"user": "bob"}`, http.StatusBadRequest},
+		"invalid ID":     {adminUsername, `{"user": "bob", "forbidden_document_ids": ["doc-1"]}`, http.StatusBadRequest},
+		"empty question": {adminUsername, `{"user": "bob", "forbidden_document_ids": ["` + doc.ID.String() + `"], "questions": [" "]}`, http.StatusBadRequest},
+		"unknown ID":     {adminUsername, `{"user": "bob", "forbidden_document_ids": ["` + uuid.NewString() + `"]}`, http.StatusNotFound},
+	} {
+		w := serveAs(server.GetHandler(), http.MethodPost, "/permissions/redteam", []byte(tc.body), tc.user)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tc.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"sync"
+	"time"
+
+	"github.com/ory/herodot"
+)
+
+// reindexJob tracks the running or last reindex job. The zero value is idle.
+type reindexJob struct {
+	mu     sync.Mutex
+	status models.ReindexStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// snapshot returns a copy of the job status
+func (j *reindexJob) snapshot() models.ReindexStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	if status.State == "" {
+		status.State = models.ReindexIdle
+	}
+	return status
+}
+
+// reindexStatus reports the progress of the reindex job
+func (s *Server) reindexStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	s.writer.Write(w, r, s.reindex.snapshot())
+}
+
+// startReindex starts re-embedding all documents with the configured model.
+// Reindexing affects every tenant, so it requires the write relation on the
+// default tenant's corpus.
+func (s *Server) startReindex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	reindexer, ok := s.vectorStore.(storage.Reindexer)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The document store cannot reindex documents"))
+		return
+	}
+
+	job := &s.reindex
+	job.mu.Lock()
+	if job.status.State == models.ReindexRunning {
+		job.mu.Unlock()
+		s.writer.WriteError(w, r, herodot.ErrConflict.WithReason("A reindex job is already running"))
+		return
+	}
+	// The job outlives the request but keeps its request ID for logging
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	job.status = models.ReindexStatus{State: models.ReindexRunning, StartedBy: principal.Username, StartedAt: time.Now().UTC()}
+	job.cancel = cancel
+	job.done = make(chan struct{})
+	status := job.status
+	job.mu.Unlock()
+
+	requestid.Logf(r.Context(), "User %s started reindexing all documents", principal.Username)
+	go s.runReindex(ctx, reindexer)
+
+	s.writer.WriteCode(w, r, http.StatusAccepted, status)
+}
+
+// runReindex re-embeds all documents and records the outcome in s.reindex
+func (s *Server) runReindex(ctx context.Context, reindexer storage.Reindexer) {
+	job := &s.reindex
+	defer close(job.done)
+
+	embed := func(ctx context.Context, doc *models.Document) ([]float32, error) {
+		return s.embedder.GetEmbedding(ctx, doc.Content)
+	}
+	progress := func(done, total int) {
+		job.mu.Lock()
+		job.status.Done = done
+		job.status.Total = total
+		job.mu.Unlock()
+	}
+	err := reindexer.Reindex(ctx, embed, progress)
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status.FinishedAt = time.Now().UTC()
+	job.cancel()
+	if err != nil {
+		job.status.State = models.ReindexFailed
+		job.status.Error = err.Error()
+		requestid.Logf(ctx, "Reindexing failed after %d/%d documents: %v", job.status.Done, job.status.Total, err)
+		return
+	}
+	job.status.State = models.ReindexCompleted
+	requestid.Logf(ctx, "Reindexed %d documents", job.status.Total)
+}
+
+// stopReindex cancels a running reindex job and waits for it to stop or until
+// ctx is done. The old vectors stay in place.
+func (s *Server) stopReindex(ctx context.Context) error {
+	job := &s.reindex
+	job.mu.Lock()
+	cancel, done := job.cancel, job.done
+	job.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("reindex job did not stop: %w", ctx.Err())
+	}
+}
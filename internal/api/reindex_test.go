@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+	"testing"
+	"time"
+)
+
+// blockingEmbedder embeds like MockEmbedder once release is closed
+type blockingEmbedder struct {
+	*MockEmbedder
+	release chan struct{}
+}
+
+func (b *blockingEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	select {
+	case <-b.release:
+		return b.MockEmbedder.GetEmbedding(ctx, text)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestReindex(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, _ := storage.NewInMemoryVectorStore("")
+	server := newTestServer(embedder, store, llmClient, permService)
+	blocking := &blockingEmbedder{MockEmbedder: embedder, release: make(chan struct{})}
+	server.embedder = blocking
+
+	doc := &models.Document{Title: "Refunds", Content: "refunds", Embedding: []float32{0.1, 0.2, 0.3}}
+	_ = store.AddDocument(doc)
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Content: "acme", Embedding: []float32{0.4, 0.5, 0.6}})
+	embedder.SetEmbedding("refunds", []float32{1, 0})
+	embedder.SetEmbedding("acme", []float32{0, 1})
+
+	reindex := func(method, user string) (*httptest.ResponseRecorder, models.ReindexStatus) {
+		w := serveAs(server.GetHandler(), method, "/documents/reindex", nil, user)
+		var status models.ReindexStatus
+		_ = json.Unmarshal(w.Body.Bytes(), &status)
+		return w, status
+	}
+
+	if _, status := reindex(http.MethodGet, "admin"); status.State != models.ReindexIdle {
+		t.Errorf("Expected an idle job before the first run, got %+v", status)
+	}
+	w, status := reindex(http.MethodPost, "admin")
+	if w.Code != http.StatusAccepted || status.State != models.ReindexRunning || status.StartedBy != "admin" {
+		t.Fatalf("Expected the job to start, got %d %+v", w.Code, status)
+	}
+	if w, _ := reindex(http.MethodPost, "admin"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 while a job is running, got %d", w.Code)
+	}
+
+	close(blocking.release)
+	select {
+	case <-server.reindex.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reindex job did not finish")
+	}
+	if _, status := reindex(http.MethodGet, "admin"); status.State != models.ReindexCompleted || status.Done != 2 || status.Total != 2 || status.FinishedAt.IsZero() {
+		t.Errorf("Expected the job to complete with 2 documents, got %+v", status)
+	}
+	results, _ := store.SearchSimilarWithFilter([]float32{1, 0}, 1, func(*models.Document) bool { return true })
+	if len(results) != 1 || results[0].ID != doc.ID || results[0].Distance > 1e-6 {
+		t.Errorf("Expected the new embedding to be searchable, got %+v", results)
+	}
+
+	permService.SetCanWrite("bob", false)
+	if w, _ := reindex(http.MethodGet, "bob"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for non-admins, got %d", w.Code)
+	}
+}
+
+func TestReindexFailureAndShutdown(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, _ := storage.NewInMemoryVectorStore("")
+	_ = store.AddDocument(&models.Document{Title: "Refunds", Content: "refunds", Embedding: []float32{0.1}})
+	server := newTestServer(embedder, store, llmClient, permService)
+
+	// Shutdown cancels a running job, which then reports the failure
+	server.embedder = &blockingEmbedder{MockEmbedder: embedder, release: make(chan struct{})}
+	w := httptest.NewRecorder()
+	server.startReindex(w, createAuthenticatedRequest(http.MethodPost, "/documents/reindex", nil, "admin"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected the job to start, got %d", w.Code)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx, nil); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if status := server.reindex.snapshot(); status.State != models.ReindexFailed || status.Error == "" {
+		t.Errorf("Expected the canceled job to fail, got %+v", status)
+	}
+
+	// Stores without reindex support are reported as such
+	server, _, _, _, _ = createTestServer()
+	w = httptest.NewRecorder()
+	server.startReindex(w, createAuthenticatedRequest(http.MethodPost, "/documents/reindex", nil, "admin"))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 for stores without reindex support, got %d", w.Code)
+	}
+}
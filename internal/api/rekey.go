@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"time"
+
+	"github.com/ory/herodot"
+)
+
+// rekeyDatabase re-encrypts the database with a new key while the server
+// keeps serving. The database holds every tenant, so it requires the write
+// relation on the default tenant's corpus like reindexing.
+func (s *Server) rekeyDatabase(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	rekeyer, ok := s.vectorStore.(storage.Rekeyer)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The document store is not encrypted"))
+		return
+	}
+
+	var req models.RekeyDatabaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	if req.Key == "" {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("The new key is required"))
+		return
+	}
+
+	if err := rekeyer.Rekey(r.Context(), req.Key); err != nil {
+		if errors.Is(err, storage.ErrEncryptionUnavailable) {
+			s.writer.WriteError(w, r, errNotImplemented.WithReason("The database is not encrypted"))
+			return
+		}
+		s.errHandler.HandleDatabaseError(w, r, err, requestid.FromContext(r.Context()))
+		return
+	}
+
+	requestid.Logf(r.Context(), "AUDIT database rekeyed: admin=%q client_ip=%s", principal.Username, clientip.FromRequest(r))
+	s.writer.Write(w, r, &models.RekeyDatabaseResponse{
+		RekeyedAt: time.Now().UTC(),
+		Message:   "Database rekeyed; update the configured encryption key before the next restart",
+	})
+}
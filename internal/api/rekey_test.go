@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+	"testing"
+)
+
+// rekeyingStore records the key of the last rekey
+type rekeyingStore struct {
+	*MockVectorStore
+	key string
+}
+
+func (s *rekeyingStore) Rekey(_ context.Context, key string) error {
+	s.key = key
+	return nil
+}
+
+func TestRekeyDatabase(t *testing.T) {
+	_, embedder, vectorStore, llmClient, permService := createTestServer()
+	store := &rekeyingStore{MockVectorStore: vectorStore}
+	server := newTestServer(embedder, store, llmClient, permService)
+	rekey := func(body, user string) *httptest.ResponseRecorder {
+		return serveAs(server.GetHandler(), http.MethodPost, "/admin/database/rekey", []byte(body), user)
+	}
+
+	w := rekey(`{"key": "n3w-key"}`, "admin")
+	var response models.RekeyDatabaseResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &response)
+	if w.Code != http.StatusOK || store.key != "n3w-key" || response.RekeyedAt.IsZero() {
+		t.Fatalf("Expected the database to be rekeyed, got %d %s", w.Code, w.Body.String())
+	}
+	if w := rekey(`{"key": ""}`, "admin"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a key, got %d", w.Code)
+	}
+
+	permService.SetCanWrite("bob", false)
+	if w := rekey(`{"key": "bobs-key"}`, "bob"); w.Code != http.StatusForbidden || store.key != "n3w-key" {
+		t.Errorf("Expected 403 for non-admins, got %d", w.Code)
+	}
+
+	// Unencrypted databases cannot be rekeyed
+	plain, err := storage.NewSQLiteVectorStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = plain.Close() }()
+	server = newTestServer(embedder, plain, llmClient, permService)
+	if w := rekey(`{"key": "n3w-key"}`, "admin"); w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected 501 for an unencrypted database, got %d", w.Code)
+	}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strings"
+)
+
+// requirement is the relation a route's caller must hold before the handler
+// runs
+type requirement int
+
+const (
+	// anyUser routes admit every authenticated caller; the handler or the RAG
+	// service checks access to the documents involved
+	anyUser requirement = iota
+	// corpusWriter routes require the write relation on the request tenant's corpus
+	corpusWriter
+	// serverAdmin routes affect every tenant and require the write relation
+	// on the default tenant's corpus
+	serverAdmin
+)
+
+// route is an endpoint and who may call it
+type route struct {
+	// pattern is the method-qualified ServeMux pattern; GET patterns also
+	// match HEAD requests
+	pattern string
+	handler http.HandlerFunc
+	// public routes are served without credentials
+	public bool
+	// scope is the API key scope admitted besides users; empty admits users only
+	scope string
+	// shareable routes recognize share tokens, which only admit reading the
+	// document in the path
+	shareable bool
+	// require is checked before the handler runs; action completes the
+	// denial "user X is not allowed to ..."
+	require requirement
+	action  string
+	// use wraps the route before authentication, in order
+	use chain
+}
+
+// routes lists the endpoints served with the server's options. Every route is
+// authenticated unless it is public, so a new route cannot be exposed by
+// forgetting to wrap its handler.
+func (s *Server) routes() []route {
+	routes := []route{
+		{pattern: "GET /health", handler: s.healthCheck, public: true},
+		{pattern: "GET /health/live", handler: s.healthCheck, public: true},
+		{pattern: "GET /health/ready", handler: s.readinessCheck, public: true},
+
+		{pattern: "GET /documents", handler: s.listDocuments},
+		// Exports may span tenants; the handler checks each of them
+		{pattern: "GET /documents/export", handler: s.exportDocuments},
+		{pattern: "GET /documents/reindex", handler: s.reindexStatus, require: serverAdmin, action: "reindex documents"},
+		{pattern: "POST /documents/reindex", handler: s.startReindex, require: serverAdmin, action: "reindex documents"},
+		{pattern: "GET /documents/stats", handler: s.getDocumentStats},
+		{pattern: "GET /documents/trash", handler: s.listTrash, require: corpusWriter, action: "list deleted documents"},
+		{pattern: "GET /documents/{id}", handler: s.getDocument, shareable: true},
+		{pattern: "PUT /documents/{id}", handler: s.updateDocument, shareable: true},
+		{pattern: "DELETE /documents/{id}", handler: s.deleteDocument, shareable: true},
+		{pattern: "POST /documents/{id}/restore", handler: s.restoreDocument},
+		{pattern: "GET /documents/{id}/access", handler: s.getDocumentAccess},
+		{pattern: "GET /documents/{id}/permissions", handler: s.listDocumentPermissions},
+
+		{pattern: "POST /query", handler: s.queryDocuments, scope: models.APIKeyScopeQuery},
+		{pattern: "POST /compare", handler: s.compareDocuments, scope: models.APIKeyScopeQuery},
+		{pattern: "GET /usage", handler: s.getUsage},
+
+		{pattern: "GET /permissions", handler: s.listPermissions},
+		{pattern: "POST /permissions", handler: s.grantPermission, require: corpusWriter, action: "change permissions"},
+		{pattern: "DELETE /permissions", handler: s.revokePermission, require: corpusWriter, action: "change permissions"},
+		{pattern: "POST /permissions/bulk", handler: s.bulkGrant, require: corpusWriter, action: "change permissions"},
+		{pattern: "PUT /permissions/policy", handler: s.applyPolicy, require: corpusWriter, action: "change permissions"},
+		{pattern: "POST /permissions/redteam", handler: s.redTeam, require: corpusWriter, action: "run red team queries"},
+		{pattern: "GET /groups/{group}", handler: s.getGroup, require: corpusWriter, action: "manage groups"},
+		{pattern: "PUT /groups/{group}/members/{user}", handler: s.addGroupMember, require: corpusWriter, action: "manage groups"},
+		{pattern: "DELETE /groups/{group}/members/{user}", handler: s.removeGroupMember, require: corpusWriter, action: "manage groups"},
+
+		{pattern: "GET /admin/stats", handler: s.getStats, require: corpusWriter, action: "view statistics"},
+		{pattern: "DELETE /admin/users/{username}/permissions", handler: s.offboardUser, require: corpusWriter, action: "change permissions"},
+		{pattern: "POST /admin/database/rekey", handler: s.rekeyDatabase, require: serverAdmin, action: "rekey the database"},
+	}
+
+	// With WithClientCertificates, ingestion also requires a verified client
+	// certificate
+	routes = append(routes, group(chain{s.requireClientCertificate},
+		route{pattern: "POST /documents", handler: s.addDocument, scope: models.APIKeyScopeIngest, require: corpusWriter, action: "write documents"},
+		route{pattern: "POST /documents/upload", handler: s.uploadDocument, scope: models.APIKeyScopeIngest, require: corpusWriter, action: "write documents"},
+	)...)
+	if s.meter != nil || s.crawlMetrics != nil {
+		routes = append(routes, route{pattern: "GET /metrics", handler: s.getMetrics, require: corpusWriter, action: "read metrics"})
+	}
+	if s.conversations != nil {
+		routes = append(routes,
+			route{pattern: "POST /conversations", handler: s.createConversation},
+			route{pattern: "POST /conversations/{id}/messages", handler: s.postMessage},
+		)
+	}
+	if s.config != nil {
+		routes = append(routes, route{pattern: "GET /admin/config", handler: s.getConfig, require: serverAdmin, action: "view the configuration"})
+	}
+	if s.apiKeys != nil {
+		routes = append(routes,
+			route{pattern: "GET /api-keys", handler: s.listAPIKeys, require: corpusWriter, action: "manage API keys"},
+			route{pattern: "POST /api-keys", handler: s.createAPIKey, require: corpusWriter, action: "manage API keys"},
+			route{pattern: "DELETE /api-keys/{id}", handler: s.revokeAPIKey, require: corpusWriter, action: "manage API keys"},
+		)
+	}
+	if s.directory != nil {
+		routes = append(routes,
+			route{pattern: "GET /admin/users", handler: s.listDirectoryUsers, require: corpusWriter, action: "manage users"},
+			route{pattern: "GET /admin/users/{username}", handler: s.getDirectoryUser, require: corpusWriter, action: "manage users"},
+			route{pattern: "PUT /admin/users/{username}", handler: s.putDirectoryUser, require: corpusWriter, action: "manage users"},
+			route{pattern: "DELETE /admin/users/{username}", handler: s.deleteDirectoryUser, require: corpusWriter, action: "manage users"},
+			route{pattern: "GET /admin/groups", handler: s.listDirectoryGroups, require: corpusWriter, action: "manage users"},
+			route{pattern: "GET /admin/groups/{group}", handler: s.getDirectoryGroup, require: corpusWriter, action: "manage users"},
+			route{pattern: "PUT /admin/groups/{group}", handler: s.putDirectoryGroup, require: corpusWriter, action: "manage users"},
+			route{pattern: "DELETE /admin/groups/{group}", handler: s.deleteDirectoryGroup, require: corpusWriter, action: "manage users"},
+		)
+	}
+	if s.shareLinks != nil {
+		routes = append(routes, route{pattern: "POST /documents/{id}/share", handler: s.createShareLink})
+	}
+	if s.originals != nil {
+		routes = append(routes, route{pattern: "GET /documents/{id}/original", handler: s.getOriginal})
+	}
+	return routes
+}
+
+func (s *Server) setupRoutes() {
+	for _, rt := range s.routes() {
+		s.mux.Handle(rt.pattern, s.guard(rt))
+		if method, _, ok := strings.Cut(rt.pattern, " "); ok && !slices.Contains(s.methods, method) {
+			s.methods = append(s.methods, method)
+		}
+	}
+}
+
+// guard wraps the route's handler in its middleware, authentication, and
+// authorization
+func (s *Server) guard(rt route) http.Handler {
+	if rt.public {
+		return rt.use.then(rt.handler)
+	}
+	var h http.Handler = rt.handler
+	if rt.require != anyUser {
+		h = s.authorize(rt, h)
+	}
+	handler := s.authenticate(rt.scope, h)
+	if rt.shareable && s.shareLinks != nil {
+		handler = auth.ShareTokenMiddleware(s.shareSigner, s.writer, handler, h)
+	}
+	return rt.use.then(handler)
+}
+
+// authorize checks the route's requirement before calling next
+func (s *Server) authorize(rt route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := s.principal(w, r)
+		if !ok {
+			return
+		}
+		var allowed bool
+		if rt.require == serverAdmin {
+			allowed = s.isServerAdmin(r.Context(), principal)
+		} else {
+			allowed = s.permService.CanWriteDocuments(r.Context(), principal.Principal)
+		}
+		if !allowed {
+			w.Header().Set("Content-Type", "application/json")
+			s.forbid(w, r, fmt.Errorf("user %s is not allowed to %s", principal.Username, rt.action))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isServerAdmin reports whether the principal may affect every tenant, which
+// requires the write relation on the default tenant's corpus
+func (s *Server) isServerAdmin(ctx context.Context, principal auth.Principal) bool {
+	return s.permService.CanWriteDocuments(tenant.NewContext(ctx, tenant.Default), principal.Principal)
+}
+
+// serveRoutes serves the request with its route. Requests for a known path
+// with a method no route declares are answered with a 405 listing the
+// allowed methods.
+func (s *Server) serveRoutes(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := s.mux.Handler(r); pattern == "" {
+		var allowed []string
+		for _, method := range s.methods {
+			probe := r.Clone(r.Context())
+			probe.Method = method
+			if _, pattern := s.mux.Handler(probe); pattern != "" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			s.writer.WriteError(w, r, errMethodNotAllowed)
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"rerag-rbac-rag-llm/internal/blob"
+	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/directory"
+	apperrors "rerag-rbac-rag-llm/internal/errors"
+	"rerag-rbac-rag-llm/internal/metering"
+	"rerag-rbac-rag-llm/internal/share"
+	"rerag-rbac-rag-llm/internal/storage"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// routeURL fills the route's path wildcards and returns its method and URL
+func routeURL(rt route) (string, string) {
+	method, path, _ := strings.Cut(rt.pattern, " ")
+	return method, regexp.MustCompile(`\{[^}]+\}`).ReplaceAllString(path, uuid.NewString())
+}
+
+// publicRoutes are the only routes served without credentials
+var publicRoutes = []string{"GET /health", "GET /health/live", "GET /health/ready"}
+
+// createFullTestServer returns a server with the options that add routes
+func createFullTestServer(t *testing.T) *Server {
+	t.Helper()
+	_, embedder, vectorStore, llmClient, permService := createTestServer()
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "routes.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	keys, err := storage.NewSQLiteAPIKeyStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create API key store: %v", err)
+	}
+	links, err := storage.NewSQLiteShareLinkStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create share link store: %v", err)
+	}
+	users, err := storage.NewSQLiteDirectoryStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create directory store: %v", err)
+	}
+	originals, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create blob store: %v", err)
+	}
+	live, err := config.LoadLive()
+	if err != nil {
+		t.Fatalf("LoadLive failed: %v", err)
+	}
+	return NewServer(embedder, vectorStore, llmClient, permService, apperrors.NewErrorHandler(&config.Config{}),
+		WithMeter(metering.NewMeter()),
+		WithConversations(NewMockConversationStore(), 1024),
+		WithConfig(live),
+		WithAPIKeys(keys),
+		WithShareLinks(links, share.NewSigner("0123456789abcdef0123456789abcdef"), time.Hour, 24*time.Hour),
+		WithOriginals(originals),
+		WithDirectory(directory.New(users, permService)),
+	)
+}
+
+// TestRoutesRequireCredentials lints the route table: every route outside
+// publicRoutes must reject requests without credentials before its handler
+// runs, with every option that adds routes enabled.
+func TestRoutesRequireCredentials(t *testing.T) {
+	server := createFullTestServer(t)
+	handler := server.GetHandler()
+
+	for _, rt := range server.routes() {
+		if rt.public != slices.Contains(publicRoutes, rt.pattern) {
+			t.Errorf("%s: public is %t, expected only %v to be public", rt.pattern, rt.public, publicRoutes)
+			continue
+		}
+		if rt.public {
+			continue
+		}
+		method, url := routeURL(rt)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status %d without credentials, got %d", rt.pattern, http.StatusUnauthorized, w.Code)
+		}
+	}
+}
+
+func TestHandlersRejectMissingPrincipal(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+
+	// A handler registered without authentication must not run as nobody
+	w := httptest.NewRecorder()
+	server.listDocuments(w, httptest.NewRequest(http.MethodGet, "/documents", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without a principal, got %d: %s", http.StatusUnauthorized, w.Code, w.Body.String())
+	}
+}
+
+func TestRoutesEnforceRequirements(t *testing.T) {
+	server, _, _, _, permService := createTestServer()
+	permService.SetCanWrite("bob", false)
+	handler := server.GetHandler()
+
+	for _, rt := range server.routes() {
+		if rt.public || rt.require == anyUser {
+			continue
+		}
+		method, url := routeURL(rt)
+		w := serveAs(handler, method, url, nil, "bob")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status %d for a reader, got %d", rt.pattern, http.StatusForbidden, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "not allowed to "+rt.action) {
+			t.Errorf("%s: expected the denial to name the action, got %s", rt.pattern, w.Body.String())
+		}
+	}
+}
+
+func TestRoutesRejectUndeclaredMethods(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+	handler := server.GetHandler()
+
+	tests := []struct {
+		method, url, allow string
+	}{
+		{http.MethodPatch, "/permissions", "GET, POST, DELETE"},
+		{http.MethodPost, "/documents/" + uuid.NewString(), "GET, PUT, DELETE"},
+		{http.MethodPost, "/admin/stats", "GET"},
+		{http.MethodDelete, "/health", "GET"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s %s: expected status %d allowing %q, got %d allowing %q", tt.method, tt.url, http.StatusMethodNotAllowed, tt.allow, w.Code, w.Header().Get("Allow"))
+		}
+		var response struct {
+			Error struct {
+				Code int `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Error.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s: expected a JSON error, got %s", tt.method, tt.url, w.Body.String())
+		}
+	}
+
+	// Unknown paths are still not found
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for an unknown path, got %d", http.StatusNotFound, w.Code)
+	}
+}
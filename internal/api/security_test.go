@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveRequest serves req with handler
+func serveRequest(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+	WithSecurityHeaders(SecurityHeaders{
+		HSTSMaxAge:            24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: "default-src 'none'",
+	})(server)
+	handler := server.GetHandler()
+
+	// Errors written before routing carry the headers too
+	for _, tenantID := range []string{"", "Not A Tenant!"} {
+		req := createAuthenticatedRequest(http.MethodGet, "/health", nil, "alice")
+		if tenantID != "" {
+			req.Header.Set("X-Tenant-ID", tenantID)
+		}
+		w := serveRequest(handler, req)
+		expected := map[string]string{
+			"X-Content-Type-Options":    "nosniff",
+			"Strict-Transport-Security": "max-age=86400; includeSubDomains",
+			"Content-Security-Policy":   "default-src 'none'",
+		}
+		for header, value := range expected {
+			if got := w.Header().Get(header); got != value {
+				t.Errorf("Expected %s %q on a %d response, got %q", header, value, w.Code, got)
+			}
+		}
+	}
+
+	plain, _, _, _, _ := createTestServer()
+	w := serveRequest(plain.GetHandler(), createAuthenticatedRequest(http.MethodGet, "/health", nil, "alice"))
+	if w.Header().Get("X-Content-Type-Options") != "" {
+		t.Error("Expected no security headers without the option")
+	}
+}
+
+func TestIngestionRequiresClientCertificate(t *testing.T) {
+	server, _, _, _, permService := createTestServer()
+	permService.SetCanWrite("alice", true)
+	WithClientCertificates()(server)
+	handler := server.GetHandler()
+	body := []byte(`{"title": "Q3 report", "content": "Revenue grew"}`)
+
+	for _, url := range []string{"/documents", "/documents/upload"} {
+		if w := serveAs(handler, http.MethodPost, url, body, "alice"); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s without a client certificate, got %d", url, w.Code)
+		}
+	}
+
+	// Certificates presented but not verified do not count
+	req := createAuthenticatedRequest(http.MethodPost, "/documents", body, "alice")
+	req.Header.Set("Authorization", "Bearer alice")
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+	if w := serveRequest(handler, req); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unverified certificate, got %d", w.Code)
+	}
+
+	req = createAuthenticatedRequest(http.MethodPost, "/documents", body, "alice")
+	req.Header.Set("Authorization", "Bearer alice")
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	if w := serveRequest(handler, req); w.Code != http.StatusCreated {
+		t.Errorf("Expected 201 with a verified certificate, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Reads do not require a certificate
+	if w := serveAs(handler, http.MethodGet, "/documents", nil, "alice"); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 listing documents without a certificate, got %d", w.Code)
+	}
+}
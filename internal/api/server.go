@@ -1,69 +1,438 @@
 package api
 
 import (
-	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/blob"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/directory"
+	apperrors "rerag-rbac-rag-llm/internal/errors"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/injection"
+	"rerag-rbac-rag-llm/internal/lifecycle"
+	"rerag-rbac-rag-llm/internal/metering"
 	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/moderation"
 	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/prompt"
+	"rerag-rbac-rag-llm/internal/querycache"
+	"rerag-rbac-rag-llm/internal/quota"
+	"rerag-rbac-rag-llm/internal/ragservice"
+	"rerag-rbac-rag-llm/internal/redact"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/rerank"
+	"rerag-rbac-rag-llm/internal/scheduler"
+	"rerag-rbac-rag-llm/internal/share"
 	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/ory/herodot"
 )
 
 // EmbedderInterface defines the contract for text embedding services
-type EmbedderInterface interface {
-	GetEmbedding(text string) ([]float32, error)
-}
+type EmbedderInterface = ragservice.Embedder
 
 // LLMInterface defines the contract for Large Language Model services
-type LLMInterface interface {
-	Generate(question string, documents []models.Document) (string, error)
+type LLMInterface = ragservice.LLM
+
+// Pagination bounds for GET /documents
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// Pinger is implemented by dependencies that can report their availability
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
 
+// defaultUploadLimit is the maximum upload size unless WithIngestion is given
+const defaultUploadLimit = 20 << 20
+
+// readinessTimeout bounds how long the readiness probe waits for dependencies
+const readinessTimeout = 3 * time.Second
+
 // Server handles HTTP requests for the RAG API
 type Server struct {
-	mux         *http.ServeMux
+	mux *http.ServeMux
+	// methods are the methods of the routes, for the Allow header of 405s
+	methods     []string
 	embedder    EmbedderInterface
 	vectorStore storage.VectorStore
 	llmClient   LLMInterface
 	permService permissions.PermissionChecker
 	writer      *herodot.JSONWriter
+	errHandler  *apperrors.ErrorHandler
+	// rag ingests, lists, and queries documents for the handlers
+	rag *ragservice.Service
+	// conversations enables the /conversations endpoints when set
+	conversations storage.ConversationStore
+	historyTokens int               // prompt budget for prior conversation turns
+	uploadLimit   int64             // maximum request body size of file uploads
+	notifier      webhooks.Notifier // optional
+	quotas        *quota.Enforcer   // optional
+	// apiKeys authenticates services on ingest and query endpoints when set
+	apiKeys storage.APIKeyStore
+	// users authenticates users; Bearer usernames are trusted if unset
+	users auth.Authenticator
+	// directory enables the /admin/users and /admin/groups endpoints when set
+	directory *directory.Directory
+	reindex   reindexJob // re-embeds all documents after model changes
+	// lifecycle health checks the dependencies for readiness and stops them
+	// on shutdown
+	lifecycle *lifecycle.Manager
+	// config enables /admin/config when set
+	config *config.Live
+	// shareLinks enables POST /documents/{id}/share and share tokens on
+	// GET /documents/{id} when set
+	shareLinks  storage.ShareLinkStore
+	shareSigner *share.Signer
+	shareTTL    time.Duration // default lifetime of share links
+	maxShareTTL time.Duration
+	// meter enables /metrics and query totals on /usage when set
+	meter *metering.Meter
+	// crawlMetrics enables the connector crawl runs on /metrics when set
+	crawlMetrics *scheduler.Metrics
+	// clientIPs resolves the client IPs of logs and audits; nil trusts no
+	// forwarding proxy
+	clientIPs *clientip.Resolver
+	// securityHeaders are set on every response when set
+	securityHeaders *SecurityHeaders
+	// requireClientCerts rejects ingestion without a verified client certificate
+	requireClientCerts bool
+	// originals keeps uploaded files and enables GET /documents/{id}/original
+	// when set
+	originals blob.Store
+}
+
+// Option configures optional Server behavior
+type Option func(*Server)
+
+// WithHybridSearch sets the rank fusion weights used for hybrid queries
+func WithHybridSearch(opts storage.HybridOptions) Option {
+	return func(s *Server) {
+		ragservice.WithHybridSearch(opts)(s.rag)
+	}
+}
+
+// WithReranker rescores the best candidates documents of each query with r
+// and passes the top_k highest-scored ones to the LLM
+func WithReranker(r rerank.Reranker, candidates int) Option {
+	return func(s *Server) {
+		ragservice.WithReranker(r, candidates)(s.rag)
+	}
+}
+
+// WithConversations enables multi-turn conversations persisted in store. Prior
+// turns are included in prompts up to historyTokens estimated tokens.
+func WithConversations(store storage.ConversationStore, historyTokens int) Option {
+	return func(s *Server) {
+		s.conversations = store
+		s.historyTokens = historyTokens
+	}
+}
+
+// WithQueryCache serves repeated queries over the same permitted documents
+// from cache instead of the LLM
+func WithQueryCache(cache *querycache.Cache) Option {
+	return func(s *Server) {
+		ragservice.WithQueryCache(cache)(s.rag)
+	}
+}
+
+// WithIngestion sets how uploaded files are split into chunks of chunkSize bytes
+// overlapping by chunkOverlap bytes, and the maximum upload size
+func WithIngestion(chunkSize, chunkOverlap int, maxUploadBytes int64) Option {
+	return func(s *Server) {
+		ragservice.WithChunking(chunkSize, chunkOverlap)(s.rag)
+		s.uploadLimit = maxUploadBytes
+	}
+}
+
+// WithChunkVectors stores documents and uploads as single documents with a
+// vector per chunk; queries match them by their chunks and answer from the
+// best matching one
+func WithChunkVectors() Option {
+	return func(s *Server) {
+		ragservice.WithChunkVectors()(s.rag)
+	}
+}
+
+// WithOriginals keeps the original file of every upload in store, so it can
+// be downloaded from the documents extracted from it
+func WithOriginals(store blob.Store) Option {
+	return func(s *Server) {
+		s.originals = store
+	}
+}
+
+// WithWebhooks publishes document and permission changes to n. If n has a
+// Close(context.Context) error method, Shutdown calls it to flush pending events.
+func WithWebhooks(n webhooks.Notifier) Option {
+	return func(s *Server) {
+		s.notifier = n
+		ragservice.WithNotifier(n)(s.rag)
+	}
+}
+
+// WithLifecycle reports the health checks of m on /health/ready and stops
+// its components on Shutdown. Without it, the server checks and stops the
+// dependencies it was created with.
+func WithLifecycle(m *lifecycle.Manager) Option {
+	return func(s *Server) {
+		s.lifecycle = m
+	}
+}
+
+// WithRequireSources answers questions that match none of the user's
+// accessible documents with models.NoAccessibleDocumentsAnswer instead of
+// calling the LLM with an empty context
+func WithRequireSources() Option {
+	return func(s *Server) {
+		ragservice.WithRequireSources()(s.rag)
+	}
+}
+
+// WithQueryTimeout bounds the time a query may take to budget; queries
+// exceeding it fail with 504. Clients can shorten it per request with the
+// X-Query-Timeout header.
+func WithQueryTimeout(budget time.Duration) Option {
+	return func(s *Server) {
+		ragservice.WithQueryTimeout(budget)(s.rag)
+	}
+}
+
+// WithHiddenResultCounts reports in query responses how many of the top_k
+// matches were withheld by permissions, so clients can tell users that more
+// results exist. Only the count is disclosed, never which documents matched.
+func WithHiddenResultCounts() Option {
+	return func(s *Server) {
+		ragservice.WithHiddenResultCounts()(s.rag)
+	}
+}
+
+// WithAgent enables agent queries ("agent": true), in which the LLM may
+// issue up to maxSearches permission-filtered follow-up searches before
+// answering
+func WithAgent(maxSearches int) Option {
+	return func(s *Server) {
+		ragservice.WithAgent(maxSearches)(s.rag)
+	}
+}
+
+// WithRouting classifies questions and answers counts of documents from the
+// metadata keys of up to maxDocuments documents and small talk without
+// retrieval
+func WithRouting(metadataKeys []string, maxDocuments int) Option {
+	return func(s *Server) {
+		ragservice.WithRouting(metadataKeys, maxDocuments)(s.rag)
+	}
+}
+
+// WithMaxQuestionLength rejects questions longer than n characters with 400
+func WithMaxQuestionLength(n int) Option {
+	return func(s *Server) {
+		ragservice.WithMaxQuestionLength(n)(s.rag)
+	}
+}
+
+// WithQuotas rejects ingestion that would exceed the document or content
+// limits of the user or tenant
+func WithQuotas(e *quota.Enforcer) Option {
+	return func(s *Server) {
+		s.quotas = e
+		ragservice.WithQuotas(e)(s.rag)
+	}
+}
+
+// WithAuthenticator authenticates users with a instead of trusting the
+// username in the bearer token
+func WithAuthenticator(a auth.Authenticator) Option {
+	return func(s *Server) {
+		s.users = a
+	}
+}
+
+// WithDirectory enables the /admin/users and /admin/groups endpoints to
+// manage the users and groups of dir
+func WithDirectory(dir *directory.Directory) Option {
+	return func(s *Server) {
+		s.directory = dir
+	}
+}
+
+// WithAPIKeys accepts the API keys in store on the ingest and query
+// endpoints alongside user authentication and enables the /api-keys
+// endpoints to manage them
+func WithAPIKeys(store storage.APIKeyStore) Option {
+	return func(s *Server) {
+		s.apiKeys = store
+	}
+}
+
+// WithShareLinks enables share links stored in store whose tokens are signed
+// with signer. Links expire after defaultTTL unless the request asks for a
+// lifetime of up to maxTTL.
+func WithShareLinks(store storage.ShareLinkStore, signer *share.Signer, defaultTTL, maxTTL time.Duration) Option {
+	return func(s *Server) {
+		s.shareLinks = store
+		s.shareSigner = signer
+		s.shareTTL = defaultTTL
+		s.maxShareTTL = maxTTL
+	}
+}
+
+// WithMeter records the tokens and stage timings of every answered query in m,
+// reports the user's totals on /usage, and exports the tenant's totals on
+// /metrics
+func WithMeter(m *metering.Meter) Option {
+	return func(s *Server) {
+		s.meter = m
+		ragservice.WithMeter(m)(s.rag)
+	}
+}
+
+// WithCrawlMetrics exports the tenant's connector crawl runs on /metrics
+func WithCrawlMetrics(m *scheduler.Metrics) Option {
+	return func(s *Server) {
+		s.crawlMetrics = m
+	}
+}
+
+// WithRedaction replaces sensitive values in documents with placeholders
+// before they are put into prompts. Queries with "rehydrate" get the values
+// back in the answer.
+func WithRedaction(r *redact.Redactor) Option {
+	return func(s *Server) {
+		ragservice.WithRedaction(r)(s.rag)
+	}
+}
+
+// WithInjectionGuard strips prompt injection attempts from ingested documents
+// with sanitizer and records their risk score. Retrieved documents are
+// sanitized again before prompting; with excludeFlagged, flagged documents are
+// left out of the prompt and reported as not included.
+func WithInjectionGuard(sanitizer *injection.Sanitizer, excludeFlagged bool) Option {
+	return func(s *Server) {
+		ragservice.WithInjectionGuard(sanitizer, excludeFlagged)(s.rag)
+	}
+}
+
+// WithModeration checks questions and generated answers against the content
+// policy of moderator, handling flagged ones with questionAction and
+// answerAction; an empty action leaves that side unchecked
+func WithModeration(moderator moderation.Moderator, questionAction, answerAction moderation.Action) Option {
+	return func(s *Server) {
+		ragservice.WithModeration(moderator, questionAction, answerAction)(s.rag)
+	}
+}
+
+// WithTrustedProxies resolves client IPs with res, which trusts the
+// X-Forwarded-For headers set by the server's reverse proxies
+func WithTrustedProxies(res *clientip.Resolver) Option {
+	return func(s *Server) {
+		s.clientIPs = res
+	}
 }
 
 // NewServer creates a new API server with the provided dependencies
-func NewServer(embedder EmbedderInterface, vectorStore storage.VectorStore, llmClient LLMInterface, permService permissions.PermissionChecker) *Server {
+func NewServer(embedder EmbedderInterface, vectorStore storage.VectorStore, llmClient LLMInterface, permService permissions.PermissionChecker, errHandler *apperrors.ErrorHandler, opts ...Option) *Server {
 	s := &Server{
 		mux:         http.NewServeMux(),
 		embedder:    embedder,
 		vectorStore: vectorStore,
 		llmClient:   llmClient,
 		permService: permService,
-		writer:      herodot.NewJSONWriter(nil),
+		writer:      errHandler.Writer(),
+		errHandler:  errHandler,
+		rag:         ragservice.New(embedder, vectorStore, llmClient, permService),
+		uploadLimit: defaultUploadLimit,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.lifecycle == nil {
+		s.lifecycle = s.dependencies()
 	}
 
 	s.setupRoutes()
 	return s
 }
 
-func (s *Server) setupRoutes() {
-	s.mux.HandleFunc("/documents", s.handleDocuments)
-	s.mux.Handle("/query", auth.Middleware(http.HandlerFunc(s.queryDocuments)))
-	s.mux.HandleFunc("/health", s.healthCheck)
-	s.mux.Handle("/permissions", auth.Middleware(http.HandlerFunc(s.handlePermissions)))
+// dependencies returns a manager checking the health of the document store,
+// the LLM, and the permission service, and flushing webhooks and closing the
+// document store on Stop
+func (s *Server) dependencies() *lifecycle.Manager {
+	m := lifecycle.New()
+	database := lifecycle.Component{
+		Name: "database",
+		Stop: func(context.Context) error {
+			if closer, ok := s.vectorStore.(io.Closer); ok {
+				return closer.Close()
+			}
+			return nil
+		},
+	}
+	if pinger, ok := s.vectorStore.(Pinger); ok {
+		database.HealthCheck = pinger.Ping
+	}
+	m.Register(database)
+	for name, dep := range map[string]interface{}{"ollama": s.llmClient, "keto": s.permService} {
+		if pinger, ok := dep.(Pinger); ok {
+			m.Register(lifecycle.Component{Name: name, HealthCheck: pinger.Ping})
+		}
+	}
+	// Read the notifier on Stop, options applied later may still set it
+	m.Register(lifecycle.Component{
+		Name: "webhooks",
+		Stop: func(ctx context.Context) error {
+			if flusher, ok := s.notifier.(interface{ Close(context.Context) error }); ok {
+				return flusher.Close(ctx)
+			}
+			return nil
+		},
+	})
+	return m
+}
+
+// authenticate wraps h in the user authentication middleware. If API keys
+// are enabled, keys with scope are accepted too; an empty scope admits users only.
+func (s *Server) authenticate(scope string, h http.Handler) http.Handler {
+	users := s.users
+	if users == nil {
+		users = auth.BearerAuthenticator{}
+	}
+	if s.apiKeys == nil {
+		return auth.RequireUser(users, s.writer, h)
+	}
+	return auth.APIKeyMiddleware(s.apiKeys, scope, users, s.writer, h)
+}
+
+// Handler returns the routes wrapped in the middleware Run serves them with
+func (s *Server) Handler() http.Handler {
+	return s.middleware().then(http.HandlerFunc(s.serveRoutes))
 }
 
 // Run starts the HTTP server on the specified address
 func (s *Server) Run(addr string) error {
 	log.Printf("Server starting on %s", addr)
-	handler := loggingMiddleware(s.mux)
 
 	server := &http.Server{
 		Addr:           addr,
-		Handler:        handler,
+		Handler:        s.Handler(),
 		ReadTimeout:    15 * time.Second,
 		WriteTimeout:   15 * time.Second,
 		IdleTimeout:    60 * time.Second,
@@ -73,18 +442,6 @@ func (s *Server) Run(addr string) error {
 	return server.ListenAndServe()
 }
 
-func (s *Server) handleDocuments(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		s.addDocument(w, r)
-	case http.MethodGet:
-		// GET requests require authentication
-		auth.Middleware(http.HandlerFunc(s.listDocuments)).ServeHTTP(w, r)
-	default:
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
-	}
-}
-
 func (s *Server) addDocument(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -94,16 +451,13 @@ func (s *Server) addDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	embedding, err := s.embedder.GetEmbedding(doc.Content)
-	if err != nil {
-		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to generate embedding").WithError(err.Error()))
+	principal, ok := s.principal(w, r)
+	if !ok {
 		return
 	}
-
-	doc.Embedding = embedding
-
-	if err := s.vectorStore.UpsertDocument(&doc); err != nil {
-		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to store document").WithError(err.Error()))
+	// Posting an existing ID replaces the document
+	if err := s.rag.Ingest(r.Context(), principal.Principal, &doc); err != nil {
+		s.writeServiceError(w, r, err, "")
 		return
 	}
 
@@ -114,108 +468,549 @@ func (s *Server) addDocument(w http.ResponseWriter, r *http.Request) {
 	s.writer.WriteCreated(w, r, "", response)
 }
 
-func (s *Server) listDocuments(w http.ResponseWriter, r *http.Request) {
+// getDocument returns a document the user may read; others are answered with 404
+func (s *Server) getDocument(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	username := auth.GetUserFromContext(r.Context())
-	filter := func(doc *models.Document) bool {
-		return s.permService.CanAccessDocument(username, doc)
+	docID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error()))
+		return
 	}
 
-	docs := s.vectorStore.GetFilteredDocuments(filter)
-	response := &models.DocumentListResponse{
-		Documents: docs,
-		Count:     len(docs),
-		User:      username,
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
 	}
-	s.writer.Write(w, r, response)
+	doc, err := s.rag.Get(r.Context(), principal.Principal, docID)
+	if err != nil {
+		s.writeServiceError(w, r, err, "document "+docID.String())
+		return
+	}
+	s.writer.Write(w, r, doc)
 }
 
-func (s *Server) queryDocuments(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+func (s *Server) updateDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	docID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error()))
 		return
 	}
 
-	var req models.QueryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var doc models.Document
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
 		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
 		return
 	}
 
-	req.TopK = cmp.Or(req.TopK, 3)
+	doc.ID = docID
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	reembedded, err := s.rag.Update(r.Context(), principal.Principal, &doc)
+	if err != nil {
+		s.writeServiceError(w, r, err, "document "+docID.String())
+		return
+	}
 
-	questionEmbedding, err := s.embedder.GetEmbedding(req.Question)
+	response := &models.DocumentResponse{
+		ID:         doc.ID.String(),
+		Message:    "Document updated successfully",
+		Reembedded: reembedded,
+	}
+	s.writer.Write(w, r, response)
+}
+
+func (s *Server) deleteDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	docID, err := uuid.Parse(r.PathValue("id"))
 	if err != nil {
-		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to generate question embedding").WithError(err.Error()))
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error()))
 		return
 	}
 
-	username := auth.GetUserFromContext(r.Context())
-	filter := func(doc *models.Document) bool {
-		return s.permService.CanAccessDocument(username, doc)
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
 	}
+	trashed, err := s.rag.Delete(r.Context(), principal.Principal, docID)
+	if err != nil {
+		s.writeServiceError(w, r, err, "document "+docID.String())
+		return
+	}
+
+	message := "Document deleted successfully"
+	if trashed {
+		message = "Document moved to trash"
+	}
+	response := &models.DocumentResponse{
+		ID:      docID.String(),
+		Message: message,
+	}
+	s.writer.Write(w, r, response)
+}
 
-	relevantDocs, err := s.vectorStore.SearchSimilarWithFilter(questionEmbedding, req.TopK, filter)
+func (s *Server) listDocuments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	opts, err := parseListOptions(r.URL.Query())
 	if err != nil {
-		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to search documents").WithError(err.Error()))
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid query parameters").WithError(err.Error()))
 		return
 	}
 
-	answer, err := s.llmClient.Generate(req.Question, relevantDocs)
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	docs, nextOffset, err := s.rag.List(r.Context(), principal.Principal, opts)
 	if err != nil {
-		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to generate answer").WithError(err.Error()))
+		s.writeServiceError(w, r, err, "")
 		return
 	}
 
-	response := &models.QueryResponse{
-		Answer:  answer,
-		Sources: relevantDocs,
+	response := &models.DocumentListResponse{
+		Documents:  docs,
+		Count:      len(docs),
+		User:       principal.Username,
+		NextOffset: nextOffset,
 	}
 	s.writer.Write(w, r, response)
 }
 
-func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+// parseListOptions reads limit, offset, sort, order, and metadata.<key> query parameters
+func parseListOptions(query url.Values) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Limit:  defaultListLimit,
+		SortBy: storage.SortByTitle,
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return opts, fmt.Errorf("limit must be a positive integer")
+		}
+		opts.Limit = min(limit, maxListLimit)
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return opts, fmt.Errorf("offset must be a non-negative integer")
+		}
+		opts.Offset = offset
+	}
+	if v := query.Get("sort"); v != "" {
+		opts.SortBy = v
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.SortDesc = true
+	default:
+		return opts, fmt.Errorf("order must be asc or desc")
+	}
+
+	opts.Metadata = parseMetadataFilter(query)
+
+	return opts, opts.Validate()
+}
+
+// parseMetadataFilter reads metadata.<key> query parameters; it returns nil without any
+func parseMetadataFilter(query url.Values) map[string]string {
+	var metadata map[string]string
+	for key, values := range query {
+		name, ok := strings.CutPrefix(key, "metadata.")
+		if !ok {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[name] = values[0]
+	}
+	return metadata
+}
+
+func (s *Server) queryDocuments(w http.ResponseWriter, r *http.Request) {
+	var req models.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	if err := s.validateQuery(&req); err != nil {
+		s.writer.WriteError(w, r, err)
+		return
+	}
+	timeout, err := queryTimeout(r)
+	if err != nil {
+		s.writer.WriteError(w, r, err)
+		return
+	}
+
+	if wantsEventStream(r) {
+		s.streamQuery(w, r, &req, timeout)
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
 		return
 	}
+	response, err := s.rag.Query(r.Context(), principal.Principal, &req, ragservice.QueryOptions{Timeout: timeout})
+	if err != nil {
+		s.writeServiceError(w, r, err, "")
+		return
+	}
+	s.writer.Write(w, r, response)
+}
+
+// validateQuery normalizes the question, applies defaults to req, and
+// rejects invalid questions and retrieval options
+func (s *Server) validateQuery(req *models.QueryRequest) error {
+	if err := s.rag.ValidateQuestion(req); err != nil {
+		return serviceError(err)
+	}
+	return validateQueryOptions(req)
+}
+
+// QueryTimeoutHeader lets clients shorten the time budget of a query, in
+// seconds, e.g. "X-Query-Timeout: 10" or "2.5"
+const QueryTimeoutHeader = "X-Query-Timeout"
+
+// maxQueryTimeout bounds QueryTimeoutHeader; budgets only shorten the
+// configured one, so larger values merely need to be representable
+const maxQueryTimeout = 24 * time.Hour
+
+// queryTimeout returns the budget requested with QueryTimeoutHeader, or 0
+// without one
+func queryTimeout(r *http.Request) (time.Duration, error) {
+	value := r.Header.Get(QueryTimeoutHeader)
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 || seconds > maxQueryTimeout.Seconds() {
+		return 0, herodot.ErrBadRequest.WithReason("Invalid " + QueryTimeoutHeader + " header").WithError("the query timeout must be a positive number of seconds")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// validateQueryOptions applies defaults to req and rejects invalid retrieval options
+func validateQueryOptions(req *models.QueryRequest) error {
+	if err := ragservice.ValidateQuery(req); err != nil {
+		return serviceError(err)
+	}
+	return nil
+}
+
+func (s *Server) store(ctx context.Context) storage.VectorStore {
+	return s.vectorStore.ForTenant(tenant.FromContext(ctx))
+}
+
+// errBadGateway is returned when a backing service is known to be down
+var errBadGateway = herodot.DefaultError{
+	StatusField: http.StatusText(http.StatusBadGateway),
+	ErrorField:  "An upstream service is unavailable, please try again later",
+	CodeField:   http.StatusBadGateway,
+}
+
+// serviceError maps an error of the RAG service to an API error
+func serviceError(err error) error {
+	var invalid *ragservice.ValidationError
+	var exceeded *quota.ExceededError
+	var timeout *ragservice.TimeoutError
+	var op *ragservice.OpError
+	switch {
+	case errors.As(err, &invalid):
+		return herodot.ErrBadRequest.WithReason("Invalid "+invalid.Field).WithError(invalid.Err.Error()).WithDetail("field", invalid.Field)
+	case errors.Is(err, ragservice.ErrAuthorizationUnavailable):
+		return errAuthorizationUnavailable
+	case errors.Is(err, ragservice.ErrUsageUnsupported):
+		return errNotImplemented.WithReason("The document store cannot measure usage for quotas")
+	case errors.Is(err, ragservice.ErrAggregationUnsupported):
+		return errNotImplemented.WithReason("The document store cannot aggregate metadata")
+	case errors.Is(err, ragservice.ErrTrashUnsupported):
+		return errNotImplemented.WithReason("The document store does not keep deleted documents")
+	case errors.As(err, &exceeded):
+		return quotaError(exceeded)
+	case errors.As(err, &timeout):
+		return errQueryTimeout.WithReason("Timed out while trying to "+timeout.Op).
+			WithDetail("stage", timeout.Op).WithDetail("budget_ms", timeout.Budget.Milliseconds())
+	case errors.Is(err, prompt.ErrTemplateNotFound):
+		return herodot.ErrBadRequest.WithReason("Unknown prompt template").WithError(err.Error())
+	case errors.As(err, &op) && (op.Op == ragservice.OpList || op.Op == ragservice.OpDelete || op.Op == ragservice.OpMeasureUsage):
+		return herodot.ErrInternalServerError.WithReason(opReason(op.Op)).WithError(op.Err.Error())
+	case errors.As(err, &op):
+		return storeError(op.Err, opReason(op.Op))
+	default:
+		return herodot.ErrInternalServerError.WithError(err.Error())
+	}
+}
+
+// opReason describes a failed step of a service operation
+func opReason(op string) string {
+	return "Failed to " + op
+}
+
+// writeServiceError answers a failed service operation. Denials go through
+// forbid and missing documents and failed lookups through the error handler,
+// naming resource.
+func (s *Server) writeServiceError(w http.ResponseWriter, r *http.Request, err error, resource string) {
+	requestID := requestid.FromContext(r.Context())
+	var op *ragservice.OpError
+	switch {
+	case errors.Is(err, ragservice.ErrPermissionDenied):
+		s.errHandler.HandleAuthorizationError(w, r, err, requestID)
+	case errors.Is(err, ragservice.ErrAuthorizationUnavailable):
+		requestid.Logf(r.Context(), "Authorization unavailable: %v", err)
+		s.writer.WriteError(w, r, errAuthorizationUnavailable)
+	case errors.Is(err, storage.ErrDocumentNotFound):
+		s.errHandler.HandleNotFoundError(w, r, resource, requestID)
+	case errors.As(err, &op) && (op.Op == ragservice.OpGetDocument || op.Op == ragservice.OpListTrash || op.Op == ragservice.OpRestore):
+		s.errHandler.HandleDatabaseError(w, r, op.Err, requestID)
+	default:
+		s.writer.WriteError(w, r, serviceError(err))
+	}
+}
+
+// upstreamError maps a failed call to a backing service to a 502 while its
+// circuit breaker is open and to a 500 otherwise
+func upstreamError(err error, reason string) error {
+	if errors.Is(err, httpclient.ErrCircuitOpen) {
+		return errBadGateway.WithReason(reason).WithError(err.Error())
+	}
+	return herodot.ErrInternalServerError.WithReason(reason).WithError(err.Error())
+}
+
+// storeError maps embeddings the vector store rejects because of a changed
+// embedding model to a 409 and other failures like upstreamError
+func storeError(err error, reason string) error {
+	var mismatch *storage.EmbeddingMismatchError
+	if errors.As(err, &mismatch) {
+		return herodot.ErrConflict.WithReason("The embedding model does not match the stored documents; reindex them with POST /documents/reindex").WithError(err.Error())
+	}
+	return upstreamError(err, reason)
+}
 
+func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 	response := &models.HealthResponse{Status: "healthy"}
 	s.writer.Write(w, r, response)
 }
 
-func (s *Server) handlePermissions(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
-		return
+// readinessCheck health checks every lifecycle component and reports 503 if any is down
+func (s *Server) readinessCheck(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	results := s.lifecycle.HealthCheck(ctx)
+	response := &models.ReadinessResponse{
+		Status:       "ready",
+		Dependencies: make(map[string]models.DependencyStatus, len(results)),
+	}
+	for name, err := range results {
+		if err != nil {
+			response.Dependencies[name] = models.DependencyStatus{Status: "down", Error: err.Error()}
+			response.Status = "unavailable"
+			continue
+		}
+		response.Dependencies[name] = models.DependencyStatus{Status: "up"}
+	}
+
+	code := http.StatusOK
+	if response.Status != "ready" {
+		code = http.StatusServiceUnavailable
 	}
+	s.writer.WriteCode(w, r, code, response)
+}
 
-	username := auth.GetUserFromContext(r.Context())
-	permissions := s.permService.GetUserPermissions(username)
+func (s *Server) listPermissions(w http.ResponseWriter, r *http.Request) {
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	permissions := s.permService.GetUserPermissions(r.Context(), principal.Principal)
 	response := &models.PermissionsResponse{
-		User:        username,
+		User:        principal.Username,
 		Permissions: permissions,
 	}
 	s.writer.Write(w, r, response)
 }
 
-// GetHandler returns the HTTP handler for the server
-func (s *Server) GetHandler() http.Handler {
-	return loggingMiddleware(s.mux)
+// grantPermission gives a user or a group's members a relation. Changing
+// relations requires the write relation on the corpus, like ingestion.
+func (s *Server) grantPermission(w http.ResponseWriter, r *http.Request) {
+	s.changePermission(w, r, true)
 }
 
-// Shutdown gracefully shuts down the server
-func (s *Server) Shutdown(timeout time.Duration) error {
-	log.Printf("Server shutdown initiated with timeout: %v", timeout)
-	// In a more complex implementation, you might close database connections,
-	// stop background workers, etc.
-	return nil
+// revokePermission removes a relation from a user or a group's members
+func (s *Server) revokePermission(w http.ResponseWriter, r *http.Request) {
+	s.changePermission(w, r, false)
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s", r.Method, r.RequestURI, r.RemoteAddr)
-		next.ServeHTTP(w, r)
+// changePermission grants or revokes a relation of a user or of a group's members
+func (s *Server) changePermission(w http.ResponseWriter, r *http.Request, grant bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	manager, ok := s.permService.(permissions.PermissionManager)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot change relations"))
+		return
+	}
+
+	var req models.PermissionChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	tuple, err := permissionTuple(req)
+	if err != nil {
+		s.writer.WriteError(w, r, err)
+		return
+	}
+
+	change, event, message := manager.Revoke, webhooks.PermissionRevoked, "Permission revoked successfully"
+	if grant {
+		change, event, message = manager.Grant, webhooks.PermissionGranted, "Permission granted successfully"
+	}
+	if err := change(r.Context(), tuple); err != nil {
+		if errors.Is(err, permissions.ErrChangesUnsupported) {
+			s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot change relations"))
+			return
+		}
+		s.writer.WriteError(w, r, upstreamError(err, "Failed to change permission"))
+		return
+	}
+	s.notify(r.Context(), event, webhooks.PermissionData{
+		Subject:  tuple.Subject,
+		Group:    tuple.Group,
+		Relation: tuple.Relation,
+		Object:   tuple.Object(),
 	})
+
+	response := &models.PermissionChangeResponse{
+		User:     tuple.Subject,
+		Group:    tuple.Group,
+		Relation: tuple.Relation,
+		Object:   tuple.Object(),
+		Message:  message,
+	}
+	s.writer.Write(w, r, response)
+}
+
+// permissionTuple converts a grant or revoke request into a valid tuple; the
+// error is a 400 naming what is wrong
+func permissionTuple(req models.PermissionChangeRequest) (permissions.Tuple, error) {
+	tuple := permissions.Tuple{Subject: req.User, Group: req.Group, Relation: req.Relation}
+	if req.DocumentID != "" {
+		docID, err := uuid.Parse(req.DocumentID)
+		if err != nil {
+			return tuple, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error())
+		}
+		tuple.DocumentID = docID
+	}
+	if err := tuple.Validate(); err != nil {
+		return tuple, herodot.ErrBadRequest.WithReason("Invalid relation").WithError(err.Error())
+	}
+	return tuple, nil
+}
+
+// errAuthorizationUnavailable is returned instead of a denial or an empty
+// result when Keto could not answer the request's permission checks
+var errAuthorizationUnavailable = herodot.DefaultError{
+	StatusField: http.StatusText(http.StatusServiceUnavailable),
+	ErrorField:  "authorization unavailable",
+	ReasonField: "Permissions could not be checked; retry when the permission service has recovered",
+	CodeField:   http.StatusServiceUnavailable,
+}
+
+// forbid answers a failed authorization check: 403, or 503 if the check
+// failed because Keto was unavailable
+func (s *Server) forbid(w http.ResponseWriter, r *http.Request, err error) {
+	if permissions.Unavailable(r.Context()) {
+		requestid.Logf(r.Context(), "Authorization unavailable: %v", err)
+		s.writer.WriteError(w, r, errAuthorizationUnavailable)
+		return
+	}
+	s.errHandler.HandleAuthorizationError(w, r, err, requestid.FromContext(r.Context()))
+}
+
+// principal returns the caller of the request. Routes that are not public
+// always authenticate, so a missing principal means the route was registered
+// without authentication; the request is then rejected with 401 instead of
+// running without a user.
+func (s *Server) principal(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, ok := auth.PrincipalFromContext(r.Context())
+	if !ok {
+		s.writer.WriteError(w, r, errUnauthenticated)
+	}
+	return principal, ok
+}
+
+// errUnauthenticated is returned when a handler finds no authenticated caller
+var errUnauthenticated = herodot.ErrUnauthorized.WithReason("Authentication required")
+
+// errQueryTimeout is returned when a query exceeds its time budget
+var errQueryTimeout = herodot.DefaultError{
+	StatusField: http.StatusText(http.StatusGatewayTimeout),
+	ErrorField:  "The query exceeded its time budget",
+	CodeField:   http.StatusGatewayTimeout,
+}
+
+// errMethodNotAllowed is returned for HTTP methods an endpoint does not support
+var errMethodNotAllowed = herodot.DefaultError{
+	StatusField: http.StatusText(http.StatusMethodNotAllowed),
+	ErrorField:  "The request method is not supported by this endpoint",
+	CodeField:   http.StatusMethodNotAllowed,
+}
+
+// errNotImplemented is returned for operations the configured backends do not support
+var errNotImplemented = herodot.DefaultError{
+	StatusField: http.StatusText(http.StatusNotImplemented),
+	ErrorField:  "The operation is not supported by this deployment",
+	CodeField:   http.StatusNotImplemented,
+}
+
+// notify publishes an event if webhooks are configured
+func (s *Server) notify(ctx context.Context, eventType webhooks.EventType, data interface{}) {
+	if s.notifier != nil {
+		s.notifier.Notify(ctx, eventType, data)
+	}
+}
+
+// GetHandler returns the HTTP handler for the server
+func (s *Server) GetHandler() http.Handler {
+	return s.Handler()
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting new connections,
+// waits for in-flight requests and LLM generations to finish, and then stops the
+// lifecycle components, closing the vector store last. The context bounds how
+// long draining may take.
+func (s *Server) Shutdown(ctx context.Context, httpServer *http.Server) error {
+	log.Println("Server shutdown initiated")
+
+	var shutdownErr error
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			shutdownErr = fmt.Errorf("failed to shut down HTTP server: %w", err)
+		}
+	}
+
+	if err := s.rag.Drain(ctx); err != nil && shutdownErr == nil {
+		shutdownErr = err
+	}
+
+	if err := s.stopReindex(ctx); err != nil && shutdownErr == nil {
+		shutdownErr = err
+	}
+
+	if err := s.lifecycle.Stop(ctx); err != nil && shutdownErr == nil {
+		shutdownErr = err
+	}
+
+	return shutdownErr
 }
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/share"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ory/herodot"
+)
+
+// createShareLink creates a link granting read access to a document to
+// whoever presents its token until it expires. There is no owner relation,
+// so sharing requires the editor relation on the document. The link is
+// stored before its viewer relation is granted, so the expiry job revokes
+// every relation granted, even if granting fails halfway.
+func (s *Server) createShareLink(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	requestID := requestid.FromContext(r.Context())
+
+	docID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error()))
+		return
+	}
+	var req models.CreateShareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithError(err.Error()))
+		return
+	}
+	ttl := s.shareTTL
+	if req.ExpiresIn != 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl <= 0 || ttl > s.maxShareTTL {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid request body").WithErrorf("expires_in must be positive and at most %d seconds", int(s.maxShareTTL.Seconds())))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	if !s.permService.CanEditDocument(r.Context(), principal.Principal, &models.Document{ID: docID}) {
+		s.forbid(w, r, fmt.Errorf("user %s is not allowed to share document %s", principal.Username, docID))
+		return
+	}
+	if _, err := s.store(r.Context()).GetDocument(docID); errors.Is(err, storage.ErrDocumentNotFound) {
+		s.errHandler.HandleNotFoundError(w, r, "document "+docID.String(), requestID)
+		return
+	} else if err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestID)
+		return
+	}
+	manager, ok := s.permService.(permissions.PermissionManager)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot change relations"))
+		return
+	}
+
+	link := models.ShareLink{DocumentID: docID, CreatedBy: principal.Username, ExpiresAt: time.Now().Add(ttl).UTC().Truncate(time.Second)}
+	if err := s.shareLinks.ForTenant(tenant.FromContext(r.Context())).CreateShareLink(&link); err != nil {
+		s.errHandler.HandleDatabaseError(w, r, err, requestID)
+		return
+	}
+	if err := manager.Grant(r.Context(), share.Tuple(&link)); err != nil {
+		if errors.Is(err, permissions.ErrChangesUnsupported) {
+			s.writer.WriteError(w, r, errNotImplemented.WithReason("The permission service cannot change relations"))
+			return
+		}
+		s.writer.WriteError(w, r, upstreamError(err, "Failed to share document"))
+		return
+	}
+	token, err := s.shareSigner.Sign(&link)
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to sign share token").WithError(err.Error()))
+		return
+	}
+
+	requestid.Logf(r.Context(), "AUDIT share link created: user=%q id=%s document=%s expires_at=%s client_ip=%s",
+		principal.Username, link.ID, docID, link.ExpiresAt.Format(time.RFC3339), clientip.FromRequest(r))
+	s.writer.WriteCreated(w, r, "/documents/"+docID.String(), &models.CreateShareLinkResponse{
+		ShareLink: link,
+		Token:     token,
+		URL:       "/documents/" + docID.String() + "?" + url.Values{auth.ShareTokenParam: {token}}.Encode(),
+	})
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/share"
+	"rerag-rbac-rag-llm/internal/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func createShareTestServer(t *testing.T) (*Server, *MockVectorStore, *MockPermissionService, *storage.SQLiteShareLinkStore) {
+	t.Helper()
+	server, _, vectorStore, _, permService := createTestServer()
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "shares.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	links, err := storage.NewSQLiteShareLinkStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create share link store: %v", err)
+	}
+	WithShareLinks(links, share.NewSigner("0123456789abcdef0123456789abcdef"), time.Hour, 24*time.Hour)(server)
+	server.mux = http.NewServeMux()
+	server.setupRoutes()
+	return server, vectorStore, permService, links
+}
+
+func TestShareLinks(t *testing.T) {
+	server, vectorStore, permService, links := createShareTestServer(t)
+	doc := &models.Document{ID: uuid.New(), Title: "Return", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}}
+	other := &models.Document{ID: uuid.New(), Title: "Invoice", Content: "Total", Embedding: []float32{0.1, 0.2, 0.3}}
+	_ = vectorStore.AddDocument(doc)
+	_ = vectorStore.AddDocument(other)
+	permService.SetCanEdit("alice", false)
+
+	call := func(method, path, body, user, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if user != "" {
+			req.Header.Set("Authorization", "Bearer "+user)
+		}
+		if token != "" {
+			req.Header.Set(auth.ShareTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		server.GetHandler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := call(http.MethodPost, "/documents/"+doc.ID.String()+"/share", `{"expires_in": 600}`, adminUsername, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	var created models.CreateShareLinkResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if !strings.HasPrefix(created.Token, "rrs_") || created.DocumentID != doc.ID || created.CreatedBy != adminUsername {
+		t.Fatalf("Unexpected share link %+v", created)
+	}
+	if ttl := time.Until(created.ExpiresAt); ttl <= 9*time.Minute || ttl > 10*time.Minute {
+		t.Errorf("Expected the link to expire in 10 minutes, got %v", ttl)
+	}
+	want := permissions.Tuple{Subject: "share:" + created.ID.String(), Relation: permissions.RelationViewer, DocumentID: doc.ID}
+	if len(permService.granted) != 1 || permService.granted[0] != want {
+		t.Errorf("Expected %v to be granted, got %v", want, permService.granted)
+	}
+
+	// The token reads the shared document, from the header or the link's query parameter
+	if w := call(http.MethodGet, "/documents/"+doc.ID.String(), "", "", created.Token); w.Code != http.StatusOK {
+		t.Errorf("Expected the token to read the document, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodGet, created.URL, "", "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the link to read the document, got %d: %s", w.Code, w.Body.String())
+	}
+	for name, tt := range map[string]struct {
+		method, path, token string
+		expectedCode        int
+	}{
+		"other document": {http.MethodGet, "/documents/" + other.ID.String(), created.Token, http.StatusForbidden},
+		"delete":         {http.MethodDelete, "/documents/" + doc.ID.String(), created.Token, http.StatusForbidden},
+		"other endpoint": {http.MethodGet, "/documents/" + doc.ID.String() + "/access", created.Token, http.StatusUnauthorized},
+		"invalid token":  {http.MethodGet, "/documents/" + doc.ID.String(), created.Token + "x", http.StatusUnauthorized},
+	} {
+		if w := call(tt.method, tt.path, "", "", tt.token); w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tt.expectedCode, w.Code, w.Body.String())
+		}
+	}
+
+	// Expired links lose their relation and their token is rejected
+	expired, err := share.Expire(t.Context(), links, permService, created.ExpiresAt)
+	if err != nil || expired != 1 || len(permService.revoked) != 1 || permService.revoked[0] != want {
+		t.Errorf("Expected the link's relation to be revoked, got %d %v (%v)", expired, permService.revoked, err)
+	}
+
+	for name, tt := range map[string]struct {
+		user, id, body string
+		expectedCode   int
+	}{
+		"not an editor":    {"alice", doc.ID.String(), "", http.StatusForbidden},
+		"missing document": {adminUsername, uuid.NewString(), "", http.StatusNotFound},
+		"too long":         {adminUsername, doc.ID.String(), `{"expires_in": 86401}`, http.StatusBadRequest},
+		"negative":         {adminUsername, doc.ID.String(), `{"expires_in": -1}`, http.StatusBadRequest},
+		"default lifetime": {adminUsername, doc.ID.String(), "", http.StatusCreated},
+	} {
+		if w := call(http.MethodPost, "/documents/"+tt.id+"/share", tt.body, tt.user, ""); w.Code != tt.expectedCode {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tt.expectedCode, w.Code, w.Body.String())
+		}
+	}
+}
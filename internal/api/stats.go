@@ -0,0 +1,208 @@
+package api
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/ory/herodot"
+)
+
+const (
+	// maxFacetValues bounds the values reported per metadata key
+	maxFacetValues = 20
+	// maxStatsUsers bounds the users whose accessible documents are counted,
+	// since each needs a permission check of every document
+	maxStatsUsers = 100
+	// maxStatsGroups bounds the groups reported by document statistics
+	maxStatsGroups = 100
+)
+
+// getDocumentStats aggregates the metadata of the documents the caller may
+// access: the number of documents and the totals of the numeric values of
+// sum=<key>, overall and per value of group_by=<key>, restricted to
+// documents matching metadata.<key>=<value>. The store computes the
+// aggregates, so no LLM is involved and the numbers are exact.
+func (s *Server) getDocumentStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	opts := storage.AggregateOptions{
+		Metadata: parseMetadataFilter(query),
+		GroupBy:  query.Get("group_by"),
+		Sum:      slices.Compact(slices.Sorted(slices.Values(query["sum"]))),
+	}
+	if err := opts.Validate(); err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid query parameters").WithError(err.Error()))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	groups, err := s.rag.Stats(r.Context(), principal.Principal, opts)
+	if err != nil {
+		s.writeServiceError(w, r, err, "")
+		return
+	}
+
+	stats := &models.DocumentStatsResponse{User: principal.Username, Filters: opts.Metadata, GroupBy: opts.GroupBy}
+	if len(opts.Sum) > 0 {
+		stats.Sums = make(map[string]float64, len(opts.Sum))
+		for _, key := range opts.Sum {
+			stats.Sums[key] = 0
+		}
+	}
+	for _, group := range groups {
+		stats.Documents += group.Documents
+		for key, sum := range group.Sums {
+			stats.Sums[key] += sum
+		}
+		if opts.GroupBy == "" {
+			continue
+		}
+		if len(stats.Groups) == maxStatsGroups {
+			stats.GroupsTruncated = true
+			continue
+		}
+		stats.Groups = append(stats.Groups, models.DocumentStatsGroup{Value: group.Value, Documents: group.Documents, Sums: group.Sums})
+	}
+	s.writer.Write(w, r, stats)
+}
+
+// getStats reports the request tenant's documents by metadata facet, the
+// stored vectors, how many documents each user may read, the query volume,
+// and the relation counts. The store size spans every tenant and is only
+// reported to server admins. Facets default to every
+// metadata key and can be limited with facet=<key>; users default to those
+// holding relations and can be added with user=<name>. Since it names users
+// and metadata values, it requires the write relation on the corpus.
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	requestID := requestid.FromContext(r.Context())
+	tenantID := tenant.FromContext(r.Context())
+	docs := s.store(r.Context()).GetAllDocuments()
+	stats := &models.StatsResponse{
+		Tenant:      tenantID,
+		Documents:   models.Usage{Documents: len(docs)},
+		Facets:      facetStats(docs, r.URL.Query()["facet"]),
+		Users:       []models.UserAccessStats{},
+		GeneratedAt: time.Now().UTC(),
+	}
+	for i := range docs {
+		stats.Documents.ContentBytes += int64(len(docs[i].Content))
+	}
+
+	if reporter, ok := s.vectorStore.(storage.StatsReporter); ok {
+		storeStats, err := reporter.Stats()
+		if err != nil {
+			s.errHandler.HandleDatabaseError(w, r, err, requestID)
+			return
+		}
+		stats.Embedding = models.EmbeddingStats{Model: storeStats.EmbeddingModel, Dimensions: storeStats.EmbeddingDimensions}
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok && s.isServerAdmin(r.Context(), principal) {
+			stats.StorageBytes = storeStats.SizeBytes
+		}
+	}
+
+	if s.meter != nil {
+		totals, users := s.meter.TenantTotals(tenantID)
+		stats.Queries = &models.QueryVolume{QueryTotals: totals, Users: users}
+	}
+
+	users := r.URL.Query()["user"]
+	if counter, ok := s.permService.(permissions.RelationCounter); ok {
+		relations, err := counter.CountRelations(r.Context())
+		switch {
+		case errors.Is(err, permissions.ErrChangesUnsupported):
+		case err != nil:
+			s.writer.WriteError(w, r, upstreamError(err, "Failed to count relations"))
+			return
+		default:
+			stats.Relations = &models.RelationStats{ByRelation: relations.Relations, Memberships: relations.Memberships}
+			for _, n := range relations.Relations {
+				stats.Relations.Tuples += n
+			}
+			users = append(users, relations.Users...)
+		}
+	}
+
+	slices.Sort(users)
+	users = slices.Compact(users)
+	if len(users) > maxStatsUsers {
+		users, stats.UsersTruncated = users[:maxStatsUsers], true
+	}
+	for _, user := range users {
+		accessible := 0
+		for _, allowed := range s.permService.BatchCheck(r.Context(), permissions.Principal{Username: user}, docs) {
+			if allowed {
+				accessible++
+			}
+		}
+		stats.Users = append(stats.Users, models.UserAccessStats{User: user, AccessibleDocuments: accessible})
+	}
+
+	s.writer.Write(w, r, stats)
+}
+
+// facetStats counts the documents per value of the given metadata keys, or
+// of every key when none are given. Values that are not strings, numbers, or
+// booleans are not counted.
+func facetStats(docs []models.Document, keys []string) []models.FacetStats {
+	counts := make(map[string]map[string]int)
+	for _, key := range keys {
+		counts[key] = make(map[string]int)
+	}
+	for i := range docs {
+		for key, value := range docs[i].Metadata {
+			var s string
+			switch v := value.(type) {
+			case string:
+				s = v
+			case float64:
+				s = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				s = strconv.FormatBool(v)
+			default:
+				continue
+			}
+			values, ok := counts[key]
+			if !ok {
+				if len(keys) > 0 {
+					continue
+				}
+				values = make(map[string]int)
+				counts[key] = values
+			}
+			values[s]++
+		}
+	}
+
+	facets := make([]models.FacetStats, 0, len(counts))
+	for _, key := range slices.Sorted(maps.Keys(counts)) {
+		facet := models.FacetStats{Key: key, Distinct: len(counts[key]), Values: []models.FacetValue{}}
+		for value, n := range counts[key] {
+			facet.Values = append(facet.Values, models.FacetValue{Value: value, Documents: n})
+		}
+		slices.SortFunc(facet.Values, func(a, b models.FacetValue) int {
+			return cmp.Or(cmp.Compare(b.Documents, a.Documents), cmp.Compare(a.Value, b.Value))
+		})
+		if len(facet.Values) > maxFacetValues {
+			facet.Values = facet.Values[:maxFacetValues]
+		}
+		facets = append(facets, facet)
+	}
+	return facets
+}
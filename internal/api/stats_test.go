@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/metering"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGetStats(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, _ := storage.NewInMemoryVectorStore("")
+	server := newTestServer(embedder, store, llmClient, permService)
+	meter := metering.NewMeter()
+	WithMeter(meter)(server)
+	server.mux = http.NewServeMux()
+	server.setupRoutes()
+	handler := server.GetHandler()
+
+	docs := []*models.Document{
+		{Title: "Return 2023", Content: "Refund", Metadata: map[string]interface{}{"taxpayer": "John Doe", "year": float64(2023)}, Embedding: []float32{0.1, 0.2, 0.3}},
+		{Title: "Return 2024", Content: "Refund", Metadata: map[string]interface{}{"taxpayer": "John Doe", "year": float64(2024)}, Embedding: []float32{0.1, 0.2, 0.3}},
+		{Title: "Invoice", Content: "Due", Metadata: map[string]interface{}{"taxpayer": "Jane Roe", "tags": []interface{}{"q1"}}, Embedding: []float32{0.1, 0.2, 0.3}},
+	}
+	for _, doc := range docs {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Content: "Other tenant", Embedding: []float32{0.1, 0.2, 0.3}})
+	_ = permService.Grant(context.Background(), permissions.Tuple{Subject: "alice", Relation: permissions.RelationViewer, DocumentID: docs[0].ID})
+	_ = permService.AddMember(context.Background(), "accounting-team", "bob")
+	permService.SetDocumentAccess("alice", docs[2].ID.String(), false)
+	meter.Record("default", "alice", &models.QueryUsage{PromptTokens: 100}, false)
+
+	// Statistics name users and metadata values, so only admins may read them
+	permService.SetCanWrite("alice", false)
+	if w := serveAs(handler, http.MethodGet, "/admin/stats", nil, "alice"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w := serveAs(handler, http.MethodGet, "/admin/stats?user=carol", nil, adminUsername)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var stats models.StatsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if stats.Tenant != "default" || stats.Documents != (models.Usage{Documents: 3, ContentBytes: 15}) {
+		t.Errorf("Expected the tenant's documents, got %s %+v", stats.Tenant, stats.Documents)
+	}
+	if stats.Embedding.Dimensions != 3 {
+		t.Errorf("Expected the dimensions of the stored vectors, got %+v", stats.Embedding)
+	}
+
+	// List values such as tags are not counted
+	if len(stats.Facets) != 2 || stats.Facets[0].Key != "taxpayer" || stats.Facets[1].Key != "year" {
+		t.Fatalf("Expected facets taxpayer and year, got %+v", stats.Facets)
+	}
+	taxpayers := []models.FacetValue{{Value: "John Doe", Documents: 2}, {Value: "Jane Roe", Documents: 1}}
+	if facet := stats.Facets[0]; facet.Distinct != 2 || !slices.Equal(facet.Values, taxpayers) {
+		t.Errorf("Expected taxpayers by frequency, got %+v", facet)
+	}
+
+	users := []models.UserAccessStats{{User: "alice", AccessibleDocuments: 2}, {User: "bob", AccessibleDocuments: 3}, {User: "carol", AccessibleDocuments: 3}}
+	if !slices.Equal(stats.Users, users) {
+		t.Errorf("Expected %+v, got %+v", users, stats.Users)
+	}
+	if q := stats.Queries; q == nil || q.Queries != 1 || q.PromptTokens != 100 || q.Users != 1 {
+		t.Errorf("Expected the tenant's query volume, got %+v", q)
+	}
+	if r := stats.Relations; r == nil || r.Tuples != 1 || r.ByRelation[permissions.RelationViewer] != 1 || r.Memberships != 1 {
+		t.Errorf("Expected the relation counts, got %+v", r)
+	}
+
+	// Facets can be limited to given keys
+	w = serveAs(handler, http.MethodGet, "/admin/stats?facet=year", nil, adminUsername)
+	stats = models.StatsResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &stats)
+	if len(stats.Facets) != 1 || stats.Facets[0].Key != "year" || stats.Facets[0].Distinct != 2 {
+		t.Errorf("Expected only the year facet, got %+v", stats.Facets)
+	}
+}
+
+// tenantWriters lets users write only in the given tenant
+type tenantWriters struct {
+	*MockPermissionService
+	tenant string
+}
+
+func (p tenantWriters) CanWriteDocuments(ctx context.Context, principal permissions.Principal) bool {
+	return tenant.FromContext(ctx) == p.tenant && p.MockPermissionService.CanWriteDocuments(ctx, principal)
+}
+
+func TestGetStatsStorageBytes(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "stats.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	_ = store.AddDocument(&models.Document{Title: "Return", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}})
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Content: "Other tenant", Embedding: []float32{0.1, 0.2, 0.3}})
+
+	statsOf := func(perms permissions.PermissionChecker, tenantID string) models.StatsResponse {
+		t.Helper()
+		req := createAuthenticatedRequest(http.MethodGet, "/admin/stats", nil, "alice")
+		req.Header.Set("Authorization", "Bearer alice")
+		req.Header.Set(tenant.Header, tenantID)
+		w := serveRequest(newTestServer(embedder, store, llmClient, perms).GetHandler(), req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var stats models.StatsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return stats
+	}
+
+	// The database file holds every tenant, so its size is for server admins
+	if stats := statsOf(permService, tenant.Default); stats.StorageBytes == 0 {
+		t.Error("Expected the storage size for a server admin")
+	}
+	if stats := statsOf(tenantWriters{permService, "acme"}, "acme"); stats.StorageBytes != 0 || stats.Documents.Documents != 1 {
+		t.Errorf("Expected acme's documents without the storage size, got %+v", stats)
+	}
+}
+
+func TestGetDocumentStats(t *testing.T) {
+	server, embedder, _, llmClient, permService := createTestServer()
+	handler := server.GetHandler()
+	// Stores that cannot aggregate report it
+	if w := serveAs(handler, http.MethodGet, "/documents/stats", nil, "alice"); w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusNotImplemented, w.Code, w.Body.String())
+	}
+
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "stats.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	handler = newTestServer(embedder, store, llmClient, permService).GetHandler()
+
+	docs := []*models.Document{
+		{Title: "Return 2023", Content: "Refund", Metadata: map[string]interface{}{"type": "1040", "year": float64(2023), "refund": 1200.5}},
+		{Title: "Return 2022", Content: "Refund", Metadata: map[string]interface{}{"type": "1040", "year": float64(2022), "refund": 800.0}},
+		{Title: "Return 2022, amended", Content: "Refund", Metadata: map[string]interface{}{"type": "1040", "year": float64(2022), "refund": 300.0}},
+		{Title: "Secret return", Content: "Refund", Metadata: map[string]interface{}{"type": "1040", "year": float64(2023), "refund": 9999.0}},
+		{Title: "Corporate return", Content: "Refund", Metadata: map[string]interface{}{"type": "1120", "year": float64(2023), "refund": 50.0}},
+	}
+	for _, doc := range docs {
+		doc.Embedding = []float32{0.1, 0.2, 0.3}
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	permService.SetDocumentAccess("alice", docs[3].ID.String(), false)
+
+	w := serveAs(handler, http.MethodGet, "/documents/stats?group_by=year&sum=refund&metadata.type=1040", nil, "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var stats models.DocumentStatsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	// The inaccessible return is neither counted nor summed
+	if stats.User != "alice" || stats.Documents != 3 || stats.Sums["refund"] != 2300.5 {
+		t.Errorf("Expected alice's three 1040 returns, got %+v", stats)
+	}
+	if len(stats.Groups) != 2 || stats.Groups[0].Value != float64(2022) || stats.Groups[0].Documents != 2 || stats.Groups[0].Sums["refund"] != 1100 {
+		t.Errorf("Expected the refunds by year, most documents first, got %+v", stats.Groups)
+	}
+
+	if w := serveAs(handler, http.MethodGet, "/documents/stats?sum=refund", nil, "bob"); !strings.Contains(w.Body.String(), `"documents":5`) || strings.Contains(w.Body.String(), `"groups"`) {
+		t.Errorf("Expected totals without groups, got %s", w.Body.String())
+	}
+	if w := serveAs(handler, http.MethodGet, "/documents/stats?group_by=tax-year", nil, "alice"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid key, got %d", http.StatusBadRequest, w.Code)
+	}
+}
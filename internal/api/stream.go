@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/ragservice"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"strings"
+	"time"
+)
+
+// Server-sent event names of streamed queries
+const (
+	eventDelta = "delta" // a piece of the answer
+	eventDone  = "done"  // the complete QueryResponse
+	eventError = "error" // generation failed or timed out after streaming started
+)
+
+// wantsEventStream reports whether the client asked for a streamed answer
+func wantsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// eventWriter writes server-sent events. The response status and headers are
+// only sent with the first event, so failures before any output can still be
+// reported as regular JSON errors.
+type eventWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func (e *eventWriter) send(name string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if !e.started {
+		header := e.w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no")
+		e.w.WriteHeader(http.StatusOK)
+		e.started = true
+	}
+	if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", name, payload); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+// streamQuery answers a query as server-sent events: delta events carry
+// pieces of the answer and a final done event carries the same body as a
+// regular query response. Cached answers and answers without sources are
+// sent as a single delta.
+func (s *Server) streamQuery(w http.ResponseWriter, r *http.Request, req *models.QueryRequest, timeout time.Duration) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writer.WriteError(w, r, errNotImplemented.WithReason("Streaming is not supported by this connection"))
+		return
+	}
+	events := &eventWriter{w: w, flusher: flusher}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	response, err := s.rag.Query(r.Context(), principal.Principal, req, ragservice.QueryOptions{
+		Stream: func(delta string) {
+			if err := events.send(eventDelta, models.StreamDelta{Text: delta}); err != nil {
+				requestid.Logf(r.Context(), "Failed to stream answer: %v", err)
+			}
+		},
+		Timeout: timeout,
+	})
+	switch {
+	case err != nil && !events.started:
+		s.writeServiceError(w, r, err, "")
+	case err != nil:
+		requestid.Logf(r.Context(), "Streamed generation failed: %v", err)
+		message := "Failed to generate answer"
+		var timeout *ragservice.TimeoutError
+		if errors.As(err, &timeout) {
+			message = errQueryTimeout.ErrorField
+		}
+		_ = events.send(eventError, models.ErrorResponse{Error: message})
+	case !events.started:
+		s.streamResponse(w, r, response)
+	default:
+		_ = events.send(eventDone, response)
+	}
+}
+
+// streamResponse sends a complete response, such as a cached one, as a single
+// delta followed by done
+func (s *Server) streamResponse(w http.ResponseWriter, r *http.Request, response *models.QueryResponse) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writer.Write(w, r, response)
+		return
+	}
+	events := &eventWriter{w: w, flusher: flusher}
+	_ = events.send(eventDelta, models.StreamDelta{Text: response.Answer})
+	_ = events.send(eventDone, response)
+}
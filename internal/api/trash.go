@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/ory/herodot"
+)
+
+// listTrash returns a page of the tenant's deleted documents that have not
+// been purged yet. It accepts the parameters of GET /documents and requires
+// the write relation on the corpus, since trashed documents are no longer
+// checked per document.
+func (s *Server) listTrash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid query parameters").WithError(err.Error()))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	docs, err := s.rag.Trash(r.Context(), principal.Principal, opts)
+	if err != nil {
+		s.writeServiceError(w, r, err, "")
+		return
+	}
+
+	response := &models.DocumentListResponse{
+		Documents: docs,
+		Count:     len(docs),
+		User:      principal.Username,
+	}
+	if len(docs) == opts.Limit {
+		offset := opts.Offset + len(docs)
+		response.NextOffset = &offset
+	}
+	s.writer.Write(w, r, response)
+}
+
+// restoreDocument moves a deleted document out of the trash. Like deleting,
+// it requires the editor relation on the document.
+func (s *Server) restoreDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	docID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error()))
+		return
+	}
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	if err := s.rag.Restore(r.Context(), principal.Principal, docID); err != nil {
+		s.writeServiceError(w, r, err, "deleted document "+docID.String())
+		return
+	}
+
+	response := &models.DocumentResponse{
+		ID:      docID.String(),
+		Message: "Document restored successfully",
+	}
+	s.writer.Write(w, r, response)
+}
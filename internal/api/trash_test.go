@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDeleteMovesDocumentToTrash(t *testing.T) {
+	_, embedder, _, llmClient, permService := createTestServer()
+	store, _ := storage.NewInMemoryVectorStore("")
+	server := newTestServer(embedder, store, llmClient, permService)
+	doc := &models.Document{Title: "Return", Content: "Refund", Embedding: []float32{0.1, 0.2}}
+	_ = store.AddDocument(doc)
+
+	req := createAuthenticatedRequest(http.MethodDelete, "/documents/"+doc.ID.String(), nil, adminUsername)
+	req.SetPathValue("id", doc.ID.String())
+	w := httptest.NewRecorder()
+	server.deleteDocument(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if _, err := store.GetDocument(doc.ID); !errors.Is(err, storage.ErrDocumentNotFound) {
+		t.Errorf("Expected the deleted document to be hidden, got %v", err)
+	}
+
+	// Only admins see the trash
+	w = httptest.NewRecorder()
+	server.listTrash(w, createAuthenticatedRequest(http.MethodGet, "/documents/trash", nil, adminUsername))
+	var list models.DocumentListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if w.Code != http.StatusOK || list.Count != 1 || list.Documents[0].ID != doc.ID || list.Documents[0].DeletedAt == nil {
+		t.Fatalf("Expected the deleted document in the trash, got %d %s", w.Code, w.Body.String())
+	}
+	permService.SetCanWrite("alice", false)
+	w = serveAs(server.GetHandler(), http.MethodGet, "/documents/trash", nil, "alice")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d for a non-admin, got %d", http.StatusForbidden, w.Code)
+	}
+
+	restore := func() *httptest.ResponseRecorder {
+		req := createAuthenticatedRequest(http.MethodPost, "/documents/"+doc.ID.String()+"/restore", nil, adminUsername)
+		req.SetPathValue("id", doc.ID.String())
+		w := httptest.NewRecorder()
+		server.restoreDocument(w, req)
+		return w
+	}
+	if w := restore(); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if _, err := store.GetDocument(doc.ID); err != nil {
+		t.Errorf("Expected the restored document to be readable, got %v", err)
+	}
+	if w := restore(); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d restoring a live document, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestTrashNotImplemented(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+
+	w := httptest.NewRecorder()
+	server.listTrash(w, createAuthenticatedRequest(http.MethodGet, "/documents/trash", nil, adminUsername))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d for a store without trash, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+// brokenTrash fails every trash operation with a database error
+type brokenTrash struct {
+	*MockVectorStore
+}
+
+func (s *brokenTrash) ForTenant(string) storage.VectorStore { return s }
+
+func (s *brokenTrash) TrashDocument(uuid.UUID) error { return errNoSuchTable }
+
+func (s *brokenTrash) ListTrash(storage.ListOptions) ([]models.Document, error) {
+	return nil, errNoSuchTable
+}
+
+func (s *brokenTrash) RestoreDocument(uuid.UUID) error { return errNoSuchTable }
+
+func (s *brokenTrash) PurgeTrash(time.Time) ([]models.Document, error) { return nil, errNoSuchTable }
+
+var errNoSuchTable = errors.New("no such table: documents_trash")
+
+func TestTrashWithholdsDatabaseErrors(t *testing.T) {
+	_, embedder, vectorStore, llmClient, permService := createTestServer()
+	handler := newTestServer(embedder, &brokenTrash{vectorStore}, llmClient, permService).GetHandler()
+
+	for _, req := range []struct{ method, url string }{
+		{http.MethodGet, "/documents/trash"},
+		{http.MethodPost, "/documents/" + uuid.NewString() + "/restore"},
+	} {
+		w := serveAs(handler, req.method, req.url, nil, adminUsername)
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "Database operation failed") || strings.Contains(w.Body.String(), "no such table") {
+			t.Errorf("Expected %s %s to report a withheld database error, got %d %s", req.method, req.url, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/blob"
+	"rerag-rbac-rag-llm/internal/extract"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ory/herodot"
+)
+
+// Upload metadata keys
+const (
+	metadataFilename = "filename"
+	metadataMIMEType = "mime_type"
+)
+
+// uploadDocument ingests a file sent as the "file" field of a multipart form.
+// Its text is extracted, split into chunks, and stored as one document per
+// chunk carrying the original filename and MIME type as metadata. An optional
+// "title" field overrides the title found in the file. With WithOriginals, the
+// file itself is kept and its digest recorded on every chunk.
+func (s *Server) uploadDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.uploadLimit)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("File too large").WithErrorf("uploads are limited to %d bytes", s.uploadLimit))
+			return
+		}
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Expected a multipart form with a file field").WithError(err.Error()))
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Failed to read uploaded file").WithError(err.Error()))
+		return
+	}
+
+	filename := filepath.Base(header.Filename)
+	extracted, err := extract.Extract(filename, header.Header.Get("Content-Type"), data)
+	if err != nil {
+		if errors.Is(err, extract.ErrUnsupportedFormat) {
+			s.writer.WriteError(w, r, herodot.ErrUnsupportedMediaType.WithReason("Unsupported file").WithError(err.Error()))
+			return
+		}
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Failed to extract text").WithError(err.Error()))
+		return
+	}
+
+	title := strings.TrimSpace(r.FormValue("title"))
+	if title == "" {
+		title = extracted.Title
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+
+	metadata := map[string]interface{}{
+		metadataFilename: filename,
+		metadataMIMEType: extracted.ContentType,
+	}
+	if s.originals != nil {
+		digest, err := s.originals.Put(r.Context(), data)
+		if err != nil {
+			s.writer.WriteError(w, r, upstreamError(err, "Failed to store the original file"))
+			return
+		}
+		metadata[blob.MetadataDigest] = digest
+	}
+
+	// Every chunk is a document of its own
+	docs, err := s.rag.IngestSource(r.Context(), principal.Principal, ingest.Source{
+		Title:    title,
+		Text:     extracted.Text,
+		Metadata: metadata,
+	})
+	if err != nil {
+		if len(docs) == 0 {
+			s.writeServiceError(w, r, err, "")
+			return
+		}
+		s.writer.WriteError(w, r, herodot.ErrInternalServerError.WithReason("Failed to store all chunks").WithErrorf("%d chunks were stored before: %v", len(docs), err))
+		return
+	}
+
+	response := &models.UploadResponse{
+		Filename:    filename,
+		ContentType: extracted.ContentType,
+		SourceID:    fmt.Sprint(docs[0].Metadata[ingest.MetadataSourceID]),
+		DocumentIDs: make([]string, len(docs)),
+		Message:     fmt.Sprintf("File ingested as %d document(s)", len(docs)),
+	}
+	for i := range docs {
+		response.DocumentIDs[i] = docs[i].ID.String()
+	}
+	s.writer.WriteCreated(w, r, "", response)
+}
+
+// getOriginal downloads the file a document was extracted from. It requires
+// the access reading the document does; documents without a kept original
+// get 404.
+func (s *Server) getOriginal(w http.ResponseWriter, r *http.Request) {
+	requestID := requestid.FromContext(r.Context())
+
+	docID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.writer.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid document ID").WithError(err.Error()))
+		return
+	}
+	principal, ok := s.principal(w, r)
+	if !ok {
+		return
+	}
+	doc, err := s.rag.Get(r.Context(), principal.Principal, docID)
+	if err != nil {
+		s.writeServiceError(w, r, err, "document "+docID.String())
+		return
+	}
+
+	digest, _ := doc.Metadata[blob.MetadataDigest].(string)
+	if digest == "" {
+		s.errHandler.HandleNotFoundError(w, r, "original file of document "+docID.String(), requestID)
+		return
+	}
+	data, err := s.originals.Get(r.Context(), digest)
+	if errors.Is(err, blob.ErrNotFound) {
+		s.errHandler.HandleNotFoundError(w, r, "original file of document "+docID.String(), requestID)
+		return
+	}
+	if err != nil {
+		s.writer.WriteError(w, r, upstreamError(err, "Failed to read the original file"))
+		return
+	}
+
+	filename, _ := doc.Metadata[metadataFilename].(string)
+	contentType, _ := doc.Metadata[metadataMIMEType].(string)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// The content never changes, so the digest is a strong ETag
+	w.Header().Set("ETag", `"`+digest+`"`)
+	http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(data))
+}
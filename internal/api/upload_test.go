@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/blob"
+	"rerag-rbac-rag-llm/internal/config"
+	apperrors "rerag-rbac-rag-llm/internal/errors"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"strings"
+	"testing"
+)
+
+// createUploadRequest builds an authenticated multipart upload of a single file
+func createUploadRequest(t *testing.T, filename, contentType, content, title, username string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if title != "" {
+		_ = form.WriteField("title", title)
+	}
+
+	header := make(map[string][]string)
+	header["Content-Disposition"] = []string{`form-data; name="file"; filename="` + filename + `"`}
+	header["Content-Type"] = []string{contentType}
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write([]byte(content))
+	_ = form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/documents/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req.WithContext(auth.NewContext(req.Context(), auth.Principal{Principal: permissions.Principal{Username: username}, Method: auth.AuthMethodUser}))
+}
+
+func TestUploadDocumentChunksFile(t *testing.T) {
+	server, _, vectorStore, _, _ := createTestServer()
+	WithIngestion(100, 0, defaultUploadLimit)(server)
+
+	paragraph := strings.Repeat("Refunds are processed within ten days. ", 2)
+	content := "# Refund Policy\n\n" + paragraph + "\n\n" + paragraph + "\n\n" + paragraph
+	w := httptest.NewRecorder()
+	server.uploadDocument(w, createUploadRequest(t, "refunds.md", "application/octet-stream", content, "", adminUsername))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	var response models.UploadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response.ContentType != "text/markdown" || len(response.DocumentIDs) < 2 {
+		t.Fatalf("Expected a chunked Markdown upload, got %+v", response)
+	}
+	if len(vectorStore.documents) != len(response.DocumentIDs) {
+		t.Errorf("Expected %d stored documents, got %d", len(response.DocumentIDs), len(vectorStore.documents))
+	}
+
+	for _, doc := range vectorStore.documents {
+		if doc.Metadata["filename"] != "refunds.md" || doc.Metadata["mime_type"] != "text/markdown" || doc.Metadata["source_id"] != response.SourceID {
+			t.Errorf("Expected upload metadata, got %v", doc.Metadata)
+		}
+		if !strings.HasPrefix(doc.Title, "Refund Policy (part ") {
+			t.Errorf("Expected the Markdown heading as title, got %q", doc.Title)
+		}
+	}
+}
+
+func TestUploadDocumentTitleOverride(t *testing.T) {
+	server, _, vectorStore, _, _ := createTestServer()
+
+	w := httptest.NewRecorder()
+	server.uploadDocument(w, createUploadRequest(t, "notes.txt", "text/plain", "Short note.", "Custom", adminUsername))
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	for _, doc := range vectorStore.documents {
+		if doc.Title != "Custom" || doc.Content != "Short note." {
+			t.Errorf("Expected a single document titled Custom, got %q: %q", doc.Title, doc.Content)
+		}
+	}
+}
+
+func TestUploadDocumentRejectsInvalidUploads(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		mimeType string
+		content  string
+		username string
+		canWrite bool
+		want     int
+	}{
+		{"unsupported format", "image.png", "image/png", "\x89PNG\r\n\x1a\n", adminUsername, true, http.StatusUnsupportedMediaType},
+		{"empty text", "empty.txt", "text/plain", "  \n ", adminUsername, true, http.StatusBadRequest},
+		{"no write permission", "notes.txt", "text/plain", "text", "alice", false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _, vectorStore, _, permService := createTestServer()
+			permService.SetCanWrite(tt.username, tt.canWrite)
+
+			w := httptest.NewRecorder()
+			server.uploadDocument(w, createUploadRequest(t, tt.filename, tt.mimeType, tt.content, "", tt.username))
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if len(vectorStore.documents) != 0 {
+				t.Errorf("Expected no documents to be stored, got %d", len(vectorStore.documents))
+			}
+		})
+	}
+}
+
+func TestUploadDocumentTooLarge(t *testing.T) {
+	server, _, _, _, _ := createTestServer()
+	server.uploadLimit = 64
+
+	w := httptest.NewRecorder()
+	server.uploadDocument(w, createUploadRequest(t, "large.txt", "text/plain", strings.Repeat("x", 1024), "", adminUsername))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestUploadKeepsOriginalFile(t *testing.T) {
+	_, embedder, vectorStore, llmClient, permService := createTestServer()
+	originals, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(embedder, vectorStore, llmClient, permService, apperrors.NewErrorHandler(&config.Config{}),
+		WithIngestion(30, 0, defaultUploadLimit), WithOriginals(originals))
+	handler := server.GetHandler()
+
+	content := "# Refund Policy\n\nRefunds are processed within ten days.\n\nRefunds need a receipt."
+	w := httptest.NewRecorder()
+	server.uploadDocument(w, createUploadRequest(t, "refunds.md", "text/markdown", content, "", adminUsername))
+	var response models.UploadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || len(response.DocumentIDs) < 2 {
+		t.Fatalf("Expected a chunked upload, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, doc := range vectorStore.documents {
+		if doc.Metadata[blob.MetadataDigest] != blob.Digest([]byte(content)) {
+			t.Errorf("Expected every chunk to reference the original, got %v", doc.Metadata)
+		}
+	}
+
+	// Every chunk downloads the same original
+	for _, id := range response.DocumentIDs {
+		w := serveAs(handler, http.MethodGet, "/documents/"+id+"/original", nil, "alice")
+		if w.Code != http.StatusOK || w.Body.String() != content {
+			t.Fatalf("Expected the original file, got %d: %s", w.Code, w.Body.String())
+		}
+		if w.Header().Get("Content-Type") != "text/markdown" || w.Header().Get("Content-Disposition") != `attachment; filename=refunds.md` {
+			t.Errorf("Expected the original type and filename, got %v", w.Header())
+		}
+	}
+
+	// Reading the original needs access to the document
+	permService.SetDocumentAccess("bob", response.DocumentIDs[0], false)
+	if w := serveAs(handler, http.MethodGet, "/documents/"+response.DocumentIDs[0]+"/original", nil, "bob"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without access, got %d", w.Code)
+	}
+
+	// Documents not created from an upload have no original
+	doc := &models.Document{Title: "Note", Content: "Typed in"}
+	_ = vectorStore.AddDocument(doc)
+	if w := serveAs(handler, http.MethodGet, "/documents/"+doc.ID.String()+"/original", nil, "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a document without an original, got %d", w.Code)
+	}
+}
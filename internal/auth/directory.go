@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+)
+
+// DirectoryAuthenticator takes the username from an "Authorization: Bearer
+// <username>" header like BearerAuthenticator, but only admits users of the
+// request tenant's directory and adds their groups and roles. It is meant for
+// the mock auth mode.
+type DirectoryAuthenticator struct {
+	store storage.DirectoryStore
+}
+
+// NewDirectoryAuthenticator returns an authenticator for the users in store
+func NewDirectoryAuthenticator(store storage.DirectoryStore) *DirectoryAuthenticator {
+	return &DirectoryAuthenticator{store: store}
+}
+
+// Authenticate looks up the bearer token's user in the directory
+func (d *DirectoryAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	identity, err := BearerAuthenticator{}.Authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := d.store.ForTenant(tenant.FromContext(r.Context())).GetUser(identity.Username)
+	if errors.Is(err, storage.ErrDirectoryUserNotFound) {
+		return nil, &CredentialsError{Message: "Unknown user"}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up directory user: %w", err)
+	}
+	return &Identity{Username: user.Username, Groups: user.Groups, Roles: user.Roles}, nil
+}
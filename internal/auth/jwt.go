@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hmacHashes maps the supported HMAC JWS algorithms to their hash
+var hmacHashes = map[string]func() hash.Hash{
+	"HS256": sha256.New, "HS384": sha512.New384, "HS512": sha512.New,
+}
+
+// JWTAuthenticator authenticates bearer JWTs signed with shared HMAC secrets.
+// Several keys can be valid at once, selected by the token's kid header, so
+// a new key can be rolled out before tokens signed with the old one expire.
+type JWTAuthenticator struct {
+	now func() time.Time
+
+	mu   sync.RWMutex
+	keys map[string][]byte
+}
+
+// NewJWTAuthenticator creates an authenticator accepting tokens signed with
+// keys, indexed by key ID. The key with the empty ID verifies tokens without
+// a kid header.
+func NewJWTAuthenticator(keys map[string][]byte) *JWTAuthenticator {
+	return &JWTAuthenticator{now: time.Now, keys: keys}
+}
+
+// SetKeys replaces the signing keys, e.g. after a rotation
+func (j *JWTAuthenticator) SetKeys(keys map[string][]byte) {
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+}
+
+// ParseJWTKeys builds the keys of a JWTAuthenticator from the secret for
+// tokens without a kid and a keyring of "kid=secret" entries separated by
+// commas or newlines
+func ParseJWTKeys(secret, keyring string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	if secret != "" {
+		keys[""] = []byte(secret)
+	}
+	for _, entry := range strings.FieldsFunc(keyring, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, key, ok := strings.Cut(entry, "=")
+		kid, key = strings.TrimSpace(kid), strings.TrimSpace(key)
+		if !ok || kid == "" || key == "" {
+			return nil, errors.New("JWT keys must be \"kid=secret\" entries")
+		}
+		if _, ok := keys[kid]; ok {
+			return nil, fmt.Errorf("duplicate JWT key ID %q", kid)
+		}
+		keys[kid] = []byte(key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no JWT signing key configured")
+	}
+	return keys, nil
+}
+
+// Authenticate returns the identity in the sub, groups, roles, and tenant
+// claims of the request's bearer token
+func (j *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, &CredentialsError{Message: "Missing bearer token"}
+	}
+
+	claims, err := j.verify(token)
+	if err == nil {
+		err = checkValidity(claims, j.now(), false)
+	}
+	if err != nil {
+		requestid.Logf(r.Context(), "Rejected access token: %v", err)
+		return nil, errInvalidToken
+	}
+
+	username, _ := claims["sub"].(string)
+	if username == "" {
+		requestid.Logf(r.Context(), "Rejected access token: token has no sub claim")
+		return nil, errInvalidToken
+	}
+	tenantID, err := tenantClaim(claims["tenant"])
+	if err != nil {
+		requestid.Logf(r.Context(), "Rejected access token: %v", err)
+		return nil, errInvalidToken
+	}
+	return &Identity{
+		Username: username,
+		Groups:   stringsClaim(claims["groups"]),
+		Roles:    stringsClaim(claims["roles"]),
+		Claims:   claims,
+		Tenant:   tenantID,
+	}, nil
+}
+
+// verify checks the signature of a JWT with the key its kid names and
+// returns its claims
+func (j *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header jwtHeader
+	var claims map[string]interface{}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || decodeSegment(parts[0], &header) != nil || decodeSegment(parts[1], &claims) != nil {
+		return nil, errors.New("malformed token")
+	}
+
+	newHash, ok := hmacHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	j.mu.RLock()
+	key, ok := j.keys[header.Kid]
+	j.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", header.Kid)
+	}
+
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return nil, errors.New("invalid signature")
+	}
+	return claims, nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// signHS256 issues a JWT with the given claims signed with key
+func signHS256(kid string, key string, claims map[string]interface{}) string {
+	header := map[string]string{"alg": "HS256", "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	headerJSON, _ := json.Marshal(header)
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTAuthenticatorAcceptsEveryConfiguredKey(t *testing.T) {
+	keys, err := ParseJWTKeys("legacy-secret", "2026-01=old-secret,\n2026-07 = new-secret")
+	if err != nil {
+		t.Fatalf("ParseJWTKeys failed: %v", err)
+	}
+	a := NewJWTAuthenticator(keys)
+	claims := map[string]interface{}{"sub": "alice", "exp": time.Now().Unix() + 300, "groups": []string{"accounting-team"}}
+
+	for _, token := range []string{
+		signHS256("", "legacy-secret", claims),
+		signHS256("2026-01", "old-secret", claims),
+		signHS256("2026-07", "new-secret", claims),
+	} {
+		status, user, groups := authenticate(a, token)
+		if status != http.StatusOK || user != "alice" || !slices.Equal(groups, []string{"accounting-team"}) {
+			t.Errorf("Expected alice in accounting-team, got %d %q %v", status, user, groups)
+		}
+	}
+
+	rejected := map[string]string{
+		"key of another kid":  signHS256("2026-07", "old-secret", claims),
+		"unknown kid":         signHS256("2025-01", "old-secret", claims),
+		"expired":             signHS256("2026-07", "new-secret", map[string]interface{}{"sub": "alice", "exp": time.Now().Unix() - 300}),
+		"no subject":          signHS256("2026-07", "new-secret", map[string]interface{}{"exp": time.Now().Unix() + 300}),
+		"unsigned":            "eyJhbGciOiJub25lIn0.eyJzdWIiOiJhbGljZSJ9.",
+		"not a JWT":           "alice",
+		"missing credentials": "",
+		"invalid tenant":      signHS256("2026-07", "new-secret", map[string]interface{}{"sub": "alice", "exp": time.Now().Unix() + 300, "tenant": "Not Valid!"}),
+	}
+	for name, token := range rejected {
+		if status, _, _ := authenticate(a, token); status != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for %s, got %d", name, status)
+		}
+	}
+
+	// Retired keys stop verifying once the keys are replaced
+	keys, _ = ParseJWTKeys("", "2026-07=new-secret")
+	a.SetKeys(keys)
+	if status, _, _ := authenticate(a, signHS256("2026-01", "old-secret", claims)); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a retired key, got %d", status)
+	}
+	if status, _, _ := authenticate(a, signHS256("2026-07", "new-secret", claims)); status != http.StatusOK {
+		t.Errorf("Expected 200 for the current key, got %d", status)
+	}
+}
+
+func TestJWTAuthenticatorReadsTenantClaim(t *testing.T) {
+	keys, _ := ParseJWTKeys("secret", "")
+	a := NewJWTAuthenticator(keys)
+	req := httptest.NewRequest(http.MethodGet, "/query", nil)
+	req.Header.Set("Authorization", "Bearer "+signHS256("", "secret", map[string]interface{}{"sub": "alice", "exp": time.Now().Unix() + 300, "tenant": "acme"}))
+	identity, err := a.Authenticate(req)
+	if err != nil || identity.Tenant != "acme" {
+		t.Errorf("Expected alice bound to acme, got %+v (%v)", identity, err)
+	}
+}
+
+func TestParseJWTKeysRejectsInvalidKeyrings(t *testing.T) {
+	for _, keyring := range []string{"", "no-separator", "=secret", "kid=", "a=1,a=2"} {
+		if _, err := ParseJWTKeys("", keyring); err == nil {
+			t.Errorf("Expected keyring %q to be rejected", keyring)
+		}
+	}
+}
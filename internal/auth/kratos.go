@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"strings"
+)
+
+// SessionTokenHeader carries Ory Kratos session tokens of API clients
+const SessionTokenHeader = "X-Session-Token"
+
+// KratosAuthenticator validates Ory Kratos sessions with the whoami endpoint
+// of the public API. Browsers are authenticated by their session cookie and
+// API clients by the X-Session-Token header.
+type KratosAuthenticator struct {
+	whoamiURL   string
+	trait       string
+	groupsTrait string
+	rolesTrait  string
+	tenantTrait string
+	client      *httpclient.Client
+}
+
+// NewKratosAuthenticator creates an authenticator for the Kratos public API
+// at publicURL. The username used for permission checks is taken from the
+// identity trait at the dot-separated path trait, e.g. "email" or
+// "name.username"; an empty trait uses the identity ID.
+func NewKratosAuthenticator(publicURL, trait string, client *httpclient.Client) *KratosAuthenticator {
+	return &KratosAuthenticator{
+		whoamiURL: strings.TrimSuffix(publicURL, "/") + "/sessions/whoami",
+		trait:     trait,
+		client:    client,
+	}
+}
+
+// SetClaimTraits takes the user's groups and roles from the identity traits
+// at the dot-separated paths groups and roles, each holding a string or an
+// array of strings. An empty path ignores groups or roles.
+func (k *KratosAuthenticator) SetClaimTraits(groups, roles string) {
+	k.groupsTrait = groups
+	k.rolesTrait = roles
+}
+
+// SetTenantTrait binds users to the tenant in the identity trait at the
+// dot-separated path trait. Identities without it, or an empty path, let the
+// X-Tenant-ID header select the tenant.
+func (k *KratosAuthenticator) SetTenantTrait(trait string) {
+	k.tenantTrait = trait
+}
+
+// kratosSession is the part of a whoami response needed to identify the user
+type kratosSession struct {
+	Active   bool `json:"active"`
+	Identity struct {
+		ID     string                 `json:"id"`
+		Traits map[string]interface{} `json:"traits"`
+	} `json:"identity"`
+}
+
+// Authenticate returns the identity of the request's active Kratos session
+func (k *KratosAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	cookie := r.Header.Get("Cookie")
+	token := r.Header.Get(SessionTokenHeader)
+	if cookie == "" && token == "" {
+		return nil, &CredentialsError{Message: "Missing session"}
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, k.whoamiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set(SessionTokenHeader, token)
+	} else {
+		req.Header.Set("Cookie", cookie)
+	}
+	requestid.SetHeader(r.Context(), req)
+
+	resp, err := k.client.Do(req) // #nosec G107 - URL is built from configuration
+	if err != nil {
+		return nil, fmt.Errorf("kratos whoami failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, &CredentialsError{Message: "Invalid session"}
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("kratos whoami returned status %d", resp.StatusCode)
+	}
+
+	var session kratosSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, fmt.Errorf("failed to decode kratos session: %w", err)
+	}
+	if !session.Active {
+		return nil, &CredentialsError{Message: "Invalid session"}
+	}
+	traits := session.Identity.Traits
+	identity := &Identity{Username: session.Identity.ID, Claims: traits}
+	if k.trait != "" {
+		username, ok := lookupTrait(traits, k.trait)
+		if !ok {
+			return nil, &CredentialsError{Message: "Session identity has no " + k.trait + " trait"}
+		}
+		identity.Username = username
+	}
+	if k.groupsTrait != "" {
+		identity.Groups = stringsClaim(traitValue(traits, k.groupsTrait))
+	}
+	if k.rolesTrait != "" {
+		identity.Roles = stringsClaim(traitValue(traits, k.rolesTrait))
+	}
+	if k.tenantTrait != "" {
+		tenantID, err := tenantClaim(traitValue(traits, k.tenantTrait))
+		if err != nil {
+			return nil, &CredentialsError{Message: "Session identity has an invalid " + k.tenantTrait + " trait"}
+		}
+		identity.Tenant = tenantID
+	}
+	return identity, nil
+}
+
+// lookupTrait returns the non-empty string at the dot-separated path in traits
+func lookupTrait(traits map[string]interface{}, path string) (string, bool) {
+	s, ok := traitValue(traits, path).(string)
+	return s, ok && s != ""
+}
+
+// traitValue returns the value at the dot-separated path in traits, or nil
+func traitValue(traits map[string]interface{}, path string) interface{} {
+	var value interface{} = traits
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"slices"
+	"testing"
+
+	"github.com/ory/herodot"
+)
+
+func TestKratosAuthenticator(t *testing.T) {
+	kratos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sessions/whoami" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		switch {
+		case r.Header.Get("Cookie") == "ory_kratos_session=alice" || r.Header.Get(SessionTokenHeader) == "alice-token":
+			_, _ = io.WriteString(w, `{"active": true, "identity": {"id": "id-alice", "traits": {"email": "alice@example.com", "name": {"username": "alice"}, "org": {"teams": ["payroll", "legal"]}, "role": "auditor"}}}`)
+		case r.Header.Get(SessionTokenHeader) == "expired":
+			_, _ = io.WriteString(w, `{"active": false, "identity": {"id": "id-alice"}}`)
+		case r.Header.Get(SessionTokenHeader) == "outage":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer kratos.Close()
+
+	handler := func(trait string) http.Handler {
+		a := NewKratosAuthenticator(kratos.URL+"/", trait, httpclient.New(httpclient.Options{}))
+		return RequireUser(a, herodot.NewJSONWriter(nil), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := PrincipalFromContext(r.Context())
+			_, _ = io.WriteString(w, principal.Username)
+		}))
+	}
+
+	for name, tc := range map[string]struct {
+		trait, header, value string
+		status               int
+		user                 string
+	}{
+		"cookie":         {"email", "Cookie", "ory_kratos_session=alice", http.StatusOK, "alice@example.com"},
+		"session token":  {"name.username", SessionTokenHeader, "alice-token", http.StatusOK, "alice"},
+		"identity ID":    {"", SessionTokenHeader, "alice-token", http.StatusOK, "id-alice"},
+		"missing trait":  {"phone", SessionTokenHeader, "alice-token", http.StatusUnauthorized, ""},
+		"no session":     {"email", "", "", http.StatusUnauthorized, ""},
+		"invalid":        {"email", SessionTokenHeader, "forged", http.StatusUnauthorized, ""},
+		"inactive":       {"email", SessionTokenHeader, "expired", http.StatusUnauthorized, ""},
+		"kratos failure": {"email", SessionTokenHeader, "outage", http.StatusServiceUnavailable, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/documents", nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		handler(tc.trait).ServeHTTP(w, req)
+		if w.Code != tc.status || (tc.user != "" && w.Body.String() != tc.user) {
+			t.Errorf("%s: expected %d %q, got %d %q", name, tc.status, tc.user, w.Code, w.Body.String())
+		}
+	}
+
+	a := NewKratosAuthenticator(kratos.URL, "email", httpclient.New(httpclient.Options{}))
+	a.SetClaimTraits("org.teams", "role")
+	req := httptest.NewRequest(http.MethodGet, "/documents", nil)
+	req.Header.Set(SessionTokenHeader, "alice-token")
+	identity, err := a.Authenticate(req)
+	if err != nil || !slices.Equal(identity.Groups, []string{"payroll", "legal"}) || !slices.Equal(identity.Roles, []string{"auditor"}) || identity.Claims["email"] != "alice@example.com" {
+		t.Errorf("Expected the groups, roles, and traits of the identity, got %+v (%v)", identity, err)
+	}
+}
@@ -2,48 +2,145 @@
 package auth
 
 import (
-	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/tenant"
 	"strings"
+
+	"github.com/ory/herodot"
 )
 
 type contextKey string
 
-// UserContextKey is the context key for storing the authenticated user
-const UserContextKey contextKey = "user"
+// Identity is an authenticated user
+type Identity struct {
+	// Username is the subject of permission checks
+	Username string
+	// Groups are the groups the identity provider places the user in; checks
+	// grant the relations their members hold as if the user were a member
+	Groups []string
+	// Roles are the roles the identity provider grants the user
+	Roles []string
+	// Claims are the token claims or identity traits the identity was read
+	// from, passed to permission policies
+	Claims map[string]interface{}
+	// Tenant is the tenant the identity provider binds the user to; empty
+	// lets the X-Tenant-ID header select it
+	Tenant string
+}
+
+// Authenticator identifies the user making a request
+type Authenticator interface {
+	// Authenticate returns the identity of the user making r. Missing or
+	// invalid credentials are reported as a *CredentialsError; any other
+	// error means the credentials could not be verified.
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// CredentialsError reports missing or invalid credentials. The message is
+// returned to the client.
+type CredentialsError struct {
+	Message string
+}
+
+func (e *CredentialsError) Error() string {
+	return e.Message
+}
+
+// BearerAuthenticator takes the username from an "Authorization: Bearer
+// <username>" header without verifying it. It is meant for the mock auth mode.
+type BearerAuthenticator struct{}
+
+// Authenticate returns the bearer token as the username
+func (BearerAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, &CredentialsError{Message: "Missing authorization header"}
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, &CredentialsError{Message: "Invalid authorization header format"}
+	}
+
+	username := parts[1]
+	if username == "" {
+		return nil, &CredentialsError{Message: "Invalid username"}
+	}
+	return &Identity{Username: username}, nil
+}
+
+// tenantClaim reads the tenant a claim or trait binds a user to: empty if
+// value is missing, and an error if it is no valid tenant ID
+func tenantClaim(value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	id, ok := value.(string)
+	if !ok || !tenant.IsValid(id) {
+		return "", fmt.Errorf("invalid tenant %v", value)
+	}
+	return id, nil
+}
 
 // Middleware validates Authorization header and adds user to context
 func Middleware(next http.Handler) http.Handler {
+	return RequireUser(BearerAuthenticator{}, herodot.NewJSONWriter(nil), next)
+}
+
+// RequireUser authenticates requests with a and adds the user's Principal,
+// with the groups, roles, and claims of their identity, to the context.
+// Users bound to a tenant act in it, and requests selecting another tenant
+// with the X-Tenant-ID header are rejected with 403; other users act in the
+// tenant of the header. Invalid credentials are rejected with 401 and
+// requests whose credentials cannot be verified with 503, all written with
+// errs.
+func RequireUser(a Authenticator, errs herodot.Writer, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, `{"error": "Missing authorization header"}`, http.StatusUnauthorized)
+		identity, err := a.Authenticate(r)
+		var credentialsErr *CredentialsError
+		if errors.As(err, &credentialsErr) {
+			errs.WriteError(w, r, herodot.ErrUnauthorized.WithReason(credentialsErr.Message))
 			return
 		}
-
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, `{"error": "Invalid authorization header format"}`, http.StatusUnauthorized)
+		if err != nil {
+			requestid.Logf(r.Context(), "Failed to authenticate request: %v", err)
+			errs.WriteError(w, r, errAuthenticationUnavailable)
 			return
 		}
 
-		username := parts[1]
-		if username == "" {
-			http.Error(w, `{"error": "Invalid username"}`, http.StatusUnauthorized)
-			return
+		ctx := r.Context()
+		if identity.Tenant != "" {
+			if requested := r.Header.Get(tenant.Header); requested != "" && requested != identity.Tenant {
+				requestid.Logf(ctx, "Rejected request of %s for tenant %q: bound to tenant %q", identity.Username, requested, identity.Tenant)
+				errs.WriteError(w, r, herodot.ErrForbidden.WithReason("The tenant does not match the authenticated user"))
+				return
+			}
+			ctx = tenant.NewContext(ctx, identity.Tenant)
 		}
 
-		ctx := context.WithValue(r.Context(), UserContextKey, username)
+		ctx = NewContext(ctx, Principal{
+			Principal: permissions.Principal{
+				Username: identity.Username,
+				Groups:   permissions.ValidGroups(identity.Groups),
+				Roles:    identity.Roles,
+				Claims:   identity.Claims,
+			},
+			Tenant:      tenant.FromContext(ctx),
+			TenantBound: identity.Tenant != "",
+			Method:      AuthMethodUser,
+		})
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// GetUserFromContext extracts the authenticated user from the context
-func GetUserFromContext(ctx context.Context) string {
-	user, ok := ctx.Value(UserContextKey).(string)
-	if !ok {
-		panic("user not found in context")
-	}
-
-	return user
+// errAuthenticationUnavailable is returned when credentials could not be verified
+var errAuthenticationUnavailable = herodot.DefaultError{
+	StatusField: http.StatusText(http.StatusServiceUnavailable),
+	ErrorField:  "The identity provider is unavailable, please try again later",
+	ReasonField: "Authentication unavailable",
+	CodeField:   http.StatusServiceUnavailable,
 }
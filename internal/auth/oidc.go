@@ -0,0 +1,455 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clockSkew is tolerated when checking the exp and nbf claims
+	clockSkew = time.Minute
+	// minJWKSRefresh bounds how often an unknown key ID triggers a JWKS fetch
+	minJWKSRefresh = time.Minute
+)
+
+// OIDCOptions configure an OIDCAuthenticator
+type OIDCOptions struct {
+	// Issuer is the expected iss claim. Its discovery document provides the
+	// JWKS URL unless JWKSURL is set.
+	Issuer  string
+	JWKSURL string
+	// Audience is the expected aud claim; empty skips the check
+	Audience string
+	// IntrospectionURL selects RFC 7662 token introspection instead of local
+	// verification; ClientID and ClientSecret authenticate the calls
+	IntrospectionURL string
+	ClientID         string
+	ClientSecret     string
+	// UsernameClaim holds the username used for permission checks, e.g. "sub"
+	UsernameClaim string
+	// GroupsClaim holds the user's groups; empty ignores groups
+	GroupsClaim string
+	// RolesClaim holds the user's roles; empty ignores roles
+	RolesClaim string
+	// TenantClaim holds the tenant the user is bound to; empty, or a token
+	// without it, lets the X-Tenant-ID header select the tenant
+	TenantClaim string
+	// JWKSCacheTTL is how long fetched signing keys are used before refetching
+	JWKSCacheTTL time.Duration
+}
+
+// OIDCAuthenticator authenticates bearer access tokens of an OpenID Connect
+// provider, or of Ory Oathkeeper's id_token mutator. Signed JWTs are verified
+// against the issuer's cached JWKS; with an introspection endpoint any token
+// format is accepted as long as the provider reports it active.
+type OIDCAuthenticator struct {
+	opts   OIDCOptions
+	client *httpclient.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	jwksURL   string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// NewOIDCAuthenticator creates an authenticator calling the provider with client
+func NewOIDCAuthenticator(opts OIDCOptions, client *httpclient.Client) *OIDCAuthenticator {
+	return &OIDCAuthenticator{
+		opts:    opts,
+		client:  client,
+		now:     time.Now,
+		jwksURL: opts.JWKSURL,
+	}
+}
+
+// errInvalidToken is reported to clients for every rejected token, so the
+// reason is only logged
+var errInvalidToken = &CredentialsError{Message: "Invalid access token"}
+
+// Authenticate returns the identity in the claims of the request's access token
+func (o *OIDCAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, &CredentialsError{Message: "Missing bearer token"}
+	}
+
+	var claims map[string]interface{}
+	var err error
+	if o.opts.IntrospectionURL != "" {
+		claims, err = o.introspect(r, token)
+	} else {
+		claims, err = o.verify(r, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := o.identity(claims)
+	if err != nil {
+		requestid.Logf(r.Context(), "Rejected access token: %v", err)
+		return nil, errInvalidToken
+	}
+	return identity, nil
+}
+
+// identity validates the registered claims and extracts the user, groups,
+// and roles. Locally verified JWTs must expire; introspected tokens are
+// vouched for by the provider.
+func (o *OIDCAuthenticator) identity(claims map[string]interface{}) (*Identity, error) {
+	if err := checkValidity(claims, o.now(), o.opts.IntrospectionURL == ""); err != nil {
+		return nil, err
+	}
+	if iss, ok := claims["iss"].(string); o.opts.Issuer != "" && (ok || o.opts.IntrospectionURL == "") && iss != o.opts.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if o.opts.Audience != "" && !slices.Contains(stringsClaim(claims["aud"]), o.opts.Audience) {
+		return nil, fmt.Errorf("token is not issued for audience %q", o.opts.Audience)
+	}
+
+	username, _ := claims[o.opts.UsernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("token has no %s claim", o.opts.UsernameClaim)
+	}
+	identity := &Identity{Username: username, Claims: claims}
+	if o.opts.GroupsClaim != "" {
+		identity.Groups = stringsClaim(claims[o.opts.GroupsClaim])
+	}
+	if o.opts.RolesClaim != "" {
+		identity.Roles = stringsClaim(claims[o.opts.RolesClaim])
+	}
+	if o.opts.TenantClaim != "" {
+		tenantID, err := tenantClaim(claims[o.opts.TenantClaim])
+		if err != nil {
+			return nil, err
+		}
+		identity.Tenant = tenantID
+	}
+	return identity, nil
+}
+
+// checkValidity checks the exp, nbf, and iat claims against now with
+// clockSkew. Tokens without exp are rejected if requireExp is set.
+func checkValidity(claims map[string]interface{}, now time.Time, requireExp bool) error {
+	exp, ok, err := timeClaim(claims, "exp")
+	switch {
+	case err != nil:
+		return err
+	case !ok && requireExp:
+		return errors.New("token has no exp claim")
+	case ok && now.After(exp.Add(clockSkew)):
+		return errors.New("token expired")
+	}
+	if nbf, ok, err := timeClaim(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(clockSkew).Before(nbf) {
+		return errors.New("token not yet valid")
+	}
+	if iat, ok, err := timeClaim(claims, "iat"); err != nil {
+		return err
+	} else if ok && now.Add(clockSkew).Before(iat) {
+		return errors.New("token issued in the future")
+	}
+	return nil
+}
+
+// timeClaim reads the NumericDate claim name, if present
+func timeClaim(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok || value == nil {
+		return time.Time{}, false, nil
+	}
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("token has an invalid %s claim", name)
+	}
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+// stringsClaim reads a claim holding a string or an array of strings
+func stringsClaim(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// introspect asks the introspection endpoint for the claims of an active token
+func (o *OIDCAuthenticator) introspect(r *http.Request, token string) (map[string]interface{}, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, o.opts.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if o.opts.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(o.opts.ClientID), url.QueryEscape(o.opts.ClientSecret))
+	}
+	requestid.SetHeader(r.Context(), req)
+
+	resp, err := o.client.Do(req) // #nosec G107 - URL is built from configuration
+	if err != nil {
+		return nil, fmt.Errorf("token introspection failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token introspection returned status %d", resp.StatusCode)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode token introspection: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// jwtHeader is the JOSE header of a signed JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature of a JWT against the issuer's keys and returns its claims
+func (o *OIDCAuthenticator) verify(r *http.Request, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+	var header jwtHeader
+	var claims map[string]interface{}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || decodeSegment(parts[0], &header) != nil || decodeSegment(parts[1], &claims) != nil {
+		return nil, errInvalidToken
+	}
+
+	key, err := o.key(r, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		requestid.Logf(r.Context(), "Rejected access token: %v", err)
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// signatureHashes maps the supported JWS algorithms to their hash
+var signatureHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifySignature checks a JWS signature; symmetric and "none" algorithms are rejected
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	hash, ok := signatureHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(k, hash, digest, signature, nil)
+		}
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match an RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, signature)
+	case *ecdsa.PublicKey:
+		size := (k.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("algorithm %s or signature size does not match an EC key", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", key)
+}
+
+// key returns the signing key with the ID kid. The JWKS is refetched when the
+// cache expires or, rate-limited, when kid is unknown so rotated keys are picked up.
+func (o *OIDCAuthenticator) key(r *http.Request, kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	age := o.now().Sub(o.fetchedAt)
+	key, ok := o.keys[kid]
+	if ok && age < o.opts.JWKSCacheTTL {
+		return key, nil
+	}
+	if !ok && o.keys != nil && age < minJWKSRefresh {
+		requestid.Logf(r.Context(), "Rejected access token: unknown key ID %q", kid)
+		return nil, errInvalidToken
+	}
+
+	keys, err := o.fetchKeys(r)
+	if err != nil {
+		if ok {
+			// Keep using the stale key while the provider is unreachable
+			requestid.Logf(r.Context(), "Failed to refresh JWKS, using cached keys: %v", err)
+			return key, nil
+		}
+		return nil, err
+	}
+	o.keys, o.fetchedAt = keys, o.now()
+	if key, ok = keys[kid]; !ok {
+		requestid.Logf(r.Context(), "Rejected access token: unknown key ID %q", kid)
+		return nil, errInvalidToken
+	}
+	return key, nil
+}
+
+// fetchKeys downloads the JWKS, discovering its URL from the issuer first if needed
+func (o *OIDCAuthenticator) fetchKeys(r *http.Request) (map[string]crypto.PublicKey, error) {
+	if o.jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := o.getJSON(r, strings.TrimSuffix(o.opts.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, fmt.Errorf("oidc discovery failed: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("oidc discovery document has no jwks_uri")
+		}
+		o.jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := o.getJSON(r, o.jwksURL, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			requestid.Logf(r.Context(), "Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// getJSON fetches a JSON document from the provider
+func (o *OIDCAuthenticator) getJSON(r *http.Request, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	requestid.SetHeader(r.Context(), req)
+
+	resp, err := o.client.Do(req) // #nosec G107 - URL is built from configuration
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jwk is an RSA or EC public key of a JWKS
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the key material
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC coordinates")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ory/herodot"
+)
+
+// testIssuer serves OIDC discovery and a JWKS with an RSA and an EC key
+type testIssuer struct {
+	*httptest.Server
+	rsaKey      *rsa.PrivateKey
+	ecKey       *ecdsa.PrivateKey
+	jwksFetches atomic.Int32
+	rotated     atomic.Bool
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate EC key: %v", err)
+	}
+	issuer := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	enc := base64.RawURLEncoding.EncodeToString
+	issuer.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"jwks_uri": issuer.URL + "/jwks"})
+		case "/jwks":
+			issuer.jwksFetches.Add(1)
+			ecBytes, _ := ecKey.PublicKey.Bytes()
+			keys := []map[string]string{
+				{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": enc(rsaKey.N.Bytes()), "e": enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+				{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": enc(ecBytes[1:33]), "y": enc(ecBytes[33:])},
+			}
+			if issuer.rotated.Load() {
+				keys[0]["kid"] = "rsa-2"
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+		case "/introspect":
+			_ = r.ParseForm()
+			if user, pass, _ := r.BasicAuth(); user != "rerag" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.PostForm.Get("token") == "opaque-alice" {
+				_, _ = io.WriteString(w, `{"active": true, "sub": "alice", "aud": ["rerag"], "groups": ["accounting-team"]}`)
+				return
+			}
+			_, _ = io.WriteString(w, `{"active": false}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// sign issues a JWT with the given claims, signed by the RSA key unless alg starts with ES
+func (i *testIssuer) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	if strings.HasPrefix(alg, "ES") {
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	} else {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+	}
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func authenticate(a Authenticator, token string) (int, string, []string) {
+	var groups []string
+	handler := RequireUser(a, herodot.NewJSONWriter(nil), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFromContext(r.Context())
+		groups = principal.Groups
+		_, _ = io.WriteString(w, principal.Username)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/query", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Code, w.Body.String(), groups
+}
+
+func TestOIDCAuthenticatorVerifiesJWTs(t *testing.T) {
+	issuer := newTestIssuer(t)
+	a := NewOIDCAuthenticator(OIDCOptions{
+		Issuer:        issuer.URL,
+		Audience:      "rerag",
+		UsernameClaim: "sub",
+		GroupsClaim:   "groups",
+		RolesClaim:    "roles",
+		JWKSCacheTTL:  time.Hour,
+	}, httpclient.New(httpclient.Options{}))
+
+	now := time.Now().Unix()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": issuer.URL, "aud": "rerag", "sub": "alice", "exp": now + 300, "groups": []string{"accounting-team"}}
+		for k, v := range changes {
+			c[k] = v
+		}
+		return c
+	}
+
+	status, user, groups := authenticate(a, issuer.sign(t, "RS256", "rsa-1", claims(nil)))
+	if status != http.StatusOK || user != "alice" || !slices.Equal(groups, []string{"accounting-team"}) {
+		t.Fatalf("Expected alice in accounting-team, got %d %q %v", status, user, groups)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/query", nil)
+	req.Header.Set("Authorization", "Bearer "+issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"roles": "auditor"})))
+	if identity, err := a.Authenticate(req); err != nil || !slices.Equal(identity.Roles, []string{"auditor"}) || identity.Claims["sub"] != "alice" {
+		t.Errorf("Expected the roles and claims of the token, got %+v (%v)", identity, err)
+	}
+	if status, user, _ := authenticate(a, issuer.sign(t, "ES256", "ec-1", claims(map[string]interface{}{"aud": []string{"other", "rerag"}}))); status != http.StatusOK || user != "alice" {
+		t.Errorf("Expected an ES256 token for several audiences to be accepted, got %d %q", status, user)
+	}
+
+	valid := issuer.sign(t, "RS256", "rsa-1", claims(nil))
+	parts := strings.Split(valid, ".")
+	forged, _ := json.Marshal(claims(map[string]interface{}{"sub": "peter"}))
+	for name, token := range map[string]string{
+		"missing":          "",
+		"malformed":        "not-a-jwt",
+		"expired":          issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": now - 600})),
+		"not yet valid":    issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"nbf": now + 600})),
+		"no expiry":        issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": nil})),
+		"invalid expiry":   issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": "tomorrow"})),
+		"issued later":     issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"iat": now + 600})),
+		"wrong issuer":     issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"iss": "https://evil.example"})),
+		"wrong audience":   issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"aud": "other"})),
+		"no subject":       issuer.sign(t, "RS256", "rsa-1", claims(map[string]interface{}{"sub": ""})),
+		"unknown key":      issuer.sign(t, "RS256", "rsa-9", claims(nil)),
+		"forged claims":    parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2],
+		"algorithm swap":   issuer.sign(t, "ES256", "rsa-1", claims(nil)),
+		"unsigned":         parts[0] + "." + parts[1] + ".",
+		"symmetric header": strings.Replace(valid, parts[0], base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"rsa-1"}`)), 1),
+	} {
+		if status, _, _ := authenticate(a, token); status != http.StatusUnauthorized {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusUnauthorized, status)
+		}
+	}
+
+	// Keys are cached; an unknown key ID refetches at most once a minute
+	if fetches := issuer.jwksFetches.Load(); fetches != 1 {
+		t.Errorf("Expected the JWKS to be fetched once, got %d", fetches)
+	}
+	issuer.rotated.Store(true)
+	a.now = func() time.Time { return time.Now().Add(2 * minJWKSRefresh) }
+	rotated := issuer.sign(t, "RS256", "rsa-2", claims(map[string]interface{}{"exp": now + 3600}))
+	if status, user, _ := authenticate(a, rotated); status != http.StatusOK || user != "alice" {
+		t.Errorf("Expected a rotated key to be picked up, got %d %q", status, user)
+	}
+}
+
+func TestOIDCAuthenticatorIntrospectsTokens(t *testing.T) {
+	issuer := newTestIssuer(t)
+	options := OIDCOptions{
+		IntrospectionURL: issuer.URL + "/introspect",
+		ClientID:         "rerag",
+		ClientSecret:     "secret",
+		Audience:         "rerag",
+		UsernameClaim:    "sub",
+		GroupsClaim:      "groups",
+	}
+	a := NewOIDCAuthenticator(options, httpclient.New(httpclient.Options{}))
+
+	if status, user, groups := authenticate(a, "opaque-alice"); status != http.StatusOK || user != "alice" || !slices.Equal(groups, []string{"accounting-team"}) {
+		t.Errorf("Expected alice in accounting-team, got %d %q %v", status, user, groups)
+	}
+	if status, _, _ := authenticate(a, "opaque-revoked"); status != http.StatusUnauthorized {
+		t.Errorf("Expected an inactive token to be rejected, got %d", status)
+	}
+
+	options.ClientSecret = "wrong"
+	misconfigured := NewOIDCAuthenticator(options, httpclient.New(httpclient.Options{}))
+	if status, _, _ := authenticate(misconfigured, "opaque-alice"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected a failed introspection to be unavailable, got %d", status)
+	}
+}
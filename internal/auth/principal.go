@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"rerag-rbac-rag-llm/internal/permissions"
+)
+
+// AuthMethod is how the caller of a request proved its identity
+type AuthMethod string
+
+const (
+	// AuthMethodUser is a user verified by the server's Authenticator
+	AuthMethodUser AuthMethod = "user"
+	// AuthMethodAPIKey is a service presenting an API key
+	AuthMethodAPIKey AuthMethod = "api_key"
+	// AuthMethodShareToken is anyone presenting a share link's token
+	AuthMethodShareToken AuthMethod = "share_token"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Principal is the subject of permission checks. API keys and share
+	// tokens carry only a username.
+	permissions.Principal
+	// Tenant is the tenant the request acts in. API keys, share tokens, and
+	// users whose identity names a tenant are bound to theirs; other users
+	// choose it with the X-Tenant-ID header.
+	Tenant string
+	// TenantBound is whether the caller is bound to Tenant and may not act
+	// in other tenants
+	TenantBound bool
+	// Method is how the caller authenticated
+	Method AuthMethod
+}
+
+// principalContextKey is the context key of the Principal
+const principalContextKey contextKey = "principal"
+
+// NewContext returns a copy of ctx carrying p
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, p)
+}
+
+// PrincipalFromContext returns the caller the auth middleware added to ctx.
+// It reports false if the request was not authenticated, for example
+// because its route is public.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalContextKey).(Principal)
+	return p, ok
+}
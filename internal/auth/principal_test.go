@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"testing"
+
+	"github.com/ory/herodot"
+)
+
+// groupAuthenticator authenticates every request as alice, an auditor in the
+// payroll group
+type groupAuthenticator struct{}
+
+func (groupAuthenticator) Authenticate(*http.Request) (*Identity, error) {
+	return &Identity{
+		Username: "alice",
+		Groups:   []string{"payroll", "not a group!", "payroll"},
+		Roles:    []string{"auditor"},
+		Claims:   map[string]interface{}{"email": "alice@example.com"},
+	}, nil
+}
+
+func TestRequireUserAddsPrincipal(t *testing.T) {
+	if _, ok := PrincipalFromContext(context.Background()); ok {
+		t.Fatal("Expected no principal without authentication")
+	}
+
+	var principal Principal
+	var ok bool
+	handler := RequireUser(groupAuthenticator{}, herodot.NewJSONWriter(nil), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok = PrincipalFromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/query", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(tenant.NewContext(req.Context(), "acme")))
+
+	if !ok || principal.Username != "alice" || !slices.Equal(principal.Groups, []string{"payroll"}) ||
+		!slices.Equal(principal.Roles, []string{"auditor"}) || principal.Claims["email"] != "alice@example.com" ||
+		principal.Tenant != "acme" || principal.Method != AuthMethodUser {
+		t.Errorf("Unexpected principal %+v", principal)
+	}
+}
+
+// tenantAuthenticator authenticates every request as bob, bound to tenant
+type tenantAuthenticator string
+
+func (t tenantAuthenticator) Authenticate(*http.Request) (*Identity, error) {
+	return &Identity{Username: "bob", Tenant: string(t)}, nil
+}
+
+func TestRequireUserBindsIdentityTenant(t *testing.T) {
+	tests := []struct {
+		name     string
+		bound    string
+		header   string
+		status   int
+		expected string
+	}{
+		{"bound tenant without header", "acme", "", http.StatusOK, "acme"},
+		{"bound tenant with matching header", "acme", "acme", http.StatusOK, "acme"},
+		{"bound tenant with conflicting header", "acme", "globex", http.StatusForbidden, ""},
+		{"unbound user selects tenant with header", "", "globex", http.StatusOK, "globex"},
+	}
+	for _, tt := range tests {
+		var principal Principal
+		var contextTenant string
+		handler := tenant.Middleware(herodot.NewJSONWriter(nil), RequireUser(tenantAuthenticator(tt.bound), herodot.NewJSONWriter(nil), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = PrincipalFromContext(r.Context())
+			contextTenant = tenant.FromContext(r.Context())
+		})))
+		req := httptest.NewRequest(http.MethodGet, "/query", nil)
+		if tt.header != "" {
+			req.Header.Set(tenant.Header, tt.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.status, w.Code)
+		}
+		if principal.Tenant != tt.expected || contextTenant != tt.expected || principal.TenantBound != (tt.bound != "" && tt.status == http.StatusOK) {
+			t.Errorf("%s: expected tenant %q, got principal %q and context %q", tt.name, tt.expected, principal.Tenant, contextTenant)
+		}
+	}
+}
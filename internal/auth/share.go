@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/share"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+
+	"github.com/ory/herodot"
+)
+
+// ShareTokenHeader is the HTTP header carrying a share token
+const ShareTokenHeader = "X-Share-Token"
+
+// ShareTokenParam is the query parameter carrying a share token in share links
+const ShareTokenParam = "share_token"
+
+// ShareTokenMiddleware authenticates requests carrying a share token, in the
+// X-Share-Token header or the share_token query parameter, with signer and
+// passes all other requests to users. A token only admits GET requests for
+// the document with the path value "id" it was signed for; the request then
+// acts as the link's Keto subject in the link's tenant. Errors are written
+// with errs.
+func ShareTokenMiddleware(signer *share.Signer, errs herodot.Writer, users, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(ShareTokenHeader)
+		if token == "" {
+			token = r.URL.Query().Get(ShareTokenParam)
+		}
+		if token == "" {
+			users.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := signer.Verify(token, time.Now())
+		if errors.Is(err, share.ErrExpiredToken) {
+			errs.WriteError(w, r, herodot.ErrUnauthorized.WithReason("Share link expired"))
+			return
+		}
+		if err != nil {
+			errs.WriteError(w, r, herodot.ErrUnauthorized.WithReason("Invalid share token"))
+			return
+		}
+		if r.Method != http.MethodGet || r.PathValue("id") != claims.DocumentID.String() {
+			errs.WriteError(w, r, herodot.ErrForbidden.WithReason("Share tokens only grant read access to the shared document"))
+			return
+		}
+
+		ctx := NewContext(r.Context(), Principal{Principal: permissions.Principal{Username: claims.Subject()}, Tenant: claims.TenantID, TenantBound: true, Method: AuthMethodShareToken})
+		ctx = tenant.NewContext(ctx, claims.TenantID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
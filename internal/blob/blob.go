@@ -0,0 +1,158 @@
+// Package blob keeps the original files of uploads, addressed by the SHA-256
+// digest of their content. Identical files are stored once.
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/connectors/s3"
+)
+
+// MetadataDigest is the document metadata key holding the digest of the
+// original file a document was extracted from. Only the server sets it.
+const MetadataDigest = "original_sha256"
+
+// ErrNotFound is returned for digests without a stored blob
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs by the hex-encoded SHA-256 digest of their content
+type Store interface {
+	// Put stores data and returns its digest. Storing existing content is a no-op.
+	Put(ctx context.Context, data []byte) (string, error)
+	// Get returns the content with digest, or ErrNotFound
+	Get(ctx context.Context, digest string) ([]byte, error)
+}
+
+// Digest returns the hex-encoded SHA-256 digest of data
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// validDigest reports whether digest is a hex-encoded SHA-256 digest, so it
+// is safe to use in paths and object keys
+func validDigest(digest string) bool {
+	if len(digest) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
+
+// verify checks that data has digest, detecting corrupted or tampered blobs
+func verify(digest string, data []byte) error {
+	if got := Digest(data); got != digest {
+		return fmt.Errorf("blob %s has digest %s", digest, got)
+	}
+	return nil
+}
+
+// FileStore keeps blobs as files in a directory, sharded by the first two
+// digits of the digest
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a store in dir, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path returns the file of the blob with digest
+func (f *FileStore) path(digest string) string {
+	return filepath.Join(f.dir, digest[:2], digest)
+}
+
+// Put writes data to a temporary file and renames it into place, so readers
+// never see a partial blob
+func (f *FileStore) Put(_ context.Context, data []byte) (string, error) {
+	digest := Digest(data)
+	path := f.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		return digest, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), digest+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	return digest, nil
+}
+
+// Get reads the blob with digest
+func (f *FileStore) Get(_ context.Context, digest string) ([]byte, error) {
+	if !validDigest(digest) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(f.path(digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob: %w", err)
+	}
+	return data, verify(digest, data)
+}
+
+// S3Store keeps blobs as objects of an S3-compatible bucket under a prefix
+type S3Store struct {
+	client *s3.Client
+	prefix string
+}
+
+// NewS3Store creates a store writing objects below prefix with client
+func NewS3Store(client *s3.Client, prefix string) *S3Store {
+	return &S3Store{client: client, prefix: prefix}
+}
+
+// key returns the object key of the blob with digest
+func (s *S3Store) key(digest string) string {
+	return s.prefix + digest[:2] + "/" + digest
+}
+
+// Put uploads data. An existing object has the same content, so it is
+// simply overwritten.
+func (s *S3Store) Put(ctx context.Context, data []byte) (string, error) {
+	digest := Digest(data)
+	if err := s.client.PutObject(ctx, s.key(digest), data, "application/octet-stream"); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// Get downloads the blob with digest
+func (s *S3Store) Get(ctx context.Context, digest string) ([]byte, error) {
+	if !validDigest(digest) {
+		return nil, ErrNotFound
+	}
+	data, _, err := s.client.GetObject(ctx, s.key(digest))
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, verify(digest, data)
+}
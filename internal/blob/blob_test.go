@@ -0,0 +1,99 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/connectors/s3"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testStores returns a file store and an S3 store backed by a fake bucket
+func testStores(t *testing.T) map[string]Store {
+	t.Helper()
+	files, err := NewFileStore(filepath.Join(t.TempDir(), "originals"))
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") == "" {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := s3.NewClient(s3.ClientConfig{Endpoint: server.URL, Region: "us-east-1", Bucket: "blobs", PathStyle: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{"file": files, "s3": NewS3Store(client, "originals/")}
+}
+
+func TestStoresAddressContentByDigest(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			data := []byte("%PDF-1.7 quarterly report")
+			digest, err := store.Put(ctx, data)
+			if err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if digest != Digest(data) || len(digest) != 64 {
+				t.Errorf("Expected the SHA-256 digest, got %q", digest)
+			}
+			if again, err := store.Put(ctx, data); err != nil || again != digest {
+				t.Errorf("Expected storing the same content to return the same digest, got %q, %v", again, err)
+			}
+
+			got, err := store.Get(ctx, digest)
+			if err != nil || string(got) != string(data) {
+				t.Errorf("Expected the stored content, got %q, %v", got, err)
+			}
+			for _, missing := range []string{Digest([]byte("other")), "../../etc/passwd", strings.Repeat("z", 64)} {
+				if _, err := store.Get(ctx, missing); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Expected ErrNotFound for %q, got %v", missing, err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileStoreDetectsCorruptedBlobs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := store.Put(context.Background(), []byte("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, digest[:2], digest), []byte("tampered"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(context.Background(), digest); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a digest mismatch, got %v", err)
+	}
+}
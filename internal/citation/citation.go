@@ -0,0 +1,55 @@
+// Package citation validates the [n] citation markers the LLM is asked to put
+// into answers, where n is the number of a document in the prompt.
+package citation
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// markerPattern matches [1], [2, 3], and [2][3]. Numbers have at most three
+// digits so bracketed years such as [2023] are left alone.
+var markerPattern = regexp.MustCompile(`\[(\d{1,3}(?:\s*,\s*\d{1,3})*)\]`)
+
+// Validate keeps the citations of documents 1 to count in answer and strips
+// the others, which the model made up. It returns the cleaned answer, the
+// cited document numbers in ascending order, and how many citations were
+// stripped.
+func Validate(answer string, count int) (string, []int, int) {
+	var cited []int
+	stripped := 0
+
+	var out strings.Builder
+	last := 0
+	for _, loc := range markerPattern.FindAllStringSubmatchIndex(answer, -1) {
+		var valid []string
+		for _, field := range strings.Split(answer[loc[2]:loc[3]], ",") {
+			n, _ := strconv.Atoi(strings.TrimSpace(field))
+			if n < 1 || n > count {
+				stripped++
+				continue
+			}
+			valid = append(valid, strconv.Itoa(n))
+			if !slices.Contains(cited, n) {
+				cited = append(cited, n)
+			}
+		}
+
+		prefix := answer[last:loc[0]]
+		if len(valid) == 0 {
+			// Drop the space that separated the marker from the claim
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		out.WriteString(prefix)
+		if len(valid) > 0 {
+			out.WriteString("[" + strings.Join(valid, ", ") + "]")
+		}
+		last = loc[1]
+	}
+	out.WriteString(answer[last:])
+
+	slices.Sort(cited)
+	return out.String(), cited, stripped
+}
@@ -0,0 +1,39 @@
+package citation
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		answer       string
+		count        int
+		want         string
+		wantCited    []int
+		wantStripped int
+	}{
+		{name: "valid", answer: "John was refunded $50 [1]. ABC paid $10 [2][1].", count: 2, want: "John was refunded $50 [1]. ABC paid $10 [2][1].", wantCited: []int{1, 2}},
+		{name: "hallucinated", answer: "John was refunded $50 [3].", count: 2, want: "John was refunded $50.", wantStripped: 1},
+		{name: "mixed list", answer: "Both paid [1, 4, 2].", count: 2, want: "Both paid [1, 2].", wantCited: []int{1, 2}, wantStripped: 1},
+		{name: "zero", answer: "Nothing [0] here", count: 2, want: "Nothing here", wantStripped: 1},
+		{name: "no documents", answer: "I cannot answer [1].", count: 0, want: "I cannot answer.", wantStripped: 1},
+		{name: "years are not citations", answer: "The [2023] return [1]", count: 1, want: "The [2023] return [1]", wantCited: []int{1}},
+		{name: "no markers", answer: "Plain answer.", count: 3, want: "Plain answer."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, cited, stripped := Validate(tt.answer, tt.count)
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+			if !slices.Equal(cited, tt.wantCited) {
+				t.Errorf("expected cited %v, got %v", tt.wantCited, cited)
+			}
+			if stripped != tt.wantStripped {
+				t.Errorf("expected %d stripped, got %d", tt.wantStripped, stripped)
+			}
+		})
+	}
+}
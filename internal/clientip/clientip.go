@@ -0,0 +1,167 @@
+// Package clientip resolves the address of the client behind a request,
+// trusting forwarding headers only when they were set by a trusted proxy.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ForwardedForHeader lists the addresses a request was forwarded for, each
+// proxy appending the address of its peer
+const ForwardedForHeader = "X-Forwarded-For"
+
+// RealIPHeader carries the client address set by a single trusted proxy
+const RealIPHeader = "X-Real-IP"
+
+type contextKey string
+
+// ContextKey is the context key for storing the client IP
+const ContextKey contextKey = "client_ip"
+
+// Resolver determines client IPs. The zero value and nil trust no proxy and
+// always use the address of the connection's peer.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver creates a resolver trusting the forwarding headers set by the
+// proxies in the given CIDR ranges or single addresses
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	trusted, err := ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// ParseTrustedProxies parses CIDR ranges or single addresses of trusted proxies
+func ParseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			addr, err := netip.ParseAddr(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ClientIP returns the client IP of r. The X-Forwarded-For hops are walked
+// from the right, the hop closest to the server, skipping trusted proxies;
+// the first untrusted hop is the client, as any hop left of it could have
+// been made up by the client itself. X-Real-IP is used if a trusted peer sent
+// no X-Forwarded-For. Without a trusted peer the peer itself is the client.
+func (res *Resolver) ClientIP(r *http.Request) string {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !res.isTrusted(peer) {
+		return peer.String()
+	}
+
+	hops := forwardedFor(r.Header)
+	if len(hops) == 0 {
+		if real, ok := parseAddr(r.Header.Get(RealIPHeader)); ok {
+			return real.String()
+		}
+		return peer.String()
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseAddr(hops[i])
+		if !ok {
+			// The hops left of a malformed one cannot be attributed to
+			// anyone; the last trusted hop is the best known client
+			break
+		}
+		client = hop
+		if !res.isTrusted(hop) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// isTrusted reports whether addr is a trusted proxy
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	if res == nil {
+		return false
+	}
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the hops of all X-Forwarded-For headers in order
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values(ForwardedForHeader) {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseAddr parses an address with or without a port
+func parseAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// Middleware stores the client IP resolved by res in the request context
+func Middleware(res *Resolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), res.ClientIP(r))))
+	})
+}
+
+// NewContext returns a copy of ctx carrying the given client IP
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ContextKey, ip)
+}
+
+// FromContext returns the client IP stored in ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(ContextKey).(string)
+	return ip
+}
+
+// FromRequest returns the client IP stored in the context of r, or the
+// address of the connection's peer if Middleware did not resolve it
+func FromRequest(r *http.Request) string {
+	if ip := FromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return (*Resolver)(nil).ClientIP(r)
+}
@@ -0,0 +1,72 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("NewResolver failed: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		realIP       string
+		expected     string
+	}{
+		{"untrusted peer ignores the headers", "203.0.113.9:4000", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.9"},
+		{"trusted peer without headers", "10.0.0.1:4000", nil, "", "10.0.0.1"},
+		{"trusted peer forwards the client", "10.0.0.1:4000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"spoofed hops left of the client are skipped", "10.0.0.1:4000", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1"},
+		{"trusted hops are skipped", "10.0.0.1:4000", []string{"198.51.100.1, 192.168.1.1, 10.2.3.4"}, "", "198.51.100.1"},
+		{"hops across several headers", "10.0.0.1:4000", []string{"1.2.3.4, 198.51.100.1", "10.9.9.9"}, "", "198.51.100.1"},
+		{"only trusted hops", "10.0.0.1:4000", []string{"10.1.1.1, 10.2.2.2"}, "", "10.1.1.1"},
+		{"malformed hop stops the walk", "10.0.0.1:4000", []string{"198.51.100.1, garbage, 10.2.2.2"}, "", "10.2.2.2"},
+		{"hops with ports", "10.0.0.1:4000", []string{"[2001:db8::1]:5000"}, "", "2001:db8::1"},
+		{"real IP of a trusted peer", "10.0.0.1:4000", nil, "198.51.100.1", "198.51.100.1"},
+		{"mapped IPv4 peer", "[::ffff:10.0.0.1]:4000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"trusted IPv6 peer", "[fd00::1]:4000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add(ForwardedForHeader, value)
+			}
+			if tt.realIP != "" {
+				r.Header.Set(RealIPHeader, tt.realIP)
+			}
+			if got := resolver.ClientIP(r); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNilResolverTrustsNoProxy(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set(ForwardedForHeader, "198.51.100.1")
+
+	var resolved string
+	Middleware(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resolved = FromRequest(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if resolved != "10.0.0.1" {
+		t.Errorf("Expected the peer address, got %s", resolved)
+	}
+}
+
+func TestParseTrustedProxiesRejectsInvalidRanges(t *testing.T) {
+	for _, proxy := range []string{"", "10.0.0.0/33", "proxy.internal"} {
+		if _, err := ParseTrustedProxies([]string{proxy}); err == nil {
+			t.Errorf("Expected %q to be rejected", proxy)
+		}
+	}
+}
@@ -3,13 +3,31 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/connectors/mirror"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"rerag-rbac-rag-llm/internal/orphans"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/quota"
+	"rerag-rbac-rag-llm/internal/ragservice"
+	"rerag-rbac-rag-llm/internal/redact"
+	"rerag-rbac-rag-llm/internal/scheduler"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/knadh/koanf/parsers/json"
 	"github.com/knadh/koanf/parsers/yaml"
-	"github.com/knadh/koanf/providers/env/v2"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
 )
@@ -25,9 +43,42 @@ type Config struct {
 	// External services
 	Services ServicesConfig `koanf:"services"`
 
+	// Retrieval settings
+	Search SearchConfig `koanf:"search"`
+
+	// File upload and chunking settings
+	Ingestion IngestionConfig `koanf:"ingestion"`
+
+	// Outgoing event notifications
+	Webhooks WebhooksConfig `koanf:"webhooks"`
+
+	// Answer cache for repeated queries
+	QueryCache QueryCacheConfig `koanf:"query_cache"`
+
+	// Per-user accounting of query tokens and latency
+	Metrics MetricsConfig `koanf:"metrics"`
+
+	// Multi-turn conversation settings
+	Conversations ConversationsConfig `koanf:"conversations"`
+
+	// Prompt template settings
+	Prompts PromptsConfig `koanf:"prompts"`
+
+	// Redaction of sensitive values before prompting
+	Redaction RedactionConfig `koanf:"redaction"`
+
+	// Prompt injection detection on ingest and query
+	InjectionGuard InjectionGuardConfig `koanf:"injection_guard"`
+
+	// Content moderation of questions and answers
+	Moderation ModerationConfig `koanf:"moderation"`
+
 	// Security settings
 	Security SecurityConfig `koanf:"security"`
 
+	// Secret managers for credentials not stored in the configuration
+	Secrets SecretsConfig `koanf:"secrets"`
+
 	// Application settings
 	App AppConfig `koanf:"app"`
 }
@@ -38,21 +89,108 @@ type ServerConfig struct {
 	Port         int       `koanf:"port"`
 	ReadTimeout  int       `koanf:"read_timeout"`  // seconds
 	WriteTimeout int       `koanf:"write_timeout"` // seconds
+	QueryTimeout int       `koanf:"query_timeout"` // seconds per query across all stages; 0 disables
 	TLS          TLSConfig `koanf:"tls"`
+	// TrustedProxies are the CIDR ranges or addresses of reverse proxies
+	// whose X-Forwarded-For headers are trusted for client IPs; empty uses
+	// the connection's peer
+	TrustedProxies  []string              `koanf:"trusted_proxies"`
+	SecurityHeaders SecurityHeadersConfig `koanf:"security_headers"`
+}
+
+// SecurityHeadersConfig holds the headers set on every response
+type SecurityHeadersConfig struct {
+	// Enabled sets X-Content-Type-Options: nosniff and the headers below
+	Enabled               bool `koanf:"enabled"`
+	HSTSMaxAge            int  `koanf:"hsts_max_age"` // seconds; 0 omits Strict-Transport-Security
+	HSTSIncludeSubdomains bool `koanf:"hsts_include_subdomains"`
+	// ContentSecurityPolicy is sent as is; empty omits the header
+	ContentSecurityPolicy string `koanf:"content_security_policy"`
 }
 
 // TLSConfig holds TLS/HTTPS configuration
 type TLSConfig struct {
-	Enabled  bool   `koanf:"enabled"`
-	CertFile string `koanf:"cert_file"`
-	KeyFile  string `koanf:"key_file"`
-	MinTLS   string `koanf:"min_version"` // "1.2" or "1.3"
+	Enabled    bool             `koanf:"enabled"`
+	CertFile   string           `koanf:"cert_file"`
+	KeyFile    string           `koanf:"key_file"`
+	MinTLS     string           `koanf:"min_version"` // "1.2" or "1.3"
+	ClientAuth ClientAuthConfig `koanf:"client_auth"`
+}
+
+// ClientAuthConfig holds the mutual TLS settings. Client certificates are
+// verified when presented and required on the ingestion endpoints.
+type ClientAuthConfig struct {
+	Enabled bool   `koanf:"enabled"`
+	CAFile  string `koanf:"ca_file"` // PEM bundle of the CAs issuing client certificates
 }
 
 // DatabaseConfig holds database configuration
 type DatabaseConfig struct {
+	Driver     string           `koanf:"driver"` // "sqlite" or "memory"
 	Path       string           `koanf:"path"`
 	Encryption EncryptionConfig `koanf:"encryption"`
+	// MemoryFile persists the documents of the memory driver as JSON; empty keeps them in memory only
+	MemoryFile string `koanf:"memory_file"`
+	// Connection settings of the sqlite driver for concurrent requests
+	JournalMode  string `koanf:"journal_mode"`   // "wal" lets reads run while a write is in progress
+	BusyTimeout  int    `koanf:"busy_timeout"`   // seconds a connection waits for a lock
+	MaxOpenConns int    `koanf:"max_open_conns"` // connection pool size; 1 serializes all access
+	// IndexedMetadata lists top-level metadata keys the sqlite driver indexes
+	// for exact-match filters, e.g. taxpayer, year, type
+	IndexedMetadata []string `koanf:"indexed_metadata"`
+	// Vector sets how the sqlite driver stores and compares embeddings
+	Vector VectorConfig `koanf:"vector"`
+	// Deleted documents stay restorable in the trash until they are purged
+	Trash TrashConfig `koanf:"trash"`
+	// Documents are deleted for good once their retention period ends
+	Retention RetentionConfig `koanf:"retention"`
+	// Documents left without permission relations are flagged, quarantined, or deleted
+	Orphans OrphansConfig `koanf:"orphans"`
+}
+
+// TrashConfig holds settings for purging deleted documents
+type TrashConfig struct {
+	RetentionDays int `koanf:"retention_days"` // days before a deleted document is purged; 0 keeps it forever
+	PurgeInterval int `koanf:"purge_interval"` // seconds between purges
+}
+
+// RetentionConfig holds settings for expiring documents
+type RetentionConfig struct {
+	Enabled       bool   `koanf:"enabled"`
+	MaxAgeDays    int    `koanf:"max_age_days"`   // days after creation a document expires; 0 relies on metadata only
+	MetadataKey   string `koanf:"metadata_key"`   // metadata field holding a per-document expiry date, e.g. "expires_at"
+	CheckInterval int    `koanf:"check_interval"` // seconds between expiry runs
+}
+
+// Policy converts the configuration into a storage.RetentionPolicy
+func (c RetentionConfig) Policy() storage.RetentionPolicy {
+	return storage.RetentionPolicy{
+		MaxAge:       time.Duration(c.MaxAgeDays) * 24 * time.Hour,
+		ExpiresAtKey: c.MetadataKey,
+	}
+}
+
+// OrphansConfig holds settings for reaping documents without relations
+type OrphansConfig struct {
+	Enabled       bool   `koanf:"enabled"`
+	Policy        string `koanf:"policy"`         // "flag", "quarantine" (move to trash), or "delete"
+	MinAgeDays    int    `koanf:"min_age_days"`   // days after creation before a document is checked
+	CheckInterval int    `koanf:"check_interval"` // seconds between reaper runs
+}
+
+// VectorConfig holds the vector index settings, applied when the vector
+// tables are created; existing tables keep theirs until a reindex
+type VectorConfig struct {
+	Metric       string `koanf:"metric"`       // "l2" or "cosine"
+	Quantization string `koanf:"quantization"` // "none", "int8", or "binary" (l2 only); smaller vectors scan faster at some recall
+	// Rescore ranks this many quantized candidates per result by full-precision
+	// copies of the vectors; 0 stores only the quantized vectors
+	Rescore int `koanf:"rescore"`
+}
+
+// Index converts the configuration into a storage.VectorIndex
+func (c VectorConfig) Index() storage.VectorIndex {
+	return storage.VectorIndex{Metric: c.Metric, Quantization: c.Quantization}
 }
 
 // EncryptionConfig holds database encryption settings
@@ -63,30 +201,566 @@ type EncryptionConfig struct {
 
 // ServicesConfig holds external service configuration
 type ServicesConfig struct {
-	Ollama OllamaConfig `koanf:"ollama"`
-	Keto   KetoConfig   `koanf:"keto"`
+	Ollama   OllamaConfig   `koanf:"ollama"`
+	Keto     KetoConfig     `koanf:"keto"`
+	Reranker RerankerConfig `koanf:"reranker"`
 }
 
 // OllamaConfig holds Ollama service configuration
 type OllamaConfig struct {
-	BaseURL        string `koanf:"base_url"`
-	EmbeddingModel string `koanf:"embedding_model"`
-	LLMModel       string `koanf:"llm_model"`
-	Timeout        int    `koanf:"timeout"` // seconds
+	BaseURL        string        `koanf:"base_url"`
+	EmbeddingModel string        `koanf:"embedding_model"`
+	LLMModel       string        `koanf:"llm_model"`
+	Timeout        int           `koanf:"timeout"`         // seconds
+	ContextTokens  int           `koanf:"context_tokens"`  // LLM context window; 0 disables prompt budgeting
+	ResponseTokens int           `koanf:"response_tokens"` // context reserved for the answer
+	MaxRetries     int           `koanf:"max_retries"`     // retries of transient failures, with exponential backoff
+	KeepAlive      string        `koanf:"keep_alive"`      // how long Ollama keeps the embedding model loaded, e.g. "5m"; empty uses Ollama's default
+	Breaker        BreakerConfig `koanf:"circuit_breaker"`
+}
+
+// BreakerConfig holds circuit breaker settings for a backing service
+type BreakerConfig struct {
+	FailureThreshold int `koanf:"failure_threshold"` // consecutive failures that open the breaker; 0 disables it
+	Cooldown         int `koanf:"cooldown"`          // seconds before a probe request is let through
 }
 
 // KetoConfig holds Ory Keto configuration
 type KetoConfig struct {
-	ReadURL  string `koanf:"read_url"`
-	WriteURL string `koanf:"write_url"`
-	Timeout  int    `koanf:"timeout"` // seconds
+	ReadURL    string                `koanf:"read_url"`
+	WriteURL   string                `koanf:"write_url"`
+	Timeout    int                   `koanf:"timeout"`     // seconds
+	MaxRetries int                   `koanf:"max_retries"` // retries of connection errors and 5xx responses, with jittered backoff
+	Cache      PermissionCacheConfig `koanf:"cache"`
+	Policy     PolicyConfig          `koanf:"policy"`
+	// CheckStrategy decides batch checks: "check" asks Keto about every
+	// document, "list" intersects them with the documents the user may view,
+	// listed once per request, unless there are more than ListLimit
+	CheckStrategy string `koanf:"check_strategy"`
+	ListLimit     int    `koanf:"list_limit"`
+	// StartupCheck decides what happens when Keto is unreachable or lacks a
+	// configured namespace at startup: "strict" refuses to start, "warn"
+	// logs the problems, and "off" skips the check
+	StartupCheck string `koanf:"startup_check"`
+	// AttributeRules grant read access to documents through metadata
+	// attributes, e.g. auditors of a taxpayer read all of its documents
+	AttributeRules []AttributeRuleConfig `koanf:"attribute_rules"`
+	// Namespaces, Relations, and SubjectFormat adapt this service to the
+	// naming of an existing Keto deployment
+	Namespaces    KetoNamespacesConfig `koanf:"namespaces"`
+	Relations     KetoRelationsConfig  `koanf:"relations"`
+	SubjectFormat string               `koanf:"subject_format"` // subject ID of a user, e.g. "user:{username}"
+}
+
+// KetoNamespacesConfig names the base Keto namespaces of documents and groups
+type KetoNamespacesConfig struct {
+	Documents string `koanf:"documents"`
+	Groups    string `koanf:"groups"`
+}
+
+// KetoRelationsConfig names the Keto relations standing for this service's relations
+type KetoRelationsConfig struct {
+	Viewer string `koanf:"viewer"` // read a document
+	Editor string `koanf:"editor"` // modify a document
+	Write  string `koanf:"write"`  // ingest documents; held on the corpus object
+	Member string `koanf:"member"` // membership in a group
+}
+
+// Naming converts the configuration into a permissions.Naming
+func (c KetoConfig) Naming() permissions.Naming {
+	return permissions.Naming{
+		DocumentsNamespace: c.Namespaces.Documents,
+		GroupsNamespace:    c.Namespaces.Groups,
+		Viewer:             c.Relations.Viewer,
+		Editor:             c.Relations.Editor,
+		Write:              c.Relations.Write,
+		Member:             c.Relations.Member,
+		SubjectFormat:      c.SubjectFormat,
+	}
+}
+
+// AttributeRuleConfig maps a document metadata attribute to objects in a Keto namespace
+type AttributeRuleConfig struct {
+	Attribute string `koanf:"attribute"` // metadata key, e.g. "taxpayer"
+	Namespace string `koanf:"namespace"` // Keto namespace of the attribute values, e.g. "taxpayers"
+	Relation  string `koanf:"relation"`  // relation on a value that grants read access, e.g. "auditor"
+}
+
+// Rule converts the configuration into a permissions.AttributeRule
+func (c AttributeRuleConfig) Rule() permissions.AttributeRule {
+	return permissions.AttributeRule{Attribute: c.Attribute, Namespace: c.Namespace, Relation: c.Relation}
+}
+
+// PolicyConfig holds the permission policy reconciled into Keto on startup
+type PolicyConfig struct {
+	File   string `koanf:"file"`   // YAML policy file; empty disables reconciliation on startup
+	Tenant string `koanf:"tenant"` // tenant whose namespace and documents the policy applies to
+}
+
+// PermissionCacheConfig holds settings for the permission decision cache
+type PermissionCacheConfig struct {
+	Enabled    bool `koanf:"enabled"`
+	TTL        int  `koanf:"ttl"` // seconds
+	MaxEntries int  `koanf:"max_entries"`
+}
+
+// RerankerConfig holds settings for the optional reranking stage of the query pipeline
+type RerankerConfig struct {
+	Enabled    bool   `koanf:"enabled"`
+	Provider   string `koanf:"provider"` // "ollama" or "http"
+	BaseURL    string `koanf:"base_url"` // Ollama base URL, or the full rerank endpoint for "http"
+	Model      string `koanf:"model"`
+	APIKey     string `koanf:"api_key"`    // bearer token for "http" providers
+	Timeout    int    `koanf:"timeout"`    // seconds
+	Candidates int    `koanf:"candidates"` // documents retrieved for reranking before keeping top_k
+}
+
+// SearchConfig holds retrieval settings
+type SearchConfig struct {
+	Hybrid HybridSearchConfig `koanf:"hybrid"`
+	// RequireSources answers questions matching none of the user's accessible
+	// documents with a standard response instead of calling the LLM
+	RequireSources bool `koanf:"require_sources"`
+	// ReportHidden adds to query responses how many of the top matches the
+	// user may not access, without revealing which
+	ReportHidden bool `koanf:"report_hidden"`
+	// Agent bounds the follow-up searches of agent queries
+	Agent AgentConfig `koanf:"agent"`
+	// Routing classifies questions before answering them
+	Routing RoutingConfig `koanf:"routing"`
+	// MaxQuestionLength rejects longer questions, in characters after
+	// normalization
+	MaxQuestionLength int `koanf:"max_question_length"`
+	// ChunkScore combines the chunk distances of documents stored with chunk
+	// vectors: "max" scores by the best chunk, "mean" by all chunks
+	ChunkScore string `koanf:"chunk_score"`
+}
+
+// AgentConfig holds the settings of agent queries, in which the LLM issues
+// follow-up searches before answering
+type AgentConfig struct {
+	MaxSearches int `koanf:"max_searches"` // 0 disables agent queries
+}
+
+// RoutingConfig holds the settings of query routing, in which the LLM
+// classifies each question as a document question, answered by retrieval, a
+// count of documents, answered from their metadata, or small talk, answered
+// without documents
+type RoutingConfig struct {
+	Enabled bool `koanf:"enabled"`
+	// MetadataKeys are the top-level metadata keys counts may filter and
+	// group documents by
+	MetadataKeys []string `koanf:"metadata_keys"`
+	// MaxDocuments bounds the documents scanned to answer one count
+	MaxDocuments int `koanf:"max_documents"`
+}
+
+// HybridSearchConfig holds the rank fusion settings for hybrid (vector + keyword) search
+type HybridSearchConfig struct {
+	VectorWeight  float64 `koanf:"vector_weight"`
+	KeywordWeight float64 `koanf:"keyword_weight"`
+	RRFK          int     `koanf:"rrf_k"` // reciprocal rank fusion constant
+}
+
+// IngestionConfig holds settings for file uploads and connectors
+type IngestionConfig struct {
+	ChunkSize     int      `koanf:"chunk_size"`      // bytes per document chunk
+	ChunkOverlap  int      `koanf:"chunk_overlap"`   // bytes repeated between consecutive chunks
+	ChunkVectors  bool     `koanf:"chunk_vectors"`   // one document per source with a vector per chunk
+	MaxUploadSize int      `koanf:"max_upload_size"` // megabytes; also bounds connector objects
+	S3            S3Config `koanf:"s3"`
+	// Quotas limit the storage of each tenant and of each user within a tenant
+	Quotas QuotasConfig `koanf:"quotas"`
+	// Originals keeps the uploaded files next to the text extracted from them
+	Originals OriginalsConfig `koanf:"originals"`
+	// Drive syncs a Google Drive or SharePoint library and mirrors its sharing
+	Drive DriveConfig `koanf:"drive"`
+}
+
+// DriveConfig holds settings for the Google Drive or SharePoint connector,
+// which mirrors who may read or edit each file into relations on its documents
+type DriveConfig struct {
+	Enabled      bool                  `koanf:"enabled"`
+	Provider     string                `koanf:"provider"`      // "google" or "sharepoint"
+	Tenant       string                `koanf:"tenant"`        // tenant the documents are ingested into
+	SyncInterval int                   `koanf:"sync_interval"` // seconds between syncs; 0 syncs once at startup
+	Schedule     string                `koanf:"schedule"`      // cron expression or "@every <duration>"; overrides sync_interval
+	Timeout      int                   `koanf:"timeout"`       // seconds per request
+	MaxRetries   int                   `koanf:"max_retries"`
+	Google       GoogleDriveConfig     `koanf:"google"`
+	SharePoint   SharePointConfig      `koanf:"sharepoint"`
+	Identities   DriveIdentitiesConfig `koanf:"identities"`
+}
+
+// GoogleDriveConfig holds the service account and files of the google provider
+type GoogleDriveConfig struct {
+	CredentialsFile string `koanf:"credentials_file"` // JSON key of the service account
+	Subject         string `koanf:"subject"`          // user impersonated through domain-wide delegation
+	DriveID         string `koanf:"drive_id"`         // shared drive; empty syncs the files the account can see
+	FolderID        string `koanf:"folder_id"`        // limits the sync to a folder and its subfolders
+}
+
+// SharePointConfig holds the app registration and library of the sharepoint provider
+type SharePointConfig struct {
+	TenantID     string `koanf:"tenant_id"` // Entra ID tenant
+	ClientID     string `koanf:"client_id"`
+	ClientSecret string `koanf:"client_secret"`
+	DriveID      string `koanf:"drive_id"` // document library
+	Folder       string `koanf:"folder"`   // limits the sync to a folder path, e.g. "Finance/2024"
+}
+
+// DriveIdentitiesConfig maps the users and groups files are shared with to
+// users and groups of this service. Unmapped principals get no relations.
+type DriveIdentitiesConfig struct {
+	Users  []DriveIdentity `koanf:"users"`
+	Groups []DriveIdentity `koanf:"groups"` // SharePoint site groups are matched by name
+	// Domain maps unlisted users and groups of this email domain to the local
+	// part of their address
+	Domain string `koanf:"domain"`
+	// EmailUsernames identifies the remaining users by their email address
+	EmailUsernames bool `koanf:"email_usernames"`
+	// EveryoneGroup receives the relations of files shared with the whole
+	// organization or by link; empty ignores such sharing
+	EveryoneGroup string `koanf:"everyone_group"`
+}
+
+// DriveIdentity maps an email address, or a site group name, to a user or group
+type DriveIdentity struct {
+	Email string `koanf:"email"`
+	Name  string `koanf:"name"`
+}
+
+// Identities converts the configuration into mirror.Identities
+func (c DriveIdentitiesConfig) Identities() mirror.Identities {
+	ids := mirror.Identities{
+		Users:          make(map[string]string, len(c.Users)),
+		Groups:         make(map[string]string, len(c.Groups)),
+		Domain:         c.Domain,
+		EmailUsernames: c.EmailUsernames,
+		EveryoneGroup:  c.EveryoneGroup,
+	}
+	for _, user := range c.Users {
+		ids.Users[strings.ToLower(user.Email)] = user.Name
+	}
+	for _, group := range c.Groups {
+		ids.Groups[strings.ToLower(group.Email)] = group.Name
+	}
+	return ids
+}
+
+// CrawlSchedule returns the schedule of the drive connector's syncs, or nil
+// to sync once at startup
+func (c DriveConfig) CrawlSchedule() (scheduler.Schedule, error) {
+	return crawlSchedule(c.Schedule, c.SyncInterval)
+}
+
+// crawlSchedule parses a connector's schedule, falling back to its sync
+// interval in seconds when none is set
+func crawlSchedule(spec string, interval int) (scheduler.Schedule, error) {
+	if strings.TrimSpace(spec) != "" {
+		return scheduler.Parse(spec)
+	}
+	if interval == 0 {
+		return nil, nil
+	}
+	return scheduler.Every(time.Duration(interval) * time.Second), nil
+}
+
+// OriginalsConfig holds settings for the store of original uploaded files,
+// addressed by the SHA-256 digest of their content
+type OriginalsConfig struct {
+	Backend string            `koanf:"backend"` // "" keeps no originals, "filesystem", or "s3"
+	Dir     string            `koanf:"dir"`     // directory of the filesystem backend
+	S3      OriginalsS3Config `koanf:"s3"`
+}
+
+// OriginalsS3Config holds the bucket of the s3 originals backend
+type OriginalsS3Config struct {
+	Endpoint   string `koanf:"endpoint"`
+	Region     string `koanf:"region"`
+	Bucket     string `koanf:"bucket"`
+	Prefix     string `koanf:"prefix"` // prepended to the object keys, e.g. "originals/"
+	AccessKey  string `koanf:"access_key"`
+	SecretKey  string `koanf:"secret_key"`
+	PathStyle  bool   `koanf:"path_style"` // required by MinIO
+	Timeout    int    `koanf:"timeout"`    // seconds per request
+	MaxRetries int    `koanf:"max_retries"`
+}
+
+// QuotasConfig holds the storage limits enforced on ingestion
+type QuotasConfig struct {
+	Tenant QuotaLimitsConfig `koanf:"tenant"`
+	User   QuotaLimitsConfig `koanf:"user"`
+}
+
+// Enabled reports whether any limit is set
+func (c QuotasConfig) Enabled() bool {
+	return c.Tenant != QuotaLimitsConfig{} || c.User != QuotaLimitsConfig{}
+}
+
+// QuotaLimitsConfig holds the limits of a single scope; 0 means unlimited
+type QuotaLimitsConfig struct {
+	MaxDocuments    int   `koanf:"max_documents"`
+	MaxContentBytes int64 `koanf:"max_content_bytes"`
+}
+
+// Limits converts the configuration into quota.Limits
+func (c QuotaLimitsConfig) Limits() quota.Limits {
+	return quota.Limits{MaxDocuments: c.MaxDocuments, MaxContentBytes: c.MaxContentBytes}
+}
+
+// S3Config holds settings for the S3/MinIO bucket connector
+type S3Config struct {
+	Enabled      bool   `koanf:"enabled"`
+	Endpoint     string `koanf:"endpoint"` // e.g. https://s3.eu-central-1.amazonaws.com or http://minio:9000
+	Region       string `koanf:"region"`
+	Bucket       string `koanf:"bucket"`
+	Prefix       string `koanf:"prefix"` // only keys starting with the prefix are ingested
+	AccessKey    string `koanf:"access_key"`
+	SecretKey    string `koanf:"secret_key"`
+	PathStyle    bool   `koanf:"path_style"`    // required by MinIO
+	Tenant       string `koanf:"tenant"`        // tenant the documents are ingested into
+	SyncInterval int    `koanf:"sync_interval"` // seconds between syncs; 0 syncs once at startup
+	Schedule     string `koanf:"schedule"`      // cron expression or "@every <duration>"; overrides sync_interval
+	Timeout      int    `koanf:"timeout"`       // seconds per request
+	MaxRetries   int    `koanf:"max_retries"`
+}
+
+// CrawlSchedule returns the schedule of the S3 connector's syncs, or nil to
+// sync once at startup
+func (c S3Config) CrawlSchedule() (scheduler.Schedule, error) {
+	return crawlSchedule(c.Schedule, c.SyncInterval)
+}
+
+// WebhooksConfig holds settings for event notifications
+type WebhooksConfig struct {
+	Endpoints  []WebhookEndpoint `koanf:"endpoints"`
+	Timeout    int               `koanf:"timeout"` // seconds per delivery attempt
+	MaxRetries int               `koanf:"max_retries"`
+	QueueSize  int               `koanf:"queue_size"` // pending events per endpoint before dead-lettering
+}
+
+// WebhookEndpoint is a receiver of event notifications
+type WebhookEndpoint struct {
+	URL    string   `koanf:"url"`
+	Events []string `koanf:"events"` // empty subscribes to all events
+	Secret string   `koanf:"secret"` // HMAC-SHA256 signing key; empty sends unsigned events
+}
+
+// QueryCacheConfig holds settings for the query response cache
+type QueryCacheConfig struct {
+	Enabled    bool `koanf:"enabled"`
+	TTL        int  `koanf:"ttl"` // seconds
+	MaxEntries int  `koanf:"max_entries"`
+}
+
+// MetricsConfig holds settings for query usage accounting
+type MetricsConfig struct {
+	Enabled bool `koanf:"enabled"` // account queries on /usage and export them on /metrics
+}
+
+// ConversationsConfig holds settings for multi-turn conversations
+type ConversationsConfig struct {
+	HistoryTokens int `koanf:"history_tokens"` // prompt budget for prior turns (estimated tokens)
+}
+
+// PromptsConfig holds settings for the prompt templates
+type PromptsConfig struct {
+	Dir            string `koanf:"dir"`             // directory of *.tmpl overrides; empty uses the built-in prompt
+	ReloadInterval int    `koanf:"reload_interval"` // seconds between checks for changed templates; 0 disables
+}
+
+// RedactionConfig holds the detectors of sensitive values replaced with
+// placeholders before documents are put into prompts
+type RedactionConfig struct {
+	Enabled   bool                     `koanf:"enabled"`
+	Detectors []string                 `koanf:"detectors"` // built-in detectors: ssn, ein, account_number, email
+	Patterns  []RedactionPatternConfig `koanf:"patterns"`  // custom detectors
+}
+
+// RedactionPatternConfig is a custom detector
+type RedactionPatternConfig struct {
+	Label   string `koanf:"label"`   // placeholder label, e.g. "POLICY" for [POLICY_1a2b3c4d]
+	Pattern string `koanf:"pattern"` // regular expression of the values
+}
+
+// Compile returns the configured detectors, built-in ones first
+func (c RedactionConfig) Compile() ([]redact.Detector, error) {
+	var detectors []redact.Detector
+	for _, name := range c.Detectors {
+		d, err := redact.Builtin(name)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, d)
+	}
+	for _, p := range c.Patterns {
+		d, err := redact.NewDetector(p.Label, p.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		detectors = append(detectors, d)
+	}
+	return detectors, nil
+}
+
+// InjectionGuardConfig holds settings for detecting prompt injection attempts in documents
+type InjectionGuardConfig struct {
+	Enabled        bool    `koanf:"enabled"`
+	Strip          bool    `koanf:"strip"`           // remove sentences with injection phrases from flagged content
+	Threshold      float64 `koanf:"threshold"`       // risk score (0 to 1) from which documents are flagged
+	ExcludeFlagged bool    `koanf:"exclude_flagged"` // keep flagged documents out of prompts
+}
+
+// ModerationConfig holds the content policy questions and generated answers
+// are checked against
+type ModerationConfig struct {
+	Enabled        bool                   `koanf:"enabled"`
+	Provider       string                 `koanf:"provider"` // "keyword" or "http"
+	BaseURL        string                 `koanf:"base_url"` // the full moderation endpoint for "http"
+	Model          string                 `koanf:"model"`
+	APIKey         string                 `koanf:"api_key"`         // bearer token for "http"
+	Timeout        int                    `koanf:"timeout"`         // seconds
+	QuestionAction string                 `koanf:"question_action"` // "block", "flag", "annotate", or "off"
+	AnswerAction   string                 `koanf:"answer_action"`   // "block", "flag", "annotate", or "off"
+	Rules          []ModerationRuleConfig `koanf:"rules"`           // content policy of the "keyword" provider
+}
+
+// ModerationRuleConfig flags content under a category
+type ModerationRuleConfig struct {
+	Category string   `koanf:"category"`
+	Keywords []string `koanf:"keywords"` // matched as whole words, ignoring case
+	Pattern  string   `koanf:"pattern"`  // regular expression
+}
+
+// CompileRules returns the rules of the "keyword" provider
+func (c ModerationConfig) CompileRules() ([]moderation.Rule, error) {
+	rules := make([]moderation.Rule, 0, len(c.Rules))
+	for _, r := range c.Rules {
+		rule, err := moderation.NewRule(r.Category, r.Keywords, r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
 }
 
 // SecurityConfig holds security-related settings
 type SecurityConfig struct {
-	AuthMode  string `koanf:"auth_mode"` // "mock" or "jwt"
-	JWTSecret string `koanf:"jwt_secret"`
+	AuthMode  string `koanf:"auth_mode"`  // "mock", "jwt", "kratos", or "oidc"
+	JWTSecret string `koanf:"jwt_secret"` // verifies tokens without a kid header
+	// JWTKeys are further signing keys as "kid=secret" entries separated by
+	// commas or newlines, selected by the kid header of a token. All listed
+	// keys are valid at once, so keys can be rotated without downtime.
+	JWTKeys   string `koanf:"jwt_keys"`
 	ErrorMode string `koanf:"error_mode"` // "detailed" or "secure"
+	// PermissionFailureMode decides access while Keto is unavailable: "closed"
+	// fails the request with 503, "deny" denies like a missing relation, and
+	// "open" grants reads while edit and write checks fail like "closed".
+	PermissionFailureMode string `koanf:"permission_failure_mode"`
+	// PermissionBackend decides permission checks: "keto" or "opa", an
+	// embedded Open Policy Agent evaluating Rego over a local tuple table
+	PermissionBackend string    `koanf:"permission_backend"`
+	OPA               OPAConfig `koanf:"opa"`
+	// APIKeys authenticates services with API keys alongside user auth
+	APIKeys APIKeysConfig `koanf:"api_keys"`
+	// ShareLinks enables time-limited links granting read access to a document
+	ShareLinks ShareLinksConfig `koanf:"share_links"`
+	// Directory defines the users and groups of the mock auth mode
+	Directory DirectoryConfig `koanf:"directory"`
+	Kratos    KratosConfig    `koanf:"kratos"`
+	OIDC      OIDCConfig      `koanf:"oidc"`
+}
+
+// OPAConfig holds the settings of the opa permission backend
+type OPAConfig struct {
+	// PolicyFile is a Rego policy of package rerag.authz; empty uses the
+	// built-in policy, which grants relations like Keto
+	PolicyFile string `koanf:"policy_file"`
+	// TuplesFile is the JSON file relations are saved to; empty keeps them
+	// in memory only
+	TuplesFile string `koanf:"tuples_file"`
+}
+
+// KratosConfig holds the Ory Kratos settings of the kratos auth mode
+type KratosConfig struct {
+	PublicURL string `koanf:"public_url"`
+	// UsernameTrait is the dot-separated path of the identity trait used as
+	// the username in permission checks; empty uses the identity ID
+	UsernameTrait string `koanf:"username_trait"`
+	// GroupsTrait and RolesTrait are the dot-separated paths of the traits
+	// holding the user's groups and roles; empty ignores them
+	GroupsTrait string `koanf:"groups_trait"`
+	RolesTrait  string `koanf:"roles_trait"`
+	// TenantTrait is the dot-separated path of the trait binding the user to
+	// a tenant; identities without it select the tenant with X-Tenant-ID
+	TenantTrait string `koanf:"tenant_trait"`
+	Timeout     int    `koanf:"timeout"` // seconds
+	MaxRetries  int    `koanf:"max_retries"`
+}
+
+// APIKeysConfig holds the service API key settings. Keys are created and
+// revoked through the /api-keys endpoints and stored hashed in the database.
+type APIKeysConfig struct {
+	Enabled bool `koanf:"enabled"`
+}
+
+// ShareLinksConfig holds the settings of document share links. Their tokens
+// are signed with Secret and grant read access through temporary Keto
+// relations, which are revoked every CleanupInterval once expired.
+type ShareLinksConfig struct {
+	Enabled         bool   `koanf:"enabled"`
+	Secret          string `koanf:"secret"`
+	DefaultTTL      int    `koanf:"default_ttl"`      // seconds
+	MaxTTL          int    `koanf:"max_ttl"`          // seconds
+	CleanupInterval int    `koanf:"cleanup_interval"` // seconds
+}
+
+// DirectoryConfig holds the user directory of the mock auth mode. Bearer
+// usernames are only admitted if they are directory users, who are managed
+// through the /admin/users and /admin/groups endpoints and stored in the
+// database. SeedFile is applied to the Tenant's directory on startup.
+type DirectoryConfig struct {
+	Enabled  bool   `koanf:"enabled"`
+	SeedFile string `koanf:"seed_file"` // YAML directory seed; empty seeds nothing
+	Tenant   string `koanf:"tenant"`    // tenant whose directory the seed applies to
+}
+
+// OIDCConfig holds the settings of the oidc auth mode. Access tokens are
+// verified against the issuer's JWKS, or with introspection_url if set.
+type OIDCConfig struct {
+	IssuerURL string `koanf:"issuer_url"`
+	// JWKSURL overrides the JWKS URL discovered from the issuer
+	JWKSURL          string `koanf:"jwks_url"`
+	Audience         string `koanf:"audience"`
+	IntrospectionURL string `koanf:"introspection_url"`
+	ClientID         string `koanf:"client_id"`
+	ClientSecret     string `koanf:"client_secret"`
+	UsernameClaim    string `koanf:"username_claim"`
+	GroupsClaim      string `koanf:"groups_claim"`
+	RolesClaim       string `koanf:"roles_claim"`
+	TenantClaim      string `koanf:"tenant_claim"`   // binds the user to a tenant; tokens without it select one with X-Tenant-ID
+	JWKSCacheTTL     int    `koanf:"jwks_cache_ttl"` // seconds
+	Timeout          int    `koanf:"timeout"`        // seconds
+	MaxRetries       int    `koanf:"max_retries"`
+}
+
+// Options converts the settings for the authenticator
+func (c OIDCConfig) Options() auth.OIDCOptions {
+	return auth.OIDCOptions{
+		Issuer:           c.IssuerURL,
+		JWKSURL:          c.JWKSURL,
+		Audience:         c.Audience,
+		IntrospectionURL: c.IntrospectionURL,
+		ClientID:         c.ClientID,
+		ClientSecret:     c.ClientSecret,
+		UsernameClaim:    c.UsernameClaim,
+		GroupsClaim:      c.GroupsClaim,
+		RolesClaim:       c.RolesClaim,
+		TenantClaim:      c.TenantClaim,
+		JWKSCacheTTL:     time.Duration(c.JWKSCacheTTL) * time.Second,
+	}
 }
 
 // AppConfig holds general application settings
@@ -99,23 +773,45 @@ type AppConfig struct {
 // Load loads configuration from multiple sources with precedence:
 // 1. config.yaml (if exists)
 // 2. config.json (if exists)
-// 3. Environment variables (highest precedence)
+// 3. Environment variables named by EnvName (highest precedence)
 func Load() (*Config, error) {
+	k, err := loadSources(false)
+	if err != nil {
+		return nil, err
+	}
+	return parse(k)
+}
+
+// loadSources reads the defaults, config files, and environment variables.
+// Unreadable config files are skipped with a warning unless strict is set.
+func loadSources(strict bool) (*koanf.Koanf, error) {
 	k := koanf.New(".")
 
 	// Set defaults
 	setDefaults(k)
 
 	// Load from config files (optional)
-	loadConfigFiles(k)
+	if err := loadConfigFiles(k); err != nil {
+		if strict {
+			return nil, err
+		}
+		log.Printf("Warning: %v", err)
+	}
 
 	// Load from environment variables (highest precedence)
-	// Use simple prefix matching for now
-	if err := k.Load(env.Provider(".", env.Opt{}), nil); err != nil {
+	if err := k.Load(envProvider(), nil); err != nil {
 		return nil, fmt.Errorf("error loading environment variables: %w", err)
 	}
 
-	// Unmarshal into config struct
+	// Read secrets from files and secret managers
+	if err := resolveSecrets(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// parse unmarshals and validates the configuration in k
+func parse(k *koanf.Koanf) (*Config, error) {
 	var cfg Config
 	if err := k.Unmarshal("", &cfg); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
@@ -137,25 +833,179 @@ func setDefaults(k *koanf.Koanf) {
 		"server.port":            4477,
 		"server.read_timeout":    30,
 		"server.write_timeout":   30,
+		"server.query_timeout":   25,
 		"server.tls.enabled":     false,
 		"server.tls.min_version": "1.3",
 
+		// Security header defaults
+		"server.security_headers.enabled":                 true,
+		"server.security_headers.hsts_max_age":            31536000,
+		"server.security_headers.hsts_include_subdomains": true,
+		"server.security_headers.content_security_policy": "default-src 'none'; frame-ancestors 'none'",
+
 		// Database defaults
-		"database.path":               "data/vector_store.db?mode=rwc",
-		"database.encryption.enabled": false,
+		"database.driver":                   "sqlite",
+		"database.path":                     "data/vector_store.db?mode=rwc",
+		"database.encryption.enabled":       false,
+		"database.journal_mode":             "wal",
+		"database.busy_timeout":             5,
+		"database.max_open_conns":           4,
+		"database.vector.metric":            "l2",
+		"database.vector.quantization":      "none",
+		"database.vector.rescore":           storage.DefaultRescore,
+		"database.trash.retention_days":     30,
+		"database.trash.purge_interval":     3600,
+		"database.retention.enabled":        false,
+		"database.retention.metadata_key":   "expires_at",
+		"database.retention.check_interval": 3600,
+		"database.orphans.enabled":          false,
+		"database.orphans.policy":           "flag",
+		"database.orphans.min_age_days":     7,
+		"database.orphans.check_interval":   86400,
 
 		// Services defaults
-		"services.ollama.base_url":        "http://localhost:11434",
-		"services.ollama.embedding_model": "nomic-embed-text",
-		"services.ollama.llm_model":       "llama3.2:1b",
-		"services.ollama.timeout":         60,
-		"services.keto.read_url":          "http://localhost:4466",
-		"services.keto.write_url":         "http://localhost:4467",
-		"services.keto.timeout":           10,
+		"services.ollama.base_url":                          "http://localhost:11434",
+		"services.ollama.embedding_model":                   "nomic-embed-text",
+		"services.ollama.llm_model":                         "llama3.2:1b",
+		"services.ollama.timeout":                           60,
+		"services.ollama.context_tokens":                    4096,
+		"services.ollama.response_tokens":                   512,
+		"services.ollama.max_retries":                       2,
+		"services.ollama.circuit_breaker.failure_threshold": 5,
+		"services.ollama.circuit_breaker.cooldown":          30,
+		"services.keto.read_url":                            "http://localhost:4466",
+		"services.keto.write_url":                           "http://localhost:4467",
+		"services.keto.timeout":                             10,
+		"services.keto.check_strategy":                      "check",
+		"services.keto.list_limit":                          permissions.DefaultListLimit,
+		"services.keto.startup_check":                       string(permissions.SelfCheckWarn),
+		"services.keto.cache.enabled":                       true,
+		"services.keto.cache.ttl":                           30,
+		"services.keto.cache.max_entries":                   10000,
+		"services.keto.policy.tenant":                       "default",
+		"services.keto.namespaces.documents":                permissions.DefaultNaming().DocumentsNamespace,
+		"services.keto.namespaces.groups":                   permissions.DefaultNaming().GroupsNamespace,
+		"services.keto.relations.viewer":                    permissions.RelationViewer,
+		"services.keto.relations.editor":                    permissions.RelationEditor,
+		"services.keto.relations.write":                     permissions.RelationWrite,
+		"services.keto.relations.member":                    permissions.RelationMember,
+		"services.keto.subject_format":                      permissions.SubjectPlaceholder,
+		"services.reranker.enabled":                         false,
+		"services.reranker.provider":                        "ollama",
+		"services.reranker.base_url":                        "http://localhost:11434",
+		"services.reranker.model":                           "llama3.2:1b",
+		"services.reranker.timeout":                         30,
+		"services.reranker.candidates":                      10,
+
+		// Search defaults
+		"search.hybrid.vector_weight":  1.0,
+		"search.hybrid.keyword_weight": 1.0,
+		"search.hybrid.rrf_k":          60,
+		"search.require_sources":       true,
+		"search.report_hidden":         false,
+		"search.agent.max_searches":    3,
+		"search.routing.metadata_keys": []string{"type", "year", "taxpayer"},
+		"search.routing.max_documents": 10000,
+		"search.max_question_length":   ragservice.DefaultMaxQuestionLength,
+		"search.chunk_score":           storage.ChunkScoreMax,
+
+		// Ingestion defaults
+		"ingestion.chunk_size":       2000,
+		"ingestion.chunk_overlap":    200,
+		"ingestion.chunk_vectors":    false,
+		"ingestion.max_upload_size":  20,
+		"ingestion.s3.enabled":       false,
+		"ingestion.s3.region":        "us-east-1",
+		"ingestion.s3.path_style":    false,
+		"ingestion.s3.tenant":        "default",
+		"ingestion.s3.sync_interval": 300,
+		"ingestion.s3.schedule":      "",
+		"ingestion.s3.timeout":       60,
+		"ingestion.s3.max_retries":   2,
+
+		// Original file defaults
+		"ingestion.originals.backend":        "",
+		"ingestion.originals.dir":            "./data/originals",
+		"ingestion.originals.s3.region":      "us-east-1",
+		"ingestion.originals.s3.path_style":  false,
+		"ingestion.originals.s3.timeout":     60,
+		"ingestion.originals.s3.max_retries": 2,
+
+		// Drive connector defaults
+		"ingestion.drive.enabled":       false,
+		"ingestion.drive.provider":      "google",
+		"ingestion.drive.tenant":        "default",
+		"ingestion.drive.sync_interval": 300,
+		"ingestion.drive.schedule":      "",
+		"ingestion.drive.timeout":       60,
+		"ingestion.drive.max_retries":   2,
+
+		// Webhook defaults
+		"webhooks.timeout":     10,
+		"webhooks.max_retries": 3,
+		"webhooks.queue_size":  1000,
+
+		// Query cache defaults
+		"query_cache.enabled":     false,
+		"query_cache.ttl":         300,
+		"query_cache.max_entries": 1000,
+
+		// Metrics defaults
+		"metrics.enabled": true,
+
+		// Conversation defaults
+		"conversations.history_tokens": 1024,
+
+		// Prompt defaults
+		"prompts.dir":             "",
+		"prompts.reload_interval": 10,
+
+		// Redaction defaults
+		"redaction.enabled":   false,
+		"redaction.detectors": []string{"ssn", "ein", "account_number", "email"},
+
+		// Injection guard defaults
+		"injection_guard.enabled":         false,
+		"injection_guard.strip":           true,
+		"injection_guard.threshold":       0.5,
+		"injection_guard.exclude_flagged": false,
+
+		// Moderation defaults
+		"moderation.enabled":         false,
+		"moderation.provider":        "keyword",
+		"moderation.timeout":         10,
+		"moderation.question_action": "block",
+		"moderation.answer_action":   "block",
 
 		// Security defaults
-		"security.auth_mode":  "mock",
-		"security.error_mode": "detailed",
+		"security.auth_mode":               "mock",
+		"security.error_mode":              "detailed",
+		"security.permission_failure_mode": "closed",
+		"security.permission_backend":      "keto",
+		"security.kratos.public_url":       "http://localhost:4433",
+		"security.kratos.username_trait":   "email",
+		"security.kratos.tenant_trait":     "tenant",
+		"security.kratos.timeout":          5,
+		"security.kratos.max_retries":      2,
+		"security.oidc.username_claim":     "sub",
+		"security.oidc.groups_claim":       "groups",
+		"security.oidc.roles_claim":        "roles",
+		"security.oidc.tenant_claim":       "tenant",
+		"security.oidc.jwks_cache_ttl":     3600,
+		"security.oidc.timeout":            5,
+		"security.oidc.max_retries":        2,
+
+		// Share link defaults
+		"security.share_links.default_ttl":      86400,
+		"security.share_links.max_ttl":          604800,
+		"security.share_links.cleanup_interval": 300,
+
+		// Directory defaults
+		"security.directory.tenant": "default",
+
+		// Secret manager defaults
+		"secrets.vault.timeout": 10,
+		"secrets.aws.timeout":   10,
 
 		// App defaults
 		"app.environment": "development",
@@ -168,21 +1018,26 @@ func setDefaults(k *koanf.Koanf) {
 	}
 }
 
-// loadConfigFiles loads configuration from files
-func loadConfigFiles(k *koanf.Koanf) {
-	// Try to load YAML config
-	if _, err := os.Stat("config.yaml"); err == nil {
-		if err := k.Load(file.Provider("config.yaml"), yaml.Parser()); err != nil {
-			log.Printf("Warning: failed to load config.yaml: %v", err)
-		}
-	}
+// configFiles are the optional config files in load order
+var configFiles = []string{"config.yaml", "config.json"}
 
-	// Try to load JSON config
-	if _, err := os.Stat("config.json"); err == nil {
-		if err := k.Load(file.Provider("config.json"), json.Parser()); err != nil {
-			log.Printf("Warning: failed to load config.json: %v", err)
+// loadConfigFiles loads configuration from the existing files; a file that
+// fails to load is skipped and reported in the returned error
+func loadConfigFiles(k *koanf.Koanf) error {
+	var errs []error
+	for _, path := range configFiles {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		var parser koanf.Parser = yaml.Parser()
+		if strings.HasSuffix(path, ".json") {
+			parser = json.Parser()
+		}
+		if err := k.Load(file.Provider(path), parser); err != nil {
+			errs = append(errs, fmt.Errorf("failed to load %s: %w", path, err))
 		}
 	}
+	return errors.Join(errs...)
 }
 
 // validate validates the configuration
@@ -204,24 +1059,360 @@ func validate(cfg *Config) error {
 			return fmt.Errorf("TLS key file does not exist: %s", cfg.Server.TLS.KeyFile)
 		}
 	}
+	if clientAuth := cfg.Server.TLS.ClientAuth; clientAuth.Enabled {
+		if !cfg.Server.TLS.Enabled || clientAuth.CAFile == "" {
+			return fmt.Errorf("TLS client auth requires TLS and a CA file")
+		}
+		if _, err := os.Stat(clientAuth.CAFile); os.IsNotExist(err) {
+			return fmt.Errorf("TLS client CA file does not exist: %s", clientAuth.CAFile)
+		}
+	}
+
+	if cfg.Server.QueryTimeout < 0 {
+		return fmt.Errorf("server query_timeout must not be negative")
+	}
+
+	// Validate security headers
+	if cfg.Server.SecurityHeaders.HSTSMaxAge < 0 {
+		return fmt.Errorf("server security_headers hsts_max_age must not be negative")
+	}
+
+	// Validate trusted proxies
+	if _, err := clientip.ParseTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return fmt.Errorf("server trusted_proxies: %w", err)
+	}
 
 	// Validate database encryption
 	if cfg.Database.Encryption.Enabled && cfg.Database.Encryption.Key == "" {
 		return fmt.Errorf("database encryption key is required when encryption is enabled")
 	}
 
+	// Validate database driver; the memory driver has no SQL tables for encryption, ingestion cursors, API keys, or share links
+	switch cfg.Database.Driver {
+	case "sqlite":
+		if !slices.Contains([]string{"wal", "delete", "truncate", "persist"}, strings.ToLower(cfg.Database.JournalMode)) {
+			return fmt.Errorf("database journal_mode must be wal, delete, truncate, or persist, got %q", cfg.Database.JournalMode)
+		}
+		if cfg.Database.BusyTimeout < 0 || cfg.Database.MaxOpenConns <= 0 {
+			return fmt.Errorf("database busy_timeout must not be negative and max_open_conns must be positive")
+		}
+		for _, key := range cfg.Database.IndexedMetadata {
+			if err := storage.ValidateMetadataFilter(map[string]string{key: ""}); err != nil {
+				return fmt.Errorf("database indexed_metadata: %w", err)
+			}
+		}
+		if err := cfg.Database.Vector.Index().Validate(); err != nil {
+			return fmt.Errorf("database vector: %w", err)
+		}
+		if cfg.Database.Vector.Rescore < 0 {
+			return fmt.Errorf("database vector rescore must not be negative")
+		}
+	case "memory":
+		if cfg.Database.Encryption.Enabled || cfg.Ingestion.S3.Enabled || cfg.Ingestion.Drive.Enabled || cfg.Security.APIKeys.Enabled || cfg.Security.ShareLinks.Enabled || cfg.Security.Directory.Enabled {
+			return fmt.Errorf("database encryption, the s3 and drive connectors, api keys, share links, and the directory require the sqlite driver")
+		}
+	default:
+		return fmt.Errorf("database driver must be sqlite or memory, got %q", cfg.Database.Driver)
+	}
+
+	// Validate trash purging
+	if cfg.Database.Trash.RetentionDays < 0 || (cfg.Database.Trash.RetentionDays > 0 && cfg.Database.Trash.PurgeInterval <= 0) {
+		return fmt.Errorf("database trash retention_days must not be negative and purge_interval must be positive")
+	}
+
+	// Validate document retention
+	if retention := cfg.Database.Retention; retention.Enabled {
+		if retention.MaxAgeDays < 0 || retention.CheckInterval <= 0 {
+			return fmt.Errorf("database retention max_age_days must not be negative and check_interval must be positive")
+		}
+		if retention.MaxAgeDays == 0 && retention.MetadataKey == "" {
+			return fmt.Errorf("database retention requires max_age_days or metadata_key")
+		}
+		if retention.MetadataKey != "" {
+			if err := storage.ValidateMetadataFilter(map[string]string{retention.MetadataKey: ""}); err != nil {
+				return fmt.Errorf("database retention metadata_key: %w", err)
+			}
+		}
+	}
+
+	// Validate orphan reaping
+	if orphansCfg := cfg.Database.Orphans; orphansCfg.Enabled {
+		if !orphans.Policy(orphansCfg.Policy).IsValid() {
+			return fmt.Errorf("database orphans policy must be one of: flag, quarantine, delete")
+		}
+		if orphansCfg.MinAgeDays < 0 || orphansCfg.CheckInterval <= 0 {
+			return fmt.Errorf("database orphans min_age_days must not be negative and check_interval must be positive")
+		}
+	}
+
+	// Validate Keto client settings
+	if cfg.Services.Keto.Timeout <= 0 || cfg.Services.Keto.MaxRetries < 0 {
+		return fmt.Errorf("keto timeout must be positive and max_retries non-negative")
+	}
+
+	// Validate batch check strategy
+	if !permissions.CheckStrategy(cfg.Services.Keto.CheckStrategy).IsValid() {
+		return fmt.Errorf("invalid keto check_strategy: %s (must be 'check' or 'list')", cfg.Services.Keto.CheckStrategy)
+	}
+	if cfg.Services.Keto.ListLimit <= 0 {
+		return fmt.Errorf("keto list_limit must be positive")
+	}
+	if !permissions.SelfCheckMode(cfg.Services.Keto.StartupCheck).IsValid() {
+		return fmt.Errorf("invalid keto startup_check: %s (must be 'strict', 'warn', or 'off')", cfg.Services.Keto.StartupCheck)
+	}
+
+	// Validate permission cache settings
+	if cfg.Services.Keto.Cache.Enabled && (cfg.Services.Keto.Cache.TTL <= 0 || cfg.Services.Keto.Cache.MaxEntries <= 0) {
+		return fmt.Errorf("permission cache ttl and max_entries must be positive when the cache is enabled")
+	}
+
+	// Validate LLM model; it may change on reload
+	if cfg.Services.Ollama.LLMModel == "" {
+		return fmt.Errorf("ollama llm_model is required")
+	}
+
+	// Validate prompt budget
+	if ollama := cfg.Services.Ollama; ollama.ContextTokens > 0 && ollama.ResponseTokens >= ollama.ContextTokens {
+		return fmt.Errorf("ollama response_tokens must be smaller than context_tokens")
+	}
+
+	// Validate Ollama resilience settings
+	if ollama := cfg.Services.Ollama; ollama.Timeout <= 0 || ollama.MaxRetries < 0 || ollama.Breaker.FailureThreshold < 0 || ollama.Breaker.Cooldown < 0 {
+		return fmt.Errorf("ollama timeout must be positive and max_retries and circuit_breaker settings non-negative")
+	}
+
+	// Validate reranker settings
+	if reranker := cfg.Services.Reranker; reranker.Enabled {
+		if reranker.Provider != "ollama" && reranker.Provider != "http" {
+			return fmt.Errorf("reranker provider must be ollama or http, got %q", reranker.Provider)
+		}
+		if reranker.BaseURL == "" {
+			return fmt.Errorf("reranker base_url is required when the reranker is enabled")
+		}
+		if reranker.Candidates <= 0 {
+			return fmt.Errorf("reranker candidates must be positive")
+		}
+	}
+
+	// Validate hybrid search settings
+	hybrid := cfg.Search.Hybrid
+	if hybrid.VectorWeight < 0 || hybrid.KeywordWeight < 0 || hybrid.VectorWeight+hybrid.KeywordWeight == 0 {
+		return fmt.Errorf("hybrid search weights must be non-negative and not both zero")
+	}
+	if hybrid.RRFK <= 0 {
+		return fmt.Errorf("hybrid search rrf_k must be positive")
+	}
+	if cfg.Search.Agent.MaxSearches < 0 {
+		return fmt.Errorf("search agent max_searches must not be negative")
+	}
+	if routing := cfg.Search.Routing; routing.Enabled {
+		if routing.MaxDocuments <= 0 {
+			return fmt.Errorf("search routing max_documents must be positive")
+		}
+		for _, key := range routing.MetadataKeys {
+			if err := storage.ValidateMetadataFilter(map[string]string{key: ""}); err != nil {
+				return fmt.Errorf("search routing metadata_keys: %w", err)
+			}
+		}
+	}
+	if cfg.Search.MaxQuestionLength <= 0 {
+		return fmt.Errorf("search max_question_length must be positive")
+	}
+	if score := cfg.Search.ChunkScore; score != storage.ChunkScoreMax && score != storage.ChunkScoreMean {
+		return fmt.Errorf("search chunk_score must be %q or %q, got %q", storage.ChunkScoreMax, storage.ChunkScoreMean, score)
+	}
+
+	// Validate ingestion settings
+	if ingestion := cfg.Ingestion; ingestion.ChunkSize <= 0 || ingestion.ChunkOverlap < 0 || ingestion.ChunkOverlap >= ingestion.ChunkSize || ingestion.MaxUploadSize <= 0 {
+		return fmt.Errorf("ingestion chunk_size and max_upload_size must be positive and chunk_overlap smaller than chunk_size")
+	}
+	for _, limits := range []QuotaLimitsConfig{cfg.Ingestion.Quotas.Tenant, cfg.Ingestion.Quotas.User} {
+		if limits.MaxDocuments < 0 || limits.MaxContentBytes < 0 {
+			return fmt.Errorf("ingestion quotas must not be negative")
+		}
+	}
+
+	naming := cfg.Services.Keto.Naming()
+	if err := naming.Validate(); err != nil {
+		return fmt.Errorf("keto naming: %w", err)
+	}
+	for _, rule := range cfg.Services.Keto.AttributeRules {
+		if err := rule.Rule().Validate(naming); err != nil {
+			return fmt.Errorf("keto attribute_rules: %w", err)
+		}
+	}
+
+	if policy := cfg.Services.Keto.Policy; policy.File != "" && !tenant.IsValid(policy.Tenant) {
+		return fmt.Errorf("keto policy tenant %q is not a valid tenant ID", policy.Tenant)
+	}
+
+	if s3 := cfg.Ingestion.S3; s3.Enabled {
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.Region == "" {
+			return fmt.Errorf("ingestion s3 endpoint, region, and bucket are required when the connector is enabled")
+		}
+		if !tenant.IsValid(s3.Tenant) {
+			return fmt.Errorf("ingestion s3 tenant %q is not a valid tenant ID", s3.Tenant)
+		}
+		if s3.SyncInterval < 0 || s3.Timeout <= 0 || s3.MaxRetries < 0 {
+			return fmt.Errorf("ingestion s3 sync_interval and max_retries must not be negative and timeout must be positive")
+		}
+		if _, err := s3.CrawlSchedule(); err != nil {
+			return fmt.Errorf("ingestion s3 schedule: %w", err)
+		}
+	}
+
+	if drive := cfg.Ingestion.Drive; drive.Enabled {
+		if err := validateDrive(drive); err != nil {
+			return err
+		}
+	}
+
+	switch originals := cfg.Ingestion.Originals; originals.Backend {
+	case "":
+	case "filesystem":
+		if originals.Dir == "" {
+			return fmt.Errorf("ingestion originals dir is required with the filesystem backend")
+		}
+	case "s3":
+		if originals.S3.Endpoint == "" || originals.S3.Bucket == "" || originals.S3.Region == "" {
+			return fmt.Errorf("ingestion originals s3 endpoint, region, and bucket are required with the s3 backend")
+		}
+		if originals.S3.Timeout <= 0 || originals.S3.MaxRetries < 0 {
+			return fmt.Errorf("ingestion originals s3 timeout must be positive and max_retries must not be negative")
+		}
+	default:
+		return fmt.Errorf("ingestion originals backend must be filesystem or s3, got %q", originals.Backend)
+	}
+
+	// Validate webhook settings
+	if err := validateWebhooks(cfg.Webhooks); err != nil {
+		return err
+	}
+
+	// Validate query cache settings
+	if cfg.QueryCache.Enabled && (cfg.QueryCache.TTL <= 0 || cfg.QueryCache.MaxEntries <= 0) {
+		return fmt.Errorf("query cache ttl and max_entries must be positive when the cache is enabled")
+	}
+
+	// Validate conversation settings
+	if cfg.Conversations.HistoryTokens <= 0 {
+		return fmt.Errorf("conversations history_tokens must be positive")
+	}
+
+	// Validate redaction settings
+	if cfg.Redaction.Enabled {
+		if _, err := cfg.Redaction.Compile(); err != nil {
+			return fmt.Errorf("redaction: %w", err)
+		}
+	}
+
+	// Validate injection guard settings
+	if guard := cfg.InjectionGuard; guard.Enabled && (guard.Threshold <= 0 || guard.Threshold > 1) {
+		return fmt.Errorf("injection_guard threshold must be greater than 0 and at most 1")
+	}
+
+	// Validate moderation settings
+	if mod := cfg.Moderation; mod.Enabled {
+		for _, action := range []string{mod.QuestionAction, mod.AnswerAction} {
+			if action != "off" && !moderation.Action(action).IsValid() {
+				return fmt.Errorf("moderation actions must be block, flag, annotate, or off, got %q", action)
+			}
+		}
+		switch mod.Provider {
+		case "keyword":
+			if len(mod.Rules) == 0 {
+				return fmt.Errorf("moderation rules are required for the keyword provider")
+			}
+			if _, err := mod.CompileRules(); err != nil {
+				return fmt.Errorf("moderation: %w", err)
+			}
+		case "http":
+			if mod.BaseURL == "" {
+				return fmt.Errorf("moderation base_url is required for the http provider")
+			}
+			if mod.Timeout <= 0 {
+				return fmt.Errorf("moderation timeout must be positive")
+			}
+		default:
+			return fmt.Errorf("moderation provider must be keyword or http, got %q", mod.Provider)
+		}
+	}
+
+	// Validate share links; the secret signs tokens, so it must resist guessing
+	if links := cfg.Security.ShareLinks; links.Enabled {
+		if len(links.Secret) < 32 {
+			return fmt.Errorf("security share_links secret must be at least 32 characters")
+		}
+		if links.DefaultTTL <= 0 || links.MaxTTL < links.DefaultTTL || links.CleanupInterval <= 0 {
+			return fmt.Errorf("security share_links default_ttl and cleanup_interval must be positive and max_ttl at least default_ttl")
+		}
+	}
+
+	// The directory replaces the trusted bearer usernames of the mock mode
+	if directory := cfg.Security.Directory; directory.Enabled {
+		if cfg.Security.AuthMode != "mock" {
+			return fmt.Errorf("security directory requires auth_mode mock, got %q", cfg.Security.AuthMode)
+		}
+		if directory.SeedFile != "" && !tenant.IsValid(directory.Tenant) {
+			return fmt.Errorf("security directory tenant %q is not a valid tenant ID", directory.Tenant)
+		}
+	}
+
 	// Validate security settings
-	if cfg.Security.AuthMode == "jwt" && cfg.Security.JWTSecret == "" {
-		return fmt.Errorf("JWT secret is required when auth mode is jwt")
+	switch cfg.Security.AuthMode {
+	case "mock":
+	case "jwt":
+		if cfg.Security.JWTSecret == "" && cfg.Security.JWTKeys == "" {
+			return fmt.Errorf("JWT secret or keys are required when auth mode is jwt")
+		}
+	case "kratos":
+		kratos := cfg.Security.Kratos
+		if u, err := url.Parse(kratos.PublicURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("security kratos public_url must be an absolute URL, got %q", kratos.PublicURL)
+		}
+		if kratos.Timeout <= 0 || kratos.MaxRetries < 0 {
+			return fmt.Errorf("security kratos timeout must be positive and max_retries non-negative")
+		}
+	case "oidc":
+		oidc := cfg.Security.OIDC
+		if oidc.IssuerURL == "" && oidc.JWKSURL == "" && oidc.IntrospectionURL == "" {
+			return fmt.Errorf("security oidc requires issuer_url, jwks_url, or introspection_url")
+		}
+		for _, raw := range []string{oidc.IssuerURL, oidc.JWKSURL, oidc.IntrospectionURL} {
+			if u, err := url.Parse(raw); raw != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+				return fmt.Errorf("security oidc URLs must be absolute, got %q", raw)
+			}
+		}
+		if oidc.UsernameClaim == "" {
+			return fmt.Errorf("security oidc username_claim is required")
+		}
+		if oidc.JWKSCacheTTL <= 0 || oidc.Timeout <= 0 || oidc.MaxRetries < 0 {
+			return fmt.Errorf("security oidc jwks_cache_ttl and timeout must be positive and max_retries non-negative")
+		}
+	default:
+		return fmt.Errorf("security auth_mode must be mock, jwt, kratos, or oidc, got %q", cfg.Security.AuthMode)
+	}
+	if mode := cfg.Security.PermissionFailureMode; mode != "closed" && mode != "deny" && mode != "open" {
+		return fmt.Errorf("permission_failure_mode must be closed, deny, or open, got %q", mode)
+	}
+	if backend := cfg.Security.PermissionBackend; backend != "keto" && backend != "opa" {
+		return fmt.Errorf("permission_backend must be keto or opa, got %q", backend)
+	}
+
+	// Validate log level; it may change on reload
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, cfg.App.LogLevel) {
+		return fmt.Errorf("app log_level must be debug, info, warn, or error, got %q", cfg.App.LogLevel)
 	}
 
 	return nil
 }
 
-// GetTLSConfig returns a TLS configuration based on the config
-func (c *Config) GetTLSConfig() *tls.Config {
+// GetTLSConfig returns a TLS configuration based on the config. With client
+// auth, client certificates are requested and verified against the CA file
+// but not required by the handshake.
+func (c *Config) GetTLSConfig() (*tls.Config, error) {
 	if !c.Server.TLS.Enabled {
-		return nil
+		return nil, nil
 	}
 
 	tlsConfig := &tls.Config{
@@ -244,19 +1435,104 @@ func (c *Config) GetTLSConfig() *tls.Config {
 		tlsConfig.MinVersion = tls.VersionTLS13
 	}
 
-	return tlsConfig
+	if clientAuth := c.Server.TLS.ClientAuth; clientAuth.Enabled {
+		pem, err := os.ReadFile(clientAuth.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS client CA file %s contains no certificates", clientAuth.CAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
 }
 
-// GetDatabaseDSN returns the database connection string with encryption if enabled
+// GetDatabaseDSN returns the database connection string with encryption if
+// enabled. The key parameters are only honored by servers built with the
+// sqlcipher tag.
 func (c *Config) GetDatabaseDSN() string {
 	if c.Database.Encryption.Enabled {
+		sep := "?"
+		if strings.Contains(c.Database.Path, "?") {
+			sep = "&"
+		}
 		// SQLCipher format
-		return fmt.Sprintf("%s?_pragma_key=%s&_pragma_cipher_page_size=4096",
-			c.Database.Path, c.Database.Encryption.Key)
+		return fmt.Sprintf("%s%s_pragma_key=%s&_pragma_cipher_page_size=4096",
+			c.Database.Path, sep, url.QueryEscape(c.Database.Encryption.Key))
 	}
 	return c.Database.Path
 }
 
+// validateDrive checks the settings of an enabled drive connector
+func validateDrive(drive DriveConfig) error {
+	switch drive.Provider {
+	case "google":
+		if drive.Google.CredentialsFile == "" {
+			return fmt.Errorf("ingestion drive google credentials_file is required")
+		}
+	case "sharepoint":
+		sp := drive.SharePoint
+		if sp.TenantID == "" || sp.ClientID == "" || sp.ClientSecret == "" || sp.DriveID == "" {
+			return fmt.Errorf("ingestion drive sharepoint tenant_id, client_id, client_secret, and drive_id are required")
+		}
+	default:
+		return fmt.Errorf("ingestion drive provider must be google or sharepoint, got %q", drive.Provider)
+	}
+	if !tenant.IsValid(drive.Tenant) {
+		return fmt.Errorf("ingestion drive tenant %q is not a valid tenant ID", drive.Tenant)
+	}
+	if drive.SyncInterval < 0 || drive.Timeout <= 0 || drive.MaxRetries < 0 {
+		return fmt.Errorf("ingestion drive sync_interval and max_retries must not be negative and timeout must be positive")
+	}
+	if _, err := drive.CrawlSchedule(); err != nil {
+		return fmt.Errorf("ingestion drive schedule: %w", err)
+	}
+
+	ids := drive.Identities
+	for _, user := range ids.Users {
+		if user.Email == "" || user.Name == "" {
+			return fmt.Errorf("ingestion drive identities users need an email and a name")
+		}
+	}
+	for _, group := range ids.Groups {
+		if group.Email == "" {
+			return fmt.Errorf("ingestion drive identities groups need an email or site group name")
+		}
+		if err := permissions.ValidateGroupName(group.Name); err != nil {
+			return fmt.Errorf("ingestion drive identities group %s: %w", group.Email, err)
+		}
+	}
+	if ids.EveryoneGroup != "" {
+		if err := permissions.ValidateGroupName(ids.EveryoneGroup); err != nil {
+			return fmt.Errorf("ingestion drive identities everyone_group: %w", err)
+		}
+	}
+	return nil
+}
+
+// validateWebhooks checks endpoint URLs and subscribed event types
+func validateWebhooks(cfg WebhooksConfig) error {
+	if len(cfg.Endpoints) > 0 && (cfg.Timeout <= 0 || cfg.MaxRetries < 0 || cfg.QueueSize <= 0) {
+		return fmt.Errorf("webhooks timeout and queue_size must be positive and max_retries not negative")
+	}
+	for _, endpoint := range cfg.Endpoints {
+		u, err := url.Parse(endpoint.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook url %q must be an absolute http or https URL", endpoint.URL)
+		}
+		for _, event := range endpoint.Events {
+			if !webhooks.EventType(event).IsValid() {
+				return fmt.Errorf("webhook %s subscribes to unknown event %q", endpoint.URL, event)
+			}
+		}
+	}
+	return nil
+}
+
 // IsProduction returns true if running in production environment
 func (c *Config) IsProduction() bool {
 	return c.App.Environment == "production"
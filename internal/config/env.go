@@ -0,0 +1,69 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/knadh/koanf/providers/env/v2"
+)
+
+// EnvPrefix starts the names of the environment variables overriding settings
+const EnvPrefix = "RERAG_"
+
+// EnvName returns the environment variable overriding the setting with key:
+// EnvPrefix followed by the upper-cased key with "__" between nested keys, so
+// server.port is set by RERAG_SERVER__PORT and security.jwt_secret by
+// RERAG_SECURITY__JWT_SECRET. List settings take comma-separated values.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "__"))
+}
+
+// envKey returns the setting key of an environment variable; the inverse of EnvName
+func envKey(name string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(name, EnvPrefix), "__", "."))
+}
+
+// envProvider loads the settings of the prefixed environment variables
+func envProvider() *env.Env {
+	lists := make(map[string]bool)
+	visitSettings(reflect.TypeOf(Config{}), "", nil, func(key string, field reflect.StructField, _ []int) {
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() != reflect.Struct {
+			lists[key] = true
+		}
+	})
+
+	return env.Provider(".", env.Opt{
+		Prefix: EnvPrefix,
+		TransformFunc: func(name, value string) (string, any) {
+			key := envKey(name)
+			if !lists[key] {
+				return key, value
+			}
+			items := strings.Split(value, ",")
+			for i := range items {
+				items[i] = strings.TrimSpace(items[i])
+			}
+			return key, items
+		},
+	})
+}
+
+// visitSettings calls visit for every setting of the struct type t with its
+// key, field, and index path. Nested structs are descended into; lists of
+// structs, which cannot be set from the environment, are visited as a whole.
+func visitSettings(t reflect.Type, prefix string, index []int, visit func(key string, field reflect.StructField, index []int)) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := field.Tag.Get("koanf")
+		if name == "" {
+			continue
+		}
+		key := prefix + name
+		fieldIndex := append(append([]int(nil), index...), i)
+		if field.Type.Kind() == reflect.Struct {
+			visitSettings(field.Type, key+".", fieldIndex, visit)
+			continue
+		}
+		visit(key, field, fieldIndex)
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	for key, name := range map[string]string{
+		"server.port":                     "RERAG_SERVER__PORT",
+		"security.jwt_secret":             "RERAG_SECURITY__JWT_SECRET",
+		"security.jwt_secret_file":        "RERAG_SECURITY__JWT_SECRET_FILE",
+		"database.encryption.key_file":    "RERAG_DATABASE__ENCRYPTION__KEY_FILE",
+		"services.ollama.embedding_model": "RERAG_SERVICES__OLLAMA__EMBEDDING_MODEL",
+	} {
+		if got := EnvName(key); got != name {
+			t.Errorf("EnvName(%q) = %q, expected %q", key, got, name)
+		}
+		if got := envKey(name); got != key {
+			t.Errorf("envKey(%q) = %q, expected %q", name, got, key)
+		}
+	}
+}
+
+// TestEnvOverridesEverySetting sets every setting to a value differing from
+// its default through its environment variable and expects it to be loaded
+func TestEnvOverridesEverySetting(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	defaults, err := loadSources(true)
+	if err != nil {
+		t.Fatalf("failed to load defaults: %v", err)
+	}
+	var base Config
+	if err := defaults.Unmarshal("", &base); err != nil {
+		t.Fatalf("failed to unmarshal defaults: %v", err)
+	}
+
+	expected := make(map[string]interface{})
+	index := make(map[string][]int)
+	visitSettings(reflect.TypeOf(Config{}), "", nil, func(key string, field reflect.StructField, fieldIndex []int) {
+		current := reflect.ValueOf(base).FieldByIndex(fieldIndex)
+		var value string
+		var want interface{}
+		switch field.Type.Kind() {
+		case reflect.String:
+			value = "env-" + key
+			want = value
+		case reflect.Bool:
+			value = strconv.FormatBool(!current.Bool())
+			want = !current.Bool()
+		case reflect.Int, reflect.Int64:
+			value = strconv.FormatInt(current.Int()+7, 10)
+			want = current.Int() + 7
+		case reflect.Float64:
+			value = strconv.FormatFloat(current.Float()+0.5, 'f', -1, 64)
+			want = current.Float() + 0.5
+		case reflect.Slice:
+			if field.Type.Elem().Kind() == reflect.Struct {
+				return // lists of structs are only read from config files
+			}
+			value = "first, second"
+			want = []string{"first", "second"}
+		default:
+			t.Fatalf("no test value for %s of kind %s", key, field.Type.Kind())
+		}
+		t.Setenv(EnvName(key), value)
+		expected[key] = want
+		index[key] = fieldIndex
+	})
+	if len(expected) == 0 {
+		t.Fatal("Expected settings to be visited")
+	}
+
+	k, err := loadSources(true)
+	if err != nil {
+		t.Fatalf("failed to load sources: %v", err)
+	}
+	var cfg Config
+	if err := k.Unmarshal("", &cfg); err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+
+	for key, want := range expected {
+		field := reflect.ValueOf(cfg).FieldByIndex(index[key])
+		var got interface{}
+		switch field.Kind() {
+		case reflect.Int, reflect.Int64:
+			got = field.Int()
+		case reflect.Slice:
+			got = field.Interface()
+			if !slices.Equal(got.([]string), want.([]string)) {
+				t.Errorf("%s: expected %v, got %v", EnvName(key), want, got)
+			}
+			continue
+		default:
+			got = field.Interface()
+		}
+		if got != want {
+			t.Errorf("%s: expected %v, got %v", EnvName(key), want, got)
+		}
+	}
+}
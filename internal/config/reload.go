@@ -0,0 +1,204 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/v2"
+)
+
+// ReloadableKeys are the settings applied when a config file changes while
+// the server runs. Changes to any other setting are logged and take effect
+// after a restart. Prompt templates are re-read on every reload as well.
+var ReloadableKeys = []string{
+	"app.log_level",
+	"services.ollama.llm_model",
+	"security.jwt_secret",
+	"security.jwt_keys",
+}
+
+// RedactedValue replaces secrets in Redact's output
+const RedactedValue = "[REDACTED]"
+
+// secretKeys are the keys of settings holding credentials
+var secretKeys = map[string]bool{
+	"key":           true, // database.encryption.key
+	"jwt_secret":    true,
+	"jwt_keys":      true,
+	"client_secret": true,
+	"api_key":       true,
+	"access_key":    true,
+	"secret_key":    true,
+	"secret":        true, // webhook signing keys
+	"token":         true, // secrets.vault.token
+}
+
+// Live holds the effective configuration and applies changes of the
+// reloadable settings while the server runs
+type Live struct {
+	mu       sync.RWMutex
+	k        *koanf.Koanf
+	cfg      *Config
+	loadedAt time.Time
+	onReload []func(*Config)
+}
+
+// LoadLive loads the configuration like Load and keeps its sources for reloads
+func LoadLive() (*Live, error) {
+	k, err := loadSources(false)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parse(k)
+	if err != nil {
+		return nil, err
+	}
+	return &Live{k: k, cfg: cfg, loadedAt: time.Now()}, nil
+}
+
+// Config returns the effective configuration. It must not be modified.
+func (l *Live) Config() *Config {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.cfg
+}
+
+// LoadedAt returns when the effective configuration was last loaded
+func (l *Live) LoadedAt() time.Time {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.loadedAt
+}
+
+// OnReload registers fn to be called with the new configuration after every
+// successful reload
+func (l *Live) OnReload(fn func(*Config)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.onReload = append(l.onReload, fn)
+}
+
+// Reload re-reads the sources and applies the reloadable settings. If the
+// result is invalid, the current configuration stays in effect and the error
+// is returned. The keys of changed settings that need a restart are returned
+// sorted.
+func (l *Live) Reload() ([]string, error) {
+	next, err := loadSources(true)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	merged := l.k.Copy()
+	for _, key := range ReloadableKeys {
+		_ = merged.Set(key, next.Get(key))
+	}
+	cfg, err := parse(merged)
+	if err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+
+	var restart []string
+	for _, key := range slices.Compact(slices.Sorted(slices.Values(append(l.k.Keys(), next.Keys()...)))) {
+		if !slices.Contains(ReloadableKeys, key) && !reflect.DeepEqual(l.k.Get(key), next.Get(key)) {
+			restart = append(restart, key)
+		}
+	}
+	l.k, l.cfg, l.loadedAt = merged, cfg, time.Now()
+	callbacks := slices.Clone(l.onReload)
+	l.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn(cfg)
+	}
+	return restart, nil
+}
+
+// Watch reloads the configuration whenever one of the config files changes
+// until ctx is done
+func (l *Live) Watch(ctx context.Context) {
+	var watched []*file.File
+	for _, path := range configFiles {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		f := file.Provider(path)
+		err := f.Watch(func(_ interface{}, err error) {
+			if err != nil {
+				log.Printf("Warning: stopped watching %s: %v", path, err)
+				return
+			}
+			l.reload(path)
+		})
+		if err != nil {
+			log.Printf("Warning: failed to watch %s: %v", path, err)
+			continue
+		}
+		watched = append(watched, f)
+	}
+
+	<-ctx.Done()
+	for _, f := range watched {
+		_ = f.Unwatch()
+	}
+}
+
+// reload reloads the configuration after path changed and logs the outcome
+func (l *Live) reload(path string) {
+	restart, err := l.Reload()
+	if err != nil {
+		log.Printf("Warning: keeping the current configuration, %s is invalid: %v", path, err)
+		return
+	}
+	log.Printf("Reloaded configuration from %s", path)
+	if len(restart) > 0 {
+		log.Printf("Warning: changes to %s take effect after a restart", strings.Join(restart, ", "))
+	}
+}
+
+// Redact returns cfg keyed like the config files with the values of secret
+// settings replaced by RedactedValue. Only known settings are included, not
+// unrelated environment variables.
+func Redact(cfg *Config) map[string]interface{} {
+	return redactValue(reflect.ValueOf(*cfg)).(map[string]interface{})
+}
+
+// redactValue converts v into maps and slices, redacting secrets of structs
+func redactValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		m := make(map[string]interface{}, v.NumField())
+		for i := range v.NumField() {
+			name := v.Type().Field(i).Tag.Get("koanf")
+			if name == "" {
+				continue
+			}
+			field := v.Field(i)
+			if secretKeys[name] && field.Kind() == reflect.String && field.String() != "" {
+				m[name] = RedactedValue
+				continue
+			}
+			m[name] = redactValue(field)
+		}
+		return m
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return v.Interface()
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = redactValue(v.Index(i))
+		}
+		return items
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+	if err := os.WriteFile("config.yaml", []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+func TestLiveReloadAppliesReloadableSettings(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeConfig(t, "services:\n  ollama:\n    llm_model: llama3.2:1b\nserver:\n  port: 4477\nsecurity:\n  auth_mode: jwt\n  jwt_secret: old\n")
+
+	live, err := LoadLive()
+	if err != nil {
+		t.Fatalf("LoadLive failed: %v", err)
+	}
+	var reloaded *Config
+	live.OnReload(func(cfg *Config) { reloaded = cfg })
+
+	writeConfig(t, "services:\n  ollama:\n    llm_model: llama3.1:8b\nserver:\n  port: 8080\nsecurity:\n  auth_mode: jwt\n  jwt_secret: new\napp:\n  log_level: debug\n")
+	restart, err := live.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	cfg := live.Config()
+	if cfg.Services.Ollama.LLMModel != "llama3.1:8b" || cfg.Security.JWTSecret != "new" || cfg.App.LogLevel != "debug" {
+		t.Errorf("Expected the model, JWT secret, and log level to be reloaded, got %q, %q, and %q", cfg.Services.Ollama.LLMModel, cfg.Security.JWTSecret, cfg.App.LogLevel)
+	}
+	if cfg.Server.Port != 4477 {
+		t.Errorf("Expected the port to keep its value until a restart, got %d", cfg.Server.Port)
+	}
+	if !slices.Equal(restart, []string{"server.port"}) {
+		t.Errorf("Expected server.port to need a restart, got %v", restart)
+	}
+	if reloaded != cfg {
+		t.Error("Expected the reload callback to receive the new configuration")
+	}
+
+	// An invalid or unreadable configuration keeps the current one
+	for _, content := range []string{"services:\n  ollama:\n    llm_model: llama3.2:3b\nsecurity:\n  auth_mode: jwt\n  jwt_secret: ''\n", "app:\n  log_level: verbose\n", "app: [log_level\n"} {
+		writeConfig(t, content)
+		if _, err := live.Reload(); err == nil {
+			t.Errorf("Expected %q to be rejected", content)
+		}
+	}
+	if model := live.Config().Services.Ollama.LLMModel; model != "llama3.1:8b" {
+		t.Errorf("Expected the last valid model to stay in effect, got %q", model)
+	}
+}
+
+func TestLoadRejectsUnknownLogLevel(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeConfig(t, "app:\n  log_level: verbose\n")
+
+	if _, err := LoadLive(); err == nil {
+		t.Error("Expected an unknown log level to fail at startup")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cfg := &Config{}
+	cfg.Security.JWTSecret = "jwt"
+	cfg.Security.JWTKeys = "2026-07=jwt"
+	cfg.Database.Encryption.Key = "db-key"
+	cfg.Webhooks.Endpoints = []WebhookEndpoint{{URL: "https://hooks.example", Secret: "hmac"}}
+	cfg.Services.Ollama.LLMModel = "llama3.2:1b"
+
+	redacted := Redact(cfg)
+	security := redacted["security"].(map[string]interface{})
+	if security["jwt_secret"] != RedactedValue || security["jwt_keys"] != RedactedValue || security["kratos"].(map[string]interface{})["public_url"] != "" {
+		t.Errorf("Expected the JWT keys to be redacted, got %v", security)
+	}
+	database := redacted["database"].(map[string]interface{})
+	if database["encryption"].(map[string]interface{})["key"] != RedactedValue {
+		t.Errorf("Expected the encryption key to be redacted, got %v", database["encryption"])
+	}
+	endpoint := redacted["webhooks"].(map[string]interface{})["endpoints"].([]interface{})[0].(map[string]interface{})
+	if endpoint["secret"] != RedactedValue || endpoint["url"] != "https://hooks.example" {
+		t.Errorf("Expected only the webhook secret to be redacted, got %v", endpoint)
+	}
+	if redacted["services"].(map[string]interface{})["ollama"].(map[string]interface{})["llm_model"] != "llama3.2:1b" {
+		t.Error("Expected other settings to be kept")
+	}
+	if secret := security["oidc"].(map[string]interface{})["client_secret"]; secret != "" {
+		t.Errorf("Expected unset secrets to stay empty, got %v", secret)
+	}
+}
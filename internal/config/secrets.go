@@ -0,0 +1,138 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/secrets"
+	"rerag-rbac-rag-llm/internal/sigv4"
+	"time"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// SecretsConfig holds the secret managers that secret settings may reference
+type SecretsConfig struct {
+	Vault VaultConfig      `koanf:"vault"`
+	AWS   AWSSecretsConfig `koanf:"aws"`
+}
+
+// VaultConfig holds the HashiCorp Vault settings for "vault:<path>#<field>" references
+type VaultConfig struct {
+	Address   string `koanf:"address"`
+	Token     string `koanf:"token"`     // set with token_file, e.g. the sink of a Vault agent
+	Namespace string `koanf:"namespace"` // Vault Enterprise namespace
+	Timeout   int    `koanf:"timeout"`   // seconds
+}
+
+// AWSSecretsConfig holds the AWS Secrets Manager settings for
+// "aws-sm:<secret id>[#<field>]" references. Credentials come from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN variables.
+type AWSSecretsConfig struct {
+	Region   string `koanf:"region"`
+	Endpoint string `koanf:"endpoint"` // overrides the regional endpoint, e.g. for VPC endpoints
+	Timeout  int    `koanf:"timeout"`  // seconds
+}
+
+// secretSettings hold credentials. Each may instead be read from the file
+// named by its "_file" setting, e.g. security.jwt_secret_file or
+// RERAG_SECURITY__JWT_SECRET_FILE, or be set to a secret manager reference. The Vault token comes first since
+// resolving references may need it.
+var secretSettings = []string{
+	"secrets.vault.token",
+	"security.jwt_secret",
+	"security.jwt_keys",
+	"security.share_links.secret",
+	"database.encryption.key",
+	"security.oidc.client_secret",
+	"services.reranker.api_key",
+	"moderation.api_key",
+	"ingestion.s3.secret_key",
+	"ingestion.originals.s3.secret_key",
+	"ingestion.drive.sharepoint.client_secret",
+}
+
+// resolveSecrets replaces the secret settings of k loaded from files or
+// secret managers with their values. In production, secrets set inline in
+// the configuration or environment are refused.
+func resolveSecrets(k *koanf.Koanf) error {
+	production := k.String("app.environment") == "production"
+	var resolver *secrets.Resolver
+	for _, key := range secretSettings {
+		value := k.String(key)
+		path := k.String(key + "_file")
+
+		switch {
+		case path != "":
+			if value != "" {
+				return fmt.Errorf("%s must not be set both inline and from a file", key)
+			}
+			secret, err := secrets.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", key, err)
+			}
+			value = secret
+		case secrets.IsReference(value):
+			if key == "secrets.vault.token" {
+				return fmt.Errorf("%s must be set from a file", key)
+			}
+			if resolver == nil {
+				var err error
+				if resolver, err = newSecretResolver(k); err != nil {
+					return err
+				}
+			}
+			secret, err := resolve(resolver, value)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", key, err)
+			}
+			value = secret
+		case value != "" && production:
+			return fmt.Errorf("%s must be loaded from a file or secret manager in production, not set inline", key)
+		default:
+			continue
+		}
+		_ = k.Set(key, value)
+	}
+	return nil
+}
+
+// newSecretResolver creates a resolver for the secret managers configured in k
+func newSecretResolver(k *koanf.Koanf) (*secrets.Resolver, error) {
+	var cfg SecretsConfig
+	if err := k.Unmarshal("secrets", &cfg); err != nil {
+		return nil, fmt.Errorf("error unmarshaling secrets config: %w", err)
+	}
+
+	resolver := &secrets.Resolver{}
+	if cfg.Vault.Address != "" {
+		if cfg.Vault.Token == "" {
+			return nil, fmt.Errorf("secrets vault token is required with a vault address")
+		}
+		resolver.Vault = secrets.NewVault(cfg.Vault.Address, cfg.Vault.Token, cfg.Vault.Namespace, httpclient.New(httpclient.Options{
+			Timeout: time.Duration(cfg.Vault.Timeout) * time.Second,
+		}))
+	}
+	if cfg.AWS.Region != "" {
+		creds := sigv4.Credentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if creds.AccessKey == "" || creds.SecretKey == "" {
+			return nil, fmt.Errorf("secrets aws requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		resolver.AWS = secrets.NewAWSSecretsManager(cfg.AWS.Region, cfg.AWS.Endpoint, creds, httpclient.New(httpclient.Options{
+			Timeout: time.Duration(cfg.AWS.Timeout) * time.Second,
+		}))
+	}
+	return resolver, nil
+}
+
+// resolve looks up a secret reference, bounding the time secret managers may take
+func resolve(resolver *secrets.Resolver, ref string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return resolver.Resolve(ctx, ref)
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeSecret(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	return path
+}
+
+func TestLoadReadsSecretFiles(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RERAG_SECURITY__AUTH_MODE", "jwt")
+	t.Setenv("RERAG_SECURITY__JWT_SECRET_FILE", writeSecret(t, "jwt", "jwt-from-file\n"))
+	t.Setenv("RERAG_DATABASE__ENCRYPTION__ENABLED", "true")
+	t.Setenv("RERAG_DATABASE__ENCRYPTION__KEY_FILE", writeSecret(t, "db", "db-from-file"))
+	t.Setenv("RERAG_APP__ENVIRONMENT", "production")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Security.JWTSecret != "jwt-from-file" || cfg.Database.Encryption.Key != "db-from-file" {
+		t.Errorf("Expected the secrets from the files, got %q and %q", cfg.Security.JWTSecret, cfg.Database.Encryption.Key)
+	}
+
+	// A secret set both ways is ambiguous
+	t.Setenv("RERAG_SECURITY__JWT_SECRET", "inline")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "security.jwt_secret") {
+		t.Errorf("Expected an inline secret next to a file to be rejected, got %v", err)
+	}
+}
+
+func TestLoadRefusesInlineSecretsInProduction(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("RERAG_SECURITY__AUTH_MODE", "jwt")
+	t.Setenv("RERAG_SECURITY__JWT_SECRET", "inline")
+
+	if _, err := Load(); err != nil {
+		t.Fatalf("Expected inline secrets outside production, got %v", err)
+	}
+	t.Setenv("RERAG_APP__ENVIRONMENT", "production")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "not set inline") {
+		t.Errorf("Expected an inline secret in production to be refused, got %v", err)
+	}
+}
+
+func TestLoadResolvesVaultReferences(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" || r.URL.Path != "/v1/secret/data/rerag" {
+			http.Error(w, `{"errors": ["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		_, _ = io.WriteString(w, `{"data": {"data": {"jwt": "jwt-from-vault"}, "metadata": {}}}`)
+	}))
+	defer vault.Close()
+
+	t.Chdir(t.TempDir())
+	t.Setenv("RERAG_APP__ENVIRONMENT", "production")
+	t.Setenv("RERAG_SECURITY__AUTH_MODE", "jwt")
+	t.Setenv("RERAG_SECURITY__JWT_SECRET", "vault:secret/data/rerag#jwt")
+	t.Setenv("RERAG_SECRETS__VAULT__ADDRESS", vault.URL)
+	t.Setenv("RERAG_SECRETS__VAULT__TOKEN_FILE", writeSecret(t, "token", "vault-token\n"))
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Security.JWTSecret != "jwt-from-vault" {
+		t.Errorf("Expected the secret from Vault, got %q", cfg.Security.JWTSecret)
+	}
+
+	t.Setenv("RERAG_SECURITY__JWT_SECRET", "aws-sm:rerag/jwt")
+	if _, err := Load(); err == nil {
+		t.Error("Expected a reference to an unconfigured secret manager to fail")
+	}
+}
@@ -0,0 +1,388 @@
+// Package gdrive is the Google Drive source of the mirror connector. It lists
+// the files of a folder or shared drive through the Drive API v3 and
+// authenticates as a service account, optionally impersonating a user through
+// domain-wide delegation.
+package gdrive
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"rerag-rbac-rag-llm/internal/connectors/mirror"
+	"rerag-rbac-rag-llm/internal/extract"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEndpoint is the base URL of the Drive API
+const DefaultEndpoint = "https://www.googleapis.com/drive/v3"
+
+// scope grants read access to file content and permissions
+const scope = "https://www.googleapis.com/auth/drive.readonly"
+
+const folderType = "application/vnd.google-apps.folder"
+
+// exportTypes maps Google Docs, Sheets, and Slides to the text format they
+// are exported in; other native types have no content to ingest
+var exportTypes = map[string]string{
+	"application/vnd.google-apps.document":     "text/plain",
+	"application/vnd.google-apps.spreadsheet":  "text/csv",
+	"application/vnd.google-apps.presentation": "text/plain",
+}
+
+// Config holds the settings of a Drive source
+type Config struct {
+	// Endpoint is the Drive API base URL; empty uses DefaultEndpoint
+	Endpoint string
+	// Credentials is the JSON key of the service account
+	Credentials []byte
+	// Subject is the user the service account impersonates; empty acts as the
+	// service account itself
+	Subject string
+	// DriveID selects a shared drive; empty syncs the files the account can see
+	DriveID string
+	// FolderID limits the sync to a folder and its subfolders
+	FolderID string
+}
+
+// serviceAccount is the part of a service account key the client uses
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// Client reads the files and permissions of a Drive
+type Client struct {
+	cfg      Config
+	account  serviceAccount
+	key      *rsa.PrivateKey
+	http     *httpclient.Client
+	now      func() time.Time
+	endpoint string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewClient creates a client from a service account key. httpClient applies
+// timeouts and retries; nil sends single attempts without a timeout.
+func NewClient(cfg Config, httpClient *httpclient.Client) (*Client, error) {
+	var account serviceAccount
+	if err := json.Unmarshal(cfg.Credentials, &account); err != nil {
+		return nil, fmt.Errorf("invalid service account key: %w", err)
+	}
+	if account.ClientEmail == "" || account.TokenURI == "" {
+		return nil, fmt.Errorf("invalid service account key: client_email and token_uri are required")
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid service account key: no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid service account private key: not an RSA key")
+	}
+
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	if httpClient == nil {
+		httpClient = httpclient.New(httpclient.Options{})
+	}
+	return &Client{cfg: cfg, account: account, key: key, http: httpClient, now: time.Now, endpoint: endpoint}, nil
+}
+
+// Name identifies the drive or folder in ingestion cursors
+func (c *Client) Name() string {
+	switch {
+	case c.cfg.DriveID != "" && c.cfg.FolderID != "":
+		return "gdrive://" + c.cfg.DriveID + "/" + c.cfg.FolderID
+	case c.cfg.DriveID != "":
+		return "gdrive://" + c.cfg.DriveID
+	case c.cfg.FolderID != "":
+		return "gdrive://folder/" + c.cfg.FolderID
+	}
+	return "gdrive://" + c.account.ClientEmail
+}
+
+// driveFile is a file of a files.list response
+type driveFile struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	MIMEType     string `json:"mimeType"`
+	MD5Checksum  string `json:"md5Checksum"`
+	ModifiedTime string `json:"modifiedTime"`
+	Size         int64  `json:"size,string"`
+}
+
+// List calls fn for the files of the drive or folder. Folders are descended
+// into; native files other than Docs, Sheets, and Slides are left out.
+func (c *Client) List(ctx context.Context, fn func(mirror.File) error) error {
+	if c.cfg.FolderID == "" {
+		return c.list(ctx, "trashed = false and mimeType != '"+folderType+"'", fn, nil)
+	}
+
+	folders := []string{c.cfg.FolderID}
+	seen := map[string]bool{c.cfg.FolderID: true}
+	for len(folders) > 0 {
+		folder := folders[0]
+		folders = folders[1:]
+		query := fmt.Sprintf("'%s' in parents and trashed = false", strings.ReplaceAll(folder, "'", `\'`))
+		err := c.list(ctx, query, fn, func(id string) {
+			if !seen[id] {
+				seen[id] = true
+				folders = append(folders, id)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// list pages through the files matching query, passing folders to folder
+func (c *Client) list(ctx context.Context, query string, fn func(mirror.File) error, folder func(id string)) error {
+	params := url.Values{
+		"q":                         {query},
+		"fields":                    {"nextPageToken,files(id,name,mimeType,md5Checksum,modifiedTime,size)"},
+		"pageSize":                  {"1000"},
+		"supportsAllDrives":         {"true"},
+		"includeItemsFromAllDrives": {"true"},
+	}
+	if c.cfg.DriveID != "" {
+		params.Set("corpora", "drive")
+		params.Set("driveId", c.cfg.DriveID)
+	}
+
+	for {
+		var page struct {
+			NextPageToken string      `json:"nextPageToken"`
+			Files         []driveFile `json:"files"`
+		}
+		if err := c.getJSON(ctx, "/files", params, &page); err != nil {
+			return fmt.Errorf("failed to list files: %w", err)
+		}
+
+		for _, f := range page.Files {
+			if f.MIMEType == folderType {
+				if folder != nil {
+					folder(f.ID)
+				}
+				continue
+			}
+			if strings.HasPrefix(f.MIMEType, "application/vnd.google-apps.") && exportTypes[f.MIMEType] == "" {
+				continue
+			}
+			// Native files have no checksum, so any modification counts as a change
+			version := f.MD5Checksum
+			if version == "" {
+				version = f.ModifiedTime
+			}
+			if err := fn(mirror.File{ID: f.ID, Name: f.Name, MIMEType: f.MIMEType, Version: version, Size: f.Size}); err != nil {
+				return err
+			}
+		}
+
+		if page.NextPageToken == "" {
+			return nil
+		}
+		params.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// Download returns the content of a file. Docs, Sheets, and Slides are
+// exported as text.
+func (c *Client) Download(ctx context.Context, file mirror.File) ([]byte, string, error) {
+	if exportType := exportTypes[file.MIMEType]; exportType != "" {
+		body, _, err := c.get(ctx, "/files/"+url.PathEscape(file.ID)+"/export", url.Values{"mimeType": {exportType}})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to export file: %w", err)
+		}
+		return body, extract.TypeText, nil
+	}
+
+	body, header, err := c.get(ctx, "/files/"+url.PathEscape(file.ID), url.Values{"alt": {"media"}, "supportsAllDrives": {"true"}})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download file: %w", err)
+	}
+	return body, header.Get("Content-Type"), nil
+}
+
+// Permissions returns the permissions of a file, including those inherited
+// from folders and shared drives
+func (c *Client) Permissions(ctx context.Context, file mirror.File) ([]mirror.Permission, error) {
+	params := url.Values{
+		"fields":            {"nextPageToken,permissions(type,role,emailAddress)"},
+		"pageSize":          {"100"},
+		"supportsAllDrives": {"true"},
+	}
+	var acl []mirror.Permission
+	for {
+		var page struct {
+			NextPageToken string `json:"nextPageToken"`
+			Permissions   []struct {
+				Type         string `json:"type"`
+				Role         string `json:"role"`
+				EmailAddress string `json:"emailAddress"`
+			} `json:"permissions"`
+		}
+		if err := c.getJSON(ctx, "/files/"+url.PathEscape(file.ID)+"/permissions", params, &page); err != nil {
+			return nil, err
+		}
+
+		for _, p := range page.Permissions {
+			permission := mirror.Permission{Email: p.EmailAddress, Role: mirror.RoleRead}
+			switch p.Type {
+			case "user":
+				permission.Principal = mirror.PrincipalUser
+			case "group":
+				permission.Principal = mirror.PrincipalGroup
+			case "domain", "anyone":
+				permission.Principal = mirror.PrincipalEveryone
+			default:
+				continue
+			}
+			switch p.Role {
+			case "owner", "organizer", "fileOrganizer", "writer":
+				permission.Role = mirror.RoleWrite
+			}
+			acl = append(acl, permission)
+		}
+
+		if page.NextPageToken == "" {
+			return acl, nil
+		}
+		params.Set("pageToken", page.NextPageToken)
+	}
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	body, _, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// get sends an authorized GET request for a path relative to the endpoint
+func (c *Client) get(ctx context.Context, path string, query url.Values) ([]byte, http.Header, error) {
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) ([]byte, http.Header, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("google returned status %d: %s", resp.StatusCode, body)
+	}
+	return body, resp.Header, nil
+}
+
+// accessToken returns a cached access token, requesting a new one with a
+// signed JWT assertion shortly before the current one expires
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.token != "" && now.Before(c.expires.Add(-time.Minute)) {
+		return c.token, nil
+	}
+
+	assertion, err := c.assertion(now)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, _, err := c.do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", errors.New("failed to get access token: invalid token response")
+	}
+	c.token = token.AccessToken
+	c.expires = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return c.token, nil
+}
+
+// assertion returns a JWT signed with the service account key that requests
+// read access to Drive
+func (c *Client) assertion(now time.Time) (string, error) {
+	claims := map[string]interface{}{
+		"iss":   c.account.ClientEmail,
+		"scope": scope,
+		"aud":   c.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+	if c.cfg.Subject != "" {
+		claims["sub"] = c.cfg.Subject
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
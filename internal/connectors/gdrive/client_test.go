@@ -0,0 +1,134 @@
+package gdrive
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/connectors/mirror"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeDrive serves the token endpoint and the parts of the Drive API used by the client
+func fakeDrive(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	files := map[string]string{
+		"root":    `{"files": [{"id": "sub", "name": "2024", "mimeType": "application/vnd.google-apps.folder"}, {"id": "doc", "name": "Refunds", "mimeType": "application/vnd.google-apps.document", "modifiedTime": "2024-05-01T10:00:00Z"}], "nextPageToken": "p2"}`,
+		"root/p2": `{"files": [{"id": "form", "name": "Survey", "mimeType": "application/vnd.google-apps.form"}]}`,
+		"sub":     `{"files": [{"id": "pdf", "name": "return.pdf", "mimeType": "application/pdf", "md5Checksum": "abc", "size": "1234"}]}`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			assertion := strings.Split(r.FormValue("assertion"), ".")
+			signature, _ := base64.RawURLEncoding.DecodeString(assertion[2])
+			digest := sha256.Sum256([]byte(assertion[0] + "." + assertion[1]))
+			if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			payload, _ := base64.RawURLEncoding.DecodeString(assertion[1])
+			var claims map[string]interface{}
+			_ = json.Unmarshal(payload, &claims)
+			if claims["sub"] != "admin@example.com" || claims["scope"] != scope {
+				http.Error(w, `{"error": "unauthorized_client"}`, http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "ya29.token", "expires_in": 3600}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer ya29.token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/files":
+			folder := strings.TrimSuffix(strings.TrimPrefix(r.URL.Query().Get("q"), "'"), "' in parents and trashed = false")
+			if token := r.URL.Query().Get("pageToken"); token != "" {
+				folder += "/" + token
+			}
+			_, _ = w.Write([]byte(files[folder]))
+		case "/files/doc/export":
+			_, _ = w.Write([]byte("Refunds are paid within 30 days."))
+		case "/files/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			_, _ = w.Write([]byte("%PDF-1.7"))
+		case "/files/doc/permissions":
+			_, _ = w.Write([]byte(`{"permissions": [
+				{"type": "user", "role": "owner", "emailAddress": "alice@example.com"},
+				{"type": "group", "role": "commenter", "emailAddress": "finance@example.com"},
+				{"type": "domain", "role": "reader"}
+			]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestClientListsFolderRecursively(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := fakeDrive(t, key)
+	defer server.Close()
+
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	credentials, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "rag@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    server.URL + "/token",
+	})
+	client, err := NewClient(Config{Endpoint: server.URL, Credentials: credentials, Subject: "admin@example.com", FolderID: "root"}, nil)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	ctx := context.Background()
+
+	var files []mirror.File
+	if err := client.List(ctx, func(f mirror.File) error {
+		files = append(files, f)
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	expected := []mirror.File{
+		{ID: "doc", Name: "Refunds", MIMEType: "application/vnd.google-apps.document", Version: "2024-05-01T10:00:00Z"},
+		{ID: "pdf", Name: "return.pdf", MIMEType: "application/pdf", Version: "abc", Size: 1234},
+	}
+	if !slices.Equal(files, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, files)
+	}
+
+	data, contentType, err := client.Download(ctx, files[0])
+	if err != nil || string(data) != "Refunds are paid within 30 days." || contentType != "text/plain" {
+		t.Errorf("Expected the document exported as text, got %q %q %v", data, contentType, err)
+	}
+	data, contentType, err = client.Download(ctx, files[1])
+	if err != nil || string(data) != "%PDF-1.7" || contentType != "application/pdf" {
+		t.Errorf("Expected the PDF content, got %q %q %v", data, contentType, err)
+	}
+
+	acl, err := client.Permissions(ctx, files[0])
+	if err != nil {
+		t.Fatalf("Permissions failed: %v", err)
+	}
+	expectedACL := []mirror.Permission{
+		{Principal: mirror.PrincipalUser, Email: "alice@example.com", Role: mirror.RoleWrite},
+		{Principal: mirror.PrincipalGroup, Email: "finance@example.com", Role: mirror.RoleRead},
+		{Principal: mirror.PrincipalEveryone, Role: mirror.RoleRead},
+	}
+	if !slices.Equal(acl, expectedACL) {
+		t.Errorf("Expected %+v, got %+v", expectedACL, acl)
+	}
+}
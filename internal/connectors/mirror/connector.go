@@ -0,0 +1,357 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"rerag-rbac-rag-llm/internal/extract"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Metadata keys attached to ingested documents
+const (
+	MetadataSource   = "drive_source"
+	MetadataFileID   = "drive_file_id"
+	MetadataVersion  = "drive_version"
+	MetadataFilename = "filename"
+	MetadataMIMEType = "mime_type"
+)
+
+// SyncStats summarizes a sync run
+type SyncStats struct {
+	Listed      int // files of the source
+	Added       int // new files ingested
+	Updated     int // changed files whose documents were replaced
+	Permissions int // files with unchanged content whose relations were updated
+	Unchanged   int // files whose version or content, and permissions, match the cursor
+	Skipped     int // files that are too large or have no extractable text
+	Removed     int // files gone from the source whose documents were deleted
+	Failed      int // files that failed transiently and are retried next run
+}
+
+// Connector ingests the files of a source and keeps the relations on their
+// documents in line with the files' permissions
+type Connector struct {
+	source      Source
+	maxFileSize int64
+	pipeline    *ingest.Pipeline
+	store       storage.VectorStore
+	cursors     storage.CursorStore
+	manager     permissions.PermissionManager
+	identities  Identities
+	notifier    webhooks.Notifier // optional
+}
+
+// ConnectorOption configures optional Connector behavior
+type ConnectorOption func(*Connector)
+
+// WithNotifier publishes document.* and permission.* events for the documents
+// and relations the connector changes. Events carry the tenant of the context
+// passed to Sync.
+func WithNotifier(n webhooks.Notifier) ConnectorOption {
+	return func(c *Connector) {
+		c.notifier = n
+	}
+}
+
+// NewConnector creates a connector that ingests the files of source into
+// store through pipeline and mirrors their permissions through manager.
+// store and cursors should be scoped to the target tenant, and the context
+// passed to Sync should carry it.
+func NewConnector(source Source, maxFileSize int64, pipeline *ingest.Pipeline, store storage.VectorStore, cursors storage.CursorStore, manager permissions.PermissionManager, identities Identities, opts ...ConnectorOption) *Connector {
+	c := &Connector{
+		source:      source,
+		maxFileSize: maxFileSize,
+		pipeline:    pipeline,
+		store:       store,
+		cursors:     cursors,
+		manager:     manager,
+		identities:  identities,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+var (
+	errUnchanged   = errors.New("file unchanged")
+	errUpdated     = errors.New("file updated")
+	errPermissions = errors.New("file permissions changed")
+	errSkipped     = errors.New("file skipped")
+)
+
+// Sync ingests new and changed files, updates the relations of files whose
+// permissions changed, and deletes the documents of files that are gone.
+// Files are only considered gone after a complete listing.
+func (c *Connector) Sync(ctx context.Context) (SyncStats, error) {
+	var stats SyncStats
+	listed := make(map[string]bool)
+	err := c.source.List(ctx, func(file File) error {
+		stats.Listed++
+		listed[file.ID] = true
+
+		switch err := c.syncFile(ctx, file); {
+		case errors.Is(err, errUnchanged):
+			stats.Unchanged++
+		case errors.Is(err, errUpdated):
+			stats.Updated++
+		case errors.Is(err, errPermissions):
+			stats.Permissions++
+		case errors.Is(err, errSkipped):
+			stats.Skipped++
+		case err != nil:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("Drive connector: failed to sync %s/%s (%s): %v", c.source.Name(), file.ID, file.Name, err)
+			stats.Failed++
+		default:
+			stats.Added++
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	cursors, err := c.cursors.ListCursors(c.source.Name())
+	if err != nil {
+		return stats, err
+	}
+	for _, cursor := range cursors {
+		if listed[cursor.Key] {
+			continue
+		}
+		if err := c.remove(ctx, cursor); err != nil {
+			log.Printf("Drive connector: failed to remove %s/%s: %v", c.source.Name(), cursor.Key, err)
+			stats.Failed++
+			continue
+		}
+		stats.Removed++
+	}
+	return stats, nil
+}
+
+// syncFile ingests a file if its content changed since the last run and
+// otherwise brings the relations on its documents up to date
+func (c *Connector) syncFile(ctx context.Context, file File) error {
+	previous, err := c.cursors.GetCursor(c.source.Name(), file.ID)
+	if err != nil && !errors.Is(err, storage.ErrCursorNotFound) {
+		return err
+	}
+
+	acl, err := c.source.Permissions(ctx, file)
+	if err != nil {
+		return fmt.Errorf("failed to get permissions: %w", err)
+	}
+	relations, unmapped := c.identities.Relations(acl)
+	if len(unmapped) > 0 {
+		log.Printf("Drive connector: %s/%s (%s): no user or group for %s", c.source.Name(), file.ID, file.Name, strings.Join(unmapped, ", "))
+	}
+
+	if previous != nil && previous.Version == file.Version {
+		return c.updateRelations(ctx, previous, relations)
+	}
+
+	cursor := &storage.Cursor{Source: c.source.Name(), Key: file.ID, Version: file.Version, Relations: relations}
+	if c.maxFileSize > 0 && file.Size > c.maxFileSize {
+		log.Printf("Drive connector: skipping %s/%s (%s): %d bytes exceeds the limit of %d", c.source.Name(), file.ID, file.Name, file.Size, c.maxFileSize)
+		return c.replace(ctx, previous, cursor, errSkipped)
+	}
+
+	data, contentType, err := c.source.Download(ctx, file)
+	if err != nil {
+		return err
+	}
+	cursor.ContentHash = contentHash(data)
+	if previous != nil && previous.ContentHash == cursor.ContentHash {
+		// A new version with identical content, e.g. a Google Doc whose
+		// comments changed
+		previous.Version = file.Version
+		return c.updateRelations(ctx, previous, relations)
+	}
+	extracted, err := extract.Extract(file.Name, contentType, data)
+	if err != nil {
+		log.Printf("Drive connector: skipping %s/%s (%s): %v", c.source.Name(), file.ID, file.Name, err)
+		return c.replace(ctx, previous, cursor, errSkipped)
+	}
+
+	title := extracted.Title
+	if title == "" {
+		title = strings.TrimSuffix(file.Name, path.Ext(file.Name))
+	}
+	docs, err := c.pipeline.Ingest(ctx, c.store, ingest.Source{
+		Title: title,
+		Text:  extracted.Text,
+		Metadata: map[string]interface{}{
+			MetadataSource:   c.source.Name(),
+			MetadataFileID:   file.ID,
+			MetadataVersion:  file.Version,
+			MetadataFilename: file.Name,
+			MetadataMIMEType: extracted.ContentType,
+		},
+	})
+	for i := range docs {
+		cursor.DocumentIDs = append(cursor.DocumentIDs, docs[i].ID)
+	}
+	if err == nil {
+		err = c.grant(ctx, cursor.DocumentIDs, relations)
+	}
+	if err != nil {
+		// Remove partially stored chunks and relations so the retry starts clean
+		c.revoke(ctx, cursor.DocumentIDs, relations)
+		c.deleteDocumentIDs(cursor.DocumentIDs)
+		return err
+	}
+
+	for i := range docs {
+		c.notify(ctx, webhooks.DocumentCreated, webhooks.DocumentData{ID: docs[i].ID, Title: docs[i].Title, Metadata: docs[i].Metadata})
+	}
+	if previous != nil {
+		return c.replace(ctx, previous, cursor, errUpdated)
+	}
+	return c.replace(ctx, previous, cursor, nil)
+}
+
+// contentHash returns the hex-encoded SHA-256 digest of a file's content
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// updateRelations grants and revokes relations on the documents of cursor so
+// they match relations, and records the cursor. It returns errUnchanged if
+// the relations already match and errPermissions once they were updated.
+func (c *Connector) updateRelations(ctx context.Context, cursor *storage.Cursor, relations []string) error {
+	if slices.Equal(cursor.Relations, relations) {
+		if err := c.cursors.PutCursor(cursor); err != nil {
+			return fmt.Errorf("failed to record ingestion cursor: %w", err)
+		}
+		return errUnchanged
+	}
+
+	var added, removed []string
+	for _, r := range relations {
+		if !slices.Contains(cursor.Relations, r) {
+			added = append(added, r)
+		}
+	}
+	for _, r := range cursor.Relations {
+		if !slices.Contains(relations, r) {
+			removed = append(removed, r)
+		}
+	}
+
+	if err := c.grant(ctx, cursor.DocumentIDs, added); err != nil {
+		return err
+	}
+	if failed := c.revoke(ctx, cursor.DocumentIDs, removed); failed > 0 {
+		return fmt.Errorf("failed to revoke %d relations", failed)
+	}
+	cursor.Relations = relations
+	if err := c.cursors.PutCursor(cursor); err != nil {
+		return fmt.Errorf("failed to record ingestion cursor: %w", err)
+	}
+	return errPermissions
+}
+
+// replace records cursor and then removes the documents and relations of
+// the previous version, returning result on success
+func (c *Connector) replace(ctx context.Context, previous, cursor *storage.Cursor, result error) error {
+	if err := c.cursors.PutCursor(cursor); err != nil {
+		return fmt.Errorf("failed to record ingestion cursor: %w", err)
+	}
+	if previous != nil {
+		c.revoke(ctx, previous.DocumentIDs, previous.Relations)
+		for _, id := range c.deleteDocumentIDs(previous.DocumentIDs) {
+			c.notify(ctx, webhooks.DocumentDeleted, webhooks.DocumentData{ID: id})
+		}
+	}
+	return result
+}
+
+// remove deletes the documents and relations of a file that is gone and
+// then its cursor
+func (c *Connector) remove(ctx context.Context, cursor *storage.Cursor) error {
+	if failed := c.revoke(ctx, cursor.DocumentIDs, cursor.Relations); failed > 0 {
+		return fmt.Errorf("failed to revoke %d relations", failed)
+	}
+	for _, id := range c.deleteDocumentIDs(cursor.DocumentIDs) {
+		c.notify(ctx, webhooks.DocumentDeleted, webhooks.DocumentData{ID: id})
+	}
+	return c.cursors.DeleteCursor(cursor.Source, cursor.Key)
+}
+
+// grant adds relations on every document, stopping at the first failure
+func (c *Connector) grant(ctx context.Context, docIDs []uuid.UUID, relations []string) error {
+	for _, id := range docIDs {
+		for _, r := range relations {
+			t, err := relationTuple(r, id)
+			if err != nil {
+				return err
+			}
+			if err := c.manager.Grant(ctx, t); err != nil {
+				return fmt.Errorf("failed to grant %s to %s: %w", t.Relation, t.Holder(), err)
+			}
+			c.notify(ctx, webhooks.PermissionGranted, permissionData(t))
+		}
+	}
+	return nil
+}
+
+// revoke removes relations from every document, logging failures, and
+// returns the number of relations that could not be revoked
+func (c *Connector) revoke(ctx context.Context, docIDs []uuid.UUID, relations []string) int {
+	failed := 0
+	for _, id := range docIDs {
+		for _, r := range relations {
+			t, err := relationTuple(r, id)
+			if err == nil {
+				err = c.manager.Revoke(ctx, t)
+			}
+			if err != nil {
+				log.Printf("Drive connector: failed to revoke %s on document %s: %v", r, id, err)
+				failed++
+				continue
+			}
+			c.notify(ctx, webhooks.PermissionRevoked, permissionData(t))
+		}
+	}
+	return failed
+}
+
+func permissionData(t permissions.Tuple) webhooks.PermissionData {
+	return webhooks.PermissionData{Subject: t.Subject, Group: t.Group, Relation: t.Relation, Object: t.Object()}
+}
+
+func (c *Connector) notify(ctx context.Context, eventType webhooks.EventType, data interface{}) {
+	if c.notifier != nil {
+		c.notifier.Notify(ctx, eventType, data)
+	}
+}
+
+// deleteDocumentIDs deletes documents and returns the IDs that were deleted
+func (c *Connector) deleteDocumentIDs(ids []uuid.UUID) []uuid.UUID {
+	deleted := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		switch err := c.store.DeleteDocument(id); {
+		case err == nil:
+			deleted = append(deleted, id)
+		case !errors.Is(err, storage.ErrDocumentNotFound):
+			log.Printf("Drive connector: failed to delete document %s: %v", id, err)
+		}
+	}
+	return deleted
+}
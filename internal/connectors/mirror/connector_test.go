@@ -0,0 +1,213 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type fakeFile struct {
+	File
+	content string
+	acl     []Permission
+}
+
+// fakeSource serves files from memory
+type fakeSource struct {
+	files     map[string]*fakeFile
+	downloads int
+}
+
+func (s *fakeSource) Name() string { return "fake://drive" }
+
+func (s *fakeSource) List(_ context.Context, fn func(File) error) error {
+	ids := make([]string, 0, len(s.files))
+	for id := range s.files {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := fn(s.files[id].File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeSource) Download(_ context.Context, file File) ([]byte, string, error) {
+	s.downloads++
+	return []byte(s.files[file.ID].content), "text/plain", nil
+}
+
+func (s *fakeSource) Permissions(_ context.Context, file File) ([]Permission, error) {
+	return s.files[file.ID].acl, nil
+}
+
+// fakeManager records granted relations
+type fakeManager struct {
+	tuples map[permissions.Tuple]bool
+}
+
+func (m *fakeManager) Grant(_ context.Context, t permissions.Tuple) error {
+	m.tuples[t] = true
+	return nil
+}
+
+func (m *fakeManager) Revoke(_ context.Context, t permissions.Tuple) error {
+	delete(m.tuples, t)
+	return nil
+}
+
+func (m *fakeManager) ListTuples(context.Context, string) ([]permissions.Tuple, error) {
+	return nil, errors.New("not implemented")
+}
+
+// holders lists the "<relation>:<holder>" relations on a file's documents
+func (m *fakeManager) holders(store storage.VectorStore, fileID string) []string {
+	var relations []string
+	for _, doc := range store.GetAllDocuments() {
+		if doc.Metadata[MetadataFileID] != fileID {
+			continue
+		}
+		for t := range m.tuples {
+			if t.DocumentID == doc.ID {
+				relations = append(relations, t.Relation+":"+t.Holder())
+			}
+		}
+	}
+	sort.Strings(relations)
+	return relations
+}
+
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) GetEmbedding(_ context.Context, text string) ([]float32, error) {
+	return []float32{float32(len(text)), 1, 0}, nil
+}
+
+func TestConnectorMirrorsContentAndPermissions(t *testing.T) {
+	source := &fakeSource{files: map[string]*fakeFile{
+		"f1": {
+			File:    File{ID: "f1", Name: "refunds.txt", Version: "v1"},
+			content: "John received a refund of $1,200.",
+			acl: []Permission{
+				{Principal: PrincipalUser, Email: "Alice@example.com", Role: RoleWrite},
+				{Principal: PrincipalGroup, Email: "finance@example.com", Role: RoleRead},
+				{Principal: PrincipalUser, Email: "mallory@elsewhere.com", Role: RoleRead},
+			},
+		},
+		"f2": {
+			File:    File{ID: "f2", Name: "handbook.txt", Version: "v1"},
+			content: "Expenses are reimbursed monthly.",
+			acl:     []Permission{{Principal: PrincipalEveryone, Role: RoleRead}},
+		},
+	}}
+
+	db, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "mirror.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	cursors, err := storage.NewSQLiteCursorStore(db)
+	if err != nil {
+		t.Fatalf("Failed to create cursor store: %v", err)
+	}
+	store := db.ForTenant("acme")
+	manager := &fakeManager{tuples: map[permissions.Tuple]bool{}}
+	identities := Identities{
+		Users:         map[string]string{"bob@example.com": "bob"},
+		Domain:        "example.com",
+		EveryoneGroup: "staff",
+	}
+	connector := NewConnector(source, 1<<20, ingest.NewPipeline(fakeEmbedder{}, 2000, 200), store, cursors.ForTenant("acme"), manager, identities)
+
+	sync := func(expected SyncStats) {
+		t.Helper()
+		stats, err := connector.Sync(context.Background())
+		if err != nil {
+			t.Fatalf("Sync failed: %v", err)
+		}
+		if stats != expected {
+			t.Fatalf("Expected %+v, got %+v", expected, stats)
+		}
+	}
+	expectRelations := func(fileID string, expected ...string) {
+		t.Helper()
+		if got := manager.holders(store, fileID); !slices.Equal(got, expected) {
+			t.Errorf("Expected relations %v on %s, got %v", expected, fileID, got)
+		}
+	}
+
+	sync(SyncStats{Listed: 2, Added: 2})
+	expectRelations("f1", "editor:alice", "viewer:alice", "viewer:group:finance")
+	expectRelations("f2", "viewer:group:staff")
+
+	// Nothing is downloaded or granted again while the files are unchanged
+	downloads := source.downloads
+	sync(SyncStats{Listed: 2, Unchanged: 2})
+	if source.downloads != downloads {
+		t.Errorf("Expected unchanged files not to be downloaded again")
+	}
+
+	// Permission changes at the source are mirrored without re-ingesting
+	source.files["f1"].acl = []Permission{
+		{Principal: PrincipalUser, Email: "alice@example.com", Role: RoleRead},
+		{Principal: PrincipalUser, Email: "bob@example.com", Role: RoleRead},
+	}
+	sync(SyncStats{Listed: 2, Permissions: 1, Unchanged: 1})
+	expectRelations("f1", "viewer:alice", "viewer:bob")
+	if source.downloads != downloads {
+		t.Errorf("Expected a permission change not to download the file")
+	}
+
+	// Changed content replaces the documents, which carry the current relations
+	source.files["f1"].Version = "v2"
+	source.files["f1"].content = "John received a refund of $1,500."
+	sync(SyncStats{Listed: 2, Updated: 1, Unchanged: 1})
+	expectRelations("f1", "viewer:alice", "viewer:bob")
+	for _, doc := range store.GetAllDocuments() {
+		if doc.Metadata[MetadataFileID] == "f1" && !strings.Contains(doc.Content, "$1,500") {
+			t.Errorf("Expected the changed file's documents to be replaced, got %q", doc.Content)
+		}
+	}
+
+	// A new version with identical content is not re-ingested
+	source.files["f1"].Version = "v3"
+	sync(SyncStats{Listed: 2, Unchanged: 2})
+
+	// Deleted files lose their documents and relations
+	delete(source.files, "f2")
+	sync(SyncStats{Listed: 1, Unchanged: 1, Removed: 1})
+	if docs := store.GetAllDocuments(); len(docs) != 1 {
+		t.Errorf("Expected only the remaining file's document, got %d documents", len(docs))
+	}
+	if len(manager.tuples) != 2 {
+		t.Errorf("Expected relations of replaced and removed documents to be revoked, got %v", manager.tuples)
+	}
+}
+
+func TestIdentitiesRelations(t *testing.T) {
+	acl := []Permission{
+		{Principal: PrincipalUser, Email: "carol@example.com", Role: RoleRead},
+		{Principal: PrincipalGroup, Email: "board@example.com", Role: RoleWrite},
+		{Principal: PrincipalEveryone, Role: RoleRead},
+	}
+
+	relations, unmapped := Identities{}.Relations(acl)
+	if len(relations) != 0 || len(unmapped) != 3 {
+		t.Errorf("Expected nothing to be mapped without identities, got %v (unmapped: %v)", relations, unmapped)
+	}
+
+	relations, _ = Identities{EmailUsernames: true, Groups: map[string]string{"board@example.com": "directors"}}.Relations(acl)
+	expected := []string{"editor:group:directors", "viewer:carol@example.com", "viewer:group:directors"}
+	if !slices.Equal(relations, expected) {
+		t.Errorf("Expected %v, got %v", expected, relations)
+	}
+}
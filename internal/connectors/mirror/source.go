@@ -0,0 +1,170 @@
+// Package mirror syncs documents from file sharing services such as Google
+// Drive or SharePoint and mirrors who may read or edit each file into
+// relation tuples, so answers only draw on files a user can open at the
+// source. Services plug in as a Source.
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// File is a file of a source
+type File struct {
+	ID       string // stable identifier within the source
+	Name     string
+	MIMEType string
+	// Version changes whenever the content changes, e.g. an MD5 checksum or cTag
+	Version string
+	Size    int64
+}
+
+// Principal kinds of a Permission
+const (
+	PrincipalUser  = "user"
+	PrincipalGroup = "group"
+	// PrincipalEveryone stands for sharing with anyone in the organization or with a link
+	PrincipalEveryone = "everyone"
+)
+
+// Roles of a Permission
+const (
+	RoleRead  = "read"
+	RoleWrite = "write"
+)
+
+// Permission is an entry of a file's native access control list, normalized
+// by the source
+type Permission struct {
+	Principal string // PrincipalUser, PrincipalGroup, or PrincipalEveryone
+	Email     string // address of the user or group, or the name of a SharePoint site group
+	Role      string // RoleRead or RoleWrite
+}
+
+// Source lists files, their content, and their permissions
+type Source interface {
+	// Name identifies the source in ingestion cursors, e.g. "gdrive://<drive>"
+	Name() string
+	// List calls fn for every file to sync. An error returned by fn stops the listing.
+	List(ctx context.Context, fn func(File) error) error
+	// Download returns the content of a file and its content type. Native
+	// documents such as Google Docs are exported as text.
+	Download(ctx context.Context, file File) ([]byte, string, error)
+	// Permissions returns the access control list of a file
+	Permissions(ctx context.Context, file File) ([]Permission, error)
+}
+
+// Identities maps the principals of a source to users and groups. Unmapped
+// principals get no relations.
+type Identities struct {
+	// Users maps email addresses to usernames
+	Users map[string]string
+	// Groups maps group email addresses to group names
+	Groups map[string]string
+	// Domain maps users and groups of this email domain that are not listed
+	// to the local part of their address, e.g. alice@example.com to alice
+	Domain string
+	// EmailUsernames identifies the remaining users by their email address,
+	// for deployments whose users log in with it
+	EmailUsernames bool
+	// EveryoneGroup receives the relations of files shared with everyone;
+	// empty ignores such sharing
+	EveryoneGroup string
+}
+
+// user returns the username of an email address
+func (id Identities) user(email string) (string, bool) {
+	email = strings.ToLower(email)
+	if user, ok := id.Users[email]; ok {
+		return user, true
+	}
+	if user, ok := id.localPart(email); ok {
+		return user, true
+	}
+	return email, id.EmailUsernames && email != ""
+}
+
+// group returns the group name of a group email address
+func (id Identities) group(email string) (string, bool) {
+	email = strings.ToLower(email)
+	if group, ok := id.Groups[email]; ok {
+		return group, true
+	}
+	group, ok := id.localPart(email)
+	if !ok || permissions.ValidateGroupName(group) != nil {
+		return "", false
+	}
+	return group, true
+}
+
+// localPart returns the local part of an address in the configured domain
+func (id Identities) localPart(email string) (string, bool) {
+	if id.Domain == "" {
+		return "", false
+	}
+	local, ok := strings.CutSuffix(email, "@"+strings.ToLower(id.Domain))
+	return local, ok && local != ""
+}
+
+// Relations maps an access control list to relations on a file's documents,
+// as "<relation>:<holder>" in the format of storage.Cursor. Writers get the
+// viewer and the editor relation. The result is sorted and has no duplicates.
+// Principals that cannot be mapped are returned separately for logging.
+func (id Identities) Relations(acl []Permission) (relations []string, unmapped []string) {
+	seen := make(map[string]bool)
+	for _, p := range acl {
+		var holder string
+		switch p.Principal {
+		case PrincipalUser:
+			if user, ok := id.user(p.Email); ok {
+				holder = user
+			}
+		case PrincipalGroup:
+			if group, ok := id.group(p.Email); ok {
+				holder = "group:" + group
+			}
+		case PrincipalEveryone:
+			if id.EveryoneGroup != "" {
+				holder = "group:" + id.EveryoneGroup
+			}
+		}
+		if holder == "" {
+			unmapped = append(unmapped, fmt.Sprintf("%s %s", p.Principal, p.Email))
+			continue
+		}
+
+		granted := []string{permissions.RelationViewer}
+		if p.Role == RoleWrite {
+			granted = append(granted, permissions.RelationEditor)
+		}
+		for _, relation := range granted {
+			r := relation + ":" + holder
+			if !seen[r] {
+				seen[r] = true
+				relations = append(relations, r)
+			}
+		}
+	}
+	sort.Strings(relations)
+	return relations, unmapped
+}
+
+// relationTuple converts a relation in cursor format into a tuple on a document
+func relationTuple(relation string, docID uuid.UUID) (permissions.Tuple, error) {
+	name, holder, ok := strings.Cut(relation, ":")
+	if !ok {
+		return permissions.Tuple{}, fmt.Errorf("invalid mirrored relation %q", relation)
+	}
+	t := permissions.Tuple{Relation: name, DocumentID: docID}
+	if group, isGroup := strings.CutPrefix(holder, "group:"); isGroup {
+		t.Group = group
+	} else {
+		t.Subject = holder
+	}
+	return t, t.Validate()
+}
@@ -0,0 +1,169 @@
+// Package s3 ingests objects from S3-compatible object storage such as AWS S3
+// or MinIO. It speaks the REST API directly with Signature Version 4 signing.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/sigv4"
+	"strings"
+	"time"
+)
+
+// ClientConfig holds the connection settings of a bucket
+type ClientConfig struct {
+	// Endpoint is the service URL, e.g. https://s3.eu-central-1.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as a path (required by MinIO) instead of a subdomain
+	PathStyle bool
+}
+
+// Object is an entry of a bucket listing
+type Object struct {
+	Key          string    `xml:"Key"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// ErrNotFound is returned for objects that do not exist
+var ErrNotFound = errors.New("object not found")
+
+// Client reads and writes the objects of a single bucket
+type Client struct {
+	cfg    ClientConfig
+	http   *httpclient.Client
+	now    func() time.Time
+	bucket *url.URL // base URL addressing the bucket
+}
+
+// NewClient creates a client for the configured bucket. httpClient applies
+// timeouts and retries; nil sends single attempts without a timeout.
+func NewClient(cfg ClientConfig, httpClient *httpclient.Client) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.PathStyle {
+		base.Path += "/" + cfg.Bucket
+	} else {
+		base.Host = cfg.Bucket + "." + base.Host
+	}
+	if httpClient == nil {
+		httpClient = httpclient.New(httpclient.Options{})
+	}
+	return &Client{cfg: cfg, http: httpClient, now: time.Now, bucket: base}, nil
+}
+
+// ListObjects returns one page of the objects under prefix and the token of
+// the next page, which is empty after the last page
+func (c *Client) ListObjects(ctx context.Context, prefix, continuationToken string) ([]Object, string, error) {
+	query := url.Values{"list-type": {"2"}}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if continuationToken != "" {
+		query.Set("continuation-token", continuationToken)
+	}
+
+	body, _, err := c.get(ctx, "/", query)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	var result struct {
+		Contents              []Object `xml:"Contents"`
+		IsTruncated           bool     `xml:"IsTruncated"`
+		NextContinuationToken string   `xml:"NextContinuationToken"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, "", fmt.Errorf("failed to decode object listing: %w", err)
+	}
+	for i := range result.Contents {
+		result.Contents[i].ETag = strings.Trim(result.Contents[i].ETag, `"`)
+	}
+
+	if !result.IsTruncated {
+		return result.Contents, "", nil
+	}
+	return result.Contents, result.NextContinuationToken, nil
+}
+
+// GetObject returns the content and content type of an object
+func (c *Client) GetObject(ctx context.Context, key string) ([]byte, string, error) {
+	body, header, err := c.get(ctx, "/"+key, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	return body, header.Get("Content-Type"), nil
+}
+
+// PutObject stores data as the object key, replacing an existing one
+func (c *Client) PutObject(ctx context.Context, key string, data []byte, contentType string) error {
+	if _, _, err := c.send(ctx, http.MethodPut, "/"+key, nil, data, contentType); err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+	return nil
+}
+
+// get sends a signed GET request for a path relative to the bucket
+func (c *Client) get(ctx context.Context, path string, query url.Values) ([]byte, http.Header, error) {
+	return c.send(ctx, http.MethodGet, path, query, nil, "")
+}
+
+// send sends a signed request for a path relative to the bucket. A missing
+// object or bucket is reported as ErrNotFound.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, payload []byte, contentType string) ([]byte, http.Header, error) {
+	target := *c.bucket
+	target.Path += path
+	// Send the path exactly as it is signed
+	target.RawPath = sigv4.URIEncode(target.Path, false)
+	target.RawQuery = sigv4.CanonicalQuery(query)
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	c.sign(req, payload, c.now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNotFound, respBody)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("s3 returned status %d: %s", resp.StatusCode, respBody)
+	}
+	return respBody, resp.Header, nil
+}
+
+// sign adds AWS Signature Version 4 headers for payload to a request
+func (c *Client) sign(req *http.Request, payload []byte, now time.Time) {
+	sigv4.Sign(req, payload, sigv4.Credentials{AccessKey: c.cfg.AccessKey, SecretKey: c.cfg.SecretKey}, c.cfg.Region, "s3", now)
+}
@@ -0,0 +1,275 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"rerag-rbac-rag-llm/internal/extract"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Metadata keys attached to ingested documents; permission mappings can match
+// on the bucket and key
+const (
+	MetadataBucket   = "s3_bucket"
+	MetadataKey      = "s3_key"
+	MetadataETag     = "s3_etag"
+	MetadataFilename = "filename"
+	MetadataMIMEType = "mime_type"
+)
+
+// SyncStats summarizes a sync run
+type SyncStats struct {
+	Listed    int // objects under the prefix
+	Added     int // new objects ingested
+	Updated   int // changed objects whose documents were replaced
+	Unchanged int // objects skipped because their ETag or content matches the cursor
+	Skipped   int // objects that are too large or have no extractable text
+	Removed   int // objects gone from the bucket whose documents were deleted
+	Failed    int // objects that failed transiently and are retried next run
+}
+
+// Connector ingests the objects under a bucket prefix
+type Connector struct {
+	client        *Client
+	prefix        string
+	maxObjectSize int64
+	pipeline      *ingest.Pipeline
+	store         storage.VectorStore
+	cursors       storage.CursorStore
+	notifier      webhooks.Notifier // optional
+}
+
+// ConnectorOption configures optional Connector behavior
+type ConnectorOption func(*Connector)
+
+// WithNotifier publishes document.created and document.deleted events for
+// ingested and replaced documents. Events carry the tenant of the context
+// passed to Sync.
+func WithNotifier(n webhooks.Notifier) ConnectorOption {
+	return func(c *Connector) {
+		c.notifier = n
+	}
+}
+
+// NewConnector creates a connector that ingests objects under prefix into store
+// through pipeline, tracking ingested versions in cursors. store and cursors
+// should be scoped to the target tenant.
+func NewConnector(client *Client, prefix string, maxObjectSize int64, pipeline *ingest.Pipeline, store storage.VectorStore, cursors storage.CursorStore, opts ...ConnectorOption) *Connector {
+	c := &Connector{
+		client:        client,
+		prefix:        prefix,
+		maxObjectSize: maxObjectSize,
+		pipeline:      pipeline,
+		store:         store,
+		cursors:       cursors,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// source identifies the bucket in ingestion cursors
+func (c *Connector) source() string {
+	return "s3://" + c.client.cfg.Bucket
+}
+
+// Sync ingests all objects under the prefix whose ETag differs from the last
+// ingested version and whose content changed. Documents of a changed object
+// are replaced. Objects that cannot be extracted are recorded so they are
+// only retried once they change. After a complete listing, the documents of
+// objects that are gone are deleted.
+func (c *Connector) Sync(ctx context.Context) (SyncStats, error) {
+	var stats SyncStats
+	listed := make(map[string]bool)
+	token := ""
+	for {
+		objects, next, err := c.client.ListObjects(ctx, c.prefix, token)
+		if err != nil {
+			return stats, err
+		}
+
+		for _, obj := range objects {
+			if strings.HasSuffix(obj.Key, "/") {
+				continue // folder placeholder
+			}
+			stats.Listed++
+			listed[obj.Key] = true
+
+			switch err := c.syncObject(ctx, obj); {
+			case errors.Is(err, errUnchanged):
+				stats.Unchanged++
+			case errors.Is(err, errUpdated):
+				stats.Updated++
+			case errors.Is(err, errSkipped):
+				stats.Skipped++
+			case err != nil:
+				if ctx.Err() != nil {
+					return stats, ctx.Err()
+				}
+				log.Printf("S3 connector: failed to ingest %s/%s: %v", c.source(), obj.Key, err)
+				stats.Failed++
+			default:
+				stats.Added++
+			}
+		}
+
+		if next == "" {
+			break
+		}
+		token = next
+	}
+
+	cursors, err := c.cursors.ListCursors(c.source())
+	if err != nil {
+		return stats, err
+	}
+	for _, cursor := range cursors {
+		if listed[cursor.Key] || !strings.HasPrefix(cursor.Key, c.prefix) {
+			continue
+		}
+		for _, id := range c.deleteDocumentIDs(cursor.DocumentIDs) {
+			c.notify(ctx, webhooks.DocumentDeleted, webhooks.DocumentData{ID: id})
+		}
+		if err := c.cursors.DeleteCursor(cursor.Source, cursor.Key); err != nil {
+			log.Printf("S3 connector: failed to remove %s/%s: %v", c.source(), cursor.Key, err)
+			stats.Failed++
+			continue
+		}
+		stats.Removed++
+	}
+	return stats, nil
+}
+
+var (
+	errUnchanged = errors.New("object unchanged")
+	errUpdated   = errors.New("object updated")
+	errSkipped   = errors.New("object skipped")
+)
+
+// syncObject ingests a single object if it changed since the last run
+func (c *Connector) syncObject(ctx context.Context, obj Object) error {
+	previous, err := c.cursors.GetCursor(c.source(), obj.Key)
+	if err != nil && !errors.Is(err, storage.ErrCursorNotFound) {
+		return err
+	}
+	if previous != nil && previous.Version == obj.ETag {
+		return errUnchanged
+	}
+
+	cursor := &storage.Cursor{Source: c.source(), Key: obj.Key, Version: obj.ETag}
+	if c.maxObjectSize > 0 && obj.Size > c.maxObjectSize {
+		log.Printf("S3 connector: skipping %s/%s: %d bytes exceeds the limit of %d", c.source(), obj.Key, obj.Size, c.maxObjectSize)
+		return c.replace(ctx, previous, cursor, errSkipped)
+	}
+
+	data, contentType, err := c.client.GetObject(ctx, obj.Key)
+	if err != nil {
+		return err
+	}
+	cursor.ContentHash = contentHash(data)
+	if previous != nil && previous.ContentHash == cursor.ContentHash {
+		// Rewritten with identical content, e.g. by a backup job
+		previous.Version = obj.ETag
+		if err := c.cursors.PutCursor(previous); err != nil {
+			return fmt.Errorf("failed to record ingestion cursor: %w", err)
+		}
+		return errUnchanged
+	}
+
+	filename := path.Base(obj.Key)
+	extracted, err := extract.Extract(filename, contentType, data)
+	if err != nil {
+		log.Printf("S3 connector: skipping %s/%s: %v", c.source(), obj.Key, err)
+		return c.replace(ctx, previous, cursor, errSkipped)
+	}
+
+	title := extracted.Title
+	if title == "" {
+		title = strings.TrimSuffix(filename, path.Ext(filename))
+	}
+	docs, err := c.pipeline.Ingest(ctx, c.store, ingest.Source{
+		Title: title,
+		Text:  extracted.Text,
+		Metadata: map[string]interface{}{
+			MetadataBucket:   c.client.cfg.Bucket,
+			MetadataKey:      obj.Key,
+			MetadataETag:     obj.ETag,
+			MetadataFilename: filename,
+			MetadataMIMEType: extracted.ContentType,
+		},
+	})
+	if err != nil {
+		// Remove partially stored chunks so the retry starts clean
+		c.deleteDocuments(docs...)
+		return err
+	}
+
+	for i := range docs {
+		cursor.DocumentIDs = append(cursor.DocumentIDs, docs[i].ID)
+		c.notify(ctx, webhooks.DocumentCreated, webhooks.DocumentData{ID: docs[i].ID, Title: docs[i].Title, Metadata: docs[i].Metadata})
+	}
+	if previous != nil {
+		return c.replace(ctx, previous, cursor, errUpdated)
+	}
+	return c.replace(ctx, previous, cursor, nil)
+}
+
+// contentHash returns the hex-encoded SHA-256 digest of an object's content
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// replace records cursor and then deletes the documents of the previous
+// version, returning result on success
+func (c *Connector) replace(ctx context.Context, previous, cursor *storage.Cursor, result error) error {
+	if err := c.cursors.PutCursor(cursor); err != nil {
+		return fmt.Errorf("failed to record ingestion cursor: %w", err)
+	}
+	if previous != nil {
+		for _, id := range c.deleteDocumentIDs(previous.DocumentIDs) {
+			c.notify(ctx, webhooks.DocumentDeleted, webhooks.DocumentData{ID: id})
+		}
+	}
+	return result
+}
+
+func (c *Connector) notify(ctx context.Context, eventType webhooks.EventType, data webhooks.DocumentData) {
+	if c.notifier != nil {
+		c.notifier.Notify(ctx, eventType, data)
+	}
+}
+
+func (c *Connector) deleteDocuments(docs ...models.Document) {
+	ids := make([]uuid.UUID, len(docs))
+	for i := range docs {
+		ids[i] = docs[i].ID
+	}
+	c.deleteDocumentIDs(ids)
+}
+
+// deleteDocumentIDs deletes documents and returns the IDs that were deleted
+func (c *Connector) deleteDocumentIDs(ids []uuid.UUID) []uuid.UUID {
+	deleted := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		switch err := c.store.DeleteDocument(id); {
+		case err == nil:
+			deleted = append(deleted, id)
+		case !errors.Is(err, storage.ErrDocumentNotFound):
+			log.Printf("S3 connector: failed to delete document %s: %v", id, err)
+		}
+	}
+	return deleted
+}
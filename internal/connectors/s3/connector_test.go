@@ -0,0 +1,162 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/storage"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeObject struct {
+	etag string
+	body string
+}
+
+// fakeBucket serves ListObjectsV2 and GetObject for a path-style bucket "docs"
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	gets    int
+}
+
+func (b *fakeBucket) put(key, etag, body string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = fakeObject{etag: etag, body: body}
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if r.URL.Path == "/docs/" {
+		var listing strings.Builder
+		listing.WriteString("<ListBucketResult><IsTruncated>false</IsTruncated>")
+		for key, obj := range b.objects {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				fmt.Fprintf(&listing, `<Contents><Key>%s</Key><ETag>"%s"</ETag><Size>%d</Size></Contents>`, key, obj.etag, len(obj.body))
+			}
+		}
+		listing.WriteString("</ListBucketResult>")
+		_, _ = w.Write([]byte(listing.String()))
+		return
+	}
+
+	obj, ok := b.objects[strings.TrimPrefix(r.URL.Path, "/docs/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	b.gets++
+	_, _ = w.Write([]byte(obj.body))
+}
+
+type fakeEmbedder struct{}
+
+func (fakeEmbedder) GetEmbedding(_ context.Context, text string) ([]float32, error) {
+	return []float32{float32(len(text)), 1, 0}, nil
+}
+
+func TestConnectorSyncIsIncremental(t *testing.T) {
+	bucket := &fakeBucket{objects: map[string]fakeObject{
+		"reports/refunds.txt": {etag: "v1", body: "John received a refund of $1,200."},
+		"reports/logo.png":    {etag: "v1", body: "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"},
+		"other/ignored.txt":   {etag: "v1", body: "Outside the prefix"},
+	}}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "connector.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	cursors, err := storage.NewSQLiteCursorStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create cursor store: %v", err)
+	}
+
+	client, err := NewClient(ClientConfig{Endpoint: server.URL, Region: "us-east-1", Bucket: "docs", PathStyle: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantStore := store.ForTenant("acme")
+	connector := NewConnector(client, "reports/", 1<<20, ingest.NewPipeline(fakeEmbedder{}, 2000, 200), tenantStore, cursors.ForTenant("acme"))
+
+	stats, err := connector.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats != (SyncStats{Listed: 2, Added: 1, Skipped: 1}) {
+		t.Fatalf("Unexpected first sync stats: %+v", stats)
+	}
+	docs := tenantStore.GetAllDocuments()
+	if len(docs) != 1 {
+		t.Fatalf("Expected 1 document, got %d", len(docs))
+	}
+	if docs[0].Metadata[MetadataBucket] != "docs" || docs[0].Metadata[MetadataKey] != "reports/refunds.txt" {
+		t.Errorf("Expected bucket and key metadata, got %v", docs[0].Metadata)
+	}
+	if len(store.GetAllDocuments()) != 0 {
+		t.Error("Expected documents to be ingested into the configured tenant only")
+	}
+
+	// Unchanged objects, including the unsupported one, are not fetched again
+	gets := bucket.gets
+	stats, err = connector.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats != (SyncStats{Listed: 2, Unchanged: 2}) || bucket.gets != gets {
+		t.Fatalf("Expected rerun to skip unchanged objects, got %+v with %d fetches", stats, bucket.gets-gets)
+	}
+
+	// A changed object replaces its documents
+	bucket.put("reports/refunds.txt", "v2", "John received a refund of $1,500.")
+	stats, err = connector.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats != (SyncStats{Listed: 2, Updated: 1, Unchanged: 1}) {
+		t.Fatalf("Unexpected sync stats after change: %+v", stats)
+	}
+	docs = tenantStore.GetAllDocuments()
+	if len(docs) != 1 || !strings.Contains(docs[0].Content, "$1,500") || docs[0].Metadata[MetadataETag] != "v2" {
+		t.Fatalf("Expected the changed object's documents to be replaced, got %+v", docs)
+	}
+
+	// A new ETag with identical content, e.g. after a copy onto itself, keeps the documents
+	id := docs[0].ID
+	bucket.put("reports/refunds.txt", "v3", "John received a refund of $1,500.")
+	stats, err = connector.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats != (SyncStats{Listed: 2, Unchanged: 2}) {
+		t.Fatalf("Expected identical content to be unchanged, got %+v", stats)
+	}
+	if docs = tenantStore.GetAllDocuments(); len(docs) != 1 || docs[0].ID != id {
+		t.Fatalf("Expected the documents to be kept, got %+v", docs)
+	}
+
+	// Deleted objects lose their documents
+	bucket.mu.Lock()
+	delete(bucket.objects, "reports/refunds.txt")
+	bucket.mu.Unlock()
+	stats, err = connector.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats != (SyncStats{Listed: 1, Unchanged: 1, Removed: 1}) {
+		t.Fatalf("Unexpected sync stats after deletion: %+v", stats)
+	}
+	if docs = tenantStore.GetAllDocuments(); len(docs) != 0 {
+		t.Fatalf("Expected the deleted object's documents to be removed, got %d", len(docs))
+	}
+}
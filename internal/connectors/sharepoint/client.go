@@ -0,0 +1,345 @@
+// Package sharepoint is the SharePoint and OneDrive source of the mirror
+// connector. It lists the files of a document library through Microsoft Graph
+// and authenticates as an Entra ID application with a client secret.
+package sharepoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"rerag-rbac-rag-llm/internal/connectors/mirror"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default endpoints of Microsoft Graph and the Microsoft identity platform
+const (
+	DefaultEndpoint  = "https://graph.microsoft.com/v1.0"
+	DefaultAuthority = "https://login.microsoftonline.com"
+)
+
+// scope requests the application permissions granted to the app, e.g. Sites.Read.All
+const scope = "https://graph.microsoft.com/.default"
+
+// Config holds the settings of a document library source
+type Config struct {
+	// Endpoint is the Graph API base URL; empty uses DefaultEndpoint
+	Endpoint string
+	// Authority is the identity platform URL; empty uses DefaultAuthority
+	Authority    string
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// DriveID is the ID of the document library
+	DriveID string
+	// Folder limits the sync to a folder path and its subfolders, e.g. "Finance/2024"
+	Folder string
+}
+
+// Client reads the files and permissions of a document library
+type Client struct {
+	cfg       Config
+	http      *httpclient.Client
+	now       func() time.Time
+	endpoint  string
+	authority string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewClient creates a client for a document library. httpClient applies
+// timeouts and retries; nil sends single attempts without a timeout.
+func NewClient(cfg Config, httpClient *httpclient.Client) (*Client, error) {
+	if cfg.TenantID == "" || cfg.ClientID == "" || cfg.ClientSecret == "" || cfg.DriveID == "" {
+		return nil, fmt.Errorf("tenant ID, client ID, client secret, and drive ID are required")
+	}
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	authority := strings.TrimSuffix(cfg.Authority, "/")
+	if authority == "" {
+		authority = DefaultAuthority
+	}
+	if httpClient == nil {
+		httpClient = httpclient.New(httpclient.Options{})
+	}
+	return &Client{cfg: cfg, http: httpClient, now: time.Now, endpoint: endpoint, authority: authority}, nil
+}
+
+// Name identifies the library or folder in ingestion cursors
+func (c *Client) Name() string {
+	if folder := strings.Trim(c.cfg.Folder, "/"); folder != "" {
+		return "sharepoint://" + c.cfg.DriveID + "/" + folder
+	}
+	return "sharepoint://" + c.cfg.DriveID
+}
+
+// driveItem is an entry of a children response
+type driveItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	CTag string `json:"cTag"`
+	ETag string `json:"eTag"`
+	Size int64  `json:"size"`
+	File *struct {
+		MIMEType string `json:"mimeType"`
+	} `json:"file"`
+	Folder *struct{} `json:"folder"`
+}
+
+// List calls fn for the files of the library or folder, descending into subfolders
+func (c *Client) List(ctx context.Context, fn func(mirror.File) error) error {
+	drive := "/drives/" + url.PathEscape(c.cfg.DriveID)
+	first := drive + "/root/children"
+	if folder := strings.Trim(c.cfg.Folder, "/"); folder != "" {
+		first = drive + "/root:/" + escapePath(folder) + ":/children"
+	}
+
+	pending := []string{c.endpoint + first}
+	for len(pending) > 0 {
+		next := pending[0]
+		pending = pending[1:]
+		for next != "" {
+			var page struct {
+				Value    []driveItem `json:"value"`
+				NextLink string      `json:"@odata.nextLink"`
+			}
+			if err := c.getJSON(ctx, next, &page); err != nil {
+				return fmt.Errorf("failed to list files: %w", err)
+			}
+
+			for _, item := range page.Value {
+				switch {
+				case item.Folder != nil:
+					pending = append(pending, c.endpoint+drive+"/items/"+url.PathEscape(item.ID)+"/children")
+				case item.File != nil:
+					// The cTag only changes with the content, not with renames or sharing
+					version := item.CTag
+					if version == "" {
+						version = item.ETag
+					}
+					file := mirror.File{ID: item.ID, Name: item.Name, MIMEType: item.File.MIMEType, Version: version, Size: item.Size}
+					if err := fn(file); err != nil {
+						return err
+					}
+				}
+			}
+			next = page.NextLink
+		}
+	}
+	return nil
+}
+
+// escapePath escapes the segments of a folder path
+func escapePath(folder string) string {
+	segments := strings.Split(folder, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// Download returns the content of a file from its pre-authorized download
+// URL, which is requested without the access token
+func (c *Client) Download(ctx context.Context, file mirror.File) ([]byte, string, error) {
+	var item struct {
+		DownloadURL string `json:"@microsoft.graph.downloadUrl"`
+	}
+	target := c.endpoint + "/drives/" + url.PathEscape(c.cfg.DriveID) + "/items/" + url.PathEscape(file.ID) + "?select=id,@microsoft.graph.downloadUrl"
+	if err := c.getJSON(ctx, target, &item); err != nil {
+		return nil, "", fmt.Errorf("failed to get download URL: %w", err)
+	}
+	if item.DownloadURL == "" {
+		return nil, "", errors.New("failed to get download URL: none returned")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, item.DownloadURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	body, header, err := c.do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download file: %w", err)
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = file.MIMEType
+	}
+	return body, contentType, nil
+}
+
+// identity is a user or group of a permission
+type identity struct {
+	DisplayName string `json:"displayName"`
+	Email       string `json:"email"`
+	LoginName   string `json:"loginName"`
+}
+
+// identitySet holds the principal a permission is granted to
+type identitySet struct {
+	User      *identity `json:"user"`
+	Group     *identity `json:"group"`
+	SiteUser  *identity `json:"siteUser"`
+	SiteGroup *identity `json:"siteGroup"`
+}
+
+// principal returns the kind and address of the principal; site groups,
+// which have no address, are identified by their display name
+func (s identitySet) principal() (string, string, bool) {
+	switch {
+	case s.User != nil && s.User.Email != "":
+		return mirror.PrincipalUser, s.User.Email, true
+	case s.SiteUser != nil && s.SiteUser.Email != "":
+		return mirror.PrincipalUser, s.SiteUser.Email, true
+	case s.SiteUser != nil && strings.Contains(s.SiteUser.LoginName, "|"):
+		// Claims encoded logins such as i:0#.f|membership|alice@example.com
+		return mirror.PrincipalUser, s.SiteUser.LoginName[strings.LastIndex(s.SiteUser.LoginName, "|")+1:], true
+	case s.Group != nil && s.Group.Email != "":
+		return mirror.PrincipalGroup, s.Group.Email, true
+	case s.SiteGroup != nil && s.SiteGroup.DisplayName != "":
+		return mirror.PrincipalGroup, s.SiteGroup.DisplayName, true
+	}
+	return "", "", false
+}
+
+// Permissions returns the permissions of a file, including those inherited
+// from its folders and the site
+func (c *Client) Permissions(ctx context.Context, file mirror.File) ([]mirror.Permission, error) {
+	next := c.endpoint + "/drives/" + url.PathEscape(c.cfg.DriveID) + "/items/" + url.PathEscape(file.ID) + "/permissions"
+	var acl []mirror.Permission
+	for next != "" {
+		var page struct {
+			Value []struct {
+				Roles                 []string      `json:"roles"`
+				GrantedToV2           *identitySet  `json:"grantedToV2"`
+				GrantedToIdentitiesV2 []identitySet `json:"grantedToIdentitiesV2"`
+				Link                  *struct {
+					Scope string `json:"scope"`
+				} `json:"link"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if err := c.getJSON(ctx, next, &page); err != nil {
+			return nil, err
+		}
+
+		for _, p := range page.Value {
+			role := mirror.RoleRead
+			for _, r := range p.Roles {
+				if r == "write" || r == "owner" {
+					role = mirror.RoleWrite
+				}
+			}
+			if p.Link != nil && (p.Link.Scope == "organization" || p.Link.Scope == "anonymous") {
+				acl = append(acl, mirror.Permission{Principal: mirror.PrincipalEveryone, Role: role})
+				continue
+			}
+
+			granted := p.GrantedToIdentitiesV2
+			if p.GrantedToV2 != nil {
+				granted = append(granted, *p.GrantedToV2)
+			}
+			for _, g := range granted {
+				if principal, email, ok := g.principal(); ok {
+					acl = append(acl, mirror.Permission{Principal: principal, Email: email, Role: role})
+				}
+			}
+		}
+		next = page.NextLink
+	}
+	return acl, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, target string, v interface{}) error {
+	body, _, err := c.get(ctx, target)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// get sends an authorized GET request for an absolute Graph URL, as returned
+// in next links
+func (c *Client) get(ctx context.Context, target string) ([]byte, http.Header, error) {
+	if !strings.HasPrefix(target, c.endpoint+"/") {
+		return nil, nil, fmt.Errorf("refusing to send the access token to %s", target)
+	}
+	token, err := c.accessToken(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return c.do(req)
+}
+
+func (c *Client) do(req *http.Request) ([]byte, http.Header, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("microsoft graph returned status %d: %s", resp.StatusCode, body)
+	}
+	return body, resp.Header, nil
+}
+
+// accessToken returns a cached access token, requesting a new one with the
+// client credentials shortly before the current one expires
+func (c *Client) accessToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.token != "" && now.Before(c.expires.Add(-time.Minute)) {
+		return c.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {c.cfg.ClientID},
+		"client_secret": {c.cfg.ClientSecret},
+		"scope":         {scope},
+	}
+	tokenURL := c.authority + "/" + url.PathEscape(c.cfg.TenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, _, err := c.do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", errors.New("failed to get access token: invalid token response")
+	}
+	c.token = token.AccessToken
+	c.expires = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return c.token, nil
+}
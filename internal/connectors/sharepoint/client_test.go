@@ -0,0 +1,116 @@
+package sharepoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/connectors/mirror"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestClientListsLibraryAndPermissions(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/contoso/oauth2/v2.0/token" {
+			if r.FormValue("client_secret") != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+				http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "graph-token", "expires_in": 3599}`))
+			return
+		}
+		if r.URL.Path == "/download/report" {
+			// Pre-authorized download URLs need no token
+			if r.Header.Get("Authorization") != "" {
+				http.Error(w, "unexpected token", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write([]byte("# Q1 report"))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer graph-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1.0/drives/lib/root:/Finance/2024:/children":
+			if r.URL.Query().Get("page") == "2" {
+				_, _ = w.Write([]byte(`{"value": [{"id": "sub", "name": "Q1", "folder": {"childCount": 1}}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"value": [], "@odata.nextLink": "` + server.URL + `/v1.0/drives/lib/root:/Finance/2024:/children?page=2"}`))
+		case "/v1.0/drives/lib/items/sub/children":
+			_, _ = w.Write([]byte(`{"value": [{"id": "report", "name": "report.md", "cTag": "c:1", "eTag": "e:1", "size": 11, "file": {"mimeType": "text/markdown"}}]}`))
+		case "/v1.0/drives/lib/items/report":
+			_, _ = w.Write([]byte(`{"id": "report", "@microsoft.graph.downloadUrl": "` + server.URL + `/download/report"}`))
+		case "/v1.0/drives/lib/items/report/permissions":
+			_, _ = w.Write([]byte(`{"value": [
+				{"roles": ["owner"], "grantedToV2": {"siteGroup": {"displayName": "Finance Owners"}}},
+				{"roles": ["read"], "grantedToV2": {"siteUser": {"loginName": "i:0#.f|membership|alice@contoso.com"}}},
+				{"roles": ["write"], "grantedToV2": {"user": {"email": "bob@contoso.com", "displayName": "Bob"}}},
+				{"roles": ["read"], "link": {"scope": "users"}, "grantedToIdentitiesV2": [{"group": {"email": "auditors@contoso.com"}}]},
+				{"roles": ["read"], "link": {"scope": "organization"}}
+			]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{
+		Endpoint:     server.URL + "/v1.0",
+		Authority:    server.URL,
+		TenantID:     "contoso",
+		ClientID:     "app",
+		ClientSecret: "s3cret",
+		DriveID:      "lib",
+		Folder:       "/Finance/2024/",
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	ctx := context.Background()
+
+	var files []mirror.File
+	if err := client.List(ctx, func(f mirror.File) error {
+		files = append(files, f)
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(files) != 1 || files[0] != (mirror.File{ID: "report", Name: "report.md", MIMEType: "text/markdown", Version: "c:1", Size: 11}) {
+		t.Fatalf("Expected the report in the subfolder, got %+v", files)
+	}
+	if client.Name() != "sharepoint://lib/Finance/2024" {
+		t.Errorf("Unexpected source name %q", client.Name())
+	}
+
+	data, contentType, err := client.Download(ctx, files[0])
+	if err != nil || string(data) != "# Q1 report" || contentType != "text/markdown" {
+		t.Errorf("Expected the file content, got %q %q %v", data, contentType, err)
+	}
+
+	acl, err := client.Permissions(ctx, files[0])
+	if err != nil {
+		t.Fatalf("Permissions failed: %v", err)
+	}
+	expected := []mirror.Permission{
+		{Principal: mirror.PrincipalGroup, Email: "Finance Owners", Role: mirror.RoleWrite},
+		{Principal: mirror.PrincipalUser, Email: "alice@contoso.com", Role: mirror.RoleRead},
+		{Principal: mirror.PrincipalUser, Email: "bob@contoso.com", Role: mirror.RoleWrite},
+		{Principal: mirror.PrincipalGroup, Email: "auditors@contoso.com", Role: mirror.RoleRead},
+		{Principal: mirror.PrincipalEveryone, Role: mirror.RoleRead},
+	}
+	if !slices.Equal(acl, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, acl)
+	}
+
+	// Next links pointing elsewhere do not receive the token
+	if _, _, err := client.get(ctx, "https://attacker.example/v1.0/drives"); err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Errorf("Expected a foreign URL to be refused, got %v", err)
+	}
+}
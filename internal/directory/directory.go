@@ -0,0 +1,254 @@
+// Package directory manages the users and groups of the mock auth mode and
+// mirrors group memberships into the permission service.
+//
+// A seed file declares the directory applied on startup:
+//
+//	groups:
+//	  - name: tax-advisors
+//	    description: Advisors of the Munich office
+//	users:
+//	  - username: alice
+//	    display_name: Alice Adams
+//	    groups: [tax-advisors]
+//	    roles: [advisor]
+//
+// Seeding creates or replaces the declared entries and leaves the others in
+// place, so restarts are idempotent.
+package directory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// ErrMembership is returned when the permission service fails to change a
+// group membership
+var ErrMembership = errors.New("failed to change group membership")
+
+// Directory changes the directory store and keeps the permission service's
+// group memberships in step with it
+type Directory struct {
+	store  storage.DirectoryStore
+	groups permissions.GroupManager
+}
+
+// New returns a directory backed by store. Memberships are mirrored into
+// permService if it manages groups.
+func New(store storage.DirectoryStore, permService permissions.PermissionChecker) *Directory {
+	groups, _ := permService.(permissions.GroupManager)
+	return &Directory{store: store, groups: groups}
+}
+
+// Store returns the directory store restricted to the context's tenant
+func (d *Directory) Store(ctx context.Context) storage.DirectoryStore {
+	return d.store.ForTenant(tenant.FromContext(ctx))
+}
+
+// ValidateUser checks the user's names and sorts its groups and roles
+func ValidateUser(user *models.DirectoryUser) error {
+	user.Username = strings.TrimSpace(user.Username)
+	if user.Username == "" || strings.Contains(user.Username, ":") {
+		return fmt.Errorf("invalid username %q", user.Username)
+	}
+	for _, group := range user.Groups {
+		if err := permissions.ValidateGroupName(group); err != nil {
+			return err
+		}
+	}
+	for _, role := range user.Roles {
+		if strings.TrimSpace(role) == "" {
+			return errors.New("roles must not be empty")
+		}
+	}
+	user.Groups = sorted(user.Groups)
+	user.Roles = sorted(user.Roles)
+	return nil
+}
+
+// ValidateGroup checks the group's name
+func ValidateGroup(group *models.DirectoryGroup) error {
+	return permissions.ValidateGroupName(group.Name)
+}
+
+// sorted returns values sorted and without duplicates, never nil
+func sorted(values []string) []string {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return append([]string{}, slices.Compact(values)...)
+}
+
+// PutUser creates or replaces the user and reports whether it was created.
+// Memberships are changed in the permission service first, so a failure
+// leaves the stored user as it was.
+func (d *Directory) PutUser(ctx context.Context, user *models.DirectoryUser) (bool, error) {
+	if err := ValidateUser(user); err != nil {
+		return false, err
+	}
+	store := d.Store(ctx)
+	for _, group := range user.Groups {
+		if _, err := store.GetGroup(group); err != nil {
+			return false, fmt.Errorf("%w: %s", err, group)
+		}
+	}
+
+	var previous []string
+	existing, err := store.GetUser(user.Username)
+	switch {
+	case err == nil:
+		previous = existing.Groups
+	case !errors.Is(err, storage.ErrDirectoryUserNotFound):
+		return false, err
+	}
+	if err := d.sync(ctx, user.Username, previous, user.Groups); err != nil {
+		return false, err
+	}
+	return store.PutUser(user)
+}
+
+// DeleteUser deletes the user and removes it from the permission service's
+// groups
+func (d *Directory) DeleteUser(ctx context.Context, username string) (*models.DirectoryUser, error) {
+	store := d.Store(ctx)
+	user, err := store.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.sync(ctx, username, user.Groups, nil); err != nil {
+		return nil, err
+	}
+	return store.DeleteUser(username)
+}
+
+// PutGroup creates the group or updates its description and reports whether
+// it was created
+func (d *Directory) PutGroup(ctx context.Context, group *models.DirectoryGroup) (bool, error) {
+	if err := ValidateGroup(group); err != nil {
+		return false, err
+	}
+	return d.Store(ctx).PutGroup(group)
+}
+
+// DeleteGroup deletes the group and removes its members from the permission
+// service's group of the same name. Relations granted to the group remain.
+func (d *Directory) DeleteGroup(ctx context.Context, name string) (*models.DirectoryGroup, error) {
+	store := d.Store(ctx)
+	group, err := store.GetGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range group.Members {
+		if err := d.sync(ctx, member, []string{name}, nil); err != nil {
+			return nil, err
+		}
+	}
+	return store.DeleteGroup(name)
+}
+
+// sync changes the user's memberships in the permission service from
+// previous to current. Permission services that cannot manage groups are
+// skipped; the authenticator still claims the directory groups for the user.
+func (d *Directory) sync(ctx context.Context, username string, previous, current []string) error {
+	if d.groups == nil {
+		return nil
+	}
+	for _, group := range current {
+		if slices.Contains(previous, group) {
+			continue
+		}
+		if err := d.groups.AddMember(ctx, group, username); err != nil {
+			if errors.Is(err, permissions.ErrChangesUnsupported) {
+				return nil
+			}
+			return fmt.Errorf("%w: adding %s to %s: %w", ErrMembership, username, group, err)
+		}
+	}
+	for _, group := range previous {
+		if slices.Contains(current, group) {
+			continue
+		}
+		if err := d.groups.RemoveMember(ctx, group, username); err != nil {
+			if errors.Is(err, permissions.ErrChangesUnsupported) {
+				return nil
+			}
+			return fmt.Errorf("%w: removing %s from %s: %w", ErrMembership, username, group, err)
+		}
+	}
+	return nil
+}
+
+// Seed is a directory declared in a seed file
+type Seed struct {
+	Groups []SeedGroup `yaml:"groups"`
+	Users  []SeedUser  `yaml:"users"`
+}
+
+// SeedGroup is a group declared in a seed file
+type SeedGroup struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+}
+
+// SeedUser is a user declared in a seed file
+type SeedUser struct {
+	Username    string   `yaml:"username"`
+	DisplayName string   `yaml:"display_name"`
+	Groups      []string `yaml:"groups"`
+	Roles       []string `yaml:"roles"`
+}
+
+// ParseSeed reads and validates a YAML seed file. Unknown fields are rejected
+// so typos do not silently drop memberships.
+func ParseSeed(data []byte) (*Seed, error) {
+	var seed Seed
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&seed); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid directory seed: %w", err)
+	}
+
+	groups := make([]string, 0, len(seed.Groups))
+	for _, group := range seed.Groups {
+		if err := permissions.ValidateGroupName(group.Name); err != nil {
+			return nil, fmt.Errorf("invalid directory seed: %w", err)
+		}
+		groups = append(groups, group.Name)
+	}
+	for _, user := range seed.Users {
+		if err := ValidateUser(&models.DirectoryUser{Username: user.Username, Groups: user.Groups, Roles: user.Roles}); err != nil {
+			return nil, fmt.Errorf("invalid directory seed: %w", err)
+		}
+		for _, group := range user.Groups {
+			if !slices.Contains(groups, group) {
+				return nil, fmt.Errorf("invalid directory seed: user %s: group %q is not declared", user.Username, group)
+			}
+		}
+	}
+	return &seed, nil
+}
+
+// Seed creates or replaces the seed's groups and then its users in the
+// context's tenant
+func (d *Directory) Seed(ctx context.Context, seed *Seed) error {
+	for _, group := range seed.Groups {
+		if _, err := d.PutGroup(ctx, &models.DirectoryGroup{Name: group.Name, Description: group.Description}); err != nil {
+			return fmt.Errorf("failed to seed group %s: %w", group.Name, err)
+		}
+	}
+	for _, user := range seed.Users {
+		if _, err := d.PutUser(ctx, &models.DirectoryUser{Username: user.Username, DisplayName: user.DisplayName, Groups: user.Groups, Roles: user.Roles}); err != nil {
+			return fmt.Errorf("failed to seed user %s: %w", user.Username, err)
+		}
+	}
+	return nil
+}
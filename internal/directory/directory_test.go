@@ -0,0 +1,137 @@
+package directory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// groupService is a permission service that manages groups in memory
+type groupService struct {
+	permissions.PermissionChecker
+	members map[string][]string
+	fail    error
+}
+
+func (g *groupService) AddMember(_ context.Context, group, user string) error {
+	if g.fail != nil {
+		return g.fail
+	}
+	if !slices.Contains(g.members[group], user) {
+		g.members[group] = append(g.members[group], user)
+	}
+	return nil
+}
+
+func (g *groupService) RemoveMember(_ context.Context, group, user string) error {
+	if g.fail != nil {
+		return g.fail
+	}
+	g.members[group] = slices.DeleteFunc(g.members[group], func(member string) bool { return member == user })
+	return nil
+}
+
+func (g *groupService) Members(_ context.Context, group string) ([]string, error) {
+	return g.members[group], nil
+}
+
+func (g *groupService) ListGroupTuples(context.Context, string) ([]permissions.Tuple, error) {
+	return nil, nil
+}
+
+func newTestDirectory(t *testing.T) (*Directory, *groupService) {
+	t.Helper()
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "directory.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	users, err := storage.NewSQLiteDirectoryStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create directory store: %v", err)
+	}
+	groups := &groupService{members: make(map[string][]string)}
+	return New(users, groups), groups
+}
+
+func TestSeedMirrorsMemberships(t *testing.T) {
+	dir, groups := newTestDirectory(t)
+	ctx := tenant.NewContext(context.Background(), "acme")
+
+	seed, err := ParseSeed([]byte(`
+groups:
+  - name: advisors
+    description: Tax advisors
+  - name: auditors
+users:
+  - username: alice
+    groups: [advisors, advisors]
+    roles: [advisor]
+  - username: bob
+    groups: [auditors]
+`))
+	if err != nil {
+		t.Fatalf("ParseSeed failed: %v", err)
+	}
+	// Seeding twice is idempotent
+	for range 2 {
+		if err := dir.Seed(ctx, seed); err != nil {
+			t.Fatalf("Seed failed: %v", err)
+		}
+	}
+	if !slices.Equal(groups.members["advisors"], []string{"alice"}) || !slices.Equal(groups.members["auditors"], []string{"bob"}) {
+		t.Fatalf("Expected seeded memberships in the permission service, got %v", groups.members)
+	}
+	alice, err := dir.Store(ctx).GetUser("alice")
+	if err != nil || !slices.Equal(alice.Groups, []string{"advisors"}) {
+		t.Fatalf("Unexpected user %+v (%v)", alice, err)
+	}
+
+	// Moving a user changes their memberships
+	if _, err := dir.PutUser(ctx, &models.DirectoryUser{Username: "alice", Groups: []string{"auditors"}}); err != nil {
+		t.Fatalf("PutUser failed: %v", err)
+	}
+	if len(groups.members["advisors"]) != 0 || !slices.Equal(groups.members["auditors"], []string{"bob", "alice"}) {
+		t.Errorf("Expected alice to move to auditors, got %v", groups.members)
+	}
+
+	// A failed membership change leaves the stored user unchanged
+	groups.fail = errors.New("keto unavailable")
+	if _, err := dir.PutUser(ctx, &models.DirectoryUser{Username: "alice", Groups: []string{"advisors"}}); !errors.Is(err, ErrMembership) {
+		t.Fatalf("Expected ErrMembership, got %v", err)
+	}
+	if alice, err := dir.Store(ctx).GetUser("alice"); err != nil || !slices.Equal(alice.Groups, []string{"auditors"}) {
+		t.Errorf("Expected alice to stay in auditors, got %+v (%v)", alice, err)
+	}
+	groups.fail = nil
+
+	// Deleting a group removes its members from the permission service
+	if _, err := dir.DeleteGroup(ctx, "auditors"); err != nil {
+		t.Fatalf("DeleteGroup failed: %v", err)
+	}
+	if len(groups.members["auditors"]) != 0 {
+		t.Errorf("Expected auditors to have no members, got %v", groups.members["auditors"])
+	}
+}
+
+func TestParseSeedRejectsInvalidSeeds(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":    "users:\n  - username: alice\n    group: [advisors]\n",
+		"undeclared group": "users:\n  - username: alice\n    groups: [advisors]\n",
+		"invalid group":    "groups:\n  - name: \"tax advisors\"\n",
+		"empty username":   "users:\n  - username: \" \"\n",
+		"subject username": "users:\n  - username: share:alice\n",
+	}
+	for name, data := range tests {
+		if _, err := ParseSeed([]byte(data)); err == nil || !strings.Contains(err.Error(), "invalid directory seed") {
+			t.Errorf("%s: expected an invalid seed error, got %v", name, err)
+		}
+	}
+}
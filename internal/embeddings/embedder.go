@@ -3,39 +3,104 @@ package embeddings
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"time"
 )
 
 // Embedder provides text embedding capabilities using Ollama
 type Embedder struct {
 	ollamaURL string
 	model     string
+	keepAlive string
+	client    *httpclient.Client
 }
 
-// NewEmbedder creates a new Embedder instance with default configuration
-func NewEmbedder() *Embedder {
-	return &Embedder{
+// Option configures an Embedder
+type Option func(*Embedder)
+
+// WithURL sets the Ollama base URL
+func WithURL(url string) Option {
+	return func(e *Embedder) { e.ollamaURL = url }
+}
+
+// WithModel sets the embedding model
+func WithModel(model string) Option {
+	return func(e *Embedder) { e.model = model }
+}
+
+// WithKeepAlive sets how long Ollama keeps the model loaded after a request,
+// as an Ollama duration such as "5m" or "-1" for indefinitely
+func WithKeepAlive(keepAlive string) Option {
+	return func(e *Embedder) { e.keepAlive = keepAlive }
+}
+
+// WithClient sets the HTTP client applying timeouts, retries, and the circuit breaker
+func WithClient(client *httpclient.Client) Option {
+	return func(e *Embedder) { e.client = client }
+}
+
+// NewEmbedder creates a new Embedder for a local Ollama with nomic-embed-text,
+// adjusted by opts
+func NewEmbedder(opts ...Option) *Embedder {
+	e := &Embedder{
 		ollamaURL: "http://localhost:11434",
 		model:     "nomic-embed-text",
 	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.client == nil {
+		e.client = httpclient.New(httpclient.Options{})
+	}
+	return e
+}
+
+// NewEmbedderFromConfig creates an Embedder using the Ollama URL, embedding model,
+// keep-alive, and resilience settings from cfg
+func NewEmbedderFromConfig(cfg config.OllamaConfig) *Embedder {
+	return NewEmbedder(
+		WithURL(cfg.BaseURL),
+		WithModel(cfg.EmbeddingModel),
+		WithKeepAlive(cfg.KeepAlive),
+		WithClient(httpclient.New(httpclient.Options{
+			Timeout:          time.Duration(cfg.Timeout) * time.Second,
+			MaxRetries:       cfg.MaxRetries,
+			FailureThreshold: cfg.Breaker.FailureThreshold,
+			Cooldown:         time.Duration(cfg.Breaker.Cooldown) * time.Second,
+		})),
+	)
 }
 
 // GetEmbedding generates a vector embedding for the given text
-func (e *Embedder) GetEmbedding(text string) ([]float32, error) {
+func (e *Embedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
 	reqBody := map[string]interface{}{
 		"model":  e.model,
 		"prompt": text,
 	}
+	if e.keepAlive != "" {
+		reqBody["keep_alive"] = e.keepAlive
+	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := http.Post(e.ollamaURL+"/api/embeddings", "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.ollamaURL+"/api/embeddings", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	requestid.SetHeader(ctx, req)
+
+	resp, err := e.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -45,6 +110,9 @@ func (e *Embedder) GetEmbedding(text string) ([]float32, error) {
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, body)
+	}
 
 	var result struct {
 		Embedding []float32 `json:"embedding"`
@@ -59,3 +127,29 @@ func (e *Embedder) GetEmbedding(text string) ([]float32, error) {
 
 	return result.Embedding, nil
 }
+
+// Ping checks that Ollama is reachable and has the embedding model
+func (e *Embedder) Ping(ctx context.Context) error {
+	jsonData, err := json.Marshal(map[string]string{"model": e.model})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.ollamaURL+"/api/show", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	requestid.SetHeader(ctx, req)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama returned status %d for embedding model %s", resp.StatusCode, e.model)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package embeddings
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/testing/ollamatest"
+	"testing"
+	"time"
+)
+
+func TestNewEmbedderFromConfig(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embeddings" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		_, _ = w.Write([]byte(`{"embedding": [0.1, 0.2]}`))
+	}))
+	defer server.Close()
+
+	embedder := NewEmbedderFromConfig(config.OllamaConfig{
+		BaseURL:        server.URL,
+		EmbeddingModel: "custom-embed",
+		Timeout:        5,
+		KeepAlive:      "10m",
+	})
+
+	embedding, err := embedder.GetEmbedding(context.Background(), "text")
+	if err != nil {
+		t.Fatalf("GetEmbedding failed: %v", err)
+	}
+	if len(embedding) != 2 {
+		t.Errorf("Expected 2 dimensions, got %d", len(embedding))
+	}
+	if got["model"] != "custom-embed" || got["keep_alive"] != "10m" {
+		t.Errorf("Expected configured model and keep_alive, got %v", got)
+	}
+}
+
+func TestGetEmbeddingReportsErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, `{"error": "model not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	if _, err := NewEmbedder(WithURL(server.URL)).GetEmbedding(context.Background(), "text"); err == nil {
+		t.Error("Expected an error for a non-200 response")
+	}
+}
+
+func TestPingChecksEmbeddingModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/api/show" || body["model"] != "nomic-embed-text" {
+			http.Error(w, `{"error": "model not found"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	if err := NewEmbedder(WithURL(server.URL)).Ping(context.Background()); err != nil {
+		t.Errorf("Expected an available model to pass, got %v", err)
+	}
+	if err := NewEmbedder(WithURL(server.URL), WithModel("missing")).Ping(context.Background()); err == nil {
+		t.Error("Expected a missing model to fail")
+	}
+}
+
+func TestGetEmbeddingRetriesServerErrors(t *testing.T) {
+	server := ollamatest.NewServer()
+	defer server.Close()
+	server.FailNext("/api/embeddings", http.StatusServiceUnavailable, 1)
+
+	embedder := NewEmbedder(WithURL(server.URL), WithClient(httpclient.New(httpclient.Options{
+		MaxRetries: 1,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   time.Millisecond,
+	})))
+	embedding, err := embedder.GetEmbedding(context.Background(), "text")
+	if err != nil {
+		t.Fatalf("GetEmbedding failed: %v", err)
+	}
+	if len(embedding) != ollamatest.DefaultDimensions || len(server.Requests()) != 2 {
+		t.Errorf("Expected a retried request, got %d dimensions after %d requests", len(embedding), len(server.Requests()))
+	}
+}
@@ -6,7 +6,11 @@ import (
 	"log"
 	"net/http"
 
+	"rerag-rbac-rag-llm/internal/clientip"
 	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/requestid"
+
+	"github.com/ory/herodot"
 )
 
 const (
@@ -14,205 +18,161 @@ const (
 	ErrorModeSecure = "secure"
 )
 
-// ErrorResponse represents a standardized API error response
-type ErrorResponse struct {
-	Code    int    `json:"code"`
-	Status  string `json:"status"`
-	Message string `json:"message"`
-	// RequestID is included in development/staging for debugging
-	RequestID string `json:"request_id,omitempty"`
-	// Details are only included in development mode
-	Details string `json:"details,omitempty"`
-}
-
-// ErrorHandler provides secure error handling based on configuration
+// ErrorHandler writes all API errors in herodot's format,
+//
+//	{"error": {"code", "status", "reason", "message", "request", "details"}}
+//
+// and withholds the message and details of errors clients should not see
+// based on the configuration. The reason is written by the server and
+// always kept.
 type ErrorHandler struct {
 	config *config.Config
+	writer *herodot.JSONWriter
 }
 
 // NewErrorHandler creates a new error handler with the given configuration
 func NewErrorHandler(cfg *config.Config) *ErrorHandler {
-	return &ErrorHandler{
+	h := &ErrorHandler{
 		config: cfg,
 	}
+	h.writer = &herodot.JSONWriter{
+		Reporter:      h,
+		ErrorEnhancer: h.enhance,
+	}
+	return h
+}
+
+// Writer returns the herodot writer handlers and middleware use for their
+// responses. Its errors are logged and redacted by h.
+func (h *ErrorHandler) Writer() *herodot.JSONWriter {
+	return h.writer
 }
 
 // HandleAuthError handles authentication-related errors with consistent responses
 func (h *ErrorHandler) HandleAuthError(w http.ResponseWriter, r *http.Request, err error, requestID string) {
-	var response ErrorResponse
-
-	if h.config.Security.ErrorMode == ErrorModeSecure || h.config.IsProduction() {
-		// In secure mode, provide minimal information to prevent user enumeration
-		response = ErrorResponse{
-			Code:      http.StatusUnauthorized,
-			Status:    "Unauthorized",
-			Message:   "Authentication required",
-			RequestID: h.getRequestID(requestID),
-		}
-	} else {
-		// In development mode, provide more details
-		response = ErrorResponse{
-			Code:      http.StatusUnauthorized,
-			Status:    "Unauthorized",
-			Message:   "Authentication failed",
-			RequestID: requestID,
-			Details:   err.Error(),
-		}
-	}
-
-	h.logError("AUTH_ERROR", err, requestID, r)
-	h.writeJSONError(w, response)
+	h.handle(w, r, "AUTH_ERROR", herodot.ErrUnauthorized.WithReason("Authentication required").WithError(err.Error()), err, requestID)
 }
 
 // HandleAuthorizationError handles authorization/permission errors
 func (h *ErrorHandler) HandleAuthorizationError(w http.ResponseWriter, r *http.Request, err error, requestID string) {
-	var response ErrorResponse
-
-	if h.config.Security.ErrorMode == ErrorModeSecure || h.config.IsProduction() {
-		response = ErrorResponse{
-			Code:      http.StatusForbidden,
-			Status:    "Forbidden",
-			Message:   "Access denied",
-			RequestID: h.getRequestID(requestID),
-		}
-	} else {
-		response = ErrorResponse{
-			Code:      http.StatusForbidden,
-			Status:    "Forbidden",
-			Message:   "Permission denied",
-			RequestID: requestID,
-			Details:   err.Error(),
-		}
-	}
-
-	h.logError("AUTHZ_ERROR", err, requestID, r)
-	h.writeJSONError(w, response)
+	h.handle(w, r, "AUTHZ_ERROR", herodot.ErrForbidden.WithReason("Access denied").WithError(err.Error()), err, requestID)
 }
 
 // HandleValidationError handles input validation errors
 func (h *ErrorHandler) HandleValidationError(w http.ResponseWriter, r *http.Request, err error, requestID string) {
-	var response ErrorResponse
-
-	if h.config.Security.ErrorMode == ErrorModeSecure || h.config.IsProduction() {
-		response = ErrorResponse{
-			Code:      http.StatusBadRequest,
-			Status:    "Bad Request",
-			Message:   "Invalid request",
-			RequestID: h.getRequestID(requestID),
-		}
-	} else {
-		response = ErrorResponse{
-			Code:      http.StatusBadRequest,
-			Status:    "Bad Request",
-			Message:   "Invalid request parameters",
-			RequestID: requestID,
-			Details:   err.Error(),
-		}
-	}
-
-	h.logError("VALIDATION_ERROR", err, requestID, r)
-	h.writeJSONError(w, response)
+	h.handle(w, r, "VALIDATION_ERROR", herodot.ErrBadRequest.WithReason("Invalid request parameters").WithError(err.Error()), err, requestID)
 }
 
 // HandleInternalError handles internal server errors
 func (h *ErrorHandler) HandleInternalError(w http.ResponseWriter, r *http.Request, err error, requestID string) {
-	response := ErrorResponse{
-		Code:      http.StatusInternalServerError,
-		Status:    "Internal Server Error",
-		Message:   "An internal error occurred",
-		RequestID: h.getRequestID(requestID),
-	}
-
-	// Never expose internal error details in production
-	if h.config.IsDevelopment() && h.config.Security.ErrorMode != ErrorModeSecure {
-		response.Details = err.Error()
-	}
-
-	h.logError("INTERNAL_ERROR", err, requestID, r)
-	h.writeJSONError(w, response)
+	h.handle(w, r, "INTERNAL_ERROR", herodot.ErrInternalServerError.WithReason("An internal error occurred").WithError(err.Error()), err, requestID)
 }
 
 // HandleNotFoundError handles resource not found errors
 func (h *ErrorHandler) HandleNotFoundError(w http.ResponseWriter, r *http.Request, resource string, requestID string) {
-	var response ErrorResponse
-
-	if h.config.Security.ErrorMode == ErrorModeSecure || h.config.IsProduction() {
-		response = ErrorResponse{
-			Code:      http.StatusNotFound,
-			Status:    "Not Found",
-			Message:   "Resource not found",
-			RequestID: h.getRequestID(requestID),
-		}
-	} else {
-		response = ErrorResponse{
-			Code:      http.StatusNotFound,
-			Status:    "Not Found",
-			Message:   "Resource not found: " + resource,
-			RequestID: requestID,
-		}
-	}
-
-	h.logError("NOT_FOUND", nil, requestID, r)
-	h.writeJSONError(w, response)
+	h.handle(w, r, "NOT_FOUND", herodot.ErrNotFound.WithReason("Resource not found").WithError("Resource not found: "+resource), nil, requestID)
 }
 
 // HandleRateLimitError handles rate limiting errors
 func (h *ErrorHandler) HandleRateLimitError(w http.ResponseWriter, r *http.Request, requestID string) {
-	response := ErrorResponse{
-		Code:      http.StatusTooManyRequests,
-		Status:    "Too Many Requests",
-		Message:   "Rate limit exceeded",
-		RequestID: h.getRequestID(requestID),
-	}
-
-	h.logError("RATE_LIMIT", nil, requestID, r)
-	h.writeJSONError(w, response)
+	h.handle(w, r, "RATE_LIMIT", &herodot.DefaultError{
+		CodeField:   http.StatusTooManyRequests,
+		StatusField: http.StatusText(http.StatusTooManyRequests),
+		ReasonField: "Rate limit exceeded",
+		ErrorField:  "Too many requests, please try again later",
+	}, nil, requestID)
 }
 
 // HandleDatabaseError handles database-related errors
 func (h *ErrorHandler) HandleDatabaseError(w http.ResponseWriter, r *http.Request, err error, requestID string) {
-	response := ErrorResponse{
-		Code:      http.StatusInternalServerError,
-		Status:    "Internal Server Error",
-		Message:   "Database operation failed",
-		RequestID: h.getRequestID(requestID),
-	}
-
-	// Only show database errors in development
-	if h.config.IsDevelopment() && h.config.Security.ErrorMode != ErrorModeSecure {
-		response.Details = err.Error()
-	}
-
-	h.logError("DATABASE_ERROR", err, requestID, r)
-	h.writeJSONError(w, response)
+	h.handle(w, r, "DATABASE_ERROR", herodot.ErrInternalServerError.WithReason("Database operation failed").WithError(err.Error()), err, requestID)
 }
 
 // HandleServiceError handles external service errors (Ollama, Keto)
 func (h *ErrorHandler) HandleServiceError(w http.ResponseWriter, r *http.Request, service string, err error, requestID string) {
-	response := ErrorResponse{
-		Code:      http.StatusBadGateway,
-		Status:    "Bad Gateway",
-		Message:   "External service unavailable",
-		RequestID: h.getRequestID(requestID),
+	h.handle(w, r, "SERVICE_ERROR", &herodot.DefaultError{
+		CodeField:   http.StatusBadGateway,
+		StatusField: http.StatusText(http.StatusBadGateway),
+		ReasonField: "External service unavailable",
+		ErrorField:  "Service unavailable: " + service + ": " + err.Error(),
+	}, err, requestID)
+}
+
+// handle logs err as errorType and writes response
+func (h *ErrorHandler) handle(w http.ResponseWriter, r *http.Request, errorType string, response *herodot.DefaultError, err error, requestID string) {
+	h.logError(errorType, err, requestID, r)
+	h.writer.WriteErrorCode(w, r, response.CodeField, response, herodot.NoLog())
+}
+
+// ReportError logs errors written through Writer. It implements
+// herodot.ErrorReporter.
+func (h *ErrorHandler) ReportError(r *http.Request, code int, err error, _ ...interface{}) {
+	h.logError(errorType(code), err, requestid.FromContext(r.Context()), r)
+}
+
+// enhance converts err to herodot's error body, redacting it if needed
+func (h *ErrorHandler) enhance(r *http.Request, err error) interface{} {
+	e := herodot.ToDefaultError(err, r.Header.Get(requestid.Header))
+	if e.StatusField == "" {
+		e.StatusField = http.StatusText(e.CodeField)
+	}
+	if h.redacted(e.CodeField) {
+		e.ErrorField = genericMessage(e.CodeField)
+		e.DetailsField = nil
+		e.DebugField = ""
 	}
+	e.RIDField = h.getRequestID(e.RIDField)
+	return &herodot.ErrorContainer{Error: e}
+}
 
-	if h.config.IsDevelopment() && h.config.Security.ErrorMode != ErrorModeSecure {
-		response.Message = "Service unavailable: " + service
-		response.Details = err.Error()
+// redacted reports whether the message and details of errors with code are
+// withheld: always in secure error mode, for all errors in production, and
+// for internal errors outside development
+func (h *ErrorHandler) redacted(code int) bool {
+	if h.config.Security.ErrorMode == ErrorModeSecure || h.config.IsProduction() {
+		return true
 	}
+	return code == http.StatusInternalServerError && !h.config.IsDevelopment()
+}
 
-	h.logError("SERVICE_ERROR", err, requestID, r)
-	h.writeJSONError(w, response)
+// genericMessages replace the messages of redacted errors
+var genericMessages = map[int]string{
+	http.StatusBadRequest:           herodot.ErrBadRequest.ErrorField,
+	http.StatusUnauthorized:         herodot.ErrUnauthorized.ErrorField,
+	http.StatusForbidden:            herodot.ErrForbidden.ErrorField,
+	http.StatusNotFound:             herodot.ErrNotFound.ErrorField,
+	http.StatusConflict:             herodot.ErrConflict.ErrorField,
+	http.StatusUnsupportedMediaType: herodot.ErrUnsupportedMediaType.ErrorField,
+	http.StatusInternalServerError:  herodot.ErrInternalServerError.ErrorField,
 }
 
-// writeJSONError writes an error response as JSON
-func (h *ErrorHandler) writeJSONError(w http.ResponseWriter, response ErrorResponse) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(response.Code)
+// genericMessage returns a message for code revealing nothing about the error
+func genericMessage(code int) string {
+	if message, ok := genericMessages[code]; ok {
+		return message
+	}
+	return http.StatusText(code)
+}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding error response: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// errorType classifies errors written through Writer for the logs
+func errorType(code int) string {
+	switch {
+	case code == http.StatusUnauthorized:
+		return "AUTH_ERROR"
+	case code == http.StatusForbidden:
+		return "AUTHZ_ERROR"
+	case code == http.StatusNotFound:
+		return "NOT_FOUND"
+	case code == http.StatusTooManyRequests:
+		return "RATE_LIMIT"
+	case code == http.StatusBadGateway || code == http.StatusServiceUnavailable:
+		return "SERVICE_ERROR"
+	case code >= http.StatusInternalServerError:
+		return "INTERNAL_ERROR"
+	case code == http.StatusBadRequest || code == http.StatusRequestEntityTooLarge || code == http.StatusUnsupportedMediaType:
+		return "VALIDATION_ERROR"
+	default:
+		return "REQUEST_ERROR"
 	}
 }
 
@@ -224,7 +184,7 @@ func (h *ErrorHandler) logError(errorType string, err error, requestID string, r
 		"method":     r.Method,
 		"path":       r.URL.Path,
 		"user_agent": r.Header.Get("User-Agent"),
-		"remote_ip":  getClientIP(r),
+		"remote_ip":  clientip.FromRequest(r),
 	}
 
 	if err != nil {
@@ -243,28 +203,15 @@ func (h *ErrorHandler) logError(errorType string, err error, requestID string, r
 	}
 }
 
-// getRequestID returns request ID for logging, only in development
+// getRequestID returns the request ID to include in error responses; it is
+// omitted in production with secure error mode
 func (h *ErrorHandler) getRequestID(requestID string) string {
-	if h.config.IsProduction() && h.config.Security.ErrorMode == "secure" {
+	if h.config.IsProduction() && h.config.Security.ErrorMode == ErrorModeSecure {
 		return ""
 	}
 	return requestID
 }
 
-// getClientIP extracts the real client IP from request headers
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header first (for proxies)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
-	}
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	// Fall back to RemoteAddr
-	return r.RemoteAddr
-}
-
 // Predefined error types for common scenarios
 
 // ErrInvalidAuthHeader indicates malformed authorization header
@@ -0,0 +1,89 @@
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/requestid"
+
+	"github.com/ory/herodot"
+)
+
+// writeError writes err with a handler for cfg and returns the response body
+func writeError(t *testing.T, cfg *config.Config, err error) (int, herodot.DefaultError) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/documents", nil)
+	r.Header.Set(requestid.Header, "req-1")
+	w := httptest.NewRecorder()
+	NewErrorHandler(cfg).Writer().WriteError(w, r, err)
+
+	var body herodot.ErrorContainer
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == nil {
+		t.Fatalf("Expected a herodot error body, got %q: %v", w.Body.String(), err)
+	}
+	return w.Code, *body.Error
+}
+
+func TestWriterRedactsByMode(t *testing.T) {
+	internal := herodot.ErrInternalServerError.WithReason("Failed to search").WithError("dial tcp 10.0.0.5:11434: connection refused")
+	invalid := herodot.ErrBadRequest.WithReason("Invalid request body").WithError("json: unexpected end of input")
+
+	tests := []struct {
+		name            string
+		environment     string
+		errorMode       string
+		err             error
+		expectedMessage string
+		expectedRequest string
+	}{
+		{"development shows internal errors", "development", "", internal, "dial tcp 10.0.0.5:11434: connection refused", "req-1"},
+		{"staging hides internal errors", "staging", "", internal, herodot.ErrInternalServerError.ErrorField, "req-1"},
+		{"staging shows validation errors", "staging", "", invalid, "json: unexpected end of input", "req-1"},
+		{"secure mode hides validation errors", "development", ErrorModeSecure, invalid, herodot.ErrBadRequest.ErrorField, "req-1"},
+		{"production hides validation errors", "production", "", invalid, herodot.ErrBadRequest.ErrorField, "req-1"},
+		{"secure production hides the request ID", "production", ErrorModeSecure, invalid, herodot.ErrBadRequest.ErrorField, ""},
+		{"plain errors are internal errors", "staging", "", errors.New("disk full"), herodot.ErrInternalServerError.ErrorField, "req-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.App.Environment = tt.environment
+			cfg.Security.ErrorMode = tt.errorMode
+
+			code, body := writeError(t, cfg, tt.err)
+			if code != body.CodeField || body.StatusField != http.StatusText(code) {
+				t.Errorf("Expected code and status to match the response, got %d, %d, %q", code, body.CodeField, body.StatusField)
+			}
+			if body.ErrorField != tt.expectedMessage {
+				t.Errorf("Expected message %q, got %q", tt.expectedMessage, body.ErrorField)
+			}
+			if body.RIDField != tt.expectedRequest {
+				t.Errorf("Expected request ID %q, got %q", tt.expectedRequest, body.RIDField)
+			}
+			var carrier herodot.ReasonCarrier
+			if errors.As(tt.err, &carrier) && body.ReasonField != carrier.Reason() {
+				t.Errorf("Expected the reason to be kept, got %q", body.ReasonField)
+			}
+		})
+	}
+}
+
+func TestHandleNotFoundErrorUsesWriterFormat(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.App.Environment = "development"
+	r := httptest.NewRequest(http.MethodGet, "/documents/1", nil)
+	w := httptest.NewRecorder()
+	NewErrorHandler(cfg).HandleNotFoundError(w, r, "document 1", "")
+
+	var body herodot.ErrorContainer
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == nil {
+		t.Fatalf("Expected a herodot error body, got %q: %v", w.Body.String(), err)
+	}
+	if w.Code != http.StatusNotFound || body.Error.ErrorField != "Resource not found: document 1" {
+		t.Errorf("Expected a 404 naming the document, got %d %q", w.Code, body.Error.ErrorField)
+	}
+}
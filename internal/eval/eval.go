@@ -0,0 +1,333 @@
+// Package eval measures RAG quality against a golden set of questions.
+//
+// A golden set lists questions with the user asking them, the documents that
+// should be retrieved, a reference answer, and what the user must never see:
+//
+//	top_k: 3
+//	cases:
+//	  - name: john-doe-refund
+//	    user: alice
+//	    question: What was John Doe's refund in 2023?
+//	    expected_sources: [a7d36b58-3d46-4107-9b88-6b1400bc9a5d]
+//	    expected_answer: John Doe received a refund of $1,200.
+//	    forbidden_sources: [c9f58d7a-5f68-4329-9daa-8d3622de1b7f]
+//	    forbidden_terms: [ABC Corporation]
+//
+// Every case reports the retrieval recall of the expected sources, the
+// groundedness of the answer as rated by an LLM judge, and permission leaks:
+// forbidden documents among the sources or forbidden terms in the answer or
+// sources.
+package eval
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.yaml.in/yaml/v3"
+)
+
+// Set is a golden set of questions
+type Set struct {
+	// TopK is sent with every question; 0 uses the server default
+	TopK  int    `yaml:"top_k"`
+	Cases []Case `yaml:"cases"`
+}
+
+// Case is a question asked by a user with its expected outcome
+type Case struct {
+	Name string `yaml:"name"`
+	// User asks the question; empty uses the default user of the run
+	User     string `yaml:"user"`
+	Question string `yaml:"question"`
+	// ExpectedSources are the IDs of the documents that should be retrieved
+	ExpectedSources []string `yaml:"expected_sources"`
+	// ExpectedAnswer is a reference answer for the judge
+	ExpectedAnswer string `yaml:"expected_answer"`
+	// ForbiddenSources are the IDs of documents the user must not retrieve
+	ForbiddenSources []string `yaml:"forbidden_sources"`
+	// ForbiddenTerms must not appear in the answer or the sources, e.g. the
+	// name of a taxpayer the user has no access to
+	ForbiddenTerms []string `yaml:"forbidden_terms"`
+}
+
+// Parse reads and validates a YAML golden set. Unknown fields are rejected
+// and unnamed cases are named after their position.
+func Parse(data []byte) (*Set, error) {
+	var s Set
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&s); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid golden set: %w", err)
+	}
+	for i := range s.Cases {
+		s.Cases[i].Name = cmp.Or(s.Cases[i].Name, fmt.Sprintf("case %d", i+1))
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid golden set: %w", err)
+	}
+	return &s, nil
+}
+
+// Validate checks that the set has cases and every case is well-formed
+func (s *Set) Validate() error {
+	if len(s.Cases) == 0 {
+		return fmt.Errorf("no cases")
+	}
+	if s.TopK < 0 {
+		return fmt.Errorf("top_k must not be negative")
+	}
+	for _, c := range s.Cases {
+		if strings.TrimSpace(c.Question) == "" {
+			return fmt.Errorf("%s: question is required", c.Name)
+		}
+		for _, id := range slices.Concat(c.ExpectedSources, c.ForbiddenSources) {
+			if _, err := uuid.Parse(id); err != nil {
+				return fmt.Errorf("%s: invalid document ID %q", c.Name, id)
+			}
+		}
+		for _, id := range c.ExpectedSources {
+			if slices.Contains(c.ForbiddenSources, id) {
+				return fmt.Errorf("%s: document %s is both expected and forbidden", c.Name, id)
+			}
+		}
+	}
+	return nil
+}
+
+// Source is a document retrieved for a question
+type Source struct {
+	ID      string
+	Title   string
+	Content string
+	// Included reports whether the source fit into the prompt
+	Included bool
+}
+
+// Response is the system's answer to a question
+type Response struct {
+	Answer  string
+	Sources []Source
+}
+
+// Querier asks the system under test a question as a user
+type Querier interface {
+	Query(ctx context.Context, user, question string, topK int) (*Response, error)
+}
+
+// Judge rates how well an answer is grounded in its sources and agrees with
+// the reference answer, from 0 (unsupported) to 1 (fully supported)
+type Judge interface {
+	Grade(ctx context.Context, question, answer, expected string, sources []Source) (float64, error)
+}
+
+// Options configure a run
+type Options struct {
+	// DefaultUser asks the questions of cases without a user
+	DefaultUser string
+	// Judge grades answers; without one groundedness is not measured
+	Judge Judge
+	// MinRecall is the retrieval recall a case needs to pass
+	MinRecall float64
+	// MinGroundedness is the judge's grade a case needs to pass
+	MinGroundedness float64
+}
+
+// Leak is forbidden content the user was shown
+type Leak struct {
+	// DocumentID is set for a forbidden document among the sources
+	DocumentID string `json:"document_id,omitempty"`
+	// Term is set for a forbidden term
+	Term string `json:"term,omitempty"`
+	// Where names the part of the response that leaked: "sources", "answer",
+	// or "source <id>"
+	Where string `json:"where"`
+}
+
+// CaseResult is the outcome of a case
+type CaseResult struct {
+	Name      string   `json:"name"`
+	User      string   `json:"user"`
+	Question  string   `json:"question"`
+	Answer    string   `json:"answer,omitempty"`
+	Retrieved []string `json:"retrieved"`
+	// Recall is the share of expected sources that were retrieved; 1 without expected sources
+	Recall  float64  `json:"recall"`
+	Missing []string `json:"missing,omitempty"`
+	// Groundedness is the judge's grade; nil if the answer was not judged
+	Groundedness *float64 `json:"groundedness,omitempty"`
+	Leaks        []Leak   `json:"leaks,omitempty"`
+	// Error is set if the question could not be asked or judged
+	Error    string   `json:"error,omitempty"`
+	Failures []string `json:"failures,omitempty"`
+	Passed   bool     `json:"passed"`
+	Seconds  float64  `json:"seconds"`
+}
+
+// Summary aggregates the results of a run
+type Summary struct {
+	Cases  int `json:"cases"`
+	Passed int `json:"passed"`
+	Failed int `json:"failed"`
+	Errors int `json:"errors"`
+	// Recall is the mean recall of the cases that could be asked
+	Recall float64 `json:"recall"`
+	// Groundedness is the mean grade of the judged cases; nil if none was judged
+	Groundedness *float64 `json:"groundedness,omitempty"`
+	Leaks        int      `json:"leaks"`
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Summary Summary      `json:"summary"`
+	Cases   []CaseResult `json:"cases"`
+}
+
+// Run asks every question of set through q and evaluates the responses
+func Run(ctx context.Context, set *Set, q Querier, opts Options) *Report {
+	report := &Report{Cases: make([]CaseResult, 0, len(set.Cases))}
+	var recall, groundedness float64
+	asked, judged := 0, 0
+	for _, c := range set.Cases {
+		result := runCase(ctx, c, set.TopK, q, opts)
+		report.Cases = append(report.Cases, result)
+
+		report.Summary.Cases++
+		report.Summary.Leaks += len(result.Leaks)
+		if result.Passed {
+			report.Summary.Passed++
+		} else {
+			report.Summary.Failed++
+		}
+		if result.Error != "" {
+			report.Summary.Errors++
+		}
+		if result.Retrieved != nil {
+			recall += result.Recall
+			asked++
+		}
+		if result.Groundedness != nil {
+			groundedness += *result.Groundedness
+			judged++
+		}
+	}
+	if asked > 0 {
+		report.Summary.Recall = recall / float64(asked)
+	}
+	if judged > 0 {
+		mean := groundedness / float64(judged)
+		report.Summary.Groundedness = &mean
+	}
+	return report
+}
+
+// runCase asks a single question and evaluates the response
+func runCase(ctx context.Context, c Case, topK int, q Querier, opts Options) (result CaseResult) {
+	start := time.Now()
+	result = CaseResult{Name: c.Name, User: cmp.Or(c.User, opts.DefaultUser), Question: c.Question}
+	defer func() { result.Seconds = time.Since(start).Seconds() }()
+
+	resp, err := q.Query(ctx, result.User, c.Question, topK)
+	if err != nil {
+		result.Error = err.Error()
+		result.Failures = append(result.Failures, "query failed: "+err.Error())
+		return result
+	}
+	result.Answer = resp.Answer
+	result.Retrieved = make([]string, len(resp.Sources))
+	for i, source := range resp.Sources {
+		result.Retrieved[i] = source.ID
+	}
+
+	result.Recall, result.Missing = recallOf(c.ExpectedSources, result.Retrieved)
+	if result.Recall < opts.MinRecall {
+		result.Failures = append(result.Failures, fmt.Sprintf("recall %.2f is below %.2f, missing %s", result.Recall, opts.MinRecall, strings.Join(result.Missing, ", ")))
+	}
+
+	result.Leaks = FindLeaks(resp, c.ForbiddenSources, c.ForbiddenTerms)
+	for _, leak := range result.Leaks {
+		result.Failures = append(result.Failures, "permission leak: "+leak.String())
+	}
+
+	if included := includedSources(resp.Sources); opts.Judge != nil && len(included) > 0 {
+		grade, err := opts.Judge.Grade(ctx, c.Question, resp.Answer, c.ExpectedAnswer, included)
+		if err != nil {
+			result.Error = "judge failed: " + err.Error()
+			result.Failures = append(result.Failures, result.Error)
+		} else {
+			result.Groundedness = &grade
+			if grade < opts.MinGroundedness {
+				result.Failures = append(result.Failures, fmt.Sprintf("groundedness %.2f is below %.2f", grade, opts.MinGroundedness))
+			}
+		}
+	}
+
+	result.Passed = len(result.Failures) == 0
+	return result
+}
+
+// recallOf returns the share of expected IDs among retrieved and the missing ones
+func recallOf(expected, retrieved []string) (float64, []string) {
+	if len(expected) == 0 {
+		return 1, nil
+	}
+	var missing []string
+	for _, id := range expected {
+		if !slices.ContainsFunc(retrieved, func(r string) bool { return strings.EqualFold(r, id) }) {
+			missing = append(missing, id)
+		}
+	}
+	return float64(len(expected)-len(missing)) / float64(len(expected)), missing
+}
+
+// includedSources returns the sources that fit into the prompt
+func includedSources(sources []Source) []Source {
+	var included []Source
+	for _, source := range sources {
+		if source.Included {
+			included = append(included, source)
+		}
+	}
+	return included
+}
+
+// FindLeaks returns the forbidden documents among the sources of resp and the
+// forbidden terms, matched case-insensitively, in its answer and sources
+func FindLeaks(resp *Response, forbiddenSources, forbiddenTerms []string) []Leak {
+	var leaks []Leak
+	for _, source := range resp.Sources {
+		if slices.ContainsFunc(forbiddenSources, func(id string) bool { return strings.EqualFold(id, source.ID) }) {
+			leaks = append(leaks, Leak{DocumentID: source.ID, Where: "sources"})
+		}
+	}
+	for _, term := range forbiddenTerms {
+		if containsFold(resp.Answer, term) {
+			leaks = append(leaks, Leak{Term: term, Where: "answer"})
+		}
+		for _, source := range resp.Sources {
+			if containsFold(source.Title, term) || containsFold(source.Content, term) {
+				leaks = append(leaks, Leak{Term: term, Where: "source " + source.ID})
+			}
+		}
+	}
+	return leaks
+}
+
+func (l Leak) String() string {
+	if l.DocumentID != "" {
+		return fmt.Sprintf("document %s in %s", l.DocumentID, l.Where)
+	}
+	return fmt.Sprintf("%q in %s", l.Term, l.Where)
+}
+
+// containsFold reports whether substr is in s, ignoring case
+func containsFold(s, substr string) bool {
+	return substr != "" && strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,176 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	johnDoe2023 = "a7d36b58-3d46-4107-9b88-6b1400bc9a5d"
+	johnDoe2022 = "b8e47c69-4e57-4218-8c99-7c2511cd0a6e"
+	abcCorp     = "c9f58d7a-5f68-4329-9daa-8d3622de1b7f"
+)
+
+// fakeQuerier answers with fixed responses per user
+type fakeQuerier map[string]*Response
+
+func (f fakeQuerier) Query(_ context.Context, user, _ string, _ int) (*Response, error) {
+	resp, ok := f[user]
+	if !ok {
+		return nil, errors.New("unauthorized")
+	}
+	return resp, nil
+}
+
+// fixedJudge grades every answer the same
+type fixedJudge float64
+
+func (j fixedJudge) Grade(context.Context, string, string, string, []Source) (float64, error) {
+	return float64(j), nil
+}
+
+func TestParse(t *testing.T) {
+	set, err := Parse([]byte(`
+top_k: 3
+cases:
+  - user: alice
+    question: What was John Doe's refund?
+    expected_sources: [` + johnDoe2023 + `]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.TopK != 3 || len(set.Cases) != 1 || set.Cases[0].Name != "case 1" {
+		t.Errorf("Unexpected set %+v", set)
+	}
+
+	for name, data := range map[string]string{
+		"no cases":       `top_k: 3`,
+		"unknown field":  "cases:\n  - question: q\n    expected: [" + johnDoe2023 + "]",
+		"no question":    "cases:\n  - user: alice",
+		"invalid ID":     "cases:\n  - question: q\n    expected_sources: [doc-1]",
+		"contradictions": "cases:\n  - question: q\n    expected_sources: [" + abcCorp + "]\n    forbidden_sources: [" + abcCorp + "]",
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	set := &Set{Cases: []Case{
+		{Name: "alice-refund", User: "alice", Question: "What were John Doe's refunds?", ExpectedSources: []string{johnDoe2023, johnDoe2022}},
+		{Name: "bob-leak", User: "bob", Question: "What was John Doe's refund?", ForbiddenSources: []string{johnDoe2023}, ForbiddenTerms: []string{"john doe"}},
+		{Name: "carol-unknown", User: "carol", Question: "Anything?"},
+	}}
+	querier := fakeQuerier{
+		"alice": {Answer: "John Doe was refunded $1,200 [1].", Sources: []Source{{ID: johnDoe2023, Title: "Tax Return 2023 - John Doe", Included: true}}},
+		"bob":   {Answer: "John Doe was refunded $1,200.", Sources: []Source{{ID: johnDoe2023, Title: "Tax Return 2023 - John Doe", Included: true}}},
+	}
+
+	report := Run(context.Background(), set, querier, Options{Judge: fixedJudge(0.9), MinRecall: 1, MinGroundedness: 0.7})
+
+	alice, bob, carol := report.Cases[0], report.Cases[1], report.Cases[2]
+	if alice.Recall != 0.5 || len(alice.Missing) != 1 || alice.Missing[0] != johnDoe2022 || alice.Passed {
+		t.Errorf("Expected alice to fail with half recall, got %+v", alice)
+	}
+	if alice.Groundedness == nil || *alice.Groundedness != 0.9 {
+		t.Errorf("Expected alice's answer to be judged, got %v", alice.Groundedness)
+	}
+	// The forbidden document and the forbidden term in the answer and the source
+	if len(bob.Leaks) != 3 || bob.Passed {
+		t.Errorf("Expected 3 leaks for bob, got %+v", bob.Leaks)
+	}
+	if carol.Error == "" || carol.Passed || carol.Retrieved != nil {
+		t.Errorf("Expected carol's question to fail, got %+v", carol)
+	}
+
+	summary := report.Summary
+	if summary.Cases != 3 || summary.Failed != 3 || summary.Errors != 1 || summary.Leaks != 3 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if summary.Recall != 0.75 {
+		t.Errorf("Expected the mean recall of the asked cases, got %f", summary.Recall)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	report := &Report{
+		Summary: Summary{Cases: 2, Passed: 1, Failed: 1},
+		Cases: []CaseResult{
+			{Name: "passes", User: "alice", Passed: true, Seconds: 0.5},
+			{Name: "leaks", User: "bob", Failures: []string{"permission leak: document x in sources"}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := WriteJUnit(&buf, report); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<testsuite name="rerag-eval" tests="2" failures="1" errors="0">`,
+		`<testcase name="passes" classname="rerag-eval.alice" time="0.500"></testcase>`,
+		`<failure message="permission leak: document x in sources">`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in\n%s", want, out)
+		}
+	}
+}
+
+func TestOllamaJudge(t *testing.T) {
+	var prompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Prompt string `json:"prompt"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		prompt = body.Prompt
+		_, _ = fmt.Fprint(w, `{"response": "8"}`)
+	}))
+	defer server.Close()
+
+	judge := NewOllamaJudge(server.URL, "judge", time.Second)
+	grade, err := judge.Grade(context.Background(), "Refund?", "$1,200 [1]", "John Doe received $1,200", []Source{{Title: "Return", Content: "Refund: $1,200"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != 0.8 {
+		t.Errorf("Expected grade 0.8, got %f", grade)
+	}
+	if !strings.Contains(prompt, "Source [1]: Return\nRefund: $1,200") || !strings.Contains(prompt, "Reference answer: John Doe received $1,200") {
+		t.Errorf("Expected the sources and reference answer in the prompt, got %q", prompt)
+	}
+}
+
+func TestProbesAndSensitiveTerms(t *testing.T) {
+	target := Target{
+		ID:       abcCorp,
+		Title:    "Tax Return 2023 - ABC Corporation",
+		Content:  "EIN: 12-3456789\nGross Receipts: $2,500,000\nEmployees: 25\nRefund Due: $3,500\nRefund Due: $3,500",
+		Metadata: map[string]interface{}{"taxpayer": "ABC Corporation", "type": "1120", "year": 2023.0},
+	}
+
+	terms := SensitiveTerms(target)
+	want := []string{"Tax Return 2023 - ABC Corporation", "ABC Corporation", "12-3456789", "$2,500,000", "$3,500"}
+	if !slices.Equal(terms, want) {
+		t.Errorf("Expected terms %q, got %q", want, terms)
+	}
+
+	probes := Probes([]Target{target})
+	if len(probes) != len(genericProbes)+3+1+3 {
+		t.Fatalf("Expected generic, lookup, metadata, and keyword probes, got %+v", probes)
+	}
+	if probes[len(genericProbes)].Question != `What does the document "Tax Return 2023 - ABC Corporation" say?` {
+		t.Errorf("Expected the title lookup after the generic probes, got %+v", probes[len(genericProbes)])
+	}
+}
@@ -0,0 +1,108 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gradePattern extracts the first number from the judge's reply
+var gradePattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// judgeSystemPrompt asks the model for a single 0-10 grade
+const judgeSystemPrompt = "You grade answers of a question answering system. An answer is grounded if every statement in it is supported by the sources, and it must agree with the reference answer when one is given. Reply with a single number from 0 (unsupported or wrong) to 10 (fully supported and correct) and nothing else."
+
+// OllamaJudge grades answers by prompting an Ollama model
+type OllamaJudge struct {
+	baseURL string
+	model   string
+	client  *http.Client
+}
+
+// NewOllamaJudge creates a judge backed by an Ollama model
+func NewOllamaJudge(baseURL, model string, timeout time.Duration) *OllamaJudge {
+	return &OllamaJudge{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		model:   model,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Grade asks the model to rate the answer and normalizes its grade to [0, 1]
+func (o *OllamaJudge) Grade(ctx context.Context, question, answer, expected string, sources []Source) (float64, error) {
+	reqBody := map[string]interface{}{
+		"model":  o.model,
+		"prompt": buildGradePrompt(question, answer, expected, sources),
+		"system": judgeSystemPrompt,
+		"stream": false,
+		"options": map[string]interface{}{
+			"temperature": 0,
+			"num_predict": 8,
+		},
+	}
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/api/generate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Response string `json:"response"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, err
+	}
+	return parseGrade(result.Response)
+}
+
+// parseGrade reads a 0-10 grade from the model output and normalizes it to [0, 1]
+func parseGrade(response string) (float64, error) {
+	match := gradePattern.FindString(response)
+	if match == "" {
+		return 0, fmt.Errorf("no grade in judge response %q", response)
+	}
+	grade, err := strconv.ParseFloat(match, 64)
+	if err != nil {
+		return 0, err
+	}
+	return min(max(grade, 0), 10) / 10, nil
+}
+
+func buildGradePrompt(question, answer, expected string, sources []Source) string {
+	var b strings.Builder
+	for i, source := range sources {
+		fmt.Fprintf(&b, "Source [%d]: %s\n%s\n\n", i+1, source.Title, source.Content)
+	}
+	fmt.Fprintf(&b, "Question: %s\n\nAnswer: %s\n\n", question, answer)
+	if expected != "" {
+		fmt.Fprintf(&b, "Reference answer: %s\n\n", expected)
+	}
+	b.WriteString("Grade (0-10):")
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package eval
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteJSON writes the report as indented JSON
+func WriteJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// junitSuite is the root element of a JUnit XML report
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as a JUnit XML test suite for CI systems. Cases
+// whose question could not be asked or judged are reported as errors, other
+// failed cases as failures.
+func WriteJUnit(w io.Writer, r *Report) error {
+	suite := junitSuite{Name: "rerag-eval", Tests: r.Summary.Cases, Cases: make([]junitCase, len(r.Cases))}
+	for i, result := range r.Cases {
+		c := junitCase{
+			Name:      result.Name,
+			ClassName: "rerag-eval." + result.User,
+			Time:      fmt.Sprintf("%.3f", result.Seconds),
+			SystemOut: result.Answer,
+		}
+		if !result.Passed {
+			failure := &junitFailure{Message: result.Failures[0], Text: strings.Join(result.Failures, "\n")}
+			if result.Error != "" {
+				c.Error = failure
+				suite.Errors++
+			} else {
+				c.Failure = failure
+				suite.Failures++
+			}
+		}
+		suite.Cases[i] = c
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,207 @@
+// Package excerpt picks the passages of a document that best match a
+// question and marks the matched terms, so clients can show the supporting
+// passage instead of the whole document.
+package excerpt
+
+import (
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Defaults used by ragservice for query sources
+const (
+	DefaultCount = 2   // excerpts per document
+	DefaultWidth = 240 // bytes per excerpt
+)
+
+// stopWords are frequent question words that would highlight noise
+var stopWords = map[string]bool{
+	"about": true, "and": true, "are": true, "did": true, "does": true, "for": true,
+	"from": true, "has": true, "have": true, "how": true, "the": true, "that": true,
+	"this": true, "was": true, "were": true, "what": true, "when": true, "where": true,
+	"which": true, "who": true, "whom": true, "whose": true, "why": true, "with": true,
+}
+
+// Terms returns the distinct lower-case words of question worth
+// highlighting: words of at least three characters and numbers, without
+// common question words
+func Terms(question string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(question), isSeparator) {
+		if stopWords[word] || slices.Contains(terms, word) {
+			continue
+		}
+		if utf8.RuneCountInString(word) >= 3 || isNumber(word) {
+			terms = append(terms, word)
+		}
+	}
+	return terms
+}
+
+// word is a word of a document with its byte offsets
+type word struct {
+	start, end int
+	term       int // index of the matching term, -1 if none
+}
+
+// Find returns up to count excerpts of about width bytes from content, in
+// document order, preferring passages that match the most distinct terms.
+// Offsets are bytes in content. Content matching no term yields its beginning
+// without highlights; empty content yields nothing.
+func Find(content string, terms []string, count, width int) []models.Excerpt {
+	if strings.TrimSpace(content) == "" || count <= 0 {
+		return nil
+	}
+
+	var hits []word
+	for _, w := range words(content) {
+		for i, term := range terms {
+			if strings.EqualFold(content[w.start:w.end], term) {
+				w.term = i
+				hits = append(hits, w)
+				break
+			}
+		}
+	}
+	if len(hits) == 0 {
+		return []models.Excerpt{build(content, 0, width, nil)}
+	}
+
+	// Greedily pick the window matching the most distinct terms, then the
+	// best window among the hits it does not cover
+	var windows [][2]int
+	remaining := slices.Clone(hits)
+	for len(windows) < count && len(remaining) > 0 {
+		best, bestScore := [2]int{}, -1
+		for _, anchor := range remaining {
+			start, end := window(content, anchor.start, width)
+			if score := distinctTerms(hits, start, end); score > bestScore {
+				best, bestScore = [2]int{start, end}, score
+			}
+		}
+		windows = append(windows, best)
+		remaining = slices.DeleteFunc(remaining, func(w word) bool { return w.start < best[1] && w.end > best[0] })
+	}
+
+	slices.SortFunc(windows, func(a, b [2]int) int { return a[0] - b[0] })
+	var excerpts []models.Excerpt
+	for _, w := range windows {
+		if n := len(excerpts); n > 0 && w[0] <= excerpts[n-1].End {
+			// Overlapping windows are merged into one excerpt
+			last := excerpts[n-1]
+			excerpts[n-1] = build(content, last.Start, max(last.End, w[1])-last.Start, hits)
+			continue
+		}
+		excerpts = append(excerpts, build(content, w[0], w[1]-w[0], hits))
+	}
+	return excerpts
+}
+
+// window returns the bounds of a passage of about width bytes around the
+// hit at offset, starting a third of the width before it, snapped to word
+// boundaries
+func window(content string, offset, width int) (int, int) {
+	start := max(offset-width/3, 0)
+	end := min(start+width, len(content))
+	start = max(end-width, 0)
+	return snapStart(content, start, offset), snapEnd(content, end)
+}
+
+// build returns the excerpt of content starting at start, width bytes long
+// and snapped to word boundaries, highlighting the hits inside it
+func build(content string, start, width int, hits []word) models.Excerpt {
+	end := snapEnd(content, min(start+width, len(content)))
+	text := content[start:end]
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	start += len(text) - len(trimmed)
+	text = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	end = start + len(text)
+
+	excerpt := models.Excerpt{Text: text, Start: start, End: end}
+	for _, hit := range hits {
+		if hit.start >= start && hit.end <= end {
+			excerpt.Highlights = append(excerpt.Highlights, models.Span{Start: hit.start - start, End: hit.end - start})
+		}
+	}
+	return excerpt
+}
+
+// snapStart moves start forward to the beginning of a word, but not past limit
+func snapStart(content string, start, limit int) int {
+	if start == 0 {
+		return 0
+	}
+	for i := start; i < limit; i++ {
+		if isSeparatorByte(content[i-1]) && !isSeparatorByte(content[i]) {
+			return i
+		}
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	return start
+}
+
+// snapEnd moves end back to the end of a word unless that leaves no text
+func snapEnd(content string, end int) int {
+	if end >= len(content) {
+		return len(content)
+	}
+	for i := end; i > 0; i-- {
+		if isSeparatorByte(content[i]) && !isSeparatorByte(content[i-1]) {
+			return i
+		}
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+	return end
+}
+
+// distinctTerms counts the different terms hit between start and end
+func distinctTerms(hits []word, start, end int) int {
+	var seen []int
+	for _, hit := range hits {
+		if hit.start >= start && hit.end <= end && !slices.Contains(seen, hit.term) {
+			seen = append(seen, hit.term)
+		}
+	}
+	return len(seen)
+}
+
+// words returns the letter and digit runs of content
+func words(content string) []word {
+	var out []word
+	start := -1
+	for i, r := range content {
+		if isSeparator(r) {
+			if start >= 0 {
+				out = append(out, word{start: start, end: i, term: -1})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		out = append(out, word{start: start, end: len(content), term: -1})
+	}
+	return out
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// isSeparatorByte reports whether b is an ASCII byte separating words;
+// bytes of multi-byte runes never are
+func isSeparatorByte(b byte) bool {
+	return b < utf8.RuneSelf && isSeparator(rune(b))
+}
+
+func isNumber(word string) bool {
+	return strings.IndexFunc(word, func(r rune) bool { return !unicode.IsDigit(r) }) < 0
+}
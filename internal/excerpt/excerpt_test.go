@@ -0,0 +1,56 @@
+package excerpt
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	got := Terms("What was John Doe's refund in 2023, and the refund in 22?")
+	want := []string{"john", "doe", "refund", "2023", "22"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestFindHighlightsBestPassages(t *testing.T) {
+	filler := strings.Repeat("Unrelated text about something else entirely. ", 10)
+	content := filler + "John Doe received a refund of $1,200 for 2023. " + filler + "The refund was paid late. " + filler
+	excerpts := Find(content, Terms("John Doe's refund in 2023"), 2, 120)
+	if len(excerpts) != 2 {
+		t.Fatalf("Expected 2 excerpts, got %+v", excerpts)
+	}
+	first := excerpts[0]
+	if content[first.Start:first.End] != first.Text {
+		t.Errorf("Expected offsets %d:%d to locate %q", first.Start, first.End, first.Text)
+	}
+	if !strings.Contains(first.Text, "John Doe received a refund of $1,200 for 2023.") {
+		t.Errorf("Expected the passage matching every term first, got %q", first.Text)
+	}
+	var highlighted []string
+	for _, span := range first.Highlights {
+		highlighted = append(highlighted, first.Text[span.Start:span.End])
+	}
+	if !slices.Equal(highlighted, []string{"John", "Doe", "refund", "2023"}) {
+		t.Errorf("Expected the matched terms highlighted, got %v", highlighted)
+	}
+	if !strings.Contains(excerpts[1].Text, "The refund was paid late.") || excerpts[1].Start <= first.End {
+		t.Errorf("Expected the second passage after the first, got %+v", excerpts[1])
+	}
+	for _, e := range excerpts {
+		if strings.HasPrefix(e.Text, " ") || len(e.Text) > 120 {
+			t.Errorf("Expected trimmed passages of at most 120 bytes, got %q", e.Text)
+		}
+	}
+}
+
+func TestFindWithoutMatches(t *testing.T) {
+	excerpts := Find("Über die Steuererklärung des Jahres", []string{"refund"}, 2, 12)
+	if len(excerpts) != 1 || excerpts[0].Start != 0 || excerpts[0].Text != "Über die" || len(excerpts[0].Highlights) != 0 {
+		t.Errorf("Expected the beginning cut at a word boundary, got %+v", excerpts)
+	}
+	if excerpts := Find("  ", []string{"refund"}, 2, 12); excerpts != nil {
+		t.Errorf("Expected no excerpts of empty content, got %+v", excerpts)
+	}
+}
@@ -0,0 +1,79 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxDOCXDocumentSize bounds the decompressed size of word/document.xml
+const maxDOCXDocumentSize = 64 << 20
+
+// extractDOCX reads the text runs of word/document.xml, keeping paragraph,
+// tab, and line breaks
+func extractDOCX(data []byte) (*Result, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid DOCX archive: %v", ErrUnsupportedFormat, err)
+	}
+
+	var document *zip.File
+	for _, f := range archive.File {
+		if f.Name == "word/document.xml" {
+			document = f
+			break
+		}
+	}
+	if document == nil {
+		return nil, fmt.Errorf("%w: DOCX archive has no word/document.xml", ErrUnsupportedFormat)
+	}
+
+	rc, err := document.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+
+	decoder := xml.NewDecoder(io.LimitReader(rc, maxDOCXDocumentSize))
+	var b strings.Builder
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse DOCX document: %w", err)
+		}
+
+		// Element names are matched on the local part; the prefix is always w:
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				b.WriteString("\t")
+			case "br", "cr":
+				b.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				b.WriteString("\n\n")
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+
+	return &Result{Text: b.String()}, nil
+}
@@ -0,0 +1,155 @@
+// Package extract converts uploaded files into plain text for ingestion. It
+// supports PDF, DOCX, HTML, Markdown, and plain text.
+package extract
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported MIME types
+const (
+	TypePDF      = "application/pdf"
+	TypeDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	TypeHTML     = "text/html"
+	TypeMarkdown = "text/markdown"
+	TypeText     = "text/plain"
+)
+
+// ErrUnsupportedFormat is returned for files that cannot be extracted
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+
+// ErrNoText is returned when a supported file contains no extractable text,
+// e.g. a scanned PDF without a text layer
+var ErrNoText = errors.New("no extractable text")
+
+// Result is the text extracted from a file
+type Result struct {
+	// Title found in the file (HTML <title>, first Markdown heading), if any
+	Title string
+	Text  string
+	// ContentType is the detected MIME type without parameters
+	ContentType string
+}
+
+// extensions maps file extensions to the MIME types they imply
+var extensions = map[string]string{
+	".pdf":      TypePDF,
+	".docx":     TypeDOCX,
+	".html":     TypeHTML,
+	".htm":      TypeHTML,
+	".md":       TypeMarkdown,
+	".markdown": TypeMarkdown,
+	".txt":      TypeText,
+}
+
+// DetectType returns the MIME type of a file from its declared content type,
+// its extension, and finally its content. Generic declared types such as
+// application/octet-stream are ignored.
+func DetectType(filename, contentType string, data []byte) string {
+	ext := extensions[strings.ToLower(filepath.Ext(filename))]
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if mediaType == "text/x-markdown" {
+			mediaType = TypeMarkdown
+		}
+		switch mediaType {
+		case TypeText:
+			// Browsers send text/plain for Markdown files
+			if ext == TypeMarkdown {
+				return TypeMarkdown
+			}
+			return TypeText
+		case TypePDF, TypeDOCX, TypeHTML, TypeMarkdown:
+			return mediaType
+		}
+	}
+	if ext != "" {
+		return ext
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return mediaType
+}
+
+// Extract returns the text of a file. The type is detected with DetectType.
+func Extract(filename, contentType string, data []byte) (*Result, error) {
+	detected := DetectType(filename, contentType, data)
+
+	var result *Result
+	var err error
+	switch detected {
+	case TypePDF:
+		result, err = extractPDF(data)
+	case TypeDOCX:
+		result, err = extractDOCX(data)
+	case TypeHTML:
+		result, err = extractHTML(data)
+	case TypeMarkdown:
+		result, err = extractText(data, true)
+	case TypeText:
+		result, err = extractText(data, false)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, detected)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.ContentType = detected
+	result.Text = normalizeSpace(result.Text)
+	if result.Text == "" {
+		return nil, ErrNoText
+	}
+	return result, nil
+}
+
+// extractText handles plain text and Markdown, which is kept as-is since its
+// markup reads naturally to both the embedding model and the LLM
+func extractText(data []byte, markdown bool) (*Result, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("%w: text is not valid UTF-8", ErrUnsupportedFormat)
+	}
+	text := strings.TrimPrefix(string(data), "\ufeff")
+
+	result := &Result{Text: text}
+	if markdown {
+		for _, line := range strings.Split(text, "\n") {
+			if title, ok := strings.CutPrefix(strings.TrimSpace(line), "# "); ok {
+				result.Title = strings.TrimSpace(title)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// normalizeSpace trims trailing whitespace from lines and collapses runs of
+// blank lines into a single paragraph break
+func normalizeSpace(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+
+	var b strings.Builder
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r\u00a0")
+		if strings.TrimSpace(line) == "" {
+			blank++
+			continue
+		}
+		if b.Len() > 0 {
+			if blank > 0 {
+				b.WriteString("\n\n")
+			} else {
+				b.WriteString("\n")
+			}
+		}
+		blank = 0
+		b.WriteString(line)
+	}
+	return b.String()
+}
@@ -0,0 +1,129 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF returns a minimal PDF with one content stream, optionally Flate-compressed
+func buildPDF(content string, compress bool) []byte {
+	stream, filter := []byte(content), ""
+	if compress {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		_, _ = w.Write(stream)
+		_ = w.Close()
+		stream, filter = buf.Bytes(), " /Filter /FlateDecode"
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n1 0 obj\n<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d%s >>\nstream\n", len(stream), filter)
+	pdf.Write(stream)
+	pdf.WriteString("\nendstream\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+// buildDOCX returns a minimal DOCX archive with the given document.xml body
+func buildDOCX(t *testing.T, body string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	w, err := archive.Create("word/document.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` + body + `</w:body></w:document>`))
+	_ = archive.Close()
+	return buf.Bytes()
+}
+
+func TestExtractPDF(t *testing.T) {
+	content := `BT /F1 12 Tf 72 712 Td (Gross receipts \(2023\)) Tj 0 -14 Td [(Tot) -20 (al:) -300 (1,000)] TJ ET`
+	for _, compress := range []bool{false, true} {
+		result, err := Extract("report.pdf", "application/pdf", buildPDF(content, compress))
+		if err != nil {
+			t.Fatalf("Extract failed (compressed=%v): %v", compress, err)
+		}
+		if want := "Gross receipts (2023)\nTotal: 1,000"; result.Text != want {
+			t.Errorf("Expected %q (compressed=%v), got %q", want, compress, result.Text)
+		}
+	}
+}
+
+func TestExtractPDFWithoutText(t *testing.T) {
+	if _, err := Extract("scan.pdf", "", buildPDF("q 100 0 0 100 0 0 cm /Im1 Do Q", false)); !errors.Is(err, ErrNoText) {
+		t.Errorf("Expected ErrNoText, got %v", err)
+	}
+}
+
+func TestExtractDOCX(t *testing.T) {
+	data := buildDOCX(t, `<w:p><w:r><w:t>First</w:t></w:r><w:r><w:tab/><w:t xml:space="preserve">paragraph</w:t></w:r></w:p><w:p><w:r><w:t>Second</w:t></w:r></w:p>`)
+
+	result, err := Extract("memo.docx", "application/octet-stream", data)
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if result.ContentType != TypeDOCX {
+		t.Errorf("Expected DOCX content type, got %s", result.ContentType)
+	}
+	if want := "First\tparagraph\n\nSecond"; result.Text != want {
+		t.Errorf("Expected %q, got %q", want, result.Text)
+	}
+}
+
+func TestExtractHTML(t *testing.T) {
+	page := `<html><head><title>Tax Guide</title><style>p { color: red }</style></head>
+<body><h1>Filing</h1><p>Married <b>filing</b> jointly.</p><script>track()</script><ul><li>One</li><li>Two</li></ul></body></html>`
+
+	result, err := Extract("guide.html", "text/html; charset=utf-8", []byte(page))
+	if err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+	if result.Title != "Tax Guide" {
+		t.Errorf("Expected title from <title>, got %q", result.Title)
+	}
+	for _, want := range []string{"Filing", "Married filing jointly.", "One", "Two"} {
+		if !strings.Contains(result.Text, want) {
+			t.Errorf("Expected %q in %q", want, result.Text)
+		}
+	}
+	for _, unwanted := range []string{"color", "track", "Tax Guide"} {
+		if strings.Contains(result.Text, unwanted) {
+			t.Errorf("Expected %q to be skipped in %q", unwanted, result.Text)
+		}
+	}
+}
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		filename, contentType string
+		data                  string
+		want                  string
+	}{
+		{"notes.md", "text/plain", "# Notes", TypeMarkdown},
+		{"notes.txt", "", "plain", TypeText},
+		{"upload", "application/octet-stream", "%PDF-1.4", TypePDF},
+		{"page", "", "<!DOCTYPE html><html></html>", TypeHTML},
+		{"image.png", "image/png", "\x89PNG\r\n\x1a\n", "image/png"},
+	}
+	for _, tt := range tests {
+		if got := DetectType(tt.filename, tt.contentType, []byte(tt.data)); got != tt.want {
+			t.Errorf("DetectType(%q, %q) = %q, want %q", tt.filename, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestExtractUnsupported(t *testing.T) {
+	if _, err := Extract("image.png", "image/png", []byte("\x89PNG\r\n\x1a\n")); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+	if _, err := Extract("bad.txt", "text/plain", []byte{0xff, 0xfe, 0x00}); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat for invalid UTF-8, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package extract
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// skippedElements hold no readable body text; the title is extracted separately
+var skippedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Title:    true,
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+// blockElements start a new line in the extracted text
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Li: true, atom.Tr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Section: true, atom.Article: true, atom.Header: true, atom.Footer: true,
+	atom.Blockquote: true, atom.Pre: true, atom.Table: true, atom.Ul: true, atom.Ol: true,
+}
+
+// extractHTML returns the visible text of an HTML document and its <title>
+func extractHTML(data []byte) (*Result, error) {
+	root, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	result := &Result{}
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.ElementNode:
+			if n.DataAtom == atom.Title && result.Title == "" && n.FirstChild != nil {
+				result.Title = strings.TrimSpace(n.FirstChild.Data)
+			}
+			if skippedElements[n.DataAtom] {
+				return
+			}
+		case html.TextNode:
+			// Collapse whitespace like a browser; line ends are trimmed below
+			b.WriteString(whitespace.ReplaceAllString(n.Data, " "))
+		}
+
+		block := n.Type == html.ElementNode && blockElements[n.DataAtom]
+		if block {
+			b.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			b.WriteString("\n")
+		}
+	}
+	walk(root)
+
+	lines := strings.Split(b.String(), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	result.Text = strings.Join(lines, "\n")
+	return result, nil
+}
@@ -0,0 +1,280 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// maxPDFStreamSize bounds the decompressed size of a single PDF stream
+const maxPDFStreamSize = 64 << 20
+
+var (
+	streamStart = regexp.MustCompile(`stream\r?\n`)
+	// dictionaryFilter finds the filter of the stream dictionary preceding a stream
+	dictionaryFilter = regexp.MustCompile(`/Filter\s*\[?\s*/(\w+)`)
+)
+
+// extractPDF returns the text shown by the content streams of a PDF. It reads
+// uncompressed and Flate-compressed streams and decodes string operands as
+// single-byte text, which covers PDFs produced with standard fonts. Text in
+// CID-keyed fonts, encrypted files, and scanned pages is not recovered.
+func extractPDF(data []byte) (*Result, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil, fmt.Errorf("%w: missing PDF header", ErrUnsupportedFormat)
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return nil, fmt.Errorf("%w: encrypted PDF", ErrUnsupportedFormat)
+	}
+
+	var b strings.Builder
+	offset := 0
+	for {
+		loc := streamStart.FindIndex(data[offset:])
+		if loc == nil {
+			break
+		}
+		start := offset + loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+
+		// The stream dictionary ends right before the stream keyword
+		dictStart := bytes.LastIndex(data[offset:offset+loc[0]], []byte("obj"))
+		dict := data[offset+max(dictStart, 0) : offset+loc[0]]
+		content := data[start : start+end]
+		offset = start + end + len("endstream")
+
+		if bytes.Contains(dict, []byte("/Subtype/Image")) || bytes.Contains(dict, []byte("/Subtype /Image")) {
+			continue
+		}
+		if m := dictionaryFilter.FindSubmatch(dict); m != nil {
+			if string(m[1]) != "FlateDecode" {
+				continue
+			}
+			decoded, err := inflate(content)
+			if err != nil {
+				continue
+			}
+			content = decoded
+		}
+		if bytes.Contains(content, []byte("BT")) {
+			showText(&b, content)
+		}
+	}
+
+	return &Result{Text: b.String()}, nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	// Truncated streams are common; keep what was decoded
+	out, err := io.ReadAll(io.LimitReader(r, maxPDFStreamSize))
+	if len(out) > 0 {
+		return out, nil
+	}
+	return nil, err
+}
+
+// showText interprets the text operators of a content stream. Text-showing
+// operators (Tj, TJ, ', ") append their strings; line and positioning
+// operators (Td, TD, T*, Tm, ET) start a new line.
+func showText(b *strings.Builder, content []byte) {
+	var operands []string
+	var inArray bool
+	var array strings.Builder
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			s, next := literalString(content, i)
+			if inArray {
+				array.WriteString(s)
+			} else {
+				operands = append(operands, s)
+			}
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			s, next := hexString(content, i)
+			if inArray {
+				array.WriteString(s)
+			} else {
+				operands = append(operands, s)
+			}
+			i = next
+		case c == '[':
+			inArray = true
+			array.Reset()
+			i++
+		case c == ']':
+			inArray = false
+			operands = append(operands, array.String())
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case isPDFDelimiter(c) || isPDFSpace(c):
+			i++
+		default:
+			start := i
+			for i < len(content) && !isPDFSpace(content[i]) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			token := string(content[start:i])
+			if inArray {
+				// Large negative kerning in TJ arrays separates words
+				if strings.HasPrefix(token, "-") && len(token) > 3 {
+					array.WriteString(" ")
+				}
+				continue
+			}
+
+			switch token {
+			case "Tj", "TJ":
+				for _, s := range operands {
+					b.WriteString(s)
+				}
+			case "'", "\"":
+				newline(b)
+				if len(operands) > 0 {
+					b.WriteString(operands[len(operands)-1])
+				}
+			case "Td", "TD", "T*", "Tm", "ET":
+				newline(b)
+			}
+			if !isNumber(token) && !strings.HasPrefix(token, "/") {
+				operands = operands[:0]
+			}
+		}
+	}
+}
+
+// newline ends the current line unless the text already ends with one
+func newline(b *strings.Builder) {
+	if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+		b.WriteString("\n")
+	}
+}
+
+// literalString decodes the (...) string starting at content[i], returning it
+// and the index after its closing parenthesis
+func literalString(content []byte, i int) (string, int) {
+	var out []byte
+	depth := 0
+	for i++; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '\\':
+			i++
+			if i >= len(content) {
+				break
+			}
+			switch e := content[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v := 0
+					for n := 0; n < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; n++ {
+						v = v*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			if depth == 0 {
+				return latin1(out), i + 1
+			}
+			depth--
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return latin1(out), i
+}
+
+// hexString decodes the <...> string starting at content[i]
+func hexString(content []byte, i int) (string, int) {
+	var digits []byte
+	for i++; i < len(content) && content[i] != '>'; i++ {
+		if c := content[i]; (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for j := range out {
+		out[j] = unhex(digits[2*j])<<4 | unhex(digits[2*j+1])
+	}
+	return latin1(out), i + 1
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	default:
+		return c - '0'
+	}
+}
+
+// latin1 converts single-byte text to UTF-8, dropping control characters
+func latin1(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		if c < 0x20 && c != '\n' && c != '\t' {
+			continue
+		}
+		s.WriteRune(rune(c))
+	}
+	return s.String()
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return c == '(' || c == ')' || c == '<' || c == '>' || c == '[' || c == ']' || c == '{' || c == '}' || c == '%'
+}
+
+func isNumber(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, c := range token {
+		if (c < '0' || c > '9') && c != '.' && c != '-' && c != '+' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+// Package httpclient provides an HTTP client for calls to backing services with
+// per-attempt timeouts, retries with exponential backoff, and a circuit breaker.
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the service while the circuit
+// breaker is open after repeated failures
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Options configure a Client. Zero values disable the respective feature.
+type Options struct {
+	// Timeout bounds a single attempt including reading the response body
+	Timeout time.Duration
+	// MaxRetries is the number of additional attempts after a transient failure
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry; it doubles per retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// FailureThreshold is the number of consecutive failed calls that opens the breaker
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before letting a probe through
+	Cooldown time.Duration
+	// HTTPClient sends the requests; nil uses a client with Timeout
+	HTTPClient *http.Client
+}
+
+// Client sends requests with retries and a circuit breaker
+type Client struct {
+	http    *http.Client
+	opts    Options
+	breaker *Breaker
+}
+
+// New creates a Client
+func New(opts Options) *Client {
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: opts.Timeout}
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 100 * time.Millisecond
+	}
+	if opts.MaxDelay < opts.BaseDelay {
+		opts.MaxDelay = max(opts.BaseDelay, 2*time.Second)
+	}
+	return &Client{
+		http:    client,
+		opts:    opts,
+		breaker: NewBreaker(opts.FailureThreshold, opts.Cooldown),
+	}
+}
+
+// Do sends req, retrying network errors and 429/5xx responses. Requests with a
+// body are only retried if it can be replayed (req.GetBody is set, as it is for
+// bytes and strings readers). Responses are returned as-is after the last
+// attempt, so callers still check the status code.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	ctx := req.Context()
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		resp, err = c.http.Do(req)
+		if attempt >= c.opts.MaxRetries || !retryable(ctx, resp, err) || (req.Body != nil && req.GetBody == nil) {
+			break
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		if err = sleep(ctx, c.backoff(attempt)); err != nil {
+			resp = nil
+			break
+		}
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				resp, err = nil, bodyErr
+				break
+			}
+			req.Body = body
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		// The caller gave up; this says nothing about the service's health
+		c.breaker.Release()
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		c.breaker.Failure()
+	default:
+		c.breaker.Success()
+	}
+	return resp, err
+}
+
+// backoff returns the delay before retry number attempt+1 with equal jitter
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.opts.MaxDelay
+	if attempt < 30 {
+		delay = min(c.opts.BaseDelay<<attempt, c.opts.MaxDelay)
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryable reports whether an attempt failed transiently
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
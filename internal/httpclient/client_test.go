@@ -0,0 +1,136 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fastOptions() Options {
+	return Options{Timeout: time.Second, MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+}
+
+func TestClientRetriesTransientFailures(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, 4)
+		n, _ := r.Body.Read(body)
+		if string(body[:n]) != "ping" {
+			t.Errorf("Expected replayed body, got %q", body[:n])
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("ping"))
+	resp, err := New(fastOptions()).Do(req)
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("Expected success on the third attempt, got status %d after %d calls", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	resp, err := New(fastOptions()).Do(req)
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected a single attempt, got %d", calls.Load())
+	}
+}
+
+func TestClientTimesOutAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	opts := fastOptions()
+	opts.Timeout = 20 * time.Millisecond
+	opts.MaxRetries = 0
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	start := time.Now()
+	if _, err := New(opts).Do(req); err == nil {
+		t.Fatal("Expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the attempt to time out quickly, took %v", elapsed)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	opts := fastOptions()
+	opts.MaxRetries = 0
+	opts.FailureThreshold = 2
+	opts.Cooldown = time.Minute
+	client := New(opts)
+	now := time.Now()
+	client.breaker.now = func() time.Time { return now }
+
+	do := func() error {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	for range 2 {
+		if err := do(); err != nil {
+			t.Fatalf("Expected the failing response to be returned, got %v", err)
+		}
+	}
+	if err := do(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected the open breaker to skip the service, got %d calls", calls.Load())
+	}
+
+	// After the cooldown a successful probe closes the breaker
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	for range 2 {
+		if err := do(); err != nil {
+			t.Fatalf("Expected the breaker to close, got %v", err)
+		}
+	}
+}
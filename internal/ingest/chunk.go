@@ -0,0 +1,95 @@
+// Package ingest turns extracted text into stored, embedded documents.
+package ingest
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// separators are the preferred chunk boundaries, strongest first
+var separators = []string{"\n\n", "\n", ". ", " "}
+
+// Chunk is a piece of a text with its byte offsets in the text
+type Chunk struct {
+	Text       string
+	Start, End int // text[Start:End] == Text
+}
+
+// Split divides text into chunks of at most size bytes. Chunks end at the
+// strongest boundary in their second half (paragraph, line, sentence, word) and
+// the next chunk repeats about overlap bytes of the previous one so passages
+// cut at a boundary keep their context. A size of zero or less returns text as
+// a single chunk.
+func Split(text string, size, overlap int) []string {
+	chunks := SplitChunks(text, size, overlap)
+	if chunks == nil {
+		return nil
+	}
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
+	return texts
+}
+
+// SplitChunks divides text like Split and returns the chunks with their
+// offsets in text
+func SplitChunks(text string, size, overlap int) []Chunk {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if size <= 0 || len(strings.TrimSpace(text)) <= size {
+		return []Chunk{trimmed(text, 0, len(text))}
+	}
+	overlap = min(max(overlap, 0), size/2)
+	var chunks []Chunk
+	start := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+	textEnd := len(strings.TrimRightFunc(text, unicode.IsSpace))
+	for start < textEnd {
+		end := start + size
+		if end >= textEnd {
+			chunks = append(chunks, trimmed(text, start, textEnd))
+			break
+		}
+		end = boundary(text, start+size/2, end)
+		chunks = append(chunks, trimmed(text, start, end))
+		// Start the next chunk at a word boundary within the overlap
+		next := end - overlap
+		if i := strings.IndexAny(text[next:end], " \n"); overlap > 0 && i >= 0 {
+			next += i + 1
+		} else {
+			next = end
+		}
+		start = max(next, start+1)
+		for start < textEnd && (text[start] == ' ' || text[start] == '\n') {
+			start++
+		}
+	}
+	return chunks
+}
+
+// trimmed returns text[start:end] without surrounding whitespace as a chunk
+func trimmed(text string, start, end int) Chunk {
+	piece := text[start:end]
+	start += len(piece) - len(strings.TrimLeftFunc(piece, unicode.IsSpace))
+	end -= len(piece) - len(strings.TrimRightFunc(piece, unicode.IsSpace))
+	if end < start {
+		end = start
+	}
+	return Chunk{Text: text[start:end], Start: start, End: end}
+}
+
+// boundary returns the end of the strongest separator in text[from:to], or a
+// rune boundary at to if there is none
+func boundary(text string, from, to int) int {
+	for _, sep := range separators {
+		if i := strings.LastIndex(text[from:to], sep); i >= 0 {
+			return from + i + len(sep)
+		}
+	}
+	for to > from && !utf8.RuneStart(text[to]) {
+		to--
+	}
+	return to
+}
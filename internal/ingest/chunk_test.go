@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitShortText(t *testing.T) {
+	if chunks := Split("  short text \n", 100, 10); len(chunks) != 1 || chunks[0] != "short text" {
+		t.Errorf("Expected a single trimmed chunk, got %q", chunks)
+	}
+	if chunks := Split(" \n ", 100, 10); len(chunks) != 0 {
+		t.Errorf("Expected no chunks for blank text, got %q", chunks)
+	}
+}
+
+func TestSplitPrefersParagraphs(t *testing.T) {
+	first := strings.Repeat("a", 60)
+	second := strings.Repeat("b", 60)
+	chunks := Split(first+"\n\n"+second, 100, 0)
+
+	if len(chunks) != 2 || chunks[0] != first || chunks[1] != second {
+		t.Errorf("Expected a split at the paragraph break, got %q", chunks)
+	}
+}
+
+func TestSplitSizeAndOverlap(t *testing.T) {
+	words := make([]string, 200)
+	for i := range words {
+		words[i] = "word"
+	}
+	text := strings.Join(words, " ")
+
+	chunks := Split(text, 100, 20)
+	if len(chunks) < 10 {
+		t.Fatalf("Expected at least 10 chunks, got %d", len(chunks))
+	}
+
+	total := 0
+	for _, chunk := range chunks {
+		if len(chunk) > 100 {
+			t.Errorf("Chunk exceeds size: %d bytes", len(chunk))
+		}
+		if strings.HasPrefix(chunk, "ord") || strings.HasSuffix(chunk, "wor") {
+			t.Errorf("Expected chunks to end at word boundaries, got %q", chunk)
+		}
+		total += len(chunk)
+	}
+	if total <= len(text) {
+		t.Errorf("Expected overlapping chunks to exceed the text length %d, got %d", len(text), total)
+	}
+}
+
+func TestSplitChunksOffsets(t *testing.T) {
+	text := "  " + strings.Repeat("alpha beta gamma. ", 20) + "\n"
+	chunks := SplitChunks(text, 100, 20)
+	if len(chunks) < 3 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+	for i, chunk := range chunks {
+		if text[chunk.Start:chunk.End] != chunk.Text {
+			t.Errorf("Chunk %d: offsets %d:%d do not locate %q", i, chunk.Start, chunk.End, chunk.Text)
+		}
+		if i > 0 && chunk.Start >= chunks[i-1].End {
+			t.Errorf("Chunk %d: expected to overlap the previous chunk", i)
+		}
+	}
+	if chunks[0].Start != 2 || chunks[len(chunks)-1].End != len(text)-2 {
+		t.Errorf("Expected offsets to skip surrounding whitespace, got %d and %d", chunks[0].Start, chunks[len(chunks)-1].End)
+	}
+	if got := Split(text, 100, 20); len(got) != len(chunks) || got[0] != chunks[0].Text {
+		t.Errorf("Expected Split to return the chunk texts, got %q", got)
+	}
+}
@@ -0,0 +1,200 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"rerag-rbac-rag-llm/internal/injection"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// Metadata keys set on every chunk document
+const (
+	MetadataSourceID   = "source_id"   // shared by all chunks of one source
+	MetadataChunkIndex = "chunk_index" // zero-based position of the chunk
+	MetadataChunkCount = "chunk_count"
+	// Byte offsets of the chunk in the source text
+	MetadataChunkStart = "chunk_start"
+	MetadataChunkEnd   = "chunk_end"
+)
+
+// Embedder generates vector embeddings for text
+type Embedder interface {
+	GetEmbedding(ctx context.Context, text string) ([]float32, error)
+}
+
+// Source is extracted text to ingest
+type Source struct {
+	Title    string
+	Text     string
+	Metadata map[string]interface{}
+}
+
+// Pipeline chunks, embeds, and stores sources
+type Pipeline struct {
+	embedder     Embedder
+	chunkSize    int
+	chunkOverlap int
+	sanitizer    *injection.Sanitizer // optional
+	// chunkVectors stores a source as one document with a vector per chunk
+	chunkVectors bool
+}
+
+// NewPipeline creates a pipeline splitting sources into chunks of chunkSize
+// bytes that overlap by chunkOverlap bytes
+func NewPipeline(embedder Embedder, chunkSize, chunkOverlap int) *Pipeline {
+	return &Pipeline{
+		embedder:     embedder,
+		chunkSize:    chunkSize,
+		chunkOverlap: chunkOverlap,
+	}
+}
+
+// SetSanitizer strips injection attempts from chunks and records their risk
+// score in the chunk metadata before they are embedded
+func (p *Pipeline) SetSanitizer(s *injection.Sanitizer) {
+	p.sanitizer = s
+}
+
+// SetChunkVectors makes Ingest store each source as a single document whose
+// chunks are embedded separately, instead of one document per chunk.
+// Searches then score the document by its chunks and answer from the best
+// matching one.
+func (p *Pipeline) SetChunkVectors(enabled bool) {
+	p.chunkVectors = enabled
+}
+
+// Split divides text into the chunks Ingest would store
+func (p *Pipeline) Split(text string) []string {
+	return Split(text, p.chunkSize, p.chunkOverlap)
+}
+
+// ChunkStart returns the byte offset of doc's content in the text of its
+// source, or 0 if doc was not ingested as a chunk
+func ChunkStart(doc *models.Document) int {
+	// Metadata read back from JSON holds numbers as float64
+	switch start := doc.Metadata[MetadataChunkStart].(type) {
+	case int:
+		return start
+	case float64:
+		return int(start)
+	}
+	return 0
+}
+
+// Ingest stores src as one document per chunk, or as a single document with
+// chunk vectors if SetChunkVectors is enabled. All chunks are embedded before
+// any is stored, so an embedding failure stores nothing. On a storage failure
+// the documents stored so far are returned with the error.
+func (p *Pipeline) Ingest(ctx context.Context, store storage.VectorStore, src Source) ([]models.Document, error) {
+	if p.chunkVectors {
+		return p.ingestChunkVectors(ctx, store, src)
+	}
+
+	chunks := SplitChunks(src.Text, p.chunkSize, p.chunkOverlap)
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("source %q has no text", src.Title)
+	}
+
+	sourceID := uuid.New()
+	docs := make([]models.Document, len(chunks))
+	for i, piece := range chunks {
+		chunk := piece.Text
+		metadata := maps.Clone(src.Metadata)
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+		}
+		if p.sanitizer != nil {
+			var risk float64
+			chunk, risk = p.sanitizer.Sanitize(chunk)
+			metadata[injection.MetadataRisk] = risk
+		}
+
+		embedding, err := p.embedder.GetEmbedding(ctx, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+
+		title := src.Title
+		if len(chunks) > 1 {
+			title = fmt.Sprintf("%s (part %d/%d)", src.Title, i+1, len(chunks))
+		}
+		metadata[MetadataSourceID] = sourceID.String()
+		metadata[MetadataChunkIndex] = i
+		metadata[MetadataChunkCount] = len(chunks)
+		metadata[MetadataChunkStart] = piece.Start
+		metadata[MetadataChunkEnd] = piece.End
+
+		docs[i] = models.Document{
+			ID:        uuid.New(),
+			Title:     title,
+			Content:   chunk,
+			Metadata:  metadata,
+			Embedding: embedding,
+		}
+	}
+
+	for i := range docs {
+		if err := store.UpsertDocument(&docs[i]); err != nil {
+			return docs[:i], fmt.Errorf("failed to store chunk %d of %d: %w", i+1, len(docs), err)
+		}
+	}
+	return docs, nil
+}
+
+// ingestChunkVectors stores src as a single document with a vector for each
+// chunk
+func (p *Pipeline) ingestChunkVectors(ctx context.Context, store storage.VectorStore, src Source) ([]models.Document, error) {
+	if len(SplitChunks(src.Text, p.chunkSize, p.chunkOverlap)) == 0 {
+		return nil, fmt.Errorf("source %q has no text", src.Title)
+	}
+	metadata := maps.Clone(src.Metadata)
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	doc := models.Document{ID: uuid.New(), Title: src.Title, Content: src.Text, Metadata: metadata}
+	// The whole text is sanitized first so that the chunk offsets hold
+	if p.sanitizer != nil {
+		var risk float64
+		doc.Content, risk = p.sanitizer.Sanitize(doc.Content)
+		metadata[injection.MetadataRisk] = risk
+	}
+	if err := p.EmbedChunks(ctx, &doc); err != nil {
+		return nil, err
+	}
+	metadata[MetadataSourceID] = doc.ID.String()
+	metadata[MetadataChunkCount] = len(doc.Chunks)
+
+	if err := store.UpsertDocument(&doc); err != nil {
+		return nil, fmt.Errorf("failed to store document: %w", err)
+	}
+	return []models.Document{doc}, nil
+}
+
+// EmbedChunks splits the content of doc into chunks, embeds each, and sets
+// them as doc's chunks. The document's own embedding is the mean of its chunk
+// embeddings.
+func (p *Pipeline) EmbedChunks(ctx context.Context, doc *models.Document) error {
+	pieces := SplitChunks(doc.Content, p.chunkSize, p.chunkOverlap)
+	doc.Embedding = nil
+	doc.Chunks = make([]models.DocumentChunk, len(pieces))
+	for i, piece := range pieces {
+		embedding, err := p.embedder.GetEmbedding(ctx, piece.Text)
+		if err != nil {
+			return fmt.Errorf("failed to embed chunk %d of %d: %w", i+1, len(pieces), err)
+		}
+		if i == 0 {
+			doc.Embedding = make([]float32, len(embedding))
+		} else if len(embedding) != len(doc.Embedding) {
+			return fmt.Errorf("chunk %d of %d has an embedding of %d dimensions, expected %d", i+1, len(pieces), len(embedding), len(doc.Embedding))
+		}
+		for j, value := range embedding {
+			doc.Embedding[j] += value / float32(len(pieces))
+		}
+		doc.Chunks[i] = models.DocumentChunk{Start: piece.Start, End: piece.End, Embedding: embedding}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Package injection detects prompt injection attempts such as "ignore previous
+// instructions" in document content, scores how likely content is to be one,
+// and strips the offending sentences.
+package injection
+
+import (
+	"regexp"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"strings"
+)
+
+// MetadataRisk is the metadata key of a document's risk score in [0, 1]
+const MetadataRisk = "injection_risk"
+
+// removed replaces stripped sentences
+const removed = "[removed]"
+
+// pattern is a phrase common in injection attempts and how much it adds to the risk score
+type pattern struct {
+	re     *regexp.Regexp
+	weight float64
+}
+
+var patterns = []pattern{
+	{regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\s+(all\s+|any\s+|the\s+|your\s+)*(previous|prior|above|earlier|preceding|system|original)\s+(instructions?|prompts?|rules|directions|context)`), 0.6},
+	{regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|output)\s+(the\s+|your\s+)*(system\s+prompt|hidden\s+prompt|initial\s+instructions)`), 0.5},
+	{regexp.MustCompile(`(?i)\bnew\s+instructions\s*:`), 0.4},
+	{regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(a|an|in|the)\b`), 0.4},
+	{regexp.MustCompile(`(?i)\bdo\s+not\s+(tell|inform|warn)\s+the\s+user`), 0.4},
+	{regexp.MustCompile(`(?i)</?\s*(system|assistant|instructions?)\s*>|(?m)^\s*(system|assistant)\s*:`), 0.4},
+	{regexp.MustCompile(`(?i)\b(pretend|act)\s+(to\s+be|as\s+if|as)\s+`), 0.2},
+}
+
+// Sanitizer scores content and optionally strips injection attempts from it
+type Sanitizer struct {
+	threshold float64
+	strip     bool
+}
+
+// NewSanitizer returns a Sanitizer flagging content scoring at least
+// threshold. With strip, sentences containing injection phrases are replaced
+// with "[removed]" in flagged content.
+func NewSanitizer(threshold float64, strip bool) *Sanitizer {
+	return &Sanitizer{threshold: threshold, strip: strip}
+}
+
+// Score returns the risk score of text: the summed weights of the injection
+// phrases it contains, capped at 1
+func Score(text string) float64 {
+	score := 0.0
+	for _, p := range patterns {
+		score += p.weight * float64(len(p.re.FindAllStringIndex(text, -1)))
+	}
+	return min(score, 1)
+}
+
+// Sanitize returns text, with injection attempts stripped if configured, and its risk score
+func (s *Sanitizer) Sanitize(text string) (string, float64) {
+	score := Score(text)
+	if !s.strip || !s.Flagged(score) {
+		return text, score
+	}
+	return strip(text), score
+}
+
+// Flagged reports whether a risk score reaches the threshold
+func (s *Sanitizer) Flagged(score float64) bool {
+	return score >= s.threshold
+}
+
+// Document sanitizes doc's content and records the risk score in its metadata
+func (s *Sanitizer) Document(doc *models.Document) {
+	var score float64
+	doc.Content, score = s.Sanitize(doc.Content)
+	if doc.Metadata == nil {
+		doc.Metadata = make(map[string]interface{})
+	}
+	doc.Metadata[MetadataRisk] = score
+}
+
+// Risk returns the higher of the risk score recorded at ingestion and the
+// score of the document's current content, which covers documents ingested
+// before sanitization was enabled
+func Risk(doc *models.Document) float64 {
+	recorded, _ := doc.Metadata[MetadataRisk].(float64)
+	return max(recorded, Score(doc.Content))
+}
+
+// strip replaces every sentence containing an injection phrase with "[removed]"
+func strip(text string) string {
+	var spans [][2]int
+	for _, p := range patterns {
+		for _, loc := range p.re.FindAllStringIndex(text, -1) {
+			spans = append(spans, sentence(text, loc[0], loc[1]))
+		}
+	}
+	slices.SortFunc(spans, func(a, b [2]int) int { return a[0] - b[0] })
+
+	var out strings.Builder
+	last := 0
+	for _, span := range spans {
+		if span[0] < last {
+			// Overlaps the previous span, which already ends the removal
+			if span[1] > last {
+				last = span[1]
+			}
+			continue
+		}
+		out.WriteString(text[last:span[0]])
+		out.WriteString(removed)
+		last = span[1]
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+// sentence widens text[start:end] to the sentence or line around it
+func sentence(text string, start, end int) [2]int {
+	start = strings.LastIndexAny(text[:start], ".!?\n") + 1
+	for start < end && text[start] == ' ' {
+		start++
+	}
+	if i := strings.IndexAny(text[end:], ".!?\n"); i >= 0 {
+		end += i
+		if text[end] != '\n' {
+			end++
+		}
+	} else {
+		end = len(text)
+	}
+	return [2]int{start, end}
+}
@@ -0,0 +1,8 @@
+// Package integration holds end-to-end tests running the ingest, grant, and
+// query flow against a real Keto started with dockertest, a SQLite vector
+// store, and a fake Ollama. The tests only build with the integration tag:
+//
+//	go test -tags integration ./internal/integration/...
+//
+// They need a Docker daemon.
+package integration
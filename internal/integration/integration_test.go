@@ -0,0 +1,236 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/api"
+	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/embeddings"
+	apperrors "rerag-rbac-rag-llm/internal/errors"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/testing/ollamatest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+// ketoVersion matches keto/config.yml
+const ketoVersion = "v0.14.0"
+
+// ketoConfig serves both APIs on all interfaces of the container and keeps
+// the relation tuples in memory
+const ketoConfig = `version: ` + ketoVersion + `
+dsn: memory
+serve:
+  read:
+    host: 0.0.0.0
+    port: 4466
+  write:
+    host: 0.0.0.0
+    port: 4467
+namespaces:
+  - name: documents
+    id: 0
+  - name: groups
+    id: 1
+`
+
+// startKeto runs Keto in Docker until the test ends and returns a permission
+// service talking to it once it passes the self-check
+func startKeto(t *testing.T) *permissions.KetoPermissionService {
+	t.Helper()
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("Failed to connect to Docker: %v", err)
+	}
+	if err := pool.Client.Ping(); err != nil {
+		t.Fatalf("Failed to connect to Docker: %v", err)
+	}
+	pool.MaxWait = 2 * time.Minute
+
+	// The container runs as an unprivileged user, so the mounted config must
+	// be world-readable
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "keto.yml"), []byte(ketoConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository:   "oryd/keto",
+		Tag:          ketoVersion,
+		Cmd:          []string{"serve", "--config", "/etc/keto/keto.yml"},
+		Mounts:       []string{dir + ":/etc/keto"},
+		ExposedPorts: []string{"4466/tcp", "4467/tcp"},
+	}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		t.Fatalf("Failed to start Keto: %v", err)
+	}
+	t.Cleanup(func() { _ = pool.Purge(resource) })
+
+	keto := permissions.NewKetoPermissionService(
+		"http://"+resource.GetHostPort("4466/tcp"),
+		"http://"+resource.GetHostPort("4467/tcp"),
+		httpclient.New(httpclient.Options{Timeout: 5 * time.Second}),
+		permissions.FailClosed,
+	)
+	if err := pool.Retry(func() error { return keto.SelfCheck(context.Background()) }); err != nil {
+		t.Fatalf("Keto did not become ready: %v", err)
+	}
+	return keto
+}
+
+// testEnv is a server over Keto, a SQLite vector store, and the fake Ollama
+type testEnv struct {
+	t      *testing.T
+	server *httptest.Server
+	keto   *permissions.KetoPermissionService
+	ollama *ollamatest.Server
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	keto := startKeto(t)
+
+	ollama := ollamatest.NewServer()
+	t.Cleanup(ollama.Close)
+
+	store, err := storage.NewSQLiteVectorStore(filepath.Join(t.TempDir(), "rag.db"))
+	if err != nil {
+		t.Fatalf("Failed to open vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	embedder := embeddings.NewEmbedder(embeddings.WithURL(ollama.URL))
+	llmClient := llm.NewOllamaClient(ollama.URL, "llama3.2:1b", nil, llm.Budget{}, nil)
+	server := api.NewServer(embedder, store, llmClient, keto, apperrors.NewErrorHandler(&config.Config{}))
+	env := &testEnv{t: t, server: httptest.NewServer(server.Handler()), keto: keto, ollama: ollama}
+	t.Cleanup(env.server.Close)
+	return env
+}
+
+// do sends a request as user and decodes the JSON response into out if set
+func (e *testEnv) do(user, method, path string, body, out interface{}) int {
+	e.t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			e.t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, e.server.URL+path, &payload)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+user)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		e.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			e.t.Fatalf("Failed to decode response of %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// ingest adds a document as the admin and returns its ID
+func (e *testEnv) ingest(title, content string) string {
+	e.t.Helper()
+	var resp map[string]string
+	doc := models.Document{Title: title, Content: content}
+	if code := e.do(admin, http.MethodPost, "/documents", doc, &resp); code != http.StatusCreated {
+		e.t.Fatalf("Failed to add document %q: status %d", title, code)
+	}
+	return resp["id"]
+}
+
+// change grants or revokes a relation as the admin
+func (e *testEnv) change(method string, req models.PermissionChangeRequest) {
+	e.t.Helper()
+	if code := e.do(admin, method, "/permissions", req, nil); code >= 300 {
+		e.t.Fatalf("%s /permissions %+v failed: status %d", method, req, code)
+	}
+}
+
+// sources queries as user and returns the titles of the sources
+func (e *testEnv) sources(user, question string) []string {
+	e.t.Helper()
+	var resp models.QueryResponse
+	if code := e.do(user, http.MethodPost, "/query", models.QueryRequest{Question: question, TopK: 5}, &resp); code != http.StatusOK {
+		e.t.Fatalf("Query as %s failed: status %d", user, code)
+	}
+	titles := make([]string, len(resp.Sources))
+	for i, source := range resp.Sources {
+		titles[i] = source.Title
+	}
+	slices.Sort(titles)
+	return titles
+}
+
+const admin = "admin"
+
+// Subjects with characters that need escaping in Keto's query strings
+const (
+	alice = "alice+tax@example.com"
+	bob   = "bob#payroll&co"
+)
+
+func TestIngestGrantQuery(t *testing.T) {
+	env := newTestEnv(t)
+	if err := env.keto.Grant(context.Background(), permissions.Tuple{Subject: admin, Relation: permissions.RelationWrite}); err != nil {
+		t.Fatalf("Failed to grant write: %v", err)
+	}
+
+	taxID := env.ingest("Tax Return", "The tax return lists income and deductions for the tax year.")
+	salaryID := env.ingest("Salary Review", "The salary review lists salary increases for the payroll team.")
+
+	env.change(http.MethodPost, models.PermissionChangeRequest{User: alice, Relation: permissions.RelationViewer, DocumentID: taxID})
+	env.change(http.MethodPost, models.PermissionChangeRequest{Group: "payroll-team", Relation: permissions.RelationViewer, DocumentID: salaryID})
+	if code := env.do(admin, http.MethodPut, "/groups/payroll-team/members/"+url.PathEscape(bob), nil, nil); code >= 300 {
+		t.Fatalf("Failed to add group member: status %d", code)
+	}
+
+	if got := env.sources(alice, "What does the tax return list?"); !slices.Equal(got, []string{"Tax Return"}) {
+		t.Errorf("Expected %s to see only the tax return, got %v", alice, got)
+	}
+	prompts := env.ollama.Prompts()
+	if prompt := prompts[len(prompts)-1]; !strings.Contains(prompt, "deductions") || strings.Contains(prompt, "payroll") {
+		t.Errorf("Expected the prompt to contain only the tax return, got %q", prompt)
+	}
+	if got := env.sources(bob, "What does the salary review list?"); !slices.Equal(got, []string{"Salary Review"}) {
+		t.Errorf("Expected %s to see the salary review through the group, got %v", bob, got)
+	}
+	if got := env.sources("mallory", "What does the tax return list?"); len(got) != 0 {
+		t.Errorf("Expected mallory to see no sources, got %v", got)
+	}
+
+	env.change(http.MethodDelete, models.PermissionChangeRequest{User: alice, Relation: permissions.RelationViewer, DocumentID: taxID})
+	if got := env.sources(alice, "What does the tax return list?"); len(got) != 0 {
+		t.Errorf("Expected %s to see no sources after the revocation, got %v", alice, got)
+	}
+}
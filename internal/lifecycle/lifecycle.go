@@ -0,0 +1,162 @@
+// Package lifecycle starts, health checks, and stops the dependencies of the
+// server in a defined order.
+//
+// Components are started in registration order and stopped in reverse, so a
+// component may rely on everything registered before it for its whole
+// lifetime. Register the document store first and the components using it
+// after.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Component is a dependency managed by a Manager. Every hook is optional.
+type Component struct {
+	Name string
+	// Start prepares the component; it must not block beyond ctx
+	Start func(ctx context.Context) error
+	// Stop releases the component's resources, waiting at most until ctx is done
+	Stop func(ctx context.Context) error
+	// HealthCheck reports whether the component can serve requests
+	HealthCheck func(ctx context.Context) error
+}
+
+// Manager coordinates the lifecycle of registered components
+type Manager struct {
+	mu         sync.Mutex
+	components []Component
+	stopped    bool
+}
+
+// New creates a manager without components
+func New() *Manager {
+	return &Manager{}
+}
+
+// Register adds c after the components registered so far
+func (m *Manager) Register(c Component) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.components = append(m.components, c)
+}
+
+// Background returns a component running run in a goroutine from Start until
+// Stop, which cancels run's context and waits for it to return
+func Background(name string, run func(ctx context.Context)) Component {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	return Component{
+		Name: name,
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				defer close(done)
+				run(ctx)
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			if cancel == nil {
+				return nil
+			}
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// Start starts the components in registration order. If one fails, the ones
+// already started are stopped again in reverse order and the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	components := m.snapshot()
+	for i, c := range components {
+		if c.Start == nil {
+			continue
+		}
+		if err := c.Start(ctx); err != nil {
+			if stopErr := stop(ctx, components[:i]); stopErr != nil {
+				log.Printf("Failed to stop components after a failed start: %v", stopErr)
+			}
+			m.mu.Lock()
+			m.stopped = true
+			m.mu.Unlock()
+			return fmt.Errorf("failed to start %s: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// Stop stops the components in reverse registration order. A failing
+// component does not keep the ones before it from stopping; all failures are
+// returned joined. Only the first call stops anything.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopped = true
+	components := m.components
+	m.mu.Unlock()
+
+	return stop(ctx, components)
+}
+
+// HealthCheck runs the health checks of all components concurrently and
+// returns the result of each component with a check by name; nil means healthy
+func (m *Manager) HealthCheck(ctx context.Context) map[string]error {
+	components := m.snapshot()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(components))
+	for _, c := range components {
+		if c.HealthCheck == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(c Component) {
+			defer wg.Done()
+			err := c.HealthCheck(ctx)
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[c.Name] = err
+		}(c)
+	}
+	wg.Wait()
+	return results
+}
+
+// snapshot returns the registered components
+func (m *Manager) snapshot() []Component {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.components
+}
+
+// stop stops components in reverse order and joins their failures
+func stop(ctx context.Context, components []Component) error {
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		if c.Stop == nil {
+			continue
+		}
+		if err := c.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop %s: %w", c.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,125 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recorder registers components logging their hooks into events
+type recorder struct {
+	events []string
+}
+
+func (r *recorder) component(name string, startErr, stopErr error) Component {
+	return Component{
+		Name: name,
+		Start: func(context.Context) error {
+			r.events = append(r.events, "start "+name)
+			return startErr
+		},
+		Stop: func(context.Context) error {
+			r.events = append(r.events, "stop "+name)
+			return stopErr
+		},
+	}
+}
+
+func TestManagerStartsInOrderAndStopsInReverse(t *testing.T) {
+	r := &recorder{}
+	m := New()
+	m.Register(r.component("database", nil, nil))
+	m.Register(Component{Name: "checks only", HealthCheck: func(context.Context) error { return nil }})
+	m.Register(r.component("webhooks", nil, errors.New("queue not drained")))
+	m.Register(r.component("retention", nil, nil))
+
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	err := m.Stop(context.Background())
+	if err == nil || err.Error() != "failed to stop webhooks: queue not drained" {
+		t.Errorf("Expected the webhooks failure, got %v", err)
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		t.Errorf("Expected a second Stop to do nothing, got %v", err)
+	}
+
+	want := []string{"start database", "start webhooks", "start retention", "stop retention", "stop webhooks", "stop database"}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("Expected %v, got %v", want, r.events)
+	}
+}
+
+func TestManagerStopsStartedComponentsWhenStartFails(t *testing.T) {
+	r := &recorder{}
+	m := New()
+	m.Register(r.component("database", nil, nil))
+	m.Register(r.component("keto", nil, nil))
+	m.Register(r.component("s3", errors.New("bucket missing"), nil))
+	m.Register(r.component("retention", nil, nil))
+
+	err := m.Start(context.Background())
+	if err == nil || err.Error() != "failed to start s3: bucket missing" {
+		t.Fatalf("Expected the s3 failure, got %v", err)
+	}
+	if err := m.Stop(context.Background()); err != nil {
+		t.Errorf("Expected Stop after a failed start to do nothing, got %v", err)
+	}
+
+	want := []string{"start database", "start keto", "start s3", "stop keto", "stop database"}
+	if !slices.Equal(r.events, want) {
+		t.Errorf("Expected %v, got %v", want, r.events)
+	}
+}
+
+func TestManagerHealthCheck(t *testing.T) {
+	m := New()
+	m.Register(Component{Name: "database", HealthCheck: func(context.Context) error { return nil }})
+	m.Register(Component{Name: "ollama", HealthCheck: func(context.Context) error { return errors.New("connection refused") }})
+	m.Register(Component{Name: "webhooks", Stop: func(context.Context) error { return nil }})
+
+	results := m.HealthCheck(context.Background())
+	if len(results) != 2 {
+		t.Fatalf("Expected results for the two checked components, got %v", results)
+	}
+	if err, ok := results["database"]; !ok || err != nil {
+		t.Errorf("Expected database to be healthy, got %v", err)
+	}
+	if err := results["ollama"]; err == nil {
+		t.Error("Expected ollama to be unhealthy")
+	}
+}
+
+func TestBackgroundComponent(t *testing.T) {
+	stopped := make(chan struct{})
+	c := Background("purge", func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := c.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("Expected Stop to wait for the goroutine to return")
+	}
+
+	// Stop gives up on a goroutine ignoring cancellation at the deadline
+	stuck := Background("stuck", func(context.Context) { select {} })
+	_ = stuck.Start(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := stuck.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+
+	if err := Background("never started", func(context.Context) {}).Stop(context.Background()); err != nil {
+		t.Errorf("Expected stopping an unstarted component to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"rerag-rbac-rag-llm/internal/models"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minDocumentTokens is the smallest remainder worth filling with a truncated document
+const minDocumentTokens = 64
+
+// Tokenizer counts the tokens a model would see for a text
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// WordPieceTokenizer approximates BPE tokenizers such as Llama's without their
+// vocabulary: every punctuation character is one token and words cost one
+// token per four characters
+type WordPieceTokenizer struct{}
+
+// CountTokens returns the approximate token count of text
+func (WordPieceTokenizer) CountTokens(text string) int {
+	tokens, wordLen := 0, 0
+	flush := func() {
+		tokens += (wordLen + 3) / 4
+		wordLen = 0
+	}
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			wordLen++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+// Budget limits how much retrieved context is placed in a prompt
+type Budget struct {
+	ContextTokens  int // model context window; 0 disables budgeting
+	ResponseTokens int // tokens reserved for the answer
+	Tokenizer      Tokenizer
+}
+
+// Fit selects the documents that fit into the context window next to the rest
+// of the prompt. Documents are considered in rank order; one that does not fit
+// is truncated if enough room is left, otherwise skipped in favour of smaller,
+// lower-ranked ones. render returns the full prompt text for a document set.
+// The returned slice reports which input documents were included.
+func (b Budget) Fit(documents []models.Document, render func([]models.Document) (string, error)) ([]models.Document, []bool, error) {
+	included := make([]bool, len(documents))
+	if b.ContextTokens <= 0 {
+		for i := range included {
+			included[i] = true
+		}
+		return documents, included, nil
+	}
+
+	tokenizer := b.Tokenizer
+	if tokenizer == nil {
+		tokenizer = WordPieceTokenizer{}
+	}
+	count := func(docs []models.Document) (int, error) {
+		text, err := render(docs)
+		if err != nil {
+			return 0, err
+		}
+		return tokenizer.CountTokens(text), nil
+	}
+
+	used, err := count(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	available := b.ContextTokens - b.ResponseTokens
+
+	var selected []models.Document
+	for i, doc := range documents {
+		candidate := append(selected[:len(selected):len(selected)], doc)
+		tokens, err := count(candidate)
+		if err != nil {
+			return nil, nil, err
+		}
+		if tokens <= available {
+			selected, used, included[i] = candidate, tokens, true
+			continue
+		}
+
+		room := available - used
+		if room < minDocumentTokens {
+			continue
+		}
+
+		// Shrink the content proportionally until the document fits
+		cost := tokens - used
+		for attempt := 0; attempt < 4 && tokens > available; attempt++ {
+			keep := len(doc.Content) * room / cost * 9 / 10
+			doc.Content = truncateUTF8(doc.Content, keep)
+			candidate[len(candidate)-1] = doc
+			if tokens, err = count(candidate); err != nil {
+				return nil, nil, err
+			}
+			cost = max(tokens-used, 1)
+		}
+		if tokens <= available {
+			selected, used, included[i] = candidate, tokens, true
+		}
+	}
+
+	return selected, included, nil
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"testing"
+)
+
+// renderContents joins a fixed question with the document contents
+func renderContents(docs []models.Document) (string, error) {
+	parts := []string{"question"}
+	for _, doc := range docs {
+		parts = append(parts, doc.Content)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func words(n int) string {
+	return strings.TrimSpace(strings.Repeat("word ", n))
+}
+
+func TestWordPieceTokenizer(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"word", 1},
+		{"hello world", 4},
+		{"a, b.", 4},
+		{"internationalization", 5},
+	}
+	for _, tt := range tests {
+		if got := (WordPieceTokenizer{}).CountTokens(tt.text); got != tt.want {
+			t.Errorf("CountTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestBudgetFit(t *testing.T) {
+	docs := []models.Document{
+		{Title: "small", Content: words(50)},
+		{Title: "large", Content: words(500)},
+		{Title: "tiny", Content: words(10)},
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		selected, included, err := Budget{}.Fit(docs, renderContents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(selected) != 3 || !included[0] || !included[1] || !included[2] {
+			t.Errorf("Expected all documents without a budget, got %v", included)
+		}
+	})
+
+	t.Run("truncates", func(t *testing.T) {
+		selected, included, err := Budget{ContextTokens: 300, ResponseTokens: 50}.Fit(docs, renderContents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !included[0] || !included[1] {
+			t.Fatalf("Expected the first two documents, got %v", included)
+		}
+		if len(selected[1].Content) >= len(docs[1].Content) {
+			t.Error("Expected the large document to be truncated")
+		}
+		text, _ := renderContents(selected)
+		if tokens := (WordPieceTokenizer{}).CountTokens(text); tokens > 250 {
+			t.Errorf("Expected at most 250 prompt tokens, got %d", tokens)
+		}
+	})
+
+	t.Run("skips", func(t *testing.T) {
+		selected, included, err := Budget{ContextTokens: 120, ResponseTokens: 50}.Fit(docs, renderContents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !included[0] || included[1] || !included[2] {
+			t.Errorf("Expected the large document to be skipped, got %v", included)
+		}
+		if len(selected) != 2 || selected[1].Title != "tiny" {
+			t.Errorf("Expected small and tiny documents, got %+v", selected)
+		}
+	})
+}
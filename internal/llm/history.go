@@ -0,0 +1,23 @@
+package llm
+
+import "rerag-rbac-rag-llm/internal/models"
+
+// EstimateTokens approximates the token count of text with WordPieceTokenizer
+func EstimateTokens(text string) int {
+	return WordPieceTokenizer{}.CountTokens(text)
+}
+
+// TrimHistory keeps the most recent messages whose combined estimated size fits
+// within maxTokens. Older turns are dropped first; order is preserved.
+func TrimHistory(history []models.Message, maxTokens int) []models.Message {
+	used := 0
+	start := len(history)
+	for i := len(history) - 1; i >= 0; i-- {
+		used += EstimateTokens(history[i].Content)
+		if used > maxTokens {
+			break
+		}
+		start = i
+	}
+	return history[start:]
+}
@@ -2,91 +2,229 @@
 package llm
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"rerag-rbac-rag-llm/internal/httpclient"
 	"rerag-rbac-rag-llm/internal/models"
-	"strings"
+	"rerag-rbac-rag-llm/internal/prompt"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"sync"
 )
 
 // OllamaClient provides interaction with Ollama LLM service
 type OllamaClient struct {
 	baseURL string
-	model   string
+	mu      sync.RWMutex
+	model   string // guarded by mu; changed by config reloads
+	prompts *prompt.Registry
+	budget  Budget
+	client  *httpclient.Client
 }
 
-// NewOllamaClient creates a new client for interacting with Ollama
-func NewOllamaClient(baseURL, model string) *OllamaClient {
+// Options customize a single generation
+type Options struct {
+	// History holds prior conversation turns to include in the prompt
+	History []models.Message
+	// Template selects a prompt template by name; empty uses the default
+	Template string
+	// Stream, if set, receives the answer in pieces as the model generates it.
+	// The result still carries the complete answer.
+	Stream func(delta string)
+}
+
+// Result is the outcome of a generation
+type Result struct {
+	Answer string
+	// Included reports, per input document, whether it fit into the prompt
+	Included []bool
+	// Tokens the model read from the prompt and generated, as reported by
+	// Ollama; 0 if unreported
+	PromptTokens   int
+	ResponseTokens int
+}
+
+// NewOllamaClient creates a new client for interacting with Ollama.
+// prompts renders the prompts; nil uses the built-in template. budget limits
+// the context documents to the model's context window. client applies timeouts,
+// retries, and the circuit breaker; nil sends single attempts without a timeout.
+func NewOllamaClient(baseURL, model string, prompts *prompt.Registry, budget Budget, client *httpclient.Client) *OllamaClient {
+	if prompts == nil {
+		// The embedded built-in template always parses
+		prompts, _ = prompt.NewRegistry("")
+	}
+	if client == nil {
+		client = httpclient.New(httpclient.Options{})
+	}
 	return &OllamaClient{
 		baseURL: baseURL,
 		model:   model,
+		prompts: prompts,
+		budget:  budget,
+		client:  client,
 	}
 }
 
 // Generate produces an answer based on the question and context documents
-func (o *OllamaClient) Generate(question string, context []models.Document) (string, error) {
-	prompt := o.buildPrompt(question, context)
+func (o *OllamaClient) Generate(ctx context.Context, question string, documents []models.Document) (string, error) {
+	result, err := o.GenerateWithOptions(ctx, question, documents, Options{})
+	if err != nil {
+		return "", err
+	}
+	return result.Answer, nil
+}
+
+// GenerateWithOptions produces an answer like Generate using the selected prompt
+// template and prior conversation turns. Documents that do not fit the context
+// budget are left out or truncated, as reported in the result.
+func (o *OllamaClient) GenerateWithOptions(ctx context.Context, question string, documents []models.Document, opts Options) (*Result, error) {
+	render := func(docs []models.Document) (string, string, error) {
+		return o.prompts.Render(opts.Template, prompt.Data{
+			Question:  question,
+			Documents: docs,
+			History:   opts.History,
+		})
+	}
+
+	fitted, included, err := o.budget.Fit(documents, func(docs []models.Document) (string, error) {
+		system, promptText, err := render(docs)
+		return system + promptText, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	system, promptText, err := render(fitted)
+	if err != nil {
+		return nil, err
+	}
 
 	reqBody := map[string]interface{}{
-		"model":  o.model,
-		"prompt": prompt,
-		"stream": false,
-		"options": map[string]interface{}{
-			"temperature": 0,
-		},
-		"system": "You are a helpful assistant that answers questions based on the provided documents. If the answer can not be found in the documents, assume the user is not authorized to view them.",
+		"model":   o.currentModel(),
+		"prompt":  promptText,
+		"stream":  opts.Stream != nil,
+		"options": o.options(),
+		"system":  system,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	resp, err := http.Post(o.baseURL+"/api/generate", "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/api/generate", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	requestid.SetHeader(ctx, req)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
 
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, body)
+	}
+	if opts.Stream != nil {
+		result, err := readStream(resp.Body, opts.Stream)
+		if err != nil {
+			return nil, err
+		}
+		result.Included = included
+		return result, nil
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	var result struct {
-		Response string `json:"response"`
+		Response        string `json:"response"`
+		PromptEvalCount int    `json:"prompt_eval_count"`
+		EvalCount       int    `json:"eval_count"`
 	}
 	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return result.Response, nil
+	return &Result{
+		Answer:         result.Response,
+		Included:       included,
+		PromptTokens:   result.PromptEvalCount,
+		ResponseTokens: result.EvalCount,
+	}, nil
 }
 
-func (o *OllamaClient) buildPrompt(question string, documents []models.Document) string {
-	var contextStr strings.Builder
-
-	contextStr.WriteString("You are a helpful assistant that answers questions based on the provided documents. If the answer can not be found in the documents, assume the user is not authorized to view them.\n\n")
-	contextStr.WriteString("Documents:\n")
-
-	for i, doc := range documents {
-		contextStr.WriteString(fmt.Sprintf("\nDocument %d: %s\n", i+1, doc.Title))
-		contextStr.WriteString(fmt.Sprintf("Content: %s\n", doc.Content))
-		contextStr.WriteString(fmt.Sprintf("ID: %s\n", doc.ID.String()))
-		if len(doc.Metadata) > 0 {
-			contextStr.WriteString("Metadata: ")
-			for k, v := range doc.Metadata {
-				contextStr.WriteString(fmt.Sprintf("%s: %v, ", k, v))
-			}
-			contextStr.WriteString("\n")
+// readStream reads a streamed generation, one JSON object per line, passing
+// each piece to emit and returning the complete answer with the token counts
+// of the final object
+func readStream(r io.Reader, emit func(string)) (*Result, error) {
+	var answer bytes.Buffer
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var chunk struct {
+			Response        string `json:"response"`
+			Done            bool   `json:"done"`
+			Error           string `json:"error"`
+			PromptEvalCount int    `json:"prompt_eval_count"`
+			EvalCount       int    `json:"eval_count"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode streamed response: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("ollama stream failed: %s", chunk.Error)
+		}
+		if chunk.Response != "" {
+			answer.WriteString(chunk.Response)
+			emit(chunk.Response)
+		}
+		if chunk.Done {
+			return &Result{Answer: answer.String(), PromptTokens: chunk.PromptEvalCount, ResponseTokens: chunk.EvalCount}, nil
 		}
-		contextStr.WriteString("---\n")
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("ollama stream ended before the answer was complete")
+}
+
+// SetModel switches the model of generations started afterwards
+func (o *OllamaClient) SetModel(model string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.model = model
+}
 
-	contextStr.WriteString(fmt.Sprintf("\nQuestion: %s\n", question))
-	contextStr.WriteString("\nPlease answer the question based ONLY on the information provided in the context documents above. If you can not answer based on the information the user is likely unauthorized to review the documents.\n\nAnswer: ")
+// Ping checks that Ollama is reachable by listing the locally available models
+func (o *OllamaClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseURL+"/api/tags", nil)
+	if err != nil {
+		return err
+	}
+	requestid.SetHeader(ctx, req)
 
-	return contextStr.String()
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama returned status %d", resp.StatusCode)
+	}
+	return nil
 }
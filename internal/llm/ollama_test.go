@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"context"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/testing/ollamatest"
+	"strings"
+	"testing"
+)
+
+func TestReadStream(t *testing.T) {
+	var deltas []string
+	result, err := readStream(strings.NewReader(`{"response":"John ","done":false}
+{"response":"received $1,200","done":false}
+
+{"response":"","done":true,"prompt_eval_count":412,"eval_count":7}
+`), func(delta string) { deltas = append(deltas, delta) })
+	if err != nil {
+		t.Fatalf("readStream failed: %v", err)
+	}
+	if result.Answer != "John received $1,200" || len(deltas) != 2 {
+		t.Errorf("Unexpected answer %q from deltas %q", result.Answer, deltas)
+	}
+	if result.PromptTokens != 412 || result.ResponseTokens != 7 {
+		t.Errorf("Expected the token counts of the final object, got %d and %d", result.PromptTokens, result.ResponseTokens)
+	}
+
+	if _, err := readStream(strings.NewReader(`{"response":"John ","done":false}`), func(string) {}); err == nil {
+		t.Error("Expected an error for a stream that ends before done")
+	}
+	if _, err := readStream(strings.NewReader(`{"error":"model not found"}`), func(string) {}); err == nil {
+		t.Error("Expected an error for a failed stream")
+	}
+}
+
+func TestGenerateWithOptionsStreams(t *testing.T) {
+	server := ollamatest.NewServer(ollamatest.WithAnswer(func(string) string { return "John received $1,200" }))
+	defer server.Close()
+
+	var deltas []string
+	result, err := NewOllamaClient(server.URL, "llama3.2:1b", nil, Budget{}, nil).GenerateWithOptions(context.Background(), "What did John receive?",
+		[]models.Document{{Title: "Refund", Content: "John received a refund of $1,200"}},
+		Options{Stream: func(delta string) { deltas = append(deltas, delta) }})
+	if err != nil {
+		t.Fatalf("GenerateWithOptions failed: %v", err)
+	}
+	if result.Answer != "John received $1,200" || len(deltas) != 3 || result.ResponseTokens != 3 {
+		t.Errorf("Unexpected answer %q from deltas %q with %d tokens", result.Answer, deltas, result.ResponseTokens)
+	}
+	if requests := server.Requests(); len(requests) != 1 || !requests[0].Stream || !strings.Contains(requests[0].Prompt, "refund of $1,200") {
+		t.Errorf("Expected one streamed request with the document, got %+v", requests)
+	}
+}
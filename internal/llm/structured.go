@@ -0,0 +1,257 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"slices"
+	"strings"
+)
+
+// ErrInvalidOutput is wrapped by errors of structured generations whose output
+// is not JSON matching the requested schema
+var ErrInvalidOutput = errors.New("model output does not match the schema")
+
+// Tool describes a function the model may call. Parameters is a JSON Schema
+// of the function's arguments object.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+// ToolCall is a function call chosen by the model
+type ToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// GenerateStructured answers promptText, which is sent as is without a prompt
+// template, with JSON matching schema. The output is coerced into JSON by
+// ExtractJSON and validated by ValidateJSON; a nil schema only requires JSON.
+func (o *OllamaClient) GenerateStructured(ctx context.Context, promptText string, schema json.RawMessage) (json.RawMessage, error) {
+	var format interface{} = "json"
+	if len(schema) > 0 {
+		format = schema
+	}
+	var result struct {
+		Response string `json:"response"`
+	}
+	if err := o.post(ctx, "/api/generate", map[string]interface{}{
+		"model":   o.currentModel(),
+		"prompt":  promptText,
+		"stream":  false,
+		"format":  format,
+		"options": o.options(),
+	}, &result); err != nil {
+		return nil, err
+	}
+
+	output, err := ExtractJSON(result.Response)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateJSON(schema, output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// CallTools asks the model which of tools to call to handle promptText and
+// returns the calls in the order the model made them, with their arguments
+// validated against the tool's parameters. A model that answers without
+// calling a tool returns no calls.
+func (o *OllamaClient) CallTools(ctx context.Context, promptText string, tools []Tool) ([]ToolCall, error) {
+	definitions := make([]map[string]interface{}, len(tools))
+	for i, tool := range tools {
+		definitions[i] = map[string]interface{}{"type": "function", "function": tool}
+	}
+	var result struct {
+		Message struct {
+			ToolCalls []struct {
+				Function ToolCall `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"message"`
+	}
+	if err := o.post(ctx, "/api/chat", map[string]interface{}{
+		"model":    o.currentModel(),
+		"messages": []map[string]string{{"role": "user", "content": promptText}},
+		"tools":    definitions,
+		"stream":   false,
+		"options":  o.options(),
+	}, &result); err != nil {
+		return nil, err
+	}
+
+	calls := make([]ToolCall, 0, len(result.Message.ToolCalls))
+	for _, c := range result.Message.ToolCalls {
+		i := slices.IndexFunc(tools, func(t Tool) bool { return t.Name == c.Function.Name })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: unknown tool %q", ErrInvalidOutput, c.Function.Name)
+		}
+		if err := ValidateJSON(tools[i].Parameters, c.Function.Arguments); err != nil {
+			return nil, fmt.Errorf("arguments of tool %q: %w", c.Function.Name, err)
+		}
+		calls = append(calls, c.Function)
+	}
+	return calls, nil
+}
+
+// currentModel returns the model generations use
+func (o *OllamaClient) currentModel() string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.model
+}
+
+// options returns the deterministic sampling options of all generations
+func (o *OllamaClient) options() map[string]interface{} {
+	options := map[string]interface{}{
+		"temperature": 0,
+	}
+	if o.budget.ContextTokens > 0 {
+		options["num_ctx"] = o.budget.ContextTokens
+	}
+	return options
+}
+
+// post sends body as JSON to the Ollama endpoint at path and decodes the
+// response into out
+func (o *OllamaClient) post(ctx context.Context, path string, body, out interface{}) error {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	requestid.SetHeader(ctx, req)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ExtractJSON returns the JSON object or array in a model's answer, ignoring
+// surrounding prose and Markdown code fences
+func ExtractJSON(answer string) (json.RawMessage, error) {
+	start := strings.IndexAny(answer, "{[")
+	if start < 0 {
+		return nil, fmt.Errorf("%w: no JSON in the answer", ErrInvalidOutput)
+	}
+	closing := "}"
+	if answer[start] == '[' {
+		closing = "]"
+	}
+	end := strings.LastIndex(answer, closing)
+	if end < start || !json.Valid([]byte(answer[start:end+1])) {
+		return nil, fmt.Errorf("%w: malformed JSON in the answer", ErrInvalidOutput)
+	}
+	return json.RawMessage(answer[start : end+1]), nil
+}
+
+// ValidateJSON checks data against schema. It supports the JSON Schema
+// keywords models are asked to follow: type, properties, required,
+// additionalProperties false, items, and enum. A nil schema accepts any JSON.
+func ValidateJSON(schema, data json.RawMessage) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOutput, err)
+	}
+	if len(schema) == 0 {
+		return nil
+	}
+	var s jsonSchema
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.validate("$", value); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOutput, err)
+	}
+	return nil
+}
+
+// jsonSchema is the subset of JSON Schema ValidateJSON understands
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+}
+
+// validate checks value at path, as decoded by encoding/json, against s
+func (s *jsonSchema) validate(path string, value interface{}) error {
+	if s.Type != "" && !hasType(value, s.Type) {
+		return fmt.Errorf("%s must be of type %s", path, s.Type)
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e interface{}) bool { return fmt.Sprint(e) == fmt.Sprint(value) }) {
+		return fmt.Errorf("%s must be one of %v", path, s.Enum)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		for name, field := range v {
+			property, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s is not allowed", path, name)
+				}
+				continue
+			}
+			if err := property.validate(path+"."+name, field); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hasType reports whether a decoded JSON value is of the JSON Schema type typ
+func hasType(value interface{}, typ string) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return typ == "object"
+	case []interface{}:
+		return typ == "array"
+	case string:
+		return typ == "string"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == float64(int64(v)))
+	case nil:
+		return typ == "null"
+	}
+	return false
+}
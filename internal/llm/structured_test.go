@@ -0,0 +1,150 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const refundSchema = `{
+	"type": "object",
+	"required": ["customer", "amount"],
+	"additionalProperties": false,
+	"properties": {
+		"customer": {"type": "string"},
+		"amount": {"type": "integer"},
+		"status": {"enum": ["approved", "denied"]},
+		"items": {"type": "array", "items": {"type": "string"}}
+	}
+}`
+
+func TestValidateJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"matching", `{"customer": "John", "amount": 1200, "status": "approved", "items": ["laptop"]}`, true},
+		{"missing required", `{"customer": "John"}`, false},
+		{"wrong type", `{"customer": "John", "amount": "1200"}`, false},
+		{"fractional integer", `{"customer": "John", "amount": 12.5}`, false},
+		{"not in enum", `{"customer": "John", "amount": 1, "status": "pending"}`, false},
+		{"wrong item type", `{"customer": "John", "amount": 1, "items": [1]}`, false},
+		{"additional property", `{"customer": "John", "amount": 1, "note": "x"}`, false},
+		{"not an object", `["John"]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateJSON(json.RawMessage(refundSchema), json.RawMessage(tt.data))
+			if tt.valid && err != nil {
+				t.Errorf("Expected %s to be valid, got %v", tt.data, err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidOutput) {
+				t.Errorf("Expected ErrInvalidOutput for %s, got %v", tt.data, err)
+			}
+		})
+	}
+
+	if err := ValidateJSON(nil, json.RawMessage(`[1, 2]`)); err != nil {
+		t.Errorf("Expected any JSON to be valid without a schema, got %v", err)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{`{"a": 1}`, `{"a": 1}`},
+		{"Here you go:\n```json\n{\"a\": {\"b\": 1}}\n```\nAnything else?", `{"a": {"b": 1}}`},
+		{`The list is ["x", "y"].`, `["x", "y"]`},
+	}
+	for _, tt := range tests {
+		got, err := ExtractJSON(tt.answer)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("ExtractJSON(%q) = %s, %v; want %s", tt.answer, got, err, tt.want)
+		}
+	}
+
+	for _, answer := range []string{"no JSON here", `{"a": 1`} {
+		if _, err := ExtractJSON(answer); !errors.Is(err, ErrInvalidOutput) {
+			t.Errorf("Expected ErrInvalidOutput for %q, got %v", answer, err)
+		}
+	}
+}
+
+// fakeOllama answers every request with response and records the last
+// request body
+func fakeOllama(t *testing.T, path, response string) (*OllamaClient, *map[string]interface{}) {
+	t.Helper()
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("Expected a request to %s, got %s", path, r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	return NewOllamaClient(server.URL, "llama3.2:1b", nil, Budget{}, nil), &received
+}
+
+func TestGenerateStructured(t *testing.T) {
+	client, received := fakeOllama(t, "/api/generate", `{"response": "Sure! {\"customer\": \"John\", \"amount\": 1200}"}`)
+	output, err := client.GenerateStructured(context.Background(), "Extract the refund", json.RawMessage(refundSchema))
+	if err != nil {
+		t.Fatalf("GenerateStructured failed: %v", err)
+	}
+	if string(output) != `{"customer": "John", "amount": 1200}` {
+		t.Errorf("Unexpected output %s", output)
+	}
+	if format, ok := (*received)["format"].(map[string]interface{}); !ok || format["type"] != "object" {
+		t.Errorf("Expected the schema to be sent as format, got %v", (*received)["format"])
+	}
+
+	client, _ = fakeOllama(t, "/api/generate", `{"response": "{\"customer\": \"John\"}"}`)
+	if _, err := client.GenerateStructured(context.Background(), "Extract the refund", json.RawMessage(refundSchema)); !errors.Is(err, ErrInvalidOutput) {
+		t.Errorf("Expected ErrInvalidOutput for output missing a field, got %v", err)
+	}
+}
+
+func TestCallTools(t *testing.T) {
+	tools := []Tool{{
+		Name:        "search_documents",
+		Description: "Search the documents",
+		Parameters:  json.RawMessage(`{"type": "object", "required": ["query"], "properties": {"query": {"type": "string"}}}`),
+	}}
+
+	client, received := fakeOllama(t, "/api/chat", `{"message": {"role": "assistant", "tool_calls": [
+		{"function": {"name": "search_documents", "arguments": {"query": "refunds"}}}
+	]}}`)
+	calls, err := client.CallTools(context.Background(), "Find refunds", tools)
+	if err != nil {
+		t.Fatalf("CallTools failed: %v", err)
+	}
+	if len(calls) != 1 || calls[0].Name != "search_documents" || string(calls[0].Arguments) != `{"query": "refunds"}` {
+		t.Errorf("Unexpected calls %+v", calls)
+	}
+	sent, _ := (*received)["tools"].([]interface{})
+	if len(sent) != 1 || sent[0].(map[string]interface{})["type"] != "function" {
+		t.Errorf("Expected the tools to be sent as functions, got %v", (*received)["tools"])
+	}
+
+	for name, response := range map[string]string{
+		"unknown tool":      `{"message": {"tool_calls": [{"function": {"name": "delete_everything", "arguments": {}}}]}}`,
+		"invalid arguments": `{"message": {"tool_calls": [{"function": {"name": "search_documents", "arguments": {"query": 1}}}]}}`,
+	} {
+		client, _ := fakeOllama(t, "/api/chat", response)
+		if _, err := client.CallTools(context.Background(), "Find refunds", tools); !errors.Is(err, ErrInvalidOutput) {
+			t.Errorf("%s: expected ErrInvalidOutput, got %v", name, err)
+		}
+	}
+
+	client, _ = fakeOllama(t, "/api/chat", `{"message": {"role": "assistant", "content": "No tool needed"}}`)
+	if calls, err := client.CallTools(context.Background(), "Hello", tools); err != nil || len(calls) != 0 {
+		t.Errorf("Expected no calls, got %+v (%v)", calls, err)
+	}
+}
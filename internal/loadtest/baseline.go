@@ -0,0 +1,77 @@
+package loadtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// minRegressionMs is the slowdown below which a percentile is never reported
+// as a regression, so sub-millisecond jitter of fast stages is ignored
+const minRegressionMs = 1
+
+// Regression is a percentile of a stage that got slower than its baseline
+type Regression struct {
+	Stage      string  `json:"stage"`
+	Percentile string  `json:"percentile"`
+	BaselineMs float64 `json:"baseline_ms"`
+	CurrentMs  float64 `json:"current_ms"`
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s %s %.1fms -> %.1fms", r.Stage, r.Percentile, r.BaselineMs, r.CurrentMs)
+}
+
+// Compare records in r the p50 and p95 latencies that exceed those of the
+// baseline by more than tolerance, a fraction such as 0.2 for 20%. Stages
+// missing from either report are not compared; p99 is too noisy to compare.
+func (r *Report) Compare(baseline *Report, tolerance float64) []Regression {
+	r.Regressions = nil
+	for _, current := range r.Stages {
+		base, ok := baseline.Stage(current.Stage)
+		if !ok || base.Count == 0 || current.Count == 0 {
+			continue
+		}
+		for _, p := range []struct {
+			name          string
+			base, current float64
+		}{
+			{"p50", base.P50, current.P50},
+			{"p95", base.P95, current.P95},
+		} {
+			if p.current > p.base*(1+tolerance) && p.current-p.base >= minRegressionMs {
+				r.Regressions = append(r.Regressions, Regression{
+					Stage:      current.Stage,
+					Percentile: p.name,
+					BaselineMs: p.base,
+					CurrentMs:  p.current,
+				})
+			}
+		}
+	}
+	return r.Regressions
+}
+
+// LoadBaseline reads a report saved with SaveBaseline
+func LoadBaseline(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// SaveBaseline writes the report to path as the baseline of later runs
+func SaveBaseline(path string, r *Report) error {
+	baseline := *r
+	baseline.Regressions = nil
+	data, err := json.MarshalIndent(&baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,255 @@
+// Package loadtest drives concurrent ingest and query traffic against a
+// server, reports latency percentiles per stage, and compares them with a
+// stored baseline so regressions in the search and permission path show up.
+//
+// Client-side stages measure whole requests ("ingest", "query"); the query
+// stages reported by the server ("embed", "search" including the permission
+// checks, "rerank", "generate") are recorded when the server returns them.
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage names
+const (
+	StageIngest   = "ingest"
+	StageQuery    = "query"
+	StageEmbed    = "embed"
+	StageSearch   = "search"
+	StageRerank   = "rerank"
+	StageGenerate = "generate"
+)
+
+// stageOrder lists the stages in report order
+var stageOrder = []string{StageIngest, StageQuery, StageEmbed, StageSearch, StageRerank, StageGenerate}
+
+// QueryTimings are the server-side durations of a query's stages; zero for
+// stages the server did not run or report
+type QueryTimings struct {
+	Embed    time.Duration
+	Search   time.Duration
+	Rerank   time.Duration
+	Generate time.Duration
+}
+
+// Target is the system under load
+type Target interface {
+	Ingest(ctx context.Context, title, content string) error
+	Query(ctx context.Context, question string) (QueryTimings, error)
+}
+
+// Options configure a run
+type Options struct {
+	// Concurrency is the number of workers sending requests
+	Concurrency int
+	// Requests is the total number of requests to send
+	Requests int
+	// Duration stops the run early once elapsed; 0 runs all requests
+	Duration time.Duration
+	// IngestRatio is the share of requests that ingest a document, from 0 to 1
+	IngestRatio float64
+	// Questions are asked in turn by the query requests
+	Questions []string
+}
+
+// DefaultQuestions are asked unless Options.Questions is set
+var DefaultQuestions = []string{
+	"What was the refund in 2023?",
+	"Which deductions were claimed?",
+	"Summarize the quarterly report.",
+	"Who approved the salary review?",
+}
+
+// Validate checks that the options describe a run
+func (o Options) Validate() error {
+	if o.Concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+	if o.Requests <= 0 {
+		return fmt.Errorf("requests must be positive")
+	}
+	if o.Duration < 0 {
+		return fmt.Errorf("duration must not be negative")
+	}
+	if o.IngestRatio < 0 || o.IngestRatio > 1 {
+		return fmt.Errorf("ingest ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// StageStats are the latencies of a stage in milliseconds
+type StageStats struct {
+	Stage  string  `json:"stage"`
+	Count  int     `json:"count"`
+	Errors int     `json:"errors"`
+	P50    float64 `json:"p50_ms"`
+	P95    float64 `json:"p95_ms"`
+	P99    float64 `json:"p99_ms"`
+	Max    float64 `json:"max_ms"`
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Concurrency int     `json:"concurrency"`
+	Requests    int     `json:"requests"`
+	Seconds     float64 `json:"seconds"`
+	// Throughput is the completed requests per second
+	Throughput float64      `json:"throughput"`
+	Stages     []StageStats `json:"stages"`
+	// Regressions are the percentiles slower than the baseline; set by Compare
+	Regressions []Regression `json:"regressions,omitempty"`
+}
+
+// Stage returns the stats of the named stage, if it was measured
+func (r *Report) Stage(name string) (StageStats, bool) {
+	i := slices.IndexFunc(r.Stages, func(s StageStats) bool { return s.Stage == name })
+	if i < 0 {
+		return StageStats{}, false
+	}
+	return r.Stages[i], true
+}
+
+// recorder collects latencies per stage from concurrent workers
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func (r *recorder) observe(stage string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies[stage] = append(r.latencies[stage], d)
+}
+
+func (r *recorder) fail(stage string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors[stage]++
+}
+
+// Run sends opts.Requests requests to target from opts.Concurrency workers.
+// Ingests are spread evenly among the queries. Failed requests are counted
+// per stage and not measured.
+func Run(ctx context.Context, target Target, opts Options) (*Report, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	questions := opts.Questions
+	if len(questions) == 0 {
+		questions = DefaultQuestions
+	}
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	rec := &recorder{latencies: make(map[string][]time.Duration), errors: make(map[string]int)}
+	var next, completed atomic.Int64
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range opts.Concurrency {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= opts.Requests {
+					return
+				}
+				if isIngest(i, opts.IngestRatio) {
+					ingest(ctx, target, i, rec)
+				} else {
+					query(ctx, target, questions[i%len(questions)], rec)
+				}
+				completed.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &Report{
+		Concurrency: opts.Concurrency,
+		Requests:    int(completed.Load()),
+		Seconds:     elapsed.Seconds(),
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+	for _, stage := range stageOrder {
+		latencies, errs := rec.latencies[stage], rec.errors[stage]
+		if len(latencies) == 0 && errs == 0 {
+			continue
+		}
+		report.Stages = append(report.Stages, stats(stage, latencies, errs))
+	}
+	return report, nil
+}
+
+// isIngest reports whether request i ingests, so that ingests are spread
+// evenly at the given ratio
+func isIngest(i int, ratio float64) bool {
+	return math.Floor(float64(i+1)*ratio) > math.Floor(float64(i)*ratio)
+}
+
+func ingest(ctx context.Context, target Target, i int, rec *recorder) {
+	title := fmt.Sprintf("Load test document %d", i)
+	content := fmt.Sprintf("Load test document %d. %s", i, DefaultQuestions[i%len(DefaultQuestions)])
+	start := time.Now()
+	if err := target.Ingest(ctx, title, content); err != nil {
+		if ctx.Err() == nil {
+			rec.fail(StageIngest)
+		}
+		return
+	}
+	rec.observe(StageIngest, time.Since(start))
+}
+
+func query(ctx context.Context, target Target, question string, rec *recorder) {
+	start := time.Now()
+	timings, err := target.Query(ctx, question)
+	if err != nil {
+		if ctx.Err() == nil {
+			rec.fail(StageQuery)
+		}
+		return
+	}
+	rec.observe(StageQuery, time.Since(start))
+	for stage, d := range map[string]time.Duration{
+		StageEmbed:    timings.Embed,
+		StageSearch:   timings.Search,
+		StageRerank:   timings.Rerank,
+		StageGenerate: timings.Generate,
+	} {
+		if d > 0 {
+			rec.observe(stage, d)
+		}
+	}
+}
+
+// stats computes the nearest-rank percentiles of latencies
+func stats(stage string, latencies []time.Duration, errs int) StageStats {
+	s := StageStats{Stage: stage, Count: len(latencies), Errors: errs}
+	if len(latencies) == 0 {
+		return s
+	}
+	slices.Sort(latencies)
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p/100*float64(len(latencies)))) - 1
+		return milliseconds(latencies[max(rank, 0)])
+	}
+	s.P50, s.P95, s.P99 = percentile(50), percentile(95), percentile(99)
+	s.Max = milliseconds(latencies[len(latencies)-1])
+	return s
+}
+
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)) / 1000
+}
@@ -0,0 +1,111 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTarget answers queries with fixed server timings and fails every
+// failEvery-th ingest
+type fakeTarget struct {
+	ingests, queries atomic.Int32
+	failEvery        int32
+}
+
+func (f *fakeTarget) Ingest(context.Context, string, string) error {
+	if n := f.ingests.Add(1); f.failEvery > 0 && n%f.failEvery == 0 {
+		return errors.New("ingest failed")
+	}
+	return nil
+}
+
+func (f *fakeTarget) Query(context.Context, string) (QueryTimings, error) {
+	f.queries.Add(1)
+	return QueryTimings{Embed: 2 * time.Millisecond, Search: 5 * time.Millisecond}, nil
+}
+
+func TestRun(t *testing.T) {
+	target := &fakeTarget{failEvery: 5}
+	report, err := Run(context.Background(), target, Options{Concurrency: 4, Requests: 100, IngestRatio: 0.2})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if target.ingests.Load() != 20 || target.queries.Load() != 80 || report.Requests != 100 {
+		t.Errorf("Expected 20 ingests and 80 queries, got %d and %d", target.ingests.Load(), target.queries.Load())
+	}
+
+	ingest, _ := report.Stage(StageIngest)
+	if ingest.Count != 16 || ingest.Errors != 4 {
+		t.Errorf("Expected 16 measured and 4 failed ingests, got %+v", ingest)
+	}
+	search, ok := report.Stage(StageSearch)
+	if !ok || search.Count != 80 || search.P50 != 5 || search.P99 != 5 {
+		t.Errorf("Expected the server's search timings, got %+v", search)
+	}
+	if _, ok := report.Stage(StageRerank); ok {
+		t.Error("Expected stages the server did not report to be left out")
+	}
+}
+
+func TestRunValidatesOptions(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"no workers":   {Requests: 1},
+		"no requests":  {Concurrency: 1},
+		"ratio over 1": {Concurrency: 1, Requests: 1, IngestRatio: 1.5},
+	} {
+		if _, err := Run(context.Background(), &fakeTarget{}, opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestStatsPercentiles(t *testing.T) {
+	latencies := make([]time.Duration, 0, 100)
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	s := stats(StageQuery, latencies, 0)
+	if s.P50 != 50 || s.P95 != 95 || s.P99 != 99 || s.Max != 100 {
+		t.Errorf("Unexpected percentiles %+v", s)
+	}
+}
+
+func TestCompareWithBaseline(t *testing.T) {
+	baseline := &Report{Stages: []StageStats{
+		{Stage: StageSearch, Count: 10, P50: 10, P95: 20},
+		{Stage: StageEmbed, Count: 10, P50: 0.2, P95: 0.3},
+	}}
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := SaveBaseline(path, baseline); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatalf("LoadBaseline failed: %v", err)
+	}
+
+	report := &Report{Stages: []StageStats{
+		{Stage: StageSearch, Count: 10, P50: 11, P95: 30},
+		// Slower by more than the tolerance but by less than a millisecond
+		{Stage: StageEmbed, Count: 10, P50: 0.5, P95: 0.9},
+		{Stage: StageQuery, Count: 10, P50: 100, P95: 200},
+	}}
+	regressions := report.Compare(loaded, 0.2)
+	if len(regressions) != 1 || regressions[0].Stage != StageSearch || regressions[0].Percentile != "p95" {
+		t.Errorf("Expected only the search p95 to regress, got %v", regressions)
+	}
+
+	var out bytes.Buffer
+	if err := WriteTable(&out, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "REGRESSION search p95 20.0ms -> 30.0ms") {
+		t.Errorf("Expected the regression in the table, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,33 @@
+package loadtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// WriteJSON writes the report as indented JSON
+func WriteJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable writes the stage latencies as an aligned table followed by the
+// throughput and any regressions
+func WriteTable(w io.Writer, r *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "STAGE\tCOUNT\tERRORS\tP50 (ms)\tP95 (ms)\tP99 (ms)\tMAX (ms)")
+	for _, s := range r.Stages {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.1f\t%.1f\t%.1f\n", s.Stage, s.Count, s.Errors, s.P50, s.P95, s.P99, s.Max)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "\n%d requests in %.1fs with %d workers (%.1f req/s)\n", r.Requests, r.Seconds, r.Concurrency, r.Throughput)
+	for _, regression := range r.Regressions {
+		_, _ = fmt.Fprintf(w, "REGRESSION %s\n", regression)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Package logging filters the standard logger's output by level. Messages
+// are logged with log.Printf as before and classified by the marker that
+// starts them, after the timestamp and request ID: "DEBUG" messages are
+// debug, "Warning" and "WARNING" ones warnings, "ERROR" ones errors, and all
+// others info. AUDIT records are always written.
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Level is the minimum severity of the messages written
+type Level int32
+
+// Levels in increasing severity
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// levelNames maps the values of app.log_level to levels
+var levelNames = map[string]Level{
+	"debug": LevelDebug,
+	"info":  LevelInfo,
+	"warn":  LevelWarn,
+	"error": LevelError,
+}
+
+// ParseLevel returns the level named debug, info, warn, or error
+func ParseLevel(name string) (Level, error) {
+	level, ok := levelNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// Writer writes the lines of a logger at or above its level to an
+// underlying writer. The level can be changed while logging.
+type Writer struct {
+	out   io.Writer
+	level atomic.Int32
+}
+
+// NewWriter creates a writer passing lines at or above level to out
+func NewWriter(out io.Writer, level Level) *Writer {
+	w := &Writer{out: out}
+	w.SetLevel(level)
+	return w
+}
+
+// SetLevel changes the minimum level of the lines written
+func (w *Writer) SetLevel(level Level) {
+	w.level.Store(int32(level))
+}
+
+// Write writes the log line p unless its level is below the writer's. The
+// log package calls it once per line.
+func (w *Writer) Write(p []byte) (int, error) {
+	if level, audit := lineLevel(p); !audit && level < Level(w.level.Load()) {
+		return len(p), nil
+	}
+	return w.out.Write(p)
+}
+
+// markers start the messages of levels other than info
+var markers = []struct {
+	prefix string
+	level  Level
+}{
+	{"DEBUG", LevelDebug},
+	{"Warning", LevelWarn},
+	{"WARNING", LevelWarn},
+	{"ERROR", LevelError},
+}
+
+// lineLevel returns the level of a log line and whether it is an audit record
+func lineLevel(line []byte) (Level, bool) {
+	msg := bytes.TrimLeft(line, "0123456789/:. ")
+	if rest, ok := bytes.CutPrefix(msg, []byte("[request_id=")); ok {
+		if _, after, found := bytes.Cut(rest, []byte("] ")); found {
+			msg = after
+		}
+	}
+	if bytes.HasPrefix(msg, []byte("AUDIT")) {
+		return LevelInfo, true
+	}
+	for _, m := range markers {
+		if bytes.HasPrefix(msg, []byte(m.prefix)) {
+			return m.level, false
+		}
+	}
+	return LevelInfo, false
+}
@@ -0,0 +1,50 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestWriterFiltersByLevel(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out, LevelWarn)
+	logger := log.New(w, "", log.LstdFlags)
+
+	logger.Printf("DEBUG: cache miss")
+	logger.Printf("Server started successfully")
+	logger.Printf("[request_id=abc] Rejected access token: expired")
+	logger.Printf("Warning: keeping previous prompt templates")
+	logger.Printf("[request_id=abc] AUDIT api key revoked: admin=%q", "peter")
+	logger.Printf("ERROR [DATABASE_ERROR] GET /documents: locked")
+	for _, want := range []string{"Warning: keeping", "AUDIT api key revoked", "ERROR [DATABASE_ERROR]"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q to be written, got %q", want, out.String())
+		}
+	}
+	for _, dropped := range []string{"cache miss", "Server started", "Rejected access token"} {
+		if strings.Contains(out.String(), dropped) {
+			t.Errorf("Expected %q to be dropped at warn, got %q", dropped, out.String())
+		}
+	}
+
+	// The level applies to the lines logged after it changes
+	out.Reset()
+	w.SetLevel(LevelDebug)
+	logger.Printf("DEBUG: cache miss")
+	if !strings.Contains(out.String(), "cache miss") {
+		t.Errorf("Expected debug messages at debug, got %q", out.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]Level{"debug": LevelDebug, "info": LevelInfo, "warn": LevelWarn, "error": LevelError} {
+		if level, err := ParseLevel(name); err != nil || level != want {
+			t.Errorf("Expected %s to parse as %d, got %d: %v", name, want, level, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+}
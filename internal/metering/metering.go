@@ -0,0 +1,175 @@
+// Package metering accounts the tokens and wall-clock time spent answering
+// queries per user and tenant, and exports the totals in the Prometheus text
+// format.
+//
+// Totals are kept in memory and reset when the server restarts.
+package metering
+
+import (
+	"fmt"
+	"io"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// key identifies a user within a tenant
+type key struct {
+	tenantID string
+	username string
+}
+
+// totals are the aggregated usage of a user's queries
+type totals struct {
+	models.QueryTotals
+	// Seconds spent in each pipeline stage
+	embed, search, rerank, generate float64
+}
+
+// Meter aggregates query usage per user and tenant. It is safe for
+// concurrent use.
+type Meter struct {
+	mu    sync.Mutex
+	users map[key]*totals
+}
+
+// NewMeter creates an empty meter
+func NewMeter() *Meter {
+	return &Meter{users: make(map[key]*totals)}
+}
+
+// Record adds a query answered for username in the tenant with usage.
+// cached reports whether the answer came from the query cache.
+func (m *Meter) Record(tenantID, username string, usage *models.QueryUsage, cached bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := key{tenantID: tenantID, username: username}
+	t, ok := m.users[k]
+	if !ok {
+		t = &totals{}
+		m.users[k] = t
+	}
+	t.Queries++
+	if cached {
+		t.CachedQueries++
+	}
+	t.EmbeddingTokens += int64(usage.EmbeddingTokens)
+	t.PromptTokens += int64(usage.PromptTokens)
+	t.ResponseTokens += int64(usage.ResponseTokens)
+	t.DurationSeconds += usage.Timings.TotalMs / 1000
+	t.embed += usage.Timings.EmbedMs / 1000
+	t.search += usage.Timings.SearchMs / 1000
+	t.rerank += usage.Timings.RerankMs / 1000
+	t.generate += usage.Timings.GenerateMs / 1000
+}
+
+// Totals returns the usage of the queries username made in the tenant
+func (m *Meter) Totals(tenantID, username string) models.QueryTotals {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.users[key{tenantID: tenantID, username: username}]; ok {
+		return t.QueryTotals
+	}
+	return models.QueryTotals{}
+}
+
+// TenantTotals returns the usage of all queries made in the tenant and the
+// number of users who made them
+func (m *Meter) TenantTotals(tenantID string) (models.QueryTotals, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sum models.QueryTotals
+	users := 0
+	for k, t := range m.users {
+		if k.tenantID != tenantID {
+			continue
+		}
+		users++
+		sum.Queries += t.Queries
+		sum.CachedQueries += t.CachedQueries
+		sum.EmbeddingTokens += t.EmbeddingTokens
+		sum.PromptTokens += t.PromptTokens
+		sum.ResponseTokens += t.ResponseTokens
+		sum.DurationSeconds += t.DurationSeconds
+	}
+	return sum, users
+}
+
+// WritePrometheus writes the totals of the tenant's users in the Prometheus
+// text exposition format, sorted by username
+func (m *Meter) WritePrometheus(w io.Writer, tenantID string) error {
+	m.mu.Lock()
+	var usernames []string
+	snapshot := make(map[string]totals)
+	for k, t := range m.users {
+		if k.tenantID == tenantID {
+			usernames = append(usernames, k.username)
+			snapshot[k.username] = *t
+		}
+	}
+	m.mu.Unlock()
+	slices.Sort(usernames)
+
+	metrics := []struct {
+		name, help, typ string
+		samples         func(labels string, t *totals) []string
+	}{
+		{"rerag_queries_total", "Queries answered per user.", "counter", func(labels string, t *totals) []string {
+			return []string{sample("rerag_queries_total", labels, "", float64(t.Queries))}
+		}},
+		{"rerag_cached_queries_total", "Queries answered from the query cache per user.", "counter", func(labels string, t *totals) []string {
+			return []string{sample("rerag_cached_queries_total", labels, "", float64(t.CachedQueries))}
+		}},
+		{"rerag_query_tokens_total", "Tokens spent on queries per user; embedding tokens are estimated.", "counter", func(labels string, t *totals) []string {
+			return []string{
+				sample("rerag_query_tokens_total", labels, `kind="embedding"`, float64(t.EmbeddingTokens)),
+				sample("rerag_query_tokens_total", labels, `kind="prompt"`, float64(t.PromptTokens)),
+				sample("rerag_query_tokens_total", labels, `kind="response"`, float64(t.ResponseTokens)),
+			}
+		}},
+		{"rerag_query_stage_seconds_total", "Wall-clock seconds spent in each query pipeline stage per user.", "counter", func(labels string, t *totals) []string {
+			return []string{
+				sample("rerag_query_stage_seconds_total", labels, `stage="embed"`, t.embed),
+				sample("rerag_query_stage_seconds_total", labels, `stage="search"`, t.search),
+				sample("rerag_query_stage_seconds_total", labels, `stage="rerank"`, t.rerank),
+				sample("rerag_query_stage_seconds_total", labels, `stage="generate"`, t.generate),
+			}
+		}},
+		{"rerag_query_duration_seconds_total", "Wall-clock seconds spent answering queries per user.", "counter", func(labels string, t *totals) []string {
+			return []string{sample("rerag_query_duration_seconds_total", labels, "", t.DurationSeconds)}
+		}},
+	}
+
+	var b strings.Builder
+	for _, metric := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.typ)
+		for _, username := range usernames {
+			t := snapshot[username]
+			labels := fmt.Sprintf(`tenant="%s",user="%s"`, escapeLabel(tenantID), escapeLabel(username))
+			for _, line := range metric.samples(labels, &t) {
+				b.WriteString(line)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sample formats one sample line with the user labels and an optional extra label
+func sample(name, labels, extra string, value float64) string {
+	if extra != "" {
+		labels += "," + extra
+	}
+	return fmt.Sprintf("%s{%s} %g\n", name, labels, value)
+}
+
+// labelEscaper escapes label values as the Prometheus text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,64 @@
+package metering
+
+import (
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestMeterAggregatesPerUser(t *testing.T) {
+	m := NewMeter()
+	usage := &models.QueryUsage{
+		EmbeddingTokens: 12,
+		PromptTokens:    400,
+		ResponseTokens:  50,
+		Timings:         models.QueryTimings{EmbedMs: 20, SearchMs: 5, GenerateMs: 1475, TotalMs: 1500},
+	}
+	m.Record("default", "alice", usage, false)
+	m.Record("default", "alice", &models.QueryUsage{EmbeddingTokens: 12, Timings: models.QueryTimings{TotalMs: 500}}, true)
+	m.Record("acme", "alice", usage, false)
+
+	got := m.Totals("default", "alice")
+	want := models.QueryTotals{Queries: 2, CachedQueries: 1, EmbeddingTokens: 24, PromptTokens: 400, ResponseTokens: 50, DurationSeconds: 2}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if got := m.Totals("default", "bob"); got != (models.QueryTotals{}) {
+		t.Errorf("Expected no usage for bob, got %+v", got)
+	}
+
+	m.Record("default", "bob", &models.QueryUsage{PromptTokens: 100}, false)
+	if got, users := m.TenantTotals("default"); got.Queries != 3 || got.PromptTokens != 500 || users != 2 {
+		t.Errorf("Expected the tenant's totals over 2 users, got %+v over %d", got, users)
+	}
+}
+
+func TestMeterWritePrometheus(t *testing.T) {
+	m := NewMeter()
+	m.Record("default", `bob "the admin"`, &models.QueryUsage{PromptTokens: 400, Timings: models.QueryTimings{TotalMs: 500}}, false)
+	m.Record("default", "alice", &models.QueryUsage{EmbeddingTokens: 12}, true)
+	m.Record("acme", "carol", &models.QueryUsage{}, false)
+
+	var b strings.Builder
+	if err := m.WritePrometheus(&b, "default"); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE rerag_queries_total counter\n",
+		`rerag_queries_total{tenant="default",user="alice"} 1` + "\n",
+		`rerag_cached_queries_total{tenant="default",user="alice"} 1` + "\n",
+		`rerag_query_tokens_total{tenant="default",user="bob \"the admin\"",kind="prompt"} 400` + "\n",
+		`rerag_query_duration_seconds_total{tenant="default",user="bob \"the admin\""} 0.5` + "\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %q in\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "carol") {
+		t.Errorf("Expected only the tenant's users, got\n%s", out)
+	}
+	if strings.Index(out, `user="alice"`) > strings.Index(out, `user="bob`) {
+		t.Errorf("Expected users sorted by name, got\n%s", out)
+	}
+}
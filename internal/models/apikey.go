@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// API key scopes
+const (
+	// APIKeyScopeIngest allows adding documents and uploading files
+	APIKeyScopeIngest = "ingest"
+	// APIKeyScopeQuery allows querying documents
+	APIKeyScopeQuery = "query"
+)
+
+// APIKey authenticates a service instead of a user. Only a hash of the key is
+// stored; the key itself is returned once when it is created.
+// swagger:model APIKey
+type APIKey struct {
+	// The unique identifier of the key
+	// required: true
+	ID uuid.UUID `json:"id"`
+
+	// Human-readable name of the service using the key
+	// required: true
+	Name string `json:"name"`
+
+	// The user the key acts as in permission checks
+	// required: true
+	User string `json:"user"`
+
+	// Endpoints the key may call: "ingest" and/or "query"
+	// required: true
+	Scopes []string `json:"scopes"`
+
+	TenantID  string     `json:"tenant_id,omitempty"`
+	Hash      string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyRequest represents the request body for creating an API key
+// swagger:model CreateAPIKeyRequest
+type CreateAPIKeyRequest struct {
+	// required: true
+	Name string `json:"name"`
+	// The user the key acts as in permission checks
+	// required: true
+	User string `json:"user"`
+	// required: true
+	Scopes []string `json:"scopes"`
+}
+
+// CreateAPIKeyResponse holds a new API key. The key cannot be retrieved again.
+// swagger:model CreateAPIKeyResponse
+type CreateAPIKeyResponse struct {
+	APIKey
+	// The secret to send in the X-API-Key header
+	Key string `json:"key"`
+}
+
+// APIKeyListResponse represents the response for listing API keys
+// swagger:model APIKeyListResponse
+type APIKeyListResponse struct {
+	Keys []APIKey `json:"keys"`
+}
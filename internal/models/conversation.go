@@ -0,0 +1,103 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message roles within a conversation
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Conversation is a multi-turn exchange between a user and the RAG assistant
+// swagger:model Conversation
+type Conversation struct {
+	// The unique identifier of the conversation
+	// required: true
+	ID uuid.UUID `json:"id"`
+
+	// Optional human-readable title
+	Title string `json:"title,omitempty"`
+
+	// The user owning the conversation
+	// required: true
+	Username string `json:"user"`
+
+	TenantID  string    `json:"tenant_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Turns in chronological order
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// Message is a single turn of a conversation
+// swagger:model Message
+type Message struct {
+	// Either "user" or "assistant"
+	// required: true
+	Role string `json:"role"`
+
+	// required: true
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateConversationRequest is the body of POST /conversations
+type CreateConversationRequest struct {
+	Title string `json:"title"`
+}
+
+// MessageResponse is the assistant's reply to a conversation message
+// swagger:model MessageResponse
+type MessageResponse struct {
+	// The conversation the message belongs to
+	// required: true
+	ConversationID uuid.UUID `json:"conversation_id"`
+
+	// The generated answer
+	// required: true
+	Answer string `json:"answer"`
+
+	// The source documents retrieved for the question
+	// required: true
+	Sources []SourceDocument `json:"sources"`
+
+	// Number of sources that fit into the model's context window
+	// required: true
+	SourcesIncluded int `json:"sources_included"`
+
+	// Number of citation markers removed from the answer because they named no source
+	StrippedCitations int `json:"stripped_citations,omitempty"`
+
+	// The metadata filters the search was restricted to
+	Filters map[string]MetadataFilter `json:"filters,omitempty"`
+
+	// Whether the user may access none of the matching documents; the answer
+	// is then NoAccessibleDocumentsAnswer and the LLM was not called
+	NoAccessibleDocuments bool `json:"no_accessible_documents,omitempty"`
+
+	// Number of the top_k matches withheld because the user may not access
+	// them; only set if the server reports hidden results
+	HiddenResults *int `json:"hidden_results,omitempty"`
+
+	// The follow-up searches the LLM issued in agent mode, in order
+	Searches []string `json:"searches,omitempty"`
+
+	// How the question was answered: retrieval, aggregate, or direct; only
+	// set if the server routes questions
+	Route string `json:"route,omitempty"`
+
+	// The document counts of the aggregate route
+	Aggregation *QueryAggregation `json:"aggregation,omitempty"`
+
+	// Why content moderation withheld or annotated the question or answer,
+	// question first; only set if moderation blocked or annotated one. Turns
+	// with a blocked question are not added to the conversation.
+	Moderation []QueryModeration `json:"moderation,omitempty"`
+
+	// The tokens and stage timings of the query; only set if include_usage was requested
+	Usage *QueryUsage `json:"usage,omitempty"`
+}
@@ -0,0 +1,81 @@
+package models
+
+import "time"
+
+// DirectoryUser is a user defined in the user directory of the mock auth
+// mode. Bearer tokens naming a directory user authenticate as them, with
+// their groups and roles.
+// swagger:model DirectoryUser
+type DirectoryUser struct {
+	// The username, used as the subject of permission checks
+	// required: true
+	Username string `json:"username"`
+
+	// Human-readable name of the user
+	DisplayName string `json:"display_name,omitempty"`
+
+	// The directory groups the user is a member of, sorted
+	// required: true
+	Groups []string `json:"groups"`
+
+	// The roles of the user, passed to permission policies
+	// required: true
+	Roles []string `json:"roles"`
+
+	// When the user was created
+	// required: true
+	CreatedAt time.Time `json:"created_at"`
+
+	// When the user was last changed
+	// required: true
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DirectoryGroup is a group defined in the user directory. Its members are
+// also members of the permission service's group of the same name.
+// swagger:model DirectoryGroup
+type DirectoryGroup struct {
+	// The group name
+	// required: true
+	Name string `json:"name"`
+
+	// What the group is for
+	Description string `json:"description,omitempty"`
+
+	// The users in the group, sorted
+	// required: true
+	Members []string `json:"members"`
+
+	// When the group was created
+	// required: true
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PutDirectoryUserRequest represents the request body for creating or
+// replacing a directory user
+// swagger:model PutDirectoryUserRequest
+type PutDirectoryUserRequest struct {
+	DisplayName string `json:"display_name"`
+	// Directory groups to place the user in; they must exist
+	Groups []string `json:"groups"`
+	Roles  []string `json:"roles"`
+}
+
+// PutDirectoryGroupRequest represents the request body for creating or
+// updating a directory group
+// swagger:model PutDirectoryGroupRequest
+type PutDirectoryGroupRequest struct {
+	Description string `json:"description"`
+}
+
+// DirectoryUserListResponse represents the response for listing directory users
+// swagger:model DirectoryUserListResponse
+type DirectoryUserListResponse struct {
+	Users []DirectoryUser `json:"users"`
+}
+
+// DirectoryGroupListResponse represents the response for listing directory groups
+// swagger:model DirectoryGroupListResponse
+type DirectoryGroupListResponse struct {
+	Groups []DirectoryGroup `json:"groups"`
+}
@@ -1,7 +1,16 @@
 // Package models defines the core data structures for the RAG system.
 package models
 
-import "github.com/google/uuid"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // Document represents a document in the system with content and metadata
 type Document struct {
@@ -9,15 +18,114 @@ type Document struct {
 	Title     string                 `json:"title"`
 	Content   string                 `json:"content"`
 	Metadata  map[string]interface{} `json:"metadata"`
-	Embedding []float32              `json:"-"`
+	TenantID  string                 `json:"tenant_id,omitempty"`
+	CreatedAt time.Time              `json:"created_at,omitzero"`
+	// DeletedAt is set while the document is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Embedding []float32  `json:"-"`
+	// Chunks are passages of Content embedded separately, so searches match
+	// the document by its best passages rather than its embedding alone
+	Chunks []DocumentChunk `json:"-"`
+	// Distance to the query embedding; only set by similarity searches
+	Distance float64 `json:"-"`
+	// Match is the chunk that matched the query best; only set by similarity
+	// searches over documents with chunks
+	Match *Span `json:"-"`
+}
+
+// DocumentChunk is a passage of a document's content with its own embedding
+type DocumentChunk struct {
+	// Byte offsets of the passage in the document's content
+	Start     int       `json:"start"`
+	End       int       `json:"end"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// ContentHash returns a stable hash of document content used to detect changes
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
 }
 
 // QueryRequest represents a user's query for document search
 type QueryRequest struct {
 	Question string `json:"question" binding:"required"`
 	TopK     int    `json:"top_k"`
+	// SearchMode selects retrieval: "vector" (default) or "hybrid" (vector + keyword)
+	SearchMode string `json:"search_mode,omitempty"`
+	// MinScore drops sources whose similarity score is below it (0 to 1)
+	MinScore float64 `json:"min_score,omitempty"`
+	// Template selects a configured prompt template; empty uses the default
+	Template string `json:"template,omitempty"`
+	// NoCache skips the query cache lookup and generates a fresh answer
+	NoCache bool `json:"no_cache,omitempty"`
+	// Rehydrate restores redacted values in the answer from the sources the user may read
+	Rehydrate bool `json:"rehydrate,omitempty"`
+	// IncludeUsage adds the tokens and stage timings of the query to the response
+	IncludeUsage bool `json:"include_usage,omitempty"`
+	// Excerpts replaces the content of sources with the passages matching the question
+	Excerpts bool `json:"excerpts,omitempty"`
+	// Filters restrict the search to documents whose metadata matches every
+	// condition, e.g. {"form": "1040", "year": {"gte": 2023}}
+	Filters map[string]MetadataFilter `json:"filters,omitempty"`
+	// DocumentIDs answers from exactly these documents, in this order,
+	// instead of searching; the user must be able to read every one of them
+	DocumentIDs []uuid.UUID `json:"document_ids,omitempty"`
+	// Agent lets the LLM issue follow-up searches, still filtered by the
+	// user's permissions, before it answers; the server must enable agent mode
+	Agent bool `json:"agent,omitempty"`
+	// Route answers through this route instead of classifying the question:
+	// "retrieval", "aggregate", or "direct"; the server must enable routing
+	Route string `json:"route,omitempty"`
+}
+
+// MetadataFilter is a condition on a top-level metadata field. A bare JSON
+// value is shorthand for {"eq": value}; range operators compare numbers
+// numerically and strings, such as ISO dates, lexicographically.
+type MetadataFilter struct {
+	Eq  interface{} `json:"eq,omitempty"`
+	Gt  interface{} `json:"gt,omitempty"`
+	Gte interface{} `json:"gte,omitempty"`
+	Lt  interface{} `json:"lt,omitempty"`
+	Lte interface{} `json:"lte,omitempty"`
+}
+
+// UnmarshalJSON accepts a condition object or a bare value to match exactly
+func (f *MetadataFilter) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		*f = MetadataFilter{}
+		return json.Unmarshal(data, &f.Eq)
+	}
+
+	// The alias has no UnmarshalJSON; unknown operators are rejected
+	type condition MetadataFilter
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var c condition
+	if err := decoder.Decode(&c); err != nil {
+		return fmt.Errorf("invalid metadata filter: %w", err)
+	}
+	*f = MetadataFilter(c)
+	return nil
 }
 
+// Query routes of QueryRequest.Route and QueryResponse.Route
+const (
+	// QueryRouteRetrieval answers from the documents most similar to the question
+	QueryRouteRetrieval = "retrieval"
+	// QueryRouteAggregate counts the accessible documents by their metadata
+	QueryRouteAggregate = "aggregate"
+	// QueryRouteDirect answers without documents, e.g. greetings
+	QueryRouteDirect = "direct"
+)
+
+// Search modes accepted in QueryRequest.SearchMode
+const (
+	SearchModeVector = "vector"
+	SearchModeHybrid = "hybrid"
+)
+
 // QueryResponse represents the response from a document query
 // swagger:model QueryResponse
 type QueryResponse struct {
@@ -25,9 +133,256 @@ type QueryResponse struct {
 	// required: true
 	Answer string `json:"answer"`
 
-	// The source documents used to generate the answer
+	// The source documents retrieved for the question
+	// required: true
+	Sources []SourceDocument `json:"sources"`
+
+	// Number of sources that fit into the model's context window
+	// required: true
+	SourcesIncluded int `json:"sources_included"`
+
+	// Number of citation markers removed from the answer because they named no source
+	StrippedCitations int `json:"stripped_citations,omitempty"`
+
+	// Whether the answer was served from the query cache
+	Cached bool `json:"cached,omitempty"`
+
+	// The metadata filters the search was restricted to
+	Filters map[string]MetadataFilter `json:"filters,omitempty"`
+
+	// Whether the user may access none of the matching documents; the answer
+	// is then NoAccessibleDocumentsAnswer and the LLM was not called
+	NoAccessibleDocuments bool `json:"no_accessible_documents,omitempty"`
+
+	// Number of the top_k matches withheld because the user may not access
+	// them; only set if the server reports hidden results
+	HiddenResults *int `json:"hidden_results,omitempty"`
+
+	// The follow-up searches the LLM issued in agent mode, in order
+	Searches []string `json:"searches,omitempty"`
+
+	// How the question was answered: retrieval, aggregate, or direct; only
+	// set if the server routes questions
+	Route string `json:"route,omitempty"`
+
+	// The document counts of the aggregate route
+	Aggregation *QueryAggregation `json:"aggregation,omitempty"`
+
+	// Why content moderation withheld or annotated the question or answer,
+	// question first; only set if moderation blocked or annotated one
+	Moderation []QueryModeration `json:"moderation,omitempty"`
+
+	// The tokens and stage timings of the query; only set if include_usage was requested
+	Usage *QueryUsage `json:"usage,omitempty"`
+}
+
+// QueryAggregation counts the documents an aggregate question is about. Only
+// documents the user may access are counted; the chunks of one source count
+// once.
+// swagger:model QueryAggregation
+type QueryAggregation struct {
+	// Number of accessible documents matching the filters
+	// required: true
+	Count int `json:"count"`
+
+	// The metadata key the documents were grouped by
+	GroupBy string `json:"group_by,omitempty"`
+
+	// The count per value of group_by, most frequent first; documents
+	// without the key are left out
+	Groups []AggregationGroup `json:"groups,omitempty"`
+
+	// Whether counting stopped at the server's document limit, so the counts
+	// are lower bounds
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// QueryModeration describes content moderation flagging a question or answer
+// swagger:model QueryModeration
+type QueryModeration struct {
+	// What was flagged: question or answer
+	// required: true
+	Stage string `json:"stage"`
+
+	// What moderation did: block withholds it and answers ModeratedAnswer,
+	// annotate lets it through
+	// required: true
+	Action string `json:"action"`
+
+	// The policy categories it was flagged for
+	Categories []string `json:"categories,omitempty"`
+}
+
+// Stages of content moderation
+const (
+	ModerationStageQuestion = "question"
+	ModerationStageAnswer   = "answer"
+)
+
+// AggregationGroup is the number of documents with one metadata value
+// swagger:model AggregationGroup
+type AggregationGroup struct {
+	// required: true
+	Value interface{} `json:"value"`
+	// required: true
+	Count int `json:"count"`
+}
+
+// QueryUsage reports the cost and latency of answering a query
+// swagger:model QueryUsage
+type QueryUsage struct {
+	// Estimated tokens of the embedded search text
+	EmbeddingTokens int `json:"embedding_tokens"`
+
+	// Tokens the LLM read from the prompt; 0 if the LLM was not called
+	PromptTokens int `json:"prompt_tokens"`
+
+	// Tokens the LLM generated; 0 if the LLM was not called
+	ResponseTokens int `json:"response_tokens"`
+
+	// Wall-clock time spent in each stage of the pipeline
+	Timings QueryTimings `json:"timings"`
+}
+
+// QueryTimings are the wall-clock durations of the query pipeline stages in milliseconds
+// swagger:model QueryTimings
+type QueryTimings struct {
+	RouteMs    float64 `json:"route_ms,omitempty"` // classifying the question
+	EmbedMs    float64 `json:"embed_ms"`
+	SearchMs   float64 `json:"search_ms"` // including permission checks
+	RerankMs   float64 `json:"rerank_ms,omitempty"`
+	GenerateMs float64 `json:"generate_ms,omitempty"`
+	TotalMs    float64 `json:"total_ms"`
+}
+
+// NoAccessibleDocumentsAnswer is the answer to questions none of the user's
+// accessible documents match, returned instead of generating one without context
+const NoAccessibleDocumentsAnswer = "No accessible documents match the question, so it cannot be answered."
+
+// ModeratedAnswer replaces the answer when content moderation blocks the
+// question or the generated answer
+const ModeratedAnswer = "This request cannot be answered because it violates the content policy."
+
+// StreamDelta is the data of a "delta" event of a streamed query: the next
+// piece of the answer
+// swagger:model StreamDelta
+type StreamDelta struct {
+	// Text to append to the answer
+	// required: true
+	Text string `json:"text"`
+}
+
+// SourceDocument is a document used to answer a query along with how relevant it was
+// swagger:model SourceDocument
+type SourceDocument struct {
+	Document
+
+	// Similarity to the question in (0, 1]; higher is more relevant
+	// required: true
+	Score float64 `json:"score"`
+
+	// Raw vector distance between the question and document embeddings
+	// required: true
+	Distance float64 `json:"distance"`
+
+	// Byte offsets of the best matching chunk in the document; set for
+	// documents stored with chunk vectors, whose content is then that chunk
+	Match *Span `json:"match,omitempty"`
+
+	// Whether the document fit into the prompt; excluded sources were not seen by the LLM
+	// required: true
+	Included bool `json:"included"`
+
+	// The number the answer cites the document with, as in [1]; omitted for excluded sources
+	Citation int `json:"citation,omitempty"`
+
+	// Whether the answer cites the document
+	Cited bool `json:"cited,omitempty"`
+
+	// The passages matching the question, with the matched terms highlighted;
+	// only set if excerpts were requested, and the content is then omitted
+	Excerpts []Excerpt `json:"excerpts,omitempty"`
+}
+
+// Excerpt is a passage of a source document
+// swagger:model Excerpt
+type Excerpt struct {
+	// The passage
+	// required: true
+	Text string `json:"text"`
+
+	// Byte offsets of the passage in the text the document was ingested
+	// from; in the document's content if it was not split into chunks
 	// required: true
-	Sources []Document `json:"sources"`
+	Start int `json:"start"`
+	End   int `json:"end"`
+
+	// Byte offsets of the matched question terms within the passage
+	Highlights []Span `json:"highlights,omitempty"`
+}
+
+// Span is a range of byte offsets in a text
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// CompareRequest asks how two or more documents differ
+// swagger:model CompareRequest
+type CompareRequest struct {
+	// The documents to compare, in order; the user must be able to read all of them
+	// required: true
+	DocumentIDs []uuid.UUID `json:"document_ids"`
+
+	// What to focus the comparison on, e.g. "income and deductions"
+	Focus string `json:"focus,omitempty"`
+}
+
+// CompareResponse is a structured comparison of documents
+// swagger:model CompareResponse
+type CompareResponse struct {
+	// The compared documents in request order; values of differences are aligned with them
+	// required: true
+	Documents []ComparedDocument `json:"documents"`
+
+	// The metadata fields whose values differ between the documents
+	// required: true
+	Metadata []MetadataDifference `json:"metadata"`
+
+	// The LLM's summary of how the documents differ
+	// required: true
+	Summary string `json:"summary"`
+
+	// The content differences the LLM found; empty if its answer was not
+	// structured, in which case the summary holds the whole answer
+	// required: true
+	Differences []ContentDifference `json:"differences"`
+
+	// Why content moderation withheld or annotated the focus or the
+	// comparison, focus first; only set if moderation blocked or annotated one
+	Moderation []QueryModeration `json:"moderation,omitempty"`
+}
+
+// ComparedDocument identifies a compared document
+type ComparedDocument struct {
+	ID    uuid.UUID `json:"id"`
+	Title string    `json:"title"`
+	// Whether the document fit into the model's context window
+	Included bool `json:"included"`
+}
+
+// MetadataDifference is a metadata field with its value in each compared
+// document; null where a document lacks the field
+type MetadataDifference struct {
+	Key    string        `json:"key"`
+	Values []interface{} `json:"values"`
+}
+
+// ContentDifference is an aspect of the content with its value in each
+// compared document
+type ContentDifference struct {
+	Aspect string   `json:"aspect"`
+	Values []string `json:"values"`
 }
 
 // DocumentResponse represents the response when a document is successfully added
@@ -40,6 +395,64 @@ type DocumentResponse struct {
 	// Success message
 	// required: true
 	Message string `json:"message"`
+
+	// Whether a new embedding was generated (updates only)
+	Reembedded bool `json:"reembedded,omitempty"`
+}
+
+// DocumentAccessResponse lists the relations a user holds on a document
+// swagger:model DocumentAccessResponse
+type DocumentAccessResponse struct {
+	// The document checked
+	// required: true
+	DocumentID string `json:"document_id"`
+
+	// The user the relations are held by
+	// required: true
+	User string `json:"user"`
+
+	// Relations the user holds on the document: viewer, editor, or both
+	// required: true
+	Relations []string `json:"relations"`
+}
+
+// DocumentPermissionsResponse lists a page of the relations held on a document
+// swagger:model DocumentPermissionsResponse
+type DocumentPermissionsResponse struct {
+	// The document listed
+	// required: true
+	DocumentID string `json:"document_id"`
+
+	// The relations users and groups hold on the document
+	// required: true
+	Relations []RelationTuple `json:"relations"`
+
+	// Pass as page_token to fetch the next page; omitted on the last page
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// UploadResponse represents the response to a file upload
+// swagger:model UploadResponse
+type UploadResponse struct {
+	// The uploaded file's name
+	// required: true
+	Filename string `json:"filename"`
+
+	// The detected MIME type of the file
+	// required: true
+	ContentType string `json:"content_type"`
+
+	// Identifier shared by all documents created from the file (metadata key "source_id")
+	// required: true
+	SourceID string `json:"source_id"`
+
+	// The documents created from the file's chunks, in order
+	// required: true
+	DocumentIDs []string `json:"document_ids"`
+
+	// Success message
+	// required: true
+	Message string `json:"message"`
 }
 
 // DocumentListResponse represents the response when listing documents
@@ -49,13 +462,57 @@ type DocumentListResponse struct {
 	// required: true
 	Documents []Document `json:"documents"`
 
-	// Total count of accessible documents
+	// Number of accessible documents in this page
 	// required: true
 	Count int `json:"count"`
 
 	// The authenticated user
 	// required: true
 	User string `json:"user"`
+
+	// Offset to request the next page with; omitted on the last page
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// ExportedDocument is one line of a JSONL document export: a document with
+// its tenant, timestamps, and embedding
+// swagger:model ExportedDocument
+type ExportedDocument struct {
+	Document
+
+	// The document's embedding; omitted if no vector is stored
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// States of a reindex job
+const (
+	ReindexIdle      = "idle" // no job has run since the server started
+	ReindexRunning   = "running"
+	ReindexCompleted = "completed"
+	ReindexFailed    = "failed"
+)
+
+// ReindexStatus reports the progress of the running or last reindex job
+// swagger:model ReindexStatus
+type ReindexStatus struct {
+	// idle, running, completed, or failed
+	// required: true
+	State string `json:"state"`
+
+	// Documents embedded with the current model so far
+	Done int `json:"done"`
+
+	// Documents to embed; grows when documents are added while the job runs
+	Total int `json:"total"`
+
+	// The user who started the job
+	StartedBy string `json:"started_by,omitempty"`
+
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+
+	// Why the job failed
+	Error string `json:"error,omitempty"`
 }
 
 // PermissionsResponse represents the user's permissions
@@ -70,6 +527,347 @@ type PermissionsResponse struct {
 	Permissions []string `json:"permissions"`
 }
 
+// PermissionChangeRequest grants or revokes a relation
+// swagger:model PermissionChangeRequest
+type PermissionChangeRequest struct {
+	// The user receiving or losing the relation; omitted if group is set
+	User string `json:"user,omitempty"`
+
+	// The group whose members receive or lose the relation; omitted if user is set
+	Group string `json:"group,omitempty"`
+
+	// The relation: "viewer" or "editor" on a document, or "write" on the corpus
+	// required: true
+	Relation string `json:"relation"`
+
+	// The document the relation applies to; omitted for "write"
+	DocumentID string `json:"document_id,omitempty"`
+}
+
+// PermissionChangeResponse represents the response after granting or revoking a relation
+// swagger:model PermissionChangeResponse
+type PermissionChangeResponse struct {
+	// The user the relation was changed for
+	User string `json:"user,omitempty"`
+
+	// The group the relation was changed for
+	Group string `json:"group,omitempty"`
+
+	// The changed relation
+	// required: true
+	Relation string `json:"relation"`
+
+	// The Keto object: a document ID or "corpus"
+	// required: true
+	Object string `json:"object"`
+
+	// Success message
+	// required: true
+	Message string `json:"message"`
+}
+
+// RelationTuple is a relation a user, or a group's members, hold on a document or the corpus
+// swagger:model RelationTuple
+type RelationTuple struct {
+	// The user holding the relation
+	User string `json:"user,omitempty"`
+
+	// The group whose members hold the relation
+	Group string `json:"group,omitempty"`
+
+	// The relation: "viewer", "editor", or "write"
+	// required: true
+	Relation string `json:"relation"`
+
+	// The Keto object: a document ID or "corpus"
+	// required: true
+	Object string `json:"object"`
+}
+
+// BulkPermissionRequest grants many relations at once
+// swagger:model BulkPermissionRequest
+type BulkPermissionRequest struct {
+	// The relations to grant
+	// required: true
+	Grants []PermissionChangeRequest `json:"grants"`
+}
+
+// BulkPermissionFailure is a grant of a bulk request that was not made
+// swagger:model BulkPermissionFailure
+type BulkPermissionFailure struct {
+	// The position of the grant in the request
+	// required: true
+	Index int `json:"index"`
+
+	// The grant as requested
+	// required: true
+	Grant PermissionChangeRequest `json:"grant"`
+
+	// Why the grant failed
+	// required: true
+	Error string `json:"error"`
+}
+
+// BulkPermissionResponse represents the outcome of a bulk grant
+// swagger:model BulkPermissionResponse
+type BulkPermissionResponse struct {
+	// Relations granted
+	// required: true
+	Granted []RelationTuple `json:"granted"`
+
+	// Grants that were invalid or could not be written
+	// required: true
+	Failed []BulkPermissionFailure `json:"failed"`
+
+	// Summary message
+	// required: true
+	Message string `json:"message"`
+}
+
+// RemovedRelation is a relation deleted when a user was offboarded, in the
+// permission backend's terms
+// swagger:model RemovedRelation
+type RemovedRelation struct {
+	// The namespace of the relation, e.g. of documents or groups
+	// required: true
+	Namespace string `json:"namespace"`
+
+	// The object: a document ID, "corpus", a group, or an attribute value
+	// required: true
+	Object string `json:"object"`
+
+	// The relation, e.g. "viewer" or "member"
+	// required: true
+	Relation string `json:"relation"`
+}
+
+// UserOffboardResponse represents the relations removed from a user
+// swagger:model UserOffboardResponse
+type UserOffboardResponse struct {
+	// The offboarded user
+	// required: true
+	User string `json:"user"`
+
+	// The removed relations
+	// required: true
+	Removed []RemovedRelation `json:"removed"`
+
+	// Whether the user's cached permission decisions were dropped
+	CacheInvalidated bool `json:"cache_invalidated"`
+
+	// Success message
+	// required: true
+	Message string `json:"message"`
+}
+
+// PolicyResponse represents the changes made to reconcile a permission policy
+// swagger:model PolicyResponse
+type PolicyResponse struct {
+	// Relations granted because the policy lists them
+	// required: true
+	Granted []RelationTuple `json:"granted"`
+
+	// Relations revoked because the policy prunes them
+	// required: true
+	Revoked []RelationTuple `json:"revoked"`
+
+	// Whether the changes were only planned, not made
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Success message
+	// required: true
+	Message string `json:"message"`
+}
+
+// RedTeamRequest asks for adversarial queries on behalf of a user who must not
+// see the given documents
+// swagger:model RedTeamRequest
+type RedTeamRequest struct {
+	// The user whose permissions the queries run with
+	// required: true
+	User string `json:"user"`
+
+	// The documents the user must not see
+	// required: true
+	ForbiddenDocumentIDs []string `json:"forbidden_document_ids"`
+
+	// Additional questions asked after the built-in adversarial ones
+	Questions []string `json:"questions,omitempty"`
+
+	// Number of documents retrieved per question
+	TopK int `json:"top_k,omitempty"`
+
+	// Only check retrieval, without generating answers
+	RetrievalOnly bool `json:"retrieval_only,omitempty"`
+}
+
+// RedTeamReport is the outcome of a red team run
+// swagger:model RedTeamReport
+type RedTeamReport struct {
+	// The user the queries ran as
+	// required: true
+	User string `json:"user"`
+
+	// Whether no forbidden content was found and every query could be run
+	// required: true
+	Passed bool `json:"passed"`
+
+	// Forbidden documents the user holds a relation on, so they leak by design
+	DirectAccess []string `json:"direct_access,omitempty"`
+
+	// Number of queries run
+	// required: true
+	Queries int `json:"queries"`
+
+	// Number of leaks across all queries
+	// required: true
+	Leaks int `json:"leaks"`
+
+	// Number of queries that failed
+	// required: true
+	Errors int `json:"errors"`
+
+	// The outcome of every query
+	// required: true
+	Results []RedTeamResult `json:"results"`
+}
+
+// RedTeamResult is the outcome of a single adversarial query
+// swagger:model RedTeamResult
+type RedTeamResult struct {
+	// The attack technique, e.g. "instruction-override" or "title-lookup"
+	// required: true
+	Technique string `json:"technique"`
+
+	// required: true
+	Question string `json:"question"`
+
+	// The IDs of the retrieved documents
+	// required: true
+	Sources []string `json:"sources"`
+
+	// The generated answer; empty for retrieval-only runs
+	Answer string `json:"answer,omitempty"`
+
+	// Forbidden content found in the sources or the answer
+	Leaks []RedTeamLeak `json:"leaks,omitempty"`
+
+	// Why the query could not be run
+	Error string `json:"error,omitempty"`
+}
+
+// RedTeamLeak is forbidden content shown to the user
+// swagger:model RedTeamLeak
+type RedTeamLeak struct {
+	// The forbidden document, for a document among the sources or a term taken from it
+	// required: true
+	DocumentID string `json:"document_id"`
+
+	// The forbidden term; empty if the document itself was retrieved
+	Term string `json:"term,omitempty"`
+
+	// Where the content leaked: "sources" or "answer"
+	// required: true
+	Where string `json:"where"`
+}
+
+// GroupResponse represents a group's members and the relations granted to them
+// swagger:model GroupResponse
+type GroupResponse struct {
+	// The group name
+	// required: true
+	Group string `json:"group"`
+
+	// The direct members of the group
+	// required: true
+	Members []string `json:"members"`
+
+	// The relations the members hold through the group
+	// required: true
+	Relations []RelationTuple `json:"relations"`
+}
+
+// GroupMemberResponse represents the response after adding or removing a group member
+// swagger:model GroupMemberResponse
+type GroupMemberResponse struct {
+	// The group name
+	// required: true
+	Group string `json:"group"`
+
+	// The added or removed user
+	// required: true
+	User string `json:"user"`
+
+	// Success message
+	// required: true
+	Message string `json:"message"`
+}
+
+// UsageResponse reports the storage consumed by the user and the tenant
+// swagger:model UsageResponse
+type UsageResponse struct {
+	// The authenticated user
+	// required: true
+	User string `json:"user"`
+
+	// The documents the user has ingested in the tenant
+	// required: true
+	Usage Usage `json:"usage"`
+
+	// The user's quota; omitted if unlimited
+	Limits *QuotaLimits `json:"limits,omitempty"`
+
+	// All documents of the tenant; only reported to users with the write relation
+	TenantUsage *Usage `json:"tenant_usage,omitempty"`
+
+	// The tenant's quota; omitted if unlimited
+	TenantLimits *QuotaLimits `json:"tenant_limits,omitempty"`
+
+	// The queries the user has made in the tenant since the server started;
+	// omitted if query accounting is disabled
+	Queries *QueryTotals `json:"queries,omitempty"`
+}
+
+// QueryTotals aggregate the usage of a user's queries
+// swagger:model QueryTotals
+type QueryTotals struct {
+	// Number of answered queries, including cached ones
+	// required: true
+	Queries int64 `json:"queries"`
+
+	// Number of queries answered from the query cache
+	CachedQueries int64 `json:"cached_queries"`
+
+	EmbeddingTokens int64 `json:"embedding_tokens"`
+	PromptTokens    int64 `json:"prompt_tokens"`
+	ResponseTokens  int64 `json:"response_tokens"`
+
+	// Total wall-clock time spent answering the queries in seconds
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Usage is the storage consumed by a set of documents
+// swagger:model Usage
+type Usage struct {
+	// Number of documents, not counting trashed ones
+	// required: true
+	Documents int `json:"documents"`
+
+	// Total size of the document contents in bytes
+	// required: true
+	ContentBytes int64 `json:"content_bytes"`
+}
+
+// QuotaLimits bound the storage of a user or tenant
+// swagger:model QuotaLimits
+type QuotaLimits struct {
+	// Maximum number of documents; omitted if unlimited
+	MaxDocuments int `json:"max_documents,omitempty"`
+
+	// Maximum total content size in bytes; omitted if unlimited
+	MaxContentBytes int64 `json:"max_content_bytes,omitempty"`
+}
+
 // HealthResponse represents the health check response
 // swagger:model HealthResponse
 type HealthResponse struct {
@@ -78,6 +876,29 @@ type HealthResponse struct {
 	Status string `json:"status"`
 }
 
+// ReadinessResponse represents the readiness probe response
+// swagger:model ReadinessResponse
+type ReadinessResponse struct {
+	// Overall readiness status ("ready" or "unavailable")
+	// required: true
+	Status string `json:"status"`
+
+	// Status of each checked dependency
+	// required: true
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// DependencyStatus represents the health of a single dependency
+// swagger:model DependencyStatus
+type DependencyStatus struct {
+	// Dependency status ("up" or "down")
+	// required: true
+	Status string `json:"status"`
+
+	// Error message if the dependency is down
+	Error string `json:"error,omitempty"`
+}
+
 // ErrorResponse represents an API error response
 // swagger:model ErrorResponse
 type ErrorResponse struct {
@@ -85,3 +906,195 @@ type ErrorResponse struct {
 	// required: true
 	Error string `json:"error"`
 }
+
+// RekeyDatabaseRequest re-encrypts the database with a new key
+// swagger:model RekeyDatabaseRequest
+type RekeyDatabaseRequest struct {
+	// The new SQLCipher key
+	// required: true
+	Key string `json:"key"`
+}
+
+// RekeyDatabaseResponse reports a completed rekey
+// swagger:model RekeyDatabaseResponse
+type RekeyDatabaseResponse struct {
+	// required: true
+	RekeyedAt time.Time `json:"rekeyed_at"`
+
+	// required: true
+	Message string `json:"message"`
+}
+
+// ConfigResponse represents the effective configuration of the server
+// swagger:model ConfigResponse
+type ConfigResponse struct {
+	// Effective settings keyed like the config file, with secrets redacted
+	// required: true
+	Config map[string]interface{} `json:"config"`
+
+	// Settings applied without a restart when the config file changes
+	// required: true
+	Reloadable []string `json:"reloadable"`
+
+	// When the configuration was last loaded or reloaded
+	// required: true
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// StatsResponse summarizes the corpus of a tenant and who can access it
+// swagger:model StatsResponse
+type StatsResponse struct {
+	// The tenant the statistics describe
+	// required: true
+	Tenant string `json:"tenant"`
+
+	// The tenant's documents
+	// required: true
+	Documents Usage `json:"documents"`
+
+	// Document counts by metadata value, per key sorted by name
+	// required: true
+	Facets []FacetStats `json:"facets"`
+
+	// The stored vectors
+	// required: true
+	Embedding EmbeddingStats `json:"embedding"`
+
+	// Size of the document store on disk across all tenants in bytes;
+	// omitted if it is not persisted or the caller is no server admin
+	StorageBytes int64 `json:"storage_bytes,omitempty"`
+
+	// Documents each user may read, sorted by user
+	// required: true
+	Users []UserAccessStats `json:"users"`
+
+	// Whether users were left out because there are more than can be checked
+	UsersTruncated bool `json:"users_truncated,omitempty"`
+
+	// Queries made in the tenant since the server started; omitted if query
+	// accounting is disabled
+	Queries *QueryVolume `json:"queries,omitempty"`
+
+	// Relation tuples of the tenant; omitted if the permission service
+	// cannot count them
+	Relations *RelationStats `json:"relations,omitempty"`
+
+	// When the statistics were computed
+	// required: true
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// FacetStats counts the documents per value of a metadata key
+// swagger:model FacetStats
+type FacetStats struct {
+	// required: true
+	Key string `json:"key"`
+
+	// Number of distinct values
+	// required: true
+	Distinct int `json:"distinct"`
+
+	// The most frequent values, most documents first
+	// required: true
+	Values []FacetValue `json:"values"`
+}
+
+// FacetValue is a metadata value and the number of documents carrying it
+// swagger:model FacetValue
+type FacetValue struct {
+	// required: true
+	Value string `json:"value"`
+
+	// required: true
+	Documents int `json:"documents"`
+}
+
+// DocumentStatsResponse aggregates the metadata of the documents a user may
+// access
+// swagger:model DocumentStatsResponse
+type DocumentStatsResponse struct {
+	// The user whose accessible documents were aggregated
+	// required: true
+	User string `json:"user"`
+
+	// Exact-match metadata filters the documents matched
+	Filters map[string]string `json:"filters,omitempty"`
+
+	// The metadata key the groups are formed by; omitted without grouping
+	GroupBy string `json:"group_by,omitempty"`
+
+	// Number of matching documents; the chunks of one upload count once
+	// required: true
+	Documents int `json:"documents"`
+
+	// Totals of the numeric values of each summed metadata key
+	Sums map[string]float64 `json:"sums,omitempty"`
+
+	// Aggregates per value of group_by, most documents first; omitted
+	// without grouping
+	Groups []DocumentStatsGroup `json:"groups,omitempty"`
+
+	// Whether groups were left out because there are too many
+	GroupsTruncated bool `json:"groups_truncated,omitempty"`
+}
+
+// DocumentStatsGroup aggregates the documents sharing a metadata value
+// swagger:model DocumentStatsGroup
+type DocumentStatsGroup struct {
+	// The metadata value; null for documents without it
+	// required: true
+	Value interface{} `json:"value"`
+
+	// required: true
+	Documents int `json:"documents"`
+
+	// Totals of the numeric values of each summed metadata key
+	Sums map[string]float64 `json:"sums,omitempty"`
+}
+
+// EmbeddingStats describes the stored vectors
+// swagger:model EmbeddingStats
+type EmbeddingStats struct {
+	// Model the vectors were embedded with; omitted if unknown
+	Model string `json:"model,omitempty"`
+
+	// Length of the vectors; 0 while none are stored
+	// required: true
+	Dimensions int `json:"dimensions"`
+}
+
+// UserAccessStats counts the documents a user may read
+// swagger:model UserAccessStats
+type UserAccessStats struct {
+	// required: true
+	User string `json:"user"`
+
+	// required: true
+	AccessibleDocuments int `json:"accessible_documents"`
+}
+
+// QueryVolume aggregates the queries of all users of a tenant
+// swagger:model QueryVolume
+type QueryVolume struct {
+	QueryTotals
+
+	// Number of users who made queries
+	// required: true
+	Users int `json:"users"`
+}
+
+// RelationStats counts the relation tuples of a tenant
+// swagger:model RelationStats
+type RelationStats struct {
+	// Total relation tuples on documents and the corpus
+	// required: true
+	Tuples int `json:"tuples"`
+
+	// Tuples per relation, e.g. viewer, editor, and write
+	// required: true
+	ByRelation map[string]int `json:"by_relation"`
+
+	// Group membership tuples
+	// required: true
+	Memberships int `json:"memberships"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLink grants read access to a document to whoever presents its token
+// until it expires. Only the link is stored; the token is returned once when
+// it is created.
+// swagger:model ShareLink
+type ShareLink struct {
+	// The unique identifier of the link
+	// required: true
+	ID uuid.UUID `json:"id"`
+
+	// The shared document
+	// required: true
+	DocumentID uuid.UUID `json:"document_id"`
+
+	// The user who created the link
+	// required: true
+	CreatedBy string `json:"created_by"`
+
+	TenantID  string    `json:"tenant_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateShareLinkRequest represents the optional request body for sharing a document
+// swagger:model CreateShareLinkRequest
+type CreateShareLinkRequest struct {
+	// Seconds until the link expires; the server's default if zero
+	ExpiresIn int `json:"expires_in,omitempty"`
+}
+
+// CreateShareLinkResponse holds a new share link. The token cannot be retrieved again.
+// swagger:model CreateShareLinkResponse
+type CreateShareLinkResponse struct {
+	ShareLink
+	// The secret to send in the X-Share-Token header or share_token query parameter
+	Token string `json:"token"`
+	// The document's path with the token as query parameter
+	URL string `json:"url"`
+}
@@ -0,0 +1,103 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"sort"
+	"time"
+)
+
+// HTTPModerator calls an external moderation API that follows the
+// request/response shape of OpenAI's moderation endpoint and compatible
+// self-hosted servers:
+//
+//	POST {"model": "...", "input": "..."}
+//	=> {"results": [{"flagged": true, "categories": {"violence": true}}]}
+type HTTPModerator struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+// NewHTTPModerator creates a moderator for the moderation endpoint at url.
+// apiKey is sent as a bearer token when non-empty.
+func NewHTTPModerator(url, model, apiKey string, timeout time.Duration) *HTTPModerator {
+	return &HTTPModerator{
+		url:    url,
+		model:  model,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Moderate sends text in one request. The text is flagged if any result is,
+// under the categories set in any result, sorted by name.
+func (h *HTTPModerator) Moderate(ctx context.Context, text string) (Verdict, error) {
+	payload := map[string]interface{}{"input": text}
+	if h.model != "" {
+		payload["model"] = h.model
+	}
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+	requestid.SetHeader(ctx, req)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Verdict{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Verdict{}, fmt.Errorf("moderation API returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return Verdict{}, err
+	}
+	if len(result.Results) == 0 {
+		return Verdict{}, fmt.Errorf("moderation API returned no results")
+	}
+
+	var verdict Verdict
+	categories := make(map[string]bool)
+	for _, r := range result.Results {
+		verdict.Flagged = verdict.Flagged || r.Flagged
+		for category, set := range r.Categories {
+			if set {
+				categories[category] = true
+			}
+		}
+	}
+	for category := range categories {
+		verdict.Categories = append(verdict.Categories, category)
+	}
+	sort.Strings(verdict.Categories)
+	return verdict, nil
+}
@@ -0,0 +1,100 @@
+// Package moderation checks questions and answers against a content policy,
+// either with local keyword rules or with an external moderation API, so
+// that disallowed content from sensitive documents is not handed out.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Action decides what happens to content a moderator flags
+type Action string
+
+const (
+	// ActionBlock withholds the flagged question or answer
+	ActionBlock Action = "block"
+	// ActionFlag lets flagged content through and only records it in the audit log
+	ActionFlag Action = "flag"
+	// ActionAnnotate lets flagged content through, records it in the audit
+	// log, and tells the user which categories it was flagged for
+	ActionAnnotate Action = "annotate"
+)
+
+// IsValid reports whether a is a supported action
+func (a Action) IsValid() bool {
+	return a == ActionBlock || a == ActionFlag || a == ActionAnnotate
+}
+
+// Verdict is the outcome of moderating a text
+type Verdict struct {
+	Flagged bool
+	// Categories lists the policy categories the text was flagged for, in
+	// the order the moderator reports them
+	Categories []string
+}
+
+// Moderator decides whether text violates the content policy
+type Moderator interface {
+	Moderate(ctx context.Context, text string) (Verdict, error)
+}
+
+// Rule flags texts matching its pattern under its category
+type Rule struct {
+	Category string
+	re       *regexp.Regexp
+}
+
+// NewRule returns a rule flagging texts containing any of keywords as whole
+// words, ignoring case, or matching pattern. Either may be empty, not both.
+func NewRule(category string, keywords []string, pattern string) (Rule, error) {
+	if category == "" {
+		return Rule{}, fmt.Errorf("moderation rule category is required")
+	}
+	var alternatives []string
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			alternatives = append(alternatives, `(?i:\b`+regexp.QuoteMeta(keyword)+`\b)`)
+		}
+	}
+	if pattern != "" {
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+	if len(alternatives) == 0 {
+		return Rule{}, fmt.Errorf("moderation rule %q needs keywords or a pattern", category)
+	}
+	re, err := regexp.Compile(strings.Join(alternatives, "|"))
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid pattern of moderation rule %q: %w", category, err)
+	}
+	return Rule{Category: category, re: re}, nil
+}
+
+// KeywordModerator flags texts matching local rules without calling out
+type KeywordModerator struct {
+	rules []Rule
+}
+
+// NewKeywordModerator returns a moderator applying rules
+func NewKeywordModerator(rules []Rule) *KeywordModerator {
+	return &KeywordModerator{rules: rules}
+}
+
+// Moderate flags text under the category of every rule it matches; a
+// category is reported once even if several of its rules match
+func (k *KeywordModerator) Moderate(_ context.Context, text string) (Verdict, error) {
+	var verdict Verdict
+	for _, rule := range k.rules {
+		if !rule.re.MatchString(text) {
+			continue
+		}
+		verdict.Flagged = true
+		if !slices.Contains(verdict.Categories, rule.Category) {
+			verdict.Categories = append(verdict.Categories, rule.Category)
+		}
+	}
+	return verdict, nil
+}
@@ -0,0 +1,88 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestKeywordModerator(t *testing.T) {
+	weapons, err := NewRule("weapons", []string{"Explosive", "detonator"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fraud, err := NewRule("fraud", nil, `(?i)hide\s+income`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moderator := NewKeywordModerator([]Rule{weapons, fraud})
+
+	tests := []struct {
+		text       string
+		categories []string
+	}{
+		{"How do I build an explosive detonator?", []string{"weapons"}},
+		{"Can I hide  income from an EXPLOSIVE business?", []string{"weapons", "fraud"}},
+		{"What were my refunds in 2023?", nil},
+		// Keywords match whole words only
+		{"Explosiveness of the market", nil},
+	}
+	for _, tt := range tests {
+		verdict, err := moderator.Moderate(context.Background(), tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verdict.Flagged != (tt.categories != nil) || !slices.Equal(verdict.Categories, tt.categories) {
+			t.Errorf("%q: expected categories %v, got %+v", tt.text, tt.categories, verdict)
+		}
+	}
+
+	if _, err := NewRule("empty", []string{" "}, ""); err == nil {
+		t.Error("Expected a rule without keywords or pattern to be rejected")
+	}
+	if _, err := NewRule("broken", nil, "("); err == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestHTTPModerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		var req struct {
+			Model string `json:"model"`
+			Input string `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "moderation-model" {
+			t.Errorf("Unexpected request: %+v", req)
+		}
+		if req.Input == "fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		flagged := req.Input != "Refunds in 2023?"
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"results": []map[string]interface{}{{
+			"flagged":    flagged,
+			"categories": map[string]bool{"violence": flagged, "self-harm": flagged, "hate": false},
+		}}})
+	}))
+	defer server.Close()
+
+	moderator := NewHTTPModerator(server.URL, "moderation-model", "secret", time.Second)
+	verdict, err := moderator.Moderate(context.Background(), "How do I hurt someone?")
+	if err != nil || !verdict.Flagged || !slices.Equal(verdict.Categories, []string{"self-harm", "violence"}) {
+		t.Errorf("Expected a flagged verdict with sorted categories, got %+v (%v)", verdict, err)
+	}
+	if verdict, err = moderator.Moderate(context.Background(), "Refunds in 2023?"); err != nil || verdict.Flagged {
+		t.Errorf("Expected an unflagged verdict, got %+v (%v)", verdict, err)
+	}
+	if _, err := moderator.Moderate(context.Background(), "fail"); err == nil {
+		t.Error("Expected an error status to fail moderation")
+	}
+}
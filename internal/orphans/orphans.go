@@ -0,0 +1,230 @@
+// Package orphans finds documents that nobody can reach any more because all
+// of their permission relations were removed, and flags, quarantines, or
+// deletes them together with their vectors.
+package orphans
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"time"
+)
+
+// Policy decides what happens to an orphaned document
+type Policy string
+
+const (
+	// PolicyFlag marks orphaned documents with the FlagKey metadata field
+	PolicyFlag Policy = "flag"
+	// PolicyQuarantine moves orphaned documents to the trash, where they stay
+	// restorable until the trash is purged
+	PolicyQuarantine Policy = "quarantine"
+	// PolicyDelete deletes orphaned documents and their vectors for good
+	PolicyDelete Policy = "delete"
+)
+
+// FlagKey is the metadata field holding the time a document was found orphaned
+const FlagKey = "orphaned_at"
+
+// pageSize is the number of documents checked per store query
+const pageSize = 100
+
+// IsValid reports whether p is a supported policy
+func (p Policy) IsValid() bool {
+	return p == PolicyFlag || p == PolicyQuarantine || p == PolicyDelete
+}
+
+// Reaper applies a policy to the documents left without relations
+type Reaper struct {
+	store    storage.VectorStore
+	tenants  storage.TenantLister
+	checker  permissions.OrphanChecker
+	policy   Policy
+	minAge   time.Duration
+	notifier webhooks.Notifier // optional
+	now      func() time.Time
+}
+
+// Option configures optional Reaper behavior
+type Option func(*Reaper)
+
+// WithNotifier publishes a document.deleted event for every document that is
+// quarantined or deleted
+func WithNotifier(n webhooks.Notifier) Option {
+	return func(r *Reaper) {
+		r.notifier = n
+	}
+}
+
+// NewReaper creates a reaper that checks the documents of all tenants in
+// store, which must be the unscoped store, with checker. Documents younger
+// than minAge are skipped since they may not have been shared yet.
+func NewReaper(store storage.VectorStore, tenants storage.TenantLister, checker permissions.OrphanChecker, policy Policy, minAge time.Duration, opts ...Option) *Reaper {
+	r := &Reaper{
+		store:   store,
+		tenants: tenants,
+		checker: checker,
+		policy:  policy,
+		minAge:  minAge,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run reaps orphaned documents immediately and then every interval until ctx
+// is done
+func (r *Reaper) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if reaped, err := r.RunOnce(ctx); err != nil {
+			log.Printf("Orphan reaper run failed after handling %d documents: %v", reaped, err)
+		} else if reaped > 0 {
+			log.Printf("Orphan reaper run: applied policy %q to %d orphaned documents", r.policy, reaped)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RunOnce applies the policy to every orphaned document older than the
+// minimum age and returns how many were handled. It stops at the first
+// document that cannot be checked or handled, which is retried on the next
+// run.
+func (r *Reaper) RunOnce(ctx context.Context) (int, error) {
+	tenantIDs, err := r.tenants.Tenants()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	cutoff := r.now().Add(-r.minAge)
+	reaped := 0
+	for _, tenantID := range tenantIDs {
+		n, err := r.reapTenant(tenant.NewContext(ctx, tenantID), r.store.ForTenant(tenantID), cutoff)
+		reaped += n
+		if err != nil {
+			return reaped, err
+		}
+	}
+	return reaped, nil
+}
+
+// reapTenant checks the tenant's documents created before cutoff, oldest first
+func (r *Reaper) reapTenant(ctx context.Context, store storage.VectorStore, cutoff time.Time) (int, error) {
+	reaped := 0
+	offset := 0
+	for {
+		docs, err := store.ListDocuments(storage.ListOptions{Limit: pageSize, Offset: offset, SortBy: storage.SortByCreatedAt})
+		if err != nil {
+			return reaped, fmt.Errorf("failed to list documents of tenant %s: %w", tenant.FromContext(ctx), err)
+		}
+		removed := 0
+		for i := range docs {
+			doc := &docs[i]
+			if !doc.CreatedAt.Before(cutoff) || ctx.Err() != nil {
+				return reaped, ctx.Err()
+			}
+			orphaned, err := r.checker.Orphaned(ctx, doc)
+			if err != nil {
+				return reaped, fmt.Errorf("failed to check relations of document %s: %w", doc.ID, err)
+			}
+			handled, err := r.apply(ctx, store, doc, orphaned)
+			if err != nil {
+				return reaped, err
+			}
+			if handled {
+				reaped++
+				if r.policy != PolicyFlag {
+					removed++
+				}
+			}
+		}
+		if len(docs) < pageSize {
+			return reaped, nil
+		}
+		offset += len(docs) - removed
+	}
+}
+
+// apply handles doc under the policy, logging an audit entry for the outcome.
+// It reports whether an orphaned document was newly flagged or removed.
+func (r *Reaper) apply(ctx context.Context, store storage.VectorStore, doc *models.Document, orphaned bool) (bool, error) {
+	_, flagged := doc.Metadata[FlagKey]
+	tenantID := tenant.FromContext(ctx)
+
+	switch {
+	case !orphaned && flagged:
+		// Relations were granted again since the document was flagged
+		metadata := copyMetadata(doc.Metadata)
+		delete(metadata, FlagKey)
+		if err := r.update(store, doc, metadata, "unflag"); err != nil {
+			return false, err
+		}
+		log.Printf("AUDIT orphaned document unflagged: id=%s tenant=%s title=%q", doc.ID, tenantID, doc.Title)
+		return false, nil
+	case !orphaned || (r.policy == PolicyFlag && flagged):
+		return false, nil
+	}
+
+	switch r.policy {
+	case PolicyFlag:
+		metadata := copyMetadata(doc.Metadata)
+		metadata[FlagKey] = r.now().UTC().Format(time.RFC3339)
+		if err := r.update(store, doc, metadata, "flag"); err != nil {
+			return false, err
+		}
+	case PolicyQuarantine:
+		trash, ok := store.(storage.Trash)
+		if !ok {
+			return false, fmt.Errorf("document store does not support quarantining orphaned documents")
+		}
+		if err := trash.TrashDocument(doc.ID); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+			log.Printf("AUDIT orphaned document reaping failed: id=%s tenant=%s policy=%s error=%q", doc.ID, tenantID, r.policy, err)
+			return false, fmt.Errorf("failed to quarantine orphaned document %s: %w", doc.ID, err)
+		}
+	case PolicyDelete:
+		if err := store.DeleteDocument(doc.ID); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+			log.Printf("AUDIT orphaned document reaping failed: id=%s tenant=%s policy=%s error=%q", doc.ID, tenantID, r.policy, err)
+			return false, fmt.Errorf("failed to delete orphaned document %s: %w", doc.ID, err)
+		}
+	default:
+		return false, fmt.Errorf("unsupported orphan policy: %s", r.policy)
+	}
+
+	log.Printf("AUDIT orphaned document reaped: id=%s tenant=%s title=%q created_at=%s policy=%s",
+		doc.ID, tenantID, doc.Title, doc.CreatedAt.Format(time.RFC3339), r.policy)
+	if r.notifier != nil && r.policy != PolicyFlag {
+		r.notifier.Notify(ctx, webhooks.DocumentDeleted, webhooks.DocumentData{ID: doc.ID, Title: doc.Title, Metadata: doc.Metadata})
+	}
+	return true, nil
+}
+
+// update stores doc with metadata, keeping its vector
+func (r *Reaper) update(store storage.VectorStore, doc *models.Document, metadata map[string]interface{}, action string) error {
+	updated := *doc
+	updated.Metadata = metadata
+	updated.Embedding = nil
+	if err := store.UpdateDocument(&updated); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+		return fmt.Errorf("failed to %s orphaned document %s: %w", action, doc.ID, err)
+	}
+	return nil
+}
+
+// copyMetadata returns a shallow copy of metadata that is safe to modify
+func copyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,146 @@
+package orphans
+
+import (
+	"context"
+	"errors"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeChecker reports the documents in orphans as orphaned and records the
+// tenant each document was checked in
+type fakeChecker struct {
+	orphans map[uuid.UUID]bool
+	checked map[uuid.UUID]string
+	err     error
+}
+
+func (f *fakeChecker) Orphaned(ctx context.Context, doc *models.Document) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	f.checked[doc.ID] = tenant.FromContext(ctx)
+	return f.orphans[doc.ID], nil
+}
+
+// setupStore adds an orphaned and a shared document to the default tenant
+// and an orphaned document to the acme tenant
+func setupStore(t *testing.T) (*storage.InMemoryVectorStore, *fakeChecker, []*models.Document) {
+	t.Helper()
+	store, _ := storage.NewInMemoryVectorStore("")
+	orphan := &models.Document{Title: "Orphan", Content: "a", Embedding: []float32{1, 0}}
+	shared := &models.Document{Title: "Shared", Content: "b", Embedding: []float32{0, 1}}
+	acme := &models.Document{Title: "Acme orphan", Content: "c", Embedding: []float32{1, 1}}
+	for _, doc := range []*models.Document{orphan, shared} {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.ForTenant("acme").AddDocument(acme); err != nil {
+		t.Fatal(err)
+	}
+	checker := &fakeChecker{
+		orphans: map[uuid.UUID]bool{orphan.ID: true, acme.ID: true},
+		checked: map[uuid.UUID]string{},
+	}
+	return store, checker, []*models.Document{orphan, shared, acme}
+}
+
+func TestReaperFlagsOrphanedDocuments(t *testing.T) {
+	store, checker, docs := setupStore(t)
+	orphan, shared := docs[0], docs[1]
+
+	reaper := NewReaper(store, store, checker, PolicyFlag, 0)
+	reaper.now = func() time.Time { return time.Now().Add(time.Minute) }
+	reaped, err := reaper.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reaped != 2 {
+		t.Fatalf("Expected 2 flagged documents, got %d", reaped)
+	}
+	if checker.checked[docs[2].ID] != "acme" {
+		t.Errorf("Expected documents to be checked in their tenant, got %v", checker.checked)
+	}
+	flagged, err := store.GetDocument(orphan.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := flagged.Metadata[FlagKey]; !ok {
+		t.Errorf("Expected the orphan to be flagged, got %v", flagged.Metadata)
+	}
+	if hits, _ := store.SearchSimilarWithFilter([]float32{1, 0}, 1, func(*models.Document) bool { return true }); len(hits) != 1 || hits[0].ID != orphan.ID {
+		t.Errorf("Expected the flagged orphan to keep its vector, got %+v", hits)
+	}
+	if kept, _ := store.GetDocument(shared.ID); kept.Metadata[FlagKey] != nil {
+		t.Errorf("Expected the shared document not to be flagged, got %v", kept.Metadata)
+	}
+
+	// Flagged documents are not flagged again, and lose the flag once shared
+	if reaped, _ := reaper.RunOnce(context.Background()); reaped != 0 {
+		t.Errorf("Expected already flagged documents to be skipped, got %d", reaped)
+	}
+	delete(checker.orphans, orphan.ID)
+	if _, err := reaper.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if unflagged, _ := store.GetDocument(orphan.ID); unflagged.Metadata[FlagKey] != nil {
+		t.Errorf("Expected the flag to be cleared, got %v", unflagged.Metadata)
+	}
+}
+
+func TestReaperQuarantinesAndDeletesOrphanedDocuments(t *testing.T) {
+	tests := []struct {
+		policy      Policy
+		wantTrashed int
+	}{
+		{PolicyQuarantine, 1},
+		{PolicyDelete, 0},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			store, checker, docs := setupStore(t)
+			reaper := NewReaper(store, store, checker, tt.policy, 0)
+			reaper.now = func() time.Time { return time.Now().Add(time.Minute) }
+			if reaped, err := reaper.RunOnce(context.Background()); err != nil || reaped != 2 {
+				t.Fatalf("Expected 2 reaped documents, got %d (%v)", reaped, err)
+			}
+			if remaining := store.GetAllDocuments(); len(remaining) != 1 || remaining[0].ID != docs[1].ID {
+				t.Errorf("Expected only the shared document to remain, got %+v", remaining)
+			}
+			trash, _ := store.ForTenant("acme").(storage.Trash).ListTrash(storage.ListOptions{Limit: 10})
+			if len(trash) != tt.wantTrashed {
+				t.Errorf("Expected %d trashed documents, got %+v", tt.wantTrashed, trash)
+			}
+		})
+	}
+}
+
+func TestReaperSkipsRecentDocuments(t *testing.T) {
+	store, checker, _ := setupStore(t)
+	reaper := NewReaper(store, store, checker, PolicyDelete, 24*time.Hour)
+	if reaped, err := reaper.RunOnce(context.Background()); err != nil || reaped != 0 {
+		t.Fatalf("Expected recent documents to be skipped, got %d (%v)", reaped, err)
+	}
+	if len(checker.checked) != 0 {
+		t.Errorf("Expected no relation checks, got %v", checker.checked)
+	}
+}
+
+func TestReaperKeepsDocumentsWhenCheckFails(t *testing.T) {
+	store, checker, _ := setupStore(t)
+	checker.err = errors.New("keto unavailable")
+	reaper := NewReaper(store, store, checker, PolicyDelete, 0)
+	reaper.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if reaped, err := reaper.RunOnce(context.Background()); err == nil || reaped != 0 {
+		t.Fatalf("Expected the run to fail without reaping, got %d (%v)", reaped, err)
+	}
+	if docs := store.GetAllDocuments(); len(docs) != 2 {
+		t.Errorf("Expected all documents to be kept, got %d", len(docs))
+	}
+}
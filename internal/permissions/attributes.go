@@ -0,0 +1,177 @@
+package permissions
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"regexp"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strconv"
+)
+
+// AttributeRule grants read access to every document whose metadata
+// Attribute equals a Keto object the user holds Relation on in Namespace. With
+// {Attribute: "taxpayer", Namespace: "taxpayers", Relation: "auditor"}, the
+// tuple taxpayers:John Doe#auditor@carol lets carol read all documents with
+// metadata.taxpayer == "John Doe", including documents ingested later.
+type AttributeRule struct {
+	Attribute string // document metadata key, e.g. "taxpayer"
+	Namespace string // base Keto namespace of the attribute values; tenants get a suffixed copy
+	Relation  string // relation on the attribute value that grants read access, e.g. "auditor"
+}
+
+// namespacePattern restricts rule namespaces to names Keto accepts
+var namespacePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Validate checks that the rule is complete and does not reuse a namespace of
+// this service under naming
+func (r AttributeRule) Validate(naming Naming) error {
+	if r.Attribute == "" || r.Relation == "" {
+		return fmt.Errorf("attribute rule needs an attribute and a relation")
+	}
+	if !namespacePattern.MatchString(r.Namespace) {
+		return fmt.Errorf("invalid attribute rule namespace %q: use lowercase letters, digits, and '_'", r.Namespace)
+	}
+	if r.Namespace == naming.DocumentsNamespace || r.Namespace == naming.GroupsNamespace {
+		return fmt.Errorf("attribute rule namespace %q is reserved", r.Namespace)
+	}
+	return nil
+}
+
+// SetAttributeRules configures the rules that grant read access through
+// document metadata in addition to per-document relations. It must be called
+// before the service handles requests.
+func (k *KetoPermissionService) SetAttributeRules(rules []AttributeRule) {
+	k.rules = rules
+}
+
+// applyAttributeRules sets allowed[i] for the denied documents a rule grants
+// access to. Each attribute value is checked once per call, so a batch of
+// chunks from the same taxpayer costs a single Keto check.
+func (k *KetoPermissionService) applyAttributeRules(ctx context.Context, p Principal, docs []models.Document, allowed []bool) {
+	if len(k.rules) == 0 {
+		return
+	}
+
+	decided := make(map[AttributeRule]map[string]bool, len(k.rules))
+	for i := range docs {
+		if allowed[i] {
+			continue
+		}
+		for _, rule := range k.rules {
+			value, ok := docs[i].Metadata[rule.Attribute].(string)
+			if !ok || value == "" {
+				continue
+			}
+			if decided[rule] == nil {
+				decided[rule] = make(map[string]bool)
+			}
+			granted, seen := decided[rule][value]
+			if !seen {
+				granted, _ = k.check(ctx, rule.Namespace, p, value, rule.Relation)
+				decided[rule][value] = granted
+			}
+			if granted {
+				allowed[i] = true
+				break
+			}
+		}
+	}
+}
+
+// attributeObjects lists the attribute values the user holds a rule's
+// relation on, as "<namespace>:<value>"
+func (k *KetoPermissionService) attributeObjects(ctx context.Context, username string) []string {
+	var objects []string
+	for _, rule := range k.rules {
+		params := url.Values{}
+		params.Add("namespace", tenant.Namespace(ctx, rule.Namespace))
+		params.Add("relation", rule.Relation)
+		params.Add("subject_id", k.naming.subject(username))
+
+		raw, err := k.listTuples(ctx, params)
+		if err != nil {
+			requestid.Logf(ctx, "Error listing %s relations of user %s: %v", rule.Namespace, username, err)
+			continue
+		}
+		for _, rt := range raw {
+			objects = append(objects, rule.Namespace+":"+rt.Object)
+		}
+	}
+	return objects
+}
+
+// AttributeGrants lists, for every attribute rule, the attribute values the
+// principal holds the rule's relation on directly or through a group it is
+// a member of or claims, once per request. Relations Keto derives otherwise
+// are not listed; BatchCheck still finds them. It reports false if a listing
+// fails or a rule grants more values than the list limit, which would make
+// the predicate too large to be worth it.
+func (k *KetoPermissionService) AttributeGrants(ctx context.Context, p Principal) ([]AttributeGrant, bool) {
+	if len(k.rules) == 0 {
+		return nil, true
+	}
+	var subjects []url.Values
+	var grants []AttributeGrant
+	for _, rule := range k.rules {
+		key := "grants:" + tenant.Namespace(ctx, rule.Namespace) + "#" + rule.Relation + "@" + k.naming.subject(p.Username)
+		values, ok := memoizedList(ctx, key)
+		if !ok {
+			if subjects == nil {
+				var err error
+				if subjects, err = k.subjects(ctx, p); err != nil {
+					requestid.Logf(ctx, "Failed to list the groups of %s, checking attribute rules per document: %v", p.Username, err)
+					return nil, false
+				}
+			}
+			values = k.listAttributeValues(ctx, rule, subjects)
+			memoizeList(ctx, key, values)
+		}
+		if values == nil {
+			return nil, false
+		}
+		if len(values) > 0 {
+			grants = append(grants, AttributeGrant{Attribute: rule.Attribute, Values: slices.Sorted(maps.Keys(values))})
+		}
+	}
+	return grants, true
+}
+
+// listAttributeValues lists the values of the rule's namespace on which any
+// of subjects holds the rule's relation. It returns nil if there are more
+// than the list limit or a listing fails.
+func (k *KetoPermissionService) listAttributeValues(ctx context.Context, rule AttributeRule, subjects []url.Values) map[string]bool {
+	limit := k.listLimit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	values := make(map[string]bool)
+	for _, subject := range subjects {
+		params := maps.Clone(subject)
+		params.Set("namespace", tenant.Namespace(ctx, rule.Namespace))
+		params.Set("relation", rule.Relation)
+		params.Set("page_size", strconv.Itoa(limit+1))
+		for {
+			page, next, err := k.listTuplesPage(ctx, params)
+			if err != nil {
+				requestid.Logf(ctx, "Failed to list the %s values granted by rule %s#%s, checking them per document: %v", rule.Attribute, rule.Namespace, rule.Relation, err)
+				return nil
+			}
+			for _, rt := range page {
+				values[rt.Object] = true
+			}
+			if len(values) > limit {
+				return nil
+			}
+			if next == "" {
+				break
+			}
+			params.Set("page_token", next)
+		}
+	}
+	return values
+}
@@ -0,0 +1,130 @@
+package permissions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+var auditorRule = AttributeRule{Attribute: "taxpayer", Namespace: "taxpayers", Relation: "auditor"}
+
+func TestAttributeRulesGrantAccessThroughMetadata(t *testing.T) {
+	keto := &fakeKeto{}
+	var attributeChecks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/relation-tuples/check/openapi" && r.URL.Query().Get("namespace") == "taxpayers" {
+			attributeChecks.Add(1)
+		}
+		keto.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	service := newTestKeto(server.URL, FailClosed)
+	service.SetAttributeRules([]AttributeRule{auditorRule})
+	ctx := context.Background()
+	if err := service.putTuple(ctx, relationTuple{Namespace: "taxpayers", Object: "John Doe", Relation: "auditor", SubjectID: "carol"}); err != nil {
+		t.Fatalf("putTuple failed: %v", err)
+	}
+
+	docs := []models.Document{
+		{ID: uuid.New(), Metadata: map[string]interface{}{"taxpayer": "John Doe"}},
+		{ID: uuid.New(), Metadata: map[string]interface{}{"taxpayer": "John Doe"}},
+		{ID: uuid.New(), Metadata: map[string]interface{}{"taxpayer": "ABC Corporation"}},
+		{ID: uuid.New()},
+	}
+	if got := service.BatchCheck(ctx, Principal{Username: "carol"}, docs); !slices.Equal(got, []bool{true, true, false, false}) {
+		t.Errorf("Expected carol to read John Doe's documents, got %v", got)
+	}
+	if n := attributeChecks.Load(); n != 2 {
+		t.Errorf("Expected one check per taxpayer, got %d", n)
+	}
+
+	if !service.CanAccessDocument(ctx, Principal{Username: "carol"}, &docs[0]) {
+		t.Error("Expected CanAccessDocument to apply the rule")
+	}
+	if service.CanAccessDocument(ctx, Principal{Username: "alice"}, &docs[0]) {
+		t.Error("Expected alice, who is no auditor, to be denied")
+	}
+	if service.CanEditDocument(ctx, Principal{Username: "carol"}, &docs[0]) {
+		t.Error("Expected the rule to grant read access only")
+	}
+	if perms := service.GetUserPermissions(ctx, Principal{Username: "carol"}); !slices.Equal(perms, []string{"taxpayers:John Doe"}) {
+		t.Errorf("Expected carol's permissions to list the taxpayer, got %v", perms)
+	}
+
+	acme := tenant.NewContext(ctx, "acme")
+	if service.CanAccessDocument(acme, Principal{Username: "carol"}, &docs[0]) {
+		t.Error("Expected the rule to use the tenant's namespace")
+	}
+}
+
+func TestAttributeRuleValidate(t *testing.T) {
+	invalid := []AttributeRule{
+		{Namespace: "taxpayers", Relation: "auditor"},
+		{Attribute: "taxpayer", Namespace: "taxpayers"},
+		{Attribute: "taxpayer", Namespace: "Tax Payers", Relation: "auditor"},
+		{Attribute: "taxpayer", Namespace: "documents", Relation: "auditor"},
+	}
+	for _, rule := range invalid {
+		if rule.Validate(DefaultNaming()) == nil {
+			t.Errorf("Expected %+v to be invalid", rule)
+		}
+	}
+	if err := auditorRule.Validate(DefaultNaming()); err != nil {
+		t.Errorf("Expected a valid rule, got %v", err)
+	}
+}
+
+func TestAttributeGrants(t *testing.T) {
+	keto := &fakeKeto{}
+	var lists atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/relation-tuples" && r.URL.Query().Get("namespace") == "taxpayers" {
+			lists.Add(1)
+		}
+		keto.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	service := newTestKeto(server.URL, FailClosed)
+	ctx := context.Background()
+	if grants, ok := service.AttributeGrants(ctx, Principal{Username: "carol"}); !ok || grants != nil {
+		t.Errorf("Expected no grants without attribute rules, got %v (%t)", grants, ok)
+	}
+
+	service.SetAttributeRules([]AttributeRule{auditorRule})
+	_ = service.putTuple(ctx, relationTuple{Namespace: "taxpayers", Object: "John Doe", Relation: "auditor", SubjectID: "carol"})
+	_ = service.putTuple(ctx, relationTuple{Namespace: "taxpayers", Object: "ABC Corporation", Relation: "auditor", SubjectSet: service.groupSubject(ctx, "audit-team")})
+	_ = service.putTuple(ctx, relationTuple{Namespace: "taxpayers", Object: "Jane Roe", Relation: "auditor", SubjectSet: service.groupSubject(ctx, "tax-team")})
+	_ = service.AddMember(ctx, "audit-team", "carol")
+
+	// Values held directly, as a member, and through a claimed group
+	request := TrackOutcome(ctx)
+	carol := Principal{Username: "carol", Groups: []string{"tax-team"}}
+	grants, ok := service.AttributeGrants(request, carol)
+	want := []AttributeGrant{{Attribute: "taxpayer", Values: []string{"ABC Corporation", "Jane Roe", "John Doe"}}}
+	if !ok || len(grants) != 1 || grants[0].Attribute != want[0].Attribute || !slices.Equal(grants[0].Values, want[0].Values) {
+		t.Errorf("Expected %v, got %v (%t)", want, grants, ok)
+	}
+	listed := lists.Load()
+	if _, ok := service.AttributeGrants(request, carol); !ok || lists.Load() != listed {
+		t.Error("Expected the grants to be listed once per request")
+	}
+
+	if grants, ok := service.AttributeGrants(ctx, Principal{Username: "alice"}); !ok || len(grants) != 0 {
+		t.Errorf("Expected no grants for alice, got %v (%t)", grants, ok)
+	}
+
+	// Too many values are not worth a predicate
+	service.SetCheckStrategy(StrategyCheck, 2)
+	if _, ok := service.AttributeGrants(ctx, carol); ok {
+		t.Error("Expected grants beyond the list limit to be reported incomplete")
+	}
+}
@@ -0,0 +1,176 @@
+package permissions
+
+import (
+	"context"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DenyReason is the reason code logged with every denied permission check
+type DenyReason string
+
+// Reason codes of denied checks
+const (
+	// DenyNoRelation means Keto answered that the user does not hold the relation
+	DenyNoRelation DenyReason = "no_relation"
+	// DenyUnavailable means Keto could not be reached or failed with a server error
+	DenyUnavailable DenyReason = "keto_unavailable"
+	// DenyCanceled means the request was canceled or ran out of time before
+	// Keto answered
+	DenyCanceled DenyReason = "request_canceled"
+	// DenyInvalidResponse means Keto rejected the check or sent an unreadable answer
+	DenyInvalidResponse DenyReason = "keto_invalid_response"
+	// DenyCached means a cached earlier denial was reused
+	DenyCached DenyReason = "cached"
+	// DenyPolicyError means the OPA backend's policy failed to evaluate
+	DenyPolicyError DenyReason = "policy_error"
+)
+
+// outcomeKey is the context key of a request's outcome
+type outcomeKey struct{}
+
+// outcome records whether a request's checks were denied because Keto could
+// not answer them and memoizes Keto's answers, so a request never checks the
+// same tuple twice. It also tracks the snapshot the request's checks must
+// reflect and when the request last changed relations.
+type outcome struct {
+	unavailable atomic.Bool
+	unanswered  atomic.Bool  // a decision was made without Keto's answer
+	snapshot    time.Time    // from SnapshotHeader; set before the request is served
+	written     atomic.Int64 // Unix nanoseconds of the last change; 0 if none
+
+	mu        sync.Mutex
+	decisions map[string]bool            // keyed by tuple query
+	lists     map[string]map[string]bool // listed objects; nil if too many
+}
+
+// Middleware tracks the outcome of the permission checks made while serving a
+// request, so handlers can tell an outage apart from a real denial with
+// Unavailable, and memoizes the checks for the rest of the request. Checks
+// reflect the snapshot token sent in SnapshotHeader; malformed tokens are
+// ignored. Responses of requests that changed relations carry a new token.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := TrackOutcome(r.Context())
+		if token := r.Header.Get(SnapshotHeader); token != "" {
+			if at, err := ParseSnapshotToken(token); err == nil {
+				requireSnapshot(ctx, at)
+			}
+		}
+		next.ServeHTTP(&snapshotWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+	})
+}
+
+// TrackOutcome returns a copy of ctx that records whether Keto could not
+// answer checks and memoizes the answers it gave
+func TrackOutcome(ctx context.Context) context.Context {
+	return context.WithValue(ctx, outcomeKey{}, &outcome{
+		decisions: make(map[string]bool),
+		lists:     make(map[string]map[string]bool),
+	})
+}
+
+// tracked returns ctx if it tracks an outcome, otherwise a copy that does
+func tracked(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		return ctx
+	}
+	return TrackOutcome(ctx)
+}
+
+// Unavailable reports whether a check made with ctx was denied because Keto
+// could not answer it under FailClosed. It is false for contexts without
+// TrackOutcome.
+func Unavailable(ctx context.Context) bool {
+	o, ok := ctx.Value(outcomeKey{}).(*outcome)
+	return ok && o.unavailable.Load()
+}
+
+// markUnavailable records in ctx that a check could not be answered
+func markUnavailable(ctx context.Context) {
+	if o, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		o.unavailable.Store(true)
+	}
+}
+
+// markUnanswered records in ctx that a decision was made without Keto's
+// answer, such as a denial of a canceled request or a grant of FailOpen
+func markUnanswered(ctx context.Context) {
+	if o, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		o.unanswered.Store(true)
+	}
+}
+
+// cacheable reports whether the decisions made with ctx may outlive the
+// request: all of them were answered by Keto and the request is still live
+func cacheable(ctx context.Context) bool {
+	o, ok := ctx.Value(outcomeKey{}).(*outcome)
+	return ok && !o.unavailable.Load() && !o.unanswered.Load() && ctx.Err() == nil
+}
+
+// memoized returns Keto's earlier answer for the tuple with key in the request
+// of ctx, if any
+func memoized(ctx context.Context, key string) (allowed, ok bool) {
+	o, tracked := ctx.Value(outcomeKey{}).(*outcome)
+	if !tracked {
+		return false, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	allowed, ok = o.decisions[key]
+	return allowed, ok
+}
+
+// memoize records Keto's answer for the tuple with key in the request of ctx.
+// Only definite answers may be memoized, never outcomes of failed checks.
+func memoize(ctx context.Context, key string, allowed bool) {
+	if o, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		o.mu.Lock()
+		o.decisions[key] = allowed
+		o.mu.Unlock()
+	}
+}
+
+// memoizedList returns the objects listed earlier under key in the request of
+// ctx, if any; nil if there were too many to list
+func memoizedList(ctx context.Context, key string) (objects map[string]bool, ok bool) {
+	o, tracked := ctx.Value(outcomeKey{}).(*outcome)
+	if !tracked {
+		return nil, false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	objects, ok = o.lists[key]
+	return objects, ok
+}
+
+// memoizeList records the objects listed under key in the request of ctx
+func memoizeList(ctx context.Context, key string, objects map[string]bool) {
+	if o, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		o.mu.Lock()
+		o.lists[key] = objects
+		o.mu.Unlock()
+	}
+}
+
+// forgetDecisions drops the memoized answers and lists of the request of ctx
+// after it changed relations and records the change for its snapshot token
+func forgetDecisions(ctx context.Context) {
+	markWritten(ctx)
+	if o, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		o.mu.Lock()
+		clear(o.decisions)
+		clear(o.lists)
+		o.mu.Unlock()
+	}
+}
+
+// logDeny writes the audit log line of a denied check with the client IP of
+// the request, if known
+func logDeny(ctx context.Context, username, relation, object string, reason DenyReason) {
+	requestid.Logf(ctx, "AUDIT permission denied: user=%q relation=%s object=%s reason=%s client_ip=%s", username, relation, object, reason, clientip.FromContext(ctx))
+}
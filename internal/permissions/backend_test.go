@@ -0,0 +1,217 @@
+package permissions
+
+import (
+	"context"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// backend is a permission service implementing every optional interface
+type backend interface {
+	PermissionChecker
+	PermissionManager
+	GroupManager
+	DocumentRelationLister
+	RelationRemover
+	UserRelationRemover
+	OrphanChecker
+}
+
+// forEachBackend runs test against a fresh Keto service, backed by fakeKeto,
+// and a fresh OPA service, so both backends keep the same behavior
+func forEachBackend(t *testing.T, test func(t *testing.T, b backend)) {
+	t.Run("keto", func(t *testing.T) {
+		server := httptest.NewServer(&fakeKeto{})
+		t.Cleanup(server.Close)
+		test(t, newTestKeto(server.URL, FailClosed))
+	})
+	t.Run("opa", func(t *testing.T) {
+		opa, err := NewOPAPermissionService("", "")
+		if err != nil {
+			t.Fatalf("NewOPAPermissionService failed: %v", err)
+		}
+		test(t, opa)
+	})
+}
+
+func TestBackendGrantAndRevoke(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b backend) {
+		ctx := context.Background()
+		doc := models.Document{ID: uuid.New()}
+		granted := []Tuple{
+			{Subject: "alice", Relation: RelationViewer, DocumentID: doc.ID},
+			{Subject: "bob", Relation: RelationEditor, DocumentID: doc.ID},
+			{Subject: "bob", Relation: RelationWrite},
+		}
+		for _, tuple := range granted {
+			if err := b.Grant(ctx, tuple); err != nil {
+				t.Fatalf("Grant failed: %v", err)
+			}
+		}
+
+		if !b.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) || b.CanEditDocument(ctx, Principal{Username: "alice"}, &doc) || b.CanWriteDocuments(ctx, Principal{Username: "alice"}) {
+			t.Error("Expected alice to only view the document")
+		}
+		if b.CanAccessDocument(ctx, Principal{Username: "bob"}, &doc) || !b.CanEditDocument(ctx, Principal{Username: "bob"}, &doc) || !b.CanWriteDocuments(ctx, Principal{Username: "bob"}) {
+			t.Error("Expected bob to edit the document and write the corpus without viewing")
+		}
+		if tuples, err := b.ListTuples(ctx, "bob"); err != nil || !slices.Equal(tuples, granted[1:]) {
+			t.Errorf("Expected bob's relations %v, got %v (%v)", granted[1:], tuples, err)
+		}
+		if got := b.GetUserPermissions(ctx, Principal{Username: "bob"}); !slices.Equal(got, []string{doc.ID.String(), CorpusObject}) {
+			t.Errorf("Expected bob's permissions on the document and corpus, got %v", got)
+		}
+
+		if err := b.Revoke(ctx, granted[0]); err != nil {
+			t.Fatalf("Revoke failed: %v", err)
+		}
+		if err := b.Revoke(ctx, granted[0]); err != nil {
+			t.Errorf("Expected revoking a missing relation to succeed, got %v", err)
+		}
+		if b.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) {
+			t.Error("Expected alice to lose access after the revoke")
+		}
+	})
+}
+
+func TestBackendGroups(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b backend) {
+		ctx := context.Background()
+		shared, private := models.Document{ID: uuid.New()}, models.Document{ID: uuid.New()}
+		groupViewer := Tuple{Group: "accounting-team", Relation: RelationViewer, DocumentID: shared.ID}
+		if err := b.Grant(ctx, groupViewer); err != nil {
+			t.Fatalf("Grant failed: %v", err)
+		}
+		if err := b.AddMember(ctx, "accounting-team", "alice"); err != nil {
+			t.Fatalf("AddMember failed: %v", err)
+		}
+
+		if got := b.BatchCheck(ctx, Principal{Username: "alice"}, []models.Document{shared, private}); !slices.Equal(got, []bool{true, false}) {
+			t.Errorf("Expected alice's batch check [true false], got %v", got)
+		}
+		if b.CanAccessDocument(ctx, Principal{Username: "bob"}, &shared) {
+			t.Error("Expected bob, who is not a member, to be denied")
+		}
+		claimed := Principal{Username: "bob", Groups: []string{"accounting-team"}}
+		if !b.CanAccessDocument(ctx, claimed, &shared) {
+			t.Error("Expected bob to view the document through a claimed group")
+		}
+		if members, err := b.Members(ctx, "accounting-team"); err != nil || !slices.Equal(members, []string{"alice"}) {
+			t.Errorf("Expected members [alice], got %v (%v)", members, err)
+		}
+		if tuples, err := b.ListGroupTuples(ctx, "accounting-team"); err != nil || !slices.Equal(tuples, []Tuple{groupViewer}) {
+			t.Errorf("Expected the group's viewer relation, got %v (%v)", tuples, err)
+		}
+
+		if err := b.RemoveMember(ctx, "accounting-team", "alice"); err != nil {
+			t.Fatalf("RemoveMember failed: %v", err)
+		}
+		if b.CanAccessDocument(ctx, Principal{Username: "alice"}, &shared) {
+			t.Error("Expected alice to lose access after leaving the group")
+		}
+	})
+}
+
+func TestBackendIsolatesTenants(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b backend) {
+		acme := tenant.NewContext(context.Background(), "acme")
+		globex := tenant.NewContext(context.Background(), "globex")
+		doc := models.Document{ID: uuid.New()}
+		if err := b.Grant(acme, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: doc.ID}); err != nil {
+			t.Fatalf("Grant failed: %v", err)
+		}
+		if !b.CanAccessDocument(acme, Principal{Username: "alice"}, &doc) || b.CanAccessDocument(globex, Principal{Username: "alice"}, &doc) {
+			t.Error("Expected alice's relation to apply in her tenant only")
+		}
+		if tuples, _ := b.ListTuples(globex, "alice"); len(tuples) != 0 {
+			t.Errorf("Expected no relations in another tenant, got %v", tuples)
+		}
+	})
+}
+
+func TestBackendDocumentRelations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b backend) {
+		ctx := context.Background()
+		doc, other := models.Document{ID: uuid.New()}, models.Document{ID: uuid.New()}
+		want := []Tuple{
+			{Subject: "alice", Relation: RelationEditor, DocumentID: doc.ID},
+			{Subject: "bob", Relation: RelationViewer, DocumentID: doc.ID},
+			{Group: "accounting-team", Relation: RelationViewer, DocumentID: doc.ID},
+		}
+		for _, tuple := range append(want, Tuple{Subject: "carol", Relation: RelationViewer, DocumentID: other.ID}) {
+			if err := b.Grant(ctx, tuple); err != nil {
+				t.Fatalf("Grant failed: %v", err)
+			}
+		}
+
+		var got []Tuple
+		pages, token := 0, ""
+		for {
+			page, next, err := b.ListDocumentTuples(ctx, doc.ID, 2, token)
+			if err != nil {
+				t.Fatalf("ListDocumentTuples failed: %v", err)
+			}
+			got, pages, token = append(got, page...), pages+1, next
+			if token == "" {
+				break
+			}
+		}
+		if pages != 2 || !slices.Equal(got, want) {
+			t.Errorf("Expected %v over 2 pages, got %v over %d", want, got, pages)
+		}
+
+		if orphaned, err := b.Orphaned(ctx, &doc); err != nil || orphaned {
+			t.Errorf("Expected the document not to be orphaned, got %t (%v)", orphaned, err)
+		}
+		if err := b.RemoveDocumentRelations(ctx, doc.ID); err != nil {
+			t.Fatalf("RemoveDocumentRelations failed: %v", err)
+		}
+		if orphaned, err := b.Orphaned(ctx, &doc); err != nil || !orphaned {
+			t.Errorf("Expected the document to be orphaned after removing its relations, got %t (%v)", orphaned, err)
+		}
+		if !b.CanAccessDocument(ctx, Principal{Username: "carol"}, &other) {
+			t.Error("Expected relations on other documents to be kept")
+		}
+	})
+}
+
+func TestBackendRemoveUserRelations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b backend) {
+		ctx := context.Background()
+		doc := models.Document{ID: uuid.New()}
+		for _, tuple := range []Tuple{
+			{Subject: "alice", Relation: RelationViewer, DocumentID: doc.ID},
+			{Subject: "alice", Relation: RelationWrite},
+			{Subject: "bob", Relation: RelationViewer, DocumentID: doc.ID},
+			{Group: "payroll", Relation: RelationEditor, DocumentID: doc.ID},
+		} {
+			if err := b.Grant(ctx, tuple); err != nil {
+				t.Fatalf("Grant failed: %v", err)
+			}
+		}
+		for _, user := range []string{"alice", "bob"} {
+			if err := b.AddMember(ctx, "payroll", user); err != nil {
+				t.Fatalf("AddMember failed: %v", err)
+			}
+		}
+
+		removed, err := b.RemoveUserRelations(ctx, "alice")
+		if err != nil || len(removed) != 3 {
+			t.Fatalf("Expected 3 relations to be removed, got %v (%v)", removed, err)
+		}
+		if b.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) || b.CanEditDocument(ctx, Principal{Username: "alice"}, &doc) || b.CanWriteDocuments(ctx, Principal{Username: "alice"}) {
+			t.Error("Expected alice to lose all access, including through groups")
+		}
+		if !b.CanAccessDocument(ctx, Principal{Username: "bob"}, &doc) || !b.CanEditDocument(ctx, Principal{Username: "bob"}, &doc) {
+			t.Error("Expected other users' relations and memberships to be kept")
+		}
+		if removed, err := b.RemoveUserRelations(ctx, "alice"); err != nil || len(removed) != 0 {
+			t.Errorf("Expected nothing left to remove, got %v (%v)", removed, err)
+		}
+	})
+}
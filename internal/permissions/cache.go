@@ -0,0 +1,409 @@
+package permissions
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cacheKey identifies a single user/document permission decision within a
+// tenant, for the groups and roles asserted for the user
+type cacheKey struct {
+	tenantID   string
+	username   string
+	assertions string
+	docID      uuid.UUID
+}
+
+// cacheEntry is a cached permission decision with the time its check started
+// and its expiry time
+type cacheEntry struct {
+	key       cacheKey
+	allowed   bool
+	checkedAt time.Time
+	expiresAt time.Time
+}
+
+// CachingPermissionService decorates a PermissionChecker with an in-process
+// LRU cache of access decisions that expire after a fixed TTL
+type CachingPermissionService struct {
+	next       PermissionChecker
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	order   *list.List // front = most recently used
+}
+
+// NewCachingPermissionService wraps next with a decision cache holding at most
+// maxEntries decisions, each valid for ttl
+func NewCachingPermissionService(next PermissionChecker, ttl time.Duration, maxEntries int) *CachingPermissionService {
+	return &CachingPermissionService{
+		next:       next,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[cacheKey]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// CanAccessDocument returns a cached decision if present and as fresh as the
+// snapshot of ctx, otherwise delegates and caches the result. Decisions Keto
+// did not answer, during an outage or after ctx was canceled, are not cached.
+func (c *CachingPermissionService) CanAccessDocument(ctx context.Context, p Principal, doc *models.Document) bool {
+	ctx = tracked(ctx)
+	key := cacheKey{tenantID: tenant.FromContext(ctx), username: p.Username, assertions: p.assertions(), docID: doc.ID}
+	if allowed, ok := c.get(key, freshAfter(ctx)); ok {
+		if !allowed {
+			logDeny(ctx, p.Username, RelationViewer, doc.ID.String(), DenyCached)
+		}
+		return allowed
+	}
+
+	checkedAt := c.now()
+	allowed := c.next.CanAccessDocument(ctx, p, doc)
+	if cacheable(ctx) {
+		c.set(key, allowed, checkedAt)
+	}
+	return allowed
+}
+
+// BatchCheck serves cached decisions as fresh as the snapshot of ctx and only
+// forwards the other documents to the wrapped checker. Decisions are not
+// cached if Keto failed to answer a check.
+func (c *CachingPermissionService) BatchCheck(ctx context.Context, p Principal, docs []models.Document) []bool {
+	ctx = tracked(ctx)
+	results := make([]bool, len(docs))
+	tenantID, assertions, fresh := tenant.FromContext(ctx), p.assertions(), freshAfter(ctx)
+
+	var misses []models.Document
+	var missIdx []int
+	for i := range docs {
+		if allowed, ok := c.get(cacheKey{tenantID: tenantID, username: p.Username, assertions: assertions, docID: docs[i].ID}, fresh); ok {
+			if !allowed {
+				logDeny(ctx, p.Username, RelationViewer, docs[i].ID.String(), DenyCached)
+			}
+			results[i] = allowed
+			continue
+		}
+		misses = append(misses, docs[i])
+		missIdx = append(missIdx, i)
+	}
+
+	if len(misses) == 0 {
+		return results
+	}
+
+	checkedAt := c.now()
+	allowed := c.next.BatchCheck(ctx, p, misses)
+	cache := cacheable(ctx)
+	for j, i := range missIdx {
+		results[i] = allowed[j]
+		if cache {
+			c.set(cacheKey{tenantID: tenantID, username: p.Username, assertions: assertions, docID: misses[j].ID}, allowed[j], checkedAt)
+		}
+	}
+
+	return results
+}
+
+// CanEditDocument is not cached so revoked edit access takes effect immediately
+func (c *CachingPermissionService) CanEditDocument(ctx context.Context, p Principal, doc *models.Document) bool {
+	return c.next.CanEditDocument(ctx, p, doc)
+}
+
+// CanWriteDocuments is not cached so revoked write access takes effect immediately
+func (c *CachingPermissionService) CanWriteDocuments(ctx context.Context, p Principal) bool {
+	return c.next.CanWriteDocuments(ctx, p)
+}
+
+// GetUserPermissions is not cached and always delegates to the wrapped checker
+func (c *CachingPermissionService) GetUserPermissions(ctx context.Context, p Principal) []string {
+	return c.next.GetUserPermissions(ctx, p)
+}
+
+// Ping forwards the readiness check to the wrapped checker if it supports one
+func (c *CachingPermissionService) Ping(ctx context.Context) error {
+	if pinger, ok := c.next.(interface{ Ping(context.Context) error }); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// ErrChangesUnsupported is returned by the relation and group methods if the wrapped checker cannot change relations
+var ErrChangesUnsupported = errors.New("permission service does not support changing relations")
+
+// Grant delegates to the wrapped checker and drops the affected cached decisions
+func (c *CachingPermissionService) Grant(ctx context.Context, t Tuple) error {
+	manager, ok := c.next.(PermissionManager)
+	if !ok {
+		return ErrChangesUnsupported
+	}
+	defer c.invalidateTuple(ctx, t)
+	return manager.Grant(ctx, t)
+}
+
+// GrantAll delegates to the wrapped checker and drops the cached decisions
+// the grants affect
+func (c *CachingPermissionService) GrantAll(ctx context.Context, tuples []Tuple) []error {
+	manager, ok := c.next.(PermissionManager)
+	if !ok {
+		errs := make([]error, len(tuples))
+		for i := range errs {
+			errs[i] = ErrChangesUnsupported
+		}
+		return errs
+	}
+	defer func() {
+		for _, t := range tuples {
+			c.invalidateTuple(ctx, t)
+		}
+	}()
+	return GrantAll(ctx, manager, tuples)
+}
+
+// Revoke delegates to the wrapped checker and drops the affected cached decisions
+func (c *CachingPermissionService) Revoke(ctx context.Context, t Tuple) error {
+	manager, ok := c.next.(PermissionManager)
+	if !ok {
+		return ErrChangesUnsupported
+	}
+	defer c.invalidateTuple(ctx, t)
+	return manager.Revoke(ctx, t)
+}
+
+// RemoveDocumentRelations delegates to the wrapped checker and drops all cached decisions on the document
+func (c *CachingPermissionService) RemoveDocumentRelations(ctx context.Context, docID uuid.UUID) error {
+	remover, ok := c.next.(RelationRemover)
+	if !ok {
+		return ErrChangesUnsupported
+	}
+	defer c.InvalidateDocument(docID)
+	return remover.RemoveDocumentRelations(ctx, docID)
+}
+
+// RemoveUserRelations delegates to the wrapped checker. The user's cached
+// decisions are kept until they expire or InvalidateUser drops them.
+func (c *CachingPermissionService) RemoveUserRelations(ctx context.Context, username string) ([]UserRelation, error) {
+	remover, ok := c.next.(UserRelationRemover)
+	if !ok {
+		return nil, ErrChangesUnsupported
+	}
+	return remover.RemoveUserRelations(ctx, username)
+}
+
+// invalidateTuple drops the decisions a relation change affects: a group's
+// relation affects every member, so all decisions on the document go
+func (c *CachingPermissionService) invalidateTuple(ctx context.Context, t Tuple) {
+	if t.Group != "" {
+		c.InvalidateDocument(t.DocumentID)
+		return
+	}
+	c.Invalidate(ctx, t.Subject, t.DocumentID)
+}
+
+// AddMember delegates to the wrapped checker and drops the user's cached decisions
+func (c *CachingPermissionService) AddMember(ctx context.Context, group, user string) error {
+	manager, ok := c.next.(GroupManager)
+	if !ok {
+		return ErrChangesUnsupported
+	}
+	defer c.InvalidateUser(user)
+	return manager.AddMember(ctx, group, user)
+}
+
+// RemoveMember delegates to the wrapped checker and drops the user's cached decisions
+func (c *CachingPermissionService) RemoveMember(ctx context.Context, group, user string) error {
+	manager, ok := c.next.(GroupManager)
+	if !ok {
+		return ErrChangesUnsupported
+	}
+	defer c.InvalidateUser(user)
+	return manager.RemoveMember(ctx, group, user)
+}
+
+// Members delegates to the wrapped checker
+func (c *CachingPermissionService) Members(ctx context.Context, group string) ([]string, error) {
+	manager, ok := c.next.(GroupManager)
+	if !ok {
+		return nil, ErrChangesUnsupported
+	}
+	return manager.Members(ctx, group)
+}
+
+// ListGroupTuples delegates to the wrapped checker
+func (c *CachingPermissionService) ListGroupTuples(ctx context.Context, group string) ([]Tuple, error) {
+	manager, ok := c.next.(GroupManager)
+	if !ok {
+		return nil, ErrChangesUnsupported
+	}
+	return manager.ListGroupTuples(ctx, group)
+}
+
+// ListTuples delegates to the wrapped checker; listings are not cached
+func (c *CachingPermissionService) ListTuples(ctx context.Context, subject string) ([]Tuple, error) {
+	manager, ok := c.next.(PermissionManager)
+	if !ok {
+		return nil, ErrChangesUnsupported
+	}
+	return manager.ListTuples(ctx, subject)
+}
+
+// ListDocumentTuples delegates to the wrapped checker; listings are not cached
+func (c *CachingPermissionService) ListDocumentTuples(ctx context.Context, docID uuid.UUID, pageSize int, pageToken string) ([]Tuple, string, error) {
+	lister, ok := c.next.(DocumentRelationLister)
+	if !ok {
+		return nil, "", ErrChangesUnsupported
+	}
+	return lister.ListDocumentTuples(ctx, docID, pageSize, pageToken)
+}
+
+// Orphaned delegates to the wrapped checker; the answer is not cached
+func (c *CachingPermissionService) Orphaned(ctx context.Context, doc *models.Document) (bool, error) {
+	checker, ok := c.next.(OrphanChecker)
+	if !ok {
+		return false, ErrChangesUnsupported
+	}
+	return checker.Orphaned(ctx, doc)
+}
+
+// AttributeGrants delegates to the wrapped checker; the grants are not cached
+func (c *CachingPermissionService) AttributeGrants(ctx context.Context, p Principal) ([]AttributeGrant, bool) {
+	granter, ok := c.next.(AttributeGranter)
+	if !ok {
+		return nil, false
+	}
+	return granter.AttributeGrants(ctx, p)
+}
+
+// Warm delegates to the wrapped checker; cached decisions need no warming
+func (c *CachingPermissionService) Warm(ctx context.Context, p Principal) {
+	if warmer, ok := c.next.(Warmer); ok {
+		warmer.Warm(ctx, p)
+	}
+}
+
+// CountRelations delegates to the wrapped checker; the counts are not cached
+func (c *CachingPermissionService) CountRelations(ctx context.Context) (RelationStats, error) {
+	counter, ok := c.next.(RelationCounter)
+	if !ok {
+		return RelationStats{}, ErrChangesUnsupported
+	}
+	return counter.CountRelations(ctx)
+}
+
+// Invalidate removes the cached decisions for a single user/document pair in
+// the tenant carried by ctx, whatever groups were claimed for the user. Call
+// this after writing or deleting the corresponding relation tuple.
+func (c *CachingPermissionService) Invalidate(ctx context.Context, username string, docID uuid.UUID) {
+	tenantID := tenant.FromContext(ctx)
+	c.removeWhere(func(key cacheKey) bool {
+		return key.tenantID == tenantID && key.username == username && key.docID == docID
+	})
+}
+
+// InvalidateUser removes all cached decisions for a user
+func (c *CachingPermissionService) InvalidateUser(username string) {
+	c.removeWhere(func(key cacheKey) bool { return key.username == username })
+}
+
+// InvalidateDocument removes all cached decisions for a document
+func (c *CachingPermissionService) InvalidateDocument(docID uuid.UUID) {
+	c.removeWhere(func(key cacheKey) bool { return key.docID == docID })
+}
+
+// Purge removes all cached decisions
+func (c *CachingPermissionService) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[cacheKey]*list.Element)
+	c.order.Init()
+}
+
+// Len returns the number of cached decisions, including expired ones not yet evicted
+func (c *CachingPermissionService) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// get returns the cached decision for key if present, not expired, and
+// checked no earlier than fresh
+func (c *CachingPermissionService) get(key cacheKey, fresh time.Time) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if c.now().After(entry.expiresAt) {
+		c.removeElement(elem)
+		return false, false
+	}
+	if entry.checkedAt.Before(fresh) {
+		return false, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.allowed, true
+}
+
+// set stores a decision whose check started at checkedAt and evicts the least
+// recently used entry when full
+func (c *CachingPermissionService) set(key cacheKey, allowed bool, checkedAt time.Time) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.allowed = allowed
+		entry.checkedAt = checkedAt
+		entry.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, allowed: allowed, checkedAt: checkedAt, expiresAt: expiresAt})
+
+	for c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// removeWhere removes all entries whose key matches the predicate
+func (c *CachingPermissionService) removeWhere(match func(cacheKey) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if match(key) {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// removeElement removes an entry; the caller must hold c.mu
+func (c *CachingPermissionService) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.order.Remove(elem)
+}
@@ -0,0 +1,208 @@
+package permissions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type countingChecker struct {
+	allowed map[uuid.UUID]bool
+	checks  int
+}
+
+func (c *countingChecker) CanAccessDocument(_ context.Context, _ Principal, doc *models.Document) bool {
+	c.checks++
+	return c.allowed[doc.ID]
+}
+
+func (c *countingChecker) BatchCheck(_ context.Context, _ Principal, docs []models.Document) []bool {
+	result := make([]bool, len(docs))
+	for i := range docs {
+		c.checks++
+		result[i] = c.allowed[docs[i].ID]
+	}
+	return result
+}
+
+func (c *countingChecker) CanEditDocument(_ context.Context, _ Principal, _ *models.Document) bool {
+	return false
+}
+
+func (c *countingChecker) CanWriteDocuments(_ context.Context, _ Principal) bool {
+	return false
+}
+
+func (c *countingChecker) GetUserPermissions(_ context.Context, _ Principal) []string {
+	return []string{}
+}
+
+func TestCachingPermissionServiceHitsAndExpiry(t *testing.T) {
+	doc := models.Document{ID: uuid.New()}
+	inner := &countingChecker{allowed: map[uuid.UUID]bool{doc.ID: true}}
+	ctx := context.Background()
+	cache := NewCachingPermissionService(inner, time.Minute, 10)
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	if !cache.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) || !cache.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) {
+		t.Fatal("Expected alice to be allowed")
+	}
+	if inner.checks != 1 {
+		t.Errorf("Expected 1 upstream check, got %d", inner.checks)
+	}
+
+	now = now.Add(2 * time.Minute)
+	cache.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc)
+	if inner.checks != 2 {
+		t.Errorf("Expected expired entry to be re-checked, got %d upstream checks", inner.checks)
+	}
+}
+
+func TestCachingPermissionServiceBatchCheckOnlyForwardsMisses(t *testing.T) {
+	docs := []models.Document{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	inner := &countingChecker{allowed: map[uuid.UUID]bool{docs[0].ID: true, docs[2].ID: true}}
+	ctx := context.Background()
+	cache := NewCachingPermissionService(inner, time.Minute, 10)
+
+	cache.CanAccessDocument(ctx, Principal{Username: "bob"}, &docs[0])
+
+	result := cache.BatchCheck(ctx, Principal{Username: "bob"}, docs)
+	want := []bool{true, false, true}
+	for i := range want {
+		if result[i] != want[i] {
+			t.Errorf("Result %d: expected %v, got %v", i, want[i], result[i])
+		}
+	}
+	if inner.checks != 3 {
+		t.Errorf("Expected 3 upstream checks, got %d", inner.checks)
+	}
+}
+
+func TestCachingPermissionServiceEvictionAndInvalidation(t *testing.T) {
+	docs := []models.Document{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	inner := &countingChecker{allowed: map[uuid.UUID]bool{}}
+	ctx := context.Background()
+	cache := NewCachingPermissionService(inner, time.Minute, 2)
+
+	cache.BatchCheck(ctx, Principal{Username: "peter"}, docs)
+	if cache.Len() != 2 {
+		t.Errorf("Expected LRU to hold 2 entries, got %d", cache.Len())
+	}
+
+	inner.allowed[docs[2].ID] = true
+	if cache.CanAccessDocument(ctx, Principal{Username: "peter"}, &docs[2]) {
+		t.Error("Expected stale cached denial before invalidation")
+	}
+
+	cache.Invalidate(ctx, "peter", docs[2].ID)
+	if !cache.CanAccessDocument(ctx, Principal{Username: "peter"}, &docs[2]) {
+		t.Error("Expected fresh decision after invalidation")
+	}
+
+	cache.InvalidateUser("peter")
+	if cache.Len() != 0 {
+		t.Errorf("Expected empty cache after user invalidation, got %d", cache.Len())
+	}
+}
+
+func TestCachingPermissionServiceInvalidatesGroupChanges(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	ctx := context.Background()
+	cache := NewCachingPermissionService(newTestKeto(server.URL, FailClosed), time.Minute, 100)
+	doc := models.Document{ID: uuid.New()}
+
+	if err := cache.Grant(ctx, Tuple{Group: "accounting-team", Relation: RelationViewer, DocumentID: doc.ID}); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+	if cache.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) || cache.CanAccessDocument(ctx, Principal{Username: "bob"}, &doc) {
+		t.Fatal("Expected non-members to be denied")
+	}
+
+	if err := cache.AddMember(ctx, "accounting-team", "alice"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	if !cache.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) {
+		t.Error("Expected the cached denial to be dropped when alice joined the group")
+	}
+
+	if err := cache.AddMember(ctx, "accounting-team", "bob"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	if err := cache.Revoke(ctx, Tuple{Group: "accounting-team", Relation: RelationViewer, DocumentID: doc.ID}); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if cache.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) || cache.CanAccessDocument(ctx, Principal{Username: "bob"}, &doc) {
+		t.Error("Expected every member's cached grant to be dropped when the group lost access")
+	}
+}
+
+func TestCachingPermissionServiceSkipsOutageDenials(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	keto := &fakeKeto{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		keto.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	service := newTestKeto(server.URL, FailClosed)
+	cache := NewCachingPermissionService(service, time.Minute, 100)
+	doc := models.Document{ID: uuid.New()}
+
+	ctx := TrackOutcome(context.Background())
+	if cache.BatchCheck(ctx, Principal{Username: "alice"}, []models.Document{doc})[0] || !Unavailable(ctx) {
+		t.Fatal("Expected a denial marked as unavailable during the outage")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Expected the outage denial not to be cached, got %d entries", cache.Len())
+	}
+
+	down.Store(false)
+	if err := service.Grant(context.Background(), Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: doc.ID}); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+	if !cache.CanAccessDocument(TrackOutcome(context.Background()), Principal{Username: "alice"}, &doc) {
+		t.Error("Expected access once Keto recovered")
+	}
+}
+
+func TestCachingPermissionServiceSkipsUnansweredDecisions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cache := NewCachingPermissionService(newTestKeto(server.URL, FailOpen), time.Minute, 100)
+	doc := models.Document{ID: uuid.New()}
+
+	if !cache.CanAccessDocument(context.Background(), Principal{Username: "alice"}, &doc) ||
+		!cache.BatchCheck(context.Background(), Principal{Username: "alice"}, []models.Document{doc})[0] {
+		t.Fatal("Expected fail-open to grant read access during the outage")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Expected fail-open grants not to be cached, got %d entries", cache.Len())
+	}
+
+	canceled, cancel := context.WithCancel(TrackOutcome(context.Background()))
+	cancel()
+	if cache.CanAccessDocument(canceled, Principal{Username: "alice"}, &doc) || Unavailable(canceled) {
+		t.Error("Expected a canceled check to be denied without marking an outage, even when failing open")
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Expected the canceled denial not to be cached, got %d entries", cache.Len())
+	}
+}
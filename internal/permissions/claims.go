@@ -0,0 +1,40 @@
+package permissions
+
+import (
+	"slices"
+	"strings"
+)
+
+// Principal is the subject of a permission check: a user and what their
+// identity provider asserts about them
+type Principal struct {
+	// Username is the user relations are checked for
+	Username string
+	// Groups are the groups the identity provider places the user in. Checks
+	// also grant the relations these groups' members hold, as if the user
+	// were a member. Use ValidGroups to set them.
+	Groups []string
+	// Roles are the roles the identity provider grants the user. They are
+	// input to policies; Keto ignores them.
+	Roles []string
+	// Claims are the raw claims or traits of the user's identity, input to
+	// policies
+	Claims map[string]interface{}
+}
+
+// ValidGroups returns the well-formed names among groups, sorted and without
+// duplicates
+func ValidGroups(groups []string) []string {
+	valid := slices.DeleteFunc(slices.Clone(groups), func(group string) bool {
+		return ValidateGroupName(group) != nil
+	})
+	slices.Sort(valid)
+	return slices.Compact(valid)
+}
+
+// assertions identifies the groups and roles of p in cache keys, since they
+// can change a decision without a change of relations
+func (p Principal) assertions() string {
+	roles := slices.Sorted(slices.Values(p.Roles))
+	return strings.Join(p.Groups, ",") + ";" + strings.Join(roles, ",")
+}
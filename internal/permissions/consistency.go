@@ -0,0 +1,111 @@
+package permissions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SnapshotHeader carries snapshot tokens. Responses to requests that changed
+// relations set it to a token of the change; a client sends back the latest
+// token it received, so the checks of its later requests reflect the change
+// even on a replica whose decision cache predates it. Keto itself answers
+// checks from its database and needs no hint.
+const SnapshotHeader = "X-Permissions-Snapshot"
+
+// SnapshotToken encodes the time of a relation change as an opaque token
+func SnapshotToken(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 36)
+}
+
+// ParseSnapshotToken decodes a token of SnapshotToken
+func ParseSnapshotToken(token string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(token, 36, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}, fmt.Errorf("invalid snapshot token %q", token)
+	}
+	return time.Unix(0, nanos), nil
+}
+
+// requireSnapshot records in ctx that its checks must reflect the relation
+// changes made up to at
+func requireSnapshot(ctx context.Context, at time.Time) {
+	if o, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		o.snapshot = at
+	}
+}
+
+// markWritten records in ctx that the request changed relations now
+func markWritten(ctx context.Context) {
+	if o, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		o.written.Store(time.Now().UnixNano())
+	}
+}
+
+// Snapshot returns the token of the last relation change made with ctx, or ""
+// if it made none
+func Snapshot(ctx context.Context) string {
+	o, ok := ctx.Value(outcomeKey{}).(*outcome)
+	if !ok || o.written.Load() == 0 {
+		return ""
+	}
+	return SnapshotToken(time.Unix(0, o.written.Load()))
+}
+
+// freshAfter returns the time decisions used for ctx must have been checked
+// after: the later of the snapshot the client asked for and the request's own
+// last change. It is zero if any decision will do.
+func freshAfter(ctx context.Context) time.Time {
+	o, ok := ctx.Value(outcomeKey{}).(*outcome)
+	if !ok {
+		return time.Time{}
+	}
+	if written := o.written.Load(); written != 0 && time.Unix(0, written).After(o.snapshot) {
+		return time.Unix(0, written)
+	}
+	return o.snapshot
+}
+
+// snapshotWriter sets SnapshotHeader before the response is written if the
+// request changed relations
+type snapshotWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+}
+
+// setHeader sets SnapshotHeader once, before the headers are sent
+func (w *snapshotWriter) setHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if token := Snapshot(w.ctx); token != "" {
+		w.Header().Set(SnapshotHeader, token)
+	}
+}
+
+func (w *snapshotWriter) WriteHeader(code int) {
+	w.setHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *snapshotWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush supports streamed responses
+func (w *snapshotWriter) Flush() {
+	w.setHeader()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *snapshotWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
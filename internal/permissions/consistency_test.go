@@ -0,0 +1,99 @@
+package permissions
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSnapshotToken(t *testing.T) {
+	at := time.Unix(1700000000, 123456789)
+	parsed, err := ParseSnapshotToken(SnapshotToken(at))
+	if err != nil || !parsed.Equal(at) {
+		t.Errorf("Expected %v to survive a round trip, got %v (%v)", at, parsed, err)
+	}
+	for _, token := range []string{"", "not a token!", "-1"} {
+		if _, err := ParseSnapshotToken(token); err == nil {
+			t.Errorf("Expected %q to be rejected", token)
+		}
+	}
+}
+
+func TestSnapshotTokenSkipsStaleCachedDecisions(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	// Two replicas with their own decision caches share Keto
+	writer := NewCachingPermissionService(newTestKeto(server.URL, FailClosed), time.Minute, 10)
+	reader := NewCachingPermissionService(newTestKeto(server.URL, FailClosed), time.Minute, 10)
+	doc := models.Document{ID: uuid.New()}
+
+	grant := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := writer.Grant(r.Context(), Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: doc.ID}); err != nil {
+			t.Errorf("Grant failed: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	var allowed bool
+	check := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed = reader.BatchCheck(r.Context(), Principal{Username: "alice"}, []models.Document{doc})[0]
+		_, _ = w.Write([]byte("{}"))
+	}))
+	serve := func(handler http.Handler, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set(SnapshotHeader, token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The reader caches alice's denial before the grant
+	if serve(check, "").Header().Get(SnapshotHeader) != "" || allowed {
+		t.Fatal("Expected a denial without a snapshot token for a request that changed nothing")
+	}
+	token := serve(grant, "").Header().Get(SnapshotHeader)
+	if _, err := ParseSnapshotToken(token); err != nil {
+		t.Fatalf("Expected the grant's response to carry a snapshot token, got %q", token)
+	}
+
+	if serve(check, ""); allowed {
+		t.Error("Expected the reader to serve its cached denial without a token")
+	}
+	if serve(check, token); !allowed {
+		t.Error("Expected the token to skip the stale cached denial")
+	}
+	if serve(check, ""); !allowed {
+		t.Error("Expected the fresh decision to replace the cached denial")
+	}
+}
+
+func TestChangesAreFreshWithinTheRequest(t *testing.T) {
+	opa, err := NewOPAPermissionService("", "")
+	if err != nil {
+		t.Fatalf("NewOPAPermissionService failed: %v", err)
+	}
+	cache := NewCachingPermissionService(opa, time.Minute, 10)
+	doc := models.Document{ID: uuid.New()}
+	ctx := TrackOutcome(context.Background())
+
+	if cache.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) || Snapshot(ctx) != "" {
+		t.Fatal("Expected a denial and no snapshot before the grant")
+	}
+	// Group grants do not invalidate alice's decisions in other caches; the
+	// request's own change makes its cached decisions stale nonetheless
+	_ = opa.AddMember(ctx, "accounting-team", "alice")
+	_ = opa.Grant(ctx, Tuple{Group: "accounting-team", Relation: RelationViewer, DocumentID: doc.ID})
+	if Snapshot(ctx) == "" {
+		t.Error("Expected the changes to set the request's snapshot")
+	}
+	if !cache.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) {
+		t.Error("Expected the check after the grant to see it")
+	}
+}
@@ -2,11 +2,236 @@
 package permissions
 
 import (
+	"context"
+	"fmt"
+	"regexp"
 	"rerag-rbac-rag-llm/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Relations users can hold on documents or the corpus
+const (
+	// RelationViewer grants read access to a single document
+	RelationViewer = "viewer"
+	// RelationEditor grants the right to modify a single document
+	RelationEditor = "editor"
+	// RelationWrite grants the right to ingest and modify documents in the corpus
+	RelationWrite = "write"
 )
 
+// CorpusObject is the Keto object that represents the document corpus as a whole
+const CorpusObject = "corpus"
+
+// RelationMember is the relation of a user to a group. Relations granted to a
+// group apply to its members through the Keto subject set group#member.
+const RelationMember = "member"
+
+// groupNamePattern restricts group names to identifiers safe in Keto objects
+var groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// ValidateGroupName checks that a group name is well-formed
+func ValidateGroupName(group string) error {
+	if !groupNamePattern.MatchString(group) {
+		return fmt.Errorf("invalid group name %q: use up to 64 letters, digits, '.', '_', or '-'", group)
+	}
+	return nil
+}
+
 // PermissionChecker defines the interface for checking document access permissions
 type PermissionChecker interface {
-	CanAccessDocument(username string, doc *models.Document) bool
-	GetUserPermissions(username string) []string
+	CanAccessDocument(ctx context.Context, p Principal, doc *models.Document) bool
+	// BatchCheck checks access for many documents at once. The returned slice
+	// is aligned with docs: result[i] reports whether the user may access docs[i].
+	BatchCheck(ctx context.Context, p Principal, docs []models.Document) []bool
+	// CanEditDocument checks whether the user may modify an existing document
+	CanEditDocument(ctx context.Context, p Principal, doc *models.Document) bool
+	// CanWriteDocuments checks whether the user may ingest or modify documents
+	CanWriteDocuments(ctx context.Context, p Principal) bool
+	GetUserPermissions(ctx context.Context, p Principal) []string
+}
+
+// Tuple is a relation of a user, or of a group's members, to a document, or to
+// the corpus for RelationWrite. Exactly one of Subject and Group is set.
+type Tuple struct {
+	Subject    string
+	Group      string
+	Relation   string
+	DocumentID uuid.UUID // uuid.Nil for RelationWrite
+}
+
+// Holder describes who holds the relation: the user or "group:<name>"
+func (t Tuple) Holder() string {
+	if t.Group != "" {
+		return "group:" + t.Group
+	}
+	return t.Subject
+}
+
+// Object returns the Keto object of the tuple
+func (t Tuple) Object() string {
+	if t.Relation == RelationWrite {
+		return CorpusObject
+	}
+	return t.DocumentID.String()
+}
+
+// Validate checks that the relation is known and applies to the object
+func (t Tuple) Validate() error {
+	switch {
+	case t.Subject == "" && t.Group == "":
+		return fmt.Errorf("subject or group is required")
+	case t.Subject != "" && t.Group != "":
+		return fmt.Errorf("subject and group are mutually exclusive")
+	case t.Group != "":
+		if err := ValidateGroupName(t.Group); err != nil {
+			return err
+		}
+	}
+	switch t.Relation {
+	case RelationViewer, RelationEditor:
+		if t.DocumentID == uuid.Nil {
+			return fmt.Errorf("relation %s requires a document", t.Relation)
+		}
+	case RelationWrite:
+		if t.DocumentID != uuid.Nil {
+			return fmt.Errorf("relation %s applies to the corpus, not a document", t.Relation)
+		}
+	default:
+		return fmt.Errorf("relation must be %s, %s, or %s", RelationViewer, RelationEditor, RelationWrite)
+	}
+	return nil
+}
+
+// PermissionManager is implemented by permission services that can change
+// relations, not just check them
+type PermissionManager interface {
+	// Grant adds the relation; granting an existing relation succeeds
+	Grant(ctx context.Context, t Tuple) error
+	// Revoke removes the relation; revoking a missing relation succeeds
+	Revoke(ctx context.Context, t Tuple) error
+	// ListTuples returns the relations the subject holds directly
+	ListTuples(ctx context.Context, subject string) ([]Tuple, error)
+}
+
+// BulkGranter is implemented by permission services that can grant many
+// relations in a few writes instead of one write per relation
+type BulkGranter interface {
+	// GrantAll grants the relations and returns the error of each failed
+	// grant, aligned with tuples; nil entries succeeded
+	GrantAll(ctx context.Context, tuples []Tuple) []error
+}
+
+// GrantAll grants the relations through manager, in bulk if it is a
+// BulkGranter and one at a time otherwise. The returned errors are aligned
+// with tuples; nil entries succeeded.
+func GrantAll(ctx context.Context, manager PermissionManager, tuples []Tuple) []error {
+	if granter, ok := manager.(BulkGranter); ok {
+		return granter.GrantAll(ctx, tuples)
+	}
+	errs := make([]error, len(tuples))
+	for i, t := range tuples {
+		errs[i] = manager.Grant(ctx, t)
+	}
+	return errs
+}
+
+// DocumentRelationLister is implemented by permission services that can list
+// who holds relations on a document
+type DocumentRelationLister interface {
+	// ListDocumentTuples returns a page of at most pageSize relations held on
+	// the document by users or groups, starting at pageToken (empty for the
+	// first page), and the token of the next page, which is empty on the last
+	ListDocumentTuples(ctx context.Context, docID uuid.UUID, pageSize int, pageToken string) ([]Tuple, string, error)
+}
+
+// OrphanChecker is implemented by permission services that can tell whether
+// anyone but corpus-wide admins can still reach a document
+type OrphanChecker interface {
+	// Orphaned reports whether no user or group holds a relation on doc and
+	// no attribute rule could grant access to it
+	Orphaned(ctx context.Context, doc *models.Document) (bool, error)
+}
+
+// AttributeGrant holds the values of a document metadata attribute that
+// grant a user read access to every document carrying one of them
+type AttributeGrant struct {
+	Attribute string
+	Values    []string
+}
+
+// AttributeGranter is implemented by permission services that can list the
+// attribute values granting a user access, so that searches can select those
+// documents by their metadata instead of checking them one by one
+type AttributeGranter interface {
+	// AttributeGrants lists the grants of the principal in the tenant of ctx.
+	// Documents no grant selects may still be readable and must be checked.
+	// It reports false if the grants could not be listed completely.
+	AttributeGrants(ctx context.Context, p Principal) ([]AttributeGrant, bool)
+}
+
+// Warmer is implemented by permission services that can load what the checks
+// of a principal need before the checked documents are known, so that it can
+// be fetched while the documents are still being searched for
+type Warmer interface {
+	// Warm loads what later checks of the principal with ctx reuse, such as
+	// the documents it may view, into the request of ctx. Failures are left
+	// to the checks, which then fetch what they need themselves.
+	Warm(ctx context.Context, p Principal)
+}
+
+// RelationStats counts the relation tuples of a tenant
+type RelationStats struct {
+	// Relations counts the tuples per relation, e.g. viewer, editor, and write
+	Relations map[string]int
+	// Memberships counts the group membership tuples
+	Memberships int
+	// Users lists the users holding a relation directly or as a group member, sorted
+	Users []string
+}
+
+// RelationCounter is implemented by permission services that can count all
+// relations of a tenant
+type RelationCounter interface {
+	// CountRelations counts the relation and membership tuples in the tenant of ctx
+	CountRelations(ctx context.Context) (RelationStats, error)
+}
+
+// RelationRemover is implemented by permission services that can delete all
+// relations on a document once the document itself is gone
+type RelationRemover interface {
+	// RemoveDocumentRelations deletes every relation tuple on the document,
+	// including group relations, in the tenant of ctx
+	RemoveDocumentRelations(ctx context.Context, docID uuid.UUID) error
+}
+
+// UserRelation is a relation a user holds directly, in the terms of the
+// permission backend: a document relation, a group membership, or an
+// attribute rule relation
+type UserRelation struct {
+	Namespace string
+	Object    string
+	Relation  string
+}
+
+// UserRelationRemover is implemented by permission services that can remove
+// every relation of a user, e.g. when offboarding them
+type UserRelationRemover interface {
+	// RemoveUserRelations deletes every relation tuple whose subject is the
+	// user in the tenant of ctx, including group memberships, and returns the
+	// deleted relations. On error, the relations deleted before it are
+	// returned with it. Cached decisions are left to the caller.
+	RemoveUserRelations(ctx context.Context, username string) ([]UserRelation, error)
+}
+
+// GroupManager is implemented by permission services that manage groups
+type GroupManager interface {
+	// AddMember adds a user to a group; adding an existing member succeeds
+	AddMember(ctx context.Context, group, user string) error
+	// RemoveMember removes a user from a group; removing a non-member succeeds
+	RemoveMember(ctx context.Context, group, user string) error
+	// Members lists the users of a group
+	Members(ctx context.Context, group string) ([]string, error)
+	// ListGroupTuples returns the relations granted to the group
+	ListGroupTuples(ctx context.Context, group string) ([]Tuple, error)
 }
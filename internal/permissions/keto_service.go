@@ -1,60 +1,192 @@
 package permissions
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"net/http"
 	"net/url"
+	"rerag-rbac-rag-llm/internal/httpclient"
 	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strconv"
+	"sync"
 
 	"github.com/google/uuid"
 )
 
+const (
+	// ketoBatchCheckSize is the maximum number of tuples sent in a single batch check request
+	ketoBatchCheckSize = 10
+	// maxConcurrentChecks bounds the number of parallel single checks used as a fallback
+	maxConcurrentChecks = 8
+	// maxConcurrentBatches bounds the number of batch check requests in flight per BatchCheck
+	maxConcurrentBatches = 4
+	// orphanPageSize is the page size of the relation listings of Orphaned
+	orphanPageSize = 10
+)
+
+// FailurePolicy decides access checks that Keto could not answer
+type FailurePolicy string
+
+const (
+	// FailClosed denies access while Keto is unavailable and marks the
+	// request's context, so the API answers 503 instead of an empty result
+	FailClosed FailurePolicy = "closed"
+	// FailDeny denies access while Keto is unavailable like a missing relation
+	FailDeny FailurePolicy = "deny"
+	// FailOpen grants read access while Keto is unavailable. Edit and write
+	// checks fail like FailClosed.
+	FailOpen FailurePolicy = "open"
+)
+
+// SelfCheckMode decides how startup reacts to problems found by SelfCheck
+type SelfCheckMode string
+
+const (
+	// SelfCheckStrict refuses to start
+	SelfCheckStrict SelfCheckMode = "strict"
+	// SelfCheckWarn logs the problems and starts anyway, e.g. while Keto is
+	// still starting next to the server
+	SelfCheckWarn SelfCheckMode = "warn"
+	// SelfCheckOff skips the self-check
+	SelfCheckOff SelfCheckMode = "off"
+)
+
+// IsValid reports whether m is a supported mode
+func (m SelfCheckMode) IsValid() bool {
+	return m == SelfCheckStrict || m == SelfCheckWarn || m == SelfCheckOff
+}
+
 // KetoPermissionService implements permission checking using Ory Keto
 type KetoPermissionService struct {
 	readURL  string
 	writeURL string
+	client   *httpclient.Client
+	policy   FailurePolicy
+	rules    []AttributeRule
+	// strategy decides BatchCheck; listLimit bounds the lists of StrategyList
+	strategy  CheckStrategy
+	listLimit int
+	naming    Naming
 }
 
-// NewKetoPermissionService creates a new Keto-based permission service
-func NewKetoPermissionService(readURL, writeURL string) *KetoPermissionService {
+// NewKetoPermissionService creates a new Keto-based permission service. client
+// applies timeouts and retries; nil sends single attempts without a timeout.
+func NewKetoPermissionService(readURL, writeURL string, client *httpclient.Client, policy FailurePolicy) *KetoPermissionService {
+	if client == nil {
+		client = httpclient.New(httpclient.Options{})
+	}
 	return &KetoPermissionService{
 		readURL:  readURL,
 		writeURL: writeURL,
+		client:   client,
+		policy:   policy,
+		naming:   DefaultNaming(),
 	}
 }
 
-// CanAccessDocument checks if a user can access a specific document
-func (k *KetoPermissionService) CanAccessDocument(username string, doc *models.Document) bool {
-	return k.canAccessDocumentByID(username, doc.ID)
+// CanAccessDocument checks if a user can access a specific document, directly
+// or through an attribute rule
+func (k *KetoPermissionService) CanAccessDocument(ctx context.Context, p Principal, doc *models.Document) bool {
+	allowed, reason := k.check(ctx, k.naming.DocumentsNamespace, p, doc.ID.String(), k.naming.Viewer)
+	decisions := []bool{allowed}
+	k.applyAttributeRules(ctx, p, []models.Document{*doc}, decisions)
+	if !decisions[0] {
+		logDeny(ctx, p.Username, RelationViewer, doc.ID.String(), reason)
+	}
+	return decisions[0]
 }
 
-// canAccessDocumentByID checks if a user can access a document by its ID
-func (k *KetoPermissionService) canAccessDocumentByID(username string, docID uuid.UUID) bool {
-	// Build the check URL
-	checkURL := fmt.Sprintf("%s/relation-tuples/check/openapi", k.readURL)
+// CanEditDocument checks if a user holds the editor relation on a document
+func (k *KetoPermissionService) CanEditDocument(ctx context.Context, p Principal, doc *models.Document) bool {
+	return k.checkLogged(ctx, p, doc.ID.String(), RelationEditor)
+}
 
-	// Create query parameters using document ID as the object
-	params := url.Values{}
-	params.Add("namespace", "documents")
-	params.Add("object", docID.String())
-	params.Add("relation", "viewer")
-	params.Add("subject_id", username)
+// CanWriteDocuments checks if a user holds the write relation on the document corpus
+func (k *KetoPermissionService) CanWriteDocuments(ctx context.Context, p Principal) bool {
+	return k.checkLogged(ctx, p, CorpusObject, RelationWrite)
+}
 
-	fullURL := fmt.Sprintf("%s?%s", checkURL, params.Encode())
+// checkLogged checks a relation of this service in the documents namespace
+// and logs a denial
+func (k *KetoPermissionService) checkLogged(ctx context.Context, p Principal, object, relation string) bool {
+	allowed, reason := k.check(ctx, k.naming.DocumentsNamespace, p, object, k.naming.relation(relation))
+	if !allowed {
+		logDeny(ctx, p.Username, relation, object, reason)
+	}
+	return allowed
+}
+
+// check asks Keto whether the principal, or a group claimed for it,
+// has the relation on the object in the tenant's copy of the namespace. A
+// denial comes with its reason.
+func (k *KetoPermissionService) check(ctx context.Context, namespace string, p Principal, object, relation string) (bool, DenyReason) {
+	allowed, reason := k.checkTuple(ctx, k.userTuple(ctx, namespace, p.Username, object, relation), p.Username)
+	if reason == DenyNoRelation && k.checkClaimedGroups(ctx, p.Groups, namespace, object, relation) {
+		return true, ""
+	}
+	return allowed, reason
+}
+
+// checkClaimedGroups reports whether any of the claimed groups holds the
+// relation on the object
+func (k *KetoPermissionService) checkClaimedGroups(ctx context.Context, groups []string, namespace, object, relation string) bool {
+	for _, group := range groups {
+		rt := relationTuple{
+			Namespace:  tenant.Namespace(ctx, namespace),
+			Object:     object,
+			Relation:   relation,
+			SubjectSet: k.groupSubject(ctx, group),
+		}
+		if allowed, _ := k.checkTuple(ctx, rt, "group:"+group); allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// userTuple is the tuple relating username to the object in the tenant's copy of the namespace
+func (k *KetoPermissionService) userTuple(ctx context.Context, namespace, username, object, relation string) relationTuple {
+	return relationTuple{
+		Namespace: tenant.Namespace(ctx, namespace),
+		Object:    object,
+		Relation:  relation,
+		SubjectID: k.naming.subject(username),
+	}
+}
+
+// checkTuple asks Keto whether the tuple exists, directly or through subject
+// sets; holder names its subject in logs. Answers are memoized for the rest of
+// the request.
+func (k *KetoPermissionService) checkTuple(ctx context.Context, rt relationTuple, holder string) (bool, DenyReason) {
+	key := rt.key()
+	if allowed, ok := memoized(ctx, key); ok {
+		if !allowed {
+			return false, DenyNoRelation
+		}
+		return true, ""
+	}
+
+	checkURL := fmt.Sprintf("%s/relation-tuples/check/openapi", k.readURL)
+	fullURL := fmt.Sprintf("%s?%s", checkURL, key)
 
 	// Validate URL before making request
 	if _, err := url.Parse(fullURL); err != nil {
-		log.Printf("Invalid URL for permission check: %v", err)
-		return false
+		requestid.Logf(ctx, "Invalid URL for permission check: %v", err)
+		return false, DenyInvalidResponse
 	}
 
-	resp, err := http.Get(fullURL) // #nosec G107 - URL is validated above
+	resp, err := k.do(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
-		log.Printf("Error checking permission for user %s on document %s: %v", username, docID, err)
-		return false
+		requestid.Logf(ctx, "Error checking %s permission for %s on %s: %v", rt.Relation, holder, rt.Object, err)
+		return k.unavailable(ctx, rt.Relation)
 	}
 	defer func() { _ = resp.Body.Close() }()
 
@@ -64,46 +196,275 @@ func (k *KetoPermissionService) canAccessDocumentByID(username string, docID uui
 		}
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			log.Printf("Error reading response body: %v", err)
-			return false
+			requestid.Logf(ctx, "Error reading response body: %v", err)
+			return false, DenyInvalidResponse
 		}
 		if err := json.Unmarshal(body, &result); err != nil {
-			log.Printf("Error unmarshaling response: %v", err)
-			return false
+			requestid.Logf(ctx, "Error unmarshaling response: %v", err)
+			return false, DenyInvalidResponse
 		}
-		return result.Allowed
+		memoize(ctx, key, result.Allowed)
+		if !result.Allowed {
+			return false, DenyNoRelation
+		}
+		return true, ""
 	}
 
-	log.Printf("Keto permission check returned status %d for user %s on document %s", resp.StatusCode, username, docID)
-	return false
+	requestid.Logf(ctx, "Keto permission check returned status %d for %s on %s", resp.StatusCode, holder, rt.Object)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return k.unavailable(ctx, rt.Relation)
+	}
+	return false, DenyInvalidResponse
+}
+
+// unavailable returns the decision for a check Keto could not answer. Checks
+// of canceled or timed out requests are denied without counting as an outage,
+// whatever the policy. Unless the policy is FailDeny, a denial marks ctx so
+// the request can fail with 503. Decisions Keto did not answer are never cached.
+func (k *KetoPermissionService) unavailable(ctx context.Context, relation string) (bool, DenyReason) {
+	markUnanswered(ctx)
+	if ctx.Err() != nil {
+		return false, DenyCanceled
+	}
+	if k.policy == FailOpen && relation == k.naming.Viewer {
+		return true, ""
+	}
+	if k.policy != FailDeny {
+		markUnavailable(ctx)
+	}
+	return false, DenyUnavailable
+}
+
+// BatchCheck checks access to multiple documents. Under StrategyList it
+// intersects them with the documents the user may view; otherwise, or if the
+// user may view too many documents to list, it checks each document.
+func (k *KetoPermissionService) BatchCheck(ctx context.Context, p Principal, docs []models.Document) []bool {
+	results := make([]bool, len(docs))
+	reasons := make([]DenyReason, len(docs))
+
+	if viewable, ok := k.viewable(ctx, p); ok {
+		for i := range docs {
+			results[i] = viewable[docs[i].ID.String()]
+			if !results[i] {
+				reasons[i] = DenyNoRelation
+			}
+		}
+	} else {
+		k.checkEach(ctx, p, docs, results, reasons)
+	}
+
+	k.applyAttributeRules(ctx, p, docs, results)
+	for i, allowed := range results {
+		if !allowed {
+			logDeny(ctx, p.Username, RelationViewer, docs[i].ID.String(), reasons[i])
+		}
+	}
+	return results
 }
 
-// GetUserPermissions retrieves all permissions for a given user
-func (k *KetoPermissionService) GetUserPermissions(username string) []string {
+// checkEach decides access to docs using Keto's batch check endpoint, sending
+// up to maxConcurrentBatches batches at once. Documents already checked in the
+// request are not checked again. If the batch endpoint is unavailable it falls
+// back to parallel single checks with bounded concurrency.
+func (k *KetoPermissionService) checkEach(ctx context.Context, p Principal, docs []models.Document, results []bool, reasons []DenyReason) {
+	var pending []int
+	for i := range docs {
+		allowed, ok := memoized(ctx, k.viewerTuple(ctx, p.Username, docs[i].ID).key())
+		switch {
+		case !ok:
+			pending = append(pending, i)
+		case allowed:
+			results[i] = true
+		default:
+			reasons[i] = DenyNoRelation
+		}
+	}
+
+	sem := make(chan struct{}, maxConcurrentBatches)
+	var wg sync.WaitGroup
+	for start := 0; start < len(pending); start += ketoBatchCheckSize {
+		indexes := pending[start:min(start+ketoBatchCheckSize, len(pending))]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			k.checkBatch(ctx, p.Username, docs, indexes, results, reasons)
+		}()
+	}
+	wg.Wait()
+	k.checkDeniedThroughGroups(ctx, p.Groups, docs, results, reasons)
+}
+
+// checkBatch checks the documents at indexes with one batch check request,
+// or with single checks if it fails, and stores the decisions at the same
+// indexes of results and reasons
+func (k *KetoPermissionService) checkBatch(ctx context.Context, username string, docs []models.Document, indexes []int, results []bool, reasons []DenyReason) {
+	batch := make([]models.Document, len(indexes))
+	for j, i := range indexes {
+		batch[j] = docs[i]
+	}
+	allowed, denied, err := k.batchCheckRequest(ctx, username, batch)
+	if err != nil {
+		requestid.Logf(ctx, "Keto batch check failed for user %s, falling back to parallel checks: %v", username, err)
+		allowed, denied = k.parallelCheck(ctx, username, batch)
+	}
+	for j, i := range indexes {
+		results[i], reasons[i] = allowed[j], denied[j]
+	}
+}
+
+// batchCheckRequest performs a single call to Keto's batch check endpoint and
+// returns the decisions and the reasons of denials, memoizing the definite ones
+func (k *KetoPermissionService) batchCheckRequest(ctx context.Context, username string, docs []models.Document) ([]bool, []DenyReason, error) {
+	type tuple struct {
+		Namespace string `json:"namespace"`
+		Object    string `json:"object"`
+		Relation  string `json:"relation"`
+		SubjectID string `json:"subject_id"`
+	}
+
+	tuples := make([]tuple, len(docs))
+	for i := range docs {
+		rt := k.viewerTuple(ctx, username, docs[i].ID)
+		tuples[i] = tuple{
+			Namespace: rt.Namespace,
+			Object:    rt.Object,
+			Relation:  rt.Relation,
+			SubjectID: rt.SubjectID,
+		}
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{"tuples": tuples})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	batchURL := fmt.Sprintf("%s/relation-tuples/batch/check", k.readURL)
+	resp, err := k.do(ctx, http.MethodPost, batchURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("batch check returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result struct {
+		Results []struct {
+			Allowed bool   `json:"allowed"`
+			Error   string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, nil, err
+	}
+
+	if len(result.Results) != len(docs) {
+		return nil, nil, fmt.Errorf("batch check returned %d results for %d tuples", len(result.Results), len(docs))
+	}
+
+	allowed := make([]bool, len(docs))
+	reasons := make([]DenyReason, len(docs))
+	for i, r := range result.Results {
+		key := k.viewerTuple(ctx, username, docs[i].ID).key()
+		switch {
+		case r.Error != "":
+			requestid.Logf(ctx, "Keto batch check error for user %s on document %s: %s", username, docs[i].ID, r.Error)
+			reasons[i] = DenyInvalidResponse
+		case r.Allowed:
+			allowed[i] = true
+			memoize(ctx, key, true)
+		default:
+			reasons[i] = DenyNoRelation
+			memoize(ctx, key, false)
+		}
+	}
+
+	return allowed, reasons, nil
+}
+
+// checkDeniedThroughGroups grants the documents denied for lack of a relation
+// that one of the claimed groups may view
+func (k *KetoPermissionService) checkDeniedThroughGroups(ctx context.Context, groups []string, docs []models.Document, results []bool, reasons []DenyReason) {
+	if len(groups) == 0 {
+		return
+	}
+	sem := make(chan struct{}, maxConcurrentChecks)
+
+	var wg sync.WaitGroup
+	for i := range docs {
+		if results[i] || reasons[i] != DenyNoRelation {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if k.checkClaimedGroups(ctx, groups, k.naming.DocumentsNamespace, docs[i].ID.String(), k.naming.Viewer) {
+				results[i], reasons[i] = true, ""
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// parallelCheck runs single permission checks concurrently, bounded by maxConcurrentChecks
+func (k *KetoPermissionService) parallelCheck(ctx context.Context, username string, docs []models.Document) ([]bool, []DenyReason) {
+	allowed := make([]bool, len(docs))
+	reasons := make([]DenyReason, len(docs))
+	sem := make(chan struct{}, maxConcurrentChecks)
+
+	var wg sync.WaitGroup
+	for i := range docs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			allowed[i], reasons[i] = k.checkTuple(ctx, k.viewerTuple(ctx, username, docs[i].ID), username)
+		}(i)
+	}
+	wg.Wait()
+
+	return allowed, reasons
+}
+
+// GetUserPermissions lists the objects a user holds relations on, directly or
+// through groups, followed by the attribute values of attribute rules as
+// "<namespace>:<value>"
+func (k *KetoPermissionService) GetUserPermissions(ctx context.Context, p Principal) []string {
 	// Build the list URL
 	listURL := fmt.Sprintf("%s/relation-tuples", k.readURL)
 
 	params := url.Values{}
-	params.Add("namespace", "documents")
-	params.Add("subject_id", username)
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.DocumentsNamespace))
+	params.Add("subject_id", k.naming.subject(p.Username))
 
 	fullURL := fmt.Sprintf("%s?%s", listURL, params.Encode())
 
 	// Validate URL before making request
 	if _, err := url.Parse(fullURL); err != nil {
-		log.Printf("Invalid URL for listing permissions: %v", err)
+		requestid.Logf(ctx, "Invalid URL for listing permissions: %v", err)
 		return []string{}
 	}
 
-	resp, err := http.Get(fullURL) // #nosec G107 - URL is validated above
+	resp, err := k.do(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
-		log.Printf("Error getting permissions for user %s: %v", username, err)
+		requestid.Logf(ctx, "Error getting permissions for user %s: %v", p.Username, err)
 		return []string{}
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Keto list relation tuples returned status %d for user %s", resp.StatusCode, username)
+		requestid.Logf(ctx, "Keto list relation tuples returned status %d for user %s", resp.StatusCode, p.Username)
 		return []string{}
 	}
 
@@ -117,16 +478,620 @@ func (k *KetoPermissionService) GetUserPermissions(username string) []string {
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
+		requestid.Logf(ctx, "Error reading response body: %v", err)
 		return permissions
 	}
 	if err := json.Unmarshal(body, &result); err != nil {
-		log.Printf("Error unmarshaling response: %v", err)
+		requestid.Logf(ctx, "Error unmarshaling response: %v", err)
 		return permissions
 	}
 	for _, tuple := range result.RelationTuples {
 		permissions = append(permissions, tuple.Object)
 	}
 
-	return permissions
+	// Add the objects the user holds relations on through groups, including
+	// the groups claimed for the user
+	groups, err := k.groups(ctx, p.Username)
+	if err != nil {
+		requestid.Logf(ctx, "Error listing groups of user %s: %v", p.Username, err)
+	}
+	for _, group := range p.Groups {
+		if !slices.Contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+	for _, group := range groups {
+		tuples, err := k.ListGroupTuples(ctx, group)
+		if err != nil {
+			requestid.Logf(ctx, "Error listing permissions of group %s: %v", group, err)
+			continue
+		}
+		for _, t := range tuples {
+			if !slices.Contains(permissions, t.Object()) {
+				permissions = append(permissions, t.Object())
+			}
+		}
+	}
+
+	return append(permissions, k.attributeObjects(ctx, p.Username)...)
+}
+
+// relationTuple is a Keto relation tuple; exactly one of SubjectID and SubjectSet is set
+type relationTuple struct {
+	Namespace  string      `json:"namespace"`
+	Object     string      `json:"object"`
+	Relation   string      `json:"relation"`
+	SubjectID  string      `json:"subject_id,omitempty"`
+	SubjectSet *subjectSet `json:"subject_set,omitempty"`
+}
+
+// subjectSet refers to all subjects holding Relation on Object, e.g. group#member
+type subjectSet struct {
+	Namespace string `json:"namespace"`
+	Object    string `json:"object"`
+	Relation  string `json:"relation"`
+}
+
+// query encodes the tuple as the query parameters of the Keto APIs
+// key identifies the tuple in memoized checks
+func (rt relationTuple) key() string {
+	return rt.query().Encode()
+}
+
+func (rt relationTuple) query() url.Values {
+	params := url.Values{}
+	params.Add("namespace", rt.Namespace)
+	params.Add("object", rt.Object)
+	params.Add("relation", rt.Relation)
+	if rt.SubjectSet != nil {
+		params.Add("subject_set.namespace", rt.SubjectSet.Namespace)
+		params.Add("subject_set.object", rt.SubjectSet.Object)
+		params.Add("subject_set.relation", rt.SubjectSet.Relation)
+	} else {
+		params.Add("subject_id", rt.SubjectID)
+	}
+	return params
+}
+
+// viewerTuple is the tuple granting username read access to the document
+func (k *KetoPermissionService) viewerTuple(ctx context.Context, username string, docID uuid.UUID) relationTuple {
+	return k.userTuple(ctx, k.naming.DocumentsNamespace, username, docID.String(), k.naming.Viewer)
+}
+
+// groupSubject is the subject set of a group's members in the tenant's groups namespace
+func (k *KetoPermissionService) groupSubject(ctx context.Context, group string) *subjectSet {
+	return &subjectSet{
+		Namespace: tenant.Namespace(ctx, k.naming.GroupsNamespace),
+		Object:    group,
+		Relation:  k.naming.Member,
+	}
+}
+
+// documentTuple converts a Tuple into the tenant's documents namespace
+func (k *KetoPermissionService) documentTuple(ctx context.Context, t Tuple) relationTuple {
+	rt := relationTuple{
+		Namespace: tenant.Namespace(ctx, k.naming.DocumentsNamespace),
+		Object:    t.Object(),
+		Relation:  k.naming.relation(t.Relation),
+	}
+	if t.Group != "" {
+		rt.SubjectSet = k.groupSubject(ctx, t.Group)
+	} else {
+		rt.SubjectID = k.naming.subject(t.Subject)
+	}
+	return rt
+}
+
+// membershipTuple is the tuple making user a member of group
+func (k *KetoPermissionService) membershipTuple(ctx context.Context, group, user string) relationTuple {
+	return relationTuple{
+		Namespace: tenant.Namespace(ctx, k.naming.GroupsNamespace),
+		Object:    group,
+		Relation:  k.naming.Member,
+		SubjectID: k.naming.subject(user),
+	}
+}
+
+// Grant writes a relation tuple through the Keto write API
+func (k *KetoPermissionService) Grant(ctx context.Context, t Tuple) error {
+	if err := k.putTuple(ctx, k.documentTuple(ctx, t)); err != nil {
+		return fmt.Errorf("failed to grant %s on %s to %s: %w", t.Relation, t.Object(), t.Holder(), err)
+	}
+	return nil
+}
+
+// patchBatchSize is the number of tuples written in one transaction by GrantAll
+const patchBatchSize = 100
+
+// GrantAll writes the relation tuples through Keto's patch API in
+// transactions of patchBatchSize tuples. A failed transaction fails all of its
+// grants and leaves the other transactions applied.
+func (k *KetoPermissionService) GrantAll(ctx context.Context, tuples []Tuple) []error {
+	errs := make([]error, len(tuples))
+	for start := 0; start < len(tuples); start += patchBatchSize {
+		batch := tuples[start:min(start+patchBatchSize, len(tuples))]
+		deltas := make([]tupleDelta, len(batch))
+		for i, t := range batch {
+			deltas[i] = tupleDelta{Action: "insert", RelationTuple: k.documentTuple(ctx, t)}
+		}
+		if err := k.patchTuples(ctx, deltas); err != nil {
+			err = fmt.Errorf("failed to grant %d relations in one transaction: %w", len(batch), err)
+			for i := range batch {
+				errs[start+i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// Revoke deletes a relation tuple through the Keto write API. Revoking a tuple
+// that does not exist succeeds.
+func (k *KetoPermissionService) Revoke(ctx context.Context, t Tuple) error {
+	if err := k.deleteTuple(ctx, k.documentTuple(ctx, t)); err != nil {
+		return fmt.Errorf("failed to revoke %s on %s from %s: %w", t.Relation, t.Object(), t.Holder(), err)
+	}
+	return nil
+}
+
+// RemoveDocumentRelations deletes all relation tuples whose object is the
+// document in the tenant's namespace
+func (k *KetoPermissionService) RemoveDocumentRelations(ctx context.Context, docID uuid.UUID) error {
+	defer forgetDecisions(ctx)
+	params := url.Values{}
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.DocumentsNamespace))
+	params.Add("object", docID.String())
+
+	resp, err := k.do(ctx, http.MethodDelete, k.writeURL+"/admin/relation-tuples?"+params.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to remove relations on %s: %w", docID, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to remove relations on %s: keto returned status %d", docID, resp.StatusCode)
+	}
+	return nil
+}
+
+// ListTuples lists the subject's relation tuples in the tenant's namespace.
+// Tuples with relations or objects this service does not manage are skipped;
+// relations held through groups are not included.
+func (k *KetoPermissionService) ListTuples(ctx context.Context, subject string) ([]Tuple, error) {
+	params := url.Values{}
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.DocumentsNamespace))
+	params.Add("subject_id", k.naming.subject(subject))
+
+	raw, err := k.listTuples(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list relations of %s: %w", subject, err)
+	}
+	return k.toTuples(raw, Tuple{Subject: subject}), nil
+}
+
+// RemoveUserRelations lists the tuples whose subject is the user in the
+// tenant's documents and groups namespaces and those of attribute rules,
+// following Keto's pagination, and deletes them in transactions of
+// patchBatchSize tuples
+func (k *KetoPermissionService) RemoveUserRelations(ctx context.Context, username string) ([]UserRelation, error) {
+	namespaces := []string{k.naming.DocumentsNamespace, k.naming.GroupsNamespace}
+	for _, rule := range k.rules {
+		namespaces = append(namespaces, rule.Namespace)
+	}
+
+	var raw []relationTuple
+	for _, namespace := range namespaces {
+		params := url.Values{}
+		params.Add("namespace", tenant.Namespace(ctx, namespace))
+		params.Add("subject_id", k.naming.subject(username))
+		tuples, err := k.listTuples(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s relations of %s: %w", namespace, username, err)
+		}
+		raw = append(raw, tuples...)
+	}
+
+	var removed []UserRelation
+	for batch := range slices.Chunk(raw, patchBatchSize) {
+		deltas := make([]tupleDelta, len(batch))
+		for i, rt := range batch {
+			deltas[i] = tupleDelta{Action: "delete", RelationTuple: rt}
+		}
+		if err := k.patchTuples(ctx, deltas); err != nil {
+			return removed, fmt.Errorf("failed to remove relations of %s: %w", username, err)
+		}
+		for _, rt := range batch {
+			removed = append(removed, UserRelation{Namespace: rt.Namespace, Object: rt.Object, Relation: rt.Relation})
+		}
+	}
+	return removed, nil
+}
+
+// ListDocumentTuples lists a page of the relation tuples on the document in
+// the tenant's namespace. Relations of subject sets other than groups and
+// relations this service does not manage are skipped, so a page may hold
+// fewer than pageSize tuples before the last.
+func (k *KetoPermissionService) ListDocumentTuples(ctx context.Context, docID uuid.UUID, pageSize int, pageToken string) ([]Tuple, string, error) {
+	params := url.Values{}
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.DocumentsNamespace))
+	params.Add("object", docID.String())
+	params.Add("page_size", strconv.Itoa(pageSize))
+	if pageToken != "" {
+		params.Add("page_token", pageToken)
+	}
+
+	raw, next, err := k.listTuplesPage(ctx, params)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list relations on %s: %w", docID, err)
+	}
+	groups := tenant.Namespace(ctx, k.naming.GroupsNamespace)
+	var tuples []Tuple
+	for _, rt := range raw {
+		var holder Tuple
+		if rt.SubjectSet != nil {
+			if rt.SubjectSet.Namespace != groups || rt.SubjectSet.Relation != k.naming.Member {
+				continue
+			}
+			holder = Tuple{Group: rt.SubjectSet.Object}
+		} else if username, ok := k.naming.username(rt.SubjectID); ok {
+			holder = Tuple{Subject: username}
+		} else {
+			continue
+		}
+		tuples = append(tuples, k.toTuples([]relationTuple{rt}, holder)...)
+	}
+	return tuples, next, nil
+}
+
+// CountRelations lists every relation tuple of the tenant's documents and
+// groups namespaces and counts them. Relations this service does not manage
+// are skipped.
+func (k *KetoPermissionService) CountRelations(ctx context.Context) (RelationStats, error) {
+	params := url.Values{}
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.DocumentsNamespace))
+	raw, err := k.listTuples(ctx, params)
+	if err != nil {
+		return RelationStats{}, fmt.Errorf("failed to list relations: %w", err)
+	}
+	stats := RelationStats{Relations: make(map[string]int)}
+	users := make(map[string]bool)
+	for _, rt := range raw {
+		relation, ok := k.naming.documentRelation(rt.Relation)
+		if !ok {
+			continue
+		}
+		stats.Relations[relation]++
+		if username, ok := k.naming.username(rt.SubjectID); rt.SubjectID != "" && ok {
+			users[username] = true
+		}
+	}
+
+	params = url.Values{}
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.GroupsNamespace))
+	params.Add("relation", k.naming.Member)
+	raw, err = k.listTuples(ctx, params)
+	if err != nil {
+		return RelationStats{}, fmt.Errorf("failed to list group members: %w", err)
+	}
+	for _, rt := range raw {
+		if username, ok := k.naming.username(rt.SubjectID); rt.SubjectID != "" && ok {
+			stats.Memberships++
+			users[username] = true
+		}
+	}
+	stats.Users = slices.Sorted(maps.Keys(users))
+	return stats, nil
+}
+
+// Orphaned reports whether doc has no relation tuples left. Documents with a
+// metadata value an attribute rule evaluates are never orphaned, since
+// finding out whether anyone holds the rule's relation on the value would
+// need a check per subject.
+func (k *KetoPermissionService) Orphaned(ctx context.Context, doc *models.Document) (bool, error) {
+	for _, rule := range k.rules {
+		if value, ok := doc.Metadata[rule.Attribute].(string); ok && value != "" {
+			return false, nil
+		}
+	}
+	token := ""
+	for {
+		tuples, next, err := k.ListDocumentTuples(ctx, doc.ID, orphanPageSize, token)
+		if err != nil {
+			return false, err
+		}
+		if len(tuples) > 0 {
+			return false, nil
+		}
+		if next == "" {
+			return true, nil
+		}
+		token = next
+	}
+}
+
+// AddMember writes the membership tuple group#member@user
+func (k *KetoPermissionService) AddMember(ctx context.Context, group, user string) error {
+	if err := k.putTuple(ctx, k.membershipTuple(ctx, group, user)); err != nil {
+		return fmt.Errorf("failed to add %s to group %s: %w", user, group, err)
+	}
+	return nil
+}
+
+// RemoveMember deletes the membership tuple group#member@user
+func (k *KetoPermissionService) RemoveMember(ctx context.Context, group, user string) error {
+	if err := k.deleteTuple(ctx, k.membershipTuple(ctx, group, user)); err != nil {
+		return fmt.Errorf("failed to remove %s from group %s: %w", user, group, err)
+	}
+	return nil
+}
+
+// Members lists the direct members of a group
+func (k *KetoPermissionService) Members(ctx context.Context, group string) ([]string, error) {
+	params := url.Values{}
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.GroupsNamespace))
+	params.Add("object", group)
+	params.Add("relation", k.naming.Member)
+
+	raw, err := k.listTuples(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of group %s: %w", group, err)
+	}
+	members := make([]string, 0, len(raw))
+	for _, rt := range raw {
+		if username, ok := k.naming.username(rt.SubjectID); rt.SubjectID != "" && ok {
+			members = append(members, username)
+		}
+	}
+	return members, nil
+}
+
+// ListGroupTuples lists the relations granted to a group's members
+func (k *KetoPermissionService) ListGroupTuples(ctx context.Context, group string) ([]Tuple, error) {
+	set := k.groupSubject(ctx, group)
+	params := url.Values{}
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.DocumentsNamespace))
+	params.Add("subject_set.namespace", set.Namespace)
+	params.Add("subject_set.object", set.Object)
+	params.Add("subject_set.relation", set.Relation)
+
+	raw, err := k.listTuples(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list relations of group %s: %w", group, err)
+	}
+	return k.toTuples(raw, Tuple{Group: group}), nil
+}
+
+// groups lists the groups the user is a direct member of
+func (k *KetoPermissionService) groups(ctx context.Context, username string) ([]string, error) {
+	params := url.Values{}
+	params.Add("namespace", tenant.Namespace(ctx, k.naming.GroupsNamespace))
+	params.Add("relation", k.naming.Member)
+	params.Add("subject_id", k.naming.subject(username))
+
+	raw, err := k.listTuples(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, len(raw))
+	for i, rt := range raw {
+		groups[i] = rt.Object
+	}
+	return groups, nil
+}
+
+// toTuples converts listed Keto tuples to Tuples held by holder, skipping
+// relations and objects this service does not manage
+func (k *KetoPermissionService) toTuples(raw []relationTuple, holder Tuple) []Tuple {
+	var tuples []Tuple
+	for _, rt := range raw {
+		relation, ok := k.naming.documentRelation(rt.Relation)
+		if !ok {
+			continue
+		}
+		t := holder
+		t.Relation = relation
+		if rt.Object != CorpusObject {
+			docID, err := uuid.Parse(rt.Object)
+			if err != nil {
+				continue
+			}
+			t.DocumentID = docID
+		}
+		if t.Validate() == nil {
+			tuples = append(tuples, t)
+		}
+	}
+	return tuples
+}
+
+// putTuple creates a relation tuple; creating an existing tuple succeeds
+func (k *KetoPermissionService) putTuple(ctx context.Context, rt relationTuple) error {
+	defer forgetDecisions(ctx)
+	jsonData, err := json.Marshal(rt)
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.do(ctx, http.MethodPut, k.writeURL+"/admin/relation-tuples", bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("keto returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// tupleDelta is a change of Keto's patch API
+type tupleDelta struct {
+	Action        string        `json:"action"`
+	RelationTuple relationTuple `json:"relation_tuple"`
+}
+
+// patchTuples applies the changes in one transaction; inserting an existing
+// tuple succeeds
+func (k *KetoPermissionService) patchTuples(ctx context.Context, deltas []tupleDelta) error {
+	defer forgetDecisions(ctx)
+	jsonData, err := json.Marshal(deltas)
+	if err != nil {
+		return err
+	}
+
+	resp, err := k.do(ctx, http.MethodPatch, k.writeURL+"/admin/relation-tuples", bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("keto returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deleteTuple deletes a relation tuple; deleting a missing tuple succeeds
+func (k *KetoPermissionService) deleteTuple(ctx context.Context, rt relationTuple) error {
+	defer forgetDecisions(ctx)
+	resp, err := k.do(ctx, http.MethodDelete, k.writeURL+"/admin/relation-tuples?"+rt.query().Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("keto returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// listTuples lists the relation tuples matching params, following Keto's pagination
+func (k *KetoPermissionService) listTuples(ctx context.Context, params url.Values) ([]relationTuple, error) {
+	var tuples []relationTuple
+	for {
+		page, next, err := k.listTuplesPage(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, page...)
+		if next == "" {
+			return tuples, nil
+		}
+		params.Set("page_token", next)
+	}
+}
+
+// listTuplesPage lists one page of the relation tuples matching params and
+// returns the token of the next page, empty on the last
+func (k *KetoPermissionService) listTuplesPage(ctx context.Context, params url.Values) ([]relationTuple, string, error) {
+	resp, err := k.do(ctx, http.MethodGet, k.readURL+"/relation-tuples?"+params.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	var result struct {
+		RelationTuples []relationTuple `json:"relation_tuples"`
+		NextPageToken  string          `json:"next_page_token"`
+	}
+	status := resp.StatusCode
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	_ = resp.Body.Close()
+	if status != http.StatusOK {
+		return nil, "", fmt.Errorf("keto returned status %d", status)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode relation tuples: %w", err)
+	}
+	return result.RelationTuples, result.NextPageToken, nil
+}
+
+// Ping checks that the Keto read API is ready to serve requests
+func (k *KetoPermissionService) Ping(ctx context.Context) error {
+	return k.ready(ctx, k.readURL)
+}
+
+// ready checks that the Keto API at baseURL is ready to serve requests
+func (k *KetoPermissionService) ready(ctx context.Context, baseURL string) error {
+	resp, err := k.do(ctx, http.MethodGet, baseURL+"/health/ready", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("keto returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SelfCheck verifies the configuration against the running Keto: that the
+// read and write APIs are ready and that the default tenant's documents and
+// groups namespaces, and those of the attribute rules, exist. Misconfigured
+// URLs or namespaces otherwise only show as checks that always deny. It
+// returns every problem found.
+func (k *KetoPermissionService) SelfCheck(ctx context.Context) error {
+	var errs []error
+	if err := k.ready(ctx, k.readURL); err != nil {
+		errs = append(errs, fmt.Errorf("read API %s is not ready: %w", k.readURL, err))
+	}
+	if err := k.ready(ctx, k.writeURL); err != nil {
+		errs = append(errs, fmt.Errorf("write API %s is not ready: %w", k.writeURL, err))
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	namespaces, err := k.namespaces(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	required := []string{k.naming.DocumentsNamespace, k.naming.GroupsNamespace}
+	for _, rule := range k.rules {
+		required = append(required, rule.Namespace)
+	}
+	for i, namespace := range required {
+		if !slices.Contains(namespaces, namespace) && !slices.Contains(required[:i], namespace) {
+			errs = append(errs, fmt.Errorf("namespace %q is not configured in keto", namespace))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// namespaces lists the namespaces configured in Keto
+func (k *KetoPermissionService) namespaces(ctx context.Context) ([]string, error) {
+	resp, err := k.do(ctx, http.MethodGet, k.readURL+"/namespaces", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("keto returned status %d", resp.StatusCode)
+	}
+	var result struct {
+		Namespaces []struct {
+			Name string `json:"name"`
+		} `json:"namespaces"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode namespaces: %w", err)
+	}
+	names := make([]string, len(result.Namespaces))
+	for i, namespace := range result.Namespaces {
+		names[i] = namespace.Name
+	}
+	return names, nil
+}
+
+// do sends a request to Keto, forwarding the request ID as a correlation header
+func (k *KetoPermissionService) do(ctx context.Context, method, rawURL string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	requestid.SetHeader(ctx, req)
+
+	return k.client.Do(req) // #nosec G107 - URL is built from configuration
 }
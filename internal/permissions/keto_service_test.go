@@ -0,0 +1,691 @@
+package permissions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func newTestKeto(url string, policy FailurePolicy) *KetoPermissionService {
+	client := httpclient.New(httpclient.Options{
+		Timeout:    time.Second,
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+		MaxDelay:   time.Millisecond,
+	})
+	return NewKetoPermissionService(url, url, client, policy)
+}
+
+func TestKetoCheckRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"allowed": true}`))
+	}))
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	if !keto.CanAccessDocument(context.Background(), Principal{Username: "alice"}, &models.Document{ID: uuid.New()}) {
+		t.Error("Expected access after a retried server error")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected 2 calls, got %d", calls.Load())
+	}
+}
+
+func TestKetoFailurePolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	doc := &models.Document{ID: uuid.New()}
+
+	closed := newTestKeto(server.URL, FailClosed)
+	if closed.CanAccessDocument(ctx, Principal{Username: "alice"}, doc) {
+		t.Error("Expected fail-closed to deny read access")
+	}
+	tracked := TrackOutcome(ctx)
+	if closed.BatchCheck(tracked, Principal{Username: "alice"}, []models.Document{*doc})[0] || !Unavailable(tracked) {
+		t.Error("Expected fail-closed to deny and mark the request as unavailable")
+	}
+
+	deny := newTestKeto(server.URL, FailDeny)
+	tracked = TrackOutcome(ctx)
+	if deny.CanAccessDocument(tracked, Principal{Username: "alice"}, doc) || Unavailable(tracked) {
+		t.Error("Expected fail-deny to deny like a missing relation")
+	}
+
+	open := newTestKeto(server.URL, FailOpen)
+	if !open.CanAccessDocument(ctx, Principal{Username: "alice"}, doc) {
+		t.Error("Expected fail-open to grant read access")
+	}
+	if allowed := open.BatchCheck(ctx, Principal{Username: "alice"}, []models.Document{*doc}); !allowed[0] {
+		t.Error("Expected fail-open to grant read access in batch checks")
+	}
+	tracked = TrackOutcome(ctx)
+	if open.CanEditDocument(tracked, Principal{Username: "alice"}, doc) || open.CanWriteDocuments(tracked, Principal{Username: "alice"}) || !Unavailable(tracked) {
+		t.Error("Expected edit and write checks to fail closed")
+	}
+}
+
+func TestKetoAnsweredDenialIsNotAnOutage(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	ctx := TrackOutcome(context.Background())
+	keto := newTestKeto(server.URL, FailClosed)
+	if keto.CanAccessDocument(ctx, Principal{Username: "alice"}, &models.Document{ID: uuid.New()}) || keto.CanWriteDocuments(ctx, Principal{Username: "alice"}) {
+		t.Error("Expected denials without relations")
+	}
+	if Unavailable(ctx) {
+		t.Error("Expected denials Keto answered not to mark the request as unavailable")
+	}
+}
+
+func TestKetoDeniesOnClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	open := newTestKeto(server.URL, FailOpen)
+	if open.CanAccessDocument(context.Background(), Principal{Username: "alice"}, &models.Document{ID: uuid.New()}) {
+		t.Error("Expected a rejected check to deny access even when failing open")
+	}
+}
+
+func TestKetoGrantAndRevoke(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method {
+		case http.MethodPut:
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			if r.URL.Query().Get("object") != CorpusObject || r.URL.Query().Get("relation") != RelationWrite {
+				t.Errorf("Unexpected revoke query %s", r.URL.RawQuery)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	ctx := context.Background()
+	if err := keto.Grant(ctx, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: uuid.New()}); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+	if err := keto.Revoke(ctx, Tuple{Subject: "alice", Relation: RelationWrite}); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+
+	want := []string{"PUT /admin/relation-tuples", "DELETE /admin/relation-tuples"}
+	if len(requests) != 2 || requests[0] != want[0] || requests[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, requests)
+	}
+}
+
+func TestKetoGrantAll(t *testing.T) {
+	rejected := uuid.New()
+	fake := &fakeKeto{rejectObject: rejected.String()}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	tuples := make([]Tuple, 0, 2*patchBatchSize)
+	for range 2*patchBatchSize - 1 {
+		tuples = append(tuples, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: uuid.New()})
+	}
+	// Fails the second transaction only
+	tuples = append(tuples, Tuple{Group: "payroll", Relation: RelationEditor, DocumentID: rejected})
+
+	errs := GrantAll(context.Background(), keto, tuples)
+	for i, err := range errs {
+		if failed := i >= patchBatchSize; (err != nil) != failed {
+			t.Fatalf("Grant %d: expected failed=%t, got %v", i, failed, err)
+		}
+	}
+	if len(fake.tuples) != patchBatchSize {
+		t.Errorf("Expected the first transaction to be applied, got %d tuples", len(fake.tuples))
+	}
+}
+
+func TestKetoListTuplesFollowsPages(t *testing.T) {
+	docID := uuid.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("subject_id") != "alice" {
+			t.Errorf("Unexpected subject %q", r.URL.Query().Get("subject_id"))
+		}
+		if r.URL.Query().Get("page_token") == "" {
+			_, _ = fmt.Fprintf(w, `{"relation_tuples":[{"object":%q,"relation":"viewer"},{"object":"john-doe:2023","relation":"viewer"}],"next_page_token":"next"}`, docID)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"relation_tuples":[{"object":"corpus","relation":"write"},{"object":"corpus","relation":"owner"}]}`)
+	}))
+	defer server.Close()
+
+	tuples, err := newTestKeto(server.URL, FailClosed).ListTuples(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("ListTuples failed: %v", err)
+	}
+	want := []Tuple{
+		{Subject: "alice", Relation: RelationViewer, DocumentID: docID},
+		{Subject: "alice", Relation: RelationWrite},
+	}
+	if !slices.Equal(tuples, want) {
+		t.Errorf("Expected %v, got %v", want, tuples)
+	}
+}
+
+// fakeKeto stores relation tuples in memory and answers checks by expanding
+// subject sets like Keto
+type fakeKeto struct {
+	mu         sync.Mutex
+	tuples     []relationTuple
+	namespaces []string
+	// rejectObject fails patch transactions that insert a tuple on it
+	rejectObject string
+}
+
+func (f *fakeKeto) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+
+	switch r.Method + " " + r.URL.Path {
+	case "PUT /admin/relation-tuples":
+		var rt relationTuple
+		if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !slices.ContainsFunc(f.tuples, rt.equal) {
+			f.tuples = append(f.tuples, rt)
+		}
+		w.WriteHeader(http.StatusCreated)
+	case "PATCH /admin/relation-tuples":
+		var deltas []tupleDelta
+		if err := json.NewDecoder(r.Body).Decode(&deltas); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if slices.ContainsFunc(deltas, func(d tupleDelta) bool { return d.RelationTuple.Object == f.rejectObject }) {
+			http.Error(w, "rejected", http.StatusBadRequest)
+			return
+		}
+		for _, d := range deltas {
+			switch {
+			case d.Action == "delete":
+				f.tuples = slices.DeleteFunc(f.tuples, d.RelationTuple.equal)
+			case !slices.ContainsFunc(f.tuples, d.RelationTuple.equal):
+				f.tuples = append(f.tuples, d.RelationTuple)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE /admin/relation-tuples":
+		f.tuples = slices.DeleteFunc(f.tuples, func(rt relationTuple) bool { return matches(rt, query) })
+		w.WriteHeader(http.StatusNoContent)
+	case "GET /relation-tuples":
+		matched := []relationTuple{}
+		for _, rt := range f.tuples {
+			if matches(rt, query) {
+				matched = append(matched, rt)
+			}
+		}
+		// Page tokens are offsets into the matches
+		start, _ := strconv.Atoi(query.Get("page_token"))
+		end, next := len(matched), ""
+		if size, _ := strconv.Atoi(query.Get("page_size")); size > 0 && start+size < end {
+			end, next = start+size, strconv.Itoa(start+size)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"relation_tuples": matched[min(start, len(matched)):end], "next_page_token": next})
+	case "GET /relation-tuples/check/openapi":
+		allowed := f.check(query.Get("namespace"), query.Get("object"), query.Get("relation"), query.Get("subject_id"))
+		if query.Has("subject_set.namespace") {
+			allowed = slices.ContainsFunc(f.tuples, func(rt relationTuple) bool { return matches(rt, query) })
+		}
+		_, _ = fmt.Fprintf(w, `{"allowed":%t}`, allowed)
+	case "POST /relation-tuples/batch/check":
+		var batch struct {
+			Tuples []relationTuple `json:"tuples"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		results := make([]map[string]bool, len(batch.Tuples))
+		for i, rt := range batch.Tuples {
+			results[i] = map[string]bool{"allowed": f.check(rt.Namespace, rt.Object, rt.Relation, rt.SubjectID)}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	case "GET /health/ready":
+		_, _ = fmt.Fprint(w, `{"status":"ok"}`)
+	case "GET /namespaces":
+		namespaces := []map[string]string{}
+		for _, name := range f.namespaces {
+			namespaces = append(namespaces, map[string]string{"name": name})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"namespaces": namespaces})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// check reports whether subject holds relation on object directly or through a subject set
+func (f *fakeKeto) check(namespace, object, relation, subject string) bool {
+	for _, rt := range f.tuples {
+		if rt.Namespace != namespace || rt.Object != object || rt.Relation != relation {
+			continue
+		}
+		if rt.SubjectID == subject {
+			return true
+		}
+		if set := rt.SubjectSet; set != nil && f.check(set.Namespace, set.Object, set.Relation, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rt relationTuple) equal(other relationTuple) bool {
+	return rt.query().Encode() == other.query().Encode()
+}
+
+// matches reports whether the tuple matches every filter in query
+func matches(rt relationTuple, query url.Values) bool {
+	fields := map[string]string{"namespace": rt.Namespace, "object": rt.Object, "relation": rt.Relation, "subject_id": rt.SubjectID}
+	if rt.SubjectSet != nil {
+		fields["subject_set.namespace"] = rt.SubjectSet.Namespace
+		fields["subject_set.object"] = rt.SubjectSet.Object
+		fields["subject_set.relation"] = rt.SubjectSet.Relation
+	}
+	for key := range query {
+		if key != "page_token" && key != "page_size" && fields[key] != query.Get(key) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestKetoGroupGrantsApplyToMembers(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	ctx := context.Background()
+	shared, private := models.Document{ID: uuid.New()}, models.Document{ID: uuid.New()}
+
+	if err := keto.AddMember(ctx, "accounting-team", "alice"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	groupViewer := Tuple{Group: "accounting-team", Relation: RelationViewer, DocumentID: shared.ID}
+	if err := keto.Grant(ctx, groupViewer); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+
+	if !keto.CanAccessDocument(ctx, Principal{Username: "alice"}, &shared) {
+		t.Error("Expected alice to view the shared document through her group")
+	}
+	if keto.CanAccessDocument(ctx, Principal{Username: "bob"}, &shared) {
+		t.Error("Expected bob, who is not a member, to be denied")
+	}
+	if got := keto.BatchCheck(ctx, Principal{Username: "alice"}, []models.Document{shared, private}); !slices.Equal(got, []bool{true, false}) {
+		t.Errorf("Expected batch check [true false], got %v", got)
+	}
+	if got := keto.GetUserPermissions(ctx, Principal{Username: "alice"}); !slices.Equal(got, []string{shared.ID.String()}) {
+		t.Errorf("Expected alice's permissions to include the shared document, got %v", got)
+	}
+
+	members, err := keto.Members(ctx, "accounting-team")
+	if err != nil || !slices.Equal(members, []string{"alice"}) {
+		t.Errorf("Expected members [alice], got %v (%v)", members, err)
+	}
+	tuples, err := keto.ListGroupTuples(ctx, "accounting-team")
+	if err != nil || !slices.Equal(tuples, []Tuple{groupViewer}) {
+		t.Errorf("Expected the group's viewer relation, got %v (%v)", tuples, err)
+	}
+	if tuples, _ := keto.ListTuples(ctx, "alice"); len(tuples) != 0 {
+		t.Errorf("Expected no direct relations for alice, got %v", tuples)
+	}
+
+	if err := keto.RemoveMember(ctx, "accounting-team", "alice"); err != nil {
+		t.Fatalf("RemoveMember failed: %v", err)
+	}
+	if keto.CanAccessDocument(ctx, Principal{Username: "alice"}, &shared) {
+		t.Error("Expected alice to lose access after leaving the group")
+	}
+}
+
+func TestKetoListDocumentTuples(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	ctx := context.Background()
+	docID := uuid.New()
+	want := []Tuple{
+		{Subject: "alice", Relation: RelationEditor, DocumentID: docID},
+		{Subject: "bob", Relation: RelationViewer, DocumentID: docID},
+		{Group: "accounting-team", Relation: RelationViewer, DocumentID: docID},
+	}
+	for _, tuple := range append(want, Tuple{Subject: "carol", Relation: RelationViewer, DocumentID: uuid.New()}) {
+		if err := keto.Grant(ctx, tuple); err != nil {
+			t.Fatalf("Grant failed: %v", err)
+		}
+	}
+
+	var got []Tuple
+	pages, token := 0, ""
+	for {
+		page, next, err := keto.ListDocumentTuples(ctx, docID, 2, token)
+		if err != nil {
+			t.Fatalf("ListDocumentTuples failed: %v", err)
+		}
+		got, pages, token = append(got, page...), pages+1, next
+		if token == "" {
+			break
+		}
+	}
+	if pages != 2 || !slices.Equal(got, want) {
+		t.Errorf("Expected %v over 2 pages, got %v over %d", want, got, pages)
+	}
+}
+
+func TestKetoCountRelations(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	ctx := context.Background()
+	docID := uuid.New()
+	for _, tuple := range []Tuple{
+		{Subject: "alice", Relation: RelationEditor, DocumentID: docID},
+		{Subject: "bob", Relation: RelationViewer, DocumentID: docID},
+		{Group: "accounting-team", Relation: RelationViewer, DocumentID: docID},
+		{Subject: "peter", Relation: RelationWrite},
+	} {
+		if err := keto.Grant(ctx, tuple); err != nil {
+			t.Fatalf("Grant failed: %v", err)
+		}
+	}
+	_ = keto.AddMember(ctx, "accounting-team", "carol")
+	// Other tenants are not counted
+	_ = keto.Grant(tenant.NewContext(ctx, "other"), Tuple{Subject: "dave", Relation: RelationViewer, DocumentID: docID})
+
+	stats, err := keto.CountRelations(ctx)
+	if err != nil {
+		t.Fatalf("CountRelations failed: %v", err)
+	}
+	if !maps.Equal(stats.Relations, map[string]int{RelationViewer: 2, RelationEditor: 1, RelationWrite: 1}) || stats.Memberships != 1 {
+		t.Errorf("Unexpected relation counts %+v", stats)
+	}
+	if want := []string{"alice", "bob", "carol", "peter"}; !slices.Equal(stats.Users, want) {
+		t.Errorf("Expected users %v, got %v", want, stats.Users)
+	}
+}
+
+func TestKetoSelfCheck(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{namespaces: []string{"documents", "groups"}})
+	defer server.Close()
+	ctx := context.Background()
+
+	if err := newTestKeto(server.URL, FailClosed).SelfCheck(ctx); err != nil {
+		t.Errorf("Expected self-check to pass, got %v", err)
+	}
+
+	keto := newTestKeto(server.URL, FailClosed)
+	naming := DefaultNaming()
+	naming.DocumentsNamespace = "docs"
+	keto.SetNaming(naming)
+	err := keto.SelfCheck(ctx)
+	if err == nil || !strings.Contains(err.Error(), `namespace "docs" is not configured`) {
+		t.Errorf("Expected missing namespace error, got %v", err)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	keto = NewKetoPermissionService(server.URL, closed.URL, httpclient.New(httpclient.Options{Timeout: time.Second}), FailClosed)
+	err = keto.SelfCheck(ctx)
+	if err == nil || !strings.Contains(err.Error(), "write API") || strings.Contains(err.Error(), "read API") {
+		t.Errorf("Expected only the write API to be reported, got %v", err)
+	}
+}
+
+func TestKetoOrphaned(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	keto.SetAttributeRules([]AttributeRule{{Attribute: "taxpayer", Namespace: "taxpayers", Relation: "auditor"}})
+	ctx := context.Background()
+	shared, grouped, orphan := models.Document{ID: uuid.New()}, models.Document{ID: uuid.New()}, models.Document{ID: uuid.New()}
+	audited := models.Document{ID: uuid.New(), Metadata: map[string]interface{}{"taxpayer": "John Doe"}}
+	_ = keto.Grant(ctx, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: shared.ID})
+	_ = keto.Grant(ctx, Tuple{Group: "accounting-team", Relation: RelationEditor, DocumentID: grouped.ID})
+
+	for doc, want := range map[*models.Document]bool{&shared: false, &grouped: false, &audited: false, &orphan: true} {
+		if got, err := keto.Orphaned(ctx, doc); err != nil || got != want {
+			t.Errorf("Expected Orphaned(%s) to be %t, got %t (%v)", doc.ID, want, got, err)
+		}
+	}
+
+	// A revoked relation leaves the document orphaned
+	_ = keto.Revoke(ctx, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: shared.ID})
+	if got, _ := keto.Orphaned(ctx, &shared); !got {
+		t.Error("Expected the document to be orphaned after its last relation was revoked")
+	}
+}
+
+func TestKetoClaimedGroupsGrantAccess(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	shared, private := models.Document{ID: uuid.New()}, models.Document{ID: uuid.New()}
+	if err := keto.Grant(context.Background(), Tuple{Group: "accounting-team", Relation: RelationViewer, DocumentID: shared.ID}); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+
+	// alice is not a member in Keto, but her identity provider says she is
+	alice := Principal{Username: "alice", Groups: ValidGroups([]string{"not a group!", "accounting-team", "accounting-team"})}
+	if !slices.Equal(alice.Groups, []string{"accounting-team"}) {
+		t.Errorf("Expected malformed and duplicate groups to be dropped, got %v", alice.Groups)
+	}
+	ctx := context.Background()
+	if !keto.CanAccessDocument(ctx, alice, &shared) || keto.CanAccessDocument(ctx, Principal{Username: "alice"}, &shared) {
+		t.Error("Expected alice to view the shared document only with the claimed group")
+	}
+	if got := keto.BatchCheck(ctx, alice, []models.Document{shared, private}); !slices.Equal(got, []bool{true, false}) {
+		t.Errorf("Expected batch check [true false], got %v", got)
+	}
+	if got := keto.GetUserPermissions(ctx, alice); !slices.Equal(got, []string{shared.ID.String()}) {
+		t.Errorf("Expected alice's permissions to include the shared document, got %v", got)
+	}
+
+	// Decisions are cached per set of claimed groups and roles
+	cached := NewCachingPermissionService(keto, time.Minute, 10)
+	if !cached.CanAccessDocument(ctx, alice, &shared) || cached.CanAccessDocument(ctx, Principal{Username: "alice"}, &shared) {
+		t.Error("Expected cached decisions to depend on the claimed groups")
+	}
+	if cached.CanAccessDocument(ctx, Principal{Username: "alice", Roles: []string{"auditor"}}, &shared) {
+		t.Error("Expected alice to be denied with a role but without the group")
+	}
+	if cached.Len() != 3 {
+		t.Errorf("Expected one cached decision per set of groups and roles, got %d", cached.Len())
+	}
+}
+
+func TestKetoRemoveDocumentRelations(t *testing.T) {
+	server := httptest.NewServer(&fakeKeto{})
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	ctx := context.Background()
+	expired, kept := models.Document{ID: uuid.New()}, models.Document{ID: uuid.New()}
+	for _, tuple := range []Tuple{
+		{Subject: "alice", Relation: RelationViewer, DocumentID: expired.ID},
+		{Group: "accounting-team", Relation: RelationEditor, DocumentID: expired.ID},
+		{Subject: "alice", Relation: RelationViewer, DocumentID: kept.ID},
+	} {
+		if err := keto.Grant(ctx, tuple); err != nil {
+			t.Fatalf("Grant failed: %v", err)
+		}
+	}
+
+	if err := keto.RemoveDocumentRelations(ctx, expired.ID); err != nil {
+		t.Fatalf("RemoveDocumentRelations failed: %v", err)
+	}
+	if keto.CanAccessDocument(ctx, Principal{Username: "alice"}, &expired) {
+		t.Error("Expected alice to lose access to the removed document")
+	}
+	if !keto.CanAccessDocument(ctx, Principal{Username: "alice"}, &kept) {
+		t.Error("Expected relations on other documents to be kept")
+	}
+	if tuples, _ := keto.ListGroupTuples(ctx, "accounting-team"); len(tuples) != 0 {
+		t.Errorf("Expected the group relation to be removed, got %v", tuples)
+	}
+}
+
+func TestKetoBatchCheckRunsConcurrentlyAndMemoizes(t *testing.T) {
+	keto := &fakeKeto{}
+	var requests, inFlight, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.URL.Path == "/relation-tuples/check/openapi" {
+			requests.Add(1)
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		keto.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	service := newTestKeto(server.URL, FailClosed)
+	ctx := TrackOutcome(context.Background())
+	docs := make([]models.Document, 4*ketoBatchCheckSize)
+	want := make([]bool, len(docs))
+	for i := range docs {
+		docs[i].ID = uuid.New()
+		if i%2 == 0 {
+			want[i] = true
+			_ = service.Grant(context.Background(), Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: docs[i].ID})
+		}
+	}
+
+	if got := service.BatchCheck(ctx, Principal{Username: "alice"}, docs); !slices.Equal(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	if requests.Load() != 4 || peak.Load() < 2 {
+		t.Errorf("Expected 4 concurrent batch requests, got %d with at most %d in flight", requests.Load(), peak.Load())
+	}
+
+	// The request's answers are reused until it changes relations
+	if got := service.BatchCheck(ctx, Principal{Username: "alice"}, docs); !slices.Equal(got, want) || requests.Load() != 4 {
+		t.Errorf("Expected memoized answers without requests, got %v after %d requests", got, requests.Load())
+	}
+	if !service.CanAccessDocument(ctx, Principal{Username: "alice"}, &docs[0]) || requests.Load() != 4 {
+		t.Errorf("Expected a memoized single check, got %d requests", requests.Load())
+	}
+	if err := service.Grant(ctx, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: docs[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if got := service.BatchCheck(ctx, Principal{Username: "alice"}, docs[:2]); !got[1] {
+		t.Error("Expected the grant to be visible to later checks of the request")
+	}
+}
+
+func TestKetoListStrategy(t *testing.T) {
+	keto := &fakeKeto{}
+	var checks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.URL.Path == "/relation-tuples/check/openapi" {
+			checks.Add(1)
+		}
+		keto.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	service := newTestKeto(server.URL, FailClosed)
+	docs := []models.Document{{ID: uuid.New()}, {ID: uuid.New()}, {ID: uuid.New()}}
+	ctx := context.Background()
+	_ = service.Grant(ctx, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: docs[0].ID})
+	_ = service.Grant(ctx, Tuple{Group: "accounting-team", Relation: RelationViewer, DocumentID: docs[1].ID})
+	_ = service.AddMember(ctx, "accounting-team", "alice")
+	_ = service.Grant(ctx, Tuple{Subject: "alice", Relation: RelationEditor, DocumentID: docs[2].ID})
+	want := []bool{true, true, false}
+
+	service.SetCheckStrategy(StrategyList, DefaultListLimit)
+	if got := service.BatchCheck(TrackOutcome(ctx), Principal{Username: "alice"}, docs); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if checks.Load() != 0 {
+		t.Errorf("Expected no checks under the list strategy, got %d", checks.Load())
+	}
+
+	// Users who may view more documents than the limit are checked one by one
+	service.SetCheckStrategy(StrategyList, 1)
+	if got := service.BatchCheck(TrackOutcome(ctx), Principal{Username: "alice"}, docs); !slices.Equal(got, want) {
+		t.Errorf("Expected %v after falling back, got %v", want, got)
+	}
+	if checks.Load() == 0 {
+		t.Error("Expected checks after exceeding the list limit")
+	}
+
+	// Warming lists the documents before they are checked
+	service.SetCheckStrategy(StrategyList, DefaultListLimit)
+	warmed := TrackOutcome(ctx)
+	service.Warm(warmed, Principal{Username: "alice"})
+	server.Close()
+	if got := service.BatchCheck(warmed, Principal{Username: "alice"}, docs); !slices.Equal(got, want) || Unavailable(warmed) {
+		t.Errorf("Expected %v from the warmed list without requests, got %v", want, got)
+	}
+}
+
+func BenchmarkKetoBatchCheck(b *testing.B) {
+	keto := &fakeKeto{}
+	server := httptest.NewServer(keto)
+	defer server.Close()
+
+	service := newTestKeto(server.URL, FailClosed)
+	ctx := context.Background()
+	docs := make([]models.Document, 200)
+	for i := range docs {
+		docs[i].ID = uuid.New()
+		if i%4 == 0 {
+			_ = service.Grant(ctx, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: docs[i].ID})
+		}
+	}
+	candidates := docs[:20]
+
+	for _, strategy := range []CheckStrategy{StrategyCheck, StrategyList} {
+		b.Run(string(strategy), func(b *testing.B) {
+			service.SetCheckStrategy(strategy, DefaultListLimit)
+			for i := 0; i < b.N; i++ {
+				service.BatchCheck(TrackOutcome(ctx), Principal{Username: "alice"}, candidates)
+			}
+		})
+	}
+}
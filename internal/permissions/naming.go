@@ -0,0 +1,121 @@
+package permissions
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SubjectPlaceholder is replaced by the username in Naming.SubjectFormat
+const SubjectPlaceholder = "{username}"
+
+// Naming maps the namespaces, relations, and subjects of this service to the
+// names used by a Keto deployment, so existing relation tuples can be reused.
+// Tuples, policy files, and the API keep this service's relation names.
+type Naming struct {
+	// DocumentsNamespace and GroupsNamespace are base namespaces; tenants
+	// get a suffixed copy
+	DocumentsNamespace string
+	GroupsNamespace    string
+	// Keto relations standing for RelationViewer, RelationEditor,
+	// RelationWrite, and RelationMember
+	Viewer string
+	Editor string
+	Write  string
+	Member string
+	// SubjectFormat turns usernames into Keto subject IDs, e.g.
+	// "user:{username}"
+	SubjectFormat string
+}
+
+// DefaultNaming returns the names this service uses unless configured otherwise
+func DefaultNaming() Naming {
+	return Naming{
+		DocumentsNamespace: "documents",
+		GroupsNamespace:    "groups",
+		Viewer:             RelationViewer,
+		Editor:             RelationEditor,
+		Write:              RelationWrite,
+		Member:             RelationMember,
+		SubjectFormat:      SubjectPlaceholder,
+	}
+}
+
+// relationPattern restricts relation names to identifiers Keto accepts
+var relationPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,63}$`)
+
+// Validate checks that the namespaces and relations are well-formed and
+// distinct and that the subject format contains the placeholder once
+func (n Naming) Validate() error {
+	for _, namespace := range []string{n.DocumentsNamespace, n.GroupsNamespace} {
+		if !namespacePattern.MatchString(namespace) {
+			return fmt.Errorf("invalid namespace %q: use lowercase letters, digits, and '_'", namespace)
+		}
+	}
+	if n.DocumentsNamespace == n.GroupsNamespace {
+		return fmt.Errorf("documents and groups namespaces must differ")
+	}
+	for _, relation := range []string{n.Viewer, n.Editor, n.Write, n.Member} {
+		if !relationPattern.MatchString(relation) {
+			return fmt.Errorf("invalid relation %q: use letters, digits, and '_'", relation)
+		}
+	}
+	if n.Viewer == n.Editor || n.Viewer == n.Write || n.Editor == n.Write {
+		return fmt.Errorf("viewer, editor, and write relations must differ")
+	}
+	if strings.Count(n.SubjectFormat, SubjectPlaceholder) != 1 {
+		return fmt.Errorf("subject format %q must contain %s once", n.SubjectFormat, SubjectPlaceholder)
+	}
+	return nil
+}
+
+// relation returns the Keto relation of a relation of this service
+func (n Naming) relation(relation string) string {
+	switch relation {
+	case RelationViewer:
+		return n.Viewer
+	case RelationEditor:
+		return n.Editor
+	case RelationWrite:
+		return n.Write
+	case RelationMember:
+		return n.Member
+	}
+	return relation
+}
+
+// documentRelation returns the relation of this service a Keto relation in
+// the documents namespace stands for, or false if it manages none
+func (n Naming) documentRelation(relation string) (string, bool) {
+	switch relation {
+	case n.Viewer:
+		return RelationViewer, true
+	case n.Editor:
+		return RelationEditor, true
+	case n.Write:
+		return RelationWrite, true
+	}
+	return "", false
+}
+
+// subject returns the Keto subject ID of a username
+func (n Naming) subject(username string) string {
+	return strings.Replace(n.SubjectFormat, SubjectPlaceholder, username, 1)
+}
+
+// username returns the username of a Keto subject ID, or false if the ID
+// does not follow the subject format
+func (n Naming) username(subjectID string) (string, bool) {
+	prefix, suffix, _ := strings.Cut(n.SubjectFormat, SubjectPlaceholder)
+	if len(subjectID) < len(prefix)+len(suffix) || !strings.HasPrefix(subjectID, prefix) || !strings.HasSuffix(subjectID, suffix) {
+		return "", false
+	}
+	return subjectID[len(prefix) : len(subjectID)-len(suffix)], true
+}
+
+// SetNaming maps this service's namespaces, relations, and subjects to the
+// names of the Keto deployment. n must pass Validate. It must be called
+// before the service handles requests.
+func (k *KetoPermissionService) SetNaming(n Naming) {
+	k.naming = n
+}
@@ -0,0 +1,113 @@
+package permissions
+
+import (
+	"context"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// customNaming follows the conventions of a Keto deployment set up for
+// another application
+var customNaming = Naming{
+	DocumentsNamespace: "files",
+	GroupsNamespace:    "teams",
+	Viewer:             "readers",
+	Editor:             "writers",
+	Write:              "uploaders",
+	Member:             "members",
+	SubjectFormat:      "user:" + SubjectPlaceholder,
+}
+
+func TestNamingValidate(t *testing.T) {
+	if err := DefaultNaming().Validate(); err != nil {
+		t.Errorf("Expected the default naming to be valid, got %v", err)
+	}
+	if err := customNaming.Validate(); err != nil {
+		t.Errorf("Expected %+v to be valid, got %v", customNaming, err)
+	}
+
+	invalid := map[string]func(n *Naming){
+		"namespace":         func(n *Naming) { n.DocumentsNamespace = "Files" },
+		"shared namespace":  func(n *Naming) { n.GroupsNamespace = n.DocumentsNamespace },
+		"relation":          func(n *Naming) { n.Viewer = "read#all" },
+		"shared relation":   func(n *Naming) { n.Editor = n.Viewer },
+		"no placeholder":    func(n *Naming) { n.SubjectFormat = "user" },
+		"two placeholders":  func(n *Naming) { n.SubjectFormat = SubjectPlaceholder + SubjectPlaceholder },
+		"missing relation":  func(n *Naming) { n.Member = "" },
+		"missing namespace": func(n *Naming) { n.GroupsNamespace = "" },
+	}
+	for name, change := range invalid {
+		n := customNaming
+		change(&n)
+		if n.Validate() == nil {
+			t.Errorf("%s: expected %+v to be invalid", name, n)
+		}
+	}
+
+	rule := AttributeRule{Attribute: "taxpayer", Namespace: "files", Relation: "auditor"}
+	if rule.Validate(customNaming) == nil {
+		t.Error("Expected a rule reusing the configured documents namespace to be invalid")
+	}
+	if err := rule.Validate(DefaultNaming()); err != nil {
+		t.Errorf("Expected the namespace to be free under the default naming, got %v", err)
+	}
+}
+
+func TestKetoUsesConfiguredNaming(t *testing.T) {
+	fake := &fakeKeto{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	keto := newTestKeto(server.URL, FailClosed)
+	keto.SetNaming(customNaming)
+	ctx := context.Background()
+	doc, other := models.Document{ID: uuid.New()}, models.Document{ID: uuid.New()}
+
+	granted := []Tuple{
+		{Subject: "alice", Relation: RelationEditor, DocumentID: doc.ID},
+		{Group: "accounting", Relation: RelationViewer, DocumentID: doc.ID},
+	}
+	for _, tuple := range granted {
+		if err := keto.Grant(ctx, tuple); err != nil {
+			t.Fatalf("Grant failed: %v", err)
+		}
+	}
+	if err := keto.AddMember(ctx, "accounting", "bob"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+	// A tuple of the other application's service accounts is not a user's
+	fake.tuples = append(fake.tuples, relationTuple{Namespace: "files", Object: doc.ID.String(), Relation: "readers", SubjectID: "service:indexer"})
+
+	want := []relationTuple{
+		{Namespace: "files", Object: doc.ID.String(), Relation: "writers", SubjectID: "user:alice"},
+		{Namespace: "files", Object: doc.ID.String(), Relation: "readers", SubjectSet: &subjectSet{Namespace: "teams", Object: "accounting", Relation: "members"}},
+		{Namespace: "teams", Object: "accounting", Relation: "members", SubjectID: "user:bob"},
+	}
+	for _, rt := range want {
+		if !slices.ContainsFunc(fake.tuples, rt.equal) {
+			t.Errorf("Expected the tuple %s in Keto, got %+v", rt.key(), fake.tuples)
+		}
+	}
+
+	if !keto.CanEditDocument(ctx, Principal{Username: "alice"}, &doc) || keto.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) {
+		t.Error("Expected alice to edit but not view the document")
+	}
+	if got := keto.BatchCheck(ctx, Principal{Username: "bob"}, []models.Document{doc, other}); !slices.Equal(got, []bool{true, false}) {
+		t.Errorf("Expected bob to view the document through his group, got %v", got)
+	}
+
+	tuples, _, err := keto.ListDocumentTuples(ctx, doc.ID, 10, "")
+	if err != nil || !slices.Equal(tuples, granted) {
+		t.Errorf("Expected %v in this service's names, got %v (%v)", granted, tuples, err)
+	}
+	if tuples, err := keto.ListTuples(ctx, "alice"); err != nil || !slices.Equal(tuples, granted[:1]) {
+		t.Errorf("Expected alice's editor relation, got %v (%v)", tuples, err)
+	}
+	if members, err := keto.Members(ctx, "accounting"); err != nil || !slices.Equal(members, []string{"bob"}) {
+		t.Errorf("Expected members [bob], got %v (%v)", members, err)
+	}
+}
@@ -0,0 +1,519 @@
+package permissions
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/open-policy-agent/opa/v1/storage/inmem"
+)
+
+// DefaultPolicy is the Rego policy of the OPA backend unless a policy file is
+// configured. It grants relations from the local tuples like Keto does.
+//
+//go:embed policies/default.rego
+var DefaultPolicy string
+
+// Queries of the OPA backend's policy, package rerag.authz
+const (
+	opaAllowQuery     = "data.rerag.authz.allow"
+	opaReachableQuery = "data.rerag.authz.reachable"
+)
+
+// Namespaces of the local tuple table
+const (
+	opaDocuments = "documents"
+	opaGroups    = "groups"
+)
+
+// OPAPermissionService implements permission checking with an embedded Open
+// Policy Agent for deployments that cannot run Keto. Relations are kept in a
+// local tuple table, optionally saved to a JSON file, and checks are decided
+// by a Rego policy over the tuples and the document's metadata. Every change
+// reloads all tuples into OPA, so the table suits thousands of relations, not
+// millions.
+type OPAPermissionService struct {
+	mu     sync.RWMutex
+	tuples []localTuple
+	path   string // JSON file written after every change; empty keeps tuples in memory only
+
+	store     storage.Store
+	allow     rego.PreparedEvalQuery
+	reachable rego.PreparedEvalQuery
+}
+
+// localTuple is a row of the tuple table: a relation of a user or group to a
+// document or the corpus in the documents namespace, or a user's membership
+// of the group Object in the groups namespace
+type localTuple struct {
+	Tenant    string `json:"tenant"`
+	Namespace string `json:"namespace"`
+	Object    string `json:"object"`
+	Relation  string `json:"relation"`
+	Subject   string `json:"subject,omitempty"`
+	Group     string `json:"group,omitempty"`
+}
+
+// NewOPAPermissionService compiles the Rego policy in policyFile, or
+// DefaultPolicy if it is empty. With a non-empty tuplesFile the relations are
+// loaded from and saved to that JSON file.
+func NewOPAPermissionService(policyFile, tuplesFile string) (*OPAPermissionService, error) {
+	name, policy := "default.rego", DefaultPolicy
+	if policyFile != "" {
+		raw, err := os.ReadFile(policyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy: %w", err)
+		}
+		name, policy = filepath.Base(policyFile), string(raw)
+	}
+
+	o := &OPAPermissionService{path: tuplesFile}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	o.store = inmem.NewFromObject(map[string]interface{}{"rerag": map[string]interface{}{"tenants": policyData(o.tuples)}})
+
+	var err error
+	for query, prepared := range map[string]*rego.PreparedEvalQuery{opaAllowQuery: &o.allow, opaReachableQuery: &o.reachable} {
+		*prepared, err = rego.New(
+			rego.Query(query),
+			rego.Module(name, policy),
+			rego.Store(o.store),
+		).PrepareForEval(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile policy %s: %w", name, err)
+		}
+	}
+	return o, nil
+}
+
+// load reads the persisted tuples; a missing file is an empty table
+func (o *OPAPermissionService) load() error {
+	if o.path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", o.path, err)
+	}
+	if err := json.Unmarshal(raw, &o.tuples); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", o.path, err)
+	}
+	return nil
+}
+
+// save writes tuples to the JSON file, replacing it atomically
+func (o *OPAPermissionService) save(tuples []localTuple) error {
+	if o.path == "" {
+		return nil
+	}
+	raw, err := json.Marshal(tuples)
+	if err != nil {
+		return fmt.Errorf("failed to encode relations: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save relations: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to save relations: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save relations: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return fmt.Errorf("failed to save relations: %w", err)
+	}
+	return nil
+}
+
+// policyData arranges tuples as the data the policy reads:
+// tenants[tenant].documents[object][relation].{users,groups} and
+// tenants[tenant].members[group]
+func policyData(tuples []localTuple) map[string]interface{} {
+	tenants := map[string]interface{}{}
+	for _, t := range tuples {
+		scope := child(tenants, t.Tenant)
+		if t.Namespace == opaGroups {
+			members := child(scope, "members")
+			users, _ := members[t.Object].([]interface{})
+			members[t.Object] = append(users, t.Subject)
+			continue
+		}
+		holders := child(child(child(scope, "documents"), t.Object), t.Relation)
+		key, holder := "users", t.Subject
+		if t.Group != "" {
+			key, holder = "groups", t.Group
+		}
+		list, _ := holders[key].([]interface{})
+		holders[key] = append(list, holder)
+	}
+	return tenants
+}
+
+// child returns the object at key of parent, adding an empty one if missing
+func child(parent map[string]interface{}, key string) map[string]interface{} {
+	if m, ok := parent[key].(map[string]interface{}); ok {
+		return m
+	}
+	m := map[string]interface{}{}
+	parent[key] = m
+	return m
+}
+
+// update applies change to a copy of the tuple table, loads the result into
+// OPA, and saves it. The table is left unchanged if either fails.
+func (o *OPAPermissionService) update(ctx context.Context, change func([]localTuple) []localTuple) error {
+	defer forgetDecisions(ctx)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	tuples := change(slices.Clone(o.tuples))
+	if err := o.publish(ctx, tuples); err != nil {
+		return err
+	}
+	if err := o.save(tuples); err != nil {
+		_ = o.publish(ctx, o.tuples)
+		return err
+	}
+	o.tuples = tuples
+	return nil
+}
+
+// publish replaces the policy data with tuples
+func (o *OPAPermissionService) publish(ctx context.Context, tuples []localTuple) error {
+	path := storage.Path{"rerag", "tenants"}
+	if err := storage.WriteOne(ctx, o.store, storage.ReplaceOp, path, policyData(tuples)); err != nil {
+		return fmt.Errorf("failed to load relations into OPA: %w", err)
+	}
+	return nil
+}
+
+// input is the policy input of a check of relation on object. doc is nil for
+// the corpus.
+func (o *OPAPermissionService) input(ctx context.Context, p Principal, relation, object string, doc *models.Document) map[string]interface{} {
+	input := map[string]interface{}{
+		"tenant":   tenant.FromContext(ctx),
+		"user":     p.Username,
+		"groups":   p.Groups,
+		"roles":    p.Roles,
+		"claims":   p.Claims,
+		"relation": relation,
+		"object":   object,
+	}
+	if doc != nil {
+		input["document"] = map[string]interface{}{
+			"id":       doc.ID.String(),
+			"title":    doc.Title,
+			"metadata": doc.Metadata,
+		}
+	}
+	return input
+}
+
+// decide evaluates whether the policy allows the user the relation on object
+// and logs a denial. A policy that fails to evaluate denies.
+func (o *OPAPermissionService) decide(ctx context.Context, p Principal, relation, object string, doc *models.Document) bool {
+	results, err := o.allow.Eval(ctx, rego.EvalInput(o.input(ctx, p, relation, object, doc)))
+	if err != nil {
+		requestid.Logf(ctx, "Error evaluating %s policy for %s on %s: %v", relation, p.Username, object, err)
+		logDeny(ctx, p.Username, relation, object, DenyPolicyError)
+		return false
+	}
+	if !results.Allowed() {
+		logDeny(ctx, p.Username, relation, object, DenyNoRelation)
+		return false
+	}
+	return true
+}
+
+// CanAccessDocument checks whether the policy grants the user the viewer relation on the document
+func (o *OPAPermissionService) CanAccessDocument(ctx context.Context, p Principal, doc *models.Document) bool {
+	return o.decide(ctx, p, RelationViewer, doc.ID.String(), doc)
+}
+
+// BatchCheck evaluates the viewer relation for each document
+func (o *OPAPermissionService) BatchCheck(ctx context.Context, p Principal, docs []models.Document) []bool {
+	results := make([]bool, len(docs))
+	for i := range docs {
+		results[i] = o.decide(ctx, p, RelationViewer, docs[i].ID.String(), &docs[i])
+	}
+	return results
+}
+
+// CanEditDocument checks whether the policy grants the user the editor relation on the document
+func (o *OPAPermissionService) CanEditDocument(ctx context.Context, p Principal, doc *models.Document) bool {
+	return o.decide(ctx, p, RelationEditor, doc.ID.String(), doc)
+}
+
+// CanWriteDocuments checks whether the policy grants the user the write relation on the corpus
+func (o *OPAPermissionService) CanWriteDocuments(ctx context.Context, p Principal) bool {
+	return o.decide(ctx, p, RelationWrite, CorpusObject, nil)
+}
+
+// GetUserPermissions lists the objects the user holds relations on in the
+// tuple table, directly or through groups, including the groups the principal
+// claims. Access a custom policy grants through metadata is not listed.
+func (o *OPAPermissionService) GetUserPermissions(ctx context.Context, p Principal) []string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	groups := slices.Clone(p.Groups)
+	for _, t := range o.tenantTuples(ctx, opaGroups) {
+		if t.Subject == p.Username {
+			groups = append(groups, t.Object)
+		}
+	}
+	permissions := make([]string, 0)
+	for _, t := range o.tenantTuples(ctx, opaDocuments) {
+		if (t.Subject == p.Username || slices.Contains(groups, t.Group)) && !slices.Contains(permissions, t.Object) {
+			permissions = append(permissions, t.Object)
+		}
+	}
+	return permissions
+}
+
+// tenantTuples returns the tuples of the tenant of ctx in namespace. The
+// caller must hold the lock.
+func (o *OPAPermissionService) tenantTuples(ctx context.Context, namespace string) []localTuple {
+	id := tenant.FromContext(ctx)
+	var tuples []localTuple
+	for _, t := range o.tuples {
+		if t.Tenant == id && t.Namespace == namespace {
+			tuples = append(tuples, t)
+		}
+	}
+	return tuples
+}
+
+// documentTuple converts a Tuple into a row of the tenant's documents namespace
+func documentTuple(ctx context.Context, t Tuple) localTuple {
+	return localTuple{
+		Tenant:    tenant.FromContext(ctx),
+		Namespace: opaDocuments,
+		Object:    t.Object(),
+		Relation:  t.Relation,
+		Subject:   t.Subject,
+		Group:     t.Group,
+	}
+}
+
+// membershipTuple is the row making user a member of group
+func membershipTuple(ctx context.Context, group, user string) localTuple {
+	return localTuple{
+		Tenant:    tenant.FromContext(ctx),
+		Namespace: opaGroups,
+		Object:    group,
+		Relation:  RelationMember,
+		Subject:   user,
+	}
+}
+
+// put adds row to the tuple table; adding an existing row succeeds
+func (o *OPAPermissionService) put(ctx context.Context, row localTuple) error {
+	return o.update(ctx, func(tuples []localTuple) []localTuple {
+		if slices.Contains(tuples, row) {
+			return tuples
+		}
+		return append(tuples, row)
+	})
+}
+
+// remove deletes the rows matching drop from the tuple table
+func (o *OPAPermissionService) remove(ctx context.Context, drop func(localTuple) bool) error {
+	return o.update(ctx, func(tuples []localTuple) []localTuple {
+		return slices.DeleteFunc(tuples, drop)
+	})
+}
+
+// Grant adds a relation to the tuple table
+func (o *OPAPermissionService) Grant(ctx context.Context, t Tuple) error {
+	if err := o.put(ctx, documentTuple(ctx, t)); err != nil {
+		return fmt.Errorf("failed to grant %s on %s to %s: %w", t.Relation, t.Object(), t.Holder(), err)
+	}
+	return nil
+}
+
+// Revoke deletes a relation from the tuple table. Revoking a relation that
+// does not exist succeeds.
+func (o *OPAPermissionService) Revoke(ctx context.Context, t Tuple) error {
+	row := documentTuple(ctx, t)
+	if err := o.remove(ctx, func(lt localTuple) bool { return lt == row }); err != nil {
+		return fmt.Errorf("failed to revoke %s on %s from %s: %w", t.Relation, t.Object(), t.Holder(), err)
+	}
+	return nil
+}
+
+// RemoveDocumentRelations deletes all relations on the document in the tenant of ctx
+func (o *OPAPermissionService) RemoveDocumentRelations(ctx context.Context, docID uuid.UUID) error {
+	id := tenant.FromContext(ctx)
+	if err := o.remove(ctx, func(lt localTuple) bool {
+		return lt.Tenant == id && lt.Namespace == opaDocuments && lt.Object == docID.String()
+	}); err != nil {
+		return fmt.Errorf("failed to remove relations on %s: %w", docID, err)
+	}
+	return nil
+}
+
+// RemoveUserRelations deletes every relation and group membership the user
+// holds directly in the tenant of ctx
+func (o *OPAPermissionService) RemoveUserRelations(ctx context.Context, username string) ([]UserRelation, error) {
+	id := tenant.FromContext(ctx)
+	var removed []UserRelation
+	err := o.update(ctx, func(tuples []localTuple) []localTuple {
+		removed = nil
+		return slices.DeleteFunc(tuples, func(lt localTuple) bool {
+			if lt.Tenant != id || lt.Subject != username {
+				return false
+			}
+			removed = append(removed, UserRelation{Namespace: lt.Namespace, Object: lt.Object, Relation: lt.Relation})
+			return true
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove relations of %s: %w", username, err)
+	}
+	return removed, nil
+}
+
+// ListTuples lists the relations the subject holds directly in the tenant;
+// relations held through groups are not included
+func (o *OPAPermissionService) ListTuples(ctx context.Context, subject string) ([]Tuple, error) {
+	return o.toTuples(ctx, func(lt localTuple) bool { return lt.Subject == subject }), nil
+}
+
+// ListDocumentTuples lists a page of the relations on the document in the
+// tenant in the order they were granted. Page tokens are offsets.
+func (o *OPAPermissionService) ListDocumentTuples(ctx context.Context, docID uuid.UUID, pageSize int, pageToken string) ([]Tuple, string, error) {
+	offset := 0
+	if pageToken != "" {
+		var err error
+		if offset, err = strconv.Atoi(pageToken); err != nil || offset < 0 {
+			return nil, "", fmt.Errorf("failed to list relations on %s: invalid page token %q", docID, pageToken)
+		}
+	}
+	tuples := o.toTuples(ctx, func(lt localTuple) bool { return lt.Object == docID.String() })
+	if offset >= len(tuples) {
+		return nil, "", nil
+	}
+	end := min(offset+pageSize, len(tuples))
+	next := ""
+	if end < len(tuples) {
+		next = strconv.Itoa(end)
+	}
+	return tuples[offset:end], next, nil
+}
+
+// Orphaned evaluates the policy's reachable rule for doc. The default policy
+// finds documents without relations orphaned; a policy that leaves reachable
+// undefined never does.
+func (o *OPAPermissionService) Orphaned(ctx context.Context, doc *models.Document) (bool, error) {
+	results, err := o.reachable.Eval(ctx, rego.EvalInput(o.input(ctx, Principal{}, RelationViewer, doc.ID.String(), doc)))
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate whether %s is reachable: %w", doc.ID, err)
+	}
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return false, nil
+	}
+	reachable, ok := results[0].Expressions[0].Value.(bool)
+	if !ok {
+		return false, fmt.Errorf("policy rule reachable must be a boolean, got %v", results[0].Expressions[0].Value)
+	}
+	return !reachable, nil
+}
+
+// AddMember adds the membership of user in group to the tuple table
+func (o *OPAPermissionService) AddMember(ctx context.Context, group, user string) error {
+	if err := o.put(ctx, membershipTuple(ctx, group, user)); err != nil {
+		return fmt.Errorf("failed to add %s to group %s: %w", user, group, err)
+	}
+	return nil
+}
+
+// RemoveMember deletes the membership of user in group from the tuple table
+func (o *OPAPermissionService) RemoveMember(ctx context.Context, group, user string) error {
+	row := membershipTuple(ctx, group, user)
+	if err := o.remove(ctx, func(lt localTuple) bool { return lt == row }); err != nil {
+		return fmt.Errorf("failed to remove %s from group %s: %w", user, group, err)
+	}
+	return nil
+}
+
+// Members lists the members of a group
+func (o *OPAPermissionService) Members(ctx context.Context, group string) ([]string, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	members := make([]string, 0)
+	for _, t := range o.tenantTuples(ctx, opaGroups) {
+		if t.Object == group {
+			members = append(members, t.Subject)
+		}
+	}
+	return members, nil
+}
+
+// ListGroupTuples lists the relations granted to a group's members
+func (o *OPAPermissionService) ListGroupTuples(ctx context.Context, group string) ([]Tuple, error) {
+	return o.toTuples(ctx, func(lt localTuple) bool { return lt.Group == group }), nil
+}
+
+// CountRelations counts the relations and memberships of the tenant
+func (o *OPAPermissionService) CountRelations(ctx context.Context) (RelationStats, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	stats := RelationStats{Relations: make(map[string]int)}
+	users := make(map[string]bool)
+	for _, lt := range o.tenantTuples(ctx, opaDocuments) {
+		stats.Relations[lt.Relation]++
+		if lt.Subject != "" {
+			users[lt.Subject] = true
+		}
+	}
+	for _, lt := range o.tenantTuples(ctx, opaGroups) {
+		stats.Memberships++
+		users[lt.Subject] = true
+	}
+	stats.Users = slices.Sorted(maps.Keys(users))
+	return stats, nil
+}
+
+// toTuples converts the relations of the tenant's documents namespace
+// matching keep to Tuples
+func (o *OPAPermissionService) toTuples(ctx context.Context, keep func(localTuple) bool) []Tuple {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	var tuples []Tuple
+	for _, lt := range o.tenantTuples(ctx, opaDocuments) {
+		if !keep(lt) {
+			continue
+		}
+		t := Tuple{Subject: lt.Subject, Group: lt.Group, Relation: lt.Relation}
+		if lt.Object != CorpusObject {
+			docID, err := uuid.Parse(lt.Object)
+			if err != nil {
+				continue
+			}
+			t.DocumentID = docID
+		}
+		tuples = append(tuples, t)
+	}
+	return tuples
+}
@@ -0,0 +1,120 @@
+package permissions
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// metadataPolicy extends the default rules with access to documents by their
+// department metadata
+const metadataPolicy = `package rerag.authz
+
+default allow := false
+
+allow if input.user in data.rerag.tenants[input.tenant].documents[input.object][input.relation].users
+
+allow if {
+	input.relation == "viewer"
+	input.document.metadata.department == "public"
+}
+
+allow if {
+	input.relation == "viewer"
+	input.document.metadata.department in input.groups
+}
+
+allow if {
+	input.relation == "write"
+	"author" in input.roles
+	input.claims.email_verified
+}
+`
+
+func TestOPAPolicyDecidesOnMetadata(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "metadata.rego")
+	if err := os.WriteFile(policyFile, []byte(metadataPolicy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	opa, err := NewOPAPermissionService(policyFile, "")
+	if err != nil {
+		t.Fatalf("NewOPAPermissionService failed: %v", err)
+	}
+
+	ctx := context.Background()
+	alice := Principal{Username: "alice", Groups: []string{"finance"}}
+	public := models.Document{ID: uuid.New(), Metadata: map[string]interface{}{"department": "public"}}
+	finance := models.Document{ID: uuid.New(), Metadata: map[string]interface{}{"department": "finance"}}
+	legal := models.Document{ID: uuid.New(), Metadata: map[string]interface{}{"department": "legal"}}
+
+	if got := opa.BatchCheck(ctx, alice, []models.Document{public, finance, legal}); !slices.Equal(got, []bool{true, true, false}) {
+		t.Errorf("Expected the public and finance documents, got %v", got)
+	}
+	if opa.CanEditDocument(ctx, alice, &public) {
+		t.Error("Expected metadata to grant viewing only")
+	}
+	if err := opa.Grant(ctx, Tuple{Subject: "alice", Relation: RelationViewer, DocumentID: legal.ID}); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+	if !opa.CanAccessDocument(ctx, alice, &legal) {
+		t.Error("Expected the granted relation to apply under the custom policy")
+	}
+	// Roles and claims are policy input too
+	author := Principal{Username: "bob", Roles: []string{"author"}, Claims: map[string]interface{}{"email_verified": true}}
+	if !opa.CanWriteDocuments(ctx, author) {
+		t.Error("Expected the author role to grant writing")
+	}
+	author.Claims["email_verified"] = false
+	if opa.CanWriteDocuments(ctx, author) || opa.CanWriteDocuments(ctx, alice) {
+		t.Error("Expected writing to require the role and a verified email")
+	}
+	// The policy does not define reachable, so nothing is reaped
+	if orphaned, err := opa.Orphaned(ctx, &finance); err != nil || orphaned {
+		t.Errorf("Expected no document to be orphaned without a reachable rule, got %t (%v)", orphaned, err)
+	}
+}
+
+func TestOPASavesTuples(t *testing.T) {
+	tuplesFile := filepath.Join(t.TempDir(), "tuples.json")
+	opa, err := NewOPAPermissionService("", tuplesFile)
+	if err != nil {
+		t.Fatalf("NewOPAPermissionService failed: %v", err)
+	}
+	ctx := context.Background()
+	doc := models.Document{ID: uuid.New()}
+	if err := opa.Grant(ctx, Tuple{Group: "accounting-team", Relation: RelationViewer, DocumentID: doc.ID}); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+	if err := opa.AddMember(ctx, "accounting-team", "alice"); err != nil {
+		t.Fatalf("AddMember failed: %v", err)
+	}
+
+	reopened, err := NewOPAPermissionService("", tuplesFile)
+	if err != nil {
+		t.Fatalf("Reopening failed: %v", err)
+	}
+	if !reopened.CanAccessDocument(ctx, Principal{Username: "alice"}, &doc) {
+		t.Error("Expected the relations to survive a restart")
+	}
+	if stats, err := reopened.CountRelations(ctx); err != nil || stats.Relations[RelationViewer] != 1 || stats.Memberships != 1 || !slices.Equal(stats.Users, []string{"alice"}) {
+		t.Errorf("Expected one group relation and alice's membership, got %+v (%v)", stats, err)
+	}
+}
+
+func TestOPARejectsInvalidPolicy(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "broken.rego")
+	if err := os.WriteFile(policyFile, []byte("package rerag.authz\n\nallow if {"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewOPAPermissionService(policyFile, ""); err == nil {
+		t.Error("Expected an invalid policy to be rejected")
+	}
+	if _, err := NewOPAPermissionService(filepath.Join(t.TempDir(), "missing.rego"), ""); err == nil {
+		t.Error("Expected a missing policy file to be rejected")
+	}
+}
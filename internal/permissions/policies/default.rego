@@ -0,0 +1,32 @@
+# Default policy of the OPA permission backend. It decides checks from the
+# local relation tuples exactly like Keto: a user holds a relation on an object
+# if it was granted to them or to a group they are a member of, or one the
+# identity provider claims for them.
+#
+# Input: tenant, user, groups (claimed), relation, object (a document ID or
+# "corpus"), and document (id, title, metadata; absent for the corpus).
+# Data: data.rerag.tenants[tenant].documents[object][relation].{users,groups}
+# and data.rerag.tenants[tenant].members[group].
+package rerag.authz
+
+default allow := false
+
+holders := data.rerag.tenants[input.tenant].documents[input.object][input.relation]
+
+allow if input.user in holders.users
+
+allow if {
+	some group in holders.groups
+	member_of(group)
+}
+
+member_of(group) if input.user in data.rerag.tenants[input.tenant].members[group]
+
+member_of(group) if group in input.groups
+
+# reachable is false for documents nobody holds a relation on. The orphan
+# reaper deletes these; policies granting access through metadata should make
+# such documents reachable, or leave reachable undefined to never reap.
+default reachable := false
+
+reachable if count(data.rerag.tenants[input.tenant].documents[input.object]) > 0
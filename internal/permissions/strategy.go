@@ -0,0 +1,118 @@
+package permissions
+
+import (
+	"context"
+	"net/url"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"strconv"
+)
+
+// CheckStrategy selects how BatchCheck decides access to many documents
+type CheckStrategy string
+
+const (
+	// StrategyCheck asks Keto about every document with batch checks
+	StrategyCheck CheckStrategy = "check"
+	// StrategyList lists the documents the user may view, directly or
+	// through groups, once per request and intersects them with the checked
+	// documents. Users who may view more documents than the list limit are
+	// checked per document.
+	StrategyList CheckStrategy = "list"
+)
+
+// DefaultListLimit is the number of viewable documents above which
+// StrategyList falls back to per-document checks
+const DefaultListLimit = 1000
+
+// IsValid reports whether s is a supported strategy
+func (s CheckStrategy) IsValid() bool {
+	return s == StrategyCheck || s == StrategyList
+}
+
+// SetCheckStrategy selects how BatchCheck decides access; under StrategyList
+// at most listLimit viewable documents are listed. It must be called before
+// the service handles requests.
+func (k *KetoPermissionService) SetCheckStrategy(strategy CheckStrategy, listLimit int) {
+	k.strategy = strategy
+	k.listLimit = listLimit
+}
+
+// viewable returns the IDs of the documents the principal may view under
+// StrategyList, listed once per request. It reports false under
+// StrategyCheck, if the user may view more than the list limit, or if Keto
+// could not list them.
+func (k *KetoPermissionService) viewable(ctx context.Context, p Principal) (map[string]bool, bool) {
+	if k.strategy != StrategyList {
+		return nil, false
+	}
+	key := tenant.Namespace(ctx, k.naming.DocumentsNamespace) + "#" + k.naming.Viewer + "@" + k.naming.subject(p.Username)
+	if objects, ok := memoizedList(ctx, key); ok {
+		return objects, objects != nil
+	}
+	objects := k.listViewable(ctx, p)
+	memoizeList(ctx, key, objects)
+	return objects, objects != nil
+}
+
+// Warm lists the documents the principal may view under StrategyList, so
+// that BatchCheck with ctx answers from the list; under StrategyCheck there
+// is nothing to load before the documents are known
+func (k *KetoPermissionService) Warm(ctx context.Context, p Principal) {
+	k.viewable(ctx, p)
+}
+
+// subjects returns the list parameters selecting the tuples of the
+// principal: its own and those of every group it is a member of or claims
+func (k *KetoPermissionService) subjects(ctx context.Context, p Principal) ([]url.Values, error) {
+	groups, err := k.groups(ctx, p.Username)
+	if err != nil {
+		return nil, err
+	}
+	subjects := []url.Values{{"subject_id": {k.naming.subject(p.Username)}}}
+	for _, group := range append(groups, p.Groups...) {
+		set := k.groupSubject(ctx, group)
+		subjects = append(subjects, url.Values{
+			"subject_set.namespace": {set.Namespace},
+			"subject_set.object":    {set.Object},
+			"subject_set.relation":  {set.Relation},
+		})
+	}
+	return subjects, nil
+}
+
+// listViewable lists the documents the principal holds the viewer relation
+// on directly or through a group it is a member of or claims. It returns nil
+// if there are more than the list limit or a listing fails.
+func (k *KetoPermissionService) listViewable(ctx context.Context, p Principal) map[string]bool {
+	subjects, err := k.subjects(ctx, p)
+	if err != nil {
+		requestid.Logf(ctx, "Failed to list the groups of %s, checking documents one by one: %v", p.Username, err)
+		return nil
+	}
+
+	viewable := make(map[string]bool)
+	for _, params := range subjects {
+		params.Set("namespace", tenant.Namespace(ctx, k.naming.DocumentsNamespace))
+		params.Set("relation", k.naming.Viewer)
+		params.Set("page_size", strconv.Itoa(k.listLimit+1))
+		for {
+			page, next, err := k.listTuplesPage(ctx, params)
+			if err != nil {
+				requestid.Logf(ctx, "Failed to list the documents %s may view, checking them one by one: %v", p.Username, err)
+				return nil
+			}
+			for _, rt := range page {
+				viewable[rt.Object] = true
+			}
+			if len(viewable) > k.listLimit {
+				return nil
+			}
+			if next == "" {
+				break
+			}
+			params.Set("page_token", next)
+		}
+	}
+	return viewable
+}
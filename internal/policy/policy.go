@@ -0,0 +1,144 @@
+// Package policy reconciles a declarative permission policy file into Keto.
+//
+// A policy declares users, groups of users, and the relations they hold:
+//
+//	users: [alice, bob, peter]
+//	groups:
+//	  tax-advisors: [alice, peter]
+//	relations:
+//	  - subjects: [peter]
+//	    relation: write
+//	  - subjects: [group:tax-advisors]
+//	    relation: viewer
+//	    taxpayers: [John Doe]
+//	  - subjects: [bob]
+//	    relation: editor
+//	    documents: [c9f58d7a-5f68-4329-9daa-8d3622de1b7f]
+//	prune: true
+//
+// Group subjects are expanded to their members. Taxpayers select the documents
+// whose "taxpayer" metadata matches. With prune, relations of the declared
+// users that the policy does not list are revoked.
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.yaml.in/yaml/v3"
+)
+
+// groupPrefix marks a subject as a group rather than a user
+const groupPrefix = "group:"
+
+// TaxpayerMetadataKey is the document metadata key matched by taxpayer rules
+const TaxpayerMetadataKey = "taxpayer"
+
+// Policy is a declarative set of relations
+type Policy struct {
+	// Users whose relations the policy manages
+	Users []string `yaml:"users"`
+	// Groups map a group name to its member users
+	Groups map[string][]string `yaml:"groups"`
+	// Relations granted to users and groups
+	Relations []Rule `yaml:"relations"`
+	// Prune revokes relations of the declared users that the policy does not list
+	Prune bool `yaml:"prune"`
+}
+
+// Rule grants a relation to subjects on documents or, for write, on the corpus
+type Rule struct {
+	// Subjects are user names or "group:<name>"
+	Subjects []string `yaml:"subjects"`
+	Relation string   `yaml:"relation"`
+	// Documents are document IDs
+	Documents []string `yaml:"documents"`
+	// Taxpayers select documents by their "taxpayer" metadata
+	Taxpayers []string `yaml:"taxpayers"`
+}
+
+// Parse reads and validates a YAML policy. Unknown fields are rejected so
+// typos do not silently drop relations.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return &p, nil
+}
+
+// Validate checks that every subject is declared and every rule is well-formed
+func (p *Policy) Validate() error {
+	for _, user := range p.Users {
+		if user == "" || strings.HasPrefix(user, groupPrefix) {
+			return fmt.Errorf("invalid user name %q", user)
+		}
+	}
+	for group, members := range p.Groups {
+		if group == "" {
+			return fmt.Errorf("group name is required")
+		}
+		for _, member := range members {
+			if !slices.Contains(p.Users, member) {
+				return fmt.Errorf("group %s: member %q is not a declared user", group, member)
+			}
+		}
+	}
+
+	for i, rule := range p.Relations {
+		if len(rule.Subjects) == 0 {
+			return fmt.Errorf("relation %d: subjects are required", i+1)
+		}
+		for _, subject := range rule.Subjects {
+			if _, err := p.members(subject); err != nil {
+				return fmt.Errorf("relation %d: %w", i+1, err)
+			}
+		}
+		for _, id := range rule.Documents {
+			if _, err := uuid.Parse(id); err != nil {
+				return fmt.Errorf("relation %d: invalid document ID %q", i+1, id)
+			}
+		}
+
+		switch rule.Relation {
+		case permissions.RelationViewer, permissions.RelationEditor:
+			if len(rule.Documents) == 0 && len(rule.Taxpayers) == 0 {
+				return fmt.Errorf("relation %d: %s needs documents or taxpayers", i+1, rule.Relation)
+			}
+		case permissions.RelationWrite:
+			if len(rule.Documents) > 0 || len(rule.Taxpayers) > 0 {
+				return fmt.Errorf("relation %d: %s applies to the corpus and takes no documents or taxpayers", i+1, rule.Relation)
+			}
+		default:
+			return fmt.Errorf("relation %d: relation must be %s, %s, or %s", i+1,
+				permissions.RelationViewer, permissions.RelationEditor, permissions.RelationWrite)
+		}
+	}
+	return nil
+}
+
+// members expands a subject to the users it stands for
+func (p *Policy) members(subject string) ([]string, error) {
+	if group, ok := strings.CutPrefix(subject, groupPrefix); ok {
+		members, exists := p.Groups[group]
+		if !exists {
+			return nil, fmt.Errorf("unknown group %q", group)
+		}
+		return members, nil
+	}
+	if !slices.Contains(p.Users, subject) {
+		return nil, fmt.Errorf("subject %q is not a declared user", subject)
+	}
+	return []string{subject}, nil
+}
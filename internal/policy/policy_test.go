@@ -0,0 +1,193 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// fakeManager holds relation tuples in memory
+type fakeManager struct {
+	tuples []permissions.Tuple
+}
+
+func (f *fakeManager) Grant(_ context.Context, t permissions.Tuple) error {
+	f.tuples = append(f.tuples, t)
+	return nil
+}
+
+func (f *fakeManager) Revoke(_ context.Context, t permissions.Tuple) error {
+	f.tuples = slices.DeleteFunc(f.tuples, func(existing permissions.Tuple) bool { return existing == t })
+	return nil
+}
+
+func (f *fakeManager) ListTuples(_ context.Context, subject string) ([]permissions.Tuple, error) {
+	var tuples []permissions.Tuple
+	for _, t := range f.tuples {
+		if t.Subject == subject {
+			tuples = append(tuples, t)
+		}
+	}
+	return tuples, nil
+}
+
+// fakeDocuments filters documents by metadata like the vector store
+type fakeDocuments []models.Document
+
+func (f fakeDocuments) ListDocuments(opts storage.ListOptions) ([]models.Document, error) {
+	var docs []models.Document
+	for _, doc := range f {
+		matches := true
+		for key, value := range opts.Metadata {
+			if doc.Metadata[key] != value {
+				matches = false
+			}
+		}
+		if matches {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func TestParseRejectsInvalidPolicies(t *testing.T) {
+	docID := uuid.NewString()
+	tests := map[string]string{
+		"unknown field":      "users: [alice]\nrelation: []",
+		"undeclared subject": "users: [alice]\nrelations:\n  - subjects: [bob]\n    relation: write",
+		"unknown group":      "users: [alice]\nrelations:\n  - subjects: [group:team]\n    relation: write",
+		"undeclared member":  "users: [alice]\ngroups:\n  team: [bob]",
+		"unknown relation":   "users: [alice]\nrelations:\n  - subjects: [alice]\n    relation: owner\n    documents: [" + docID + "]",
+		"viewer without doc": "users: [alice]\nrelations:\n  - subjects: [alice]\n    relation: viewer",
+		"write with doc":     "users: [alice]\nrelations:\n  - subjects: [alice]\n    relation: write\n    documents: [" + docID + "]",
+		"invalid document":   "users: [alice]\nrelations:\n  - subjects: [alice]\n    relation: viewer\n    documents: [john-doe]",
+		"no subjects":        "users: [alice]\nrelations:\n  - relation: write",
+	}
+	for name, data := range tests {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := Parse(nil); err != nil {
+		t.Errorf("expected an empty policy to be valid, got %v", err)
+	}
+}
+
+func TestPlanExpandsGroupsAndTaxpayers(t *testing.T) {
+	johnReturn, johnW2, abcReturn := uuid.New(), uuid.New(), uuid.New()
+	docs := fakeDocuments{
+		{ID: johnReturn, Metadata: map[string]interface{}{"taxpayer": "John Doe"}},
+		{ID: johnW2, Metadata: map[string]interface{}{"taxpayer": "John Doe"}},
+		{ID: abcReturn, Metadata: map[string]interface{}{"taxpayer": "ABC Corporation"}},
+	}
+
+	p, err := Parse([]byte(strings.Join([]string{
+		"users: [alice, bob, peter]",
+		"groups:",
+		"  advisors: [alice, peter]",
+		"relations:",
+		"  - subjects: [peter]",
+		"    relation: write",
+		"  - subjects: [group:advisors]",
+		"    relation: viewer",
+		"    taxpayers: [John Doe]",
+		"  - subjects: [bob, peter]",
+		"    relation: viewer",
+		"    documents: [" + abcReturn.String() + "]",
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	stale := permissions.Tuple{Subject: "bob", Relation: permissions.RelationViewer, DocumentID: johnReturn}
+	manager := &fakeManager{tuples: []permissions.Tuple{
+		{Subject: "peter", Relation: permissions.RelationWrite},
+		stale,
+	}}
+
+	plan, err := NewPlan(context.Background(), p, docs, manager)
+	if err != nil {
+		t.Fatalf("NewPlan failed: %v", err)
+	}
+	wantGrant := []permissions.Tuple{
+		{Subject: "alice", Relation: permissions.RelationViewer, DocumentID: johnReturn},
+		{Subject: "alice", Relation: permissions.RelationViewer, DocumentID: johnW2},
+		{Subject: "peter", Relation: permissions.RelationViewer, DocumentID: johnReturn},
+		{Subject: "peter", Relation: permissions.RelationViewer, DocumentID: johnW2},
+		{Subject: "bob", Relation: permissions.RelationViewer, DocumentID: abcReturn},
+		{Subject: "peter", Relation: permissions.RelationViewer, DocumentID: abcReturn},
+	}
+	if !slices.Equal(plan.Grant, wantGrant) {
+		t.Errorf("expected grants %v, got %v", wantGrant, plan.Grant)
+	}
+	if len(plan.Revoke) != 0 {
+		t.Errorf("expected no revocations without prune, got %v", plan.Revoke)
+	}
+
+	p.Prune = true
+	plan, err = NewPlan(context.Background(), p, docs, manager)
+	if err != nil {
+		t.Fatalf("NewPlan failed: %v", err)
+	}
+	if !slices.Equal(plan.Revoke, []permissions.Tuple{stale}) {
+		t.Errorf("expected the stale relation to be revoked, got %v", plan.Revoke)
+	}
+
+	if err := plan.Apply(context.Background(), manager, nil); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	plan, err = NewPlan(context.Background(), p, docs, manager)
+	if err != nil {
+		t.Fatalf("NewPlan failed: %v", err)
+	}
+	if len(plan.Grant) != 0 || len(plan.Revoke) != 0 {
+		t.Errorf("expected an applied policy to be reconciled, got %+v", plan)
+	}
+}
+
+func TestDemoPolicyMatchesRelationTuples(t *testing.T) {
+	data, err := os.ReadFile("../../demo/documents/policy.yaml")
+	if err != nil {
+		t.Fatalf("failed to read demo policy: %v", err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		t.Fatalf("demo policy is invalid: %v", err)
+	}
+	tuples, err := p.Tuples(fakeDocuments{})
+	if err != nil {
+		t.Fatalf("Tuples failed: %v", err)
+	}
+
+	data, err = os.ReadFile("../../demo/documents/relation_tuples.json")
+	if err != nil {
+		t.Fatalf("failed to read demo tuples: %v", err)
+	}
+	var want []struct {
+		Object    string `json:"object"`
+		Relation  string `json:"relation"`
+		SubjectID string `json:"subject_id"`
+	}
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatalf("failed to decode demo tuples: %v", err)
+	}
+	if len(tuples) != len(want) {
+		t.Fatalf("expected %d tuples, got %d", len(want), len(tuples))
+	}
+	for _, w := range want {
+		if !slices.ContainsFunc(tuples, func(t permissions.Tuple) bool {
+			return t.Subject == w.SubjectID && t.Relation == w.Relation && t.Object() == w.Object
+		}) {
+			t.Errorf("demo policy is missing %s %s %s", w.SubjectID, w.Relation, w.Object)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+// listPageSize is the page size used to find the documents of a taxpayer
+const listPageSize = 500
+
+// DocumentLister finds the documents selected by taxpayer rules
+type DocumentLister interface {
+	ListDocuments(opts storage.ListOptions) ([]models.Document, error)
+}
+
+// Plan is the set of changes that brings Keto in line with a policy
+type Plan struct {
+	Grant  []permissions.Tuple
+	Revoke []permissions.Tuple
+}
+
+// Tuples resolves the policy into relation tuples, expanding groups to their
+// members and taxpayers to their documents. The result has no duplicates.
+func (p *Policy) Tuples(docs DocumentLister) ([]permissions.Tuple, error) {
+	var tuples []permissions.Tuple
+	seen := make(map[permissions.Tuple]bool)
+	add := func(t permissions.Tuple) {
+		if !seen[t] {
+			seen[t] = true
+			tuples = append(tuples, t)
+		}
+	}
+
+	for _, rule := range p.Relations {
+		objects, err := rule.objects(docs)
+		if err != nil {
+			return nil, err
+		}
+		for _, subject := range rule.Subjects {
+			users, err := p.members(subject)
+			if err != nil {
+				return nil, err
+			}
+			for _, user := range users {
+				for _, docID := range objects {
+					add(permissions.Tuple{Subject: user, Relation: rule.Relation, DocumentID: docID})
+				}
+			}
+		}
+	}
+	return tuples, nil
+}
+
+// objects returns the documents a rule applies to; uuid.Nil stands for the corpus
+func (r Rule) objects(docs DocumentLister) ([]uuid.UUID, error) {
+	if r.Relation == permissions.RelationWrite {
+		return []uuid.UUID{uuid.Nil}, nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(r.Documents))
+	for _, id := range r.Documents {
+		// Validated by Parse
+		docID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, docID)
+	}
+	for _, taxpayer := range r.Taxpayers {
+		for offset := 0; ; offset += listPageSize {
+			page, err := docs.ListDocuments(storage.ListOptions{
+				Limit:    listPageSize,
+				Offset:   offset,
+				Metadata: map[string]string{TaxpayerMetadataKey: taxpayer},
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to find documents of taxpayer %s: %w", taxpayer, err)
+			}
+			for _, doc := range page {
+				ids = append(ids, doc.ID)
+			}
+			if len(page) < listPageSize {
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// NewPlan compares the policy with the relations its users hold in the
+// tenant carried by ctx
+func NewPlan(ctx context.Context, p *Policy, docs DocumentLister, manager permissions.PermissionManager) (*Plan, error) {
+	desired, err := p.Tuples(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[permissions.Tuple]bool)
+	var current []permissions.Tuple
+	for _, user := range p.Users {
+		tuples, err := manager.ListTuples(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tuples {
+			existing[t] = true
+		}
+		current = append(current, tuples...)
+	}
+
+	plan := &Plan{}
+	wanted := make(map[permissions.Tuple]bool, len(desired))
+	for _, t := range desired {
+		wanted[t] = true
+		if !existing[t] {
+			plan.Grant = append(plan.Grant, t)
+		}
+	}
+	if p.Prune {
+		for _, t := range current {
+			if !wanted[t] {
+				plan.Revoke = append(plan.Revoke, t)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// Apply grants and revokes the planned relations, stopping at the first
+// failure. applied, if not nil, is called after each successful change.
+func (plan *Plan) Apply(ctx context.Context, manager permissions.PermissionManager, applied func(t permissions.Tuple, granted bool)) error {
+	for _, t := range plan.Grant {
+		if err := manager.Grant(ctx, t); err != nil {
+			return err
+		}
+		if applied != nil {
+			applied(t, true)
+		}
+	}
+	for _, t := range plan.Revoke {
+		if err := manager.Revoke(ctx, t); err != nil {
+			return err
+		}
+		if applied != nil {
+			applied(t, false)
+		}
+	}
+	return nil
+}
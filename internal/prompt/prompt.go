@@ -0,0 +1,221 @@
+// Package prompt renders the LLM prompts of the RAG pipeline from Go text/template
+// files that can be overridden per deployment and reloaded without a restart.
+package prompt
+
+import (
+	"context"
+	_ "embed" // embeds the built-in template
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// DefaultName is the template used when a request does not select one
+const DefaultName = "default"
+
+// CompareName is the built-in template of document comparisons
+const CompareName = "compare"
+
+// DirectName is the built-in template of questions answered without documents
+const DirectName = "direct"
+
+// ErrTemplateNotFound is returned when rendering an unknown template name
+var ErrTemplateNotFound = errors.New("prompt template not found")
+
+//go:embed templates/default.tmpl
+var builtinTemplate string
+
+//go:embed templates/compare.tmpl
+var compareTemplate string
+
+//go:embed templates/direct.tmpl
+var directTemplate string
+
+var funcs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}
+
+// Data is passed to the templates
+type Data struct {
+	Question  string
+	Documents []models.Document
+	History   []models.Message
+}
+
+// Registry holds the named prompt templates. Every template starts from the
+// built-in blocks ("system", "document", "citations", "refusal", "prompt") and may redefine
+// any of them; a file named like a built-in template other than the default,
+// such as compare.tmpl, starts from that template instead.
+type Registry struct {
+	dir string
+
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+	signature string // names, sizes, and modification times of the loaded files
+}
+
+// NewRegistry loads the built-in template and every *.tmpl file in dir.
+// An empty dir uses the built-in template only.
+func NewRegistry(dir string) (*Registry, error) {
+	r := &Registry{dir: dir}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the template directory. On error the previously loaded
+// templates stay in use.
+func (r *Registry) Reload() error {
+	base, err := template.New(DefaultName).Funcs(funcs).Parse(builtinTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse built-in prompt template: %w", err)
+	}
+
+	compare, err := base.Clone()
+	if err == nil {
+		_, err = compare.Parse(compareTemplate)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse built-in compare template: %w", err)
+	}
+
+	direct, err := base.Clone()
+	if err == nil {
+		_, err = direct.Parse(directTemplate)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse built-in direct template: %w", err)
+	}
+
+	templates := map[string]*template.Template{DefaultName: base, CompareName: compare, DirectName: direct}
+	files, signature, err := r.scan()
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".tmpl")
+		content, err := os.ReadFile(file) // #nosec G304 -- files come from the configured prompt directory
+		if err != nil {
+			return fmt.Errorf("failed to read prompt template %s: %w", file, err)
+		}
+
+		parent := base
+		if builtin, ok := templates[name]; ok && name != DefaultName {
+			parent = builtin
+		}
+		tmpl, err := parent.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := tmpl.Parse(string(content)); err != nil {
+			return fmt.Errorf("failed to parse prompt template %s: %w", file, err)
+		}
+		templates[name] = tmpl
+	}
+
+	r.mu.Lock()
+	r.templates = templates
+	r.signature = signature
+	r.mu.Unlock()
+	return nil
+}
+
+// scan lists the template files and fingerprints them for change detection
+func (r *Registry) scan() ([]string, string, error) {
+	if r.dir == "" {
+		return nil, "", nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.tmpl"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var signature strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to stat prompt template %s: %w", file, err)
+		}
+		fmt.Fprintf(&signature, "%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return files, signature.String(), nil
+}
+
+// Watch reloads the templates whenever a file in the directory changes, checking
+// every interval until ctx is done
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	if r.dir == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, signature, err := r.scan()
+			if err != nil {
+				log.Printf("Warning: failed to check prompt templates: %v", err)
+				continue
+			}
+
+			r.mu.RLock()
+			changed := signature != r.signature
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := r.Reload(); err != nil {
+				log.Printf("Warning: keeping previous prompt templates: %v", err)
+				continue
+			}
+			log.Printf("Reloaded prompt templates from %s", r.dir)
+		}
+	}
+}
+
+// Has reports whether a template with the given name is loaded
+func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.templates[name]
+	return ok
+}
+
+// Render returns the system prompt and the full prompt of the named template.
+// An empty name selects DefaultName.
+func (r *Registry) Render(name string, data Data) (system, prompt string, err error) {
+	if name == "" {
+		name = DefaultName
+	}
+
+	r.mu.RLock()
+	tmpl, ok := r.templates[name]
+	r.mu.RUnlock()
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	var systemBuf, promptBuf strings.Builder
+	if err := tmpl.ExecuteTemplate(&systemBuf, "system", data); err != nil {
+		return "", "", fmt.Errorf("failed to render system prompt: %w", err)
+	}
+	if err := tmpl.ExecuteTemplate(&promptBuf, "prompt", data); err != nil {
+		return "", "", fmt.Errorf("failed to render prompt: %w", err)
+	}
+	return systemBuf.String(), promptBuf.String(), nil
+}
@@ -0,0 +1,110 @@
+package prompt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRenderBuiltin(t *testing.T) {
+	registry, err := NewRegistry("")
+	if err != nil {
+		t.Fatalf("Failed to load built-in template: %v", err)
+	}
+
+	system, prompt, err := registry.Render("", Data{
+		Question: "What was the refund?",
+		Documents: []models.Document{{
+			ID:       uuid.New(),
+			Title:    "John Doe 2023",
+			Content:  "Refund: $1,200",
+			Metadata: map[string]interface{}{"year": 2023},
+		}},
+		History: []models.Message{{Role: models.RoleUser, Content: "Hello"}},
+	})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	if !strings.HasPrefix(system, "You are a helpful assistant") {
+		t.Errorf("Unexpected system prompt: %q", system)
+	}
+	for _, want := range []string{"Document 1: John Doe 2023", "Metadata: year: 2023", "User: Hello", "Question: What was the refund?", "likely unauthorized"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("Expected prompt to contain %q, got:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestRegistryOverridesAndReload(t *testing.T) {
+	dir := t.TempDir()
+	override := filepath.Join(dir, "terse.tmpl")
+	if err := os.WriteFile(override, []byte(`{{define "refusal"}}Say "I don't know".{{end}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	registry, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	// Overrides only replace the blocks they define
+	_, prompt, err := registry.Render("terse", Data{Question: "Q"})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.Contains(prompt, `Say "I don't know".`) || !strings.Contains(prompt, "Question: Q") {
+		t.Errorf("Expected overridden refusal in the built-in prompt, got:\n%s", prompt)
+	}
+
+	if _, _, err := registry.Render("missing", Data{}); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("Expected ErrTemplateNotFound, got %v", err)
+	}
+
+	// A broken template keeps the previous set in use
+	if err := os.WriteFile(override, []byte(`{{define "refusal"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Reload(); err == nil {
+		t.Error("Expected reload of a broken template to fail")
+	}
+	if !registry.Has("terse") {
+		t.Error("Expected previous templates to stay loaded after a failed reload")
+	}
+}
+
+func TestRenderCompare(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := NewRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := []models.Document{{ID: uuid.New(), Title: "2022", Content: "Refund: $900"}, {ID: uuid.New(), Title: "2023", Content: "Refund: $1,200"}}
+
+	_, prompt, err := registry.Render(CompareName, Data{Question: "refunds", Documents: docs})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	for _, want := range []string{"Document 2: 2023", "Compare the 2 documents above, focusing on: refunds.", `"differences"`} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("Expected prompt to contain %q, got:\n%s", want, prompt)
+		}
+	}
+
+	// A compare.tmpl file starts from the built-in compare template
+	if err := os.WriteFile(filepath.Join(dir, "compare.tmpl"), []byte(`{{define "system"}}Compare like an auditor.{{end}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	system, prompt, _ := registry.Render(CompareName, Data{Documents: docs})
+	if system != "Compare like an auditor." || !strings.Contains(prompt, `"differences"`) || strings.Contains(prompt, "focusing on") {
+		t.Errorf("Expected the override on top of the built-in compare template, got %q:\n%s", system, prompt)
+	}
+}
@@ -0,0 +1,133 @@
+// Package querycache caches generated answers for repeated queries over the same
+// permitted documents.
+package querycache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"rerag-rbac-rag-llm/internal/models"
+	"sync"
+	"time"
+)
+
+// entry is a cached response with its expiry time
+type entry struct {
+	key       string
+	response  models.QueryResponse
+	expiresAt time.Time
+}
+
+// Cache is an in-process LRU cache of query responses that expire after a fixed TTL
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front = most recently used
+}
+
+// New creates a cache holding at most maxEntries responses, each valid for ttl
+func New(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Key identifies a query by the tenant, the request options that shape the
+// answer, and the permitted documents retrieved for it. The documents act as
+// the caller's permission snapshot: any change in what the user may see, or in
+// the documents' content, produces a different key.
+func Key(tenantID string, req *models.QueryRequest, docs []models.Document) string {
+	h := sha256.New()
+	writeString(h, tenantID)
+	writeString(h, req.Question)
+	writeString(h, req.Template)
+	_ = binary.Write(h, binary.BigEndian, int64(req.TopK))
+	// Agent answers report their searches, so they are cached separately
+	_ = binary.Write(h, binary.BigEndian, req.Agent)
+	// Filters are echoed in the response; map keys marshal sorted
+	filters, _ := json.Marshal(req.Filters)
+	writeString(h, string(filters))
+	for _, doc := range docs {
+		h.Write(doc.ID[:])
+		writeString(h, doc.Title)
+		writeString(h, models.ContentHash(doc.Content))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeString writes s length-prefixed so adjacent fields cannot run together
+func writeString(h hash.Hash, s string) {
+	_ = binary.Write(h, binary.BigEndian, int64(len(s)))
+	h.Write([]byte(s))
+}
+
+// Get returns the cached response for key if present and not expired
+func (c *Cache) Get(key string) (*models.QueryResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := elem.Value.(*entry)
+	if c.now().After(e.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	response := e.response
+	return &response, true
+}
+
+// Set stores a response and evicts the least recently used entry when full
+func (c *Cache) Set(key string, response *models.QueryResponse) {
+	if c.maxEntries <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*entry)
+		e.response = *response
+		e.expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&entry{key: key, response: *response, expiresAt: expiresAt})
+
+	for c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// Len returns the number of cached responses, including expired ones not yet evicted
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// removeElement removes an entry; the caller must hold c.mu
+func (c *Cache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).key)
+}
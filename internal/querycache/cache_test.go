@@ -0,0 +1,57 @@
+package querycache
+
+import (
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCacheExpiresAndEvicts(t *testing.T) {
+	cache := New(time.Minute, 2)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", &models.QueryResponse{Answer: "A"})
+	cache.Set("b", &models.QueryResponse{Answer: "B"})
+	if resp, ok := cache.Get("a"); !ok || resp.Answer != "A" {
+		t.Fatalf("Expected cached answer A, got %v %v", resp, ok)
+	}
+
+	// "b" is now least recently used
+	cache.Set("c", &models.QueryResponse{Answer: "C"})
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("Expected a to expire")
+	}
+}
+
+func TestKeyDependsOnPermittedDocuments(t *testing.T) {
+	req := &models.QueryRequest{Question: "q", TopK: 3}
+	doc := models.Document{ID: uuid.New(), Title: "T", Content: "content"}
+	other := models.Document{ID: uuid.New(), Title: "U", Content: "other"}
+
+	base := Key("", req, []models.Document{doc})
+	if Key("", req, []models.Document{doc}) != base {
+		t.Error("Expected identical inputs to produce the same key")
+	}
+
+	changed := doc
+	changed.Content = "edited"
+	for name, key := range map[string]string{
+		"tenant":    Key("acme", req, []models.Document{doc}),
+		"documents": Key("", req, []models.Document{doc, other}),
+		"content":   Key("", req, []models.Document{changed}),
+		"top_k":     Key("", &models.QueryRequest{Question: "q", TopK: 4}, []models.Document{doc}),
+		"filters":   Key("", &models.QueryRequest{Question: "q", TopK: 3, Filters: map[string]models.MetadataFilter{"year": {Eq: 2023.0}}}, []models.Document{doc}),
+	} {
+		if key == base {
+			t.Errorf("Expected a different key when the %s changes", name)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Package quota limits how many documents and how much content each user and
+// each tenant may store.
+//
+// Documents are attributed to the user who ingested them through the
+// created_by metadata key. Limits are checked against the current usage
+// before a write, so concurrent ingestions may overshoot a limit slightly.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/storage"
+)
+
+// MetadataCreatedBy is the metadata key holding the user who ingested a document
+const MetadataCreatedBy = "created_by"
+
+// Errors wrapped by ExceededError naming the exhausted resource
+var (
+	ErrDocumentLimit = errors.New("document quota exceeded")
+	ErrContentLimit  = errors.New("content quota exceeded")
+)
+
+// Limits bound the storage of a user or tenant; zero means unlimited
+type Limits struct {
+	MaxDocuments    int
+	MaxContentBytes int64
+}
+
+// Scopes a quota applies to
+const (
+	ScopeTenant = "tenant"
+	ScopeUser   = "user"
+)
+
+// ExceededError reports a write that would exceed a quota
+type ExceededError struct {
+	Scope string // ScopeTenant or ScopeUser
+	Err   error  // ErrDocumentLimit or ErrContentLimit
+	Limit int64
+	Used  int64
+	Added int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s %s: %d used, %d requested, limit %d", e.Scope, e.Err, e.Used, e.Added, e.Limit)
+}
+
+func (e *ExceededError) Unwrap() error {
+	return e.Err
+}
+
+// Enforcer checks writes against the tenant and user limits
+type Enforcer struct {
+	tenant Limits
+	user   Limits
+}
+
+// NewEnforcer creates an enforcer applying tenant to every tenant and user
+// to every user within a tenant
+func NewEnforcer(tenant, user Limits) *Enforcer {
+	return &Enforcer{tenant: tenant, user: user}
+}
+
+// Limits returns the tenant and user limits
+func (e *Enforcer) Limits() (tenant, user Limits) {
+	return e.tenant, e.user
+}
+
+// Usage returns the storage used by the tenant counter is scoped to and by user within it
+func (e *Enforcer) Usage(counter storage.UsageCounter, user string) (tenant, own storage.Usage, err error) {
+	if tenant, err = counter.Usage(nil); err != nil {
+		return tenant, own, err
+	}
+	own, err = counter.Usage(map[string]string{MetadataCreatedBy: user})
+	return tenant, own, err
+}
+
+// Check returns an *ExceededError if adding tenantDelta to the tenant's usage
+// or userDelta to user's usage exceeds a limit. Only growing resources are
+// checked, so shrinking a document is allowed even over quota.
+func (e *Enforcer) Check(counter storage.UsageCounter, user string, tenantDelta, userDelta storage.Usage) error {
+	tenant, own, err := e.Usage(counter, user)
+	if err != nil {
+		return err
+	}
+	if err := exceeded(ScopeTenant, e.tenant, tenant, tenantDelta); err != nil {
+		return err
+	}
+	return exceeded(ScopeUser, e.user, own, userDelta)
+}
+
+// exceeded checks a single scope
+func exceeded(scope string, limits Limits, used, delta storage.Usage) error {
+	if limits.MaxDocuments > 0 && delta.Documents > 0 && used.Documents+delta.Documents > limits.MaxDocuments {
+		return &ExceededError{Scope: scope, Err: ErrDocumentLimit, Limit: int64(limits.MaxDocuments), Used: int64(used.Documents), Added: int64(delta.Documents)}
+	}
+	if limits.MaxContentBytes > 0 && delta.ContentBytes > 0 && used.ContentBytes+delta.ContentBytes > limits.MaxContentBytes {
+		return &ExceededError{Scope: scope, Err: ErrContentLimit, Limit: limits.MaxContentBytes, Used: used.ContentBytes, Added: delta.ContentBytes}
+	}
+	return nil
+}
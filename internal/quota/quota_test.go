@@ -0,0 +1,54 @@
+package quota
+
+import (
+	"errors"
+	"rerag-rbac-rag-llm/internal/storage"
+	"testing"
+)
+
+// fakeCounter reports fixed usage for the tenant and per user
+type fakeCounter struct {
+	tenant storage.Usage
+	users  map[string]storage.Usage
+}
+
+func (f fakeCounter) Usage(metadata map[string]string) (storage.Usage, error) {
+	if user, ok := metadata[MetadataCreatedBy]; ok {
+		return f.users[user], nil
+	}
+	return f.tenant, nil
+}
+
+func TestEnforcerCheck(t *testing.T) {
+	counter := fakeCounter{
+		tenant: storage.Usage{Documents: 9, ContentBytes: 900},
+		users:  map[string]storage.Usage{"alice": {Documents: 2, ContentBytes: 500}},
+	}
+	enforcer := NewEnforcer(Limits{MaxDocuments: 10}, Limits{MaxDocuments: 5, MaxContentBytes: 600})
+
+	for name, tc := range map[string]struct {
+		tenant, user storage.Usage
+		scope        string
+		err          error
+	}{
+		"fits":             {storage.Usage{Documents: 1, ContentBytes: 100}, storage.Usage{Documents: 1, ContentBytes: 100}, "", nil},
+		"tenant documents": {storage.Usage{Documents: 2}, storage.Usage{Documents: 2}, ScopeTenant, ErrDocumentLimit},
+		"user content":     {storage.Usage{Documents: 1, ContentBytes: 101}, storage.Usage{Documents: 1, ContentBytes: 101}, ScopeUser, ErrContentLimit},
+		"shrinking":        {storage.Usage{ContentBytes: -50}, storage.Usage{ContentBytes: -50}, "", nil},
+	} {
+		err := enforcer.Check(counter, "alice", tc.tenant, tc.user)
+		var exceeded *ExceededError
+		switch {
+		case tc.err == nil && err != nil:
+			t.Errorf("%s: unexpected error %v", name, err)
+		case tc.err != nil && (!errors.Is(err, tc.err) || !errors.As(err, &exceeded) || exceeded.Scope != tc.scope):
+			t.Errorf("%s: expected %s %v, got %v", name, tc.scope, tc.err, err)
+		}
+	}
+
+	// Users over quota may still shrink, but not grow, their documents
+	counter.users["alice"] = storage.Usage{Documents: 6, ContentBytes: 700}
+	if err := enforcer.Check(counter, "alice", storage.Usage{ContentBytes: -10}, storage.Usage{ContentBytes: -10}); err != nil {
+		t.Errorf("Expected shrinking over quota to be allowed, got %v", err)
+	}
+}
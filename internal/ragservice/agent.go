@@ -0,0 +1,119 @@
+package ragservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"rerag-rbac-rag-llm/internal/excerpt"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"slices"
+	"strings"
+)
+
+// searchTool is offered to the model in agent mode to retrieve more documents
+var searchTool = llm.Tool{
+	Name:        "search_documents",
+	Description: "Search the documents for information the documents found so far do not contain, such as details about another person, company, or year mentioned in the question.",
+	Parameters: json.RawMessage(`{
+		"type": "object",
+		"required": ["query"],
+		"properties": {
+			"query": {"type": "string", "description": "A short search query for the missing information"}
+		}
+	}`),
+}
+
+// refine lets the LLM issue follow-up searches for req.Question until it
+// stops asking, a round finds nothing new, or the configured number of
+// searches is reached. Every search runs through retrieve and so through the
+// user's permission filter. It returns docs extended with the new documents
+// in the order they were found and the searches issued. A model that fails to
+// choose tools ends the loop; the question is then answered from what was
+// found so far.
+func (s *Service) refine(ctx context.Context, p permissions.Principal, req *models.QueryRequest, docs []models.Document, usage *models.QueryUsage) ([]models.Document, []string, error) {
+	searches := []string{}
+	for len(searches) < s.agentSearches {
+		prompted, _ := s.promptDocuments(docs)
+		calls, err := s.callTools(ctx, agentPrompt(req.Question, prompted, searches), []llm.Tool{searchTool})
+		if err != nil {
+			log.Printf("Agent search planning failed, answering from %d documents: %v", len(docs), err)
+			break
+		}
+
+		found := false
+		for _, call := range calls {
+			var args struct {
+				Query string `json:"query"`
+			}
+			// The arguments were validated against the tool's parameters
+			_ = json.Unmarshal(call.Arguments, &args)
+			query := strings.TrimSpace(args.Query)
+			if query == "" || slices.Contains(searches, query) || len(searches) == s.agentSearches {
+				continue
+			}
+			searches = append(searches, query)
+
+			step := &models.QueryUsage{}
+			more, _, err := s.retrieve(ctx, p, req, query, step)
+			if err != nil {
+				return nil, nil, err
+			}
+			addUsage(usage, step)
+			for _, doc := range more {
+				if !slices.ContainsFunc(docs, func(d models.Document) bool { return d.ID == doc.ID }) {
+					docs = append(docs, doc)
+					found = true
+				}
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return docs, searches, nil
+}
+
+// callTools asks the LLM to choose tools while tracking the call so Drain
+// can wait for it
+func (s *Service) callTools(ctx context.Context, prompt string, tools []llm.Tool) ([]llm.ToolCall, error) {
+	s.generations.Add(1)
+	defer s.generations.Done()
+	return s.llmClient.CallTools(ctx, prompt, tools)
+}
+
+// agentPrompt asks the model whether documents, shown as the passages most
+// relevant to question, suffice to answer it. searches lists the searches
+// already issued so they are not repeated.
+func agentPrompt(question string, documents []models.Document, searches []string) string {
+	var b strings.Builder
+	b.WriteString("You are gathering documents to answer a question. Documents found so far:\n\n")
+	terms := excerpt.Terms(question)
+	for i, doc := range documents {
+		passage := ""
+		if excerpts := excerpt.Find(doc.Content, terms, 1, excerpt.DefaultWidth); len(excerpts) > 0 {
+			passage = excerpts[0].Text
+		}
+		fmt.Fprintf(&b, "Document %d: %s\n%s\n---\n", i+1, doc.Title, passage)
+	}
+	if len(documents) == 0 {
+		b.WriteString("(none)\n")
+	}
+	if len(searches) > 0 {
+		fmt.Fprintf(&b, "\nSearches already made: %s\n", strings.Join(searches, "; "))
+	}
+	fmt.Fprintf(&b, "\nQuestion: %s\n\n", question)
+	b.WriteString("If the documents lack information needed to answer the question, call search_documents once for each missing piece. If they suffice, answer \"done\" without calling a tool.")
+	return b.String()
+}
+
+// addUsage adds the embedding tokens and stage timings of a follow-up search
+// to usage
+func addUsage(usage, step *models.QueryUsage) {
+	usage.EmbeddingTokens += step.EmbeddingTokens
+	usage.Timings.EmbedMs += step.Timings.EmbedMs
+	usage.Timings.SearchMs += step.Timings.SearchMs
+	usage.Timings.RerankMs += step.Timings.RerankMs
+}
@@ -0,0 +1,86 @@
+package ragservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Queries run under a time budget so that a slow upstream service fails the
+// query with a TimeoutError instead of holding the handler past the server's
+// write timeout. Embedding the question may use a share of the budget and
+// searching, which starts with the embedding since the user's permissions are
+// listed meanwhile, the embedding's share plus its own. A stalled embedder or
+// permission service thus fails fast and generation gets at least the rest.
+// Calls of a stage are cancelled when its share or the budget runs out.
+
+// Shares of the query budget the stages before generation may use at most
+const (
+	embedBudgetShare  = 0.2
+	searchBudgetShare = 0.3
+)
+
+// TimeoutError reports that a query exceeded its time budget
+type TimeoutError struct {
+	Op     string // the stage that ran out of time, one of the Op constants
+	Budget time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("query exceeded its time budget of %s while trying to %s", e.Budget, e.Op)
+}
+
+// Unwrap lets errors.Is match context.DeadlineExceeded
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// WithQueryTimeout bounds the time a query may take to budget; 0 leaves
+// queries unbounded unless QueryOptions.Timeout sets a budget
+func WithQueryTimeout(budget time.Duration) Option {
+	return func(s *Service) {
+		s.queryTimeout = budget
+	}
+}
+
+// budgetKey is the context key of the budget of a query
+type budgetKey struct{}
+
+// budget returns the time budget of a query with opts: the shorter of the
+// service's budget and opts.Timeout, or 0 if neither is set
+func (s *Service) budget(opts QueryOptions) time.Duration {
+	if opts.Timeout > 0 && (s.queryTimeout <= 0 || opts.Timeout < s.queryTimeout) {
+		return opts.Timeout
+	}
+	return s.queryTimeout
+}
+
+// withBudget returns ctx expiring after budget along with its cancel func;
+// without a budget ctx is returned unchanged
+func withBudget(ctx context.Context, budget time.Duration) (context.Context, context.CancelFunc) {
+	if budget <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(context.WithValue(ctx, budgetKey{}, budget), budget)
+}
+
+// stageContext returns the context of a stage that may use share of the
+// budget of ctx; without a budget ctx is returned unchanged
+func stageContext(ctx context.Context, share float64) (context.Context, context.CancelFunc) {
+	budget, ok := ctx.Value(budgetKey{}).(time.Duration)
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(float64(budget)*share))
+}
+
+// timedOut returns a TimeoutError naming op if the stage run under ctx ran
+// out of its budget, and nil otherwise
+func timedOut(ctx context.Context, op string) error {
+	budget, ok := ctx.Value(budgetKey{}).(time.Duration)
+	if !ok || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil
+	}
+	return &TimeoutError{Op: op, Budget: budget}
+}
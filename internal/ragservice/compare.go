@@ -0,0 +1,191 @@
+package ragservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"rerag-rbac-rag-llm/internal/blob"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/injection"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/prompt"
+	"rerag-rbac-rag-llm/internal/quota"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// bookkeepingKeys are metadata fields set by the service itself, which differ
+// between any two documents and are left out of comparisons
+var bookkeepingKeys = []string{
+	quota.MetadataCreatedBy,
+	injection.MetadataRisk,
+	ingest.MetadataSourceID,
+	ingest.MetadataChunkIndex,
+	ingest.MetadataChunkCount,
+	ingest.MetadataChunkStart,
+	ingest.MetadataChunkEnd,
+	blob.MetadataDigest,
+}
+
+// ValidateCompare rejects comparisons of fewer than two or more than
+// MaxDocumentIDs distinct documents
+func ValidateCompare(req *models.CompareRequest) error {
+	req.DocumentIDs = uniqueIDs(req.DocumentIDs)
+	if len(req.DocumentIDs) < 2 || len(req.DocumentIDs) > MaxDocumentIDs {
+		return &ValidationError{Field: "document_ids", Err: fmt.Errorf("between 2 and %d distinct documents can be compared", MaxDocumentIDs)}
+	}
+	return nil
+}
+
+// Compare reports how the documents of req differ: the metadata fields with
+// different values, and a summary and content differences generated by the
+// LLM with the built-in compare prompt. The focus and the generated
+// comparison are moderated like the question and answer of a query; a
+// blocked one leaves only the metadata differences. p must be able to read
+// every document; req must have passed ValidateCompare.
+func (s *Service) Compare(ctx context.Context, p permissions.Principal, req *models.CompareRequest) (*models.CompareResponse, error) {
+	docs, err := s.selected(ctx, p, req.DocumentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.CompareResponse{
+		Documents:   make([]models.ComparedDocument, len(docs)),
+		Metadata:    metadataDifferences(docs),
+		Summary:     models.ModeratedAnswer,
+		Differences: []models.ContentDifference{},
+	}
+	for i, doc := range docs {
+		response.Documents[i] = models.ComparedDocument{ID: doc.ID, Title: doc.Title}
+	}
+
+	if req.Focus != "" {
+		moderations, blocked, err := s.moderateQuestion(ctx, p.Username, req.Focus)
+		if err != nil {
+			return nil, err
+		}
+		response.Moderation = moderations
+		if blocked {
+			return response, nil
+		}
+	}
+
+	result, err := s.Generate(ctx, req.Focus, docs, llm.Options{Template: prompt.CompareName})
+	if err != nil {
+		return nil, err
+	}
+	answer := result.Answer
+	if s.redactor != nil {
+		// The user may read every compared document
+		answer = s.redactor.Rehydrate(answer, docs)
+	}
+	for i := range response.Documents {
+		response.Documents[i].Included = i < len(result.Included) && result.Included[i]
+	}
+
+	moderated, blocked, err := s.moderateGenerated(ctx, p.Username, answer)
+	if err != nil {
+		return nil, err
+	}
+	if moderated != nil {
+		response.Moderation = append(response.Moderation, *moderated)
+	}
+	if !blocked {
+		response.Summary, response.Differences = parseComparison(answer, len(docs))
+	}
+	return response, nil
+}
+
+// metadataDifferences lists the metadata fields whose values are not the same
+// in all docs, sorted by key
+func metadataDifferences(docs []models.Document) []models.MetadataDifference {
+	var keys []string
+	for _, doc := range docs {
+		for key := range doc.Metadata {
+			if !slices.Contains(keys, key) && !slices.Contains(bookkeepingKeys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	differences := []models.MetadataDifference{}
+	for _, key := range keys {
+		values := make([]interface{}, len(docs))
+		_, firstPresent := docs[0].Metadata[key]
+		same := true
+		for i, doc := range docs {
+			value, present := doc.Metadata[key]
+			values[i] = value
+			if present != firstPresent || !equalValues(value, values[0]) {
+				same = false
+			}
+		}
+		if !same {
+			differences = append(differences, models.MetadataDifference{Key: key, Values: values})
+		}
+	}
+	return differences
+}
+
+// equalValues compares metadata values, treating numbers of different Go
+// types as equal when their values are, as after a JSON round trip
+func equalValues(a, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// number converts the numeric types metadata may hold to float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// parseComparison extracts the summary and the differences with one value per
+// document from the model's JSON answer. Answers that are not such JSON are
+// returned whole as the summary.
+func parseComparison(answer string, documents int) (string, []models.ContentDifference) {
+	var parsed struct {
+		Summary     string `json:"summary"`
+		Differences []struct {
+			Aspect string        `json:"aspect"`
+			Values []interface{} `json:"values"`
+		} `json:"differences"`
+	}
+	output, err := llm.ExtractJSON(answer)
+	if err != nil || json.Unmarshal(output, &parsed) != nil || parsed.Summary == "" {
+		return strings.TrimSpace(answer), []models.ContentDifference{}
+	}
+
+	differences := []models.ContentDifference{}
+	for _, d := range parsed.Differences {
+		if d.Aspect == "" || len(d.Values) != documents {
+			continue
+		}
+		values := make([]string, len(d.Values))
+		for i, v := range d.Values {
+			if s, ok := v.(string); ok {
+				values[i] = s
+			} else {
+				encoded, _ := json.Marshal(v)
+				values[i] = string(encoded)
+			}
+		}
+		differences = append(differences, models.ContentDifference{Aspect: d.Aspect, Values: values})
+	}
+	return parsed.Summary, differences
+}
@@ -0,0 +1,413 @@
+package ragservice
+
+import (
+	"context"
+	"errors"
+	"rerag-rbac-rag-llm/internal/blob"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/quota"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/webhooks"
+
+	"github.com/google/uuid"
+)
+
+// Ingest embeds and stores doc on behalf of p, who needs the write
+// relation on the corpus. A doc with the ID of an existing document replaces it.
+func (s *Service) Ingest(ctx context.Context, p permissions.Principal, doc *models.Document) error {
+	if !s.permService.CanWriteDocuments(ctx, p) {
+		return denied(ctx, "user %s is not allowed to write documents", p.Username)
+	}
+
+	s.sanitize(doc)
+	doc.Metadata = setCreatedBy(doc.Metadata, p.Username)
+	delete(doc.Metadata, blob.MetadataDigest)
+
+	store := s.store(ctx)
+	var existing *models.Document
+	if doc.ID != uuid.Nil {
+		var err error
+		if existing, err = store.GetDocument(doc.ID); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+			return &OpError{Op: OpGetDocument, Err: err}
+		}
+	}
+	tenantDelta, userDelta := replacementDeltas(doc, existing, p.Username)
+	if err := s.checkQuota(store, p.Username, tenantDelta, userDelta); err != nil {
+		return err
+	}
+
+	if err := s.embed(ctx, doc); err != nil {
+		return err
+	}
+
+	if err := store.UpsertDocument(doc); err != nil {
+		return &OpError{Op: OpStoreDocument, Err: err}
+	}
+	s.notifyDocument(ctx, webhooks.DocumentCreated, doc)
+	return nil
+}
+
+// embed sets the embedding of doc, and with chunk vectors its chunks
+func (s *Service) embed(ctx context.Context, doc *models.Document) error {
+	if s.chunkVectors {
+		if err := s.ingest.EmbedChunks(ctx, doc); err != nil {
+			return &OpError{Op: OpEmbed, Err: err}
+		}
+		if len(doc.Chunks) > 0 {
+			return nil
+		}
+	}
+	embedding, err := s.embedder.GetEmbedding(ctx, doc.Content)
+	if err != nil {
+		return &OpError{Op: OpEmbed, Err: err}
+	}
+	doc.Embedding = embedding
+	return nil
+}
+
+// IngestSource splits src into chunks and stores each as a document of its
+// own, or all as one document with chunk vectors, attributed to p. On a storage failure the documents stored so
+// far are returned with the error.
+func (s *Service) IngestSource(ctx context.Context, p permissions.Principal, src ingest.Source) ([]models.Document, error) {
+	if !s.permService.CanWriteDocuments(ctx, p) {
+		return nil, denied(ctx, "user %s is not allowed to write documents", p.Username)
+	}
+
+	store := s.store(ctx)
+	delta := storage.Usage{}
+	for _, chunk := range s.ingest.Split(src.Text) {
+		delta.Documents++
+		delta.ContentBytes += int64(len(chunk))
+	}
+	if s.chunkVectors && delta.Documents > 0 {
+		delta = storage.Usage{Documents: 1, ContentBytes: int64(len(src.Text))}
+	}
+	if err := s.checkQuota(store, p.Username, delta, delta); err != nil {
+		return nil, err
+	}
+
+	src.Metadata = setCreatedBy(src.Metadata, p.Username)
+	docs, err := s.ingest.Ingest(ctx, store, src)
+	for i := range docs {
+		s.notifyDocument(ctx, webhooks.DocumentCreated, &docs[i])
+	}
+	if err != nil {
+		return docs, &OpError{Op: OpIngest, Err: err}
+	}
+	return docs, nil
+}
+
+// Get returns the document with id if p may read it. A document the
+// user may not read is reported as not found, like by Access.
+func (s *Service) Get(ctx context.Context, p permissions.Principal, id uuid.UUID) (*models.Document, error) {
+	doc, err := s.store(ctx).GetDocument(id)
+	if err != nil {
+		return nil, getError(err)
+	}
+	if !s.permService.CanAccessDocument(ctx, p, doc) {
+		if permissions.Unavailable(ctx) {
+			return nil, denied(ctx, "user %s is not allowed to read document %s", p.Username, id)
+		}
+		return nil, storage.ErrDocumentNotFound
+	}
+	return doc, nil
+}
+
+// Update replaces the document with doc.ID if p may edit it and
+// reports whether its content changed and was embedded again. Updates keep
+// the attribution and count against the quota of the user who ingested the
+// document.
+func (s *Service) Update(ctx context.Context, p permissions.Principal, doc *models.Document) (bool, error) {
+	store := s.store(ctx)
+	existing, err := store.GetDocument(doc.ID)
+	if err != nil {
+		return false, getError(err)
+	}
+	if !s.permService.CanEditDocument(ctx, p, existing) {
+		return false, denied(ctx, "user %s is not allowed to edit document %s", p.Username, doc.ID)
+	}
+
+	s.sanitize(doc)
+	owner, _ := existing.Metadata[quota.MetadataCreatedBy].(string)
+	if owner != "" {
+		doc.Metadata = setCreatedBy(doc.Metadata, owner)
+	}
+	doc.Metadata = keepOriginal(doc.Metadata, existing.Metadata)
+	delta, _ := replacementDeltas(doc, existing, owner)
+	if err := s.checkQuota(store, owner, delta, delta); err != nil {
+		return false, err
+	}
+
+	reembedded := models.ContentHash(doc.Content) != models.ContentHash(existing.Content)
+	if reembedded {
+		if err := s.embed(ctx, doc); err != nil {
+			return false, err
+		}
+	}
+
+	if err := store.UpdateDocument(doc); err != nil {
+		return false, &OpError{Op: OpUpdate, Err: err}
+	}
+	s.notifyDocument(ctx, webhooks.DocumentUpdated, doc)
+	return reembedded, nil
+}
+
+// Delete removes the document with id if p may edit it and reports
+// whether it was moved to the trash, which stores with a trash do so it stays
+// restorable until it is purged
+func (s *Service) Delete(ctx context.Context, p permissions.Principal, id uuid.UUID) (bool, error) {
+	store := s.store(ctx)
+	existing, err := store.GetDocument(id)
+	if err != nil {
+		return false, getError(err)
+	}
+	if !s.permService.CanEditDocument(ctx, p, existing) {
+		return false, denied(ctx, "user %s is not allowed to delete document %s", p.Username, id)
+	}
+
+	trashed := false
+	deleteDocument := store.DeleteDocument
+	if trash, ok := store.(storage.Trash); ok {
+		trashed = true
+		deleteDocument = trash.TrashDocument
+	}
+	if err := deleteDocument(id); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+		return false, &OpError{Op: OpDelete, Err: err}
+	}
+	s.notifyDocument(ctx, webhooks.DocumentDeleted, existing)
+	return trashed, nil
+}
+
+// Trash returns a page of the tenant's deleted documents that have not been
+// purged yet. Trashed documents are no longer checked per document, so p
+// needs the write relation on the corpus.
+func (s *Service) Trash(ctx context.Context, p permissions.Principal, opts storage.ListOptions) ([]models.Document, error) {
+	if !s.permService.CanWriteDocuments(ctx, p) {
+		return nil, denied(ctx, "user %s is not allowed to list deleted documents", p.Username)
+	}
+	trash, ok := s.store(ctx).(storage.Trash)
+	if !ok {
+		return nil, ErrTrashUnsupported
+	}
+	docs, err := trash.ListTrash(opts)
+	if err != nil {
+		return nil, &OpError{Op: OpListTrash, Err: err}
+	}
+	return docs, nil
+}
+
+// Restore moves the deleted document with id out of the trash if p may edit
+// it, like deleting it requires
+func (s *Service) Restore(ctx context.Context, p permissions.Principal, id uuid.UUID) error {
+	if !s.permService.CanEditDocument(ctx, p, &models.Document{ID: id}) {
+		return denied(ctx, "user %s is not allowed to restore document %s", p.Username, id)
+	}
+	store := s.store(ctx)
+	trash, ok := store.(storage.Trash)
+	if !ok {
+		return ErrTrashUnsupported
+	}
+	if err := trash.RestoreDocument(id); err != nil {
+		if errors.Is(err, storage.ErrDocumentNotFound) {
+			return err
+		}
+		return &OpError{Op: OpRestore, Err: err}
+	}
+	if restored, err := store.GetDocument(id); err == nil {
+		s.notifyDocument(ctx, webhooks.DocumentRestored, restored)
+	}
+	return nil
+}
+
+// Access returns the relations p holds on the document with id:
+// viewer and editor, in that order, as the permission checks enforcing them
+// decide, so relations held through groups and attribute rules count. A
+// document the user holds no relation on is reported as not found.
+func (s *Service) Access(ctx context.Context, p permissions.Principal, id uuid.UUID) ([]string, error) {
+	existing, err := s.store(ctx).GetDocument(id)
+	if err != nil {
+		return nil, getError(err)
+	}
+
+	relations := []string{}
+	if s.permService.CanAccessDocument(ctx, p, existing) {
+		relations = append(relations, permissions.RelationViewer)
+	}
+	if s.permService.CanEditDocument(ctx, p, existing) {
+		relations = append(relations, permissions.RelationEditor)
+	}
+	// A check Keto could not answer makes the result incomplete
+	if permissions.Unavailable(ctx) {
+		return nil, denied(ctx, "relations of user %s on document %s are unknown", p.Username, id)
+	}
+	if len(relations) == 0 {
+		return nil, storage.ErrDocumentNotFound
+	}
+	return relations, nil
+}
+
+// List returns up to opts.Limit documents p may access. Storage pages
+// are scanned until enough accessible documents are collected, so the
+// returned next offset points into the storage ordering, not the filtered
+// result; it is nil on the last page.
+func (s *Service) List(ctx context.Context, p permissions.Principal, opts storage.ListOptions) ([]models.Document, *int, error) {
+	store := s.store(ctx)
+	limit := opts.Limit
+
+	docs := make([]models.Document, 0, limit)
+	var nextOffset *int
+	for len(docs) < limit {
+		page, err := store.ListDocuments(opts)
+		if err != nil {
+			return nil, nil, &OpError{Op: OpList, Err: err}
+		}
+
+		allowed := s.permService.BatchCheck(ctx, p, page)
+		consumed := 0
+		for i := range page {
+			if len(docs) == limit {
+				break
+			}
+			consumed++
+			if allowed[i] {
+				docs = append(docs, page[i])
+			}
+		}
+		opts.Offset += consumed
+
+		if len(docs) == limit {
+			// A full storage page may be followed by more rows
+			if consumed < len(page) || len(page) == opts.Limit {
+				offset := opts.Offset
+				nextOffset = &offset
+			}
+			break
+		}
+		if len(page) < opts.Limit {
+			break
+		}
+	}
+	if err := unavailable(ctx); err != nil {
+		return nil, nil, err
+	}
+	return docs, nextOffset, nil
+}
+
+// Stats aggregates the metadata of the documents p may access that match
+// opts.Metadata; opts.IDs and opts.DistinctKey are set here. Storage pages
+// are scanned for accessible documents like List, then the store computes
+// the aggregates over their metadata. The chunks of one upload count once.
+func (s *Service) Stats(ctx context.Context, p permissions.Principal, opts storage.AggregateOptions) ([]storage.AggregateGroup, error) {
+	store := s.store(ctx)
+	aggregator, ok := store.(storage.Aggregator)
+	if !ok {
+		return nil, ErrAggregationUnsupported
+	}
+
+	opts.IDs = nil
+	list := storage.ListOptions{Limit: aggregatePageSize, Metadata: opts.Metadata}
+	for {
+		page, err := store.ListDocuments(list)
+		if err != nil {
+			return nil, &OpError{Op: OpList, Err: err}
+		}
+		for i, allowed := range s.permService.BatchCheck(ctx, p, page) {
+			if allowed {
+				opts.IDs = append(opts.IDs, page[i].ID)
+			}
+		}
+		list.Offset += len(page)
+		if len(page) < list.Limit {
+			break
+		}
+	}
+	if err := unavailable(ctx); err != nil {
+		return nil, err
+	}
+
+	opts.DistinctKey = ingest.MetadataSourceID
+	groups, err := aggregator.Aggregate(opts)
+	if err != nil {
+		return nil, &OpError{Op: OpAggregate, Err: err}
+	}
+	return groups, nil
+}
+
+// getError wraps a failed lookup unless the document does not exist
+func getError(err error) error {
+	if errors.Is(err, storage.ErrDocumentNotFound) {
+		return err
+	}
+	return &OpError{Op: OpGetDocument, Err: err}
+}
+
+// sanitize strips injection attempts from doc and records its risk score if configured
+func (s *Service) sanitize(doc *models.Document) {
+	if s.sanitizer != nil {
+		s.sanitizer.Document(doc)
+	}
+}
+
+// setCreatedBy attributes a document to the user who ingests it, replacing
+// any value sent by the client so quotas cannot be dodged
+func setCreatedBy(metadata map[string]interface{}, username string) map[string]interface{} {
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	metadata[quota.MetadataCreatedBy] = username
+	return metadata
+}
+
+// keepOriginal carries the original file of a document over to its new
+// metadata, so clients can neither drop it nor point it at another file
+func keepOriginal(metadata, existing map[string]interface{}) map[string]interface{} {
+	digest, ok := existing[blob.MetadataDigest]
+	if !ok {
+		delete(metadata, blob.MetadataDigest)
+		return metadata
+	}
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+	}
+	metadata[blob.MetadataDigest] = digest
+	return metadata
+}
+
+// checkQuota returns nil if quotas are disabled or store has room for a write
+// growing the tenant by tenantDelta and owner by ownerDelta
+func (s *Service) checkQuota(store storage.VectorStore, owner string, tenantDelta, ownerDelta storage.Usage) error {
+	if s.quotas == nil {
+		return nil
+	}
+	counter, ok := store.(storage.UsageCounter)
+	if !ok {
+		return ErrUsageUnsupported
+	}
+	if owner == "" {
+		// Documents ingested before attribution belong to nobody
+		ownerDelta = storage.Usage{}
+	}
+
+	err := s.quotas.Check(counter, owner, tenantDelta, ownerDelta)
+	var exceeded *quota.ExceededError
+	if err != nil && !errors.As(err, &exceeded) {
+		return &OpError{Op: OpMeasureUsage, Err: err}
+	}
+	return err
+}
+
+// replacementDeltas returns how storing doc in place of existing, which is nil
+// for new documents, grows the tenant and the user storing it
+func replacementDeltas(doc, existing *models.Document, username string) (tenant, user storage.Usage) {
+	size := int64(len(doc.Content))
+	if existing == nil {
+		return storage.Usage{Documents: 1, ContentBytes: size}, storage.Usage{Documents: 1, ContentBytes: size}
+	}
+	tenant = storage.Usage{ContentBytes: size - int64(len(existing.Content))}
+	if existing.Metadata[quota.MetadataCreatedBy] == username {
+		return tenant, tenant
+	}
+	return tenant, storage.Usage{Documents: 1, ContentBytes: size}
+}
@@ -0,0 +1,104 @@
+package ragservice
+
+import (
+	"context"
+	"rerag-rbac-rag-llm/internal/clientip"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"strings"
+)
+
+// WithModeration checks questions before they are answered and generated
+// answers before they are returned against the content policy of moderator.
+// Flagged questions and answers are handled with questionAction and
+// answerAction; an empty action leaves that side unchecked.
+func WithModeration(moderator moderation.Moderator, questionAction, answerAction moderation.Action) Option {
+	return func(s *Service) {
+		s.moderator = moderator
+		s.questionAction = questionAction
+		s.answerAction = answerAction
+	}
+}
+
+// moderateQuestion checks question if questions are moderated. It returns
+// the moderation to report to the user, if any, and whether the question
+// must not be answered.
+func (s *Service) moderateQuestion(ctx context.Context, username, question string) ([]models.QueryModeration, bool, error) {
+	if s.moderator == nil || s.questionAction == "" {
+		return nil, false, nil
+	}
+	moderated, err := s.moderate(ctx, username, models.ModerationStageQuestion, s.questionAction, question)
+	if err != nil || moderated == nil {
+		return nil, false, err
+	}
+	return []models.QueryModeration{*moderated}, s.questionAction == moderation.ActionBlock, nil
+}
+
+// moderateAnswer checks the answer of response if answers are moderated. It
+// returns response reporting moderations, which hold those of the question,
+// with the answer and its sources withheld if it was blocked. Answers given
+// without the LLM are not checked, and response itself, which may be cached,
+// is never changed.
+func (s *Service) moderateAnswer(ctx context.Context, username string, response *models.QueryResponse, moderations []models.QueryModeration) (*models.QueryResponse, error) {
+	var blocked bool
+	if !response.NoAccessibleDocuments && response.Aggregation == nil {
+		moderated, block, err := s.moderateGenerated(ctx, username, response.Answer)
+		if err != nil {
+			return nil, err
+		}
+		if moderated != nil {
+			moderations = append(moderations, *moderated)
+			blocked = block
+		}
+	}
+	if len(moderations) == 0 {
+		return response, nil
+	}
+
+	moderated := *response
+	moderated.Moderation = moderations
+	if blocked {
+		moderated.Answer = models.ModeratedAnswer
+		moderated.Sources = []models.SourceDocument{}
+		moderated.SourcesIncluded = 0
+		moderated.StrippedCitations = 0
+	}
+	return &moderated, nil
+}
+
+// moderateGenerated checks text generated by the LLM if answers are
+// moderated. It returns the moderation to report to the user, if any, and
+// whether text must be withheld.
+func (s *Service) moderateGenerated(ctx context.Context, username, text string) (*models.QueryModeration, bool, error) {
+	if s.moderator == nil || s.answerAction == "" {
+		return nil, false, nil
+	}
+	moderated, err := s.moderate(ctx, username, models.ModerationStageAnswer, s.answerAction, text)
+	if err != nil || moderated == nil {
+		return nil, false, err
+	}
+	return moderated, s.answerAction == moderation.ActionBlock, nil
+}
+
+// moderate checks text of stage and records it in the audit log if it is
+// flagged. It returns what to report to the user about it: nil unless text
+// was flagged and action blocks or annotates it.
+func (s *Service) moderate(ctx context.Context, username, stage string, action moderation.Action, text string) (*models.QueryModeration, error) {
+	verdict, err := s.moderator.Moderate(ctx, text)
+	if err != nil {
+		if timeout := timedOut(ctx, OpModerate); timeout != nil {
+			return nil, timeout
+		}
+		return nil, &OpError{Op: OpModerate, Err: err}
+	}
+	if !verdict.Flagged {
+		return nil, nil
+	}
+	requestid.Logf(ctx, "AUDIT content moderated: user=%q stage=%s action=%s categories=%s client_ip=%s",
+		username, stage, action, strings.Join(verdict.Categories, ","), clientip.FromContext(ctx))
+	if action == moderation.ActionFlag {
+		return nil, nil
+	}
+	return &models.QueryModeration{Stage: stage, Action: string(action), Categories: verdict.Categories}, nil
+}
@@ -0,0 +1,620 @@
+package ragservice
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/citation"
+	"rerag-rbac-rag-llm/internal/excerpt"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/injection"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/querycache"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/rerank"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+// QueryOptions adjust how a question is answered
+type QueryOptions struct {
+	// History holds prior conversation turns for the prompt. The last user
+	// turn is also searched for, and answers with history are not cached.
+	History []models.Message
+	// Stream, if set, receives the answer in pieces as the model generates
+	// it. Cached answers and answers without sources are not streamed.
+	Stream func(delta string)
+	// Timeout, if positive, bounds the time of the query like
+	// WithQueryTimeout; it can shorten the service's budget but not extend it
+	Timeout time.Duration
+}
+
+// MaxDocumentIDs is the number of documents a question may be restricted to
+// with QueryRequest.DocumentIDs
+const MaxDocumentIDs = 20
+
+// ValidateQuery applies defaults to req and rejects invalid retrieval options
+func ValidateQuery(req *models.QueryRequest) error {
+	req.TopK = cmp.Or(req.TopK, 3)
+	req.SearchMode = cmp.Or(req.SearchMode, models.SearchModeVector)
+	if req.SearchMode != models.SearchModeVector && req.SearchMode != models.SearchModeHybrid {
+		return &ValidationError{Field: "search_mode", Err: fmt.Errorf("search_mode must be %q or %q", models.SearchModeVector, models.SearchModeHybrid)}
+	}
+	if req.MinScore < 0 || req.MinScore > 1 {
+		return &ValidationError{Field: "min_score", Err: fmt.Errorf("min_score must be between 0 and 1")}
+	}
+	if err := storage.ValidateFilters(req.Filters); err != nil {
+		return &ValidationError{Field: "filters", Err: err}
+	}
+	if len(req.DocumentIDs) > 0 {
+		req.DocumentIDs = uniqueIDs(req.DocumentIDs)
+		if len(req.DocumentIDs) > MaxDocumentIDs {
+			return &ValidationError{Field: "document_ids", Err: fmt.Errorf("at most %d documents can be asked about", MaxDocumentIDs)}
+		}
+		if len(req.Filters) > 0 {
+			return &ValidationError{Field: "document_ids", Err: fmt.Errorf("document_ids cannot be combined with filters")}
+		}
+		if req.Agent {
+			return &ValidationError{Field: "document_ids", Err: fmt.Errorf("document_ids cannot be combined with agent mode")}
+		}
+	}
+	switch req.Route {
+	case "", models.QueryRouteRetrieval:
+	case models.QueryRouteAggregate, models.QueryRouteDirect:
+		if len(req.DocumentIDs) > 0 || req.Agent {
+			return &ValidationError{Field: "route", Err: fmt.Errorf("document_ids and agent mode require the %s route", models.QueryRouteRetrieval)}
+		}
+	default:
+		return &ValidationError{Field: "route", Err: fmt.Errorf("route must be %q, %q, or %q", models.QueryRouteRetrieval, models.QueryRouteAggregate, models.QueryRouteDirect)}
+	}
+	return nil
+}
+
+// uniqueIDs returns ids without repetitions, keeping the first occurrences in order
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !slices.Contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// Query answers req.Question for p from the documents the user may
+// access. req must have passed ValidateQuery. Retrieval runs with the user's
+// permissions, so cached answers are only shared between users who may see
+// exactly the same documents. With routing, counts of documents and small
+// talk are answered without retrieval. With moderation, the question and
+// the answer are checked against the content policy. A query exceeding its
+// time budget fails with a *TimeoutError.
+func (s *Service) Query(ctx context.Context, p permissions.Principal, req *models.QueryRequest, opts QueryOptions) (*models.QueryResponse, error) {
+	if req.Agent && s.agentSearches == 0 {
+		return nil, &ValidationError{Field: "agent", Err: fmt.Errorf("agent mode is not enabled")}
+	}
+	ctx, cancel := withBudget(ctx, s.budget(opts))
+	defer cancel()
+
+	start := time.Now()
+	moderations, blocked, err := s.moderateQuestion(ctx, p.Username, req.Question)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return s.metered(ctx, p.Username, req, &models.QueryResponse{
+			Answer:     models.ModeratedAnswer,
+			Sources:    []models.SourceDocument{},
+			Filters:    req.Filters,
+			Moderation: moderations,
+		}, &models.QueryUsage{}, start), nil
+	}
+	if s.moderator != nil && s.answerAction == moderation.ActionBlock {
+		// Answers that may be withheld are only sent once they are checked
+		opts.Stream = nil
+	}
+	response, err := s.answer(ctx, p, req, opts, start)
+	if err != nil {
+		return nil, err
+	}
+	return s.moderateAnswer(ctx, p.Username, response, moderations)
+}
+
+// answer implements Query without moderation, timing the query from start
+func (s *Service) answer(ctx context.Context, p permissions.Principal, req *models.QueryRequest, opts QueryOptions, start time.Time) (*models.QueryResponse, error) {
+	usage := &models.QueryUsage{}
+	plan, err := s.route(ctx, req, usage)
+	if err != nil {
+		return nil, err
+	}
+	switch plan.Route {
+	case models.QueryRouteAggregate:
+		return s.aggregateQuery(ctx, p, req, plan, usage, start)
+	case models.QueryRouteDirect:
+		return s.directQuery(ctx, p, req, opts, usage, start)
+	}
+
+	docs, hidden, err := s.retrieve(ctx, p, req, RetrievalText(opts.History, req.Question), usage)
+	if err != nil {
+		return nil, err
+	}
+	var searches []string
+	if req.Agent {
+		if docs, searches, err = s.refine(ctx, p, req, docs, usage); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Unanswerable(docs) {
+		return s.metered(ctx, p.Username, req, &models.QueryResponse{
+			Answer:                models.NoAccessibleDocumentsAnswer,
+			Sources:               []models.SourceDocument{},
+			Filters:               req.Filters,
+			NoAccessibleDocuments: true,
+			HiddenResults:         hidden,
+			Searches:              searches,
+			Route:                 plan.Route,
+		}, usage, start), nil
+	}
+
+	var cacheKey string
+	cache := s.queryCache
+	if opts.History != nil {
+		cache = nil
+	}
+	if cache != nil {
+		cacheKey = querycache.Key(tenant.FromContext(ctx), req, docs)
+		if cached, ok := cache.Get(cacheKey); ok && !req.NoCache {
+			cached.Cached = true
+			cached.HiddenResults = hidden
+			return s.metered(ctx, p.Username, req, excerpted(req, s.rehydrated(req, docs, cached)), usage, start), nil
+		}
+	}
+
+	generateStart := time.Now()
+	result, err := s.Generate(ctx, req.Question, docs, llm.Options{History: opts.History, Template: req.Template, Stream: opts.Stream})
+	if err != nil {
+		return nil, err
+	}
+	usage.Timings.GenerateMs = milliseconds(time.Since(generateStart))
+	usage.PromptTokens = result.PromptTokens
+	usage.ResponseTokens = result.ResponseTokens
+
+	sources, included := sourcesFor(docs, result.Included)
+	answer, stripped := citeSources(result.Answer, sources, included)
+	response := &models.QueryResponse{
+		Answer:            answer,
+		Sources:           sources,
+		SourcesIncluded:   included,
+		StrippedCitations: stripped,
+		Filters:           req.Filters,
+		HiddenResults:     hidden,
+		Searches:          searches,
+		Route:             plan.Route,
+	}
+	if cache != nil {
+		cache.Set(cacheKey, response)
+	}
+	return s.metered(ctx, p.Username, req, excerpted(req, s.rehydrated(req, docs, response)), usage, start), nil
+}
+
+// metered completes usage with the total time since start, records it for
+// username, and attaches it to response if req asks for it. Cached responses
+// never carry usage since it is only attached to the returned copy.
+func (s *Service) metered(ctx context.Context, username string, req *models.QueryRequest, response *models.QueryResponse, usage *models.QueryUsage, start time.Time) *models.QueryResponse {
+	usage.Timings.TotalMs = milliseconds(time.Since(start))
+	if s.meter != nil {
+		s.meter.Record(tenant.FromContext(ctx), username, usage, response.Cached)
+	}
+	if req.IncludeUsage {
+		response.Usage = usage
+	}
+	return response
+}
+
+// milliseconds converts d to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Retrieve returns the documents most relevant to searchText that p may
+// access, applying the metadata filters, search mode, score threshold, and
+// reranker from req. With hidden result counts enabled it also returns how
+// many of the top_k matches were withheld by permissions; nil otherwise.
+func (s *Service) Retrieve(ctx context.Context, p permissions.Principal, req *models.QueryRequest, searchText string) ([]models.Document, *int, error) {
+	return s.retrieve(ctx, p, req, searchText, &models.QueryUsage{})
+}
+
+// retrieve implements Retrieve, recording the estimated embedding tokens and
+// the timings of the embed, search, and rerank stages in usage
+func (s *Service) retrieve(ctx context.Context, p permissions.Principal, req *models.QueryRequest, searchText string, usage *models.QueryUsage) ([]models.Document, *int, error) {
+	if len(req.DocumentIDs) > 0 {
+		stageStart := time.Now()
+		docs, err := s.selected(ctx, p, req.DocumentIDs)
+		usage.Timings.SearchMs = milliseconds(time.Since(stageStart))
+		return docs, nil, err
+	}
+
+	store := s.store(ctx)
+	grantSearcher, canGrant := store.(storage.GrantSearcher)
+	canGrant = canGrant && req.SearchMode != models.SearchModeHybrid
+
+	// The user's permissions are listed while the question is embedded, since
+	// neither needs the other; a failed embedding cancels the listing
+	searchCtx, cancel := stageContext(ctx, embedBudgetShare+searchBudgetShare)
+	defer cancel()
+	group, groupCtx := errgroup.WithContext(searchCtx)
+	var questionEmbedding []float32
+	var grants []storage.MetadataGrant
+	usage.EmbeddingTokens = llm.WordPieceTokenizer{}.CountTokens(searchText)
+	group.Go(func() error {
+		stageStart := time.Now()
+		embedCtx, cancel := stageContext(groupCtx, embedBudgetShare)
+		defer cancel()
+		var err error
+		if questionEmbedding, err = s.embedder.GetEmbedding(embedCtx, searchText); err != nil {
+			if timeout := timedOut(embedCtx, OpEmbedQuestion); timeout != nil {
+				return timeout
+			}
+			return &OpError{Op: OpEmbedQuestion, Err: err}
+		}
+		usage.Timings.EmbedMs = milliseconds(time.Since(stageStart))
+		return nil
+	})
+	if warmer, ok := s.permService.(permissions.Warmer); ok {
+		group.Go(func() error {
+			warmer.Warm(groupCtx, p)
+			return nil
+		})
+	}
+	if canGrant {
+		group.Go(func() error {
+			grants = s.metadataGrants(groupCtx, p)
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	// With a reranker, retrieve a larger candidate pool and let it pick the top K
+	searchK := req.TopK
+	if s.reranker != nil {
+		searchK = max(req.TopK, s.candidates)
+	}
+
+	access := s.accessFilter(searchCtx, p)
+	var counter *hiddenCounter
+	if s.reportHidden {
+		counter = &hiddenCounter{topK: req.TopK, minScore: req.MinScore}
+		access = counter.wrap(access)
+	}
+	filter := storage.WithFilters(req.Filters, access)
+	stageStart := time.Now()
+	var relevantDocs []models.Document
+	var err error
+	switch {
+	case req.SearchMode == models.SearchModeHybrid:
+		relevantDocs, err = store.SearchHybridWithBatchFilter(questionEmbedding, searchText, searchK, filter, s.hybrid)
+	case canGrant && len(grants) > 0:
+		relevantDocs, err = grantSearcher.SearchSimilarWithGrants(questionEmbedding, searchK, grants, req.Filters, access)
+	default:
+		relevantDocs, err = store.SearchSimilarWithBatchFilter(questionEmbedding, searchK, filter)
+	}
+	// Permission checks cut off by the budget deny, so the results are incomplete
+	if timeout := timedOut(searchCtx, OpSearch); timeout != nil {
+		return nil, nil, timeout
+	}
+	if err != nil {
+		return nil, nil, &OpError{Op: OpSearch, Err: err}
+	}
+
+	if err := unavailable(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	usage.Timings.SearchMs = milliseconds(time.Since(stageStart))
+
+	// Documents matched by a chunk are answered from that chunk
+	for i := range relevantDocs {
+		if match := relevantDocs[i].Match; match != nil {
+			relevantDocs[i].Content = relevantDocs[i].Content[match.Start:match.End]
+		}
+	}
+
+	var hidden *int
+	if counter != nil {
+		hidden = &counter.hidden
+	}
+	relevantDocs = dropWeakMatches(relevantDocs, req.MinScore)
+	if s.reranker == nil {
+		return relevantDocs, hidden, nil
+	}
+	stageStart = time.Now()
+	reranked := s.rerank(ctx, searchText, relevantDocs, req.TopK)
+	usage.Timings.RerankMs = milliseconds(time.Since(stageStart))
+	return reranked, hidden, nil
+}
+
+// selected returns the documents with ids in order, bypassing the search.
+// Documents the user may not read are reported like missing ones, as
+// invalid document_ids, so their existence is not revealed.
+func (s *Service) selected(ctx context.Context, p permissions.Principal, ids []uuid.UUID) ([]models.Document, error) {
+	store := s.store(ctx)
+	docs := make([]models.Document, 0, len(ids))
+	for _, id := range ids {
+		doc, err := store.GetDocument(id)
+		if errors.Is(err, storage.ErrDocumentNotFound) {
+			return nil, &ValidationError{Field: "document_ids", Err: fmt.Errorf("document %s not found", id)}
+		}
+		if err != nil {
+			return nil, getError(err)
+		}
+		docs = append(docs, *doc)
+	}
+
+	allowed := s.permService.BatchCheck(ctx, p, docs)
+	if err := unavailable(ctx); err != nil {
+		return nil, err
+	}
+	for i, ok := range allowed {
+		if !ok {
+			return nil, &ValidationError{Field: "document_ids", Err: fmt.Errorf("document %s not found", docs[i].ID)}
+		}
+	}
+	return docs, nil
+}
+
+// Unanswerable reports whether a question must be answered with
+// models.NoAccessibleDocumentsAnswer instead of the LLM because retrieval
+// found no document the user may access
+func (s *Service) Unanswerable(docs []models.Document) bool {
+	return s.requireSources && len(docs) == 0
+}
+
+// Generate calls the LLM while tracking the generation so Drain can wait for
+// it. Documents are sanitized and redacted first if configured; the result's
+// Included stays aligned with documents even when flagged ones are left out.
+func (s *Service) Generate(ctx context.Context, question string, documents []models.Document, opts llm.Options) (*llm.Result, error) {
+	s.generations.Add(1)
+	defer s.generations.Done()
+
+	documents, kept := s.promptDocuments(documents)
+	result, err := s.llmClient.GenerateWithOptions(ctx, question, documents, opts)
+	if err != nil {
+		if timeout := timedOut(ctx, OpGenerate); timeout != nil {
+			return nil, timeout
+		}
+		return nil, &OpError{Op: OpGenerate, Err: err}
+	}
+	if kept == nil {
+		return result, nil
+	}
+	included := make([]bool, len(kept))
+	for i, index := range kept {
+		if index >= 0 && index < len(result.Included) {
+			included[i] = result.Included[index]
+		}
+	}
+	result.Included = included
+	return result, nil
+}
+
+// promptDocuments sanitizes and redacts documents before they are put into a
+// prompt if configured. If flagged documents are left out, it also returns
+// the index of each input document in the output or -1; nil otherwise.
+func (s *Service) promptDocuments(documents []models.Document) ([]models.Document, []int) {
+	var kept []int
+	if s.sanitizer != nil {
+		documents, kept = s.guardPrompt(documents)
+	}
+	if s.redactor != nil {
+		documents = s.redactor.Documents(documents)
+	}
+	return documents, kept
+}
+
+// Rehydrate returns response with the redacted values of its answer restored
+// from its sources if redaction is configured
+func (s *Service) Rehydrate(response *models.QueryResponse) *models.QueryResponse {
+	docs := make([]models.Document, len(response.Sources))
+	for i, source := range response.Sources {
+		docs[i] = source.Document
+	}
+	return s.rehydrated(&models.QueryRequest{Rehydrate: true}, docs, response)
+}
+
+// RetrievalText prefixes the question with the previous user turn so follow-ups
+// like "and for 2022?" still retrieve documents about the earlier subject
+func RetrievalText(history []models.Message, question string) string {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == models.RoleUser {
+			return history[i].Content + "\n" + question
+		}
+	}
+	return question
+}
+
+// rehydrated returns response with the redacted values of its answer restored
+// from docs if req asks for it. Cached responses stay redacted.
+func (s *Service) rehydrated(req *models.QueryRequest, docs []models.Document, response *models.QueryResponse) *models.QueryResponse {
+	if !req.Rehydrate || s.redactor == nil {
+		return response
+	}
+	restored := *response
+	restored.Answer = s.redactor.Rehydrate(response.Answer, docs)
+	return &restored
+}
+
+// excerpted returns response with the content of its sources replaced by
+// the passages matching the question if req asks for it. Excerpt offsets are
+// shifted by the chunk offsets so they locate the passage in the ingested
+// source. Cached responses keep their full content.
+func excerpted(req *models.QueryRequest, response *models.QueryResponse) *models.QueryResponse {
+	if !req.Excerpts || len(response.Sources) == 0 {
+		return response
+	}
+	terms := excerpt.Terms(req.Question)
+	shortened := *response
+	shortened.Sources = slices.Clone(response.Sources)
+	for i := range shortened.Sources {
+		source := &shortened.Sources[i]
+		offset := ingest.ChunkStart(&source.Document)
+		if source.Match != nil {
+			offset += source.Match.Start
+		}
+		source.Excerpts = excerpt.Find(source.Content, terms, excerpt.DefaultCount, excerpt.DefaultWidth)
+		for j := range source.Excerpts {
+			source.Excerpts[j].Start += offset
+			source.Excerpts[j].End += offset
+		}
+		source.Content = ""
+	}
+	return &shortened
+}
+
+// hiddenCounter counts the documents permissions withheld from a search. The
+// store evaluates its filter on growing batches of ranked candidates, so the
+// last batch holds the best matches; denied ones among its first topK that
+// pass the score threshold would have been returned to a user allowed to
+// read them. Candidates rejected by metadata filters never reach it.
+type hiddenCounter struct {
+	topK     int
+	minScore float64
+	hidden   int
+}
+
+// wrap returns filter counting its denials into c
+func (c *hiddenCounter) wrap(filter storage.BatchFilter) storage.BatchFilter {
+	return func(docs []models.Document) []bool {
+		allowed := filter(docs)
+		c.hidden = 0
+		for i := range min(c.topK, len(docs)) {
+			if !allowed[i] && (c.minScore <= 0 || storage.Similarity(docs[i].Distance) >= c.minScore) {
+				c.hidden++
+			}
+		}
+		return allowed
+	}
+}
+
+// sourcesFor wraps retrieved documents with their relevance and whether they fit
+// into the prompt, and counts the included ones. Included sources are numbered
+// in prompt order, which is how the answer cites them.
+func sourcesFor(docs []models.Document, included []bool) ([]models.SourceDocument, int) {
+	sources := make([]models.SourceDocument, len(docs))
+	count := 0
+	for i, doc := range docs {
+		sources[i] = models.SourceDocument{
+			Document: doc,
+			Score:    storage.Similarity(doc.Distance),
+			Distance: doc.Distance,
+			Match:    doc.Match,
+			Included: i < len(included) && included[i],
+		}
+		if sources[i].Included {
+			count++
+			sources[i].Citation = count
+		}
+	}
+	return sources, count
+}
+
+// citeSources strips the citations of answer that name no included source,
+// marks the cited sources, and returns the cleaned answer and the number of
+// stripped citations
+func citeSources(answer string, sources []models.SourceDocument, included int) (string, int) {
+	answer, cited, stripped := citation.Validate(answer, included)
+	for i := range sources {
+		sources[i].Cited = sources[i].Citation > 0 && slices.Contains(cited, sources[i].Citation)
+	}
+	return answer, stripped
+}
+
+// guardPrompt sanitizes the documents for the prompt. With excludeFlagged it
+// also drops the flagged ones and returns, for every original document, its
+// index in the returned slice or -1.
+func (s *Service) guardPrompt(docs []models.Document) ([]models.Document, []int) {
+	guarded := make([]models.Document, 0, len(docs))
+	var kept []int
+	if s.excludeFlagged {
+		kept = make([]int, len(docs))
+	}
+	for i, doc := range docs {
+		if s.excludeFlagged && s.sanitizer.Flagged(injection.Risk(&doc)) {
+			kept[i] = -1
+			continue
+		}
+		doc.Content, _ = s.sanitizer.Sanitize(doc.Content)
+		if kept != nil {
+			kept[i] = len(guarded)
+		}
+		guarded = append(guarded, doc)
+	}
+	return guarded, kept
+}
+
+// accessFilter returns a batch filter that checks document access for the given user
+func (s *Service) accessFilter(ctx context.Context, p permissions.Principal) storage.BatchFilter {
+	return func(docs []models.Document) []bool {
+		return s.permService.BatchCheck(ctx, p, docs)
+	}
+}
+
+// metadataGrants returns the attribute grants of the principal as metadata
+// grants, letting the store select the granted documents in its query
+// instead of checking them one by one. It returns nil if the permission
+// service cannot list them.
+func (s *Service) metadataGrants(ctx context.Context, p permissions.Principal) []storage.MetadataGrant {
+	granter, ok := s.permService.(permissions.AttributeGranter)
+	if !ok {
+		return nil
+	}
+	attributeGrants, ok := granter.AttributeGrants(ctx, p)
+	if !ok {
+		return nil
+	}
+	grants := make([]storage.MetadataGrant, len(attributeGrants))
+	for i, grant := range attributeGrants {
+		grants[i] = storage.MetadataGrant{Key: grant.Attribute, Values: grant.Values}
+	}
+	return grants
+}
+
+// dropWeakMatches removes documents whose similarity score is below minScore
+func dropWeakMatches(docs []models.Document, minScore float64) []models.Document {
+	if minScore <= 0 {
+		return docs
+	}
+
+	kept := docs[:0]
+	for _, doc := range docs {
+		if storage.Similarity(doc.Distance) >= minScore {
+			kept = append(kept, doc)
+		}
+	}
+	return kept
+}
+
+// rerank reorders docs with the configured reranker and keeps topK. Reranking is
+// best effort: on failure the retrieval order is kept.
+func (s *Service) rerank(ctx context.Context, question string, docs []models.Document, topK int) []models.Document {
+	if s.reranker == nil {
+		return docs
+	}
+
+	reranked, err := rerank.Rerank(ctx, s.reranker, question, docs, topK)
+	if err != nil {
+		requestid.Logf(ctx, "Reranking failed, using retrieval order: %v", err)
+		return docs[:min(topK, len(docs))]
+	}
+	return reranked
+}
@@ -0,0 +1,47 @@
+package ragservice
+
+import (
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultMaxQuestionLength is the longest question in characters accepted
+// unless configured otherwise
+const DefaultMaxQuestionLength = 4000
+
+// NormalizeQuestion strips control characters other than newlines and tabs,
+// normalizes question to NFC so equal text embeds and caches alike, and trims
+// surrounding whitespace. It rejects questions that end up empty or longer
+// than maxLength characters; maxLength <= 0 allows any length.
+func NormalizeQuestion(question string, maxLength int) (string, error) {
+	question = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, question)
+	question = strings.TrimSpace(norm.NFC.String(question))
+	if question == "" {
+		return "", &ValidationError{Field: "question", Err: fmt.Errorf("question is required")}
+	}
+	if n := utf8.RuneCountInString(question); maxLength > 0 && n > maxLength {
+		return "", &ValidationError{Field: "question", Err: fmt.Errorf("question has %d characters, at most %d are allowed", n, maxLength)}
+	}
+	return question, nil
+}
+
+// ValidateQuestion normalizes req.Question in place with NormalizeQuestion
+// and the configured maximum length
+func (s *Service) ValidateQuestion(req *models.QueryRequest) error {
+	question, err := NormalizeQuestion(req.Question, s.maxQuestionLength)
+	if err != nil {
+		return err
+	}
+	req.Question = question
+	return nil
+}
@@ -0,0 +1,296 @@
+package ragservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/prompt"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"rerag-rbac-rag-llm/internal/storage"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// routeSchema constrains the classification of a question
+var routeSchema = json.RawMessage(`{
+	"type": "object",
+	"required": ["route"],
+	"properties": {
+		"route": {"type": "string", "enum": ["retrieval", "aggregate", "direct"]},
+		"filters": {"type": "object"},
+		"group_by": {"type": "string"}
+	}
+}`)
+
+// aggregatePageSize is the number of documents listed per storage page while
+// aggregating
+const aggregatePageSize = 200
+
+// routePlan is how a question is answered. Filters and GroupBy only apply to
+// the aggregate route.
+type routePlan struct {
+	Route   string                 `json:"route"`
+	Filters map[string]interface{} `json:"filters"`
+	GroupBy string                 `json:"group_by"`
+}
+
+// route decides how req is answered: through req.Route if set, by retrieval
+// if the server does not route questions or req selects documents or agent
+// mode, and by classifying the question otherwise. Without routing the plan's
+// route is empty, so responses do not report one.
+func (s *Service) route(ctx context.Context, req *models.QueryRequest, usage *models.QueryUsage) (routePlan, error) {
+	switch {
+	case s.routeLimit == 0 && req.Route != "":
+		return routePlan{}, &ValidationError{Field: "route", Err: fmt.Errorf("routing is not enabled")}
+	case s.routeLimit == 0:
+		return routePlan{}, nil
+	case req.Route != "":
+		return routePlan{Route: req.Route}, nil
+	case len(req.DocumentIDs) > 0 || req.Agent:
+		return routePlan{Route: models.QueryRouteRetrieval}, nil
+	}
+
+	start := time.Now()
+	plan := s.classify(ctx, req.Question)
+	usage.Timings.RouteMs = milliseconds(time.Since(start))
+	return plan, nil
+}
+
+// classify asks the LLM how to answer question. A failed or invalid
+// classification falls back to retrieval, so routing never fails a question
+// retrieval could answer.
+func (s *Service) classify(ctx context.Context, question string) routePlan {
+	s.generations.Add(1)
+	defer s.generations.Done()
+
+	output, err := s.llmClient.GenerateStructured(ctx, routePrompt(question, s.routeKeys), routeSchema)
+	var plan routePlan
+	if err == nil {
+		err = json.Unmarshal(output, &plan)
+	}
+	if err == nil {
+		err = s.validatePlan(plan)
+	}
+	if _, ok := s.store(ctx).(storage.Aggregator); err == nil && plan.Route == models.QueryRouteAggregate && !ok {
+		err = ErrAggregationUnsupported
+	}
+	if err != nil {
+		requestid.Logf(ctx, "Query routing failed, answering by retrieval: %v", err)
+		return routePlan{Route: models.QueryRouteRetrieval}
+	}
+	return plan
+}
+
+// validatePlan rejects unknown routes and aggregations over metadata keys
+// the server does not offer, which would count the wrong documents
+func (s *Service) validatePlan(plan routePlan) error {
+	switch plan.Route {
+	case models.QueryRouteRetrieval, models.QueryRouteDirect:
+		return nil
+	case models.QueryRouteAggregate:
+	default:
+		return fmt.Errorf("unknown route %q", plan.Route)
+	}
+	for key := range plan.Filters {
+		if !slices.Contains(s.routeKeys, key) {
+			return fmt.Errorf("unknown metadata key %q in filters", key)
+		}
+	}
+	if plan.GroupBy != "" && !slices.Contains(s.routeKeys, plan.GroupBy) {
+		return fmt.Errorf("unknown metadata key %q in group_by", plan.GroupBy)
+	}
+	return storage.ValidateFilters(plan.filters())
+}
+
+// filters returns the plan's filters as equality conditions
+func (p routePlan) filters() map[string]models.MetadataFilter {
+	filters := make(map[string]models.MetadataFilter, len(p.Filters))
+	for key, value := range p.Filters {
+		filters[key] = models.MetadataFilter{Eq: value}
+	}
+	return filters
+}
+
+// routePrompt asks the model to classify question, offering keys for the
+// filters and grouping of aggregate questions
+func routePrompt(question string, keys []string) string {
+	var b strings.Builder
+	b.WriteString("You route messages sent to an assistant that answers questions from a user's documents. Classify the message:\n")
+	b.WriteString("- \"retrieval\": questions answered by reading the content of documents, e.g. \"What was John Doe's refund in 2023?\"\n")
+	b.WriteString("- \"aggregate\": questions about how many documents there are, e.g. \"How many 1120 filings do I have?\". Set \"filters\" to the metadata values the counted documents must have and \"group_by\" to a metadata key if the question asks for a count per value of it.\n")
+	b.WriteString("- \"direct\": greetings, thanks, and questions about the assistant itself that need no documents, e.g. \"Hello!\"\n\n")
+	if len(keys) > 0 {
+		fmt.Fprintf(&b, "Metadata keys: %s\n", strings.Join(keys, ", "))
+	} else {
+		b.WriteString("Metadata keys: none, so aggregate questions take no filters or group_by\n")
+	}
+	fmt.Fprintf(&b, "Message: %s\n\n", question)
+	b.WriteString(`Answer with a single JSON object, for example {"route": "aggregate", "filters": {"type": "1120"}, "group_by": "year"} or {"route": "retrieval"}.`)
+	return b.String()
+}
+
+// aggregateQuery answers with the number of accessible documents matching
+// req's filters and those of plan, which req's override. The LLM is not
+// called, so the counts are exact.
+func (s *Service) aggregateQuery(ctx context.Context, p permissions.Principal, req *models.QueryRequest, plan routePlan, usage *models.QueryUsage, start time.Time) (*models.QueryResponse, error) {
+	filters := plan.filters()
+	maps.Copy(filters, req.Filters)
+
+	stageStart := time.Now()
+	aggregation, err := s.aggregate(ctx, p, filters, plan.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+	usage.Timings.SearchMs = milliseconds(time.Since(stageStart))
+
+	if len(filters) == 0 {
+		filters = nil
+	}
+	return s.metered(ctx, p.Username, req, &models.QueryResponse{
+		Answer:      aggregationAnswer(aggregation, filters),
+		Sources:     []models.SourceDocument{},
+		Filters:     filters,
+		Route:       models.QueryRouteAggregate,
+		Aggregation: aggregation,
+	}, usage, start), nil
+}
+
+// aggregate counts the documents matching filters that p may access, grouped
+// by the metadata key groupBy if set. At most the server's limit of
+// documents is scanned. Groups are computed by the store like Stats does, so
+// list values count in the group of each element and documents without a
+// value are not grouped.
+func (s *Service) aggregate(ctx context.Context, p permissions.Principal, filters map[string]models.MetadataFilter, groupBy string) (*models.QueryAggregation, error) {
+	store := s.store(ctx)
+	aggregator, ok := store.(storage.Aggregator)
+	if !ok {
+		return nil, ErrAggregationUnsupported
+	}
+	aggregation := &models.QueryAggregation{GroupBy: groupBy}
+	counted := make(map[string]bool)
+	var ids []uuid.UUID
+
+	opts := storage.ListOptions{Limit: aggregatePageSize}
+	for {
+		page, err := store.ListDocuments(opts)
+		if err != nil {
+			return nil, &OpError{Op: OpList, Err: err}
+		}
+		matching := slices.DeleteFunc(slices.Clone(page), func(doc models.Document) bool { return !storage.MatchesFilters(&doc, filters) })
+		allowed := s.permService.BatchCheck(ctx, p, matching)
+		for i, doc := range matching {
+			key := sourceKey(&doc)
+			if !allowed[i] || counted[key] {
+				continue
+			}
+			counted[key] = true
+			aggregation.Count++
+			ids = append(ids, doc.ID)
+		}
+
+		opts.Offset += len(page)
+		if len(page) < opts.Limit {
+			break
+		}
+		if opts.Offset >= s.routeLimit {
+			aggregation.Truncated = true
+			break
+		}
+	}
+	if err := unavailable(ctx); err != nil {
+		return nil, err
+	}
+
+	if groupBy == "" || len(ids) == 0 {
+		return aggregation, nil
+	}
+	groups, err := aggregator.Aggregate(storage.AggregateOptions{IDs: ids, GroupBy: groupBy})
+	if err != nil {
+		return nil, &OpError{Op: OpAggregate, Err: err}
+	}
+	for _, group := range groups {
+		if group.Value != nil {
+			aggregation.Groups = append(aggregation.Groups, models.AggregationGroup{Value: group.Value, Count: group.Documents})
+		}
+	}
+	return aggregation, nil
+}
+
+// sourceKey identifies the source of doc, so the chunks of one source are
+// counted once
+func sourceKey(doc *models.Document) string {
+	if source, ok := doc.Metadata[ingest.MetadataSourceID]; ok {
+		return "source:" + fmt.Sprint(source)
+	}
+	return doc.ID.String()
+}
+
+// aggregationAnswer states the counts of aggregation in a sentence
+func aggregationAnswer(aggregation *models.QueryAggregation, filters map[string]models.MetadataFilter) string {
+	var b strings.Builder
+	noun := "documents"
+	if aggregation.Count == 1 {
+		noun = "document"
+	}
+	fmt.Fprintf(&b, "You can access %d %s", aggregation.Count, noun)
+	if len(filters) > 0 {
+		fmt.Fprintf(&b, " matching %s", describeFilters(filters))
+	}
+	b.WriteString(".")
+	if len(aggregation.Groups) > 0 {
+		counts := make([]string, len(aggregation.Groups))
+		for i, group := range aggregation.Groups {
+			counts[i] = fmt.Sprintf("%v: %d", group.Value, group.Count)
+		}
+		fmt.Fprintf(&b, " By %s: %s.", aggregation.GroupBy, strings.Join(counts, ", "))
+	}
+	if aggregation.Truncated {
+		b.WriteString(" Not every document was counted, so there may be more.")
+	}
+	return b.String()
+}
+
+// describeFilters lists the conditions of filters, e.g. "type = 1120, year >= 2023"
+func describeFilters(filters map[string]models.MetadataFilter) string {
+	var conditions []string
+	for _, key := range slices.Sorted(maps.Keys(filters)) {
+		filter := filters[key]
+		for _, c := range []struct {
+			op    string
+			value interface{}
+		}{{"=", filter.Eq}, {">", filter.Gt}, {">=", filter.Gte}, {"<", filter.Lt}, {"<=", filter.Lte}} {
+			if c.value != nil {
+				conditions = append(conditions, fmt.Sprintf("%s %s %v", key, c.op, c.value))
+			}
+		}
+	}
+	return strings.Join(conditions, ", ")
+}
+
+// directQuery answers req without documents, such as a greeting
+func (s *Service) directQuery(ctx context.Context, p permissions.Principal, req *models.QueryRequest, opts QueryOptions, usage *models.QueryUsage, start time.Time) (*models.QueryResponse, error) {
+	generateStart := time.Now()
+	result, err := s.Generate(ctx, req.Question, nil, llm.Options{History: opts.History, Template: prompt.DirectName, Stream: opts.Stream})
+	if err != nil {
+		return nil, err
+	}
+	usage.Timings.GenerateMs = milliseconds(time.Since(generateStart))
+	usage.PromptTokens = result.PromptTokens
+	usage.ResponseTokens = result.ResponseTokens
+
+	answer, stripped := citeSources(result.Answer, nil, 0)
+	return s.metered(ctx, p.Username, req, &models.QueryResponse{
+		Answer:            answer,
+		Sources:           []models.SourceDocument{},
+		StrippedCitations: stripped,
+		Route:             models.QueryRouteDirect,
+	}, usage, start), nil
+}
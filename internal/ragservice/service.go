@@ -0,0 +1,370 @@
+// Package ragservice implements the document and question answering
+// operations of the RAG system independently of a transport, so the HTTP
+// API and other frontends share one implementation of embedding, permission
+// filtering, retrieval, and generation.
+//
+// Operations take the authenticated user's permissions.Principal and a
+// context carrying the tenant and the permission state of the request.
+// Failures are reported as ErrPermissionDenied, ErrAuthorizationUnavailable,
+// *ValidationError, *quota.ExceededError, storage errors, or an *OpError
+// naming the failed step, or a *TimeoutError naming the step that exceeded
+// the query's time budget.
+package ragservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/injection"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/metering"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/querycache"
+	"rerag-rbac-rag-llm/internal/quota"
+	"rerag-rbac-rag-llm/internal/redact"
+	"rerag-rbac-rag-llm/internal/rerank"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"sync"
+	"time"
+)
+
+// Embedder defines the contract for text embedding services
+type Embedder interface {
+	GetEmbedding(ctx context.Context, text string) ([]float32, error)
+}
+
+// LLM defines the contract for Large Language Model services
+type LLM interface {
+	Generate(ctx context.Context, question string, documents []models.Document) (string, error)
+	// GenerateWithOptions selects the prompt template and includes prior conversation turns
+	GenerateWithOptions(ctx context.Context, question string, documents []models.Document, opts llm.Options) (*llm.Result, error)
+	// GenerateStructured answers a raw prompt with JSON validated against a
+	// JSON Schema
+	GenerateStructured(ctx context.Context, prompt string, schema json.RawMessage) (json.RawMessage, error)
+	// CallTools asks the model which of the tools to call for a raw prompt
+	CallTools(ctx context.Context, prompt string, tools []llm.Tool) ([]llm.ToolCall, error)
+}
+
+// Chunking defaults used unless WithChunking is given
+const (
+	DefaultChunkSize    = 2000
+	DefaultChunkOverlap = 200
+)
+
+// Errors of denied or unauthorizable operations
+var (
+	// ErrPermissionDenied is wrapped by errors of operations the user may not perform
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrAuthorizationUnavailable is returned instead of a denial or an empty
+	// result when Keto could not answer the operation's permission checks
+	ErrAuthorizationUnavailable = errors.New("authorization unavailable")
+	// ErrUsageUnsupported is returned when quotas are enabled but the document
+	// store cannot measure usage
+	ErrUsageUnsupported = errors.New("the document store cannot measure usage for quotas")
+	// ErrAggregationUnsupported is returned when the document store cannot
+	// aggregate metadata
+	ErrAggregationUnsupported = errors.New("the document store cannot aggregate metadata")
+	// ErrTrashUnsupported is returned when the document store does not keep
+	// deleted documents
+	ErrTrashUnsupported = errors.New("the document store does not keep deleted documents")
+)
+
+// Steps of operations reported by OpError
+const (
+	OpEmbed         = "generate embedding"
+	OpEmbedQuestion = "generate question embedding"
+	OpGetDocument   = "get document"
+	OpStoreDocument = "store document"
+	OpUpdate        = "update document"
+	OpDelete        = "delete document"
+	OpList          = "list documents"
+	OpSearch        = "search documents"
+	OpGenerate      = "generate answer"
+	OpIngest        = "ingest file"
+	OpMeasureUsage  = "measure usage"
+	OpAggregate     = "aggregate documents"
+	OpModerate      = "moderate content"
+	OpListTrash     = "list deleted documents"
+	OpRestore       = "restore document"
+)
+
+// OpError reports the step of an operation that failed
+type OpError struct {
+	Op  string // one of the Op constants
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return "failed to " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError reports an invalid request field
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", e.Field, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// denied returns the error of a failed authorization check: wrapping
+// ErrPermissionDenied, or ErrAuthorizationUnavailable if the check failed
+// because Keto was unavailable
+func denied(ctx context.Context, format string, args ...interface{}) error {
+	if permissions.Unavailable(ctx) {
+		return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), ErrAuthorizationUnavailable)
+	}
+	return fmt.Errorf("%s: %w", fmt.Sprintf(format, args...), ErrPermissionDenied)
+}
+
+// Service ingests, lists, and queries documents on behalf of users
+type Service struct {
+	embedder    Embedder
+	vectorStore storage.VectorStore
+	llmClient   LLM
+	permService permissions.PermissionChecker
+	hybrid      storage.HybridOptions
+	reranker    rerank.Reranker // optional
+	candidates  int             // documents retrieved for reranking
+	queryCache  *querycache.Cache
+	ingest      *ingest.Pipeline  // chunks and embeds sources
+	notifier    webhooks.Notifier // optional
+	quotas      *quota.Enforcer   // optional
+	redactor    *redact.Redactor  // optional
+	// chunkVectors stores documents with a vector per chunk and answers from
+	// their best matching chunk
+	chunkVectors bool
+	// sanitizer strips injection attempts on ingest and scores retrieved documents
+	sanitizer      *injection.Sanitizer
+	excludeFlagged bool // keep flagged documents out of prompts
+	// moderator checks questions and answers with the actions below; an
+	// empty action leaves that side unchecked
+	moderator      moderation.Moderator
+	questionAction moderation.Action
+	answerAction   moderation.Action
+	// requireSources answers without the LLM when no accessible document matches
+	requireSources bool
+	// reportHidden counts the matches withheld by permissions in query responses
+	reportHidden bool
+	// agentSearches bounds the follow-up searches of agent queries; 0
+	// disables agent mode
+	agentSearches int
+	// routeKeys are the metadata keys aggregate questions may filter and
+	// group by; routing is disabled unless routeLimit is positive
+	routeKeys []string
+	// routeLimit bounds the documents scanned per aggregate question
+	routeLimit int
+	// maxQuestionLength bounds questions in characters
+	maxQuestionLength int
+	// queryTimeout bounds the time of a query; 0 leaves it unbounded
+	queryTimeout time.Duration
+	meter        *metering.Meter // optional
+	generations  sync.WaitGroup  // in-flight LLM generations
+}
+
+// Option configures optional Service behavior
+type Option func(*Service)
+
+// WithHybridSearch sets the rank fusion weights used for hybrid queries
+func WithHybridSearch(opts storage.HybridOptions) Option {
+	return func(s *Service) {
+		s.hybrid = opts
+	}
+}
+
+// WithReranker rescores the best candidates documents of each query with r
+// and passes the top_k highest-scored ones to the LLM
+func WithReranker(r rerank.Reranker, candidates int) Option {
+	return func(s *Service) {
+		s.reranker = r
+		s.candidates = candidates
+	}
+}
+
+// WithQueryCache serves repeated queries over the same permitted documents
+// from cache instead of the LLM
+func WithQueryCache(cache *querycache.Cache) Option {
+	return func(s *Service) {
+		s.queryCache = cache
+	}
+}
+
+// WithChunking sets how sources are split into chunks of chunkSize bytes
+// overlapping by chunkOverlap bytes
+func WithChunking(chunkSize, chunkOverlap int) Option {
+	return func(s *Service) {
+		s.ingest = ingest.NewPipeline(s.embedder, chunkSize, chunkOverlap)
+		s.ingest.SetSanitizer(s.sanitizer)
+		s.ingest.SetChunkVectors(s.chunkVectors)
+	}
+}
+
+// WithChunkVectors stores every document and source as a single document
+// with a vector per chunk instead of one document per chunk. Searches match
+// documents by their chunks and prompt with the best matching chunk.
+func WithChunkVectors() Option {
+	return func(s *Service) {
+		s.chunkVectors = true
+		s.ingest.SetChunkVectors(true)
+	}
+}
+
+// WithNotifier publishes document changes to n
+func WithNotifier(n webhooks.Notifier) Option {
+	return func(s *Service) {
+		s.notifier = n
+	}
+}
+
+// WithQuotas rejects ingestion that would exceed the document or content
+// limits of the user or tenant
+func WithQuotas(e *quota.Enforcer) Option {
+	return func(s *Service) {
+		s.quotas = e
+	}
+}
+
+// WithRedaction replaces sensitive values in documents with placeholders
+// before they are put into prompts
+func WithRedaction(r *redact.Redactor) Option {
+	return func(s *Service) {
+		s.redactor = r
+	}
+}
+
+// WithInjectionGuard strips prompt injection attempts from ingested documents
+// with sanitizer and records their risk score. Retrieved documents are
+// sanitized again before prompting; with excludeFlagged, flagged documents are
+// left out of the prompt and reported as not included.
+func WithInjectionGuard(sanitizer *injection.Sanitizer, excludeFlagged bool) Option {
+	return func(s *Service) {
+		s.sanitizer = sanitizer
+		s.excludeFlagged = excludeFlagged
+		s.ingest.SetSanitizer(sanitizer)
+	}
+}
+
+// WithRequireSources answers questions that match none of the user's
+// accessible documents with models.NoAccessibleDocumentsAnswer instead of
+// calling the LLM with an empty context
+func WithRequireSources() Option {
+	return func(s *Service) {
+		s.requireSources = true
+	}
+}
+
+// WithHiddenResultCounts reports in query responses how many of the top_k
+// matches were withheld by permissions
+func WithHiddenResultCounts() Option {
+	return func(s *Service) {
+		s.reportHidden = true
+	}
+}
+
+// WithAgent enables agent queries, in which the LLM may issue up to
+// maxSearches follow-up searches before answering
+func WithAgent(maxSearches int) Option {
+	return func(s *Service) {
+		s.agentSearches = maxSearches
+	}
+}
+
+// WithRouting classifies questions before answering them: document questions
+// are answered by retrieval, counts of documents by aggregating the metadata
+// keys of up to maxDocuments accessible documents, and small talk without
+// documents
+func WithRouting(metadataKeys []string, maxDocuments int) Option {
+	return func(s *Service) {
+		s.routeKeys = metadataKeys
+		s.routeLimit = maxDocuments
+	}
+}
+
+// WithMaxQuestionLength rejects questions longer than n characters
+func WithMaxQuestionLength(n int) Option {
+	return func(s *Service) {
+		s.maxQuestionLength = n
+	}
+}
+
+// WithMeter records the tokens and stage timings of every answered query in m
+func WithMeter(m *metering.Meter) Option {
+	return func(s *Service) {
+		s.meter = m
+	}
+}
+
+// New creates a service over the provided dependencies
+func New(embedder Embedder, vectorStore storage.VectorStore, llmClient LLM, permService permissions.PermissionChecker, opts ...Option) *Service {
+	s := &Service{
+		embedder:    embedder,
+		vectorStore: vectorStore,
+		llmClient:   llmClient,
+		permService: permService,
+		hybrid:      storage.DefaultHybridOptions,
+		ingest:      ingest.NewPipeline(embedder, DefaultChunkSize, DefaultChunkOverlap),
+
+		maxQuestionLength: DefaultMaxQuestionLength,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Drain waits for in-flight LLM generations or until ctx is done
+func (s *Service) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.generations.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for in-flight LLM generations: %w", ctx.Err())
+	}
+}
+
+// store returns the vector store of the request's tenant
+func (s *Service) store(ctx context.Context) storage.VectorStore {
+	return s.vectorStore.ForTenant(tenant.FromContext(ctx))
+}
+
+// unavailable returns ErrAuthorizationUnavailable if a permission check of
+// ctx failed because Keto was unavailable
+func unavailable(ctx context.Context) error {
+	if permissions.Unavailable(ctx) {
+		return ErrAuthorizationUnavailable
+	}
+	return nil
+}
+
+// notifyDocument publishes a document event without the content and embedding
+func (s *Service) notifyDocument(ctx context.Context, eventType webhooks.EventType, doc *models.Document) {
+	if s.notifier == nil {
+		return
+	}
+	s.notifier.Notify(ctx, eventType, webhooks.DocumentData{
+		ID:       doc.ID,
+		Title:    doc.Title,
+		Metadata: doc.Metadata,
+	})
+}
@@ -0,0 +1,815 @@
+package ragservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/blob"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/prompt"
+	"rerag-rbac-rag-llm/internal/querycache"
+	"rerag-rbac-rag-llm/internal/quota"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// fakeEmbedder embeds every text as the same vector unless vectors names it
+type fakeEmbedder struct {
+	err     error
+	vectors map[string][]float32
+	stall   bool // wait until the context is done
+}
+
+func (f *fakeEmbedder) GetEmbedding(ctx context.Context, text string) ([]float32, error) {
+	if f.stall {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if vector, ok := f.vectors[text]; ok {
+		return vector, f.err
+	}
+	return []float32{0.1, 0.2, 0.3}, f.err
+}
+
+// fakeLLM answers with the titles of the documents in its prompt
+type fakeLLM struct {
+	calls    int
+	prompted []models.Document
+	history  []models.Message
+	// toolCalls are returned by successive CallTools calls, whose prompts
+	// are recorded in toolPrompts
+	toolCalls   [][]llm.ToolCall
+	toolPrompts []string
+	// structured is returned by GenerateStructured, {} if unset
+	structured json.RawMessage
+	templates  []string
+	stall      bool // wait until the context is done before generating
+}
+
+func (f *fakeLLM) Generate(ctx context.Context, question string, documents []models.Document) (string, error) {
+	result, err := f.GenerateWithOptions(ctx, question, documents, llm.Options{})
+	return result.Answer, err
+}
+
+func (f *fakeLLM) GenerateWithOptions(ctx context.Context, _ string, documents []models.Document, opts llm.Options) (*llm.Result, error) {
+	if f.stall {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	f.calls++
+	f.prompted = documents
+	f.history = opts.History
+	f.templates = append(f.templates, opts.Template)
+	result := &llm.Result{Included: make([]bool, len(documents))}
+	for i, doc := range documents {
+		result.Answer += fmt.Sprintf("%s [%d] ", doc.Title, i+1)
+		result.Included[i] = true
+	}
+	if opts.Stream != nil {
+		opts.Stream(result.Answer)
+	}
+	return result, nil
+}
+
+func (f *fakeLLM) GenerateStructured(context.Context, string, json.RawMessage) (json.RawMessage, error) {
+	if f.structured != nil {
+		return f.structured, nil
+	}
+	return json.RawMessage(`{}`), nil
+}
+
+func (f *fakeLLM) CallTools(_ context.Context, prompt string, _ []llm.Tool) ([]llm.ToolCall, error) {
+	f.toolPrompts = append(f.toolPrompts, prompt)
+	if len(f.toolCalls) == 0 {
+		return nil, nil
+	}
+	calls := f.toolCalls[0]
+	f.toolCalls = f.toolCalls[1:]
+	return calls, nil
+}
+
+// fakePermissions lets writers write everything and everyone read documents
+// titled "public" or created by themselves
+type fakePermissions struct {
+	writers []string
+}
+
+func (f *fakePermissions) CanAccessDocument(_ context.Context, p permissions.Principal, doc *models.Document) bool {
+	return doc.Title == "public" || doc.Metadata[quota.MetadataCreatedBy] == p.Username
+}
+
+func (f *fakePermissions) BatchCheck(ctx context.Context, p permissions.Principal, docs []models.Document) []bool {
+	allowed := make([]bool, len(docs))
+	for i := range docs {
+		allowed[i] = f.CanAccessDocument(ctx, p, &docs[i])
+	}
+	return allowed
+}
+
+func (f *fakePermissions) CanEditDocument(ctx context.Context, p permissions.Principal, doc *models.Document) bool {
+	return f.CanWriteDocuments(ctx, p)
+}
+
+func (f *fakePermissions) CanWriteDocuments(_ context.Context, p permissions.Principal) bool {
+	return slices.Contains(f.writers, p.Username)
+}
+
+func (f *fakePermissions) GetUserPermissions(context.Context, permissions.Principal) []string {
+	return nil
+}
+
+// grantingPermissions additionally grants everyone the documents of a
+// taxpayer through an attribute grant
+type grantingPermissions struct {
+	fakePermissions
+	taxpayer string
+}
+
+func (g *grantingPermissions) AttributeGrants(context.Context, permissions.Principal) ([]permissions.AttributeGrant, bool) {
+	return []permissions.AttributeGrant{{Attribute: "taxpayer", Values: []string{g.taxpayer}}}, true
+}
+
+// stallingPermissions waits until the context is done before denying a batch
+type stallingPermissions struct {
+	fakePermissions
+}
+
+func (s *stallingPermissions) BatchCheck(ctx context.Context, _ permissions.Principal, docs []models.Document) []bool {
+	<-ctx.Done()
+	return make([]bool, len(docs))
+}
+
+// warmingPermissions warms while the question is embedded: Warm and
+// GetEmbedding each wait for the other to start, so running them one after
+// the other stalls until the context is done
+type warmingPermissions struct {
+	fakePermissions
+	warming, embedding chan struct{}
+	overlapped         bool
+}
+
+func (w *warmingPermissions) Warm(ctx context.Context, _ permissions.Principal) {
+	close(w.warming)
+	select {
+	case <-w.embedding:
+		w.overlapped = true
+	case <-ctx.Done():
+	}
+}
+
+func (w *warmingPermissions) GetEmbedding(ctx context.Context, _ string) ([]float32, error) {
+	close(w.embedding)
+	select {
+	case <-w.warming:
+		return []float32{0.1, 0.2, 0.3}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// recordingNotifier collects published webhook events
+type recordingNotifier struct {
+	events []webhooks.EventType
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, eventType webhooks.EventType, _ interface{}) {
+	n.events = append(n.events, eventType)
+}
+
+func newTestService(t *testing.T, opts ...Option) (*Service, *storage.InMemoryVectorStore, *fakeLLM) {
+	t.Helper()
+	store, err := storage.NewInMemoryVectorStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := &fakeLLM{}
+	return New(&fakeEmbedder{}, store, generator, &fakePermissions{writers: []string{"admin", "alice"}}, opts...), store, generator
+}
+
+func TestIngestAttributesAndNotifies(t *testing.T) {
+	notifier := &recordingNotifier{}
+	service, store, _ := newTestService(t, WithNotifier(notifier))
+	ctx := context.Background()
+
+	if err := service.Ingest(ctx, permissions.Principal{Username: "bob"}, &models.Document{Title: "Return", Content: "Refund"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("Expected a reader to be denied, got %v", err)
+	}
+
+	doc := &models.Document{Title: "Return", Content: "Refund", Metadata: map[string]interface{}{
+		quota.MetadataCreatedBy: "bob",
+		blob.MetadataDigest:     blob.Digest([]byte("someone else's file")),
+	}}
+	if err := service.Ingest(ctx, permissions.Principal{Username: "alice"}, doc); err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	stored, err := store.GetDocument(doc.ID)
+	if err != nil {
+		t.Fatalf("Expected the document to be stored: %v", err)
+	}
+	if _, ok := stored.Metadata[blob.MetadataDigest]; ok {
+		t.Errorf("Expected clients not to set the original file, got %v", stored.Metadata)
+	}
+	if stored.Metadata[quota.MetadataCreatedBy] != "alice" || len(doc.Embedding) == 0 {
+		t.Errorf("Expected an embedded document created by alice, got %v", stored.Metadata)
+	}
+	if !slices.Equal(notifier.events, []webhooks.EventType{webhooks.DocumentCreated}) {
+		t.Errorf("Expected a created event, got %v", notifier.events)
+	}
+
+	failing := New(&fakeEmbedder{err: errors.New("ollama down")}, store, &fakeLLM{}, &fakePermissions{writers: []string{"alice"}})
+	var op *OpError
+	if err := failing.Ingest(ctx, permissions.Principal{Username: "alice"}, &models.Document{Title: "New"}); !errors.As(err, &op) || op.Op != OpEmbed {
+		t.Errorf("Expected an embedding failure, got %v", err)
+	}
+}
+
+func TestIngestEnforcesQuotas(t *testing.T) {
+	service, _, _ := newTestService(t, WithQuotas(quota.NewEnforcer(quota.Limits{}, quota.Limits{MaxDocuments: 1})))
+	ctx := context.Background()
+
+	if err := service.Ingest(ctx, permissions.Principal{Username: "alice"}, &models.Document{Title: "One", Content: "1"}); err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+	var exceeded *quota.ExceededError
+	if err := service.Ingest(ctx, permissions.Principal{Username: "alice"}, &models.Document{Title: "Two", Content: "2"}); !errors.As(err, &exceeded) || exceeded.Scope != quota.ScopeUser {
+		t.Errorf("Expected the user quota to be exceeded, got %v", err)
+	}
+	if docs, err := service.IngestSource(ctx, permissions.Principal{Username: "admin"}, ingest.Source{Title: "Three", Text: "3"}); err != nil || len(docs) != 1 {
+		t.Errorf("Expected another user to have room, got %d documents: %v", len(docs), err)
+	}
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	notifier := &recordingNotifier{}
+	service, store, _ := newTestService(t, WithNotifier(notifier))
+	ctx := context.Background()
+	original := blob.Digest([]byte("return.pdf"))
+	doc := &models.Document{Title: "Return", Content: "Refund", Metadata: map[string]interface{}{quota.MetadataCreatedBy: "bob", blob.MetadataDigest: original}}
+	_ = store.AddDocument(doc)
+
+	if _, err := service.Update(ctx, permissions.Principal{Username: "alice"}, &models.Document{ID: uuid.New(), Content: "x"}); !errors.Is(err, storage.ErrDocumentNotFound) {
+		t.Errorf("Expected a missing document, got %v", err)
+	}
+	if _, err := service.Update(ctx, permissions.Principal{Username: "bob"}, &models.Document{ID: doc.ID, Content: "x"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected a reader to be denied, got %v", err)
+	}
+
+	reembedded, err := service.Update(ctx, permissions.Principal{Username: "alice"}, &models.Document{ID: doc.ID, Title: "Return", Content: "Refund"})
+	if err != nil || reembedded {
+		t.Errorf("Expected unchanged content to keep its embedding, got %v, %v", reembedded, err)
+	}
+	updated := &models.Document{ID: doc.ID, Title: "Return", Content: "Refund of $1,200", Metadata: map[string]interface{}{blob.MetadataDigest: blob.Digest([]byte("other.pdf"))}}
+	if reembedded, err := service.Update(ctx, permissions.Principal{Username: "alice"}, updated); err != nil || !reembedded {
+		t.Errorf("Expected changed content to be embedded again, got %v, %v", reembedded, err)
+	}
+	if updated.Metadata[quota.MetadataCreatedBy] != "bob" || updated.Metadata[blob.MetadataDigest] != original {
+		t.Errorf("Expected updates to keep the attribution and original file, got %v", updated.Metadata)
+	}
+
+	if trashed, err := service.Delete(ctx, permissions.Principal{Username: "alice"}, doc.ID); err != nil || !trashed {
+		t.Errorf("Expected the document to be moved to the trash, got %v, %v", trashed, err)
+	}
+	if _, err := store.GetDocument(doc.ID); !errors.Is(err, storage.ErrDocumentNotFound) {
+		t.Errorf("Expected the trashed document to be hidden, got %v", err)
+	}
+	want := []webhooks.EventType{webhooks.DocumentUpdated, webhooks.DocumentUpdated, webhooks.DocumentDeleted}
+	if !slices.Equal(notifier.events, want) {
+		t.Errorf("Expected events %v, got %v", want, notifier.events)
+	}
+}
+
+func TestListSkipsInaccessibleDocuments(t *testing.T) {
+	service, store, _ := newTestService(t)
+	for i := range 5 {
+		title := "private"
+		if i%2 == 0 {
+			title = "public"
+		}
+		_ = store.AddDocument(&models.Document{Title: title, Content: fmt.Sprint(i)})
+	}
+
+	opts := storage.ListOptions{Limit: 2, SortBy: storage.SortByTitle}
+	docs, next, err := service.List(context.Background(), permissions.Principal{Username: "bob"}, opts)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(docs) != 2 || docs[0].Title != "public" || docs[1].Title != "public" || next == nil {
+		t.Fatalf("Expected two public documents and a next page, got %d documents, next %v", len(docs), next)
+	}
+
+	opts.Offset = *next
+	docs, next, err = service.List(context.Background(), permissions.Principal{Username: "bob"}, opts)
+	if err != nil || len(docs) != 1 || next != nil {
+		t.Errorf("Expected the last public document without a next page, got %d documents, next %v: %v", len(docs), next, err)
+	}
+}
+
+func TestQueryAnswersFromAccessibleDocuments(t *testing.T) {
+	service, store, generator := newTestService(t, WithRequireSources())
+	ctx := context.Background()
+	_ = store.AddDocument(&models.Document{Title: "public", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}})
+	_ = store.AddDocument(&models.Document{Title: "secret", Content: "Salary", Embedding: []float32{0.1, 0.2, 0.3}})
+
+	req := &models.QueryRequest{Question: "Refund?"}
+	if err := ValidateQuery(req); err != nil {
+		t.Fatalf("ValidateQuery failed: %v", err)
+	}
+	response, err := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(response.Sources) != 1 || response.Sources[0].Title != "public" || !response.Sources[0].Cited {
+		t.Errorf("Expected only the cited public source, got %+v", response.Sources)
+	}
+	if len(generator.prompted) != 1 || generator.prompted[0].Title != "public" {
+		t.Errorf("Expected only the public document in the prompt, got %v", generator.prompted)
+	}
+
+	// Without any accessible document the LLM is not called
+	_ = store.DeleteDocument(response.Sources[0].ID)
+	calls := generator.calls
+	response, err = service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{})
+	if err != nil || !response.NoAccessibleDocuments || response.Answer != models.NoAccessibleDocumentsAnswer {
+		t.Errorf("Expected no accessible documents, got %+v: %v", response, err)
+	}
+	if generator.calls != calls {
+		t.Error("Expected the LLM not to be called without sources")
+	}
+}
+
+func TestRetrieveSelectsGrantedDocuments(t *testing.T) {
+	store, err := storage.NewInMemoryVectorStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := New(&fakeEmbedder{}, store, &fakeLLM{}, &grantingPermissions{taxpayer: "John Doe"})
+	_ = store.AddDocument(&models.Document{Title: "public", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}})
+	_ = store.AddDocument(&models.Document{Title: "Doe 1040", Content: "Refund", Metadata: map[string]interface{}{"taxpayer": "John Doe"}, Embedding: []float32{0.1, 0.2, 0.3}})
+	_ = store.AddDocument(&models.Document{Title: "Roe 1040", Content: "Refund", Metadata: map[string]interface{}{"taxpayer": "Jane Roe"}, Embedding: []float32{0.1, 0.2, 0.3}})
+
+	docs, _, err := service.Retrieve(context.Background(), permissions.Principal{Username: "bob"}, &models.QueryRequest{Question: "Refund?", TopK: 5}, "Refund?")
+	if err != nil {
+		t.Fatalf("Retrieve failed: %v", err)
+	}
+	titles := make([]string, len(docs))
+	for i, doc := range docs {
+		titles[i] = doc.Title
+	}
+	slices.Sort(titles)
+	if !slices.Equal(titles, []string{"Doe 1040", "public"}) {
+		t.Errorf("Expected the public and the granted document, got %v", titles)
+	}
+}
+
+func TestRetrieveWarmsPermissionsWhileEmbedding(t *testing.T) {
+	store, err := storage.NewInMemoryVectorStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	warmer := &warmingPermissions{warming: make(chan struct{}), embedding: make(chan struct{})}
+	service := New(warmer, store, &fakeLLM{}, warmer)
+	_ = store.AddDocument(&models.Document{Title: "public", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	docs, _, err := service.Retrieve(ctx, permissions.Principal{Username: "bob"}, &models.QueryRequest{Question: "Refund?", TopK: 3}, "Refund?")
+	if err != nil || len(docs) != 1 {
+		t.Fatalf("Expected the public document, got %+v (%v)", docs, err)
+	}
+	if !warmer.overlapped {
+		t.Error("Expected the permissions to be warmed while the question was embedded")
+	}
+}
+
+func TestQueryTimeoutNamesStage(t *testing.T) {
+	tests := []struct {
+		name        string
+		embedder    *fakeEmbedder
+		permissions permissions.PermissionChecker
+		llm         *fakeLLM
+		op          string
+	}{
+		{"embedding", &fakeEmbedder{stall: true}, &fakePermissions{}, &fakeLLM{}, OpEmbedQuestion},
+		{"search", &fakeEmbedder{}, &stallingPermissions{}, &fakeLLM{}, OpSearch},
+		{"generation", &fakeEmbedder{}, &fakePermissions{}, &fakeLLM{stall: true}, OpGenerate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := storage.NewInMemoryVectorStore("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = store.AddDocument(&models.Document{Title: "public", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}})
+			service := New(tt.embedder, store, tt.llm, tt.permissions, WithQueryTimeout(time.Minute))
+
+			// The request's budget shortens the service's
+			start := time.Now()
+			_, err = service.Query(context.Background(), permissions.Principal{Username: "bob"}, &models.QueryRequest{Question: "Refund?", TopK: 3}, QueryOptions{Timeout: 50 * time.Millisecond})
+			var timeout *TimeoutError
+			if !errors.As(err, &timeout) || timeout.Op != tt.op || timeout.Budget != 50*time.Millisecond {
+				t.Fatalf("Expected a timeout while trying to %s, got %v", tt.op, err)
+			}
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Error("Expected the timeout to match context.DeadlineExceeded")
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Expected the query to be cancelled within its budget, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestQueryCachesAnswersWithoutHistory(t *testing.T) {
+	service, store, generator := newTestService(t, WithQueryCache(querycache.New(time.Minute, 10)))
+	ctx := context.Background()
+	_ = store.AddDocument(&models.Document{Title: "public", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}})
+
+	req := &models.QueryRequest{Question: "Refund?"}
+	_ = ValidateQuery(req)
+	var streamed []string
+	for i := range 2 {
+		response, err := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{Stream: func(delta string) { streamed = append(streamed, delta) }})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if response.Cached != (i == 1) {
+			t.Errorf("Query %d: expected cached %v, got %v", i+1, i == 1, response.Cached)
+		}
+	}
+	if generator.calls != 1 || len(streamed) != 1 {
+		t.Errorf("Expected one streamed generation, got %d generations and %d deltas", generator.calls, len(streamed))
+	}
+
+	history := []models.Message{{Role: models.RoleUser, Content: "Refunds in 2023?"}, {Role: models.RoleAssistant, Content: "$1,200"}}
+	if response, err := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{History: history}); err != nil || response.Cached {
+		t.Errorf("Expected answers with history to be generated, got %+v: %v", response, err)
+	}
+	if generator.calls != 2 || len(generator.history) != 2 {
+		t.Errorf("Expected the history in the prompt, got %d generations with %v", generator.calls, generator.history)
+	}
+}
+
+func TestQueryModeration(t *testing.T) {
+	smuggling, _ := moderation.NewRule("smuggling", []string{"smuggle"}, "")
+	leak, _ := moderation.NewRule("leak", []string{"public"}, "")
+	moderator := moderation.NewKeywordModerator([]moderation.Rule{smuggling, leak})
+	ctx := context.Background()
+	bob := permissions.Principal{Username: "bob"}
+
+	// Blocked questions are not answered
+	service, store, generator := newTestService(t, WithModeration(moderator, moderation.ActionBlock, moderation.ActionBlock))
+	_ = store.AddDocument(&models.Document{Title: "public", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}})
+	response, err := service.Query(ctx, bob, &models.QueryRequest{Question: "How do I smuggle cash?", TopK: 3}, QueryOptions{})
+	if err != nil || response.Answer != models.ModeratedAnswer || generator.calls != 0 {
+		t.Fatalf("Expected the question to be blocked without generating, got %+v after %d generations (%v)", response, generator.calls, err)
+	}
+	if len(response.Moderation) != 1 || response.Moderation[0].Stage != models.ModerationStageQuestion || response.Moderation[0].Categories[0] != "smuggling" {
+		t.Errorf("Expected the question's moderation, got %+v", response.Moderation)
+	}
+
+	// Blocked answers are withheld with their sources and never streamed
+	var streamed []string
+	response, err = service.Query(ctx, bob, &models.QueryRequest{Question: "Refund?", TopK: 3}, QueryOptions{Stream: func(delta string) { streamed = append(streamed, delta) }})
+	if err != nil || response.Answer != models.ModeratedAnswer || len(response.Sources) != 0 || len(streamed) != 0 {
+		t.Fatalf("Expected the answer to be withheld, got %+v and deltas %v (%v)", response, streamed, err)
+	}
+	if len(response.Moderation) != 1 || response.Moderation[0].Stage != models.ModerationStageAnswer || response.Moderation[0].Action != string(moderation.ActionBlock) {
+		t.Errorf("Expected the answer's moderation, got %+v", response.Moderation)
+	}
+
+	// Annotated content is answered and reported, flagged content only audited
+	service, store, _ = newTestService(t, WithModeration(moderator, moderation.ActionAnnotate, moderation.ActionFlag))
+	_ = store.AddDocument(&models.Document{Title: "public", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}})
+	response, err = service.Query(ctx, bob, &models.QueryRequest{Question: "Can I smuggle a refund?", TopK: 3}, QueryOptions{})
+	if err != nil || response.Answer == models.ModeratedAnswer || len(response.Sources) != 1 {
+		t.Fatalf("Expected an answer, got %+v (%v)", response, err)
+	}
+	if len(response.Moderation) != 1 || response.Moderation[0].Stage != models.ModerationStageQuestion || response.Moderation[0].Action != string(moderation.ActionAnnotate) {
+		t.Errorf("Expected only the question's annotation, got %+v", response.Moderation)
+	}
+}
+
+func TestQueryExcerptsSources(t *testing.T) {
+	service, store, _ := newTestService(t, WithQueryCache(querycache.New(time.Minute, 10)))
+	ctx := context.Background()
+	content := "Wages were reported. The refund for 2023 was $1,200."
+	_ = store.AddDocument(&models.Document{
+		Title:     "public",
+		Content:   content,
+		Embedding: []float32{0.1, 0.2, 0.3},
+		Metadata:  map[string]interface{}{ingest.MetadataChunkStart: 100},
+	})
+
+	req := &models.QueryRequest{Question: "Refund in 2023?"}
+	_ = ValidateQuery(req)
+	if response, _ := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{}); response.Sources[0].Content != content || response.Sources[0].Excerpts != nil {
+		t.Fatalf("Expected full content without excerpts by default, got %+v", response.Sources[0])
+	}
+
+	req.Excerpts = true
+	response, err := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	source := response.Sources[0]
+	if !response.Cached || source.Content != "" || len(source.Excerpts) != 1 {
+		t.Fatalf("Expected a cached answer with one excerpt instead of the content, got %+v", source)
+	}
+	if e := source.Excerpts[0]; e.Start != 100 || e.End != 100+len(content) || len(e.Highlights) != 2 {
+		t.Errorf("Expected the excerpt located in the source text, got %+v", e)
+	}
+}
+
+func TestQueryAnswersFromBestChunk(t *testing.T) {
+	store, _ := storage.NewInMemoryVectorStore("")
+	embedder := &fakeEmbedder{vectors: map[string][]float32{
+		"Wages were reported.":   {1, 0, 0},
+		"The refund was $1,200.": {0, 1, 0},
+		"Refund?":                {0, 1, 0},
+	}}
+	generator := &fakeLLM{}
+	service := New(embedder, store, generator, &fakePermissions{writers: []string{"alice"}}, WithChunkVectors(), WithChunking(30, 0))
+	ctx := context.Background()
+
+	text := "Wages were reported.\n\nThe refund was $1,200."
+	docs, err := service.IngestSource(ctx, permissions.Principal{Username: "alice"}, ingest.Source{Title: "public", Text: text})
+	if err != nil {
+		t.Fatalf("IngestSource failed: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Content != text || len(docs[0].Chunks) != 2 {
+		t.Fatalf("Expected a single document with two chunks, got %+v", docs)
+	}
+
+	req := &models.QueryRequest{Question: "Refund?", Excerpts: true}
+	_ = ValidateQuery(req)
+	response, err := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	start := strings.Index(text, "The refund")
+	if len(response.Sources) != 1 || response.Sources[0].Match == nil || *response.Sources[0].Match != (models.Span{Start: start, End: len(text)}) {
+		t.Fatalf("Expected the source matched by its second chunk, got %+v", response.Sources)
+	}
+	if len(generator.prompted) != 1 || generator.prompted[0].Content != "The refund was $1,200." {
+		t.Errorf("Expected only the matching chunk in the prompt, got %+v", generator.prompted)
+	}
+	if excerpts := response.Sources[0].Excerpts; len(excerpts) != 1 || excerpts[0].Start != start {
+		t.Errorf("Expected the excerpt located in the document, got %+v", excerpts)
+	}
+}
+
+func TestQueryAboutSpecificDocuments(t *testing.T) {
+	service, store, generator := newTestService(t)
+	ctx := context.Background()
+	first := &models.Document{Title: "public", Content: "Refund", Embedding: []float32{0.1, 0.2, 0.3}}
+	second := &models.Document{Title: "public", Content: "Wages", Embedding: []float32{0.3, 0.2, 0.1}}
+	secret := &models.Document{Title: "secret", Content: "Salary", Embedding: []float32{0.1, 0.2, 0.3}}
+	for _, doc := range []*models.Document{first, second, secret} {
+		_ = store.AddDocument(doc)
+	}
+
+	req := &models.QueryRequest{Question: "Refund?", TopK: 1, DocumentIDs: []uuid.UUID{second.ID, first.ID, second.ID}}
+	if err := ValidateQuery(req); err != nil {
+		t.Fatalf("ValidateQuery failed: %v", err)
+	}
+	response, err := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(response.Sources) != 2 || response.Sources[0].ID != second.ID || response.Sources[1].ID != first.ID {
+		t.Errorf("Expected exactly the requested documents in order, got %+v", response.Sources)
+	}
+	if len(generator.prompted) != 2 {
+		t.Errorf("Expected both documents in the prompt, got %v", generator.prompted)
+	}
+
+	// Unreadable and missing documents are reported alike
+	var invalid *ValidationError
+	for _, id := range []uuid.UUID{secret.ID, uuid.New()} {
+		req := &models.QueryRequest{Question: "Salary?", DocumentIDs: []uuid.UUID{first.ID, id}}
+		_ = ValidateQuery(req)
+		if _, err := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{}); !errors.As(err, &invalid) || invalid.Field != "document_ids" {
+			t.Errorf("Expected document %s to be rejected, got %v", id, err)
+		}
+	}
+}
+
+func TestQueryAgentSearchesWithinPermissions(t *testing.T) {
+	service, store, generator := newTestService(t, WithAgent(2))
+	service.embedder = &fakeEmbedder{vectors: map[string][]float32{
+		"Compare the refunds of John and Jane": {1, 0, 0},
+		"Jane refund":                          {0, 1, 0},
+	}}
+	ctx := context.Background()
+	john := &models.Document{Title: "public", Content: "John's refund was $1,200", Embedding: []float32{1, 0, 0}}
+	jane := &models.Document{Title: "public", Content: "Jane's refund was $800", Embedding: []float32{0, 1, 0.1}}
+	secret := &models.Document{Title: "secret", Content: "Jane's salary", Embedding: []float32{0, 1, 0}}
+	for _, doc := range []*models.Document{john, jane, secret} {
+		_ = store.AddDocument(doc)
+	}
+
+	search := func(query string) llm.ToolCall {
+		return llm.ToolCall{Name: "search_documents", Arguments: json.RawMessage(fmt.Sprintf(`{"query": %q}`, query))}
+	}
+	// The repeated search ends the loop before the second allowed search
+	generator.toolCalls = [][]llm.ToolCall{{search("Jane refund")}, {search("Jane refund")}}
+	req := &models.QueryRequest{Question: "Compare the refunds of John and Jane", TopK: 1, Agent: true}
+	if err := ValidateQuery(req); err != nil {
+		t.Fatalf("ValidateQuery failed: %v", err)
+	}
+	response, err := service.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(response.Sources) != 2 || response.Sources[0].ID != john.ID || response.Sources[1].ID != jane.ID {
+		t.Errorf("Expected the initial and the follow-up source, got %+v", response.Sources)
+	}
+	if !slices.Equal(response.Searches, []string{"Jane refund"}) {
+		t.Errorf("Expected the follow-up search to be reported, got %v", response.Searches)
+	}
+	if len(generator.toolPrompts) != 2 || !strings.Contains(generator.toolPrompts[1], "Jane's refund") {
+		t.Fatalf("Expected the found documents in the second planning prompt, got %q", generator.toolPrompts)
+	}
+	for _, prompt := range generator.toolPrompts {
+		if strings.Contains(prompt, "salary") {
+			t.Errorf("Expected the inaccessible document to stay out of the planning prompt, got %q", prompt)
+		}
+	}
+
+	// Agent mode must be enabled and cannot be combined with document_ids
+	disabled, _, _ := newTestService(t)
+	var invalid *ValidationError
+	if _, err := disabled.Query(ctx, permissions.Principal{Username: "bob"}, req, QueryOptions{}); !errors.As(err, &invalid) || invalid.Field != "agent" {
+		t.Errorf("Expected agent mode to be rejected when disabled, got %v", err)
+	}
+	req = &models.QueryRequest{Question: "Q", Agent: true, DocumentIDs: []uuid.UUID{john.ID}}
+	if err := ValidateQuery(req); !errors.As(err, &invalid) || invalid.Field != "document_ids" {
+		t.Errorf("Expected document_ids to be rejected in agent mode, got %v", err)
+	}
+}
+
+func TestQueryRoutesByIntent(t *testing.T) {
+	service, store, generator := newTestService(t, WithRouting([]string{"type", "year"}, 10000))
+	ctx := context.Background()
+	bob := permissions.Principal{Username: "bob"}
+	for _, doc := range []*models.Document{
+		{Title: "public", Content: "2023 return", Metadata: map[string]interface{}{"type": "1120", "year": 2023.0}},
+		{Title: "public", Content: "2022 return", Metadata: map[string]interface{}{"type": "1120", "year": 2022.0}},
+		{Title: "public", Content: "2022 return, part 2", Metadata: map[string]interface{}{"type": "1120", "year": 2022.0, ingest.MetadataSourceID: "s1"}},
+		{Title: "public", Content: "2022 return, part 3", Metadata: map[string]interface{}{"type": "1120", "year": 2022.0, ingest.MetadataSourceID: "s1"}},
+		{Title: "secret", Content: "2021 return", Metadata: map[string]interface{}{"type": "1120", "year": 2021.0}},
+		{Title: "public", Content: "Personal return", Metadata: map[string]interface{}{"type": "1040", "year": 2023.0}},
+	} {
+		_ = store.AddDocument(doc)
+	}
+	query := func(req *models.QueryRequest) *models.QueryResponse {
+		t.Helper()
+		if err := ValidateQuery(req); err != nil {
+			t.Fatalf("ValidateQuery failed: %v", err)
+		}
+		response, err := service.Query(ctx, bob, req, QueryOptions{})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return response
+	}
+
+	// Counts only cover accessible documents, and the chunks of a source once
+	generator.structured = json.RawMessage(`{"route": "aggregate", "filters": {"type": "1120"}, "group_by": "year"}`)
+	response := query(&models.QueryRequest{Question: "How many 1120 filings do I have?"})
+	if response.Route != models.QueryRouteAggregate || response.Aggregation == nil || response.Aggregation.Count != 3 {
+		t.Fatalf("Expected three accessible 1120 filings, got %+v", response)
+	}
+	if groups := response.Aggregation.Groups; len(groups) != 2 || groups[0].Value != 2022.0 || groups[0].Count != 2 {
+		t.Errorf("Expected the counts per year, most frequent first, got %+v", groups)
+	}
+	if want := "You can access 3 documents matching type = 1120. By year: 2022: 2, 2023: 1."; response.Answer != want {
+		t.Errorf("Expected %q, got %q", want, response.Answer)
+	}
+	if generator.calls != 0 {
+		t.Errorf("Expected counts to be answered without generating, got %d generations", generator.calls)
+	}
+
+	generator.structured = json.RawMessage(`{"route": "direct"}`)
+	response = query(&models.QueryRequest{Question: "Hello!"})
+	if response.Route != models.QueryRouteDirect || len(generator.prompted) != 0 || !slices.Equal(generator.templates, []string{prompt.DirectName}) {
+		t.Errorf("Expected a direct answer without documents, got %+v with %v", response, generator.prompted)
+	}
+
+	// Classifications over unknown metadata keys fall back to retrieval
+	generator.structured = json.RawMessage(`{"route": "aggregate", "filters": {"salary": 1}}`)
+	response = query(&models.QueryRequest{Question: "How many salaries are there?"})
+	if response.Route != models.QueryRouteRetrieval || len(response.Sources) == 0 {
+		t.Errorf("Expected retrieval, got %+v", response)
+	}
+
+	// A requested route skips the classification
+	generator.structured = json.RawMessage(`{"route": "direct"}`)
+	response = query(&models.QueryRequest{Question: "Count my 1040s", Route: models.QueryRouteAggregate, Filters: map[string]models.MetadataFilter{"type": {Eq: "1040"}}})
+	if response.Route != models.QueryRouteAggregate || response.Aggregation.Count != 1 {
+		t.Errorf("Expected the requested route, got %+v", response)
+	}
+
+	disabled, _, _ := newTestService(t)
+	var invalid *ValidationError
+	if _, err := disabled.Query(ctx, bob, &models.QueryRequest{Question: "Q", Route: models.QueryRouteDirect}, QueryOptions{}); !errors.As(err, &invalid) || invalid.Field != "route" {
+		t.Errorf("Expected routes to be rejected when routing is disabled, got %v", err)
+	}
+}
+
+func TestAggregationsGroupListValuesAlike(t *testing.T) {
+	service, store, generator := newTestService(t, WithRouting([]string{"tags"}, 10000))
+	ctx := context.Background()
+	bob := permissions.Principal{Username: "bob"}
+	for _, tags := range []interface{}{[]interface{}{"audit", "urgent"}, []interface{}{"audit"}, []interface{}{}, "urgent"} {
+		_ = store.AddDocument(&models.Document{Title: "public", Content: "Return", Metadata: map[string]interface{}{"tags": tags}})
+	}
+
+	generator.structured = json.RawMessage(`{"route": "aggregate", "group_by": "tags"}`)
+	response, err := service.Query(ctx, bob, &models.QueryRequest{Question: "How many documents per tag?"}, QueryOptions{})
+	if err != nil || response.Aggregation == nil || response.Aggregation.Count != 4 {
+		t.Fatalf("Expected four documents, got %+v (%v)", response, err)
+	}
+	want := []models.AggregationGroup{{Value: "audit", Count: 2}, {Value: "urgent", Count: 2}}
+	if !slices.Equal(response.Aggregation.Groups, want) {
+		t.Errorf("Expected each tag counted, got %+v", response.Aggregation.Groups)
+	}
+
+	// Stats groups the same way, reporting untagged documents under nil
+	stats, err := service.Stats(ctx, bob, storage.AggregateOptions{GroupBy: "tags"})
+	if err != nil || len(stats) != 3 || stats[2].Value != nil || stats[2].Documents != 1 {
+		t.Fatalf("Expected the tag groups and the untagged ones, got %+v (%v)", stats, err)
+	}
+	for i, group := range want {
+		if stats[i].Value != group.Value || stats[i].Documents != group.Count {
+			t.Errorf("Expected stats to match the aggregate route, got %+v", stats)
+		}
+	}
+}
+
+func TestValidateQuery(t *testing.T) {
+	for field, req := range map[string]models.QueryRequest{
+		"search_mode":  {Question: "Q", SearchMode: "fuzzy"},
+		"min_score":    {Question: "Q", MinScore: 2},
+		"filters":      {Question: "Q", Filters: map[string]models.MetadataFilter{"year": {}}},
+		"document_ids": {Question: "Q", DocumentIDs: []uuid.UUID{uuid.New()}, Filters: map[string]models.MetadataFilter{"year": {Eq: 2023.0}}},
+		"route":        {Question: "Q", Route: "sql"},
+	} {
+		var invalid *ValidationError
+		if err := ValidateQuery(&req); !errors.As(err, &invalid) || invalid.Field != field {
+			t.Errorf("Expected invalid %s, got %v", field, err)
+		}
+	}
+
+	req := models.QueryRequest{Question: "Q"}
+	if err := ValidateQuery(&req); err != nil || req.TopK != 3 || req.SearchMode != models.SearchModeVector {
+		t.Errorf("Expected defaults to be applied, got %+v: %v", req, err)
+	}
+}
+
+func TestNormalizeQuestion(t *testing.T) {
+	// "e" followed by a combining acute accent is composed to "é"
+	got, err := NormalizeQuestion(" Cafe\u0301 refund\x00\x1b[31m?\r\nAnd 2022?\t", 100)
+	if err != nil || got != "Caf\u00e9 refund[31m?\nAnd 2022?" {
+		t.Errorf("Unexpected normalized question %q: %v", got, err)
+	}
+	if got, err := NormalizeQuestion("Caf\u00e9", 4); err != nil || got != "Caf\u00e9" {
+		t.Errorf("Expected the length to count characters, got %q: %v", got, err)
+	}
+
+	for name, question := range map[string]string{
+		"empty":        "",
+		"whitespace":   " \n\t ",
+		"control only": "\x00\x07",
+		"over the max": strings.Repeat("a", 101),
+	} {
+		var invalid *ValidationError
+		if _, err := NormalizeQuestion(question, 100); !errors.As(err, &invalid) || invalid.Field != "question" {
+			t.Errorf("%s: expected an invalid question, got %v", name, err)
+		}
+	}
+}
+
+func TestRetrievalText(t *testing.T) {
+	history := []models.Message{
+		{Role: models.RoleUser, Content: "John's refund in 2023?"},
+		{Role: models.RoleAssistant, Content: "$1,200"},
+	}
+	if got := RetrievalText(history, "And 2022?"); got != fmt.Sprintf("%s\n%s", "John's refund in 2023?", "And 2022?") {
+		t.Errorf("Unexpected retrieval text: %q", got)
+	}
+	if got := RetrievalText(nil, "Question"); got != "Question" {
+		t.Errorf("Expected question unchanged without history, got %q", got)
+	}
+}
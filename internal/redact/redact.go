@@ -0,0 +1,141 @@
+// Package redact replaces sensitive values such as SSNs and account numbers in
+// document content with placeholders before the content is sent to the LLM,
+// and restores them in answers.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"regexp"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"strings"
+)
+
+// Detector finds one kind of sensitive value. Its label names the placeholders
+// of the values it finds, e.g. [SSN_1a2b3c4d].
+type Detector struct {
+	Label   string
+	Pattern *regexp.Regexp
+}
+
+// builtin are the detectors selectable by name in the configuration
+var builtin = map[string]Detector{
+	"ssn":            {Label: "SSN", Pattern: regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`)},
+	"ein":            {Label: "EIN", Pattern: regexp.MustCompile(`\b\d{2}-\d{7}\b`)},
+	"account_number": {Label: "ACCOUNT", Pattern: regexp.MustCompile(`\b\d{8,17}\b`)},
+	"email":          {Label: "EMAIL", Pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`)},
+}
+
+// Builtin returns the built-in detector with the given name
+func Builtin(name string) (Detector, error) {
+	d, ok := builtin[name]
+	if !ok {
+		return Detector{}, fmt.Errorf("unknown detector %q: use one of %s", name, strings.Join(slices.Sorted(maps.Keys(builtin)), ", "))
+	}
+	return d, nil
+}
+
+// labelPattern restricts labels to names that cannot be confused with other placeholders
+var labelPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{0,31}$`)
+
+// NewDetector compiles a custom detector
+func NewDetector(label, pattern string) (Detector, error) {
+	if !labelPattern.MatchString(label) {
+		return Detector{}, fmt.Errorf("invalid detector label %q: use up to 32 uppercase letters and digits", label)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Detector{}, fmt.Errorf("invalid pattern of detector %s: %w", label, err)
+	}
+	return Detector{Label: label, Pattern: re}, nil
+}
+
+// Redactor replaces the values its detectors find with placeholders. A value
+// gets the same placeholder in every document and request of the process, so
+// conversation history stays consistent, but placeholders cannot be reversed
+// without the documents the values came from.
+type Redactor struct {
+	detectors []Detector
+	key       []byte
+}
+
+// New returns a Redactor applying detectors in order
+func New(detectors ...Detector) *Redactor {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return &Redactor{detectors: detectors, key: key}
+}
+
+// placeholder returns the placeholder of a value found by d
+func (r *Redactor) placeholder(d Detector, value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(d.Label + "\x00" + value))
+	return "[" + d.Label + "_" + hex.EncodeToString(mac.Sum(nil))[:8] + "]"
+}
+
+// redact replaces the values in text and records their placeholders in values if set
+func (r *Redactor) redact(text string, values map[string]string) string {
+	for _, d := range r.detectors {
+		text = d.Pattern.ReplaceAllStringFunc(text, func(value string) string {
+			p := r.placeholder(d, value)
+			if values != nil {
+				values[p] = value
+			}
+			return p
+		})
+	}
+	return text
+}
+
+// Redact replaces the sensitive values in text with placeholders
+func (r *Redactor) Redact(text string) string {
+	return r.redact(text, nil)
+}
+
+// Documents returns copies of docs with the title, content, and string
+// metadata values redacted
+func (r *Redactor) Documents(docs []models.Document) []models.Document {
+	redacted, _ := r.documents(docs)
+	return redacted
+}
+
+func (r *Redactor) documents(docs []models.Document) ([]models.Document, map[string]string) {
+	values := make(map[string]string)
+	redacted := make([]models.Document, len(docs))
+	for i, doc := range docs {
+		doc.Title = r.redact(doc.Title, values)
+		doc.Content = r.redact(doc.Content, values)
+		if len(doc.Metadata) > 0 {
+			metadata := make(map[string]interface{}, len(doc.Metadata))
+			for key, value := range doc.Metadata {
+				if s, ok := value.(string); ok {
+					value = r.redact(s, values)
+				}
+				metadata[key] = value
+			}
+			doc.Metadata = metadata
+		}
+		redacted[i] = doc
+	}
+	return redacted, values
+}
+
+// Rehydrate restores the placeholders in text of values found in docs.
+// Placeholders of values from other documents stay in place, so callers only
+// get back what the documents they may read contain.
+func (r *Redactor) Rehydrate(text string, docs []models.Document) string {
+	_, values := r.documents(docs)
+	if len(values) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(values))
+	for p, value := range values {
+		pairs = append(pairs, p, value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
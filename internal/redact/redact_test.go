@@ -0,0 +1,88 @@
+package redact
+
+import (
+	"regexp"
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"testing"
+)
+
+func newTestRedactor(t *testing.T) *Redactor {
+	t.Helper()
+	var detectors []Detector
+	for _, name := range []string{"ssn", "ein", "account_number", "email"} {
+		d, err := Builtin(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		detectors = append(detectors, d)
+	}
+	custom, err := NewDetector("POLICY", `POL-\d{6}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(append(detectors, custom)...)
+}
+
+var placeholderPattern = regexp.MustCompile(`\[[A-Z0-9]+_[0-9a-f]{8}\]`)
+
+func TestRedactDocuments(t *testing.T) {
+	r := newTestRedactor(t)
+	docs := []models.Document{{
+		Title:    "John Doe 123-45-6789",
+		Content:  "SSN 123-45-6789, EIN 12-3456789, account 000123456789, mail john@example.com, policy POL-123456. Refund $1,200 in 2023.",
+		Metadata: map[string]interface{}{"ssn": "123-45-6789", "year": 2023},
+	}}
+
+	redacted := r.Documents(docs)
+	for _, value := range []string{"123-45-6789", "12-3456789", "000123456789", "john@example.com", "POL-123456"} {
+		if strings.Contains(redacted[0].Title+redacted[0].Content, value) || redacted[0].Metadata["ssn"] == value {
+			t.Errorf("Expected %s to be redacted, got %+v", value, redacted[0])
+		}
+	}
+	if !strings.Contains(redacted[0].Content, "Refund $1,200 in 2023.") {
+		t.Errorf("Expected amounts and years to stay, got %q", redacted[0].Content)
+	}
+	if got := len(placeholderPattern.FindAllString(redacted[0].Content, -1)); got != 5 {
+		t.Errorf("Expected 5 placeholders, got %d in %q", got, redacted[0].Content)
+	}
+	if docs[0].Content == redacted[0].Content || docs[0].Metadata["ssn"] != "123-45-6789" {
+		t.Error("Expected the original documents to be left unchanged")
+	}
+
+	// The same value gets the same placeholder everywhere
+	ssn := placeholderPattern.FindString(redacted[0].Title)
+	if !strings.Contains(redacted[0].Content, ssn) || redacted[0].Metadata["ssn"] != ssn {
+		t.Errorf("Expected placeholder %s in content and metadata, got %+v", ssn, redacted[0])
+	}
+}
+
+func TestRehydrate(t *testing.T) {
+	r := newTestRedactor(t)
+	readable := []models.Document{{Content: "John's SSN is 123-45-6789"}}
+	other := []models.Document{{Content: "Jane's SSN is 987-65-4321"}}
+
+	answer := "John: " + r.Redact("123-45-6789") + ", Jane: " + r.Redact("987-65-4321")
+	got := r.Rehydrate(answer, readable)
+	if !strings.Contains(got, "John: 123-45-6789") {
+		t.Errorf("Expected John's SSN to be restored, got %q", got)
+	}
+	if strings.Contains(got, "987-65-4321") || !strings.Contains(got, r.Redact("987-65-4321")) {
+		t.Errorf("Expected values of unreadable documents to stay redacted, got %q", got)
+	}
+	if got := r.Rehydrate(answer, append(readable, other...)); strings.Contains(got, "[SSN_") {
+		t.Errorf("Expected all placeholders to be restored, got %q", got)
+	}
+}
+
+func TestDetectorErrors(t *testing.T) {
+	if _, err := Builtin("passport"); err == nil {
+		t.Error("Expected an error for an unknown detector")
+	}
+	if _, err := NewDetector("policy", `POL-\d+`); err == nil {
+		t.Error("Expected an error for a lowercase label")
+	}
+	if _, err := NewDetector("POLICY", `POL-(`); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,80 @@
+// Package requestid provides request ID generation and propagation across
+// incoming HTTP requests and outgoing calls to external services.
+package requestid
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header used to carry the request ID
+const Header = "X-Request-ID"
+
+// maxLength bounds the length of client-supplied request IDs
+const maxLength = 128
+
+type contextKey string
+
+// ContextKey is the context key for storing the request ID
+const ContextKey contextKey = "request_id"
+
+// Middleware reuses a valid incoming X-Request-ID header or generates a new one,
+// stores it in the request context, and echoes it in the response headers
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !isValid(id) {
+			id = uuid.NewString()
+			// herodot reads the request ID from the request header when writing errors
+			r.Header.Set(Header, id)
+		}
+
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// NewContext returns a copy of ctx carrying the given request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ContextKey, id)
+}
+
+// FromContext returns the request ID stored in ctx, or an empty string
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ContextKey).(string)
+	return id
+}
+
+// SetHeader forwards the request ID from ctx to an outgoing request as a correlation header
+func SetHeader(ctx context.Context, req *http.Request) {
+	if id := FromContext(ctx); id != "" {
+		req.Header.Set(Header, id)
+	}
+}
+
+// Logf logs a message prefixed with the request ID from ctx, if any
+func Logf(ctx context.Context, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if id := FromContext(ctx); id != "" {
+		log.Printf("[request_id=%s] %s", id, msg)
+		return
+	}
+	log.Print(msg)
+}
+
+// isValid accepts non-empty IDs of bounded length made of printable ASCII characters
+func isValid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"time"
+)
+
+// HTTPReranker calls an external rerank API that follows the request/response
+// shape shared by Cohere, Jina, and compatible self-hosted servers:
+//
+//	POST {"model": "...", "query": "...", "documents": ["..."]}
+//	=> {"results": [{"index": 0, "relevance_score": 0.92}]}
+type HTTPReranker struct {
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+// NewHTTPReranker creates a reranker for the rerank endpoint at url.
+// apiKey is sent as a bearer token when non-empty.
+func NewHTTPReranker(url, model, apiKey string, timeout time.Duration) *HTTPReranker {
+	return &HTTPReranker{
+		url:    url,
+		model:  model,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Score sends all documents in one request and maps the results back to input order
+func (h *HTTPReranker) Score(ctx context.Context, query string, docs []models.Document) ([]float64, error) {
+	texts := make([]string, len(docs))
+	for i := range docs {
+		texts[i] = docs[i].Title + "\n" + docs[i].Content
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"model":     h.model,
+		"query":     query,
+		"documents": texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.apiKey)
+	}
+	requestid.SetHeader(ctx, req)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reranker returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	// Documents missing from the response rank last
+	scores := make([]float64, len(docs))
+	for i := range scores {
+		scores[i] = -1
+	}
+	for _, r := range result.Results {
+		if r.Index < 0 || r.Index >= len(docs) {
+			return nil, fmt.Errorf("reranker returned out-of-range index %d", r.Index)
+		}
+		scores[r.Index] = r.RelevanceScore
+	}
+	return scores, nil
+}
@@ -0,0 +1,131 @@
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/requestid"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxConcurrentScores bounds parallel scoring requests sent to Ollama
+const maxConcurrentScores = 4
+
+// scorePattern extracts the first number from the model's reply
+var scorePattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+// OllamaReranker uses an Ollama model as a cross-encoder: each (query, document)
+// pair is scored by prompting the model for a 0-10 relevance rating
+type OllamaReranker struct {
+	baseURL string
+	model   string
+	client  *http.Client
+}
+
+// NewOllamaReranker creates a reranker backed by an Ollama model
+func NewOllamaReranker(baseURL, model string, timeout time.Duration) *OllamaReranker {
+	return &OllamaReranker{
+		baseURL: baseURL,
+		model:   model,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Score rates every document concurrently and returns scores normalized to [0, 1]
+func (o *OllamaReranker) Score(ctx context.Context, query string, docs []models.Document) ([]float64, error) {
+	scores := make([]float64, len(docs))
+	errs := make([]error, len(docs))
+
+	sem := make(chan struct{}, maxConcurrentScores)
+	var wg sync.WaitGroup
+	for i := range docs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			scores[i], errs[i] = o.score(ctx, query, &docs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to score document %s: %w", docs[i].ID, err)
+		}
+	}
+	return scores, nil
+}
+
+func (o *OllamaReranker) score(ctx context.Context, query string, doc *models.Document) (float64, error) {
+	reqBody := map[string]interface{}{
+		"model":  o.model,
+		"prompt": buildScorePrompt(query, doc),
+		"stream": false,
+		"options": map[string]interface{}{
+			"temperature": 0,
+			"num_predict": 8,
+		},
+		"system": "You rate how relevant a document is to a search query. Reply with a single number from 0 (irrelevant) to 10 (answers the query directly) and nothing else.",
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.baseURL+"/api/generate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	requestid.SetHeader(ctx, req)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("ollama returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var result struct {
+		Response string `json:"response"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, err
+	}
+
+	return parseScore(result.Response)
+}
+
+// parseScore reads a 0-10 rating from the model output and normalizes it to [0, 1]
+func parseScore(response string) (float64, error) {
+	match := scorePattern.FindString(response)
+	if match == "" {
+		return 0, fmt.Errorf("no score in model response %q", response)
+	}
+
+	score, err := strconv.ParseFloat(match, 64)
+	if err != nil {
+		return 0, err
+	}
+	return min(max(score, 0), 10) / 10, nil
+}
+
+func buildScorePrompt(query string, doc *models.Document) string {
+	return fmt.Sprintf("Query: %s\n\nDocument title: %s\nDocument content: %s\n\nRelevance (0-10):", query, doc.Title, doc.Content)
+}
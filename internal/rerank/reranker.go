@@ -0,0 +1,46 @@
+// Package rerank provides rerankers that rescore retrieved documents against the query
+// before they are passed to the LLM.
+package rerank
+
+import (
+	"context"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"sort"
+)
+
+// Reranker scores how relevant each document is to the query
+type Reranker interface {
+	// Score returns one relevance score per document, higher is more relevant
+	Score(ctx context.Context, query string, docs []models.Document) ([]float64, error)
+}
+
+// Rerank orders docs by the reranker's scores and keeps the topN best.
+// Documents with equal scores keep their retrieval order.
+func Rerank(ctx context.Context, r Reranker, query string, docs []models.Document, topN int) ([]models.Document, error) {
+	if len(docs) == 0 {
+		return docs, nil
+	}
+
+	scores, err := r.Score(ctx, query, docs)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) != len(docs) {
+		return nil, fmt.Errorf("reranker returned %d scores for %d documents", len(scores), len(docs))
+	}
+
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return scores[order[a]] > scores[order[b]]
+	})
+
+	reranked := make([]models.Document, 0, min(topN, len(docs)))
+	for _, i := range order[:min(topN, len(order))] {
+		reranked = append(reranked, docs[i])
+	}
+	return reranked, nil
+}
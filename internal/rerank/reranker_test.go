@@ -0,0 +1,98 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func testDocuments(titles ...string) []models.Document {
+	docs := make([]models.Document, len(titles))
+	for i, title := range titles {
+		docs[i] = models.Document{ID: uuid.New(), Title: title, Content: "Content of " + title}
+	}
+	return docs
+}
+
+func titles(docs []models.Document) string {
+	out := make([]string, len(docs))
+	for i, doc := range docs {
+		out[i] = doc.Title
+	}
+	return strings.Join(out, ",")
+}
+
+func TestHTTPReranker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected bearer token, got %q", r.Header.Get("Authorization"))
+		}
+		var req struct {
+			Query     string   `json:"query"`
+			Documents []string `json:"documents"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Query != "form 1120" || len(req.Documents) != 3 {
+			t.Errorf("Unexpected request: %+v", req)
+		}
+		// Results are returned best first and reference the input index; "b" is omitted
+		_, _ = w.Write([]byte(`{"results": [{"index": 2, "relevance_score": 0.9}, {"index": 0, "relevance_score": 0.4}]}`))
+	}))
+	defer server.Close()
+
+	reranker := NewHTTPReranker(server.URL, "rerank-model", "secret", time.Second)
+	reranked, err := Rerank(context.Background(), reranker, "form 1120", testDocuments("a", "b", "c"), 2)
+	if err != nil {
+		t.Fatalf("Rerank failed: %v", err)
+	}
+	if got := titles(reranked); got != "c,a" {
+		t.Errorf("Expected c,a, got %s", got)
+	}
+}
+
+func TestOllamaReranker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Prompt string `json:"prompt"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		score := "2"
+		if strings.Contains(req.Prompt, "Document title: b") {
+			score = "Relevance: 9"
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"response": score})
+	}))
+	defer server.Close()
+
+	reranker := NewOllamaReranker(server.URL, "llama3.2:1b", time.Second)
+	reranked, err := Rerank(context.Background(), reranker, "question", testDocuments("a", "b", "c"), 3)
+	if err != nil {
+		t.Fatalf("Rerank failed: %v", err)
+	}
+	// Equal scores keep retrieval order
+	if got := titles(reranked); got != "b,a,c" {
+		t.Errorf("Expected b,a,c, got %s", got)
+	}
+}
+
+func TestParseScore(t *testing.T) {
+	tests := map[string]float64{"7": 0.7, " 10.": 1, "Score: 4.5/10": 0.45, "42": 1}
+	for response, expected := range tests {
+		score, err := parseScore(response)
+		if err != nil || score != expected {
+			t.Errorf("parseScore(%q) = %v, %v; expected %v", response, score, err, expected)
+		}
+	}
+
+	if _, err := parseScore("not relevant"); err == nil {
+		t.Error("Expected an error for a reply without a number")
+	}
+}
@@ -0,0 +1,135 @@
+// Package retention hard-deletes documents whose retention period has ended,
+// together with their vectors and permission relations.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"rerag-rbac-rag-llm/internal/webhooks"
+	"time"
+)
+
+// batchSize is the number of expired documents deleted per store query
+const batchSize = 100
+
+// Scheduler deletes expired documents
+type Scheduler struct {
+	store     storage.VectorStore
+	expirer   storage.Expirer
+	policy    storage.RetentionPolicy
+	relations permissions.RelationRemover // optional
+	notifier  webhooks.Notifier           // optional
+	now       func() time.Time
+}
+
+// Option configures optional Scheduler behavior
+type Option func(*Scheduler)
+
+// WithRelationRemover deletes the permission relations of every expired
+// document before the document itself, so no tuple outlives its document
+func WithRelationRemover(r permissions.RelationRemover) Option {
+	return func(s *Scheduler) {
+		s.relations = r
+	}
+}
+
+// WithNotifier publishes a document.deleted event for every expired document
+func WithNotifier(n webhooks.Notifier) Option {
+	return func(s *Scheduler) {
+		s.notifier = n
+	}
+}
+
+// NewScheduler creates a scheduler that deletes the documents expirer reports
+// under policy from store, which must be the unscoped store of all tenants
+func NewScheduler(store storage.VectorStore, expirer storage.Expirer, policy storage.RetentionPolicy, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		store:   store,
+		expirer: expirer,
+		policy:  policy,
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run deletes expired documents immediately and then every interval until
+// ctx is done
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if deleted, err := s.RunOnce(ctx); err != nil {
+			log.Printf("Retention run failed after deleting %d documents: %v", deleted, err)
+		} else if deleted > 0 {
+			log.Printf("Retention run: deleted %d expired documents", deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RunOnce deletes all documents that have expired by now and returns how many
+// were deleted. It stops at the first document that cannot be deleted, which
+// is retried on the next run.
+func (s *Scheduler) RunOnce(ctx context.Context) (int, error) {
+	now := s.now()
+	deleted := 0
+	for {
+		docs, err := s.expirer.ExpiredDocuments(s.policy, now, batchSize)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to find expired documents: %w", err)
+		}
+		for i := range docs {
+			if err := s.expire(ctx, &docs[i]); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if len(docs) < batchSize || ctx.Err() != nil {
+			return deleted, ctx.Err()
+		}
+	}
+}
+
+// expire deletes the relations and then the document, logging an audit
+// entry for the outcome
+func (s *Scheduler) expire(ctx context.Context, doc *models.Document) error {
+	ctx = tenant.NewContext(ctx, doc.TenantID)
+	expiresAt, _ := s.policy.ExpiresAt(doc)
+
+	relations := "skipped"
+	if s.relations != nil {
+		err := s.relations.RemoveDocumentRelations(ctx, doc.ID)
+		switch {
+		case errors.Is(err, permissions.ErrChangesUnsupported):
+		case err != nil:
+			log.Printf("AUDIT document expiry failed: id=%s tenant=%s step=relations error=%q", doc.ID, doc.TenantID, err)
+			return fmt.Errorf("failed to remove relations of expired document %s: %w", doc.ID, err)
+		default:
+			relations = "deleted"
+		}
+	}
+
+	if err := s.store.ForTenant(doc.TenantID).DeleteDocument(doc.ID); err != nil && !errors.Is(err, storage.ErrDocumentNotFound) {
+		log.Printf("AUDIT document expiry failed: id=%s tenant=%s step=document error=%q", doc.ID, doc.TenantID, err)
+		return fmt.Errorf("failed to delete expired document %s: %w", doc.ID, err)
+	}
+
+	log.Printf("AUDIT document expired: id=%s tenant=%s title=%q created_at=%s expires_at=%s trashed=%t relations=%s",
+		doc.ID, doc.TenantID, doc.Title, doc.CreatedAt.Format(time.RFC3339), expiresAt.Format(time.RFC3339), doc.DeletedAt != nil, relations)
+	if s.notifier != nil {
+		s.notifier.Notify(ctx, webhooks.DocumentDeleted, webhooks.DocumentData{ID: doc.ID, Title: doc.Title, Metadata: doc.Metadata})
+	}
+	return nil
+}
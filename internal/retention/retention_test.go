@@ -0,0 +1,71 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// fakeRemover records the relations removed per tenant
+type fakeRemover struct {
+	removed map[uuid.UUID]string
+	err     error
+}
+
+func (f *fakeRemover) RemoveDocumentRelations(ctx context.Context, docID uuid.UUID) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.removed[docID] = tenant.FromContext(ctx)
+	return nil
+}
+
+func TestSchedulerDeletesExpiredDocuments(t *testing.T) {
+	store, _ := storage.NewInMemoryVectorStore("")
+	overdue := &models.Document{Title: "2019 return", Content: "a", Embedding: []float32{1, 0}, Metadata: map[string]interface{}{"expires_at": "2020-01-01"}}
+	acme := &models.Document{Title: "Acme 2018 return", Content: "b", Embedding: []float32{0, 1}, Metadata: map[string]interface{}{"expires_at": "2019-06-30"}}
+	current := &models.Document{Title: "Current", Content: "c", Embedding: []float32{1, 1}}
+	_ = store.AddDocument(overdue)
+	_ = store.ForTenant("acme").AddDocument(acme)
+	_ = store.AddDocument(current)
+	_ = store.ForTenant("acme").(storage.Trash).TrashDocument(acme.ID)
+
+	remover := &fakeRemover{removed: map[uuid.UUID]string{}}
+	scheduler := NewScheduler(store, store, storage.RetentionPolicy{ExpiresAtKey: "expires_at"}, WithRelationRemover(remover))
+	deleted, err := scheduler.RunOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("Expected 2 deleted documents, got %d", deleted)
+	}
+	if remover.removed[overdue.ID] != tenant.Default || remover.removed[acme.ID] != "acme" {
+		t.Errorf("Expected relations to be removed in each document's tenant, got %v", remover.removed)
+	}
+	if trash, _ := store.ForTenant("acme").(storage.Trash).ListTrash(storage.ListOptions{Limit: 10}); len(trash) != 0 {
+		t.Errorf("Expected the trashed document to be deleted for good, got %+v", trash)
+	}
+	if docs := store.GetAllDocuments(); len(docs) != 1 || docs[0].ID != current.ID {
+		t.Errorf("Expected only the current document to remain, got %+v", docs)
+	}
+}
+
+func TestSchedulerKeepsDocumentWhenRelationsFail(t *testing.T) {
+	store, _ := storage.NewInMemoryVectorStore("")
+	overdue := &models.Document{Title: "2019 return", Content: "a", Embedding: []float32{1, 0}, Metadata: map[string]interface{}{"expires_at": "2020-01-01"}}
+	_ = store.AddDocument(overdue)
+
+	remover := &fakeRemover{err: errors.New("keto unavailable")}
+	scheduler := NewScheduler(store, store, storage.RetentionPolicy{ExpiresAtKey: "expires_at"}, WithRelationRemover(remover))
+	if deleted, err := scheduler.RunOnce(context.Background()); err == nil || deleted != 0 {
+		t.Fatalf("Expected the run to fail without deleting, got %d (%v)", deleted, err)
+	}
+	if _, err := store.GetDocument(overdue.ID); err != nil {
+		t.Errorf("Expected the document to be kept for the next run, got %v", err)
+	}
+}
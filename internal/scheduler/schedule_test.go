@@ -0,0 +1,50 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedules(t *testing.T) {
+	// Wednesday
+	from := time.Date(2026, 1, 14, 10, 7, 30, 0, time.UTC)
+	for spec, expected := range map[string]time.Time{
+		"*/15 * * * *":  time.Date(2026, 1, 14, 10, 15, 0, 0, time.UTC),
+		"0 3 * * *":     time.Date(2026, 1, 15, 3, 0, 0, 0, time.UTC),
+		"30 9-17 * * *": time.Date(2026, 1, 14, 10, 30, 0, 0, time.UTC),
+		"0 0 * * 0":     time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC),
+		"0 0 * * 7":     time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC),
+		"0 8 1,15 * *":  time.Date(2026, 1, 15, 8, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":    time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		"@hourly":       time.Date(2026, 1, 14, 11, 0, 0, 0, time.UTC),
+		"@monthly":      time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		// Restricted day of month and day of week match either
+		"0 0 20 * 5": time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+		"@every 90s": from.Add(90 * time.Second),
+	} {
+		schedule, err := Parse(spec)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", spec, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(expected) {
+			t.Errorf("%q: expected next run at %v, got %v", spec, expected, next)
+		}
+	}
+
+	never, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := never.Next(from); !next.IsZero() {
+		t.Errorf("Expected no run on February 30, got %v", next)
+	}
+}
+
+func TestParseRejectsInvalidSchedules(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@every 10ms", "@every soon", "@sometimes"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
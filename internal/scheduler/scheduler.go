@@ -0,0 +1,248 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunStats counts the objects a crawl found changed, by kind of change
+type RunStats struct {
+	Added     int // new objects ingested
+	Updated   int // changed objects re-ingested, or whose permissions were updated
+	Removed   int // objects gone from the source whose documents were deleted
+	Unchanged int // objects skipped by their version or content hash
+	Skipped   int // objects that are too large or have no extractable text
+	Failed    int // objects that failed and are retried next run
+}
+
+// Job is a crawl run on a schedule
+type Job struct {
+	Name   string
+	Tenant string // tenant the job ingests into, exported as a metric label
+	// Schedule decides when the job runs after the run at startup; nil runs
+	// it only once
+	Schedule Schedule
+	Run      func(ctx context.Context) (RunStats, error)
+}
+
+// Scheduler runs jobs at startup and then on their schedules. Runs of a job
+// never overlap: a run that takes longer than the schedule's interval delays
+// the next one, and the runs it missed are dropped.
+type Scheduler struct {
+	jobs    []Job
+	metrics *Metrics
+	now     func() time.Time
+}
+
+// New creates a scheduler recording runs in metrics
+func New(metrics *Metrics) *Scheduler {
+	return &Scheduler{metrics: metrics, now: time.Now}
+}
+
+// Add adds a job; it must be called before Run
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Run runs the jobs until ctx is done and their current runs have returned
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Go(func() {
+			s.runJob(ctx, job)
+		})
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) runJob(ctx context.Context, job Job) {
+	for {
+		start := s.now()
+		stats, err := job.Run(ctx)
+		duration := s.now().Sub(start)
+		if ctx.Err() != nil {
+			return
+		}
+		s.metrics.record(job, start, duration, stats, err)
+		if err != nil {
+			log.Printf("Crawl %s failed after %v: %v", job.Name, duration.Round(time.Millisecond), err)
+		} else {
+			log.Printf("Crawl %s finished in %v (added: %d, updated: %d, removed: %d, unchanged: %d, skipped: %d, failed: %d)",
+				job.Name, duration.Round(time.Millisecond),
+				stats.Added, stats.Updated, stats.Removed, stats.Unchanged, stats.Skipped, stats.Failed)
+		}
+
+		if job.Schedule == nil {
+			return
+		}
+		next := job.Schedule.Next(s.now())
+		if next.IsZero() {
+			log.Printf("Crawl %s has no further runs on its schedule", job.Name)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// jobKey identifies a job in the metrics
+type jobKey struct {
+	tenant, name string
+}
+
+// jobTotals are the recorded runs of a job
+type jobTotals struct {
+	runs, failedRuns int
+	totals, last     RunStats
+	lastStart        time.Time
+	lastDuration     time.Duration
+	lastSuccess      bool
+}
+
+// Metrics aggregates the runs of jobs. It is safe for concurrent use; a nil
+// *Metrics records nothing.
+type Metrics struct {
+	mu   sync.Mutex
+	jobs map[jobKey]*jobTotals
+}
+
+// NewMetrics creates empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{jobs: make(map[jobKey]*jobTotals)}
+}
+
+func (m *Metrics) record(job Job, start time.Time, duration time.Duration, stats RunStats, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := jobKey{tenant: job.Tenant, name: job.Name}
+	t, ok := m.jobs[k]
+	if !ok {
+		t = &jobTotals{}
+		m.jobs[k] = t
+	}
+	t.runs++
+	if err != nil {
+		t.failedRuns++
+	}
+	t.totals.Added += stats.Added
+	t.totals.Updated += stats.Updated
+	t.totals.Removed += stats.Removed
+	t.totals.Unchanged += stats.Unchanged
+	t.totals.Skipped += stats.Skipped
+	t.totals.Failed += stats.Failed
+	t.last = stats
+	t.lastStart = start
+	t.lastDuration = duration
+	t.lastSuccess = err == nil
+}
+
+// changes lists the kinds of change with their counts
+func changes(s RunStats) []struct {
+	kind  string
+	count int
+} {
+	return []struct {
+		kind  string
+		count int
+	}{
+		{"added", s.Added}, {"updated", s.Updated}, {"removed", s.Removed},
+		{"unchanged", s.Unchanged}, {"skipped", s.Skipped}, {"failed", s.Failed},
+	}
+}
+
+// WritePrometheus writes the metrics of the tenant's jobs in the Prometheus
+// text exposition format, sorted by job name
+func (m *Metrics) WritePrometheus(w io.Writer, tenantID string) error {
+	m.mu.Lock()
+	var names []string
+	snapshot := make(map[string]jobTotals)
+	for k, t := range m.jobs {
+		if k.tenant == tenantID {
+			names = append(names, k.name)
+			snapshot[k.name] = *t
+		}
+	}
+	m.mu.Unlock()
+	slices.Sort(names)
+
+	metrics := []struct {
+		name, help, typ string
+		samples         func(labels string, t *jobTotals) []string
+	}{
+		{"rerag_crawl_runs_total", "Crawl runs per job.", "counter", func(labels string, t *jobTotals) []string {
+			return []string{sample("rerag_crawl_runs_total", labels, "", float64(t.runs))}
+		}},
+		{"rerag_crawl_failed_runs_total", "Crawl runs that failed before completing per job.", "counter", func(labels string, t *jobTotals) []string {
+			return []string{sample("rerag_crawl_failed_runs_total", labels, "", float64(t.failedRuns))}
+		}},
+		{"rerag_crawl_objects_total", "Objects found by crawl runs per job and kind of change.", "counter", func(labels string, t *jobTotals) []string {
+			var lines []string
+			for _, c := range changes(t.totals) {
+				lines = append(lines, sample("rerag_crawl_objects_total", labels, `change="`+c.kind+`"`, float64(c.count)))
+			}
+			return lines
+		}},
+		{"rerag_crawl_last_run_objects", "Objects found by the last crawl run per job and kind of change.", "gauge", func(labels string, t *jobTotals) []string {
+			var lines []string
+			for _, c := range changes(t.last) {
+				lines = append(lines, sample("rerag_crawl_last_run_objects", labels, `change="`+c.kind+`"`, float64(c.count)))
+			}
+			return lines
+		}},
+		{"rerag_crawl_last_run_timestamp_seconds", "Start of the last crawl run per job as a Unix timestamp.", "gauge", func(labels string, t *jobTotals) []string {
+			return []string{sample("rerag_crawl_last_run_timestamp_seconds", labels, "", float64(t.lastStart.Unix()))}
+		}},
+		{"rerag_crawl_last_run_duration_seconds", "Wall-clock seconds of the last crawl run per job.", "gauge", func(labels string, t *jobTotals) []string {
+			return []string{sample("rerag_crawl_last_run_duration_seconds", labels, "", t.lastDuration.Seconds())}
+		}},
+		{"rerag_crawl_last_run_success", "Whether the last crawl run per job completed (1) or failed (0).", "gauge", func(labels string, t *jobTotals) []string {
+			success := 0.0
+			if t.lastSuccess {
+				success = 1
+			}
+			return []string{sample("rerag_crawl_last_run_success", labels, "", success)}
+		}},
+	}
+
+	var b strings.Builder
+	for _, metric := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.typ)
+		for _, name := range names {
+			t := snapshot[name]
+			labels := fmt.Sprintf(`tenant="%s",job="%s"`, escapeLabel(tenantID), escapeLabel(name))
+			for _, line := range metric.samples(labels, &t) {
+				b.WriteString(line)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sample formats one sample line with the job labels and an optional extra label
+func sample(name, labels, extra string, value float64) string {
+	if extra != "" {
+		labels += "," + extra
+	}
+	return fmt.Sprintf("%s{%s} %g\n", name, labels, value)
+}
+
+// labelEscaper escapes label values as the Prometheus text format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRunsJobsAndRecordsMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	s := New(metrics)
+
+	var runs atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Add(Job{Name: "s3://docs", Tenant: "acme", Schedule: Every(time.Second), Run: func(context.Context) (RunStats, error) {
+		if runs.Add(1) == 2 {
+			cancel()
+		}
+		return RunStats{Added: 2, Unchanged: 5}, nil
+	}})
+	s.Add(Job{Name: "once", Tenant: "acme", Run: func(context.Context) (RunStats, error) {
+		return RunStats{}, errors.New("listing failed")
+	}})
+	s.Add(Job{Name: "other", Tenant: "globex", Run: func(context.Context) (RunStats, error) {
+		return RunStats{Removed: 1}, nil
+	}})
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the scheduler to stop once the context is done")
+	}
+	if runs.Load() != 2 {
+		t.Errorf("Expected the job to run at startup and once on its schedule, got %d runs", runs.Load())
+	}
+
+	var b strings.Builder
+	if err := metrics.WritePrometheus(&b, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		`rerag_crawl_runs_total{tenant="acme",job="s3://docs"} 1`,
+		`rerag_crawl_objects_total{tenant="acme",job="s3://docs",change="added"} 2`,
+		`rerag_crawl_last_run_objects{tenant="acme",job="s3://docs",change="unchanged"} 5`,
+		`rerag_crawl_last_run_success{tenant="acme",job="s3://docs"} 1`,
+		`rerag_crawl_failed_runs_total{tenant="acme",job="once"} 1`,
+		`rerag_crawl_last_run_success{tenant="acme",job="once"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "globex") || strings.Contains(out, `job="other"`) {
+		t.Errorf("Expected only the tenant's jobs, got:\n%s", out)
+	}
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/sigv4"
+	"strings"
+	"time"
+)
+
+// AWSSecretsManager reads secrets from AWS Secrets Manager
+type AWSSecretsManager struct {
+	endpoint string
+	region   string
+	creds    sigv4.Credentials
+	client   *httpclient.Client
+	now      func() time.Time
+}
+
+// NewAWSSecretsManager creates a client for the region authenticating with
+// creds. An empty endpoint uses the public endpoint of the region. client
+// applies timeouts and retries; nil sends single attempts without a timeout.
+func NewAWSSecretsManager(region, endpoint string, creds sigv4.Credentials, client *httpclient.Client) *AWSSecretsManager {
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	if client == nil {
+		client = httpclient.New(httpclient.Options{})
+	}
+	return &AWSSecretsManager{endpoint: strings.TrimSuffix(endpoint, "/"), region: region, creds: creds, client: client, now: time.Now}
+}
+
+// Get returns the current value of the secret with id, its name or ARN. With
+// a field name, the value must be a JSON object and the field's value is returned.
+func (a *AWSSecretsManager) Get(ctx context.Context, id, name string) (string, error) {
+	payload, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, payload, a.creds, a.region, "secretsmanager", a.now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read aws secret %s: %w", id, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("aws secrets manager returned status %d for %s: %s", resp.StatusCode, id, body)
+	}
+
+	var result struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("failed to decode aws secret %s: %w", id, err)
+	}
+	if result.SecretString == "" {
+		return "", fmt.Errorf("aws secret %s has no string value", id)
+	}
+	if name == "" {
+		return result.SecretString, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(result.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("aws secret %s is not a JSON object", id)
+	}
+	value, err := field(fields, name)
+	if err != nil {
+		return "", fmt.Errorf("aws secret %s: %w", id, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,84 @@
+// Package secrets loads credentials from files and from HashiCorp Vault or
+// AWS Secrets Manager, so they need not be stored in the configuration.
+//
+// Secrets in a secret manager are named by references: "vault:<path>#<field>"
+// reads a field of a Vault KV secret and "aws-sm:<secret id>[#<field>]" reads
+// an AWS Secrets Manager secret, or a field of its JSON value.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Reference prefixes of the secret managers
+const (
+	VaultPrefix = "vault:"
+	AWSPrefix   = "aws-sm:"
+)
+
+// ErrNotConfigured is returned for references to a secret manager that is not configured
+var ErrNotConfigured = errors.New("secret manager is not configured")
+
+// IsReference reports whether value names a secret in a secret manager
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, VaultPrefix) || strings.HasPrefix(value, AWSPrefix)
+}
+
+// ReadFile reads a secret from a file such as a Docker or Kubernetes secret
+// mount, without trailing line breaks
+func ReadFile(path string) (string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- the path comes from the operator's configuration
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return secret, nil
+}
+
+// Resolver looks up references in the configured secret managers
+type Resolver struct {
+	Vault *Vault             // optional
+	AWS   *AWSSecretsManager // optional
+}
+
+// Resolve returns the secret named by ref
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, VaultPrefix):
+		if r.Vault == nil {
+			return "", fmt.Errorf("vault: %w", ErrNotConfigured)
+		}
+		path, field, _ := strings.Cut(strings.TrimPrefix(ref, VaultPrefix), "#")
+		if path == "" || field == "" {
+			return "", fmt.Errorf("vault references must have the form %s<path>#<field>", VaultPrefix)
+		}
+		return r.Vault.Get(ctx, path, field)
+	case strings.HasPrefix(ref, AWSPrefix):
+		if r.AWS == nil {
+			return "", fmt.Errorf("aws secrets manager: %w", ErrNotConfigured)
+		}
+		id, field, _ := strings.Cut(strings.TrimPrefix(ref, AWSPrefix), "#")
+		if id == "" {
+			return "", fmt.Errorf("aws secrets manager references must have the form %s<secret id>[#<field>]", AWSPrefix)
+		}
+		return r.AWS.Get(ctx, id, field)
+	default:
+		return "", fmt.Errorf("unknown secret reference")
+	}
+}
+
+// field returns the non-empty string value of name in a secret's fields
+func field(fields map[string]interface{}, name string) (string, error) {
+	value, ok := fields[name].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("secret has no string field %q", name)
+	}
+	return value, nil
+}
@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/sigv4"
+	"strings"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt_secret")
+	if err := os.WriteFile(path, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := ReadFile(path); err != nil || secret != "s3cret" {
+		t.Errorf("Expected the secret without the line break, got %q, %v", secret, err)
+	}
+
+	if err := os.WriteFile(path, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err == nil {
+		t.Error("Expected an empty secret file to be rejected")
+	}
+}
+
+func TestResolveVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" || r.Header.Get("X-Vault-Namespace") != "team" {
+			http.Error(w, `{"errors": ["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/rerag":
+			_, _ = io.WriteString(w, `{"data": {"data": {"jwt_secret": "from-kv2"}, "metadata": {"version": 3}}}`)
+		case "/v1/kv/rerag":
+			_, _ = io.WriteString(w, `{"data": {"jwt_secret": "from-kv1"}}`)
+		default:
+			http.Error(w, `{"errors": []}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r := &Resolver{Vault: NewVault(server.URL, "vault-token", "team", nil)}
+	for ref, want := range map[string]string{
+		"vault:secret/data/rerag#jwt_secret": "from-kv2",
+		"vault:kv/rerag#jwt_secret":          "from-kv1",
+	} {
+		if secret, err := r.Resolve(context.Background(), ref); err != nil || secret != want {
+			t.Errorf("%s: expected %q, got %q, %v", ref, want, secret, err)
+		}
+	}
+	for _, ref := range []string{"vault:secret/data/rerag#missing", "vault:secret/data/other#jwt_secret", "vault:secret/data/rerag"} {
+		if _, err := r.Resolve(context.Background(), ref); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+
+	r.Vault = NewVault(server.URL, "wrong", "team", nil)
+	if _, err := r.Resolve(context.Background(), "vault:secret/data/rerag#jwt_secret"); err == nil {
+		t.Error("Expected a rejected token to fail")
+	}
+}
+
+func TestResolveAWSSecretsManager(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.Contains(auth, "Credential=AKID/") || !strings.Contains(auth, "/eu-central-1/secretsmanager/aws4_request") ||
+			!strings.Contains(auth, "x-amz-security-token") || r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			http.Error(w, `{"__type": "UnrecognizedClientException"}`, http.StatusBadRequest)
+			return
+		}
+		var body struct{ SecretId string }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch body.SecretId {
+		case "rerag/jwt":
+			_, _ = io.WriteString(w, `{"SecretString": "plain-secret"}`)
+		case "rerag/prod":
+			_, _ = io.WriteString(w, `{"SecretString": "{\"db_key\": \"json-secret\"}"}`)
+		default:
+			http.Error(w, `{"__type": "ResourceNotFoundException"}`, http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	creds := sigv4.Credentials{AccessKey: "AKID", SecretKey: "secret", SessionToken: "session"}
+	r := &Resolver{AWS: NewAWSSecretsManager("eu-central-1", server.URL, creds, nil)}
+	for ref, want := range map[string]string{
+		"aws-sm:rerag/jwt":         "plain-secret",
+		"aws-sm:rerag/prod#db_key": "json-secret",
+	} {
+		if secret, err := r.Resolve(context.Background(), ref); err != nil || secret != want {
+			t.Errorf("%s: expected %q, got %q, %v", ref, want, secret, err)
+		}
+	}
+	for _, ref := range []string{"aws-sm:rerag/missing", "aws-sm:rerag/jwt#field", "aws-sm:rerag/prod#missing"} {
+		if _, err := r.Resolve(context.Background(), ref); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+}
+
+func TestResolveUnconfiguredManager(t *testing.T) {
+	r := &Resolver{}
+	for _, ref := range []string{"vault:secret/data/rerag#jwt_secret", "aws-sm:rerag/jwt"} {
+		if _, err := r.Resolve(context.Background(), ref); !errors.Is(err, ErrNotConfigured) {
+			t.Errorf("%s: expected ErrNotConfigured, got %v", ref, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"strings"
+)
+
+// Vault reads secrets from the KV secrets engine of HashiCorp Vault
+type Vault struct {
+	address   string
+	token     string
+	namespace string
+	client    *httpclient.Client
+}
+
+// NewVault creates a client for the Vault server at address authenticating
+// with token. namespace is only needed for Vault Enterprise. client applies
+// timeouts and retries; nil sends single attempts without a timeout.
+func NewVault(address, token, namespace string, client *httpclient.Client) *Vault {
+	if client == nil {
+		client = httpclient.New(httpclient.Options{})
+	}
+	return &Vault{address: strings.TrimSuffix(address, "/"), token: token, namespace: namespace, client: client}
+}
+
+// Get reads field of the secret at path, e.g. "secret/data/rerag" for
+// version 2 of the KV engine mounted at secret/ or "kv/rerag" for version 1
+func (v *Vault) Get(ctx context.Context, path, name string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read vault secret %s: %w", path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		// Vault's error bodies name the problem without revealing secrets
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("vault returned status %d for %s: %s", resp.StatusCode, path, body)
+	}
+
+	var result struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode vault secret %s: %w", path, err)
+	}
+	// Version 2 nests the fields with the secret's metadata
+	fields := result.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		if _, versioned := fields["metadata"]; versioned {
+			fields = nested
+		}
+	}
+	value, err := field(fields, name)
+	if err != nil {
+		return "", fmt.Errorf("vault %s: %w", path, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,73 @@
+package share
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Store lists and deletes share links across tenants
+type Store interface {
+	// ExpiredShareLinks returns the links of all tenants that expired before t
+	ExpiredShareLinks(t time.Time) ([]models.ShareLink, error)
+	// DeleteShareLink deletes the link with id in any tenant
+	DeleteShareLink(id uuid.UUID) error
+}
+
+// Revoker deletes relation tuples
+type Revoker interface {
+	Revoke(ctx context.Context, t permissions.Tuple) error
+}
+
+// Tuple returns the relation granting read access through link
+func Tuple(link *models.ShareLink) permissions.Tuple {
+	return permissions.Tuple{Subject: Subject(link.ID), Relation: permissions.RelationViewer, DocumentID: link.DocumentID}
+}
+
+// Expire revokes the relations of the links that expired before now and
+// deletes them. A link whose relation could not be revoked is kept to be
+// retried. It returns the number of links deleted.
+func Expire(ctx context.Context, store Store, revoker Revoker, now time.Time) (int, error) {
+	links, err := store.ExpiredShareLinks(now)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i := range links {
+		link := &links[i]
+		if err := revoker.Revoke(tenant.NewContext(ctx, link.TenantID), Tuple(link)); err != nil {
+			return deleted, fmt.Errorf("failed to revoke share link %s: %w", link.ID, err)
+		}
+		if err := store.DeleteShareLink(link.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// RunExpiry expires share links immediately and then every interval until
+// ctx is done
+func RunExpiry(ctx context.Context, store Store, revoker Revoker, interval time.Duration) {
+	for {
+		expired, err := Expire(ctx, store, revoker, time.Now())
+		if err != nil {
+			log.Printf("Share link expiry failed: %v", err)
+		}
+		if expired > 0 {
+			log.Printf("Share link expiry: revoked %d expired links", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package share
+
+import (
+	"context"
+	"errors"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSignerVerify(t *testing.T) {
+	signer := NewSigner("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+	link := &models.ShareLink{ID: uuid.New(), TenantID: "acme", DocumentID: uuid.New(), ExpiresAt: now.Add(time.Hour)}
+
+	token, err := signer.Sign(link)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	claims, err := signer.Verify(token, now)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.ID != link.ID || claims.TenantID != "acme" || claims.DocumentID != link.DocumentID || claims.Subject() != "share:"+link.ID.String() {
+		t.Errorf("Unexpected claims %+v", claims)
+	}
+
+	if _, err := signer.Verify(token, now.Add(time.Hour)); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Expected the token to expire, got %v", err)
+	}
+	encoded, signature, _ := strings.Cut(token, ".")
+	other, _ := NewSigner("another secret").Sign(link)
+	for name, tampered := range map[string]string{
+		"other key":       other,
+		"changed payload": encoded + "x." + signature,
+		"no signature":    encoded,
+		"no prefix":       strings.TrimPrefix(token, tokenPrefix),
+	} {
+		if _, err := signer.Verify(tampered, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: expected ErrInvalidToken, got %v", name, err)
+		}
+	}
+}
+
+// memoryStore holds share links for Expire
+type memoryStore struct {
+	links []models.ShareLink
+}
+
+func (m *memoryStore) ExpiredShareLinks(t time.Time) ([]models.ShareLink, error) {
+	var expired []models.ShareLink
+	for _, link := range m.links {
+		if !link.ExpiresAt.After(t) {
+			expired = append(expired, link)
+		}
+	}
+	return expired, nil
+}
+
+func (m *memoryStore) DeleteShareLink(id uuid.UUID) error {
+	m.links = slices.DeleteFunc(m.links, func(link models.ShareLink) bool { return link.ID == id })
+	return nil
+}
+
+// recordingRevoker records revoked tuples with their tenant and fails for failDoc
+type recordingRevoker struct {
+	revoked []string
+	failDoc uuid.UUID
+}
+
+func (r *recordingRevoker) Revoke(ctx context.Context, t permissions.Tuple) error {
+	if t.DocumentID == r.failDoc {
+		return errors.New("keto unavailable")
+	}
+	r.revoked = append(r.revoked, tenant.FromContext(ctx)+"/"+t.Subject+"#"+t.Relation)
+	return nil
+}
+
+func TestExpireRevokesExpiredLinks(t *testing.T) {
+	now := time.Now()
+	expired := models.ShareLink{ID: uuid.New(), TenantID: "acme", DocumentID: uuid.New(), ExpiresAt: now.Add(-time.Minute)}
+	active := models.ShareLink{ID: uuid.New(), TenantID: "acme", DocumentID: uuid.New(), ExpiresAt: now.Add(time.Minute)}
+	store := &memoryStore{links: []models.ShareLink{expired, active}}
+	revoker := &recordingRevoker{}
+
+	deleted, err := Expire(context.Background(), store, revoker, now)
+	if err != nil || deleted != 1 {
+		t.Fatalf("Expected one link to expire, got %d (%v)", deleted, err)
+	}
+	if want := []string{"acme/" + Subject(expired.ID) + "#viewer"}; !slices.Equal(revoker.revoked, want) {
+		t.Errorf("Expected %v to be revoked, got %v", want, revoker.revoked)
+	}
+	if len(store.links) != 1 || store.links[0].ID != active.ID {
+		t.Errorf("Expected only the active link to remain, got %+v", store.links)
+	}
+
+	// A link whose relation could not be revoked is kept for the next run
+	revoker.failDoc = active.DocumentID
+	if _, err := Expire(context.Background(), store, revoker, now.Add(time.Hour)); err == nil {
+		t.Error("Expected the failed revocation to be reported")
+	}
+	if len(store.links) != 1 {
+		t.Errorf("Expected the link to be kept, got %+v", store.links)
+	}
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrAPIKeyNotFound is returned when an API key does not exist or is revoked
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyStore persists hashed API keys
+type APIKeyStore interface {
+	// CreateAPIKey stores a new key in the tenant, assigning its ID
+	CreateAPIKey(key *models.APIKey) error
+	// ListAPIKeys returns the tenant's keys including revoked ones, oldest first
+	ListAPIKeys() ([]models.APIKey, error)
+	// RevokeAPIKey revokes a key of the tenant and returns it, or returns
+	// ErrAPIKeyNotFound. Revoking a revoked key keeps its revocation time.
+	RevokeAPIKey(id uuid.UUID) (*models.APIKey, error)
+	// FindAPIKey returns the unrevoked key with the given hash in any tenant or ErrAPIKeyNotFound
+	FindAPIKey(hash string) (*models.APIKey, error)
+	// ForTenant returns a view of the store restricted to tenantID
+	ForTenant(tenantID string) APIKeyStore
+}
+
+// SQLiteAPIKeyStore stores API keys in the vector store's SQLite database
+type SQLiteAPIKeyStore struct {
+	db       *sql.DB
+	tenantID string
+}
+
+// NewSQLiteAPIKeyStore creates the API key table in the database backing store
+func NewSQLiteAPIKeyStore(store *SQLiteVectorStore) (*SQLiteAPIKeyStore, error) {
+	s := &SQLiteAPIKeyStore{
+		db:       store.db,
+		tenantID: tenant.Default,
+	}
+
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		tenant_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		username TEXT NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '[]',
+		key_hash TEXT NOT NULL UNIQUE,
+		created_at INTEGER NOT NULL,
+		revoked_at INTEGER
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api key table: %w", err)
+	}
+
+	return s, nil
+}
+
+// ForTenant returns a view of the store whose reads and writes are restricted to tenantID
+func (s *SQLiteAPIKeyStore) ForTenant(tenantID string) APIKeyStore {
+	scoped := *s
+	scoped.tenantID = tenantID
+	return &scoped
+}
+
+// CreateAPIKey stores a new key in the tenant
+func (s *SQLiteAPIKeyStore) CreateAPIKey(key *models.APIKey) error {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+	key.ID = uuid.New()
+	key.TenantID = s.tenantID
+	key.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	key.RevokedAt = nil
+
+	_, err = s.db.Exec(`INSERT INTO api_keys (id, tenant_id, name, username, scopes, key_hash, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		key.ID.String(), s.tenantID, key.Name, key.User, string(scopes), key.Hash, key.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+	return nil
+}
+
+// ListAPIKeys returns the tenant's keys in creation order
+func (s *SQLiteAPIKeyStore) ListAPIKeys() ([]models.APIKey, error) {
+	rows, err := s.db.Query(`SELECT id, tenant_id, name, username, scopes, key_hash, created_at, revoked_at FROM api_keys WHERE tenant_id = ? ORDER BY created_at, rowid`, s.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks a key of the tenant as revoked
+func (s *SQLiteAPIKeyStore) RevokeAPIKey(id uuid.UUID) (*models.APIKey, error) {
+	_, err := s.db.Exec(`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ? AND tenant_id = ?`,
+		time.Now().UTC().Unix(), id.String(), s.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	row := s.db.QueryRow(`SELECT id, tenant_id, name, username, scopes, key_hash, created_at, revoked_at FROM api_keys WHERE id = ? AND tenant_id = ?`, id.String(), s.tenantID)
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// FindAPIKey looks up an unrevoked key by hash across all tenants
+func (s *SQLiteAPIKeyStore) FindAPIKey(hash string) (*models.APIKey, error) {
+	row := s.db.QueryRow(`SELECT id, tenant_id, name, username, scopes, key_hash, created_at, revoked_at FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL`, hash)
+	key, err := scanAPIKey(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// scanAPIKey reads a key from a row of the api_keys table
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (*models.APIKey, error) {
+	var key models.APIKey
+	var id, scopes string
+	var createdAt int64
+	var revokedAt sql.NullInt64
+	if err := row.Scan(&id, &key.TenantID, &key.Name, &key.User, &scopes, &key.Hash, &createdAt, &revokedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan api key: %w", err)
+	}
+
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse api key ID: %w", err)
+	}
+	key.ID = parsed
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return nil, fmt.Errorf("failed to decode api key scopes: %w", err)
+	}
+	key.CreatedAt = time.Unix(createdAt, 0).UTC()
+	if revokedAt.Valid {
+		revoked := time.Unix(revokedAt.Int64, 0).UTC()
+		key.RevokedAt = &revoked
+	}
+	return &key, nil
+}
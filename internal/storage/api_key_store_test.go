@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSQLiteAPIKeyStore(t *testing.T) {
+	store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	keys, err := NewSQLiteAPIKeyStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create API key store: %v", err)
+	}
+	acme := keys.ForTenant("acme")
+
+	key := &models.APIKey{Name: "import", User: "bot", Scopes: []string{models.APIKeyScopeIngest}, Hash: "hash-1"}
+	if err := acme.CreateAPIKey(key); err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	if key.ID == uuid.Nil || key.TenantID != "acme" {
+		t.Fatalf("Expected ID and tenant to be assigned, got %+v", key)
+	}
+
+	found, err := keys.FindAPIKey("hash-1")
+	if err != nil {
+		t.Fatalf("Failed to find API key: %v", err)
+	}
+	if found.ID != key.ID || found.TenantID != "acme" || found.User != "bot" || len(found.Scopes) != 1 {
+		t.Errorf("Unexpected key %+v", found)
+	}
+	if _, err := keys.FindAPIKey("hash-2"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound, got %v", err)
+	}
+
+	if listed, err := keys.ListAPIKeys(); err != nil || len(listed) != 0 {
+		t.Errorf("Expected no keys in the default tenant, got %v (%v)", listed, err)
+	}
+	if _, err := keys.RevokeAPIKey(key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected revoking another tenant's key to fail, got %v", err)
+	}
+
+	revoked, err := acme.RevokeAPIKey(key.ID)
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("Failed to revoke API key: %+v (%v)", revoked, err)
+	}
+	if _, err := keys.FindAPIKey("hash-1"); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("Expected a revoked key not to be found, got %v", err)
+	}
+	listed, err := acme.ListAPIKeys()
+	if err != nil || len(listed) != 1 || listed[0].RevokedAt == nil {
+		t.Errorf("Expected the revoked key to be listed, got %+v (%v)", listed, err)
+	}
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrConversationNotFound is returned when a conversation does not exist in the tenant
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// ConversationStore persists conversations and their messages
+type ConversationStore interface {
+	CreateConversation(conv *models.Conversation) error
+	// GetConversation returns the conversation with all messages or ErrConversationNotFound
+	GetConversation(id uuid.UUID) (*models.Conversation, error)
+	// AppendMessages adds messages to the end of a conversation atomically
+	AppendMessages(id uuid.UUID, messages ...models.Message) error
+	// ForTenant returns a view of the store restricted to tenantID
+	ForTenant(tenantID string) ConversationStore
+}
+
+// SQLiteConversationStore stores conversations in the vector store's SQLite database
+type SQLiteConversationStore struct {
+	db       *sql.DB
+	tenantID string
+}
+
+// NewSQLiteConversationStore creates the conversation tables in the database backing store
+func NewSQLiteConversationStore(store *SQLiteVectorStore) (*SQLiteConversationStore, error) {
+	c := &SQLiteConversationStore{
+		db:       store.db,
+		tenantID: tenant.Default,
+	}
+
+	schema := []string{
+		`CREATE TABLE IF NOT EXISTS conversations (
+			id TEXT PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			username TEXT NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS conversation_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			conversation_id TEXT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+			role TEXT NOT NULL,
+			content TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_conversation_messages_conversation ON conversation_messages(conversation_id, id)`,
+	}
+	for _, query := range schema {
+		if _, err := c.db.Exec(query); err != nil {
+			return nil, fmt.Errorf("failed to create conversation tables: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// ForTenant returns a view of the store whose reads and writes are restricted to tenantID
+func (c *SQLiteConversationStore) ForTenant(tenantID string) ConversationStore {
+	scoped := *c
+	scoped.tenantID = tenantID
+	return &scoped
+}
+
+// CreateConversation stores a new conversation, assigning an ID if none is set
+func (c *SQLiteConversationStore) CreateConversation(conv *models.Conversation) error {
+	if conv.ID == uuid.Nil {
+		conv.ID = uuid.New()
+	}
+	conv.TenantID = c.tenantID
+	conv.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	_, err := c.db.Exec(`INSERT INTO conversations (id, tenant_id, username, title, created_at) VALUES (?, ?, ?, ?, ?)`,
+		conv.ID.String(), c.tenantID, conv.Username, conv.Title, conv.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to create conversation: %w", err)
+	}
+	return nil
+}
+
+// GetConversation returns the conversation and its messages in chronological order
+func (c *SQLiteConversationStore) GetConversation(id uuid.UUID) (*models.Conversation, error) {
+	conv := &models.Conversation{ID: id, TenantID: c.tenantID}
+	var createdAt int64
+	err := c.db.QueryRow(`SELECT username, title, created_at FROM conversations WHERE id = ? AND tenant_id = ?`, id.String(), c.tenantID).
+		Scan(&conv.Username, &conv.Title, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+	conv.CreatedAt = time.Unix(createdAt, 0).UTC()
+
+	rows, err := c.db.Query(`SELECT role, content, created_at FROM conversation_messages WHERE conversation_id = ? ORDER BY id`, id.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation messages: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var msg models.Message
+		var msgCreatedAt int64
+		if err := rows.Scan(&msg.Role, &msg.Content, &msgCreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation message: %w", err)
+		}
+		msg.CreatedAt = time.Unix(msgCreatedAt, 0).UTC()
+		conv.Messages = append(conv.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating conversation messages: %w", err)
+	}
+
+	return conv, nil
+}
+
+// AppendMessages adds messages to the end of a conversation in one transaction
+func (c *SQLiteConversationStore) AppendMessages(id uuid.UUID, messages ...models.Message) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM conversations WHERE id = ? AND tenant_id = ?`, id.String(), c.tenantID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up conversation: %w", err)
+	}
+	if exists == 0 {
+		return ErrConversationNotFound
+	}
+
+	for _, msg := range messages {
+		createdAt := msg.CreatedAt
+		if createdAt.IsZero() {
+			createdAt = time.Now()
+		}
+		if _, err := tx.Exec(`INSERT INTO conversation_messages (conversation_id, role, content, created_at) VALUES (?, ?, ?, ?)`,
+			id.String(), msg.Role, msg.Content, createdAt.Unix()); err != nil {
+			return fmt.Errorf("failed to store conversation message: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"os"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSQLiteConversationStore(t *testing.T) {
+	dbPath := "./test_conversation_store.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	conversations, err := NewSQLiteConversationStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create conversation store: %v", err)
+	}
+
+	conv := &models.Conversation{Username: "alice", Title: "Refunds"}
+	if err := conversations.CreateConversation(conv); err != nil {
+		t.Fatalf("Failed to create conversation: %v", err)
+	}
+	if conv.ID == uuid.Nil {
+		t.Fatal("Expected conversation ID to be assigned")
+	}
+
+	err = conversations.AppendMessages(conv.ID,
+		models.Message{Role: models.RoleUser, Content: "What was John's refund?"},
+		models.Message{Role: models.RoleAssistant, Content: "$1,200"},
+	)
+	if err != nil {
+		t.Fatalf("Failed to append messages: %v", err)
+	}
+
+	loaded, err := conversations.GetConversation(conv.ID)
+	if err != nil {
+		t.Fatalf("Failed to get conversation: %v", err)
+	}
+	if loaded.Username != "alice" || loaded.Title != "Refunds" || len(loaded.Messages) != 2 {
+		t.Fatalf("Unexpected conversation: %+v", loaded)
+	}
+	if loaded.Messages[0].Role != models.RoleUser || loaded.Messages[1].Content != "$1,200" {
+		t.Errorf("Expected messages in order, got %+v", loaded.Messages)
+	}
+
+	other := conversations.ForTenant("other")
+	if _, err := other.GetConversation(conv.ID); err != ErrConversationNotFound {
+		t.Errorf("Expected conversation to be invisible to other tenants, got %v", err)
+	}
+	if err := other.AppendMessages(conv.ID, models.Message{Role: models.RoleUser, Content: "x"}); err != ErrConversationNotFound {
+		t.Errorf("Expected append from another tenant to fail, got %v", err)
+	}
+}
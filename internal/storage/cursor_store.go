@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrCursorNotFound is returned when an object has not been ingested yet
+var ErrCursorNotFound = errors.New("ingestion cursor not found")
+
+// Cursor records the version of an external object that was last ingested and
+// the documents created from it, so connectors can skip unchanged objects and
+// replace the documents of changed ones
+type Cursor struct {
+	Source  string // connector-specific source, e.g. "s3://bucket"
+	Key     string // object key within the source
+	Version string // ETag or other change marker of the ingested object
+	// ContentHash is the hex SHA-256 digest of the ingested content, so a new
+	// version with identical content need not be ingested again
+	ContentHash string
+	DocumentIDs []uuid.UUID
+	// Relations are the relations the connector granted on the documents, as
+	// "<relation>:<holder>", so it can revoke them when the source changes
+	Relations []string
+	UpdatedAt time.Time
+}
+
+// CursorStore persists ingestion cursors
+type CursorStore interface {
+	// GetCursor returns the cursor of an object or ErrCursorNotFound
+	GetCursor(source, key string) (*Cursor, error)
+	// PutCursor creates or replaces the cursor of an object
+	PutCursor(cursor *Cursor) error
+	// ListCursors returns the cursors of all objects of a source
+	ListCursors(source string) ([]*Cursor, error)
+	// DeleteCursor removes the cursor of an object; a missing cursor is not an error
+	DeleteCursor(source, key string) error
+	// ForTenant returns a view of the store restricted to tenantID
+	ForTenant(tenantID string) CursorStore
+}
+
+// SQLiteCursorStore stores ingestion cursors in the vector store's SQLite database
+type SQLiteCursorStore struct {
+	db       *sql.DB
+	tenantID string
+}
+
+// NewSQLiteCursorStore creates the cursor table in the database backing store
+func NewSQLiteCursorStore(store *SQLiteVectorStore) (*SQLiteCursorStore, error) {
+	c := &SQLiteCursorStore{
+		db:       store.db,
+		tenantID: tenant.Default,
+	}
+
+	_, err := c.db.Exec(`CREATE TABLE IF NOT EXISTS ingestion_cursors (
+		tenant_id TEXT NOT NULL,
+		source TEXT NOT NULL,
+		object_key TEXT NOT NULL,
+		version TEXT NOT NULL,
+		content_hash TEXT NOT NULL DEFAULT '',
+		document_ids TEXT NOT NULL DEFAULT '[]',
+		relations TEXT NOT NULL DEFAULT '[]',
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (tenant_id, source, object_key)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ingestion cursor table: %w", err)
+	}
+
+	// Databases created by earlier versions lack the later columns
+	for _, column := range []struct{ name, definition string }{
+		{"relations", "TEXT NOT NULL DEFAULT '[]'"},
+		{"content_hash", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		var hasColumn int
+		if err := c.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('ingestion_cursors') WHERE name = ?`, column.name).Scan(&hasColumn); err != nil {
+			return nil, fmt.Errorf("failed to inspect ingestion cursor table: %w", err)
+		}
+		if hasColumn == 0 {
+			if _, err := c.db.Exec(fmt.Sprintf(`ALTER TABLE ingestion_cursors ADD COLUMN %s %s`, column.name, column.definition)); err != nil {
+				return nil, fmt.Errorf("failed to add %s to ingestion cursor table: %w", column.name, err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// ForTenant returns a view of the store whose reads and writes are restricted to tenantID
+func (c *SQLiteCursorStore) ForTenant(tenantID string) CursorStore {
+	scoped := *c
+	scoped.tenantID = tenantID
+	return &scoped
+}
+
+// GetCursor returns the cursor of an object in the tenant
+func (c *SQLiteCursorStore) GetCursor(source, key string) (*Cursor, error) {
+	row := c.db.QueryRow(`SELECT object_key, version, content_hash, document_ids, relations, updated_at FROM ingestion_cursors
+		WHERE tenant_id = ? AND source = ? AND object_key = ?`, c.tenantID, source, key)
+	cursor, err := scanCursor(source, row)
+	if err == sql.ErrNoRows {
+		return nil, ErrCursorNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingestion cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// ListCursors returns the cursors of all objects of a source in the tenant,
+// ordered by key
+func (c *SQLiteCursorStore) ListCursors(source string) ([]*Cursor, error) {
+	rows, err := c.db.Query(`SELECT object_key, version, content_hash, document_ids, relations, updated_at FROM ingestion_cursors
+		WHERE tenant_id = ? AND source = ? ORDER BY object_key`, c.tenantID, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingestion cursors: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var cursors []*Cursor
+	for rows.Next() {
+		cursor, err := scanCursor(source, rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ingestion cursors: %w", err)
+		}
+		cursors = append(cursors, cursor)
+	}
+	return cursors, rows.Err()
+}
+
+// scanCursor reads a cursor of source from a row
+func scanCursor(source string, row interface{ Scan(...any) error }) (*Cursor, error) {
+	cursor := &Cursor{Source: source}
+	var documentIDs, relations string
+	var updatedAt int64
+	if err := row.Scan(&cursor.Key, &cursor.Version, &cursor.ContentHash, &documentIDs, &relations, &updatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(documentIDs), &cursor.DocumentIDs); err != nil {
+		return nil, fmt.Errorf("failed to decode ingestion cursor documents: %w", err)
+	}
+	if err := json.Unmarshal([]byte(relations), &cursor.Relations); err != nil {
+		return nil, fmt.Errorf("failed to decode ingestion cursor relations: %w", err)
+	}
+	cursor.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return cursor, nil
+}
+
+// PutCursor creates or replaces the cursor of an object in the tenant
+func (c *SQLiteCursorStore) PutCursor(cursor *Cursor) error {
+	documentIDs, err := jsonArray(cursor.DocumentIDs)
+	if err != nil {
+		return err
+	}
+	relations, err := jsonArray(cursor.Relations)
+	if err != nil {
+		return err
+	}
+	cursor.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+
+	_, err = c.db.Exec(`INSERT INTO ingestion_cursors (tenant_id, source, object_key, version, content_hash, document_ids, relations, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (tenant_id, source, object_key) DO UPDATE SET
+			version = excluded.version, content_hash = excluded.content_hash, document_ids = excluded.document_ids,
+			relations = excluded.relations, updated_at = excluded.updated_at`,
+		c.tenantID, cursor.Source, cursor.Key, cursor.Version, cursor.ContentHash, documentIDs, relations, cursor.UpdatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to store ingestion cursor: %w", err)
+	}
+	return nil
+}
+
+// DeleteCursor removes the cursor of an object in the tenant
+func (c *SQLiteCursorStore) DeleteCursor(source, key string) error {
+	if _, err := c.db.Exec(`DELETE FROM ingestion_cursors WHERE tenant_id = ? AND source = ? AND object_key = ?`,
+		c.tenantID, source, key); err != nil {
+		return fmt.Errorf("failed to delete ingestion cursor: %w", err)
+	}
+	return nil
+}
+
+// jsonArray encodes a slice as JSON, encoding nil as an empty array
+func jsonArray[T any](values []T) (string, error) {
+	if values == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(values)
+	return string(data), err
+}
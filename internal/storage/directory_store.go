@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+)
+
+// ErrDirectoryUserNotFound is returned when a directory user does not exist
+var ErrDirectoryUserNotFound = errors.New("directory user not found")
+
+// ErrDirectoryGroupNotFound is returned when a directory group does not exist
+var ErrDirectoryGroupNotFound = errors.New("directory group not found")
+
+// DirectoryStore persists the users and groups of the user directory
+type DirectoryStore interface {
+	// PutUser creates or replaces the user and its group memberships in the
+	// tenant and reports whether it was created. Every group must exist,
+	// otherwise ErrDirectoryGroupNotFound is returned.
+	PutUser(user *models.DirectoryUser) (bool, error)
+	// GetUser returns the user or ErrDirectoryUserNotFound
+	GetUser(username string) (*models.DirectoryUser, error)
+	// ListUsers returns the tenant's users sorted by username
+	ListUsers() ([]models.DirectoryUser, error)
+	// DeleteUser deletes the user and its memberships and returns it, or
+	// returns ErrDirectoryUserNotFound
+	DeleteUser(username string) (*models.DirectoryUser, error)
+	// PutGroup creates the group or updates its description and reports
+	// whether it was created
+	PutGroup(group *models.DirectoryGroup) (bool, error)
+	// GetGroup returns the group with its members or ErrDirectoryGroupNotFound
+	GetGroup(name string) (*models.DirectoryGroup, error)
+	// ListGroups returns the tenant's groups sorted by name
+	ListGroups() ([]models.DirectoryGroup, error)
+	// DeleteGroup deletes the group and its memberships and returns it, or
+	// returns ErrDirectoryGroupNotFound
+	DeleteGroup(name string) (*models.DirectoryGroup, error)
+	// ForTenant returns a view of the store restricted to tenantID
+	ForTenant(tenantID string) DirectoryStore
+}
+
+// SQLiteDirectoryStore stores the user directory in the vector store's SQLite database
+type SQLiteDirectoryStore struct {
+	db       *sql.DB
+	tenantID string
+}
+
+// NewSQLiteDirectoryStore creates the directory tables in the database backing store
+func NewSQLiteDirectoryStore(store *SQLiteVectorStore) (*SQLiteDirectoryStore, error) {
+	s := &SQLiteDirectoryStore{
+		db:       store.db,
+		tenantID: tenant.Default,
+	}
+
+	for _, ddl := range []string{
+		`CREATE TABLE IF NOT EXISTS directory_users (
+			tenant_id TEXT NOT NULL,
+			username TEXT NOT NULL,
+			display_name TEXT NOT NULL DEFAULT '',
+			roles TEXT NOT NULL DEFAULT '[]',
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY (tenant_id, username)
+		)`,
+		`CREATE TABLE IF NOT EXISTS directory_groups (
+			tenant_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY (tenant_id, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS directory_members (
+			tenant_id TEXT NOT NULL,
+			group_name TEXT NOT NULL,
+			username TEXT NOT NULL,
+			PRIMARY KEY (tenant_id, group_name, username)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_directory_members_username ON directory_members(tenant_id, username)`,
+	} {
+		if _, err := s.db.Exec(ddl); err != nil {
+			return nil, fmt.Errorf("failed to create directory tables: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// ForTenant returns a view of the store whose reads and writes are restricted to tenantID
+func (s *SQLiteDirectoryStore) ForTenant(tenantID string) DirectoryStore {
+	scoped := *s
+	scoped.tenantID = tenantID
+	return &scoped
+}
+
+// PutUser creates or replaces the user in one transaction
+func (s *SQLiteDirectoryStore) PutUser(user *models.DirectoryUser) (bool, error) {
+	roles, err := json.Marshal(user.Roles)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, group := range user.Groups {
+		var exists int
+		err := tx.QueryRow(`SELECT 1 FROM directory_groups WHERE tenant_id = ? AND name = ?`, s.tenantID, group).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("%w: %s", ErrDirectoryGroupNotFound, group)
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to look up directory group: %w", err)
+		}
+	}
+
+	var createdAt int64
+	err = tx.QueryRow(`SELECT created_at FROM directory_users WHERE tenant_id = ? AND username = ?`, s.tenantID, user.Username).Scan(&createdAt)
+	created := errors.Is(err, sql.ErrNoRows)
+	if err != nil && !created {
+		return false, fmt.Errorf("failed to look up directory user: %w", err)
+	}
+	if created {
+		createdAt = now.Unix()
+	}
+
+	_, err = tx.Exec(`INSERT INTO directory_users (tenant_id, username, display_name, roles, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (tenant_id, username) DO UPDATE SET display_name = excluded.display_name, roles = excluded.roles, updated_at = excluded.updated_at`,
+		s.tenantID, user.Username, user.DisplayName, string(roles), createdAt, now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to store directory user: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM directory_members WHERE tenant_id = ? AND username = ?`, s.tenantID, user.Username); err != nil {
+		return false, fmt.Errorf("failed to store directory memberships: %w", err)
+	}
+	for _, group := range user.Groups {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO directory_members (tenant_id, group_name, username) VALUES (?, ?, ?)`, s.tenantID, group, user.Username); err != nil {
+			return false, fmt.Errorf("failed to store directory memberships: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit directory user: %w", err)
+	}
+
+	user.CreatedAt = time.Unix(createdAt, 0).UTC()
+	user.UpdatedAt = now
+	return created, nil
+}
+
+// GetUser returns the user with its groups
+func (s *SQLiteDirectoryStore) GetUser(username string) (*models.DirectoryUser, error) {
+	users, err := s.users(`AND username = ?`, username)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrDirectoryUserNotFound
+	}
+	return &users[0], nil
+}
+
+// ListUsers returns the tenant's users with their groups
+func (s *SQLiteDirectoryStore) ListUsers() ([]models.DirectoryUser, error) {
+	return s.users("")
+}
+
+// DeleteUser deletes the user and its memberships
+func (s *SQLiteDirectoryStore) DeleteUser(username string) (*models.DirectoryUser, error) {
+	user, err := s.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`DELETE FROM directory_members WHERE tenant_id = ? AND username = ?`, s.tenantID, username); err != nil {
+		return nil, fmt.Errorf("failed to delete directory memberships: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM directory_users WHERE tenant_id = ? AND username = ?`, s.tenantID, username); err != nil {
+		return nil, fmt.Errorf("failed to delete directory user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit directory user deletion: %w", err)
+	}
+	return user, nil
+}
+
+// users returns the tenant's users matching the SQL condition, sorted by username
+func (s *SQLiteDirectoryStore) users(condition string, args ...any) ([]models.DirectoryUser, error) {
+	rows, err := s.db.Query(`SELECT username, display_name, roles, created_at, updated_at FROM directory_users WHERE tenant_id = ? `+condition+` ORDER BY username`,
+		append([]any{s.tenantID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory users: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	users := []models.DirectoryUser{}
+	for rows.Next() {
+		var user models.DirectoryUser
+		var roles string
+		var createdAt, updatedAt int64
+		if err := rows.Scan(&user.Username, &user.DisplayName, &roles, &createdAt, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan directory user: %w", err)
+		}
+		if err := json.Unmarshal([]byte(roles), &user.Roles); err != nil {
+			return nil, fmt.Errorf("failed to decode directory user roles: %w", err)
+		}
+		if user.Roles == nil {
+			user.Roles = []string{}
+		}
+		user.CreatedAt = time.Unix(createdAt, 0).UTC()
+		user.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating directory users: %w", err)
+	}
+
+	for i := range users {
+		if users[i].Groups, err = s.column(`SELECT group_name FROM directory_members WHERE tenant_id = ? AND username = ? ORDER BY group_name`, users[i].Username); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// PutGroup creates the group or updates its description
+func (s *SQLiteDirectoryStore) PutGroup(group *models.DirectoryGroup) (bool, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	result, err := s.db.Exec(`INSERT INTO directory_groups (tenant_id, name, description, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (tenant_id, name) DO NOTHING`, s.tenantID, group.Name, group.Description, now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to store directory group: %w", err)
+	}
+	created, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to store directory group: %w", err)
+	}
+	if created == 0 {
+		if _, err := s.db.Exec(`UPDATE directory_groups SET description = ? WHERE tenant_id = ? AND name = ?`, group.Description, s.tenantID, group.Name); err != nil {
+			return false, fmt.Errorf("failed to store directory group: %w", err)
+		}
+	}
+
+	stored, err := s.GetGroup(group.Name)
+	if err != nil {
+		return false, err
+	}
+	*group = *stored
+	return created > 0, nil
+}
+
+// GetGroup returns the group with its members
+func (s *SQLiteDirectoryStore) GetGroup(name string) (*models.DirectoryGroup, error) {
+	groups, err := s.groups(`AND name = ?`, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, ErrDirectoryGroupNotFound
+	}
+	return &groups[0], nil
+}
+
+// ListGroups returns the tenant's groups with their members
+func (s *SQLiteDirectoryStore) ListGroups() ([]models.DirectoryGroup, error) {
+	return s.groups("")
+}
+
+// DeleteGroup deletes the group and its memberships
+func (s *SQLiteDirectoryStore) DeleteGroup(name string) (*models.DirectoryGroup, error) {
+	group, err := s.GetGroup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`DELETE FROM directory_members WHERE tenant_id = ? AND group_name = ?`, s.tenantID, name); err != nil {
+		return nil, fmt.Errorf("failed to delete directory memberships: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM directory_groups WHERE tenant_id = ? AND name = ?`, s.tenantID, name); err != nil {
+		return nil, fmt.Errorf("failed to delete directory group: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit directory group deletion: %w", err)
+	}
+	return group, nil
+}
+
+// groups returns the tenant's groups matching the SQL condition, sorted by name
+func (s *SQLiteDirectoryStore) groups(condition string, args ...any) ([]models.DirectoryGroup, error) {
+	rows, err := s.db.Query(`SELECT name, description, created_at FROM directory_groups WHERE tenant_id = ? `+condition+` ORDER BY name`,
+		append([]any{s.tenantID}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory groups: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	groups := []models.DirectoryGroup{}
+	for rows.Next() {
+		var group models.DirectoryGroup
+		var createdAt int64
+		if err := rows.Scan(&group.Name, &group.Description, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan directory group: %w", err)
+		}
+		group.CreatedAt = time.Unix(createdAt, 0).UTC()
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating directory groups: %w", err)
+	}
+
+	for i := range groups {
+		if groups[i].Members, err = s.column(`SELECT username FROM directory_members WHERE tenant_id = ? AND group_name = ? ORDER BY username`, groups[i].Name); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// column returns the strings selected by query for the tenant and arg
+func (s *SQLiteDirectoryStore) column(query, arg string) ([]string, error) {
+	rows, err := s.db.Query(query, s.tenantID, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory memberships: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to scan directory membership: %w", err)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating directory memberships: %w", err)
+	}
+	return values, nil
+}
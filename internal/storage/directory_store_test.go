@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"testing"
+)
+
+func TestSQLiteDirectoryStore(t *testing.T) {
+	store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "directory.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	users, err := NewSQLiteDirectoryStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create directory store: %v", err)
+	}
+	acme := users.ForTenant("acme")
+
+	// Users can only join existing groups
+	alice := &models.DirectoryUser{Username: "alice", Groups: []string{"advisors"}, Roles: []string{"advisor"}}
+	if _, err := acme.PutUser(alice); !errors.Is(err, ErrDirectoryGroupNotFound) {
+		t.Fatalf("Expected ErrDirectoryGroupNotFound, got %v", err)
+	}
+	if _, err := acme.GetUser("alice"); !errors.Is(err, ErrDirectoryUserNotFound) {
+		t.Fatalf("Expected the rejected user not to be stored, got %v", err)
+	}
+
+	created, err := acme.PutGroup(&models.DirectoryGroup{Name: "advisors", Description: "Tax advisors"})
+	if err != nil || !created {
+		t.Fatalf("Failed to create group: %t (%v)", created, err)
+	}
+	created, err = acme.PutUser(alice)
+	if err != nil || !created || alice.CreatedAt.IsZero() {
+		t.Fatalf("Failed to create user: %+v %t (%v)", alice, created, err)
+	}
+
+	// Replacing the user keeps its creation time and replaces its groups
+	alice.DisplayName = "Alice Adams"
+	alice.Groups = nil
+	created, err = acme.PutUser(alice)
+	if err != nil || created {
+		t.Fatalf("Expected the user to be replaced, got %t (%v)", created, err)
+	}
+	found, err := acme.GetUser("alice")
+	if err != nil || found.DisplayName != "Alice Adams" || len(found.Groups) != 0 || !slices.Equal(found.Roles, []string{"advisor"}) {
+		t.Fatalf("Unexpected user %+v (%v)", found, err)
+	}
+
+	alice.Groups = []string{"advisors"}
+	if _, err := acme.PutUser(alice); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	group, err := acme.GetGroup("advisors")
+	if err != nil || group.Description != "Tax advisors" || !slices.Equal(group.Members, []string{"alice"}) {
+		t.Fatalf("Unexpected group %+v (%v)", group, err)
+	}
+
+	// Tenants do not see each other's directory
+	if listed, err := users.ListUsers(); err != nil || len(listed) != 0 {
+		t.Errorf("Expected no users in the default tenant, got %v (%v)", listed, err)
+	}
+	if _, err := users.DeleteGroup("advisors"); !errors.Is(err, ErrDirectoryGroupNotFound) {
+		t.Errorf("Expected deleting another tenant's group to fail, got %v", err)
+	}
+
+	// Deleting a group removes its memberships
+	deleted, err := acme.DeleteGroup("advisors")
+	if err != nil || !slices.Equal(deleted.Members, []string{"alice"}) {
+		t.Fatalf("Failed to delete group: %+v (%v)", deleted, err)
+	}
+	if found, err := acme.GetUser("alice"); err != nil || len(found.Groups) != 0 {
+		t.Errorf("Expected alice to have no groups, got %+v (%v)", found, err)
+	}
+
+	if _, err := acme.DeleteUser("alice"); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if _, err := acme.DeleteUser("alice"); !errors.Is(err, ErrDirectoryUserNotFound) {
+		t.Errorf("Expected ErrDirectoryUserNotFound, got %v", err)
+	}
+}
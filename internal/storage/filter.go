@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"cmp"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"strings"
+)
+
+// ValidateFilters checks that metadata filters name simple identifiers and
+// hold conditions that can be evaluated: equality with a string, number, or
+// boolean, or a range bounded by strings or numbers
+func ValidateFilters(filters map[string]models.MetadataFilter) error {
+	for key, filter := range filters {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid metadata filter key: %s", key)
+		}
+
+		bounds := filterBounds(filter)
+		if filter.Eq == nil && len(bounds) == 0 {
+			return fmt.Errorf("metadata filter %s has no condition", key)
+		}
+		if filter.Eq != nil && len(bounds) > 0 {
+			return fmt.Errorf("metadata filter %s cannot combine eq with a range", key)
+		}
+		switch filter.Eq.(type) {
+		case nil, string, float64, bool:
+		default:
+			return fmt.Errorf("metadata filter %s must compare with a string, number, or boolean", key)
+		}
+		for _, b := range bounds {
+			switch b.value.(type) {
+			case string, float64:
+			default:
+				return fmt.Errorf("metadata filter %s range must be bounded by strings or numbers", key)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchesFilters reports whether the metadata of doc satisfies every filter.
+// A list value matches if any of its elements does.
+func MatchesFilters(doc *models.Document, filters map[string]models.MetadataFilter) bool {
+	for key, filter := range filters {
+		value, ok := doc.Metadata[key]
+		if !ok {
+			return false
+		}
+		values, isList := value.([]interface{})
+		if !isList {
+			values = []interface{}{value}
+		}
+		if !slices.ContainsFunc(values, func(v interface{}) bool { return matchesFilter(filter, v) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// WithFilters wraps filter so that candidates not matching the metadata
+// filters are rejected before it evaluates them, sparing permission checks
+// on documents the caller did not ask for
+func WithFilters(filters map[string]models.MetadataFilter, filter BatchFilter) BatchFilter {
+	if len(filters) == 0 {
+		return filter
+	}
+	return prefilter(func(doc *models.Document) bool { return MatchesFilters(doc, filters) }, filter)
+}
+
+// bound is a range operator of a MetadataFilter with its value
+type bound struct {
+	value interface{}
+	holds func(order int) bool // whether the order of a metadata value relative to value satisfies the operator
+}
+
+// filterBounds returns the range operators set on filter
+func filterBounds(filter models.MetadataFilter) []bound {
+	var bounds []bound
+	add := func(value interface{}, holds func(int) bool) {
+		if value != nil {
+			bounds = append(bounds, bound{value: value, holds: holds})
+		}
+	}
+	add(filter.Gt, func(order int) bool { return order > 0 })
+	add(filter.Gte, func(order int) bool { return order >= 0 })
+	add(filter.Lt, func(order int) bool { return order < 0 })
+	add(filter.Lte, func(order int) bool { return order <= 0 })
+	return bounds
+}
+
+// matchesFilter reports whether a single metadata value satisfies filter
+func matchesFilter(filter models.MetadataFilter, value interface{}) bool {
+	if filter.Eq != nil {
+		order, ok := compareValues(value, filter.Eq)
+		return ok && order == 0
+	}
+	for _, b := range filterBounds(filter) {
+		if order, ok := compareValues(value, b.value); !ok || !b.holds(order) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues orders two metadata values of the same kind; values of
+// different kinds are not comparable
+func compareValues(a, b interface{}) (int, bool) {
+	switch b := b.(type) {
+	case string:
+		if a, ok := a.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case float64:
+		if a, ok := number(a); ok {
+			return cmp.Compare(a, b), true
+		}
+	case bool:
+		// Booleans are only compared for equality
+		if a, ok := a.(bool); ok {
+			if a == b {
+				return 0, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// number converts the numeric types metadata may hold to float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// usableGrants returns the grants with values on keys that are simple
+// identifiers
+func usableGrants(grants []MetadataGrant) []MetadataGrant {
+	var usable []MetadataGrant
+	for _, grant := range grants {
+		if len(grant.Values) > 0 && metadataKeyPattern.MatchString(grant.Key) {
+			usable = append(usable, grant)
+		}
+	}
+	return usable
+}
+
+// matchesGrants reports whether a grant selects doc. Only string values
+// match, so a list or number is decided by the access filter.
+func matchesGrants(doc *models.Document, grants []MetadataGrant) bool {
+	for _, grant := range grants {
+		if value, ok := doc.Metadata[grant.Key].(string); ok && slices.Contains(grant.Values, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// withGrants returns a filter that accepts the candidates matching filters
+// that a grant selects and evaluates access on the other candidates matching
+// filters
+func withGrants(grants []MetadataGrant, filters map[string]models.MetadataFilter, access BatchFilter) BatchFilter {
+	others := prefilter(func(doc *models.Document) bool { return !matchesGrants(doc, grants) }, access)
+	return WithFilters(filters, func(docs []models.Document) []bool {
+		allowed := others(docs)
+		for i := range docs {
+			if matchesGrants(&docs[i], grants) {
+				allowed[i] = true
+			}
+		}
+		return allowed
+	})
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+)
+
+func TestMatchesFilters(t *testing.T) {
+	doc := &models.Document{Metadata: map[string]interface{}{
+		"form":      "1040",
+		"year":      2023,
+		"filed":     "2024-04-15",
+		"amended":   false,
+		"taxpayers": []interface{}{"John Doe", "Jane Doe"},
+	}}
+
+	tests := []struct {
+		name    string
+		filters map[string]models.MetadataFilter
+		want    bool
+	}{
+		{"equal string", map[string]models.MetadataFilter{"form": {Eq: "1040"}}, true},
+		{"other string", map[string]models.MetadataFilter{"form": {Eq: "W-2"}}, false},
+		{"equal number", map[string]models.MetadataFilter{"year": {Eq: 2023.0}}, true},
+		{"number as string", map[string]models.MetadataFilter{"year": {Eq: "2023"}}, false},
+		{"equal boolean", map[string]models.MetadataFilter{"amended": {Eq: false}}, true},
+		{"numeric range", map[string]models.MetadataFilter{"year": {Gte: 2023.0, Lt: 2024.0}}, true},
+		{"outside range", map[string]models.MetadataFilter{"year": {Gt: 2023.0}}, false},
+		{"date range", map[string]models.MetadataFilter{"filed": {Gte: "2024-01-01", Lte: "2024-12-31"}}, true},
+		{"list element", map[string]models.MetadataFilter{"taxpayers": {Eq: "Jane Doe"}}, true},
+		{"missing key", map[string]models.MetadataFilter{"state": {Eq: "CA"}}, false},
+		{"all conditions", map[string]models.MetadataFilter{"form": {Eq: "1040"}, "year": {Lt: 2023.0}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchesFilters(doc, tt.filters); got != tt.want {
+				t.Errorf("Expected %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWithFiltersSparesPermissionChecks(t *testing.T) {
+	docs := []models.Document{
+		{Title: "2023", Metadata: map[string]interface{}{"year": 2023.0}},
+		{Title: "2022", Metadata: map[string]interface{}{"year": 2022.0}},
+	}
+	var checked []string
+	filter := WithFilters(map[string]models.MetadataFilter{"year": {Eq: 2023.0}}, func(docs []models.Document) []bool {
+		allowed := make([]bool, len(docs))
+		for i, doc := range docs {
+			checked = append(checked, doc.Title)
+			allowed[i] = true
+		}
+		return allowed
+	})
+
+	allowed := filter(docs)
+	if !allowed[0] || allowed[1] {
+		t.Errorf("Expected only the 2023 document to pass, got %v", allowed)
+	}
+	if len(checked) != 1 || checked[0] != "2023" {
+		t.Errorf("Expected only the matching document to be checked, got %v", checked)
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	valid := map[string]models.MetadataFilter{"form": {Eq: "1040"}, "year": {Gte: 2020.0, Lte: 2023.0}}
+	if err := ValidateFilters(valid); err != nil {
+		t.Errorf("Expected valid filters, got %v", err)
+	}
+
+	for name, filters := range map[string]map[string]models.MetadataFilter{
+		"key":        {"form'": {Eq: "1040"}},
+		"empty":      {"form": {}},
+		"eq range":   {"year": {Eq: 2023.0, Lt: 2024.0}},
+		"bool range": {"amended": {Gt: false}},
+		"list value": {"form": {Eq: []interface{}{"1040"}}},
+	} {
+		if err := ValidateFilters(filters); err == nil {
+			t.Errorf("Expected an error for an invalid %s", name)
+		}
+	}
+}
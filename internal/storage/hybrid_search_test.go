@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"math"
+	"os"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFuseRankings(t *testing.T) {
+	a := models.Document{ID: uuid.New(), Title: "a"}
+	b := models.Document{ID: uuid.New(), Title: "b"}
+	c := models.Document{ID: uuid.New(), Title: "c"}
+
+	// b ranks second in both lists and must beat documents found by only one
+	fused := FuseRankings([]models.Document{a, b}, []models.Document{c, b}, DefaultHybridOptions)
+	if len(fused) != 3 || fused[0].ID != b.ID || fused[1].ID != a.ID || fused[2].ID != c.ID {
+		t.Errorf("Expected order b, a, c; got %v, %v, %v", fused[0].Title, fused[1].Title, fused[2].Title)
+	}
+
+	// Keyword-only weighting follows the keyword ranking
+	fused = FuseRankings([]models.Document{a, b}, []models.Document{c, b}, HybridOptions{KeywordWeight: 1, RRFK: 60})
+	if fused[0].ID != c.ID || fused[1].ID != b.ID {
+		t.Errorf("Expected keyword ranking c, b first; got %v, %v", fused[0].Title, fused[1].Title)
+	}
+}
+
+func TestFTSMatchExpression(t *testing.T) {
+	if got := ftsMatchExpression(`What's on form "1120"?`); got != `"What" OR "s" OR "on" OR "form" OR "1120"` {
+		t.Errorf("Unexpected match expression: %s", got)
+	}
+	if got := ftsMatchExpression("?!"); got != "" {
+		t.Errorf("Expected empty expression for punctuation only, got %s", got)
+	}
+}
+
+// TestHybridSearchFindsExactTerms checks that keyword matches surface documents
+// the vector ranking places last. Requires building with -tags sqlite_fts5.
+func TestHybridSearchFindsExactTerms(t *testing.T) {
+	dbPath := "./test_hybrid_search.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	if !store.ftsEnabled {
+		t.Skip("FTS5 not available; run with -tags sqlite_fts5")
+	}
+
+	query := []float32{1, 0, 0}
+	docs := []*models.Document{
+		{Title: "Close", Content: "Individual income tax return", Embedding: []float32{1, 0, 0}},
+		{Title: "Closer", Content: "Amended individual return", Embedding: []float32{0.9, 0.1, 0}},
+		{Title: "Far", Content: "Form 1120 corporate income tax", Embedding: []float32{0, 0, 1}},
+	}
+	for _, doc := range docs {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+	allowAll := perDocumentFilter(func(*models.Document) bool { return true })
+
+	vectorOnly, err := store.SearchSimilarWithBatchFilter(query, 2, allowAll)
+	if err != nil {
+		t.Fatalf("Vector search failed: %v", err)
+	}
+	for _, doc := range vectorOnly {
+		if doc.Title == "Far" {
+			t.Fatal("Expected vector search alone to miss the form 1120 document")
+		}
+	}
+
+	hybrid, err := store.SearchHybridWithBatchFilter(query, "form 1120", 2, allowAll, DefaultHybridOptions)
+	if err != nil {
+		t.Fatalf("Hybrid search failed: %v", err)
+	}
+	if len(hybrid) != 2 || hybrid[0].Title != "Far" {
+		t.Fatalf("Expected the form 1120 document first, got %+v", hybrid)
+	}
+	// Keyword hits carry their vector distance so they can be scored like vector hits
+	if hits, _ := store.searchWithFTS("1120", query, 10); len(hits) != 1 || math.Abs(hits[0].Distance-math.Sqrt2) > 1e-6 {
+		t.Errorf("Expected one keyword hit at distance sqrt(2), got %+v", hits)
+	}
+
+	// Keyword hits stay tenant-scoped and follow content updates
+	if results, _ := store.ForTenant("other").SearchHybridWithBatchFilter(query, "1120", 2, allowAll, DefaultHybridOptions); len(results) != 0 {
+		t.Errorf("Expected no results for another tenant, got %d", len(results))
+	}
+	if err := store.UpdateDocument(&models.Document{ID: docs[2].ID, Title: "Far", Content: "Corporate income tax"}); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	if hits, _ := store.searchWithFTS("1120", query, 10); len(hits) != 0 {
+		t.Errorf("Expected updated content to be reindexed, still found %d hits", len(hits))
+	}
+}
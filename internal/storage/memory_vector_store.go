@@ -0,0 +1,813 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// InMemoryVectorStore keeps documents in memory and searches them by brute
+// force, comparing the query with every document of the tenant. It needs
+// neither cgo nor sqlite-vec and suits tests and small corpora. Distances are
+// cosine distances (1 - cosine similarity) rather than the L2 distances of
+// SQLiteVectorStore.
+type InMemoryVectorStore struct {
+	data     *memoryData // shared by all tenant views
+	tenantID string
+}
+
+// memoryData holds the documents of all tenants
+type memoryData struct {
+	mu   sync.RWMutex
+	docs map[uuid.UUID]*models.Document // stored copies including embeddings
+	path string                         // JSON file written after every change; empty keeps documents in memory only
+	// chunkScore combines the chunk distances of a document: ChunkScoreMax
+	// or ChunkScoreMean
+	chunkScore string
+}
+
+// memoryRecord is a persisted document; models.Document does not serialize
+// its embedding and chunks
+type memoryRecord struct {
+	models.Document
+	Embedding []float32              `json:"embedding"`
+	Chunks    []models.DocumentChunk `json:"chunks,omitempty"`
+}
+
+// NewInMemoryVectorStore creates an in-memory store. With a non-empty path the
+// documents are loaded from and saved to that JSON file.
+func NewInMemoryVectorStore(path string) (*InMemoryVectorStore, error) {
+	data := &memoryData{docs: make(map[uuid.UUID]*models.Document), path: path}
+	if path != "" {
+		if err := data.load(); err != nil {
+			return nil, err
+		}
+	}
+	return &InMemoryVectorStore{data: data, tenantID: tenant.Default}, nil
+}
+
+// load reads the persisted documents; a missing file is an empty store
+func (d *memoryData) load() error {
+	raw, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", d.path, err)
+	}
+
+	var records []memoryRecord
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", d.path, err)
+	}
+	for _, record := range records {
+		doc := record.Document
+		doc.Embedding = record.Embedding
+		doc.Chunks = record.Chunks
+		d.docs[doc.ID] = &doc
+	}
+	return nil
+}
+
+// save writes all documents to the JSON file, replacing it atomically. The
+// caller must hold the write lock.
+func (d *memoryData) save() error {
+	if d.path == "" {
+		return nil
+	}
+
+	records := make([]memoryRecord, 0, len(d.docs))
+	for _, doc := range d.docs {
+		records = append(records, memoryRecord{Document: *doc, Embedding: doc.Embedding, Chunks: doc.Chunks})
+	}
+	slices.SortFunc(records, func(a, b memoryRecord) int { return strings.Compare(a.ID.String(), b.ID.String()) })
+	raw, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to encode documents: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save documents: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to save documents: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save documents: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		return fmt.Errorf("failed to save documents: %w", err)
+	}
+	return nil
+}
+
+// Stats reports the dimensions of the stored vectors and the size of the
+// JSON file; the embedding model is not recorded
+func (s *InMemoryVectorStore) Stats() (StoreStats, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+	var stats StoreStats
+	for _, doc := range s.data.docs {
+		if len(doc.Embedding) > 0 {
+			stats.EmbeddingDimensions = len(doc.Embedding)
+			break
+		}
+	}
+	if s.data.path != "" {
+		info, err := os.Stat(s.data.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return StoreStats{}, fmt.Errorf("failed to measure %s: %w", s.data.path, err)
+		}
+		if err == nil {
+			stats.SizeBytes = info.Size()
+		}
+	}
+	return stats, nil
+}
+
+// ForTenant returns a view of the store scoped to the given tenant
+func (s *InMemoryVectorStore) ForTenant(tenantID string) VectorStore {
+	return &InMemoryVectorStore{data: s.data, tenantID: tenantID}
+}
+
+// SetChunkScore sets how the distances of a document's chunks combine into
+// its search distance: ChunkScoreMax (the default) or ChunkScoreMean
+func (s *InMemoryVectorStore) SetChunkScore(mode string) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	s.data.chunkScore = mode
+}
+
+// stored returns a copy of a stored document without its embedding and chunks
+func stored(doc *models.Document) models.Document {
+	found := *doc
+	found.Metadata = maps.Clone(doc.Metadata)
+	found.Embedding = nil
+	found.Chunks = nil
+	return found
+}
+
+// cloneChunks returns a deep copy of chunks
+func cloneChunks(chunks []models.DocumentChunk) []models.DocumentChunk {
+	if chunks == nil {
+		return nil
+	}
+	cloned := make([]models.DocumentChunk, len(chunks))
+	for i, chunk := range chunks {
+		cloned[i] = models.DocumentChunk{Start: chunk.Start, End: chunk.End, Embedding: slices.Clone(chunk.Embedding)}
+	}
+	return cloned
+}
+
+// AddDocument stores a new document with its embedding
+func (s *InMemoryVectorStore) AddDocument(doc *models.Document) error {
+	if doc.ID == uuid.Nil {
+		doc.ID = uuid.New()
+	}
+	if err := ValidateChunks(doc); err != nil {
+		return err
+	}
+
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	if _, ok := s.data.docs[doc.ID]; ok {
+		return fmt.Errorf("document %s already exists", doc.ID)
+	}
+	doc.TenantID = s.tenantID
+	doc.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	return s.put(doc)
+}
+
+// UpsertDocument inserts or replaces a document with its embedding
+func (s *InMemoryVectorStore) UpsertDocument(doc *models.Document) error {
+	if doc.ID == uuid.Nil {
+		doc.ID = uuid.New()
+	}
+	if err := ValidateChunks(doc); err != nil {
+		return err
+	}
+
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	doc.TenantID = s.tenantID
+	doc.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	if existing, ok := s.data.docs[doc.ID]; ok {
+		if existing.TenantID != s.tenantID {
+			return fmt.Errorf("document %s belongs to another tenant", doc.ID)
+		}
+		doc.CreatedAt = existing.CreatedAt
+	}
+	return s.put(doc)
+}
+
+// put stores a copy of doc and saves the store. The caller must hold the write lock.
+func (s *InMemoryVectorStore) put(doc *models.Document) error {
+	copied := *doc
+	copied.Distance = 0
+	copied.Metadata = maps.Clone(doc.Metadata)
+	copied.Embedding = slices.Clone(doc.Embedding)
+	copied.Chunks = cloneChunks(doc.Chunks)
+	copied.Match = nil
+	previous := s.data.docs[doc.ID]
+	s.data.docs[doc.ID] = &copied
+	if err := s.data.save(); err != nil {
+		if previous != nil {
+			s.data.docs[doc.ID] = previous
+		} else {
+			delete(s.data.docs, doc.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// live reports whether doc belongs to the tenant and is not trashed
+func (s *InMemoryVectorStore) live(doc *models.Document) bool {
+	return doc.TenantID == s.tenantID && doc.DeletedAt == nil
+}
+
+// GetDocument returns a single document of the tenant by ID
+func (s *InMemoryVectorStore) GetDocument(id uuid.UUID) (*models.Document, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+	doc, ok := s.data.docs[id]
+	if !ok || !s.live(doc) {
+		return nil, ErrDocumentNotFound
+	}
+	found := stored(doc)
+	return &found, nil
+}
+
+// UpdateDocument updates an existing document of the tenant. The stored vector
+// is only replaced when doc carries a new embedding, and then its chunks with
+// doc's chunks.
+func (s *InMemoryVectorStore) UpdateDocument(doc *models.Document) error {
+	if err := ValidateChunks(doc); err != nil {
+		return err
+	}
+
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	existing, ok := s.data.docs[doc.ID]
+	if !ok || !s.live(existing) {
+		return ErrDocumentNotFound
+	}
+
+	updated := *doc
+	updated.TenantID = s.tenantID
+	updated.CreatedAt = existing.CreatedAt
+	if len(updated.Embedding) == 0 {
+		updated.Embedding = existing.Embedding
+		updated.Chunks = existing.Chunks
+	}
+	if err := s.put(&updated); err != nil {
+		return err
+	}
+	doc.TenantID = s.tenantID
+	return nil
+}
+
+// DeleteDocument removes a document of the tenant
+func (s *InMemoryVectorStore) DeleteDocument(id uuid.UUID) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	existing, ok := s.data.docs[id]
+	if !ok || existing.TenantID != s.tenantID {
+		return ErrDocumentNotFound
+	}
+
+	delete(s.data.docs, id)
+	if err := s.data.save(); err != nil {
+		s.data.docs[id] = existing
+		return err
+	}
+	return nil
+}
+
+// TrashDocument moves a document of the tenant to the trash
+func (s *InMemoryVectorStore) TrashDocument(id uuid.UUID) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	existing, ok := s.data.docs[id]
+	if !ok || !s.live(existing) {
+		return ErrDocumentNotFound
+	}
+
+	trashed := *existing
+	deletedAt := time.Now().UTC().Truncate(time.Second)
+	trashed.DeletedAt = &deletedAt
+	return s.put(&trashed)
+}
+
+// RestoreDocument moves a trashed document of the tenant back
+func (s *InMemoryVectorStore) RestoreDocument(id uuid.UUID) error {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	existing, ok := s.data.docs[id]
+	if !ok || existing.TenantID != s.tenantID || existing.DeletedAt == nil {
+		return ErrDocumentNotFound
+	}
+
+	restored := *existing
+	restored.DeletedAt = nil
+	return s.put(&restored)
+}
+
+// PurgeTrash permanently deletes the documents of all tenants trashed before cutoff
+func (s *InMemoryVectorStore) PurgeTrash(cutoff time.Time) ([]models.Document, error) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+
+	removed := make(map[uuid.UUID]*models.Document)
+	var purged []models.Document
+	for id, doc := range s.data.docs {
+		if doc.DeletedAt != nil && doc.DeletedAt.Before(cutoff) {
+			removed[id] = doc
+			purged = append(purged, stored(doc))
+			delete(s.data.docs, id)
+		}
+	}
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	if err := s.data.save(); err != nil {
+		maps.Copy(s.data.docs, removed)
+		return nil, err
+	}
+	return purged, nil
+}
+
+// Usage counts the tenant's live documents matching the metadata filters and
+// the bytes of their content
+func (s *InMemoryVectorStore) Usage(metadata map[string]string) (Usage, error) {
+	if err := ValidateMetadataFilter(metadata); err != nil {
+		return Usage{}, err
+	}
+
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+	var usage Usage
+	for _, doc := range s.data.docs {
+		if s.live(doc) && matchesMetadata(doc, metadata) {
+			usage.Documents++
+			usage.ContentBytes += int64(len(doc.Content))
+		}
+	}
+	return usage, nil
+}
+
+// Aggregate counts and sums the tenant's live documents matching opts by
+// group, like SQLiteVectorStore does in SQL
+func (s *InMemoryVectorStore) Aggregate(opts AggregateOptions) ([]AggregateGroup, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+	counted := make(map[string]bool)
+	groups := make(map[string]*AggregateGroup)
+	for _, id := range opts.IDs {
+		doc, ok := s.data.docs[id]
+		if !ok || !s.live(doc) || !matchesMetadata(doc, opts.Metadata) {
+			continue
+		}
+		key := id.String()
+		if value, ok := doc.Metadata[opts.DistinctKey]; ok && opts.DistinctKey != "" {
+			key = "key:" + metadataText(value)
+		}
+		if counted[key] {
+			continue
+		}
+		counted[key] = true
+
+		for _, value := range groupValues(doc.Metadata, opts.GroupBy) {
+			// SQLite extracts JSON booleans as integers
+			if b, ok := value.(bool); ok {
+				value = 0.0
+				if b {
+					value = 1.0
+				}
+			}
+			groupKey := fmt.Sprintf("%T:%s", value, metadataText(value))
+			group, ok := groups[groupKey]
+			if !ok {
+				group = &AggregateGroup{Value: value}
+				if len(opts.Sum) > 0 {
+					group.Sums = make(map[string]float64, len(opts.Sum))
+				}
+				groups[groupKey] = group
+			}
+			group.Documents++
+			for _, key := range opts.Sum {
+				if n, ok := doc.Metadata[key].(float64); ok {
+					group.Sums[key] += n
+				}
+			}
+		}
+	}
+
+	aggregated := make([]AggregateGroup, 0, len(groups))
+	for _, group := range groups {
+		aggregated = append(aggregated, *group)
+	}
+	slices.SortFunc(aggregated, func(a, b AggregateGroup) int {
+		return cmp.Or(cmp.Compare(b.Documents, a.Documents), strings.Compare(metadataText(a.Value), metadataText(b.Value)))
+	})
+	if len(aggregated) == 0 {
+		return nil, nil
+	}
+	return aggregated, nil
+}
+
+// groupValues returns the values of key in metadata a document is grouped
+// by: each element of a list, or the value itself. Documents without a value
+// or with an empty list are grouped under nil, as are all without key.
+func groupValues(metadata map[string]interface{}, key string) []interface{} {
+	if key == "" {
+		return []interface{}{nil}
+	}
+	value := metadata[key]
+	if values, ok := value.([]interface{}); ok {
+		if len(values) == 0 {
+			return []interface{}{nil}
+		}
+		return values
+	}
+	return []interface{}{value}
+}
+
+// ExpiredDocuments returns up to limit documents of all tenants that expired
+// under policy before now, oldest first
+func (s *InMemoryVectorStore) ExpiredDocuments(policy RetentionPolicy, now time.Time, limit int) ([]models.Document, error) {
+	s.data.mu.RLock()
+	var expired []models.Document
+	for _, doc := range s.data.docs {
+		if expiresAt, ok := policy.ExpiresAt(doc); ok && expiresAt.Before(now) {
+			expired = append(expired, stored(doc))
+		}
+	}
+	s.data.mu.RUnlock()
+
+	slices.SortFunc(expired, func(a, b models.Document) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID.String(), b.ID.String()))
+	})
+	return expired[:min(limit, len(expired))], nil
+}
+
+// Tenants returns the IDs of the tenants with live documents, sorted
+func (s *InMemoryVectorStore) Tenants() ([]string, error) {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+	var tenants []string
+	for _, doc := range s.data.docs {
+		if doc.DeletedAt == nil && !slices.Contains(tenants, doc.TenantID) {
+			tenants = append(tenants, doc.TenantID)
+		}
+	}
+	slices.Sort(tenants)
+	return tenants, nil
+}
+
+// Reindex re-embeds the documents of all tenants and their chunks and
+// replaces every embedding at once when all documents are covered
+func (s *InMemoryVectorStore) Reindex(ctx context.Context, embed EmbedFunc, progress func(done, total int)) error {
+	shadow := make(map[uuid.UUID]shadowVector)
+	for pass := 0; pass < maxReindexPasses; pass++ {
+		s.data.mu.RLock()
+		var pending []models.Document
+		for id, doc := range s.data.docs {
+			if shadow[id].hash != reindexHash(doc) {
+				found := stored(doc)
+				found.Chunks = cloneChunks(doc.Chunks)
+				pending = append(pending, found)
+			}
+		}
+		total := len(s.data.docs)
+		s.data.mu.RUnlock()
+
+		if len(pending) == 0 {
+			swapped, err := s.data.swapEmbeddings(shadow)
+			if err != nil || swapped {
+				return err
+			}
+			continue
+		}
+
+		done := total - len(pending)
+		for i := range pending {
+			doc := &pending[i]
+			embedding, err := embed(ctx, doc)
+			if err != nil {
+				return fmt.Errorf("failed to embed document %s: %w", doc.ID, err)
+			}
+			chunks := make([][]float32, len(doc.Chunks))
+			for j, chunk := range doc.Chunks {
+				passage := models.Document{ID: doc.ID, TenantID: doc.TenantID, Title: doc.Title, Content: doc.Content[chunk.Start:chunk.End]}
+				if chunks[j], err = embed(ctx, &passage); err != nil {
+					return fmt.Errorf("failed to embed chunk %d of document %s: %w", j, doc.ID, err)
+				}
+			}
+			shadow[doc.ID] = shadowVector{hash: reindexHash(doc), embedding: embedding, chunks: chunks}
+			done++
+			if progress != nil {
+				progress(done, total)
+			}
+		}
+	}
+	return fmt.Errorf("documents kept changing during %d reindex passes", maxReindexPasses)
+}
+
+// shadowVector is a new embedding computed by Reindex, the new embeddings of
+// the document's chunks, and the hash of the content and chunks they embed
+type shadowVector struct {
+	hash      string
+	embedding []float32
+	chunks    [][]float32
+}
+
+// swapEmbeddings replaces the embedding of every document with its shadow
+// vector if all documents are still covered by one. It reports false without
+// changes otherwise.
+func (d *memoryData) swapEmbeddings(shadow map[uuid.UUID]shadowVector) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, doc := range d.docs {
+		if shadow[id].hash != reindexHash(doc) {
+			return false, nil
+		}
+	}
+
+	previous := make(map[uuid.UUID]models.Document, len(d.docs))
+	for id, doc := range d.docs {
+		previous[id] = models.Document{Embedding: doc.Embedding, Chunks: doc.Chunks}
+		doc.Embedding = shadow[id].embedding
+		doc.Chunks = cloneChunks(doc.Chunks)
+		for i := range doc.Chunks {
+			doc.Chunks[i].Embedding = shadow[id].chunks[i]
+		}
+	}
+	if err := d.save(); err != nil {
+		for id, doc := range d.docs {
+			doc.Embedding, doc.Chunks = previous[id].Embedding, previous[id].Chunks
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0 if
+// their lengths differ or either is a zero vector
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// ranked returns the tenant's documents closest to embedding first, with
+// Distance set to the cosine distance. Documents with chunks are scored by
+// their chunks.
+func (s *InMemoryVectorStore) ranked(embedding []float32) []models.Document {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	var docs []models.Document
+	for _, doc := range s.data.docs {
+		if !s.live(doc) {
+			continue
+		}
+		found := stored(doc)
+		found.Distance = 1 - cosineSimilarity(embedding, doc.Embedding)
+		if len(doc.Chunks) > 0 {
+			spans := make([]models.Span, len(doc.Chunks))
+			distances := make([]float64, len(doc.Chunks))
+			for i, chunk := range doc.Chunks {
+				spans[i] = models.Span{Start: chunk.Start, End: chunk.End}
+				distances[i] = 1 - cosineSimilarity(embedding, chunk.Embedding)
+			}
+			scoreChunks(&found, spans, distances, s.data.chunkScore)
+		}
+		docs = append(docs, found)
+	}
+	slices.SortFunc(docs, func(a, b models.Document) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), strings.Compare(a.ID.String(), b.ID.String()))
+	})
+	return docs
+}
+
+// SearchSimilarWithFilter finds the top K most similar documents with an optional filter
+func (s *InMemoryVectorStore) SearchSimilarWithFilter(embedding []float32, topK int, filter func(*models.Document) bool) ([]models.Document, error) {
+	return s.SearchSimilarWithBatchFilter(embedding, topK, perDocumentFilter(filter))
+}
+
+// SearchSimilarWithBatchFilter finds the top K most similar documents the
+// filter allows, evaluating it on growing batches of candidates
+func (s *InMemoryVectorStore) SearchSimilarWithBatchFilter(embedding []float32, topK int, filter BatchFilter) ([]models.Document, error) {
+	docs := s.ranked(embedding)
+	fetch := func(n int) ([]models.Document, error) {
+		return docs[:min(n, len(docs))], nil
+	}
+	return searchWithFilterRecursive(fetch, topK, filter, initialMultiplier, 0)
+}
+
+// SearchSimilarWithGrants behaves like SearchSimilarWithBatchFilter but
+// accepts the candidates a grant selects without evaluating access. Every
+// document is ranked anyway, so the grants only spare access checks.
+func (s *InMemoryVectorStore) SearchSimilarWithGrants(embedding []float32, topK int, grants []MetadataGrant, filters map[string]models.MetadataFilter, access BatchFilter) ([]models.Document, error) {
+	return s.SearchSimilarWithBatchFilter(embedding, topK, withGrants(usableGrants(grants), filters, access))
+}
+
+// SearchHybridWithBatchFilter fuses the vector ranking with a keyword ranking
+// that orders documents by how many distinct terms of query they contain
+func (s *InMemoryVectorStore) SearchHybridWithBatchFilter(embedding []float32, query string, topK int, filter BatchFilter, opts HybridOptions) ([]models.Document, error) {
+	vectorHits := s.ranked(embedding)
+	keywordHits := keywordRanking(vectorHits, query)
+	fused := FuseRankings(vectorHits, keywordHits, opts)
+	fetch := func(n int) ([]models.Document, error) {
+		return fused[:min(n, len(fused))], nil
+	}
+	return searchWithFilterRecursive(fetch, topK, filter, initialMultiplier, 0)
+}
+
+// keywordRanking returns the documents containing any term of query, those
+// containing the most distinct terms first
+func keywordRanking(docs []models.Document, query string) []models.Document {
+	terms := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	slices.Sort(terms)
+	terms = slices.Compact(terms)
+
+	matches := make(map[uuid.UUID]int)
+	var hits []models.Document
+	for _, doc := range docs {
+		text := strings.ToLower(doc.Title + " " + doc.Content)
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				matches[doc.ID]++
+			}
+		}
+		if matches[doc.ID] > 0 {
+			hits = append(hits, doc)
+		}
+	}
+	slices.SortStableFunc(hits, func(a, b models.Document) int {
+		return matches[b.ID] - matches[a.ID]
+	})
+	return hits
+}
+
+// GetAllDocuments returns all documents of the tenant (without embeddings)
+func (s *InMemoryVectorStore) GetAllDocuments() []models.Document {
+	return s.GetFilteredDocuments(nil)
+}
+
+// GetFilteredDocuments returns the tenant's documents that match the given filter
+func (s *InMemoryVectorStore) GetFilteredDocuments(filter func(*models.Document) bool) []models.Document {
+	return s.filtered(false, filter)
+}
+
+// filtered returns the tenant's live or trashed documents that match filter
+func (s *InMemoryVectorStore) filtered(trashed bool, filter func(*models.Document) bool) []models.Document {
+	s.data.mu.RLock()
+	defer s.data.mu.RUnlock()
+
+	docs := []models.Document{}
+	for _, doc := range s.data.docs {
+		if doc.TenantID != s.tenantID || (doc.DeletedAt != nil) != trashed {
+			continue
+		}
+		found := stored(doc)
+		if filter == nil || filter(&found) {
+			docs = append(docs, found)
+		}
+	}
+	slices.SortFunc(docs, func(a, b models.Document) int { return strings.Compare(b.ID.String(), a.ID.String()) })
+	return docs
+}
+
+// ListDocuments returns one page of the tenant's documents matching the
+// metadata filters, sorted like SQLiteVectorStore.ListDocuments
+func (s *InMemoryVectorStore) ListDocuments(opts ListOptions) ([]models.Document, error) {
+	return s.listDocuments(opts, false)
+}
+
+// ListTrash returns one page of the tenant's trashed documents like ListDocuments
+func (s *InMemoryVectorStore) ListTrash(opts ListOptions) ([]models.Document, error) {
+	return s.listDocuments(opts, true)
+}
+
+// listDocuments returns one page of the tenant's live or trashed documents
+func (s *InMemoryVectorStore) listDocuments(opts ListOptions, trashed bool) ([]models.Document, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	docs := s.filtered(trashed, func(doc *models.Document) bool {
+		return matchesMetadata(doc, opts.Metadata)
+	})
+	slices.SortFunc(docs, func(a, b models.Document) int {
+		order := strings.Compare(a.Title, b.Title)
+		if opts.SortBy == SortByCreatedAt {
+			order = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		order = cmp.Or(order, strings.Compare(a.ID.String(), b.ID.String()))
+		if opts.SortDesc {
+			return -order
+		}
+		return order
+	})
+
+	start := min(opts.Offset, len(docs))
+	end := min(start+opts.Limit, len(docs))
+	return docs[start:end], nil
+}
+
+// ExportDocuments calls fn with the tenant's documents matching the metadata
+// filters and their embeddings, oldest first
+func (s *InMemoryVectorStore) ExportDocuments(metadata map[string]string, fn func(*models.Document) error) error {
+	if err := ValidateMetadataFilter(metadata); err != nil {
+		return err
+	}
+
+	s.data.mu.RLock()
+	var docs []models.Document
+	for _, doc := range s.data.docs {
+		if s.live(doc) && matchesMetadata(doc, metadata) {
+			found := stored(doc)
+			found.Embedding = slices.Clone(doc.Embedding)
+			docs = append(docs, found)
+		}
+	}
+	s.data.mu.RUnlock()
+
+	slices.SortFunc(docs, func(a, b models.Document) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID.String(), b.ID.String()))
+	})
+	for i := range docs {
+		if err := fn(&docs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesMetadata reports whether doc has every metadata filter value
+func matchesMetadata(doc *models.Document, metadata map[string]string) bool {
+	for key, want := range metadata {
+		value, ok := doc.Metadata[key]
+		if !ok || metadataText(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataText renders a metadata value the way SQLite's CAST(json_extract(...) AS TEXT) does
+func metadataText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	default:
+		raw, _ := json.Marshal(v)
+		return string(raw)
+	}
+}
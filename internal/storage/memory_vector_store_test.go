@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInMemoryVectorStoreSearch(t *testing.T) {
+	store, err := NewInMemoryVectorStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	north := &models.Document{Title: "North", Content: "Refunds for 2023", Embedding: []float32{0, 1, 0}}
+	east := &models.Document{Title: "East", Content: "Invoices", Embedding: []float32{1, 0, 0}}
+	northEast := &models.Document{Title: "North East", Content: "Mixed", Embedding: []float32{1, 1, 0}}
+	for _, doc := range []*models.Document{north, east, northEast} {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add %s: %v", doc.Title, err)
+		}
+	}
+
+	results, err := store.SearchSimilarWithFilter([]float32{0, 2, 0}, 2, func(*models.Document) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ID != north.ID || results[1].ID != northEast.ID {
+		t.Fatalf("Expected North then North East, got %+v", results)
+	}
+	if results[0].Distance > 1e-6 || results[0].Embedding != nil {
+		t.Errorf("Expected an exact match without embedding, got distance %f", results[0].Distance)
+	}
+
+	// The filter is applied until topK documents are found
+	results, _ = store.SearchSimilarWithBatchFilter([]float32{0, 1, 0}, 1, func(docs []models.Document) []bool {
+		allowed := make([]bool, len(docs))
+		for i := range docs {
+			allowed[i] = docs[i].ID == east.ID
+		}
+		return allowed
+	})
+	if len(results) != 1 || results[0].ID != east.ID {
+		t.Errorf("Expected only East, got %+v", results)
+	}
+
+	// Keyword matches move up in hybrid search
+	results, _ = store.SearchHybridWithBatchFilter([]float32{1, 0, 0}, "refunds 2023", 3, perDocumentFilter(func(*models.Document) bool { return true }), HybridOptions{VectorWeight: 1, KeywordWeight: 2, RRFK: 1})
+	if len(results) != 3 || results[0].ID != north.ID {
+		t.Errorf("Expected the keyword match first, got %+v", results)
+	}
+}
+
+func TestInMemoryVectorStoreTenantIsolation(t *testing.T) {
+	store, _ := NewInMemoryVectorStore("")
+	acme := store.ForTenant("acme")
+	doc := &models.Document{Title: "Acme", Content: "Secret", Embedding: []float32{1, 0}}
+	if err := acme.AddDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetDocument(doc.ID); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound from another tenant, got %v", err)
+	}
+	if docs := store.GetAllDocuments(); len(docs) != 0 {
+		t.Errorf("Expected no documents in the default tenant, got %d", len(docs))
+	}
+	if err := store.UpsertDocument(&models.Document{ID: doc.ID, Title: "Takeover"}); err == nil {
+		t.Error("Expected upserting another tenant's document to fail")
+	}
+	if err := store.DeleteDocument(doc.ID); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound deleting another tenant's document, got %v", err)
+	}
+}
+
+func TestInMemoryVectorStoreListDocuments(t *testing.T) {
+	store, _ := NewInMemoryVectorStore("")
+	for _, doc := range []*models.Document{
+		{Title: "B", Metadata: map[string]interface{}{"year": 2023, "taxpayer": "John Doe"}},
+		{Title: "A", Metadata: map[string]interface{}{"year": 2022, "taxpayer": "John Doe"}},
+		{Title: "C", Metadata: map[string]interface{}{"year": 2023, "taxpayer": "ABC Corp"}},
+	} {
+		_ = store.AddDocument(doc)
+	}
+
+	docs, err := store.ListDocuments(ListOptions{Limit: 10, Metadata: map[string]string{"taxpayer": "John Doe"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || docs[0].Title != "A" || docs[1].Title != "B" {
+		t.Errorf("Expected A and B, got %+v", docs)
+	}
+
+	docs, _ = store.ListDocuments(ListOptions{Limit: 1, Offset: 1, SortDesc: true, Metadata: map[string]string{"year": "2023"}})
+	if len(docs) != 1 || docs[0].Title != "B" {
+		t.Errorf("Expected the second page to hold B, got %+v", docs)
+	}
+
+	if _, err := store.ListDocuments(ListOptions{}); err == nil {
+		t.Error("Expected invalid options to be rejected")
+	}
+}
+
+func TestInMemoryVectorStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "documents.json")
+	store, err := NewInMemoryVectorStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &models.Document{Title: "Refunds", Content: "John Doe", Metadata: map[string]interface{}{"year": 2023}, Embedding: []float32{0.1, 0.2}}
+	if err := store.ForTenant("acme").AddDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ForTenant("acme").UpdateDocument(&models.Document{ID: doc.ID, Title: "Refunds 2023", Content: "John Doe", Metadata: doc.Metadata}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewInMemoryVectorStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	found, err := reopened.ForTenant("acme").GetDocument(doc.ID)
+	if err != nil {
+		t.Fatalf("Expected the document to be persisted: %v", err)
+	}
+	if found.Title != "Refunds 2023" || found.Metadata["year"] != 2023.0 || !found.CreatedAt.Equal(doc.CreatedAt) {
+		t.Errorf("Unexpected persisted document: %+v", found)
+	}
+	results, _ := reopened.ForTenant("acme").SearchSimilarWithFilter([]float32{0.1, 0.2}, 1, func(*models.Document) bool { return true })
+	if len(results) != 1 || results[0].Distance > 1e-6 {
+		t.Errorf("Expected the embedding to survive the update and reload, got %+v", results)
+	}
+
+	if err := reopened.ForTenant("acme").DeleteDocument(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := NewInMemoryVectorStore(path); len(again.ForTenant("acme").GetAllDocuments()) != 0 {
+		t.Error("Expected the deletion to be persisted")
+	}
+}
+
+func TestInMemoryVectorStoreReindex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "documents.json")
+	store, _ := NewInMemoryVectorStore(path)
+	north := &models.Document{Title: "North", Content: "north", Embedding: []float32{0.1, 0.2, 0.3}}
+	gone := &models.Document{Title: "Gone", Content: "gone", Embedding: []float32{0.4, 0.5, 0.6}}
+	_ = store.AddDocument(north)
+	_ = store.ForTenant("acme").AddDocument(gone)
+
+	// A document deleted during reindexing does not block the swap
+	err := store.Reindex(context.Background(), func(_ context.Context, doc *models.Document) ([]float32, error) {
+		if doc.ID == gone.ID {
+			_ = store.ForTenant("acme").DeleteDocument(gone.ID)
+		}
+		return []float32{0, 1}, nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+
+	reopened, _ := NewInMemoryVectorStore(path)
+	results, _ := reopened.SearchSimilarWithFilter([]float32{0, 2}, 1, func(*models.Document) bool { return true })
+	if len(results) != 1 || results[0].ID != north.ID || results[0].Distance > 1e-6 {
+		t.Errorf("Expected the new vector to be persisted, got %+v", results)
+	}
+}
+
+func TestInMemoryVectorStoreTrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "documents.json")
+	store, _ := NewInMemoryVectorStore(path)
+	north := &models.Document{Title: "North", Content: "north", Embedding: []float32{0, 1}}
+	east := &models.Document{Title: "East", Content: "east", Embedding: []float32{1, 0}}
+	_ = store.AddDocument(north)
+	_ = store.AddDocument(east)
+
+	if err := store.TrashDocument(north.ID); err != nil {
+		t.Fatal(err)
+	}
+	results, _ := store.SearchSimilarWithFilter([]float32{0, 1}, 1, func(*models.Document) bool { return true })
+	if len(results) != 1 || results[0].ID != east.ID {
+		t.Errorf("Expected the trashed document to be excluded from search, got %+v", results)
+	}
+
+	// The trash survives a restart
+	reopened, _ := NewInMemoryVectorStore(path)
+	trash, _ := reopened.ListTrash(ListOptions{Limit: 10})
+	if len(trash) != 1 || trash[0].ID != north.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("Expected North in the persisted trash, got %+v", trash)
+	}
+	if err := reopened.RestoreDocument(north.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.GetDocument(north.ID); err != nil {
+		t.Errorf("Expected the restored document to be readable, got %v", err)
+	}
+
+	_ = reopened.TrashDocument(north.ID)
+	purged, err := reopened.PurgeTrash(time.Now().Add(time.Hour))
+	if err != nil || len(purged) != 1 || purged[0].ID != north.ID {
+		t.Errorf("Expected North to be purged, got %+v, %v", purged, err)
+	}
+	if trash, _ := reopened.ListTrash(ListOptions{Limit: 10}); len(trash) != 0 {
+		t.Errorf("Expected an empty trash after purging, got %+v", trash)
+	}
+}
+
+func TestInMemoryVectorStoreTenants(t *testing.T) {
+	store, _ := NewInMemoryVectorStore("")
+	_ = store.AddDocument(&models.Document{Title: "Return", Content: "a", Embedding: []float32{1, 0}})
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Content: "b", Embedding: []float32{0, 1}})
+	trashed := &models.Document{Title: "Old", Content: "c", Embedding: []float32{1, 1}}
+	_ = store.ForTenant("globex").AddDocument(trashed)
+	_ = store.ForTenant("globex").(Trash).TrashDocument(trashed.ID)
+
+	if tenants, err := store.Tenants(); err != nil || !slices.Equal(tenants, []string{"acme", "default"}) {
+		t.Errorf("Expected the tenants with live documents, got %v (%v)", tenants, err)
+	}
+}
+
+func TestInMemoryVectorStoreExpiredDocuments(t *testing.T) {
+	store, _ := NewInMemoryVectorStore("")
+	overdue := &models.Document{Title: "2019 return", Content: "a", Embedding: []float32{1, 0}, Metadata: map[string]interface{}{"expires_at": "2020-01-01"}}
+	held := &models.Document{Title: "Held", Content: "b", Embedding: []float32{0, 1}, Metadata: map[string]interface{}{"expires_at": "2999-01-01"}}
+	plain := &models.Document{Title: "Plain", Content: "c", Embedding: []float32{1, 1}}
+	_ = store.AddDocument(overdue)
+	_ = store.AddDocument(held)
+	_ = store.ForTenant("acme").AddDocument(plain)
+
+	policy := RetentionPolicy{MaxAge: 24 * time.Hour, ExpiresAtKey: "expires_at"}
+	if expired, _ := store.ExpiredDocuments(policy, time.Now(), 10); len(expired) != 1 || expired[0].ID != overdue.ID {
+		t.Fatalf("Expected only the overdue document, got %+v", expired)
+	}
+	expired, _ := store.ExpiredDocuments(policy, time.Now().Add(48*time.Hour), 10)
+	if len(expired) != 2 || slices.ContainsFunc(expired, func(doc models.Document) bool { return doc.ID == held.ID }) {
+		t.Errorf("Expected the held document to be kept until its expiry date, got %+v", expired)
+	}
+}
@@ -120,3 +120,62 @@ func TestRecursiveSearchMaxAttempts(t *testing.T) {
 		t.Errorf("Expected 0 results, got %d", len(results))
 	}
 }
+
+// TestSearchWithBatchFilter verifies that the batch filter is evaluated once per
+// candidate pool and that its results are aligned with the candidates
+func TestSearchWithBatchFilter(t *testing.T) {
+	dbPath := "./test_batch_filter.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	for i := 0; i < 6; i++ {
+		category := "even"
+		if i%2 == 1 {
+			category = odd
+		}
+
+		doc := &models.Document{
+			ID:        uuid.New(),
+			Title:     category,
+			Content:   "Content " + category,
+			Embedding: []float32{float32(i) / 10.0, float32(i) / 20.0, float32(i) / 30.0},
+		}
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document %d: %v", i, err)
+		}
+	}
+
+	calls := 0
+	filter := func(docs []models.Document) []bool {
+		calls++
+		allowed := make([]bool, len(docs))
+		for i := range docs {
+			allowed[i] = docs[i].Title == odd
+		}
+		return allowed
+	}
+
+	results, err := store.SearchSimilarWithBatchFilter([]float32{0.3, 0.15, 0.1}, 3, filter)
+	if err != nil {
+		t.Fatalf("Failed to search with batch filter: %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Errorf("Expected 3 results, got %d", len(results))
+	}
+	for i, doc := range results {
+		if doc.Title != odd {
+			t.Errorf("Result %d has wrong title: %s", i, doc.Title)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected batch filter to be called once, got %d", calls)
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ShareLinkStore persists document share links until they expire
+type ShareLinkStore interface {
+	// CreateShareLink stores a new link in the tenant, assigning its ID
+	CreateShareLink(link *models.ShareLink) error
+	// ExpiredShareLinks returns the links of all tenants that expired before t
+	ExpiredShareLinks(t time.Time) ([]models.ShareLink, error)
+	// DeleteShareLink deletes the link with id in any tenant
+	DeleteShareLink(id uuid.UUID) error
+	// ForTenant returns a view of the store whose new links belong to tenantID
+	ForTenant(tenantID string) ShareLinkStore
+}
+
+// SQLiteShareLinkStore stores share links in the vector store's SQLite database
+type SQLiteShareLinkStore struct {
+	db       *sql.DB
+	tenantID string
+}
+
+// NewSQLiteShareLinkStore creates the share link table in the database backing store
+func NewSQLiteShareLinkStore(store *SQLiteVectorStore) (*SQLiteShareLinkStore, error) {
+	s := &SQLiteShareLinkStore{
+		db:       store.db,
+		tenantID: tenant.Default,
+	}
+
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS share_links (
+		id TEXT PRIMARY KEY,
+		tenant_id TEXT NOT NULL,
+		document_id TEXT NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create share link table: %w", err)
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_share_links_expires_at ON share_links(expires_at)`); err != nil {
+		return nil, fmt.Errorf("failed to create share link index: %w", err)
+	}
+
+	return s, nil
+}
+
+// ForTenant returns a view of the store whose new links belong to tenantID
+func (s *SQLiteShareLinkStore) ForTenant(tenantID string) ShareLinkStore {
+	scoped := *s
+	scoped.tenantID = tenantID
+	return &scoped
+}
+
+// CreateShareLink stores a new link in the tenant
+func (s *SQLiteShareLinkStore) CreateShareLink(link *models.ShareLink) error {
+	link.ID = uuid.New()
+	link.TenantID = s.tenantID
+	link.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	_, err := s.db.Exec(`INSERT INTO share_links (id, tenant_id, document_id, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		link.ID.String(), s.tenantID, link.DocumentID.String(), link.CreatedBy, link.CreatedAt.Unix(), link.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to create share link: %w", err)
+	}
+	return nil
+}
+
+// ExpiredShareLinks returns the links of all tenants that expired before t, oldest first
+func (s *SQLiteShareLinkStore) ExpiredShareLinks(t time.Time) ([]models.ShareLink, error) {
+	rows, err := s.db.Query(`SELECT id, tenant_id, document_id, created_by, created_at, expires_at FROM share_links WHERE expires_at <= ? ORDER BY expires_at`, t.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired share links: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var links []models.ShareLink
+	for rows.Next() {
+		var link models.ShareLink
+		var id, docID string
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&id, &link.TenantID, &docID, &link.CreatedBy, &createdAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan share link: %w", err)
+		}
+		if link.ID, err = uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("invalid share link ID %q: %w", id, err)
+		}
+		if link.DocumentID, err = uuid.Parse(docID); err != nil {
+			return nil, fmt.Errorf("invalid document ID %q of share link %s: %w", docID, id, err)
+		}
+		link.CreatedAt = time.Unix(createdAt, 0).UTC()
+		link.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating share links: %w", err)
+	}
+	return links, nil
+}
+
+// DeleteShareLink deletes the link with id in any tenant
+func (s *SQLiteShareLinkStore) DeleteShareLink(id uuid.UUID) error {
+	if _, err := s.db.Exec(`DELETE FROM share_links WHERE id = ?`, id.String()); err != nil {
+		return fmt.Errorf("failed to delete share link: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSQLiteShareLinkStore(t *testing.T) {
+	store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "shares.db"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	links, err := NewSQLiteShareLinkStore(store)
+	if err != nil {
+		t.Fatalf("Failed to create share link store: %v", err)
+	}
+
+	now := time.Now()
+	expired := &models.ShareLink{DocumentID: uuid.New(), CreatedBy: "peter", ExpiresAt: now.Add(-time.Minute)}
+	if err := links.ForTenant("acme").CreateShareLink(expired); err != nil {
+		t.Fatalf("Failed to create share link: %v", err)
+	}
+	if expired.ID == uuid.Nil || expired.TenantID != "acme" {
+		t.Fatalf("Expected ID and tenant to be assigned, got %+v", expired)
+	}
+	active := &models.ShareLink{DocumentID: uuid.New(), CreatedBy: "peter", ExpiresAt: now.Add(time.Hour)}
+	if err := links.CreateShareLink(active); err != nil {
+		t.Fatalf("Failed to create share link: %v", err)
+	}
+
+	found, err := links.ExpiredShareLinks(now)
+	if err != nil {
+		t.Fatalf("Failed to list expired share links: %v", err)
+	}
+	if len(found) != 1 || found[0].ID != expired.ID || found[0].TenantID != "acme" || found[0].DocumentID != expired.DocumentID || found[0].CreatedBy != "peter" {
+		t.Fatalf("Expected only the expired link, got %+v", found)
+	}
+
+	if err := links.DeleteShareLink(expired.ID); err != nil {
+		t.Fatalf("Failed to delete share link: %v", err)
+	}
+	if found, _ := links.ExpiredShareLinks(now.Add(2 * time.Hour)); len(found) != 1 || found[0].ID != active.ID {
+		t.Errorf("Expected only the active link to remain, got %+v", found)
+	}
+}
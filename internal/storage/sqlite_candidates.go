@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Searches rank candidates by vector and keyword hits carrying only their
+// document ID, distance, and match. A candidateCache turns the hits into
+// documents with their title, metadata, and timestamps for the filter,
+// loading the documents it has not seen in one query, and the content is
+// only loaded for the documents the filter lets through.
+
+// candidateCache holds the candidates of one search. Each attempt of
+// searchWithFilterRecursive ranks a larger pool that starts with the
+// previous one, so the metadata of those candidates is already warm and
+// metadata-based filters never cause a lookup per candidate.
+type candidateCache struct {
+	store *SQLiteVectorStore
+	docs  map[uuid.UUID]models.Document
+}
+
+// newCandidateCache returns an empty cache for a search of the store's tenant
+func (s *SQLiteVectorStore) newCandidateCache() *candidateCache {
+	return &candidateCache{store: s, docs: make(map[uuid.UUID]models.Document)}
+}
+
+// searchHit returns a search hit on the document id at distance
+func searchHit(id string, distance float64) (models.Document, error) {
+	docID, err := uuid.Parse(id)
+	if err != nil {
+		return models.Document{}, fmt.Errorf("error parsing UUID %s: %w", id, err)
+	}
+	return models.Document{ID: docID, Distance: distance}, nil
+}
+
+// fill returns the documents of hits in order, without their content, with
+// the distance and match of the hit. Hits on documents that no longer exist
+// are dropped.
+func (c *candidateCache) fill(hits []models.Document) ([]models.Document, error) {
+	args := []interface{}{c.store.tenantID}
+	for _, h := range hits {
+		if _, ok := c.docs[h.ID]; !ok {
+			args = append(args, h.ID.String())
+		}
+	}
+	if len(args) > 1 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)-1), ", ")
+		loaded, err := c.store.queryDocuments(`SELECT id, title, '', metadata, created_at, deleted_at FROM documents WHERE tenant_id = ? AND id IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range loaded {
+			c.docs[doc.ID] = doc
+		}
+	}
+
+	docs := make([]models.Document, 0, len(hits))
+	for _, h := range hits {
+		doc, ok := c.docs[h.ID]
+		if !ok {
+			continue
+		}
+		doc.Distance, doc.Match = h.Distance, h.Match
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// withContent returns docs with their content, dropping documents deleted
+// since they were filtered
+func (s *SQLiteVectorStore) withContent(docs []models.Document) ([]models.Document, error) {
+	if len(docs) == 0 {
+		return docs, nil
+	}
+	args := []interface{}{s.tenantID}
+	for _, doc := range docs {
+		args = append(args, doc.ID.String())
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(docs)), ", ")
+	rows, err := s.db.Query(`SELECT id, content FROM documents WHERE tenant_id = ? AND id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query document content: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	contents := make(map[string]string, len(docs))
+	for rows.Next() {
+		var id, content string
+		if err := rows.Scan(&id, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan document content: %w", err)
+		}
+		contents[id] = content
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating document content: %w", err)
+	}
+	kept := docs[:0]
+	for _, doc := range docs {
+		if content, ok := contents[doc.ID.String()]; ok {
+			doc.Content = content
+			kept = append(kept, doc)
+		}
+	}
+	return kept, nil
+}
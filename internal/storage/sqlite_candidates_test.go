@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+)
+
+func TestSearchFiltersByCandidateMetadata(t *testing.T) {
+	store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "candidates.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupTestStore(store)
+	for _, doc := range []*models.Document{
+		{Title: "Doe 1040", Content: "doe return", Metadata: map[string]interface{}{"taxpayer": "John Doe"}, Embedding: []float32{1, 0, 0}},
+		{Title: "Roe 1040", Content: "roe return", Metadata: map[string]interface{}{"taxpayer": "Jane Roe"}, Embedding: []float32{0.9, 0.1, 0}},
+	} {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The filter sees each candidate's metadata, but not its content
+	var seen []models.Document
+	results, err := store.SearchSimilarWithBatchFilter([]float32{1, 0, 0}, 1, func(docs []models.Document) []bool {
+		seen = append(seen, docs...)
+		allowed := make([]bool, len(docs))
+		for i := range docs {
+			allowed[i] = docs[i].Metadata["taxpayer"] == "Jane Roe"
+		}
+		return allowed
+	})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Title != "Roe 1040" || results[0].Content != "roe return" {
+		t.Errorf("Expected Roe's return with its content, got %+v", results)
+	}
+	for _, doc := range seen {
+		if doc.Metadata["taxpayer"] == nil || doc.Content != "" {
+			t.Errorf("Expected candidates with metadata and without content, got %+v", doc)
+		}
+	}
+}
+
+func TestCandidateCache(t *testing.T) {
+	store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "candidates.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupTestStore(store)
+	doc := &models.Document{Title: "Doe 1040", Content: "doe return", Metadata: map[string]interface{}{"taxpayer": "John Doe"}, Embedding: []float32{1, 0, 0}}
+	if err := store.AddDocument(doc); err != nil {
+		t.Fatal(err)
+	}
+	missing, _ := searchHit("00000000-0000-0000-0000-000000000001", 0)
+	hits := []models.Document{{ID: doc.ID, Distance: 0.5}, missing}
+
+	cache := store.newCandidateCache()
+	docs, err := cache.fill(hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 || docs[0].Title != "Doe 1040" || docs[0].Distance != 0.5 || docs[0].Metadata["taxpayer"] != "John Doe" {
+		t.Fatalf("Expected the document of the hit without the missing one, got %+v", docs)
+	}
+
+	// Later attempts of the search reuse the loaded metadata
+	if _, err := store.db.Exec(`UPDATE documents SET metadata = '{}' WHERE id = ?`, doc.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	docs, _ = cache.fill(hits)
+	if len(docs) != 1 || docs[0].Metadata["taxpayer"] != "John Doe" {
+		t.Errorf("Expected the cached metadata, got %+v", docs)
+	}
+	if docs, _ = store.newCandidateCache().fill(hits); len(docs) != 1 || docs[0].Metadata != nil {
+		t.Errorf("Expected a new search to load the current metadata, got %+v", docs)
+	}
+}
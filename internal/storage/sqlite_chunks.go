@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"rerag-rbac-rag-llm/internal/models"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Chunks of a document are stored in document_chunks with their offsets and
+// in vec_chunks with their vectors, keyed by "<document ID>:<index>".
+// vec_chunks is created with vec_documents and has the same dimensions, so
+// chunk vectors are compared with queries like document vectors.
+
+// shadowChunkTable holds the chunk vectors of a reindex until the swap
+const shadowChunkTable = "vec_chunks_shadow"
+
+// WithChunkScore sets how the distances of a document's chunks combine into
+// its search distance: ChunkScoreMax (the default) or ChunkScoreMean
+func WithChunkScore(mode string) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.chunkScore = mode
+	}
+}
+
+// chunkID returns the key of the index-th chunk of a document
+func chunkID(docID uuid.UUID, index int) string {
+	return fmt.Sprintf("%s:%d", docID, index)
+}
+
+// initChunks creates the chunk table, and the chunk vector table for
+// databases whose document vectors were stored before chunks were supported
+func (s *SQLiteVectorStore) initChunks() error {
+	_, err := s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS document_chunks (
+		id TEXT PRIMARY KEY,
+		document_id TEXT NOT NULL,
+		tenant_id TEXT NOT NULL,
+		chunk_index INTEGER NOT NULL,
+		start_offset INTEGER NOT NULL,
+		end_offset INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_document_chunks_document ON document_chunks(document_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create document_chunks table: %w", err)
+	}
+
+	if s.info.dimensions == 0 {
+		return nil
+	}
+	var exists int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='vec_chunks'`).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check vec_chunks existence: %w", err)
+	}
+	if exists > 0 {
+		return nil
+	}
+	if _, err := s.db.Exec(s.vecTableQuery("vec_chunks", s.info.dimensions, s.info.index)); err != nil {
+		return fmt.Errorf("failed to create vec_chunks table: %w", err)
+	}
+	return nil
+}
+
+// writeChunks replaces the chunks of doc and their vectors. The vector
+// tables exist since doc's embedding was checked before.
+func (s *SQLiteVectorStore) writeChunks(tx *sql.Tx, doc *models.Document) error {
+	if err := deleteChunks(tx, doc.ID.String()); err != nil {
+		return err
+	}
+	for i, chunk := range doc.Chunks {
+		id := chunkID(doc.ID, i)
+		if _, err := tx.Exec(`INSERT INTO document_chunks (id, document_id, tenant_id, chunk_index, start_offset, end_offset) VALUES (?, ?, ?, ?, ?, ?)`,
+			id, doc.ID.String(), s.tenantID, i, chunk.Start, chunk.End); err != nil {
+			return fmt.Errorf("failed to insert chunk: %w", err)
+		}
+		if _, err := tx.Exec(`INSERT INTO vec_chunks (id, tenant_id, embedding) VALUES (?, ?, `+s.vectorIndex().param()+`)`,
+			id, s.tenantID, serializeFloat32Vector(chunk.Embedding)); err != nil {
+			return fmt.Errorf("failed to insert chunk vector: %w", err)
+		}
+		if err := s.writeFullVector(tx, fullVecTable, s.vectorIndex(), id, chunk.Embedding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteChunks removes the chunks of the document and their vectors
+func deleteChunks(tx *sql.Tx, docID string) error {
+	rows, err := tx.Query(`SELECT id FROM document_chunks WHERE document_id = ?`, docID)
+	if err != nil {
+		return fmt.Errorf("failed to query chunks: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to scan chunk: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating chunks: %w", err)
+	}
+
+	// Chunks are only stored once vec_chunks exists
+	for _, id := range ids {
+		if _, err := tx.Exec(`DELETE FROM vec_chunks WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete chunk vector: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM `+fullVecTable+` WHERE id LIKE ?`, docID+":%"); err != nil {
+		return fmt.Errorf("failed to delete full-precision chunk vectors: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM document_chunks WHERE document_id = ?`, docID); err != nil {
+		return fmt.Errorf("failed to delete chunks: %w", err)
+	}
+	return nil
+}
+
+// searchWithChunks returns hits on up to n documents closest to embedding. Documents
+// are found by their own vectors and by their chunks' vectors; documents
+// with chunks are then scored by the distances of all their chunks. Since
+// every document has a vector of its own, fewer than n results means every
+// document was considered.
+func (s *SQLiteVectorStore) searchWithChunks(embedding []float32, n int) ([]models.Document, error) {
+	docs, err := s.searchWithSqliteVec(embedding, n)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := s.searchChunkParents(embedding, n)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		found[doc.ID.String()] = true
+	}
+	var missing []string
+	for _, id := range parents {
+		if !found[id] {
+			found[id] = true
+			missing = append(missing, id)
+		}
+	}
+	for _, id := range missing {
+		// scoreByChunks sets the distance
+		doc, err := searchHit(id, 0)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+
+	if err := s.scoreByChunks(embedding, docs); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(docs, func(a, b models.Document) int { return cmp.Compare(a.Distance, b.Distance) })
+	return docs[:min(n, len(docs))], nil
+}
+
+// searchChunkParents returns the IDs of the documents of the n chunks
+// closest to embedding, closest first
+func (s *SQLiteVectorStore) searchChunkParents(embedding []float32, n int) ([]string, error) {
+	var rows *sql.Rows
+	var err error
+	index := s.vectorIndex()
+	if s.goVectors {
+		rows, err = s.db.Query(`SELECT c.id, c.document_id, v.embedding FROM vec_chunks v JOIN document_chunks c ON c.id = v.id WHERE v.tenant_id = ?`, s.tenantID)
+	} else {
+		rows, err = s.db.Query(`
+			SELECT c.id, c.document_id, v.distance
+			FROM vec_chunks v
+			JOIN document_chunks c ON c.id = v.id
+			WHERE v.embedding MATCH `+index.param()+` AND k = ? AND v.tenant_id = ?
+			ORDER BY v.distance
+		`, serializeFloat32Vector(embedding), s.candidates(index, n), s.tenantID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chunks: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	type hit struct {
+		id, documentID string
+		distance       float64
+	}
+	var hits []hit
+	for rows.Next() {
+		var h hit
+		if s.goVectors {
+			var stored []byte
+			if err := rows.Scan(&h.id, &h.documentID, &stored); err != nil {
+				return nil, fmt.Errorf("failed to scan chunk: %w", err)
+			}
+			if h.distance, err = storedDistance(index.Metric, embedding, stored); err != nil {
+				return nil, err
+			}
+		} else if err := rows.Scan(&h.id, &h.documentID, &h.distance); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk: %w", err)
+		} else {
+			h.distance = index.scale(h.distance, len(embedding))
+		}
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chunks: %w", err)
+	}
+
+	if s.keepsFull(index) {
+		ids := make([]string, len(hits))
+		for i, h := range hits {
+			ids[i] = h.id
+		}
+		distances, err := s.fullDistances(embedding, index.Metric, ids)
+		if err != nil {
+			return nil, err
+		}
+		for i := range hits {
+			if distance, ok := distances[hits[i].id]; ok {
+				hits[i].distance = distance
+			}
+		}
+	}
+	slices.SortStableFunc(hits, func(a, b hit) int { return cmp.Compare(a.distance, b.distance) })
+	ids := make([]string, 0, min(n, len(hits)))
+	for _, h := range hits[:min(n, len(hits))] {
+		ids = append(ids, h.documentID)
+	}
+	return ids, nil
+}
+
+// scoreByChunks sets the distance and match of the documents with chunks
+// from the distances of all their chunks to embedding
+func (s *SQLiteVectorStore) scoreByChunks(embedding []float32, docs []models.Document) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		args[i] = doc.ID.String()
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(docs)), ", ")
+	distanceColumn, distanceArgs := s.distanceColumn("v", embedding)
+	rows, err := s.db.Query(`
+		SELECT c.document_id, c.start_offset, c.end_offset, `+distanceColumn+`
+		FROM document_chunks c
+		JOIN vec_chunks v ON v.id = c.id
+		WHERE c.document_id IN (`+placeholders+`)
+		ORDER BY c.document_id, c.chunk_index
+	`, append(distanceArgs, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to query chunks: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	spans := make(map[string][]models.Span)
+	distances := make(map[string][]float64)
+	for rows.Next() {
+		var documentID string
+		var span models.Span
+		var stored interface{}
+		if err := rows.Scan(&documentID, &span.Start, &span.End, &stored); err != nil {
+			return fmt.Errorf("failed to scan chunk: %w", err)
+		}
+		distance, err := s.distance(embedding, stored)
+		if err != nil {
+			return err
+		}
+		spans[documentID] = append(spans[documentID], span)
+		distances[documentID] = append(distances[documentID], distance)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating chunks: %w", err)
+	}
+
+	for i := range docs {
+		id := docs[i].ID.String()
+		scoreChunks(&docs[i], spans[id], distances[id], s.chunkScore)
+	}
+	return nil
+}
+
+// reindexChunks returns the chunks of every document by document ID, in
+// order; their embeddings are not loaded
+func reindexChunks(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}) (map[string][]models.DocumentChunk, error) {
+	rows, err := q.QueryContext(ctx, `SELECT document_id, start_offset, end_offset FROM document_chunks ORDER BY document_id, chunk_index`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chunks: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	chunks := make(map[string][]models.DocumentChunk)
+	for rows.Next() {
+		var documentID string
+		var chunk models.DocumentChunk
+		if err := rows.Scan(&documentID, &chunk.Start, &chunk.End); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk: %w", err)
+		}
+		chunks[documentID] = append(chunks[documentID], chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chunks: %w", err)
+	}
+	return chunks, nil
+}
+
+// reindexHash identifies the content and chunking of doc, which decide its
+// vectors
+func reindexHash(doc *models.Document) string {
+	var b strings.Builder
+	b.WriteString(doc.Content)
+	for _, chunk := range doc.Chunks {
+		fmt.Fprintf(&b, "\x00%d:%d", chunk.Start, chunk.End)
+	}
+	return models.ContentHash(b.String())
+}
+
+// embedChunks embeds the chunks of doc with embed, each as a document of its
+// passage, and stores their vectors in the shadow chunk table
+func (s *SQLiteVectorStore) embedChunks(ctx context.Context, embed EmbedFunc, doc *models.Document, dimensions int) error {
+	// The document may have had more chunks when it was embedded before
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM `+shadowChunkTable+` WHERE id LIKE ?`, doc.ID.String()+":%"); err != nil {
+		return fmt.Errorf("failed to delete old shadow chunk vectors: %w", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM `+shadowFullVecTable+` WHERE id LIKE ?`, doc.ID.String()+":%"); err != nil {
+		return fmt.Errorf("failed to delete old shadow chunk vectors: %w", err)
+	}
+	for i, chunk := range doc.Chunks {
+		passage := models.Document{ID: doc.ID, TenantID: doc.TenantID, Title: doc.Title, Content: doc.Content[chunk.Start:chunk.End]}
+		embedding, err := embed(ctx, &passage)
+		if err != nil {
+			return fmt.Errorf("failed to embed chunk %d of document %s: %w", i, doc.ID, err)
+		}
+		if len(embedding) != dimensions {
+			return fmt.Errorf("chunk %d of document %s has an embedding of %d dimensions, expected %d", i, doc.ID, len(embedding), dimensions)
+		}
+		if _, err := s.db.ExecContext(ctx, `INSERT INTO `+shadowChunkTable+` (id, tenant_id, embedding) VALUES (?, ?, `+s.index.param()+`)`,
+			chunkID(doc.ID, i), doc.TenantID, serializeFloat32Vector(embedding)); err != nil {
+			return fmt.Errorf("failed to insert shadow chunk vector: %w", err)
+		}
+		if err := s.writeFullVector(s.db, shadowFullVecTable, s.index, chunkID(doc.ID, i), embedding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+)
+
+// chunkStores returns a store of every kind scoring chunks with mode
+func chunkStores(t *testing.T, mode string) map[string]VectorStore {
+	t.Helper()
+	dir := t.TempDir()
+	native, err := NewSQLiteVectorStore(filepath.Join(dir, "native.db"), WithChunkScore(mode))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = native.Close() })
+	goVectors, err := newSQLiteVectorStore(filepath.Join(dir, "go.db"), true, WithChunkScore(mode))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	t.Cleanup(func() { _ = goVectors.Close() })
+	memory, _ := NewInMemoryVectorStore("")
+	memory.SetChunkScore(mode)
+	return map[string]VectorStore{"sqlite": native, "sqlite-go-vectors": goVectors, "memory": memory}
+}
+
+// addChunkDocuments adds a document with two chunks, one near to the query
+// {0, 1, 0} and one far from it, and two documents without chunks
+func addChunkDocuments(t *testing.T, store VectorStore) (chunked, near *models.Document) {
+	t.Helper()
+	chunked = &models.Document{Title: "Chunked", Content: "northsouth", Embedding: []float32{0, 0.5, 0.5}, Chunks: []models.DocumentChunk{
+		{Start: 0, End: 5, Embedding: []float32{0, 1, 0}},
+		{Start: 5, End: 10, Embedding: []float32{0, 0, 1}},
+	}}
+	near = &models.Document{Title: "Close", Content: "close", Embedding: []float32{0.2, 1, 0}}
+	east := &models.Document{Title: "East", Content: "east", Embedding: []float32{1, 0, 0}}
+	for _, doc := range []*models.Document{chunked, near, east} {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add %s: %v", doc.Title, err)
+		}
+	}
+	return chunked, near
+}
+
+func TestChunkVectors(t *testing.T) {
+	all := func(*models.Document) bool { return true }
+	query := []float32{0, 1, 0}
+
+	for name, store := range chunkStores(t, ChunkScoreMax) {
+		t.Run(name, func(t *testing.T) {
+			chunked, _ := addChunkDocuments(t, store)
+
+			// The document is found by its best chunk although its own
+			// vector is farther from the query than the others
+			results, err := store.SearchSimilarWithFilter(query, 1, all)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if len(results) != 1 || results[0].ID != chunked.ID || results[0].Distance > 1e-6 {
+				t.Fatalf("Expected the chunked document at distance 0, got %+v", results)
+			}
+			if results[0].Match == nil || *results[0].Match != (models.Span{Start: 0, End: 5}) {
+				t.Errorf("Expected the first chunk to match, got %+v", results[0].Match)
+			}
+			results, err = store.SearchHybridWithBatchFilter(query, "south", 1, perDocumentFilter(all), DefaultHybridOptions)
+			if err != nil {
+				t.Fatalf("Hybrid search failed: %v", err)
+			}
+			if len(results) != 1 || results[0].ID != chunked.ID || results[0].Match == nil {
+				t.Errorf("Expected the chunked document with its match from a hybrid search, got %+v", results)
+			}
+
+			// Stored documents don't expose their chunks
+			found, _ := store.GetDocument(chunked.ID)
+			if found == nil || found.Chunks != nil {
+				t.Errorf("Expected the document without chunks, got %+v", found)
+			}
+
+			invalid := &models.Document{Title: "Invalid", Content: "short", Embedding: []float32{1, 0, 0}, Chunks: []models.DocumentChunk{{Start: 0, End: 50, Embedding: []float32{1, 0, 0}}}}
+			if err := store.AddDocument(invalid); err == nil {
+				t.Error("Expected a chunk outside the content to be rejected")
+			}
+
+			// Reindexing re-embeds the chunks, so the other chunk matches now
+			vectors := map[string][]float32{"northsouth": {0, 0.5, 0.5}, "north": {0, 0, 1}, "south": {0, 1, 0}, "close": {0.2, 1, 0}, "east": {1, 0, 0}}
+			err = store.(Reindexer).Reindex(context.Background(), func(_ context.Context, doc *models.Document) ([]float32, error) {
+				return vectors[doc.Content], nil
+			}, nil)
+			if err != nil {
+				t.Fatalf("Reindex failed: %v", err)
+			}
+			results, _ = store.SearchSimilarWithFilter(query, 1, all)
+			if len(results) != 1 || results[0].ID != chunked.ID || results[0].Match == nil || *results[0].Match != (models.Span{Start: 5, End: 10}) {
+				t.Errorf("Expected the second chunk to match after reindexing, got %+v", results)
+			}
+
+			if err := store.DeleteDocument(chunked.ID); err != nil {
+				t.Fatalf("Failed to delete document: %v", err)
+			}
+			results, _ = store.SearchSimilarWithFilter(query, 3, all)
+			for _, doc := range results {
+				if doc.ID == chunked.ID {
+					t.Errorf("Expected the deleted document not to be found, got %+v", results)
+				}
+			}
+			if sqliteStore, ok := store.(*SQLiteVectorStore); ok {
+				var chunks, vectors int
+				_ = sqliteStore.db.QueryRow(`SELECT COUNT(*) FROM document_chunks`).Scan(&chunks)
+				_ = sqliteStore.db.QueryRow(`SELECT COUNT(*) FROM vec_chunks`).Scan(&vectors)
+				if chunks != 0 || vectors != 0 {
+					t.Errorf("Expected the chunks to be deleted, got %d chunks and %d vectors", chunks, vectors)
+				}
+			}
+		})
+	}
+
+	// Scoring by the mean distance ranks the document behind one near as a whole
+	for name, store := range chunkStores(t, ChunkScoreMean) {
+		t.Run(name+"/mean", func(t *testing.T) {
+			chunked, near := addChunkDocuments(t, store)
+			results, err := store.SearchSimilarWithFilter(query, 2, all)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if len(results) != 2 || results[0].ID != near.ID || results[1].ID != chunked.ID || results[1].Distance < 0.1 {
+				t.Errorf("Expected Close before the chunked document, got %+v", results)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+//go:build cgo && !sqlcipher
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+	_ "github.com/mattn/go-sqlite3" // Import sqlite3 driver
+)
+
+// sqliteDriver is the database/sql driver backing SQLiteVectorStore
+const sqliteDriver = "sqlite3"
+
+// nativeVectors reports whether sqlite-vec is available for vector search
+const nativeVectors = true
+
+// EncryptionSupported reports whether the driver encrypts databases with
+// SQLCipher; build with -tags sqlcipher to enable it
+const EncryptionSupported = false
+
+// connectionParams returns the DSN parameters that set the busy timeout of
+// every pooled connection and make transactions take the write lock up front
+func connectionParams(busyTimeout time.Duration) []string {
+	return []string{
+		fmt.Sprintf("_busy_timeout=%d", busyTimeout.Milliseconds()),
+		"_txlock=immediate",
+	}
+}
+
+func init() {
+	sqlite_vec.Auto()
+}
@@ -0,0 +1,30 @@
+//go:build !cgo
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite" // Import the pure Go sqlite driver
+)
+
+// sqliteDriver is the database/sql driver backing SQLiteVectorStore
+const sqliteDriver = "sqlite"
+
+// nativeVectors reports whether sqlite-vec is available for vector search.
+// The pure Go driver cannot load C extensions, so vectors are scored in Go.
+const nativeVectors = false
+
+// EncryptionSupported reports whether the driver encrypts databases with
+// SQLCipher, which needs cgo
+const EncryptionSupported = false
+
+// connectionParams returns the DSN parameters that set the busy timeout of
+// every pooled connection and make transactions take the write lock up front
+func connectionParams(busyTimeout time.Duration) []string {
+	return []string{
+		fmt.Sprintf("_pragma=busy_timeout(%d)", busyTimeout.Milliseconds()),
+		"_txlock=immediate",
+	}
+}
@@ -0,0 +1,31 @@
+//go:build cgo && sqlcipher
+
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4" // Import the SQLCipher build of the sqlite3 driver
+)
+
+// sqliteDriver is the database/sql driver backing SQLiteVectorStore
+const sqliteDriver = "sqlite3"
+
+// nativeVectors reports whether sqlite-vec is available for vector search.
+// The SQLite bundled with SQLCipher predates the virtual table API sqlite-vec
+// needs, so vectors are scored in Go.
+const nativeVectors = false
+
+// EncryptionSupported reports whether the driver encrypts databases with
+// SQLCipher, keyed by the _pragma_key DSN parameter
+const EncryptionSupported = true
+
+// connectionParams returns the DSN parameters that set the busy timeout of
+// every pooled connection and make transactions take the write lock up front
+func connectionParams(busyTimeout time.Duration) []string {
+	return []string{
+		fmt.Sprintf("_busy_timeout=%d", busyTimeout.Milliseconds()),
+		"_txlock=immediate",
+	}
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"rerag-rbac-rag-llm/internal/models"
+	"sort"
+	"strings"
+)
+
+// Searches with metadata grants split the candidates in two. The documents a
+// grant selects are found by a predicate on their metadata compiled into the
+// query and ranked by their exact distance; only the metadata filters of the
+// search apply to them. The other documents are found by the vector search
+// and filtered by access as usual, but only while they can still rank among
+// the results: once topK granted documents are found, candidates farther
+// away than the last of them need no check, so the candidate pool stops
+// growing as soon as the grants cover the nearest documents.
+
+// SearchSimilarWithGrants behaves like SearchSimilarWithBatchFilter with
+// WithFilters(filters, access), except that the documents a grant selects
+// are accepted without evaluating access
+func (s *SQLiteVectorStore) SearchSimilarWithGrants(embedding []float32, topK int, grants []MetadataGrant, filters map[string]models.MetadataFilter, access BatchFilter) ([]models.Document, error) {
+	grants = usableGrants(grants)
+	if len(grants) == 0 {
+		return s.SearchSimilarWithBatchFilter(embedding, topK, WithFilters(filters, access))
+	}
+	if stored, err := s.checkQuery(embedding); err != nil || !stored {
+		return nil, err
+	}
+
+	candidates := s.newCandidateCache()
+	grantedHits, err := s.searchGranted(embedding, grants)
+	if err != nil {
+		return nil, err
+	}
+	fetchGranted := func(n int) ([]models.Document, error) {
+		return candidates.fill(grantedHits[:min(n, len(grantedHits))])
+	}
+	granted, err := searchWithFilterRecursive(fetchGranted, topK, WithFilters(filters, acceptAll), initialMultiplier, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	bound := math.Inf(1)
+	if len(granted) == topK {
+		bound = granted[topK-1].Distance
+	}
+	fetch := func(n int) ([]models.Document, error) {
+		hits, err := s.searchWithChunks(embedding, n)
+		if err != nil {
+			return nil, err
+		}
+		// Candidates beyond the last granted result cannot rank among the results
+		end := sort.Search(len(hits), func(i int) bool { return hits[i].Distance > bound })
+		return candidates.fill(hits[:end])
+	}
+	others := prefilter(func(doc *models.Document) bool { return !matchesGrants(doc, grants) }, WithFilters(filters, access))
+	rest, err := searchWithFilterRecursive(fetch, topK, withoutTrashed(others), initialMultiplier, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	results := append(granted, rest...)
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	return s.withContent(results[:min(topK, len(results))])
+}
+
+// acceptAll is a BatchFilter accepting every candidate
+func acceptAll(docs []models.Document) []bool {
+	allowed := make([]bool, len(docs))
+	for i := range allowed {
+		allowed[i] = true
+	}
+	return allowed
+}
+
+// searchGranted returns hits on every live document of the tenant a grant
+// selects, closest to embedding first. Each document is scored exactly, by
+// its chunks if it has any.
+func (s *SQLiteVectorStore) searchGranted(embedding []float32, grants []MetadataGrant) ([]models.Document, error) {
+	distanceColumn, args := s.distanceColumn("v", embedding)
+	var query strings.Builder
+	query.WriteString(`SELECT d.id, ` + distanceColumn + `
+		FROM documents d
+		JOIN vec_documents v ON v.id = d.id
+		WHERE d.tenant_id = ? AND d.deleted_at IS NULL`)
+	args = append(args, s.tenantID)
+	args = s.appendGrants(&query, args, "d.", grants)
+
+	rows, err := s.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search granted documents: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var hits []models.Document
+	for rows.Next() {
+		var id string
+		var value interface{}
+		if err := rows.Scan(&id, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan granted document: %w", err)
+		}
+		distance, err := s.distance(embedding, value)
+		if err != nil {
+			return nil, err
+		}
+		hit, err := searchHit(id, distance)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating granted documents: %w", err)
+	}
+
+	if err := s.scoreByChunks(embedding, hits); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Distance < hits[j].Distance })
+	return hits, nil
+}
+
+// appendGrants adds a condition selecting the documents any grant selects
+// to query and returns args extended with its values. Columns are qualified
+// with alias like in appendMetadataFilter, and indexed keys compare their
+// generated column. Only string values match, like in matchesGrants.
+func (s *SQLiteVectorStore) appendGrants(query *strings.Builder, args []interface{}, alias string, grants []MetadataGrant) []interface{} {
+	query.WriteString(` AND (`)
+	for i, grant := range grants {
+		if i > 0 {
+			query.WriteString(` OR `)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(grant.Values)), ", ")
+		args = append(args, "$."+grant.Key)
+		if s.indexed[grant.Key] {
+			// Keys are validated identifiers, so they are safe to interpolate
+			fmt.Fprintf(query, `(json_type(%[1]smetadata, ?) = 'text' AND %[1]s"%[2]s%[3]s" IN (%[4]s))`, alias, metadataColumnPrefix, grant.Key, placeholders)
+		} else {
+			fmt.Fprintf(query, `(json_type(%[1]smetadata, ?) = 'text' AND json_extract(%[1]smetadata, ?) IN (%[2]s))`, alias, placeholders)
+			args = append(args, "$."+grant.Key)
+		}
+		for _, value := range grant.Values {
+			args = append(args, value)
+		}
+	}
+	query.WriteString(`)`)
+	return args
+}
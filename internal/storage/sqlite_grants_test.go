@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"testing"
+)
+
+func TestSearchSimilarWithGrants(t *testing.T) {
+	stores := []struct {
+		name string
+		open func(t *testing.T) VectorStore
+	}{
+		{"sqlite", func(t *testing.T) VectorStore {
+			store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "grants.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { cleanupTestStore(store) })
+			return store
+		}},
+		{"sqlite/indexed", func(t *testing.T) VectorStore {
+			store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "grants.db"), WithIndexedMetadata("taxpayer"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { cleanupTestStore(store) })
+			return store
+		}},
+		{"memory", func(t *testing.T) VectorStore {
+			store, err := NewInMemoryVectorStore("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+	}
+	grants := []MetadataGrant{{Key: "taxpayer", Values: []string{"John Doe"}}}
+	query := []float32{1, 0, 0}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.open(t)
+			docs := []*models.Document{
+				{Title: "Doe W-2", Content: "doe w-2", Metadata: map[string]interface{}{"taxpayer": "John Doe"}, Embedding: []float32{1, 0, 0}},
+				{Title: "Doe 1040", Content: "doe 1040", Metadata: map[string]interface{}{"taxpayer": "John Doe", "form": "1040"}, Embedding: []float32{0.9, 0.1, 0}},
+				{Title: "Roe W-2", Content: "roe w-2", Metadata: map[string]interface{}{"taxpayer": "Jane Roe"}, Embedding: []float32{0.95, 0.05, 0}},
+				{Title: "Roe 1040", Content: "roe 1040", Metadata: map[string]interface{}{"taxpayer": "Jane Roe", "form": "1040"}, Embedding: []float32{0.8, 0.2, 0}},
+				{Title: "Listed", Content: "listed", Metadata: map[string]interface{}{"taxpayer": []interface{}{"John Doe"}}, Embedding: []float32{0.85, 0.15, 0}},
+			}
+			for _, doc := range docs {
+				if err := store.AddDocument(doc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			searcher := store.(GrantSearcher)
+
+			// Access is only asked about documents no grant selects; Roe's W-2
+			// is granted directly
+			var checked []string
+			access := func(candidates []models.Document) []bool {
+				allowed := make([]bool, len(candidates))
+				for i, doc := range candidates {
+					checked = append(checked, doc.Title)
+					allowed[i] = doc.ID == docs[2].ID
+				}
+				return allowed
+			}
+			results, err := searcher.SearchSimilarWithGrants(query, 4, grants, nil, access)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			titles := make([]string, len(results))
+			for i, doc := range results {
+				titles[i] = doc.Title
+			}
+			if len(results) != 3 || titles[0] != "Doe W-2" || titles[1] != "Roe W-2" || titles[2] != "Doe 1040" || results[0].Content != "doe w-2" {
+				t.Errorf("Expected the granted and the directly granted documents by distance, got %v", titles)
+			}
+			for _, title := range checked {
+				if title == "Doe W-2" || title == "Doe 1040" {
+					t.Errorf("Expected granted documents not to be checked, got %v", checked)
+				}
+			}
+
+			// Metadata filters apply to granted documents
+			filters := map[string]models.MetadataFilter{"form": {Eq: "1040"}}
+			results, err = searcher.SearchSimilarWithGrants(query, 4, grants, filters, access)
+			if err != nil || len(results) != 1 || results[0].ID != docs[1].ID {
+				t.Errorf("Expected only Doe's 1040, got %+v (%v)", results, err)
+			}
+
+			// Candidates farther than topK granted documents are not checked
+			if _, ok := store.(*SQLiteVectorStore); ok {
+				checked = nil
+				results, err = searcher.SearchSimilarWithGrants(query, 1, grants, nil, access)
+				if err != nil || len(results) != 1 || results[0].ID != docs[0].ID || len(checked) != 0 {
+					t.Errorf("Expected Doe's W-2 without checks, got %+v and checks %v (%v)", results, checked, err)
+				}
+			}
+
+			if err := store.(Trash).TrashDocument(docs[0].ID); err != nil {
+				t.Fatal(err)
+			}
+			results, err = searcher.SearchSimilarWithGrants(query, 1, grants, nil, access)
+			if err != nil || len(results) != 1 || results[0].ID != docs[2].ID {
+				t.Errorf("Expected trashed granted documents to be skipped, got %+v (%v)", results, err)
+			}
+
+			// Grants on keys that are no identifiers leave documents to access
+			results, err = searcher.SearchSimilarWithGrants(query, 4, []MetadataGrant{{Key: "tax payer", Values: []string{"John Doe"}}}, nil, access)
+			if err != nil || len(results) != 1 || results[0].ID != docs[2].ID {
+				t.Errorf("Expected only the directly granted document, got %+v (%v)", results, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package storage
+
+import "fmt"
+
+// Distance metrics of a VectorIndex
+const (
+	VectorMetricL2     = "l2"
+	VectorMetricCosine = "cosine"
+)
+
+// Quantizations of a VectorIndex
+const (
+	QuantizationNone   = "none"
+	QuantizationInt8   = "int8"   // a byte per dimension
+	QuantizationBinary = "binary" // a bit per dimension, compared by Hamming distance
+)
+
+// VectorIndex configures the vector tables of a SQLiteVectorStore. It is
+// applied when the tables are created, with the first stored vector or by a
+// reindex; tables created with other options keep theirs until reindexed.
+// sqlite-vec compares the query with every vector of the tenant, so smaller
+// quantized vectors trade recall for faster scans.
+type VectorIndex struct {
+	// Metric ranks vectors by VectorMetricL2 or VectorMetricCosine distance
+	Metric string
+	// Quantization stores vectors as float32 (QuantizationNone), int8, or bits
+	Quantization string
+}
+
+// DefaultVectorIndex stores float32 vectors ranked by L2 distance, the
+// layout of databases created before the index was configurable
+var DefaultVectorIndex = VectorIndex{Metric: VectorMetricL2, Quantization: QuantizationNone}
+
+// Validate checks that the metric and quantization are known and compatible
+func (ix VectorIndex) Validate() error {
+	if ix.Metric != VectorMetricL2 && ix.Metric != VectorMetricCosine {
+		return fmt.Errorf("unknown vector metric %q, expected %q or %q", ix.Metric, VectorMetricL2, VectorMetricCosine)
+	}
+	switch ix.Quantization {
+	case QuantizationNone, QuantizationInt8:
+	case QuantizationBinary:
+		if ix.Metric != VectorMetricL2 {
+			return fmt.Errorf("binary quantized vectors are compared by Hamming distance and cannot use the %s metric", ix.Metric)
+		}
+	default:
+		return fmt.Errorf("unknown vector quantization %q, expected %q, %q, or %q", ix.Quantization, QuantizationNone, QuantizationInt8, QuantizationBinary)
+	}
+	return nil
+}
+
+func (ix VectorIndex) String() string {
+	return fmt.Sprintf("%s metric, %s quantization", ix.Metric, ix.Quantization)
+}
+
+// WithVectorIndex sets the metric and quantization of newly created vector
+// tables; DefaultVectorIndex if unset
+func WithVectorIndex(index VectorIndex) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.vectorIndex = index
+	}
+}
+
+// checkDimensions reports whether vectors of the given dimensions fit the index
+func (ix VectorIndex) checkDimensions(dimensions int) error {
+	if ix.Quantization == QuantizationBinary && dimensions%8 != 0 {
+		return fmt.Errorf("binary quantization needs embeddings whose dimensions are a multiple of 8, got %d", dimensions)
+	}
+	return nil
+}
+
+// column returns the vec0 column definition of the embedding
+func (ix VectorIndex) column(dimensions int) string {
+	var column string
+	switch ix.Quantization {
+	case QuantizationInt8:
+		column = fmt.Sprintf("INT8[%d]", dimensions)
+	case QuantizationBinary:
+		column = fmt.Sprintf("BIT[%d]", dimensions)
+	default:
+		column = fmt.Sprintf("FLOAT[%d]", dimensions)
+	}
+	if ix.Metric == VectorMetricCosine {
+		column += " distance_metric=cosine"
+	}
+	return column
+}
+
+// param returns the SQL expression turning a bound serialized float32 vector
+// into a vector of the index
+func (ix VectorIndex) param() string {
+	switch ix.Quantization {
+	case QuantizationInt8:
+		return "vec_quantize_int8(?, 'unit')"
+	case QuantizationBinary:
+		return "vec_quantize_binary(?)"
+	}
+	return "?"
+}
+
+// distanceFunc returns the sqlite-vec function computing the index's distance
+func (ix VectorIndex) distanceFunc() string {
+	switch {
+	case ix.Quantization == QuantizationBinary:
+		return "vec_distance_hamming"
+	case ix.Metric == VectorMetricCosine:
+		return "vec_distance_cosine"
+	}
+	return "vec_distance_l2"
+}
+
+// scale maps a sqlite-vec distance between quantized vectors of the given
+// dimensions to about the distance of their float32 originals, so that
+// similarity thresholds keep their meaning: int8 'unit' quantization spreads
+// [-1, 1] over 255 steps, and Hamming distances count differing bits
+func (ix VectorIndex) scale(distance float64, dimensions int) float64 {
+	switch {
+	case ix.Quantization == QuantizationBinary && dimensions > 0:
+		return distance / float64(dimensions)
+	case ix.Quantization == QuantizationInt8 && ix.Metric == VectorMetricL2:
+		return distance / 127.5
+	}
+	return distance
+}
+
+// storedDistance returns the distance under metric between a query and a
+// vector in the format of serializeFloat32Vector, matching sqlite-vec
+func storedDistance(metric string, query []float32, stored []byte) (float64, error) {
+	if metric != VectorMetricCosine {
+		return l2Distance(query, stored)
+	}
+	if len(stored) != len(query)*4 {
+		return 0, fmt.Errorf("embedding has %d dimensions, query has %d", len(stored)/4, len(query))
+	}
+	return 1 - cosineSimilarity(query, deserializeFloat32Vector(stored)), nil
+}
+
+// vectorIndex returns the index of the stored vector tables
+func (s *SQLiteVectorStore) vectorIndex() VectorIndex {
+	s.info.mu.Lock()
+	defer s.info.mu.Unlock()
+	return s.info.index
+}
+
+// distanceColumn returns the SQL selecting the distance between embedding
+// and the vector of the vector table aliased as table, and its arguments.
+// Without sqlite-vec the raw vector is selected, and so is the
+// full-precision copy of a quantized vector if one is kept; distance turns
+// each into the distance.
+func (s *SQLiteVectorStore) distanceColumn(table string, embedding []float32) (string, []interface{}) {
+	if s.goVectors {
+		return table + ".embedding", nil
+	}
+	ix := s.vectorIndex()
+	column := ix.distanceFunc() + "(" + table + ".embedding, " + ix.param() + ")"
+	if s.keepsFull(ix) {
+		column = "COALESCE((SELECT embedding FROM " + fullVecTable + " WHERE id = " + table + ".id), " + column + ")"
+	}
+	return column, []interface{}{serializeFloat32Vector(embedding)}
+}
+
+// distance returns the distance of embedding to a vector stored in the
+// column selected by distanceColumn
+func (s *SQLiteVectorStore) distance(embedding []float32, value interface{}) (float64, error) {
+	ix := s.vectorIndex()
+	switch v := value.(type) {
+	case []byte:
+		return storedDistance(ix.Metric, embedding, v)
+	case float64:
+		return ix.scale(v, len(embedding)), nil
+	}
+	return 0, fmt.Errorf("unexpected distance value %T", value)
+}
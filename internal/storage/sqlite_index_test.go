@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"strings"
+	"testing"
+)
+
+// indexDocuments adds documents at increasing distance from indexQuery under
+// every metric and quantization
+func indexDocuments(t *testing.T, store VectorStore) []*models.Document {
+	t.Helper()
+	docs := []*models.Document{
+		{Title: "Same", Content: "same", Embedding: []float32{1, 1, 1, 1, -1, -1, -1, -1}},
+		{Title: "Near", Content: "near", Embedding: []float32{1, 1, -1, -1, -1, -1, -1, -1}},
+		{Title: "Opposite", Content: "opposite", Embedding: []float32{-1, -1, -1, -1, 1, 1, 1, 1}},
+	}
+	for _, doc := range docs {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add %s: %v", doc.Title, err)
+		}
+	}
+	return docs
+}
+
+var indexQuery = []float32{1, 1, 1, 1, -1, -1, -1, -1}
+
+func TestVectorIndex(t *testing.T) {
+	all := func(*models.Document) bool { return true }
+	tests := []struct {
+		name    string
+		index   VectorIndex
+		rescore int
+		column  string
+		near    float64 // distance of Near from the query
+	}{
+		{"cosine", VectorIndex{Metric: VectorMetricCosine, Quantization: QuantizationNone}, DefaultRescore, "float[8] distance_metric=cosine", 0.5},
+		{"int8", VectorIndex{Metric: VectorMetricL2, Quantization: QuantizationInt8}, 0, "int8[8]", math.Sqrt(8)},
+		{"binary", VectorIndex{Metric: VectorMetricL2, Quantization: QuantizationBinary}, 0, "bit[8]", 0.25},
+		// Rescoring ranks by the exact distance
+		{"binary/rescored", VectorIndex{Metric: VectorMetricL2, Quantization: QuantizationBinary}, DefaultRescore, "bit[8]", math.Sqrt(8)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !nativeVectors && tt.index.Quantization != QuantizationNone {
+				t.Skip("quantization requires sqlite-vec")
+			}
+			store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "index.db"), WithVectorIndex(tt.index), WithRescore(tt.rescore))
+			if err != nil {
+				t.Fatalf("Failed to create SQLite vector store: %v", err)
+			}
+			defer cleanupTestStore(store)
+			docs := indexDocuments(t, store)
+
+			if nativeVectors {
+				var ddl string
+				_ = store.db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'vec_documents'`).Scan(&ddl)
+				if !strings.Contains(strings.ToLower(ddl), tt.column) {
+					t.Errorf("Expected the vector column %s, got %s", tt.column, ddl)
+				}
+			}
+
+			results, err := store.SearchSimilarWithFilter(indexQuery, 3, all)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if len(results) != 3 || results[0].ID != docs[0].ID || results[1].ID != docs[1].ID || results[2].ID != docs[2].ID {
+				t.Fatalf("Expected the documents by distance, got %+v", results)
+			}
+			if results[0].Distance > 0.01 || math.Abs(results[1].Distance-tt.near) > 0.05 {
+				t.Errorf("Expected distances 0 and %v, got %v and %v", tt.near, results[0].Distance, results[1].Distance)
+			}
+
+			// Keyword hits are scored by the same distance
+			if !store.ftsEnabled {
+				return
+			}
+			results, err = store.SearchHybridWithBatchFilter(indexQuery, "near", 1, perDocumentFilter(all), DefaultHybridOptions)
+			if err != nil {
+				t.Fatalf("Hybrid search failed: %v", err)
+			}
+			if len(results) != 1 || results[0].ID != docs[1].ID || math.Abs(results[0].Distance-tt.near) > 0.05 {
+				t.Errorf("Expected Near at distance %v from a hybrid search, got %+v", tt.near, results)
+			}
+		})
+	}
+}
+
+func TestVectorIndexChange(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "index.db")
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := indexDocuments(t, store)
+	_ = store.Close()
+
+	// Existing tables keep their index until they are reindexed
+	cosine := VectorIndex{Metric: VectorMetricCosine, Quantization: QuantizationNone}
+	reopened, err := NewSQLiteVectorStore(dbPath, WithVectorIndex(cosine))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupTestStore(reopened)
+	if got := reopened.vectorIndex(); got != DefaultVectorIndex {
+		t.Errorf("Expected the stored index to be kept, got %s", got)
+	}
+	results, err := reopened.SearchSimilarWithFilter(indexQuery, 2, func(*models.Document) bool { return true })
+	if err != nil || len(results) != 2 || math.Abs(results[1].Distance-math.Sqrt(8)) > 0.01 {
+		t.Errorf("Expected the L2 distance of Near, got %+v (%v)", results, err)
+	}
+
+	vectors := make(map[string][]float32)
+	for _, doc := range docs {
+		vectors[doc.Content] = doc.Embedding
+	}
+	if err := reopened.Reindex(context.Background(), func(_ context.Context, doc *models.Document) ([]float32, error) {
+		return vectors[doc.Content], nil
+	}, nil); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if got := reopened.vectorIndex(); got != cosine {
+		t.Errorf("Expected the reindex to apply the configured index, got %s", got)
+	}
+	results, err = reopened.SearchSimilarWithFilter(indexQuery, 2, func(*models.Document) bool { return true })
+	if err != nil || len(results) != 2 || math.Abs(results[1].Distance-0.5) > 0.01 {
+		t.Errorf("Expected the cosine distance of Near, got %+v (%v)", results, err)
+	}
+	var metric string
+	_ = reopened.db.QueryRow(`SELECT value FROM store_info WHERE key = ?`, infoVectorMetric).Scan(&metric)
+	if metric != VectorMetricCosine {
+		t.Errorf("Expected the metric to be recorded, got %q", metric)
+	}
+}
+
+func TestVectorIndexValidation(t *testing.T) {
+	if err := (VectorIndex{Metric: VectorMetricCosine, Quantization: QuantizationBinary}).Validate(); err == nil {
+		t.Error("Expected binary quantization with the cosine metric to be rejected")
+	}
+	if err := (VectorIndex{Metric: "dot", Quantization: QuantizationNone}).Validate(); err == nil {
+		t.Error("Expected an unknown metric to be rejected")
+	}
+	if _, err := newSQLiteVectorStore(filepath.Join(t.TempDir(), "go.db"), true, WithVectorIndex(VectorIndex{Metric: VectorMetricL2, Quantization: QuantizationInt8})); err == nil {
+		t.Error("Expected quantization without sqlite-vec to be rejected")
+	}
+	if !nativeVectors {
+		return
+	}
+	store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "bit.db"), WithVectorIndex(VectorIndex{Metric: VectorMetricL2, Quantization: QuantizationBinary}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupTestStore(store)
+	if err := store.AddDocument(&models.Document{Title: "Odd", Content: "odd", Embedding: []float32{1, 0, 1}}); err == nil {
+		t.Error("Expected binary vectors of dimensions other than a multiple of 8 to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Rekeyer is implemented by stores that can change their encryption key
+// while serving requests
+type Rekeyer interface {
+	// Rekey re-encrypts the database with key. Connections opened afterwards
+	// use key; the old key no longer opens the database.
+	Rekey(ctx context.Context, key string) error
+}
+
+// keyedConnector opens connections with a DSN whose SQLCipher key can be
+// replaced, so the pool picks up a new key without reopening the store
+type keyedConnector struct {
+	driver driver.Driver
+
+	mu  sync.RWMutex
+	dsn string
+}
+
+// newKeyedConnector creates a connector opening dsn with the named driver
+func newKeyedConnector(driverName, dsn string) (*keyedConnector, error) {
+	db, err := sql.Open(driverName, "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+	return &keyedConnector{driver: db.Driver(), dsn: dsn}, nil
+}
+
+// Connect opens a connection with the current DSN
+func (c *keyedConnector) Connect(context.Context) (driver.Conn, error) {
+	c.mu.RLock()
+	dsn := c.dsn
+	c.mu.RUnlock()
+	return c.driver.Open(dsn)
+}
+
+// Driver returns the underlying driver
+func (c *keyedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// setKey replaces the SQLCipher key of the DSN
+func (c *keyedConnector) setKey(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path, query, _ := strings.Cut(c.dsn, "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return fmt.Errorf("failed to parse database DSN: %w", err)
+	}
+	params.Set("_pragma_key", key)
+	c.dsn = path + "?" + params.Encode()
+	return nil
+}
+
+// keyed reports whether the DSN carries a SQLCipher key
+func (c *keyedConnector) keyed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, query, _ := strings.Cut(c.dsn, "?")
+	params, err := url.ParseQuery(query)
+	return err == nil && params.Get("_pragma_key") != ""
+}
+
+// defaultMaxIdleConns is database/sql's default number of idle connections
+const defaultMaxIdleConns = 2
+
+// Rekey re-encrypts the database with key. The pool is narrowed to a single
+// connection for the rekey: requests wait for it instead of failing, and the
+// connections opened with the old key are closed as they are released. The
+// configured key must be changed to key before the next restart.
+func (s *SQLiteVectorStore) Rekey(ctx context.Context, key string) error {
+	if !EncryptionSupported || !s.connector.keyed() {
+		return fmt.Errorf("%w: the database is not encrypted", ErrEncryptionUnavailable)
+	}
+	if key == "" {
+		return errors.New("the new key must not be empty")
+	}
+
+	s.rekeyMu.Lock()
+	defer s.rekeyMu.Unlock()
+	s.db.SetMaxIdleConns(0)
+	s.db.SetMaxOpenConns(1)
+	defer func() {
+		s.db.SetMaxOpenConns(s.maxOpenConns)
+		s.db.SetMaxIdleConns(defaultMaxIdleConns)
+	}()
+
+	// Waits until the connections in use are released and closed
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reserve a connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The WAL is encrypted with the old key and must be empty beforehand
+	if _, err := conn.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("failed to checkpoint the database: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA rekey = '%s'", strings.ReplaceAll(key, "'", "''"))); err != nil {
+		return fmt.Errorf("failed to rekey the database: %w", err)
+	}
+	return s.connector.setKey(key)
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Quantized vector tables can keep full-precision copies of their vectors in
+// vec_full, keyed like vec_documents and vec_chunks. Searches then fetch
+// more candidates from the quantized scan and rank them by exact distance,
+// recovering most of the recall lost to quantization. Without copies the
+// database only holds the quantized vectors.
+
+// fullVecTable holds the full-precision copies of quantized vectors
+const fullVecTable = "vec_full"
+
+// shadowFullVecTable holds the full-precision copies of a reindex until the swap
+const shadowFullVecTable = "vec_full_shadow"
+
+// DefaultRescore is the number of quantized candidates fetched per result
+// and ranked by their full-precision vectors
+const DefaultRescore = 4
+
+// WithRescore keeps full-precision copies of quantized vectors and ranks
+// factor candidates per result of the quantized scan by them; 0 stores only
+// the quantized vectors. Without quantization it has no effect.
+func WithRescore(factor int) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.rescore = factor
+	}
+}
+
+// execer runs statements on a database or in a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// fullVecTableQuery returns the DDL for a table of full-precision vectors
+func fullVecTableQuery(name string) string {
+	return `CREATE TABLE IF NOT EXISTS ` + name + ` (id TEXT PRIMARY KEY, embedding BLOB NOT NULL)`
+}
+
+// initFullVectors creates the table of full-precision vectors
+func (s *SQLiteVectorStore) initFullVectors() error {
+	if _, err := s.db.Exec(fullVecTableQuery(fullVecTable)); err != nil {
+		return fmt.Errorf("failed to create %s table: %w", fullVecTable, err)
+	}
+	return nil
+}
+
+// keepsFull reports whether vectors stored under index have full-precision copies
+func (s *SQLiteVectorStore) keepsFull(index VectorIndex) bool {
+	return s.rescore > 0 && index.Quantization != QuantizationNone
+}
+
+// writeFullVector stores the full-precision copy of the vector id written
+// under index into table, or removes a stale copy if none is kept
+func (s *SQLiteVectorStore) writeFullVector(tx execer, table string, index VectorIndex, id string, embedding []float32) error {
+	if !s.keepsFull(index) {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete full-precision vector: %w", err)
+		}
+		return nil
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO `+table+` (id, embedding) VALUES (?, ?)`, id, serializeFloat32Vector(embedding)); err != nil {
+		return fmt.Errorf("failed to insert full-precision vector: %w", err)
+	}
+	return nil
+}
+
+// deleteFullVectors removes the full-precision copies of a document's vector
+// and its chunks' vectors from table
+func deleteFullVectors(tx execer, table, docID string) error {
+	if _, err := tx.Exec(`DELETE FROM `+table+` WHERE id = ? OR id LIKE ?`, docID, docID+":%"); err != nil {
+		return fmt.Errorf("failed to delete full-precision vectors: %w", err)
+	}
+	return nil
+}
+
+// candidates returns how many quantized candidates to fetch for n results
+func (s *SQLiteVectorStore) candidates(index VectorIndex, n int) int {
+	if s.keepsFull(index) {
+		return n * s.rescore
+	}
+	return n
+}
+
+// fullDistances returns the exact distances under metric of embedding to the
+// full-precision copies of the vectors ids; vectors without a copy are missing
+func (s *SQLiteVectorStore) fullDistances(embedding []float32, metric string, ids []string) (map[string]float64, error) {
+	distances := make(map[string]float64, len(ids))
+	if len(ids) == 0 {
+		return distances, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	rows, err := s.db.Query(`SELECT id, embedding FROM `+fullVecTable+` WHERE id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query full-precision vectors: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var id string
+		var stored []byte
+		if err := rows.Scan(&id, &stored); err != nil {
+			return nil, fmt.Errorf("failed to scan full-precision vector: %w", err)
+		}
+		if distances[id], err = storedDistance(metric, embedding, stored); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating full-precision vectors: %w", err)
+	}
+	return distances, nil
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/models"
+	"sort"
+	"testing"
+)
+
+// randomVectors returns n reproducible vectors of the given dimensions
+func randomVectors(seed int64, n, dimensions int) [][]float32 {
+	r := rand.New(rand.NewSource(seed))
+	vectors := make([][]float32, n)
+	for i := range vectors {
+		vectors[i] = make([]float32, dimensions)
+		for j := range vectors[i] {
+			vectors[i][j] = float32(r.NormFloat64()) / 4
+		}
+	}
+	return vectors
+}
+
+// measureRecall stores corpus under index and returns the share of the exact
+// k nearest neighbours of the queries that searches find
+func measureRecall(tb testing.TB, index VectorIndex, rescore int, corpus, queries [][]float32, k int) float64 {
+	tb.Helper()
+	store, err := NewSQLiteVectorStore(filepath.Join(tb.TempDir(), "recall.db"), WithVectorIndex(index), WithRescore(rescore))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer cleanupTestStore(store)
+	ids := make(map[string]int, len(corpus))
+	for i, vector := range corpus {
+		doc := &models.Document{Title: fmt.Sprint(i), Content: fmt.Sprint(i), Embedding: vector}
+		if err := store.AddDocument(doc); err != nil {
+			tb.Fatal(err)
+		}
+		ids[doc.ID.String()] = i
+	}
+
+	found := 0
+	for _, query := range queries {
+		exact := make([]int, len(corpus))
+		distances := make([]float64, len(corpus))
+		for i, vector := range corpus {
+			exact[i] = i
+			distances[i], _ = l2Distance(query, serializeFloat32Vector(vector))
+		}
+		sort.Slice(exact, func(a, b int) bool { return distances[exact[a]] < distances[exact[b]] })
+		nearest := make(map[int]bool, k)
+		for _, i := range exact[:k] {
+			nearest[i] = true
+		}
+
+		results, err := store.searchWithSqliteVec(query, k)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		for _, doc := range results {
+			if nearest[ids[doc.ID.String()]] {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(k*len(queries))
+}
+
+func TestRescoreRecall(t *testing.T) {
+	if !nativeVectors {
+		t.Skip("quantization requires sqlite-vec")
+	}
+	corpus, queries := randomVectors(1, 300, 32), randomVectors(2, 10, 32)
+	binary := VectorIndex{Metric: VectorMetricL2, Quantization: QuantizationBinary}
+
+	quantized := measureRecall(t, binary, 0, corpus, queries, 10)
+	rescored := measureRecall(t, binary, 8, corpus, queries, 10)
+	if rescored <= quantized || rescored < 0.6 {
+		t.Errorf("Expected rescoring to improve the recall of binary vectors, got %.2f without and %.2f with", quantized, rescored)
+	}
+}
+
+func TestRescoreFullVectors(t *testing.T) {
+	if !nativeVectors {
+		t.Skip("quantization requires sqlite-vec")
+	}
+	store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "full.db"), WithVectorIndex(VectorIndex{Metric: VectorMetricL2, Quantization: QuantizationInt8}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupTestStore(store)
+	count := func() int {
+		var n int
+		_ = store.db.QueryRow(`SELECT COUNT(*) FROM ` + fullVecTable).Scan(&n)
+		return n
+	}
+
+	docs := indexDocuments(t, store)
+	chunked := &models.Document{Title: "Chunked", Content: "northsouth", Embedding: indexQuery, Chunks: []models.DocumentChunk{
+		{Start: 0, End: 5, Embedding: indexQuery},
+		{Start: 5, End: 10, Embedding: docs[2].Embedding},
+	}}
+	if err := store.AddDocument(chunked); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 6 {
+		t.Errorf("Expected full-precision copies of 4 documents and 2 chunks, got %d", n)
+	}
+
+	// Exports return the full-precision embeddings
+	err = store.ExportDocuments(nil, func(doc *models.Document) error {
+		if len(doc.Embedding) != len(indexQuery) {
+			return fmt.Errorf("document %s exported without its embedding", doc.Title)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := store.DeleteDocument(chunked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("Expected the copies of the deleted document and its chunks to be removed, got %d", n)
+	}
+
+	// A reindex replaces the copies
+	vectors := map[string][]float32{}
+	for _, doc := range docs {
+		vectors[doc.Content] = doc.Embedding
+	}
+	if err := store.Reindex(context.Background(), func(_ context.Context, doc *models.Document) ([]float32, error) {
+		return vectors[doc.Content], nil
+	}, nil); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("Expected the reindex to keep a copy per document, got %d", n)
+	}
+	results, err := store.SearchSimilarWithFilter(indexQuery, 1, func(*models.Document) bool { return true })
+	if err != nil || len(results) != 1 || results[0].ID != docs[0].ID {
+		t.Errorf("Expected Same to be found after reindexing, got %+v (%v)", results, err)
+	}
+}
+
+// BenchmarkQuantizationRecall reports the recall@10 of each quantization
+// with and without rescoring, e.g. go test -bench QuantizationRecall ./internal/storage
+func BenchmarkQuantizationRecall(b *testing.B) {
+	if !nativeVectors {
+		b.Skip("quantization requires sqlite-vec")
+	}
+	corpus, queries := randomVectors(1, 2000, 256), randomVectors(2, 20, 256)
+	for _, quantization := range []string{QuantizationNone, QuantizationInt8, QuantizationBinary} {
+		for _, rescore := range []int{0, DefaultRescore} {
+			if quantization == QuantizationNone && rescore > 0 {
+				continue
+			}
+			b.Run(fmt.Sprintf("%s/rescore=%d", quantization, rescore), func(b *testing.B) {
+				var recall float64
+				for b.Loop() {
+					recall = measureRecall(b, VectorIndex{Metric: VectorMetricL2, Quantization: quantization}, rescore, corpus, queries, 10)
+				}
+				b.ReportMetric(recall, "recall@10")
+			})
+		}
+	}
+}
@@ -2,34 +2,176 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
+	"net/url"
+	"regexp"
 	"rerag-rbac-rag-llm/internal/models"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
-	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
 	"github.com/google/uuid"
-	_ "github.com/mattn/go-sqlite3" // Import sqlite3 driver
 )
 
-func init() {
-	sqlite_vec.Auto()
+// SQLiteVectorStore implements a SQLite-based vector storage system using sqlite-vec.
+// Every read and write is scoped to a single tenant; use ForTenant to obtain a
+// view for another tenant.
+//
+// Binaries built with CGO_ENABLED=0 use the pure Go modernc.org/sqlite driver
+// instead, which cannot load sqlite-vec: embeddings are then stored in a plain
+// table and scored in Go.
+type SQLiteVectorStore struct {
+	db         *sql.DB
+	info       *storeInfo // shared by all tenant views
+	model      string     // embedding model of new vectors; empty skips the model check
+	tenantID   string
+	ftsEnabled bool // false when the sqlite3 driver is built without FTS5
+	goVectors  bool // true when vectors are scored in Go instead of by sqlite-vec
+	// indexed holds the metadata keys with an indexed generated column,
+	// which exact-match filters compare instead of extracting the JSON
+	indexed map[string]bool
+	// chunkScore combines the chunk distances of a document: ChunkScoreMax
+	// or ChunkScoreMean
+	chunkScore string
+	// index configures vector tables created from now on; the stored ones
+	// are described by info.index
+	index VectorIndex
+	// rescore is the number of quantized candidates per result ranked by
+	// their full-precision copies; 0 keeps no copies
+	rescore int
+	// connector opens the pooled connections; Rekey changes its key
+	connector    *keyedConnector
+	maxOpenConns int
+	rekeyMu      *sync.Mutex // shared by all tenant views
 }
 
-// SQLiteVectorStore implements a SQLite-based vector storage system using sqlite-vec
-type SQLiteVectorStore struct {
-	db              *sql.DB
-	embeddingLength int
+// storeInfo is the fingerprint of the stored vectors, persisted in the
+// store_info table when the first vector is stored
+type storeInfo struct {
+	mu         sync.Mutex
+	model      string
+	dimensions int // 0 until the first vector is stored
+	index      VectorIndex
+}
+
+// Keys of the store_info table
+const (
+	infoEmbeddingModel      = "embedding_model"
+	infoEmbeddingDimensions = "embedding_dimensions"
+	infoVectorMetric        = "vector_metric"
+	infoVectorQuantization  = "vector_quantization"
+)
+
+// ErrEncryptionUnavailable is returned when encryption was requested but the
+// database is not encrypted
+var ErrEncryptionUnavailable = errors.New("database encryption unavailable")
+
+// SQLiteOption configures the connections of a SQLiteVectorStore
+type SQLiteOption func(*sqliteOptions)
+
+type sqliteOptions struct {
+	journalMode     string
+	busyTimeout     time.Duration
+	maxOpenConns    int
+	indexedMetadata []string
+	encrypted       bool
+	chunkScore      string
+	vectorIndex     VectorIndex
+	rescore         int
+}
+
+// Connection defaults that let concurrent requests read while one writes
+const (
+	DefaultJournalMode  = "wal"
+	DefaultBusyTimeout  = 5 * time.Second
+	DefaultMaxOpenConns = 4
+)
+
+// WithJournalMode sets the SQLite journal mode, e.g. "wal" or "delete"
+func WithJournalMode(mode string) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.journalMode = mode
+	}
+}
+
+// WithBusyTimeout sets how long a connection waits for a lock held by
+// another connection before failing with SQLITE_BUSY
+func WithBusyTimeout(timeout time.Duration) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.busyTimeout = timeout
+	}
+}
+
+// WithEncryption makes the store verify on open that the database is
+// encrypted with the key in the DSN. Opening fails with
+// ErrEncryptionUnavailable if the driver lacks SQLCipher or the database
+// can be read without the key.
+func WithEncryption() SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.encrypted = true
+	}
+}
+
+// WithMaxOpenConns limits the connection pool; 1 serializes all access
+func WithMaxOpenConns(n int) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.maxOpenConns = n
+	}
+}
+
+// WithIndexedMetadata adds an indexed generated column for each top-level
+// metadata key, so exact-match filters on frequently filtered keys such as
+// "taxpayer" or "year" don't scan the tenant's documents. Columns of keys no
+// longer listed are dropped.
+func WithIndexedMetadata(keys ...string) SQLiteOption {
+	return func(o *sqliteOptions) {
+		o.indexedMetadata = keys
+	}
 }
 
 // NewSQLiteVectorStore creates a new SQLite-based vector store with sqlite-vec support
-func NewSQLiteVectorStore(dsn string) (*SQLiteVectorStore, error) {
-	db, err := sql.Open("sqlite3", dsn)
+func NewSQLiteVectorStore(dsn string, opts ...SQLiteOption) (*SQLiteVectorStore, error) {
+	return newSQLiteVectorStore(dsn, !nativeVectors, opts...)
+}
+
+// newSQLiteVectorStore opens the store, scoring vectors in Go if goVectors is set
+func newSQLiteVectorStore(dsn string, goVectors bool, opts ...SQLiteOption) (*SQLiteVectorStore, error) {
+	o := sqliteOptions{journalMode: DefaultJournalMode, busyTimeout: DefaultBusyTimeout, maxOpenConns: DefaultMaxOpenConns, vectorIndex: DefaultVectorIndex, rescore: DefaultRescore}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.vectorIndex.Validate(); err != nil {
+		return nil, err
+	}
+	if o.rescore < 0 {
+		return nil, fmt.Errorf("rescore factor must not be negative, got %d", o.rescore)
+	}
+	if goVectors && o.vectorIndex.Quantization != QuantizationNone {
+		return nil, fmt.Errorf("vector quantization requires sqlite-vec and a binary built with CGO_ENABLED=1")
+	}
+
+	// Fail before a driver without SQLCipher writes a plaintext database
+	if o.encrypted && !EncryptionSupported {
+		return nil, fmt.Errorf("%w: the server is built without the sqlcipher tag", ErrEncryptionUnavailable)
+	}
+
+	connector, err := newKeyedConnector(sqliteDriver, withConnectionParams(dsn, o.busyTimeout))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	db := sql.OpenDB(connector)
+	db.SetMaxOpenConns(o.maxOpenConns)
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
@@ -37,27 +179,119 @@ func NewSQLiteVectorStore(dsn string) (*SQLiteVectorStore, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	// The journal mode is stored in the database file, so setting it once
+	// applies to every connection
+	if o.journalMode != "" {
+		if _, err := db.Exec("PRAGMA journal_mode = " + o.journalMode); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to set journal mode %q: %w", o.journalMode, err)
+		}
+	}
+
 	store := &SQLiteVectorStore{
-		db:              db,
-		embeddingLength: 768, // Default for nomic-embed-text, will be updated on first insert
+		db:           db,
+		info:         &storeInfo{index: DefaultVectorIndex},
+		tenantID:     tenant.Default,
+		goVectors:    goVectors,
+		chunkScore:   o.chunkScore,
+		index:        o.vectorIndex,
+		rescore:      o.rescore,
+		connector:    connector,
+		maxOpenConns: o.maxOpenConns,
+		rekeyMu:      &sync.Mutex{},
 	}
 
-	if err := store.initDB(); err != nil {
+	if err := store.initDB(o.indexedMetadata); err != nil {
 		_ = db.Close()
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
+	if stored := store.info.index; store.info.dimensions > 0 && stored != store.index {
+		log.Printf("Warning: stored vectors use %s instead of the configured %s until they are reindexed", stored, store.index)
+	}
+
+	if o.encrypted {
+		if err := verifyEncryption(db, dsn, o.busyTimeout); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
 
 	return store, nil
 }
 
+// verifyEncryption checks that the driver is built with SQLCipher and that
+// the database of dsn, initialized with its tables, cannot be read without
+// the key. Drivers without SQLCipher ignore the key pragmas and store
+// plaintext.
+func verifyEncryption(db *sql.DB, dsn string, busyTimeout time.Duration) error {
+	var version string
+	if err := db.QueryRow("PRAGMA cipher_version").Scan(&version); err != nil || version == "" {
+		return fmt.Errorf("%w: the sqlite driver is built without SQLCipher", ErrEncryptionUnavailable)
+	}
+	if isMemoryDSN(dsn) {
+		return nil // a second connection would open another database
+	}
+
+	plain, err := sql.Open(sqliteDriver, withConnectionParams(withoutKey(dsn), busyTimeout))
+	if err != nil {
+		return fmt.Errorf("failed to open database without key: %w", err)
+	}
+	defer func() { _ = plain.Close() }()
+	var tables int
+	if err := plain.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&tables); err == nil {
+		return fmt.Errorf("%w: the database can be read without the key", ErrEncryptionUnavailable)
+	}
+	return nil
+}
+
+// withoutKey removes the SQLCipher parameters from dsn
+func withoutKey(dsn string) string {
+	path, query, found := strings.Cut(dsn, "?")
+	if !found {
+		return dsn
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return path
+	}
+	for name := range params {
+		if strings.HasPrefix(name, "_pragma_key") || strings.HasPrefix(name, "_pragma_cipher") {
+			params.Del(name)
+		}
+	}
+	if len(params) == 0 {
+		return path
+	}
+	return path + "?" + params.Encode()
+}
+
+// isMemoryDSN reports whether dsn names an in-memory database
+func isMemoryDSN(dsn string) bool {
+	path, query, _ := strings.Cut(dsn, "?")
+	return strings.TrimPrefix(path, "file:") == ":memory:" || strings.Contains(query, "mode=memory")
+}
+
+// withConnectionParams appends the driver's per-connection settings to dsn
+func withConnectionParams(dsn string, busyTimeout time.Duration) string {
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + strings.Join(connectionParams(busyTimeout), "&")
+}
+
 // initDB creates the necessary tables for storing documents and embeddings using sqlite-vec
-func (s *SQLiteVectorStore) initDB() error {
+func (s *SQLiteVectorStore) initDB(indexedMetadata []string) error {
 	// Create metadata table for documents
 	metadataQuery := `
 	CREATE TABLE IF NOT EXISTS documents (
 		id TEXT PRIMARY KEY,
 		title TEXT NOT NULL,
-		content TEXT NOT NULL
+		content TEXT NOT NULL,
+		tenant_id TEXT NOT NULL DEFAULT 'default',
+		metadata TEXT NOT NULL DEFAULT '{}',
+		created_at INTEGER NOT NULL DEFAULT 0,
+		deleted_at INTEGER
 	);
 	`
 
@@ -65,305 +299,1858 @@ func (s *SQLiteVectorStore) initDB() error {
 		return fmt.Errorf("failed to create documents table: %w", err)
 	}
 
-	return nil
-}
+	if err := s.checkVecTable(); err != nil {
+		return err
+	}
 
-// Close closes the database connection
-func (s *SQLiteVectorStore) Close() error {
-	return s.db.Close()
-}
+	if err := s.migrateTenantColumns(); err != nil {
+		return fmt.Errorf("failed to migrate tenant columns: %w", err)
+	}
 
-// serializeFloat32Vector converts a float32 slice to the byte format expected by sqlite-vec
-func serializeFloat32Vector(vec []float32) []byte {
-	buf := make([]byte, len(vec)*4)
-	for i, v := range vec {
-		binary.LittleEndian.PutUint32(buf[i*4:(i+1)*4], math.Float32bits(v))
+	// Columns added after the initial schema; legacy rows get empty metadata and a zero timestamp
+	if err := s.ensureColumn("metadata", "TEXT NOT NULL DEFAULT '{}'"); err != nil {
+		return fmt.Errorf("failed to migrate metadata column: %w", err)
+	}
+	if err := s.ensureColumn("created_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate created_at column: %w", err)
+	}
+	if err := s.ensureColumn("deleted_at", "INTEGER"); err != nil {
+		return fmt.Errorf("failed to migrate deleted_at column: %w", err)
 	}
-	return buf
-}
 
-// AddDocument stores a new document with its embedding in the vector store
-func (s *SQLiteVectorStore) AddDocument(doc *models.Document) error {
-	if doc.ID == uuid.Nil {
-		newID, err := uuid.NewUUID()
-		if err != nil {
-			return fmt.Errorf("failed to generate UUID: %w", err)
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_documents_tenant ON documents(tenant_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_documents_tenant_title ON documents(tenant_id, title)`,
+		`CREATE INDEX IF NOT EXISTS idx_documents_tenant_created ON documents(tenant_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_documents_deleted ON documents(deleted_at)`,
+	}
+	for _, index := range indexes {
+		if _, err := s.db.Exec(index); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
 		}
-		doc.ID = newID
 	}
 
-	// Ensure vec_documents table exists with correct dimensions
-	if err := s.ensureVecTableExists(len(doc.Embedding)); err != nil {
-		return fmt.Errorf("failed to ensure vec table exists: %w", err)
+	if err := s.indexMetadata(indexedMetadata); err != nil {
+		return fmt.Errorf("failed to index metadata: %w", err)
 	}
 
-	// Start transaction
-	tx, err := s.db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+	if err := s.initFTS(); err != nil {
+		return fmt.Errorf("failed to initialize full-text index: %w", err)
 	}
-	defer func() { _ = tx.Rollback() }()
 
-	// Insert metadata
-	metadataQuery := `INSERT INTO documents (id, title, content) VALUES (?, ?, ?)`
-	if _, err := tx.Exec(metadataQuery, doc.ID.String(), doc.Title, doc.Content); err != nil {
-		return fmt.Errorf("failed to insert document metadata: %w", err)
+	if err := s.loadStoreInfo(); err != nil {
+		return fmt.Errorf("failed to load store info: %w", err)
 	}
 
-	// Insert vector
-	embeddingBytes := serializeFloat32Vector(doc.Embedding)
-	vecQuery := `INSERT INTO vec_documents (id, embedding) VALUES (?, ?)`
-	if _, err := tx.Exec(vecQuery, doc.ID.String(), embeddingBytes); err != nil {
-		return fmt.Errorf("failed to insert document vector: %w", err)
+	if err := s.initChunks(); err != nil {
+		return fmt.Errorf("failed to initialize chunks: %w", err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	if err := s.initFullVectors(); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// ensureVecTableExists creates the vec_documents table if it doesn't exist
-func (s *SQLiteVectorStore) ensureVecTableExists(embeddingLen int) error {
-	// Check if we need to update the embedding length
-	if s.embeddingLength != embeddingLen && s.embeddingLength != 768 {
-		// Only allow changing from default, otherwise it's an error
-		var count int
-		if err := s.db.QueryRow("SELECT COUNT(*) FROM documents").Scan(&count); err == nil && count > 0 {
-			return fmt.Errorf("cannot change embedding length from %d to %d with existing documents", s.embeddingLength, embeddingLen)
-		}
+// loadStoreInfo reads the fingerprint of the stored vectors. Databases created
+// before fingerprinting get the dimensions of their vector table recorded and
+// adopt the model set with SetEmbeddingModel.
+func (s *SQLiteVectorStore) loadStoreInfo() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS store_info (key TEXT PRIMARY KEY, value TEXT NOT NULL)`); err != nil {
+		return err
 	}
 
-	// Check if table exists
-	var tableExists int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='vec_documents'").Scan(&tableExists)
+	rows, err := s.db.Query(`SELECT key, value FROM store_info`)
 	if err != nil {
-		return fmt.Errorf("failed to check vec_documents existence: %w", err)
+		return err
 	}
-
-	if tableExists == 0 {
-		s.embeddingLength = embeddingLen
-		vecQuery := fmt.Sprintf(`
-			CREATE VIRTUAL TABLE vec_documents USING vec0(
-				id TEXT PRIMARY KEY,
-				embedding FLOAT[%d]
-			)
-		`, s.embeddingLength)
-
-		if _, err := s.db.Exec(vecQuery); err != nil {
-			return fmt.Errorf("failed to create vec_documents table: %w", err)
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		switch key {
+		case infoEmbeddingModel:
+			s.info.model = value
+		case infoEmbeddingDimensions:
+			if s.info.dimensions, err = strconv.Atoi(value); err != nil {
+				return fmt.Errorf("invalid embedding dimensions %q: %w", value, err)
+			}
+		case infoVectorMetric:
+			s.info.index.Metric = value
+		case infoVectorQuantization:
+			s.info.index.Quantization = value
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	return nil
+	if s.info.dimensions > 0 {
+		return nil
+	}
+	dimensions, err := s.vecTableDimensions()
+	if err != nil || dimensions == 0 {
+		return err
+	}
+	s.info.dimensions = dimensions
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO store_info (key, value) VALUES (?, ?)`, infoEmbeddingDimensions, strconv.Itoa(dimensions))
+	return err
 }
 
-// UpsertDocument inserts or updates a document with its embedding in the vector store
-func (s *SQLiteVectorStore) UpsertDocument(doc *models.Document) error {
-	if doc.ID == uuid.Nil {
-		newID, err := uuid.NewUUID()
-		if err != nil {
-			return fmt.Errorf("failed to generate UUID: %w", err)
-		}
-		doc.ID = newID
+// vecTableDimensions returns the dimensions of the vectors in vec_documents,
+// or 0 if it holds none
+func (s *SQLiteVectorStore) vecTableDimensions() (int, error) {
+	var vecSQL string
+	err := s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='vec_documents'`).Scan(&vecSQL)
+	if err == sql.ErrNoRows {
+		return 0, nil
 	}
-
-	// Ensure vec_documents table exists with correct dimensions
-	if err := s.ensureVecTableExists(len(doc.Embedding)); err != nil {
-		return fmt.Errorf("failed to ensure vec table exists: %w", err)
+	if err != nil {
+		return 0, err
 	}
 
-	// Start transaction
-	tx, err := s.db.Begin()
+	var bytes int
+	err = s.db.QueryRow(`SELECT length(embedding) FROM vec_documents LIMIT 1`).Scan(&bytes)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return 0, err
 	}
-	defer func() { _ = tx.Rollback() }()
-
-	// Upsert metadata
-	metadataQuery := `
-		INSERT INTO documents (id, title, content)
-		VALUES (?, ?, ?)
-		ON CONFLICT(id) DO UPDATE SET
-			title = excluded.title,
-			content = excluded.content
-	`
-	if _, err := tx.Exec(metadataQuery, doc.ID.String(), doc.Title, doc.Content); err != nil {
-		return fmt.Errorf("failed to upsert document metadata: %w", err)
+	if match := vecDimensionsPattern.FindStringSubmatch(vecSQL); match != nil {
+		return strconv.Atoi(match[1])
 	}
+	return bytes / 4, nil
+}
 
-	// Upsert vector (delete and insert since vec0 doesn't support UPDATE)
-	if _, err := tx.Exec(`DELETE FROM vec_documents WHERE id = ?`, doc.ID.String()); err != nil {
-		return fmt.Errorf("failed to delete old vector: %w", err)
-	}
+// vecDimensionsPattern extracts the dimensions from a vec0 table definition
+var vecDimensionsPattern = regexp.MustCompile(`(?i)(?:FLOAT|INT8|BIT)\[(\d+)\]`)
 
-	embeddingBytes := serializeFloat32Vector(doc.Embedding)
-	vecQuery := `INSERT INTO vec_documents (id, embedding) VALUES (?, ?)`
-	if _, err := tx.Exec(vecQuery, doc.ID.String(), embeddingBytes); err != nil {
-		return fmt.Errorf("failed to insert document vector: %w", err)
+// SetEmbeddingModel sets the name of the model new vectors are embedded with.
+// Vectors and queries of another model than the stored vectors are rejected
+// with *EmbeddingMismatchError. Call it before using the store.
+func (s *SQLiteVectorStore) SetEmbeddingModel(model string) error {
+	s.model = model
+	s.info.mu.Lock()
+	defer s.info.mu.Unlock()
+	if s.info.model != "" || s.info.dimensions == 0 || model == "" {
+		return nil
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	// Vectors stored before fingerprinting are assumed to be of this model
+	if _, err := s.db.Exec(`INSERT OR REPLACE INTO store_info (key, value) VALUES (?, ?)`, infoEmbeddingModel, model); err != nil {
+		return fmt.Errorf("failed to record embedding model: %w", err)
 	}
-
+	s.info.model = model
 	return nil
 }
 
-const (
-	initialMultiplier = 2
-	growthFactor      = 2.0
-	maxAttempts       = 10
-)
+// checkEmbedding returns *EmbeddingMismatchError if an embedding of s.model
+// with the given dimensions cannot be compared with the stored vectors. The
+// caller must hold s.info.mu.
+func (s *SQLiteVectorStore) checkEmbedding(dimensions int) error {
+	if s.info.dimensions == 0 {
+		return nil
+	}
+	modelMismatch := s.model != "" && s.info.model != "" && s.model != s.info.model
+	if dimensions != s.info.dimensions || modelMismatch {
+		return &EmbeddingMismatchError{
+			StoredModel:      s.info.model,
+			StoredDimensions: s.info.dimensions,
+			Model:            s.model,
+			Dimensions:       dimensions,
+		}
+	}
+	return nil
+}
 
-// SearchSimilarWithFilter finds the top K most similar documents with an optional filter
-// Uses sqlite-vec's KNN search for efficient vector similarity
-// Recursively increases the candidate pool until topK matching documents are found
-func (s *SQLiteVectorStore) SearchSimilarWithFilter(embedding []float32, topK int, filter func(*models.Document) bool) ([]models.Document, error) {
-	return s.searchWithFilterRecursive(embedding, topK, filter, initialMultiplier, 0)
+// checkQuery validates a query embedding against the stored vectors. It
+// reports false if no vector has been stored yet.
+func (s *SQLiteVectorStore) checkQuery(embedding []float32) (bool, error) {
+	s.info.mu.Lock()
+	defer s.info.mu.Unlock()
+	return s.info.dimensions > 0, s.checkEmbedding(len(embedding))
 }
 
-// searchWithFilterRecursive recursively fetches more candidates until topK matching documents are found
-func (s *SQLiteVectorStore) searchWithFilterRecursive(embedding []float32, topK int, filter func(*models.Document) bool, multiplier int, attempt int) ([]models.Document, error) {
-	// Safety check to prevent infinite recursion
-	if attempt >= maxAttempts {
-		log.Printf("Warning: Reached max attempts (%d) in recursive search, returning partial results", maxAttempts)
-		// Return whatever we can get with the maximum multiplier
-		candidates, err := s.searchWithSqliteVec(embedding, topK*multiplier)
+// initFTS creates the FTS5 keyword index and the triggers that keep it in sync
+// with the documents table. Keyword search is disabled if FTS5 is unavailable
+// (build with -tags sqlite_fts5).
+func (s *SQLiteVectorStore) initFTS() error {
+	var exists int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'documents_fts'`).Scan(&exists); err != nil {
+		return err
+	}
+
+	if exists == 0 {
+		_, err := s.db.Exec(`CREATE VIRTUAL TABLE documents_fts USING fts5(id UNINDEXED, title, content)`)
 		if err != nil {
-			return nil, err
+			if strings.Contains(err.Error(), "no such module") {
+				log.Printf("Warning: FTS5 is not available, hybrid search falls back to vector search")
+				return nil
+			}
+			return err
 		}
-		return s.applyFilter(candidates, topK, filter), nil
 	}
 
-	// Fetch candidates with current multiplier
-	candidateCount := topK * multiplier
-	candidates, err := s.searchWithSqliteVec(embedding, candidateCount)
-	if err != nil {
-		return nil, err
+	triggers := []string{
+		`CREATE TRIGGER IF NOT EXISTS documents_fts_insert AFTER INSERT ON documents BEGIN
+			INSERT INTO documents_fts (id, title, content) VALUES (new.id, new.title, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS documents_fts_update AFTER UPDATE OF title, content ON documents BEGIN
+			DELETE FROM documents_fts WHERE id = old.id;
+			INSERT INTO documents_fts (id, title, content) VALUES (new.id, new.title, new.content);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS documents_fts_delete AFTER DELETE ON documents BEGIN
+			DELETE FROM documents_fts WHERE id = old.id;
+		END`,
+	}
+	for _, trigger := range triggers {
+		if _, err := s.db.Exec(trigger); err != nil {
+			return err
+		}
 	}
 
-	// Apply filter
-	filtered := s.applyFilter(candidates, topK, filter)
-
-	// If we have enough results or no more documents exist, return
-	if len(filtered) >= topK || len(candidates) < candidateCount {
-		return filtered, nil
+	// Index documents stored before the FTS table existed
+	if exists == 0 {
+		if _, err := s.db.Exec(`INSERT INTO documents_fts (id, title, content) SELECT id, title, content FROM documents`); err != nil {
+			return err
+		}
 	}
 
-	// Not enough results, recurse with increased multiplier
-	newMultiplier := int(float64(multiplier) * growthFactor)
-	log.Printf("Only found %d/%d matching documents, increasing search from %d to %d candidates (attempt %d/%d)",
-		len(filtered), topK, candidateCount, topK*newMultiplier, attempt+1, maxAttempts)
-	return s.searchWithFilterRecursive(embedding, topK, filter, newMultiplier, attempt+1)
+	s.ftsEnabled = true
+	return nil
 }
 
-// applyFilter applies the filter function to candidates and returns up to topK results
-func (s *SQLiteVectorStore) applyFilter(candidates []models.Document, topK int, filter func(*models.Document) bool) []models.Document {
-	var filtered []models.Document
-	for i := range candidates {
-		if filter(&candidates[i]) {
-			filtered = append(filtered, candidates[i])
-			if len(filtered) >= topK {
-				break
-			}
-		}
+// ensureColumn adds a column to the documents table if it does not exist yet
+func (s *SQLiteVectorStore) ensureColumn(name, definition string) error {
+	var hasColumn int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('documents') WHERE name = ?`, name).Scan(&hasColumn); err != nil {
+		return err
 	}
-	return filtered
+	if hasColumn > 0 {
+		return nil
+	}
+
+	_, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE documents ADD COLUMN %s %s`, name, definition))
+	return err
 }
 
-// searchWithSqliteVec performs KNN vector search using sqlite-vec
-func (s *SQLiteVectorStore) searchWithSqliteVec(embedding []float32, topK int) ([]models.Document, error) {
-	embeddingBytes := serializeFloat32Vector(embedding)
+// metadataColumnPrefix prefixes the generated columns of indexed metadata keys
+const metadataColumnPrefix = "meta_"
 
-	// Use sqlite-vec's KNN search with distance calculation
-	// Note: sqlite-vec requires the k parameter to be passed as part of the MATCH expression
-	query := `
-		SELECT
-			d.id,
-			d.title,
-			d.content,
-			v.distance
-		FROM vec_documents v
-		JOIN documents d ON d.id = v.id
-		WHERE v.embedding MATCH ? AND k = ?
-		ORDER BY v.distance
-	`
+// indexMetadata adds a generated column holding the text of each metadata
+// key, compared like CAST(json_extract(...) AS TEXT), with an index by
+// tenant, and drops the columns of keys no longer indexed. Generated columns
+// are virtual, so existing rows are covered without rewriting them.
+func (s *SQLiteVectorStore) indexMetadata(keys []string) error {
+	s.indexed = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid indexed metadata key: %s", key)
+		}
+		s.indexed[key] = true
+	}
 
-	rows, err := s.db.Query(query, embeddingBytes, topK)
+	rows, err := s.db.Query(`SELECT name FROM pragma_table_xinfo('documents') WHERE name GLOB ?`, metadataColumnPrefix+"*")
 	if err != nil {
-		return nil, fmt.Errorf("failed to perform vector search: %w", err)
+		return err
 	}
-	defer func() { _ = rows.Close() }()
-
-	var results []models.Document
+	existing := make(map[string]bool)
 	for rows.Next() {
-		var id, title, content string
-		var distance float32
-
-		if err := rows.Scan(&id, &title, &content, &distance); err != nil {
-			log.Printf("Error scanning row: %v", err)
-			continue
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return err
 		}
+		existing[strings.TrimPrefix(name, metadataColumnPrefix)] = true
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-		docID, err := uuid.Parse(id)
-		if err != nil {
-			log.Printf("Error parsing UUID %s: %v", id, err)
+	// Keys are validated identifiers, so they are safe to interpolate. The
+	// virtual columns of keys no longer indexed are kept where SQLite cannot
+	// drop columns; filters on those keys ignore them.
+	dropColumns, err := s.canDropColumns()
+	if err != nil {
+		return err
+	}
+	for key := range existing {
+		if s.indexed[key] {
 			continue
 		}
+		if _, err := s.db.Exec(fmt.Sprintf(`DROP INDEX IF EXISTS "idx_documents_%s%s"`, metadataColumnPrefix, key)); err != nil {
+			return err
+		}
+		if dropColumns {
+			if _, err := s.db.Exec(fmt.Sprintf(`ALTER TABLE documents DROP COLUMN "%s%s"`, metadataColumnPrefix, key)); err != nil {
+				return err
+			}
+		}
+		log.Printf("Dropped index of metadata key %q", key)
+	}
+	for _, key := range keys {
+		column := metadataColumnPrefix + key
+		if !existing[key] {
+			definition := fmt.Sprintf(`ALTER TABLE documents ADD COLUMN "%s" TEXT GENERATED ALWAYS AS (CAST(json_extract(metadata, '$.%s') AS TEXT)) VIRTUAL`, column, key)
+			if _, err := s.db.Exec(definition); err != nil {
+				return err
+			}
+		}
+		if _, err := s.db.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "idx_documents_%[1]s" ON documents(tenant_id, "%[1]s")`, column)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		results = append(results, models.Document{
-			ID:      docID,
-			Title:   title,
-			Content: content,
-			// Note: We don't fetch the embedding vector to save memory
-			// If needed, it can be fetched separately
-		})
+// canDropColumns reports whether SQLite supports ALTER TABLE DROP COLUMN,
+// which the SQLite bundled with SQLCipher predates
+func (s *SQLiteVectorStore) canDropColumns() (bool, error) {
+	var version string
+	if err := s.db.QueryRow(`SELECT sqlite_version()`).Scan(&version); err != nil {
+		return false, err
 	}
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		return false, fmt.Errorf("unexpected sqlite version %q", version)
+	}
+	return major > 3 || (major == 3 && minor >= 35), nil
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating results: %w", err)
+// migrateTenantColumns upgrades databases created before multi-tenancy support.
+// Existing documents are assigned to the default tenant.
+func (s *SQLiteVectorStore) migrateTenantColumns() error {
+	if err := s.ensureColumn("tenant_id", "TEXT NOT NULL DEFAULT 'default'"); err != nil {
+		return err
 	}
 
-	return results, nil
+	var vecSQL string
+	err := s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='vec_documents'`).Scan(&vecSQL)
+	if err == sql.ErrNoRows || (err == nil && strings.Contains(vecSQL, "tenant_id")) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.rebuildVecTableWithTenant()
 }
 
-// GetAllDocuments returns all documents in the store (without embeddings for efficiency)
-func (s *SQLiteVectorStore) GetAllDocuments() []models.Document {
-	query := `SELECT id, title, content FROM documents ORDER BY id DESC`
-	rows, err := s.db.Query(query)
+// checkVecTable rejects databases whose vector table was created by the other
+// backend: sqlite-vec tables cannot be read without the extension, and plain
+// tables do not support KNN queries
+func (s *SQLiteVectorStore) checkVecTable() error {
+	var vecSQL string
+	err := s.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='vec_documents'`).Scan(&vecSQL)
+	if err == sql.ErrNoRows {
+		return nil
+	}
 	if err != nil {
-		log.Printf("Error querying all documents: %v", err)
-		return []models.Document{}
+		return err
 	}
-	defer func() { _ = rows.Close() }()
 
-	var documents []models.Document
-
-	for rows.Next() {
-		var id, title, content string
-		if err := rows.Scan(&id, &title, &content); err != nil {
+	native := strings.Contains(vecSQL, "vec0")
+	if native && s.goVectors {
+		return fmt.Errorf("database was created with sqlite-vec and requires a binary built with CGO_ENABLED=1")
+	}
+	if !native && !s.goVectors {
+		return fmt.Errorf("database was created by a binary built with CGO_ENABLED=0 and cannot be used with sqlite-vec")
+	}
+	return nil
+}
+
+// rebuildVecTableWithTenant recreates vec_documents with a tenant partition key,
+// since vec0 virtual tables cannot be altered in place
+func (s *SQLiteVectorStore) rebuildVecTableWithTenant() error {
+	log.Println("Migrating vec_documents to a tenant-partitioned table")
+
+	rows, err := s.db.Query(`SELECT v.id, d.tenant_id, v.embedding FROM vec_documents v JOIN documents d ON d.id = v.id`)
+	if err != nil {
+		return err
+	}
+
+	type vecRow struct {
+		id, tenantID string
+		embedding    []byte
+	}
+	var existing []vecRow
+	for rows.Next() {
+		var row vecRow
+		if err := rows.Scan(&row.id, &row.tenantID, &row.embedding); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		existing = append(existing, row)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	dimensions, err := s.vecTableDimensions()
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`DROP TABLE vec_documents`); err != nil {
+		return err
+	}
+	// An empty table is created again with the first stored vector
+	if dimensions == 0 {
+		return tx.Commit()
+	}
+	// Legacy tables hold float32 vectors compared by L2 distance
+	if _, err := tx.Exec(s.vecTableQuery("vec_documents", dimensions, DefaultVectorIndex)); err != nil {
+		return err
+	}
+	for _, row := range existing {
+		if _, err := tx.Exec(`INSERT INTO vec_documents (id, tenant_id, embedding) VALUES (?, ?, ?)`, row.id, row.tenantID, row.embedding); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// vecTableQuery returns the DDL for a vector table named name holding
+// embeddings of the given dimensions: a vec0 virtual table laid out by index,
+// or a plain table when vectors are scored in Go
+func (s *SQLiteVectorStore) vecTableQuery(name string, dimensions int, index VectorIndex) string {
+	if s.goVectors {
+		return fmt.Sprintf(`
+		CREATE TABLE %[1]s (
+			id TEXT PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			embedding BLOB NOT NULL
+		);
+		CREATE INDEX idx_%[1]s_tenant ON %[1]s(tenant_id);
+	`, name)
+	}
+	return fmt.Sprintf(`
+		CREATE VIRTUAL TABLE %s USING vec0(
+			id TEXT PRIMARY KEY,
+			tenant_id TEXT PARTITION KEY,
+			embedding %s
+		)
+	`, name, index.column(dimensions))
+}
+
+// ForTenant returns a view of the store scoped to the given tenant. The view
+// shares the underlying database connection with the original store.
+func (s *SQLiteVectorStore) ForTenant(tenantID string) VectorStore {
+	scoped := *s
+	scoped.tenantID = tenantID
+	return &scoped
+}
+
+// Close closes the database connection
+func (s *SQLiteVectorStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping verifies the database is reachable by running a trivial query
+func (s *SQLiteVectorStore) Ping(ctx context.Context) error {
+	var one int
+	if err := s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("database ping failed: %w", err)
+	}
+	return nil
+}
+
+// serializeFloat32Vector converts a float32 slice to the byte format expected by sqlite-vec
+func serializeFloat32Vector(vec []float32) []byte {
+	buf := make([]byte, len(vec)*4)
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[i*4:(i+1)*4], math.Float32bits(v))
+	}
+	return buf
+}
+
+// deserializeFloat32Vector is the inverse of serializeFloat32Vector
+func deserializeFloat32Vector(buf []byte) []float32 {
+	if len(buf) == 0 {
+		return nil
+	}
+	vec := make([]float32, len(buf)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+	}
+	return vec
+}
+
+// l2Distance returns the Euclidean distance between a query and a vector in
+// the format of serializeFloat32Vector, matching sqlite-vec's vec_distance_l2
+func l2Distance(query []float32, stored []byte) (float64, error) {
+	if len(stored) != len(query)*4 {
+		return 0, fmt.Errorf("embedding has %d dimensions, query has %d", len(stored)/4, len(query))
+	}
+	var sum float64
+	for i, q := range query {
+		d := float64(math.Float32frombits(binary.LittleEndian.Uint32(stored[i*4:])) - q)
+		sum += d * d
+	}
+	return math.Sqrt(sum), nil
+}
+
+// marshalMetadata serializes document metadata for the metadata column
+func marshalMetadata(metadata map[string]interface{}) (string, error) {
+	if len(metadata) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	return string(data), nil
+}
+
+// newDocument builds a document from stored columns
+func (s *SQLiteVectorStore) newDocument(id, title, content, metadataJSON string, createdAt int64) (models.Document, error) {
+	docID, err := uuid.Parse(id)
+	if err != nil {
+		return models.Document{}, fmt.Errorf("error parsing UUID %s: %w", id, err)
+	}
+
+	doc := models.Document{
+		ID:       docID,
+		Title:    title,
+		Content:  content,
+		TenantID: s.tenantID,
+	}
+	if metadataJSON != "" && metadataJSON != "{}" {
+		if err := json.Unmarshal([]byte(metadataJSON), &doc.Metadata); err != nil {
+			return models.Document{}, fmt.Errorf("error parsing metadata of %s: %w", id, err)
+		}
+	}
+	if createdAt > 0 {
+		doc.CreatedAt = time.Unix(createdAt, 0).UTC()
+	}
+	return doc, nil
+}
+
+// deletedTime converts the deleted_at column into Document.DeletedAt
+func deletedTime(deletedAt sql.NullInt64) *time.Time {
+	if !deletedAt.Valid {
+		return nil
+	}
+	t := time.Unix(deletedAt.Int64, 0).UTC()
+	return &t
+}
+
+// AddDocument stores a new document with its embedding in the vector store
+func (s *SQLiteVectorStore) AddDocument(doc *models.Document) error {
+	if doc.ID == uuid.Nil {
+		newID, err := uuid.NewUUID()
+		if err != nil {
+			return fmt.Errorf("failed to generate UUID: %w", err)
+		}
+		doc.ID = newID
+	}
+
+	if err := ValidateChunks(doc); err != nil {
+		return err
+	}
+
+	// Ensure vec_documents table exists with correct dimensions
+	if err := s.ensureVecTableExists(len(doc.Embedding)); err != nil {
+		return fmt.Errorf("failed to ensure vec table exists: %w", err)
+	}
+
+	// Start transaction
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	metadata, err := marshalMetadata(doc.Metadata)
+	if err != nil {
+		return err
+	}
+
+	doc.TenantID = s.tenantID
+	doc.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	// Insert metadata
+	metadataQuery := `INSERT INTO documents (id, title, content, tenant_id, metadata, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := tx.Exec(metadataQuery, doc.ID.String(), doc.Title, doc.Content, s.tenantID, metadata, doc.CreatedAt.Unix()); err != nil {
+		return fmt.Errorf("failed to insert document metadata: %w", err)
+	}
+
+	// Insert vector
+	embeddingBytes := serializeFloat32Vector(doc.Embedding)
+	vecQuery := `INSERT INTO vec_documents (id, tenant_id, embedding) VALUES (?, ?, ` + s.vectorIndex().param() + `)`
+	if _, err := tx.Exec(vecQuery, doc.ID.String(), s.tenantID, embeddingBytes); err != nil {
+		return fmt.Errorf("failed to insert document vector: %w", err)
+	}
+	if err := s.writeFullVector(tx, fullVecTable, s.vectorIndex(), doc.ID.String(), doc.Embedding); err != nil {
+		return err
+	}
+
+	if err := s.writeChunks(tx, doc); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ensureVecTableExists validates an embedding of embeddingLen dimensions
+// against the stored vectors. For the first vector it records the model and
+// dimensions and creates the vec_documents and vec_chunks tables.
+func (s *SQLiteVectorStore) ensureVecTableExists(embeddingLen int) error {
+	s.info.mu.Lock()
+	defer s.info.mu.Unlock()
+	if err := s.checkEmbedding(embeddingLen); err != nil {
+		return err
+	}
+
+	if s.info.dimensions > 0 {
+		return nil
+	}
+	if err := s.index.checkDimensions(embeddingLen); err != nil {
+		return err
+	}
+
+	// No vectors are stored, so a vector table left over without any is
+	// replaced by one of the new dimensions and the configured index
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`DROP TABLE IF EXISTS vec_documents`); err != nil {
+		return fmt.Errorf("failed to drop empty vec_documents table: %w", err)
+	}
+	if _, err := tx.Exec(s.vecTableQuery("vec_documents", embeddingLen, s.index)); err != nil {
+		return fmt.Errorf("failed to create vec_documents table: %w", err)
+	}
+	if _, err := tx.Exec(`DROP TABLE IF EXISTS vec_chunks`); err != nil {
+		return fmt.Errorf("failed to drop empty vec_chunks table: %w", err)
+	}
+	if _, err := tx.Exec(s.vecTableQuery("vec_chunks", embeddingLen, s.index)); err != nil {
+		return fmt.Errorf("failed to create vec_chunks table: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM ` + fullVecTable); err != nil {
+		return fmt.Errorf("failed to delete full-precision vectors: %w", err)
+	}
+	if err := writeStoreInfo(tx, s.model, embeddingLen, s.index); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.info.model = s.model
+	s.info.dimensions = embeddingLen
+	s.info.index = s.index
+	return nil
+}
+
+// writeStoreInfo records the fingerprint and index of the stored vectors
+func writeStoreInfo(tx *sql.Tx, model string, dimensions int, index VectorIndex) error {
+	info := map[string]string{
+		infoEmbeddingModel:      model,
+		infoEmbeddingDimensions: strconv.Itoa(dimensions),
+		infoVectorMetric:        index.Metric,
+		infoVectorQuantization:  index.Quantization,
+	}
+	for key, value := range info {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO store_info (key, value) VALUES (?, ?)`, key, value); err != nil {
+			return fmt.Errorf("failed to record store info: %w", err)
+		}
+	}
+	return nil
+}
+
+// UpsertDocument inserts or updates a document with its embedding in the vector store
+func (s *SQLiteVectorStore) UpsertDocument(doc *models.Document) error {
+	if doc.ID == uuid.Nil {
+		newID, err := uuid.NewUUID()
+		if err != nil {
+			return fmt.Errorf("failed to generate UUID: %w", err)
+		}
+		doc.ID = newID
+	}
+
+	if err := ValidateChunks(doc); err != nil {
+		return err
+	}
+
+	// Ensure vec_documents table exists with correct dimensions
+	if err := s.ensureVecTableExists(len(doc.Embedding)); err != nil {
+		return fmt.Errorf("failed to ensure vec table exists: %w", err)
+	}
+
+	// Start transaction
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	metadata, err := marshalMetadata(doc.Metadata)
+	if err != nil {
+		return err
+	}
+
+	doc.TenantID = s.tenantID
+
+	// Upsert metadata; documents owned by another tenant are never overwritten
+	// and trashed documents are restored
+	metadataQuery := `
+		INSERT INTO documents (id, title, content, tenant_id, metadata, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			title = excluded.title,
+			content = excluded.content,
+			metadata = excluded.metadata,
+			deleted_at = NULL
+		WHERE documents.tenant_id = excluded.tenant_id
+	`
+	result, err := tx.Exec(metadataQuery, doc.ID.String(), doc.Title, doc.Content, s.tenantID, metadata, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to upsert document metadata: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return fmt.Errorf("document %s belongs to another tenant", doc.ID)
+	}
+
+	// Upsert vector (delete and insert since vec0 doesn't support UPDATE)
+	if _, err := tx.Exec(`DELETE FROM vec_documents WHERE id = ?`, doc.ID.String()); err != nil {
+		return fmt.Errorf("failed to delete old vector: %w", err)
+	}
+
+	embeddingBytes := serializeFloat32Vector(doc.Embedding)
+	vecQuery := `INSERT INTO vec_documents (id, tenant_id, embedding) VALUES (?, ?, ` + s.vectorIndex().param() + `)`
+	if _, err := tx.Exec(vecQuery, doc.ID.String(), s.tenantID, embeddingBytes); err != nil {
+		return fmt.Errorf("failed to insert document vector: %w", err)
+	}
+	if err := s.writeFullVector(tx, fullVecTable, s.vectorIndex(), doc.ID.String(), doc.Embedding); err != nil {
+		return err
+	}
+
+	if err := s.writeChunks(tx, doc); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// GetDocument returns a single document of the tenant by ID
+func (s *SQLiteVectorStore) GetDocument(id uuid.UUID) (*models.Document, error) {
+	var title, content, metadata string
+	var createdAt int64
+	err := s.db.QueryRow(`SELECT title, content, metadata, created_at FROM documents WHERE id = ? AND tenant_id = ? AND deleted_at IS NULL`, id.String(), s.tenantID).
+		Scan(&title, &content, &metadata, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get document: %w", err)
+	}
+
+	doc, err := s.newDocument(id.String(), title, content, metadata, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// UpdateDocument updates an existing document of the tenant. The stored vector
+// is only replaced when doc carries a new embedding, and then its chunks with
+// doc's chunks.
+func (s *SQLiteVectorStore) UpdateDocument(doc *models.Document) error {
+	if err := ValidateChunks(doc); err != nil {
+		return err
+	}
+	if len(doc.Embedding) > 0 {
+		if err := s.ensureVecTableExists(len(doc.Embedding)); err != nil {
+			return fmt.Errorf("failed to ensure vec table exists: %w", err)
+		}
+	}
+
+	metadata, err := marshalMetadata(doc.Metadata)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(`UPDATE documents SET title = ?, content = ?, metadata = ? WHERE id = ? AND tenant_id = ? AND deleted_at IS NULL`,
+		doc.Title, doc.Content, metadata, doc.ID.String(), s.tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to update document metadata: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrDocumentNotFound
+	}
+
+	if len(doc.Embedding) > 0 {
+		if _, err := tx.Exec(`DELETE FROM vec_documents WHERE id = ?`, doc.ID.String()); err != nil {
+			return fmt.Errorf("failed to delete old vector: %w", err)
+		}
+		vecQuery := `INSERT INTO vec_documents (id, tenant_id, embedding) VALUES (?, ?, ` + s.vectorIndex().param() + `)`
+		if _, err := tx.Exec(vecQuery, doc.ID.String(), s.tenantID, serializeFloat32Vector(doc.Embedding)); err != nil {
+			return fmt.Errorf("failed to insert document vector: %w", err)
+		}
+		if err := s.writeFullVector(tx, fullVecTable, s.vectorIndex(), doc.ID.String(), doc.Embedding); err != nil {
+			return err
+		}
+		if err := s.writeChunks(tx, doc); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	doc.TenantID = s.tenantID
+	return nil
+}
+
+// DeleteDocument removes a document of the tenant together with its vectors
+func (s *SQLiteVectorStore) DeleteDocument(id uuid.UUID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(`DELETE FROM documents WHERE id = ? AND tenant_id = ?`, id.String(), s.tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to delete document: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrDocumentNotFound
+	}
+
+	var vecTable int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='vec_documents'").Scan(&vecTable); err != nil {
+		return fmt.Errorf("failed to check vec_documents existence: %w", err)
+	}
+	if vecTable > 0 {
+		if _, err := tx.Exec(`DELETE FROM vec_documents WHERE id = ?`, id.String()); err != nil {
+			return fmt.Errorf("failed to delete document vector: %w", err)
+		}
+		if err := deleteChunks(tx, id.String()); err != nil {
+			return err
+		}
+		if err := deleteFullVectors(tx, fullVecTable, id.String()); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// TrashDocument moves a document of the tenant to the trash. Its vector is
+// kept so that a restored document is searchable again.
+func (s *SQLiteVectorStore) TrashDocument(id uuid.UUID) error {
+	result, err := s.db.Exec(`UPDATE documents SET deleted_at = ? WHERE id = ? AND tenant_id = ? AND deleted_at IS NULL`,
+		time.Now().Unix(), id.String(), s.tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to trash document: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrDocumentNotFound
+	}
+	return nil
+}
+
+// RestoreDocument moves a trashed document of the tenant back
+func (s *SQLiteVectorStore) RestoreDocument(id uuid.UUID) error {
+	result, err := s.db.Exec(`UPDATE documents SET deleted_at = NULL WHERE id = ? AND tenant_id = ? AND deleted_at IS NOT NULL`,
+		id.String(), s.tenantID)
+	if err != nil {
+		return fmt.Errorf("failed to restore document: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrDocumentNotFound
+	}
+	return nil
+}
+
+// PurgeTrash permanently deletes the documents of all tenants trashed before
+// cutoff together with their vectors
+func (s *SQLiteVectorStore) PurgeTrash(cutoff time.Time) ([]models.Document, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.Query(`SELECT id, tenant_id, title, content, metadata, created_at, deleted_at FROM documents WHERE deleted_at < ?`, cutoff.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trashed documents: %w", err)
+	}
+	var purged []models.Document
+	for rows.Next() {
+		var id, tenantID, title, content, metadata string
+		var createdAt int64
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&id, &tenantID, &title, &content, &metadata, &createdAt, &deletedAt); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan trashed document: %w", err)
+		}
+		doc, err := s.newDocument(id, title, content, metadata, createdAt)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		doc.TenantID = tenantID
+		doc.DeletedAt = deletedTime(deletedAt)
+		purged = append(purged, doc)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating results: %w", err)
+	}
+	if len(purged) == 0 {
+		return nil, nil
+	}
+
+	var vecTable int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='vec_documents'").Scan(&vecTable); err != nil {
+		return nil, fmt.Errorf("failed to check vec_documents existence: %w", err)
+	}
+	for _, doc := range purged {
+		if _, err := tx.Exec(`DELETE FROM documents WHERE id = ?`, doc.ID.String()); err != nil {
+			return nil, fmt.Errorf("failed to purge document: %w", err)
+		}
+		if vecTable > 0 {
+			if _, err := tx.Exec(`DELETE FROM vec_documents WHERE id = ?`, doc.ID.String()); err != nil {
+				return nil, fmt.Errorf("failed to purge document vector: %w", err)
+			}
+			if err := deleteChunks(tx, doc.ID.String()); err != nil {
+				return nil, err
+			}
+			if err := deleteFullVectors(tx, fullVecTable, doc.ID.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return purged, nil
+}
+
+// Usage counts the tenant's live documents matching the metadata filters and
+// the bytes of their content
+func (s *SQLiteVectorStore) Usage(metadata map[string]string) (Usage, error) {
+	if err := ValidateMetadataFilter(metadata); err != nil {
+		return Usage{}, err
+	}
+
+	var query strings.Builder
+	query.WriteString(`SELECT COUNT(*), COALESCE(SUM(LENGTH(CAST(content AS BLOB))), 0) FROM documents WHERE tenant_id = ? AND deleted_at IS NULL`)
+	args := s.appendMetadataFilter(&query, []interface{}{s.tenantID}, "", metadata)
+
+	var usage Usage
+	if err := s.db.QueryRow(query.String(), args...).Scan(&usage.Documents, &usage.ContentBytes); err != nil {
+		return Usage{}, fmt.Errorf("failed to measure usage: %w", err)
+	}
+	return usage, nil
+}
+
+// Aggregate counts and sums the tenant's live documents matching opts in SQL,
+// grouped by the JSON value of the GroupBy key, or by each element of a JSON
+// array. Numbers are returned as float64 like decoded metadata.
+func (s *SQLiteVectorStore) Aggregate(opts AggregateOptions) ([]AggregateGroup, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if len(opts.IDs) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(opts.IDs))
+	for i, id := range opts.IDs {
+		ids[i] = id.String()
+	}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal document IDs: %w", err)
+	}
+
+	var query strings.Builder
+	var args []interface{}
+	if opts.GroupBy != "" {
+		// Elements of arrays come from the join below; empty arrays join none
+		query.WriteString(`SELECT CASE WHEN json_type(metadata, ?) = 'array' THEN e.value ELSE json_extract(metadata, ?) END AS group_value, COUNT(*)`)
+		args = append(args, "$."+opts.GroupBy, "$."+opts.GroupBy)
+	} else {
+		query.WriteString(`SELECT NULL AS group_value, COUNT(*)`)
+	}
+	for _, key := range opts.Sum {
+		query.WriteString(`, TOTAL(CASE WHEN json_type(metadata, ?) IN ('integer', 'real') THEN json_extract(metadata, ?) END)`)
+		args = append(args, "$."+key, "$."+key)
+	}
+
+	// Documents sharing the distinct key collapse into one row first; they
+	// share their metadata, such as the chunks of one upload
+	if opts.DistinctKey != "" {
+		query.WriteString(` FROM (SELECT MIN(metadata) AS metadata FROM documents`)
+	} else {
+		query.WriteString(` FROM (SELECT metadata FROM documents`)
+	}
+	query.WriteString(` WHERE tenant_id = ? AND deleted_at IS NULL AND id IN (SELECT value FROM json_each(?))`)
+	args = s.appendMetadataFilter(&query, append(args, s.tenantID, string(idsJSON)), "", opts.Metadata)
+	if opts.DistinctKey != "" {
+		query.WriteString(` GROUP BY COALESCE('key:' || CAST(json_extract(metadata, ?) AS TEXT), id)`)
+		args = append(args, "$."+opts.DistinctKey)
+	}
+	query.WriteString(`)`)
+	if opts.GroupBy != "" {
+		query.WriteString(` LEFT JOIN json_each(CASE WHEN json_type(metadata, ?) = 'array' THEN json_extract(metadata, ?) END) AS e`)
+		args = append(args, "$."+opts.GroupBy, "$."+opts.GroupBy)
+	}
+	query.WriteString(` GROUP BY group_value ORDER BY COUNT(*) DESC, group_value`)
+
+	rows, err := s.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate documents: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var groups []AggregateGroup
+	for rows.Next() {
+		var group AggregateGroup
+		sums := make([]float64, len(opts.Sum))
+		dest := []interface{}{&group.Value, &group.Documents}
+		for i := range sums {
+			dest = append(dest, &sums[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan aggregate: %w", err)
+		}
+		switch v := group.Value.(type) {
+		case int64:
+			group.Value = float64(v)
+		case []byte:
+			group.Value = string(v)
+		}
+		if len(opts.Sum) > 0 {
+			group.Sums = make(map[string]float64, len(opts.Sum))
+			for i, key := range opts.Sum {
+				group.Sums[key] = sums[i]
+			}
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating aggregates: %w", err)
+	}
+	return groups, nil
+}
+
+// Stats reports the fingerprint of the stored vectors and the size of the
+// database file from its page count, which excludes the write-ahead log
+func (s *SQLiteVectorStore) Stats() (StoreStats, error) {
+	s.info.mu.Lock()
+	stats := StoreStats{EmbeddingModel: s.info.model, EmbeddingDimensions: s.info.dimensions}
+	s.info.mu.Unlock()
+
+	var pages, pageSize int64
+	if err := s.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return StoreStats{}, fmt.Errorf("failed to measure database size: %w", err)
+	}
+	if err := s.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return StoreStats{}, fmt.Errorf("failed to measure database size: %w", err)
+	}
+	stats.SizeBytes = pages * pageSize
+	return stats, nil
+}
+
+// Tenants returns the IDs of the tenants with live documents, sorted
+func (s *SQLiteVectorStore) Tenants() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT tenant_id FROM documents WHERE deleted_at IS NULL ORDER BY tenant_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var tenants []string
+	for rows.Next() {
+		var tenantID string
+		if err := rows.Scan(&tenantID); err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenants = append(tenants, tenantID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tenants: %w", err)
+	}
+	return tenants, nil
+}
+
+// ExpiredDocuments returns up to limit documents of all tenants that expired
+// under policy before now, oldest first. Expiry dates in metadata are
+// compared with SQLite's julianday, so only text values are considered.
+func (s *SQLiteVectorStore) ExpiredDocuments(policy RetentionPolicy, now time.Time, limit int) ([]models.Document, error) {
+	var query strings.Builder
+	var args []interface{}
+	query.WriteString(`SELECT id, tenant_id, title, content, metadata, created_at, deleted_at FROM documents WHERE `)
+	byAge := `(? > 0 AND created_at > 0 AND created_at < ?)`
+	ageArgs := []interface{}{int64(policy.MaxAge), now.Add(-policy.MaxAge).Unix()}
+	if policy.ExpiresAtKey != "" {
+		path := "$." + policy.ExpiresAtKey
+		query.WriteString(`CASE WHEN json_type(metadata, ?) = 'text' AND julianday(json_extract(metadata, ?)) IS NOT NULL
+			THEN julianday(json_extract(metadata, ?)) < julianday(?) ELSE ` + byAge + ` END`)
+		args = append(args, path, path, path, now.UTC().Format(time.RFC3339))
+	} else {
+		query.WriteString(byAge)
+	}
+	args = append(args, ageArgs...)
+	query.WriteString(` ORDER BY created_at, id LIMIT ?`)
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired documents: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var expired []models.Document
+	for rows.Next() {
+		var id, tenantID, title, content, metadata string
+		var createdAt int64
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&id, &tenantID, &title, &content, &metadata, &createdAt, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan expired document: %w", err)
+		}
+		doc, err := s.newDocument(id, title, content, metadata, createdAt)
+		if err != nil {
+			return nil, err
+		}
+		doc.TenantID = tenantID
+		doc.DeletedAt = deletedTime(deletedAt)
+		expired = append(expired, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating results: %w", err)
+	}
+	return expired, nil
+}
+
+const (
+	initialMultiplier = 2
+	growthFactor      = 2.0
+	maxAttempts       = 10
+)
+
+// SearchSimilarWithFilter finds the top K most similar documents with an optional filter
+// Uses sqlite-vec's KNN search for efficient vector similarity
+// Recursively increases the candidate pool until topK matching documents are found
+func (s *SQLiteVectorStore) SearchSimilarWithFilter(embedding []float32, topK int, filter func(*models.Document) bool) ([]models.Document, error) {
+	return s.SearchSimilarWithBatchFilter(embedding, topK, perDocumentFilter(filter))
+}
+
+// SearchSimilarWithBatchFilter behaves like SearchSimilarWithFilter but evaluates the
+// filter once per candidate batch, allowing permission checks to be batched
+func (s *SQLiteVectorStore) SearchSimilarWithBatchFilter(embedding []float32, topK int, filter BatchFilter) ([]models.Document, error) {
+	if stored, err := s.checkQuery(embedding); err != nil || !stored {
+		return nil, err
+	}
+	candidates := s.newCandidateCache()
+	fetch := func(n int) ([]models.Document, error) {
+		hits, err := s.searchWithChunks(embedding, n)
+		if err != nil {
+			return nil, err
+		}
+		return candidates.fill(hits)
+	}
+	results, err := searchWithFilterRecursive(fetch, topK, withoutTrashed(filter), initialMultiplier, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.withContent(results)
+}
+
+// SearchHybridWithBatchFilter combines vector similarity with FTS5 keyword matches
+// on query using weighted reciprocal rank fusion. Without FTS5 it is equivalent
+// to SearchSimilarWithBatchFilter.
+func (s *SQLiteVectorStore) SearchHybridWithBatchFilter(embedding []float32, query string, topK int, filter BatchFilter, opts HybridOptions) ([]models.Document, error) {
+	if !s.ftsEnabled {
+		return s.SearchSimilarWithBatchFilter(embedding, topK, filter)
+	}
+	if stored, err := s.checkQuery(embedding); err != nil || !stored {
+		return nil, err
+	}
+
+	candidates := s.newCandidateCache()
+	fetch := func(n int) ([]models.Document, error) {
+		vectorHits, err := s.searchWithChunks(embedding, n)
+		if err != nil {
+			return nil, err
+		}
+		keywordHits, err := s.searchWithFTS(query, embedding, n)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.scoreByChunks(embedding, keywordHits); err != nil {
+			return nil, err
+		}
+		fused := FuseRankings(vectorHits, keywordHits, opts)
+		return candidates.fill(fused[:min(n, len(fused))])
+	}
+	results, err := searchWithFilterRecursive(fetch, topK, withoutTrashed(filter), initialMultiplier, 0)
+	if err != nil {
+		return nil, err
+	}
+	return s.withContent(results)
+}
+
+// perDocumentFilter adapts a per-document filter to a BatchFilter
+func perDocumentFilter(filter func(*models.Document) bool) BatchFilter {
+	return func(docs []models.Document) []bool {
+		allowed := make([]bool, len(docs))
+		for i := range docs {
+			allowed[i] = filter(&docs[i])
+		}
+		return allowed
+	}
+}
+
+// searchWithFilterRecursive recursively fetches more candidates until topK matching documents are found
+func searchWithFilterRecursive(fetch func(n int) ([]models.Document, error), topK int, filter BatchFilter, multiplier int, attempt int) ([]models.Document, error) {
+	// Safety check to prevent infinite recursion
+	if attempt >= maxAttempts {
+		log.Printf("Warning: Reached max attempts (%d) in recursive search, returning partial results", maxAttempts)
+		// Return whatever we can get with the maximum multiplier
+		candidates, err := fetch(topK * multiplier)
+		if err != nil {
+			return nil, err
+		}
+		return applyFilter(candidates, topK, filter), nil
+	}
+
+	// Fetch candidates with current multiplier
+	candidateCount := topK * multiplier
+	candidates, err := fetch(candidateCount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply filter
+	filtered := applyFilter(candidates, topK, filter)
+
+	// If we have enough results or no more documents exist, return
+	if len(filtered) >= topK || len(candidates) < candidateCount {
+		return filtered, nil
+	}
+
+	// Not enough results, recurse with increased multiplier
+	newMultiplier := int(float64(multiplier) * growthFactor)
+	log.Printf("Only found %d/%d matching documents, increasing search from %d to %d candidates (attempt %d/%d)",
+		len(filtered), topK, candidateCount, topK*newMultiplier, attempt+1, maxAttempts)
+	return searchWithFilterRecursive(fetch, topK, filter, newMultiplier, attempt+1)
+}
+
+// applyFilter applies the batch filter to candidates and returns up to topK results
+func applyFilter(candidates []models.Document, topK int, filter BatchFilter) []models.Document {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	allowed := filter(candidates)
+
+	var filtered []models.Document
+	for i := range candidates {
+		if allowed[i] {
+			filtered = append(filtered, candidates[i])
+			if len(filtered) >= topK {
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// searchWithSqliteVec performs KNN vector search using sqlite-vec and
+// returns hits carrying the document ID and distance
+func (s *SQLiteVectorStore) searchWithSqliteVec(embedding []float32, topK int) ([]models.Document, error) {
+	if s.goVectors {
+		return s.searchWithGoVectors(embedding, topK)
+	}
+
+	embeddingBytes := serializeFloat32Vector(embedding)
+	index := s.vectorIndex()
+
+	// Use sqlite-vec's KNN search with distance calculation
+	// Note: sqlite-vec requires the k parameter to be passed as part of the MATCH expression
+	query := `
+		SELECT v.id, v.distance
+		FROM vec_documents v
+		WHERE v.embedding MATCH ` + index.param() + ` AND k = ? AND v.tenant_id = ?
+		ORDER BY v.distance
+	`
+
+	rows, err := s.db.Query(query, embeddingBytes, s.candidates(index, topK), s.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform vector search: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var results []models.Document
+	for rows.Next() {
+		var id string
+		var distance float32
+		if err := rows.Scan(&id, &distance); err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
+		doc, err := searchHit(id, index.scale(float64(distance), len(embedding)))
+		if err != nil {
+			log.Printf("Error reading document: %v", err)
+			continue
+		}
+		results = append(results, doc)
+	}
 
-		docID, err := uuid.Parse(id)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating results: %w", err)
+	}
+
+	if !s.keepsFull(index) {
+		return results, nil
+	}
+	ids := make([]string, len(results))
+	for i, doc := range results {
+		ids[i] = doc.ID.String()
+	}
+	distances, err := s.fullDistances(embedding, index.Metric, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if distance, ok := distances[ids[i]]; ok {
+			results[i].Distance = distance
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	return results[:min(topK, len(results))], nil
+}
+
+// searchWithGoVectors performs an exhaustive vector search, scoring every
+// embedding of the tenant in Go, and returns hits like searchWithSqliteVec
+func (s *SQLiteVectorStore) searchWithGoVectors(embedding []float32, topK int) ([]models.Document, error) {
+	rows, err := s.db.Query(`SELECT id, embedding FROM vec_documents WHERE tenant_id = ?`, s.tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform vector search: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	metric := s.vectorIndex().Metric
+
+	type hit struct {
+		id       string
+		distance float64
+	}
+	var hits []hit
+	for rows.Next() {
+		var id string
+		var stored []byte
+		if err := rows.Scan(&id, &stored); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		distance, err := storedDistance(metric, embedding, stored)
+		if err != nil {
+			log.Printf("Error scoring document %s: %v", id, err)
+			continue
+		}
+		hits = append(hits, hit{id: id, distance: distance})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating results: %w", err)
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].distance < hits[j].distance })
+	hits = hits[:min(topK, len(hits))]
+
+	results := make([]models.Document, 0, len(hits))
+	for _, h := range hits {
+		doc, err := searchHit(h.id, h.distance)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, doc)
+	}
+	return results, nil
+}
+
+// searchWithFTS returns hits on the tenant's documents matching any term of query, best BM25
+// rank first. Distances to embedding are filled in so keyword hits can be scored like vector hits.
+func (s *SQLiteVectorStore) searchWithFTS(query string, embedding []float32, limit int) ([]models.Document, error) {
+	match := ftsMatchExpression(query)
+	if match == "" {
+		return nil, nil
+	}
+
+	distanceColumn, args := s.distanceColumn("v", embedding)
+	rows, err := s.db.Query(`
+		SELECT d.id, `+distanceColumn+`
+		FROM documents_fts f
+		JOIN documents d ON d.id = f.id
+		JOIN vec_documents v ON v.id = d.id
+		WHERE documents_fts MATCH ? AND d.tenant_id = ?
+		ORDER BY f.rank
+		LIMIT ?
+	`, append(args, match, s.tenantID, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform keyword search: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var results []models.Document
+	for rows.Next() {
+		var id string
+		var stored interface{}
+		if err := rows.Scan(&id, &stored); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+		distance, err := s.distance(embedding, stored)
+		if err != nil {
+			log.Printf("Error scoring document %s: %v", id, err)
+			continue
+		}
+		doc, err := searchHit(id, distance)
+		if err != nil {
+			log.Printf("Error reading document: %v", err)
+			continue
+		}
+		results = append(results, doc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating results: %w", err)
+	}
+
+	return results, nil
+}
+
+// ftsMatchExpression turns free text into an FTS5 query that ORs its quoted terms,
+// so punctuation in questions cannot break the MATCH syntax
+func ftsMatchExpression(text string) string {
+	terms := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// shadowVecTable receives the new vectors while reindexing
+const shadowVecTable = "vec_documents_shadow"
+
+// Reindex re-embeds the documents of all tenants and their chunks into shadow
+// vector tables and replaces vec_documents and vec_chunks with them in a
+// single transaction. The shadow tables may have other dimensions than the
+// current ones.
+func (s *SQLiteVectorStore) Reindex(ctx context.Context, embed EmbedFunc, progress func(done, total int)) error {
+	// A shadow table left behind by an interrupted run holds unknown vectors
+	for _, table := range []string{shadowVecTable, shadowChunkTable, shadowFullVecTable} {
+		if _, err := s.db.ExecContext(ctx, `DROP TABLE IF EXISTS `+table); err != nil {
+			return fmt.Errorf("failed to drop stale shadow table: %w", err)
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, fullVecTableQuery(shadowFullVecTable)); err != nil {
+		return fmt.Errorf("failed to create shadow table: %w", err)
+	}
+
+	embedded := make(map[string]string) // document ID -> hash of the embedded content and chunks
+	dimensions := 0
+	for pass := 0; pass < maxReindexPasses; pass++ {
+		docs, err := s.reindexSnapshot(ctx, s.db)
 		if err != nil {
-			log.Printf("Error parsing UUID %s: %v", id, err)
+			return err
+		}
+
+		var pending []models.Document
+		current := make(map[string]bool, len(docs))
+		for _, doc := range docs {
+			current[doc.ID.String()] = true
+			if embedded[doc.ID.String()] != reindexHash(&doc) {
+				pending = append(pending, doc)
+			}
+		}
+		// Forget documents deleted since the previous pass
+		for id := range embedded {
+			if current[id] {
+				continue
+			}
+			if _, err := s.db.ExecContext(ctx, `DELETE FROM `+shadowVecTable+` WHERE id = ?`, id); err != nil {
+				return fmt.Errorf("failed to delete shadow vector: %w", err)
+			}
+			if _, err := s.db.ExecContext(ctx, `DELETE FROM `+shadowChunkTable+` WHERE id LIKE ?`, id+":%"); err != nil {
+				return fmt.Errorf("failed to delete shadow chunk vectors: %w", err)
+			}
+			if err := deleteFullVectors(s.db, shadowFullVecTable, id); err != nil {
+				return err
+			}
+			delete(embedded, id)
+		}
+
+		if len(pending) == 0 {
+			swapped, err := s.swapVecTable(ctx, embedded, dimensions)
+			if err != nil || swapped {
+				return err
+			}
 			continue
 		}
 
-		documents = append(documents, models.Document{
-			ID:      docID,
-			Title:   title,
-			Content: content,
-		})
+		done := len(docs) - len(pending)
+		for i := range pending {
+			doc := &pending[i]
+			embedding, err := embed(ctx, doc)
+			if err != nil {
+				return fmt.Errorf("failed to embed document %s: %w", doc.ID, err)
+			}
+			if dimensions == 0 {
+				dimensions = len(embedding)
+				if err := s.index.checkDimensions(dimensions); err != nil {
+					return err
+				}
+				for _, table := range []string{shadowVecTable, shadowChunkTable} {
+					if _, err := s.db.ExecContext(ctx, s.vecTableQuery(table, dimensions, s.index)); err != nil {
+						return fmt.Errorf("failed to create shadow table: %w", err)
+					}
+				}
+			} else if len(embedding) != dimensions {
+				return fmt.Errorf("document %s has an embedding of %d dimensions, expected %d", doc.ID, len(embedding), dimensions)
+			}
+
+			if err := s.writeShadowVector(ctx, doc, embedding); err != nil {
+				return err
+			}
+			if err := s.embedChunks(ctx, embed, doc, dimensions); err != nil {
+				return err
+			}
+			embedded[doc.ID.String()] = reindexHash(doc)
+			done++
+			if progress != nil {
+				progress(done, len(docs))
+			}
+		}
+	}
+	return fmt.Errorf("documents kept changing during %d reindex passes", maxReindexPasses)
+}
+
+// reindexSnapshot returns the ID, tenant, content, and chunk offsets of every
+// document
+func (s *SQLiteVectorStore) reindexSnapshot(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}) ([]models.Document, error) {
+	chunks, err := reindexChunks(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT id, tenant_id, title, content FROM documents ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var docs []models.Document
+	for rows.Next() {
+		var id, tenantID, title, content string
+		if err := rows.Scan(&id, &tenantID, &title, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		docID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing UUID %s: %w", id, err)
+		}
+		docs = append(docs, models.Document{ID: docID, TenantID: tenantID, Title: title, Content: content, Chunks: chunks[id]})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating results: %w", err)
+	}
+	return docs, nil
+}
+
+// writeShadowVector stores the new vector of doc in the shadow table
+func (s *SQLiteVectorStore) writeShadowVector(ctx context.Context, doc *models.Document, embedding []float32) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`DELETE FROM `+shadowVecTable+` WHERE id = ?`, doc.ID.String()); err != nil {
+		return fmt.Errorf("failed to delete old shadow vector: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO `+shadowVecTable+` (id, tenant_id, embedding) VALUES (?, ?, `+s.index.param()+`)`,
+		doc.ID.String(), doc.TenantID, serializeFloat32Vector(embedding)); err != nil {
+		return fmt.Errorf("failed to insert shadow vector: %w", err)
+	}
+	if err := s.writeFullVector(tx, shadowFullVecTable, s.index, doc.ID.String(), embedding); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// swapVecTable replaces vec_documents with the shadow table if every document
+// is still embedded with its current content. It reports false without
+// changes when documents were written since the last pass.
+func (s *SQLiteVectorStore) swapVecTable(ctx context.Context, embedded map[string]string, dimensions int) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	docs, err := s.reindexSnapshot(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+	if len(docs) != len(embedded) {
+		return false, nil
+	}
+	for _, doc := range docs {
+		if embedded[doc.ID.String()] != reindexHash(&doc) {
+			return false, nil
+		}
+	}
+
+	for _, table := range []string{"vec_documents", "vec_chunks", fullVecTable} {
+		if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + table); err != nil {
+			return false, fmt.Errorf("failed to drop vector table: %w", err)
+		}
+	}
+	if _, err := tx.Exec(`ALTER TABLE ` + shadowFullVecTable + ` RENAME TO ` + fullVecTable); err != nil {
+		return false, fmt.Errorf("failed to replace full-precision vectors: %w", err)
+	}
+	// Without documents the next stored vector records a new fingerprint
+	if _, err := tx.Exec(`DELETE FROM store_info WHERE key IN (?, ?, ?, ?)`, infoEmbeddingModel, infoEmbeddingDimensions, infoVectorMetric, infoVectorQuantization); err != nil {
+		return false, fmt.Errorf("failed to reset store info: %w", err)
+	}
+	if dimensions > 0 {
+		if err := writeStoreInfo(tx, s.model, dimensions, s.index); err != nil {
+			return false, err
+		}
+		for table, shadow := range map[string]string{"vec_documents": shadowVecTable, "vec_chunks": shadowChunkTable} {
+			if _, err := tx.Exec(s.vecTableQuery(table, dimensions, s.index)); err != nil {
+				return false, fmt.Errorf("failed to create vector table: %w", err)
+			}
+			if _, err := tx.Exec(`INSERT INTO ` + table + ` (id, tenant_id, embedding) SELECT id, tenant_id, embedding FROM ` + shadow); err != nil {
+				return false, fmt.Errorf("failed to copy shadow vectors: %w", err)
+			}
+			if _, err := tx.Exec(`DROP TABLE ` + shadow); err != nil {
+				return false, fmt.Errorf("failed to drop shadow table: %w", err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.info.mu.Lock()
+	s.info.model, s.info.dimensions, s.info.index = s.model, dimensions, s.index
+	if dimensions == 0 {
+		s.info.model, s.info.index = "", DefaultVectorIndex
 	}
+	s.info.mu.Unlock()
+	return true, nil
+}
 
+// GetAllDocuments returns all documents of the tenant (without embeddings for efficiency)
+func (s *SQLiteVectorStore) GetAllDocuments() []models.Document {
+	query := `SELECT id, title, content, metadata, created_at, deleted_at FROM documents WHERE tenant_id = ? AND deleted_at IS NULL ORDER BY id DESC`
+	documents, err := s.queryDocuments(query, s.tenantID)
+	if err != nil {
+		log.Printf("Error querying all documents: %v", err)
+		return []models.Document{}
+	}
 	return documents
 }
 
+// ListDocuments returns one page of the tenant's documents matching the metadata
+// filters, sorted as requested. Filtering and pagination happen in SQL.
+func (s *SQLiteVectorStore) ListDocuments(opts ListOptions) ([]models.Document, error) {
+	return s.listDocuments(opts, false)
+}
+
+// ListTrash returns one page of the tenant's trashed documents like ListDocuments
+func (s *SQLiteVectorStore) ListTrash(opts ListOptions) ([]models.Document, error) {
+	return s.listDocuments(opts, true)
+}
+
+// listDocuments returns one page of the tenant's live or trashed documents
+func (s *SQLiteVectorStore) listDocuments(opts ListOptions, trashed bool) ([]models.Document, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	var query strings.Builder
+	args := []interface{}{s.tenantID}
+	query.WriteString(`SELECT id, title, content, metadata, created_at, deleted_at FROM documents WHERE tenant_id = ?`)
+	if trashed {
+		query.WriteString(` AND deleted_at IS NOT NULL`)
+	} else {
+		query.WriteString(` AND deleted_at IS NULL`)
+	}
+
+	args = s.appendMetadataFilter(&query, args, "", opts.Metadata)
+
+	direction := "ASC"
+	if opts.SortDesc {
+		direction = "DESC"
+	}
+	column := "title"
+	if opts.SortBy == SortByCreatedAt {
+		column = "created_at"
+	}
+	// SortBy is validated above, so only known column names are interpolated
+	fmt.Fprintf(&query, ` ORDER BY %s %s, id %s LIMIT ? OFFSET ?`, column, direction, direction)
+	args = append(args, opts.Limit, opts.Offset)
+
+	return s.queryDocuments(query.String(), args...)
+}
+
+// appendMetadataFilter adds an exact-match condition for every metadata filter
+// to query and returns args extended with their values. Columns are qualified
+// with alias, e.g. "d."; indexed keys compare their generated column.
+func (s *SQLiteVectorStore) appendMetadataFilter(query *strings.Builder, args []interface{}, alias string, metadata map[string]string) []interface{} {
+	// Sort keys for deterministic SQL generation
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if s.indexed[key] {
+			// Keys are validated identifiers, so they are safe to interpolate
+			fmt.Fprintf(query, ` AND %s"%s%s" = ?`, alias, metadataColumnPrefix, key)
+			args = append(args, metadata[key])
+			continue
+		}
+		fmt.Fprintf(query, ` AND CAST(json_extract(%smetadata, ?) AS TEXT) = ?`, alias)
+		args = append(args, "$."+key, metadata[key])
+	}
+	return args
+}
+
+// ExportDocuments streams the tenant's documents matching the metadata
+// filters with their embeddings, oldest first. Documents without a stored
+// vector are exported without embedding.
+func (s *SQLiteVectorStore) ExportDocuments(metadata map[string]string, fn func(*models.Document) error) error {
+	if err := ValidateMetadataFilter(metadata); err != nil {
+		return err
+	}
+
+	var vecTable int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='vec_documents'").Scan(&vecTable); err != nil {
+		return fmt.Errorf("failed to check vec_documents existence: %w", err)
+	}
+
+	// Quantized vectors are exported from their full-precision copies, if any
+	var query strings.Builder
+	switch index := s.vectorIndex(); {
+	case vecTable > 0 && index.Quantization == QuantizationNone:
+		query.WriteString(`SELECT d.id, d.title, d.content, d.metadata, d.created_at, v.embedding FROM documents d LEFT JOIN vec_documents v ON v.id = d.id`)
+	case vecTable > 0 && s.keepsFull(index):
+		query.WriteString(`SELECT d.id, d.title, d.content, d.metadata, d.created_at, f.embedding FROM documents d LEFT JOIN ` + fullVecTable + ` f ON f.id = d.id`)
+	default:
+		query.WriteString(`SELECT d.id, d.title, d.content, d.metadata, d.created_at, NULL FROM documents d`)
+	}
+	query.WriteString(` WHERE d.tenant_id = ? AND d.deleted_at IS NULL`)
+	args := s.appendMetadataFilter(&query, []interface{}{s.tenantID}, "d.", metadata)
+	query.WriteString(` ORDER BY d.created_at, d.id`)
+
+	rows, err := s.db.Query(query.String(), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var id, title, content, metadataJSON string
+		var createdAt int64
+		var embedding []byte
+		if err := rows.Scan(&id, &title, &content, &metadataJSON, &createdAt, &embedding); err != nil {
+			return fmt.Errorf("failed to scan document: %w", err)
+		}
+
+		doc, err := s.newDocument(id, title, content, metadataJSON, createdAt)
+		if err != nil {
+			return err
+		}
+		doc.Embedding = deserializeFloat32Vector(embedding)
+		if err := fn(&doc); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating results: %w", err)
+	}
+	return nil
+}
+
+// queryDocuments runs a query selecting id, title, content, metadata, created_at, and deleted_at
+func (s *SQLiteVectorStore) queryDocuments(query string, args ...interface{}) ([]models.Document, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	documents := []models.Document{}
+	for rows.Next() {
+		var id, title, content, metadata string
+		var createdAt int64
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&id, &title, &content, &metadata, &createdAt, &deletedAt); err != nil {
+			log.Printf("Error scanning row: %v", err)
+			continue
+		}
+
+		doc, err := s.newDocument(id, title, content, metadata, createdAt)
+		if err != nil {
+			log.Printf("Error reading document: %v", err)
+			continue
+		}
+		doc.DeletedAt = deletedTime(deletedAt)
+		documents = append(documents, doc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating results: %w", err)
+	}
+
+	return documents, nil
+}
+
 // GetFilteredDocuments returns documents that match the given filter
 func (s *SQLiteVectorStore) GetFilteredDocuments(filter func(*models.Document) bool) []models.Document {
 	allDocs := s.GetAllDocuments()
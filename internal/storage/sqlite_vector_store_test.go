@@ -1,10 +1,20 @@
 package storage
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
 	"os"
+	"path/filepath"
 	"rerag-rbac-rag-llm/internal/models"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -187,3 +197,929 @@ func TestSQLiteVectorStoreEmptyDB(t *testing.T) {
 		t.Errorf("Expected 0 documents in empty store, got %d", len(allDocs))
 	}
 }
+
+func TestSQLiteVectorStoreTenantIsolation(t *testing.T) {
+	dbPath := "./test_tenant_vector_store.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	acme := store.ForTenant("acme")
+	globex := store.ForTenant("globex")
+
+	acmeDoc := &models.Document{Title: "Acme", Content: "Acme content", Embedding: []float32{0.1, 0.2, 0.3}}
+	if err := acme.AddDocument(acmeDoc); err != nil {
+		t.Fatalf("Failed to add acme document: %v", err)
+	}
+	if err := globex.AddDocument(&models.Document{Title: "Globex", Content: "Globex content", Embedding: []float32{0.1, 0.2, 0.3}}); err != nil {
+		t.Fatalf("Failed to add globex document: %v", err)
+	}
+
+	docs := acme.GetAllDocuments()
+	if len(docs) != 1 || docs[0].Title != "Acme" {
+		t.Errorf("Expected only the acme document, got %+v", docs)
+	}
+
+	allowAll := func(*models.Document) bool { return true }
+	results, err := globex.SearchSimilarWithFilter([]float32{0.1, 0.2, 0.3}, 5, allowAll)
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(results) != 1 || results[0].Title != "Globex" {
+		t.Errorf("Expected only the globex document, got %+v", results)
+	}
+
+	if len(store.GetAllDocuments()) != 0 {
+		t.Error("Expected the default tenant to see no documents")
+	}
+
+	// Upserting another tenant's document ID must not overwrite it
+	hijack := &models.Document{ID: acmeDoc.ID, Title: "Hijacked", Content: "x", Embedding: []float32{0.1, 0.2, 0.3}}
+	if err := globex.UpsertDocument(hijack); err == nil {
+		t.Error("Expected cross-tenant upsert to fail")
+	}
+	if docs := acme.GetAllDocuments(); len(docs) != 1 || docs[0].Title != "Acme" {
+		t.Errorf("Expected acme document to be unchanged, got %+v", docs)
+	}
+}
+
+func TestSQLiteVectorStoreMigratesLegacySchema(t *testing.T) {
+	if !nativeVectors {
+		t.Skip("legacy schema requires sqlite-vec")
+	}
+	dbPath := "./test_legacy_vector_store.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	db, err := sql.Open(sqliteDriver, dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	legacyID := uuid.New().String()
+	legacySchema := []string{
+		`CREATE TABLE documents (id TEXT PRIMARY KEY, title TEXT NOT NULL, content TEXT NOT NULL)`,
+		`CREATE VIRTUAL TABLE vec_documents USING vec0(id TEXT PRIMARY KEY, embedding FLOAT[3])`,
+		`INSERT INTO documents (id, title, content) VALUES ('` + legacyID + `', 'Legacy', 'Legacy content')`,
+	}
+	for _, stmt := range legacySchema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO vec_documents (id, embedding) VALUES (?, ?)`, legacyID, serializeFloat32Vector([]float32{0.1, 0.2, 0.3})); err != nil {
+		t.Fatalf("Failed to insert legacy vector: %v", err)
+	}
+	_ = db.Close()
+
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	results, err := store.SearchSimilarWithFilter([]float32{0.1, 0.2, 0.3}, 1, func(*models.Document) bool { return true })
+	if err != nil {
+		t.Fatalf("Failed to search migrated store: %v", err)
+	}
+	if len(results) != 1 || results[0].ID.String() != legacyID {
+		t.Errorf("Expected legacy document in default tenant, got %+v", results)
+	}
+}
+
+func TestSQLiteVectorStoreUpdateDocument(t *testing.T) {
+	dbPath := "./test_update_vector_store.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	doc := &models.Document{Title: "Original", Content: "Original content", Embedding: []float32{0.1, 0.2, 0.3}}
+	if err := store.AddDocument(doc); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	// Update metadata only; the stored vector must still be searchable
+	if err := store.UpdateDocument(&models.Document{ID: doc.ID, Title: "Renamed", Content: "Original content"}); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+
+	updated, err := store.GetDocument(doc.ID)
+	if err != nil {
+		t.Fatalf("Failed to get document: %v", err)
+	}
+	if updated.Title != "Renamed" {
+		t.Errorf("Expected title 'Renamed', got '%s'", updated.Title)
+	}
+
+	results, err := store.SearchSimilarWithFilter([]float32{0.1, 0.2, 0.3}, 1, func(*models.Document) bool { return true })
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Expected vector to be kept after metadata update, got %d results (err: %v)", len(results), err)
+	}
+
+	if err := store.UpdateDocument(&models.Document{ID: uuid.New(), Title: "Missing"}); err != ErrDocumentNotFound {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+	if _, err := store.ForTenant("other").GetDocument(doc.ID); err != ErrDocumentNotFound {
+		t.Errorf("Expected document to be invisible to other tenants, got %v", err)
+	}
+}
+
+func TestSQLiteVectorStoreListDocuments(t *testing.T) {
+	dbPath := "./test_list_vector_store.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	for _, doc := range []*models.Document{
+		{Title: "C", Content: "c", Embedding: []float32{0.1, 0.2, 0.3}, Metadata: map[string]interface{}{"taxpayer": "John Doe", "year": 2023}},
+		{Title: "A", Content: "a", Embedding: []float32{0.2, 0.3, 0.4}, Metadata: map[string]interface{}{"taxpayer": "John Doe", "year": 2022}},
+		{Title: "B", Content: "b", Embedding: []float32{0.3, 0.4, 0.5}, Metadata: map[string]interface{}{"taxpayer": "Jane Smith", "year": 2023}},
+	} {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+	if err := store.ForTenant("other").AddDocument(&models.Document{Title: "0", Content: "x", Embedding: []float32{0.1, 0.1, 0.1}}); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	titles := func(docs []models.Document) []string {
+		var out []string
+		for _, doc := range docs {
+			out = append(out, doc.Title)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		opts     ListOptions
+		expected []string
+	}{
+		{"first page", ListOptions{Limit: 2}, []string{"A", "B"}},
+		{"second page", ListOptions{Limit: 2, Offset: 2}, []string{"C"}},
+		{"descending", ListOptions{Limit: 10, SortDesc: true}, []string{"C", "B", "A"}},
+		{"metadata filter", ListOptions{Limit: 10, Metadata: map[string]string{"taxpayer": "John Doe"}}, []string{"A", "C"}},
+		{"numeric metadata filter", ListOptions{Limit: 10, Metadata: map[string]string{"taxpayer": "John Doe", "year": "2023"}}, []string{"C"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := store.ListDocuments(tt.opts)
+			if err != nil {
+				t.Fatalf("ListDocuments failed: %v", err)
+			}
+			if got := titles(docs); strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	docs, err := store.ListDocuments(ListOptions{Limit: 1, SortBy: SortByCreatedAt})
+	if err != nil || len(docs) != 1 {
+		t.Fatalf("Expected one document sorted by created_at, got %d (err: %v)", len(docs), err)
+	}
+	if docs[0].CreatedAt.IsZero() || docs[0].Metadata["taxpayer"] == nil {
+		t.Errorf("Expected created_at and metadata to be populated, got %+v", docs[0])
+	}
+
+	if _, err := store.ListDocuments(ListOptions{Limit: 10, Metadata: map[string]string{"bad key')": "x"}}); err == nil {
+		t.Error("Expected invalid metadata key to be rejected")
+	}
+}
+
+func TestSQLiteVectorStoreIndexedMetadata(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "indexed.db")
+	store, err := NewSQLiteVectorStore(dbPath, WithIndexedMetadata("taxpayer", "year"))
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	for _, doc := range []*models.Document{
+		{Title: "A", Content: "a", Embedding: []float32{0.1, 0.2, 0.3}, Metadata: map[string]interface{}{"taxpayer": "John Doe", "year": 2023}},
+		{Title: "B", Content: "b", Embedding: []float32{0.2, 0.3, 0.4}, Metadata: map[string]interface{}{"taxpayer": "John Doe", "year": 2022}},
+		{Title: "C", Content: "c", Embedding: []float32{0.3, 0.4, 0.5}},
+	} {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	opts := ListOptions{Limit: 10, Metadata: map[string]string{"taxpayer": "John Doe", "year": "2023"}}
+	if docs, err := store.ListDocuments(opts); err != nil || len(docs) != 1 || docs[0].Title != "A" {
+		t.Fatalf("Expected A through the indexed columns, got %+v (%v)", docs, err)
+	}
+	if usage, err := store.Usage(map[string]string{"taxpayer": "John Doe"}); err != nil || usage.Documents != 2 {
+		t.Errorf("Expected 2 documents of the taxpayer, got %+v (%v)", usage, err)
+	}
+
+	var plan strings.Builder
+	rows, err := store.db.Query(`EXPLAIN QUERY PLAN SELECT id FROM documents WHERE tenant_id = ? AND "meta_taxpayer" = ?`, "default", "John Doe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		_ = rows.Scan(&id, &parent, &notused, &detail)
+		plan.WriteString(detail)
+	}
+	_ = rows.Close()
+	if !strings.Contains(plan.String(), "idx_documents_meta_taxpayer") {
+		t.Errorf("Expected the filter to use the metadata index, got plan %q", plan.String())
+	}
+	_ = store.Close()
+
+	// Keys no longer indexed lose their column; filters on them still work
+	reopened, err := NewSQLiteVectorStore(dbPath, WithIndexedMetadata("taxpayer"))
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	var columns int
+	if err := reopened.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_xinfo('documents') WHERE name = 'meta_year'`).Scan(&columns); err != nil {
+		t.Fatal(err)
+	}
+	if dropColumns, _ := reopened.canDropColumns(); dropColumns && columns != 0 {
+		t.Errorf("Expected the year column to be dropped, got %d", columns)
+	}
+	if docs, err := reopened.ListDocuments(opts); err != nil || len(docs) != 1 {
+		t.Errorf("Expected A after dropping the year column, got %+v (%v)", docs, err)
+	}
+
+	if _, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "bad.db"), WithIndexedMetadata("bad key")); err == nil {
+		t.Error("Expected an invalid indexed key to be rejected")
+	}
+}
+
+func TestSQLiteVectorStoreDeleteDocument(t *testing.T) {
+	dbPath := "./test_delete_vector_store.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	doc := &models.Document{Title: "Obsolete", Content: "Obsolete content", Embedding: []float32{0.1, 0.2, 0.3}}
+	if err := store.AddDocument(doc); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	if err := store.ForTenant("other").DeleteDocument(doc.ID); err != ErrDocumentNotFound {
+		t.Errorf("Expected delete from another tenant to fail, got %v", err)
+	}
+	if err := store.DeleteDocument(doc.ID); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if _, err := store.GetDocument(doc.ID); err != ErrDocumentNotFound {
+		t.Errorf("Expected deleted document to be gone, got %v", err)
+	}
+
+	results, err := store.SearchSimilarWithFilter([]float32{0.1, 0.2, 0.3}, 1, func(*models.Document) bool { return true })
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected vector to be deleted, got %d results (err: %v)", len(results), err)
+	}
+}
+
+func TestSQLiteVectorStoreGoVectors(t *testing.T) {
+	dbPath := "./test_go_vectors.db"
+	t.Cleanup(func() { _ = os.Remove(dbPath) })
+
+	store, err := newSQLiteVectorStore(dbPath, true)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite vector store: %v", err)
+	}
+	north := &models.Document{Title: "North", Content: "Refunds", Embedding: []float32{0, 1, 0}}
+	east := &models.Document{Title: "East", Content: "Invoices", Embedding: []float32{1, 0, 0}}
+	for _, doc := range []*models.Document{north, east} {
+		if err := store.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add %s: %v", doc.Title, err)
+		}
+	}
+	if err := store.ForTenant("other").AddDocument(&models.Document{Title: "Other", Embedding: []float32{0, 1, 0}}); err != nil {
+		t.Fatalf("Failed to add document for another tenant: %v", err)
+	}
+
+	results, err := store.SearchSimilarWithFilter([]float32{0, 2, 0}, 2, func(*models.Document) bool { return true })
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != north.ID || results[1].ID != east.ID {
+		t.Fatalf("Expected North then East, got %+v", results)
+	}
+	if results[0].Distance != 1 || math.Abs(results[1].Distance-math.Sqrt(5)) > 1e-6 {
+		t.Errorf("Expected L2 distances 1 and sqrt(5), got %f and %f", results[0].Distance, results[1].Distance)
+	}
+
+	// Replaced vectors are scored, deleted ones are gone
+	if err := store.UpdateDocument(&models.Document{ID: east.ID, Title: "East", Content: "Invoices", Embedding: []float32{0, 3, 0}}); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	if err := store.DeleteDocument(north.ID); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	results, _ = store.SearchSimilarWithFilter([]float32{0, 2, 0}, 2, func(*models.Document) bool { return true })
+	if len(results) != 1 || results[0].ID != east.ID || results[0].Distance != 1 {
+		t.Errorf("Expected only the updated East document, got %+v", results)
+	}
+	_ = store.Close()
+
+	// The plain vector table cannot be queried by sqlite-vec
+	if nativeVectors {
+		if _, err := NewSQLiteVectorStore(dbPath); err == nil {
+			t.Error("Expected opening a Go-scored database with sqlite-vec to fail")
+		}
+	}
+}
+
+func TestSQLiteVectorStoreExportDocuments(t *testing.T) {
+	store := setupTestStore(t)
+	defer cleanupTestStore(store)
+
+	// Exporting before any vector was stored must not fail on the missing vec table
+	if err := store.ExportDocuments(nil, func(*models.Document) error { return nil }); err != nil {
+		t.Fatalf("Failed to export empty store: %v", err)
+	}
+
+	doc := &models.Document{Title: "Return", Metadata: map[string]interface{}{"year": 2023}, Embedding: []float32{0.1, 0.2, 0.3}}
+	_ = store.AddDocument(doc)
+	_ = store.AddDocument(&models.Document{Title: "Invoice", Metadata: map[string]interface{}{"year": 2022}, Embedding: []float32{0.4, 0.5, 0.6}})
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Metadata: map[string]interface{}{"year": 2023}, Embedding: []float32{0.7, 0.8, 0.9}})
+
+	var exported []models.Document
+	err := store.ExportDocuments(map[string]string{"year": "2023"}, func(doc *models.Document) error {
+		exported = append(exported, *doc)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to export documents: %v", err)
+	}
+	if len(exported) != 1 || exported[0].ID != doc.ID {
+		t.Fatalf("Expected only the tenant's 2023 document, got %+v", exported)
+	}
+	if len(exported[0].Embedding) != 3 || exported[0].Embedding[2] != 0.3 || exported[0].CreatedAt.IsZero() {
+		t.Errorf("Expected the embedding and timestamp to be exported, got %+v", exported[0])
+	}
+
+	if err := store.ExportDocuments(map[string]string{"bad-key": "1"}, func(*models.Document) error { return nil }); err == nil {
+		t.Error("Expected invalid metadata keys to be rejected")
+	}
+}
+
+func TestSQLiteVectorStoreUsage(t *testing.T) {
+	store := setupTestStore(t)
+	defer cleanupTestStore(store)
+
+	_ = store.AddDocument(&models.Document{Title: "Return", Content: "Refund: €1", Metadata: map[string]interface{}{"created_by": "alice"}, Embedding: []float32{0.1, 0.2, 0.3}})
+	_ = store.AddDocument(&models.Document{Title: "Invoice", Content: "abc", Metadata: map[string]interface{}{"created_by": "bob"}, Embedding: []float32{0.4, 0.5, 0.6}})
+	trashed := &models.Document{Title: "Old", Content: "trashed", Metadata: map[string]interface{}{"created_by": "alice"}, Embedding: []float32{0.7, 0.8, 0.9}}
+	_ = store.AddDocument(trashed)
+	_ = store.TrashDocument(trashed.ID)
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Content: "acme", Metadata: map[string]interface{}{"created_by": "alice"}, Embedding: []float32{0.7, 0.8, 0.9}})
+
+	// Content is measured in bytes, not characters
+	if usage, err := store.Usage(nil); err != nil || usage != (Usage{Documents: 2, ContentBytes: 15}) {
+		t.Errorf("Expected the tenant's live documents, got %+v (%v)", usage, err)
+	}
+	if usage, err := store.Usage(map[string]string{"created_by": "alice"}); err != nil || usage != (Usage{Documents: 1, ContentBytes: 12}) {
+		t.Errorf("Expected alice's live document, got %+v (%v)", usage, err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	sqliteStore := setupTestStore(t)
+	defer cleanupTestStore(sqliteStore)
+	memoryStore, _ := NewInMemoryVectorStore("")
+
+	for name, store := range map[string]VectorStore{"sqlite": sqliteStore, "memory": memoryStore} {
+		t.Run(name, func(t *testing.T) {
+			var ids []uuid.UUID
+			add := func(metadata map[string]interface{}) *models.Document {
+				doc := &models.Document{Title: "Return", Content: "Refund", Metadata: metadata, Embedding: []float32{0.1, 0.2, 0.3}}
+				if err := store.AddDocument(doc); err != nil {
+					t.Fatalf("Failed to add document: %v", err)
+				}
+				ids = append(ids, doc.ID)
+				return doc
+			}
+			add(map[string]interface{}{"type": "1040", "year": 2023.0, "refund": 100.0})
+			add(map[string]interface{}{"type": "1040", "year": 2023.0, "refund": 50.5})
+			add(map[string]interface{}{"type": "1040", "year": 2022.0, "refund": "n/a"})
+			add(map[string]interface{}{"type": "1040", "year": 2022.0, "refund": 10.0, "source_id": "s1"})
+			add(map[string]interface{}{"type": "1040", "year": 2022.0, "refund": 10.0, "source_id": "s1"})
+			add(map[string]interface{}{"type": "1040", "refund": 1.0})
+			add(map[string]interface{}{"type": "1120", "year": 2023.0, "refund": 1000.0})
+			trashed := add(map[string]interface{}{"type": "1040", "year": 2023.0, "refund": 7.0})
+			_ = store.(Trash).TrashDocument(trashed.ID)
+			// Documents not listed are not aggregated
+			_ = store.AddDocument(&models.Document{Title: "Secret", Content: "Refund", Metadata: map[string]interface{}{"type": "1040", "year": 2021.0, "refund": 5.0}, Embedding: []float32{0.1, 0.2, 0.3}})
+
+			opts := AggregateOptions{IDs: ids, Metadata: map[string]string{"type": "1040"}, GroupBy: "year", Sum: []string{"refund"}, DistinctKey: "source_id"}
+			groups, err := store.(Aggregator).Aggregate(opts)
+			if err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+			want := []AggregateGroup{
+				{Value: 2022.0, Documents: 2, Sums: map[string]float64{"refund": 10}},
+				{Value: 2023.0, Documents: 2, Sums: map[string]float64{"refund": 150.5}},
+				{Value: nil, Documents: 1, Sums: map[string]float64{"refund": 1}},
+			}
+			if fmt.Sprint(groups) != fmt.Sprint(want) {
+				t.Errorf("Expected %v, got %v", want, groups)
+			}
+
+			opts.GroupBy = ""
+			if groups, err := store.(Aggregator).Aggregate(opts); err != nil || len(groups) != 1 || groups[0].Documents != 5 || groups[0].Sums["refund"] != 161.5 {
+				t.Errorf("Expected one group of every document, got %v (%v)", groups, err)
+			}
+			if groups, err := store.(Aggregator).Aggregate(AggregateOptions{GroupBy: "year"}); err != nil || len(groups) != 0 {
+				t.Errorf("Expected no groups without documents, got %v (%v)", groups, err)
+			}
+			if _, err := store.(Aggregator).Aggregate(AggregateOptions{IDs: ids, GroupBy: "$.year"}); err == nil {
+				t.Error("Expected an invalid metadata key to be rejected")
+			}
+
+			// List values count in the group of each element; empty lists like missing values
+			var tagged []uuid.UUID
+			for _, tags := range []interface{}{[]interface{}{"audit", "urgent"}, []interface{}{"audit"}, []interface{}{}, "urgent"} {
+				tagged = append(tagged, add(map[string]interface{}{"type": "w2", "tags": tags}).ID)
+			}
+			groups, err = store.(Aggregator).Aggregate(AggregateOptions{IDs: tagged, GroupBy: "tags"})
+			want = []AggregateGroup{{Value: "audit", Documents: 2}, {Value: "urgent", Documents: 2}, {Value: nil, Documents: 1}}
+			if err != nil || fmt.Sprint(groups) != fmt.Sprint(want) {
+				t.Errorf("Expected %v, got %v (%v)", want, groups, err)
+			}
+		})
+	}
+}
+
+func TestSQLiteVectorStoreTenants(t *testing.T) {
+	store := setupTestStore(t)
+	defer cleanupTestStore(store)
+
+	_ = store.AddDocument(&models.Document{Title: "Return", Content: "a", Embedding: []float32{0.1, 0.2, 0.3}})
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Content: "b", Embedding: []float32{0.4, 0.5, 0.6}})
+	trashed := &models.Document{Title: "Old", Content: "c", Embedding: []float32{0.7, 0.8, 0.9}}
+	_ = store.ForTenant("globex").AddDocument(trashed)
+	_ = store.ForTenant("globex").(Trash).TrashDocument(trashed.ID)
+
+	if tenants, err := store.Tenants(); err != nil || !slices.Equal(tenants, []string{"acme", "default"}) {
+		t.Errorf("Expected the tenants with live documents, got %v (%v)", tenants, err)
+	}
+}
+
+func TestSQLiteVectorStoreStats(t *testing.T) {
+	store, err := NewSQLiteVectorStore(filepath.Join(t.TempDir(), "stats.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupTestStore(store)
+	if err := store.SetEmbeddingModel("nomic-embed-text"); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := store.Stats(); err != nil || stats.EmbeddingDimensions != 0 || stats.SizeBytes == 0 {
+		t.Errorf("Expected no vectors in a sized database, got %+v (%v)", stats, err)
+	}
+
+	_ = store.ForTenant("acme").AddDocument(&models.Document{Title: "Acme", Content: "acme", Embedding: []float32{0.1, 0.2, 0.3}})
+	stats, err := store.Stats()
+	if err != nil || stats.EmbeddingModel != "nomic-embed-text" || stats.EmbeddingDimensions != 3 {
+		t.Errorf("Expected the fingerprint of the stored vectors, got %+v (%v)", stats, err)
+	}
+}
+
+func TestSQLiteVectorStoreReindex(t *testing.T) {
+	store := setupTestStore(t)
+	defer cleanupTestStore(store)
+
+	north := &models.Document{Title: "North", Content: "north", Embedding: []float32{0.1, 0.2, 0.3}}
+	east := &models.Document{Title: "East", Content: "east", Embedding: []float32{0.4, 0.5, 0.6}}
+	_ = store.AddDocument(north)
+	_ = store.ForTenant("acme").AddDocument(east)
+
+	// The new model embeds into two dimensions; a document added during the
+	// first pass is picked up by the next one
+	vectors := map[string][]float32{"north": {0, 1}, "east": {1, 0}, "late": {1, 1}}
+	late := &models.Document{Title: "Late", Content: "late", Embedding: []float32{0.7, 0.8, 0.9}}
+	var progress []int
+	err := store.Reindex(context.Background(), func(_ context.Context, doc *models.Document) ([]float32, error) {
+		if late.ID == uuid.Nil {
+			if err := store.AddDocument(late); err != nil {
+				t.Fatalf("Failed to add document during reindex: %v", err)
+			}
+		}
+		return vectors[doc.Content], nil
+	}, func(done, total int) {
+		progress = append(progress, done, total)
+	})
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if !slices.Equal(progress, []int{1, 2, 2, 2, 3, 3}) {
+		t.Errorf("Unexpected progress %v", progress)
+	}
+
+	results, err := store.SearchSimilarWithFilter([]float32{0, 1}, 2, func(*models.Document) bool { return true })
+	if err != nil {
+		t.Fatalf("Failed to search reindexed store: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != north.ID || results[0].Distance > 1e-6 || results[1].ID != late.ID {
+		t.Errorf("Expected North then Late with new vectors, got %+v", results)
+	}
+	results, _ = store.ForTenant("acme").SearchSimilarWithFilter([]float32{1, 0}, 1, func(*models.Document) bool { return true })
+	if len(results) != 1 || results[0].ID != east.ID || results[0].Distance > 1e-6 {
+		t.Errorf("Expected the other tenant to be reindexed, got %+v", results)
+	}
+
+	// New documents use the new dimensions
+	if err := store.AddDocument(&models.Document{Title: "New", Content: "new", Embedding: []float32{0.5, 0.5}}); err != nil {
+		t.Errorf("Failed to add a document after reindexing: %v", err)
+	}
+	var shadow int
+	_ = store.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = ?`, shadowVecTable).Scan(&shadow)
+	if shadow != 0 {
+		t.Error("Expected the shadow table to be swapped in")
+	}
+}
+
+func TestSQLiteVectorStoreEmbeddingFingerprint(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "fingerprint.db")
+	store, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetEmbeddingModel("nomic-embed-text"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddDocument(&models.Document{Title: "North", Content: "north", Embedding: []float32{0.1, 0.2, 0.3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mismatch *EmbeddingMismatchError
+	err = store.ForTenant("acme").AddDocument(&models.Document{Title: "Short", Content: "short", Embedding: []float32{0.1, 0.2}})
+	if !errors.As(err, &mismatch) || mismatch.StoredDimensions != 3 || mismatch.Dimensions != 2 {
+		t.Errorf("Expected a dimension mismatch, got %v", err)
+	}
+	if _, err := store.SearchSimilarWithFilter([]float32{0.1, 0.2}, 1, func(*models.Document) bool { return true }); !errors.As(err, &mismatch) {
+		t.Errorf("Expected a query of other dimensions to be rejected, got %v", err)
+	}
+	_ = store.Close()
+
+	// The fingerprint survives a restart with another model
+	reopened, err := NewSQLiteVectorStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupTestStore(reopened)
+	if err := reopened.SetEmbeddingModel("mxbai-embed-large"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = reopened.SearchHybridWithBatchFilter([]float32{0.1, 0.2, 0.3}, "north", 1, perDocumentFilter(func(*models.Document) bool { return true }), HybridOptions{})
+	if !errors.As(err, &mismatch) || mismatch.StoredModel != "nomic-embed-text" || mismatch.Model != "mxbai-embed-large" {
+		t.Errorf("Expected a model mismatch, got %v", err)
+	}
+
+	// Reindexing records the new model
+	if err := reopened.Reindex(context.Background(), func(context.Context, *models.Document) ([]float32, error) {
+		return []float32{0, 1}, nil
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.SearchSimilarWithFilter([]float32{0, 1}, 1, func(*models.Document) bool { return true }); err != nil {
+		t.Errorf("Expected queries of the new model to succeed, got %v", err)
+	}
+}
+
+func TestSQLiteVectorStoreEmbeddingFingerprintLegacy(t *testing.T) {
+	store := setupTestStore(t)
+	defer cleanupTestStore(store)
+	if err := store.AddDocument(&models.Document{Title: "North", Content: "north", Embedding: []float32{0.1, 0.2, 0.3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A database without a fingerprint learns its dimensions from the vector table
+	if _, err := store.db.Exec(`DELETE FROM store_info`); err != nil {
+		t.Fatal(err)
+	}
+	store.info = &storeInfo{}
+	if err := store.loadStoreInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetEmbeddingModel("nomic-embed-text"); err != nil {
+		t.Fatal(err)
+	}
+	if store.info.dimensions != 3 || store.info.model != "nomic-embed-text" {
+		t.Errorf("Unexpected fingerprint %q with %d dimensions", store.info.model, store.info.dimensions)
+	}
+	if err := store.AddDocument(&models.Document{Title: "Long", Content: "long", Embedding: []float32{0.1, 0.2, 0.3, 0.4}}); err == nil {
+		t.Error("Expected a vector of other dimensions to be rejected")
+	}
+}
+
+func TestSQLiteVectorStoreConcurrentAccess(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "concurrent.db")
+	store, err := NewSQLiteVectorStore(dbPath, WithBusyTimeout(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanupTestStore(store)
+
+	var journalMode string
+	var busyTimeout int
+	_ = store.db.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode)
+	_ = store.db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout)
+	if journalMode != "wal" || busyTimeout != 10000 {
+		t.Errorf("Expected WAL with a 10s busy timeout, got %s and %dms", journalMode, busyTimeout)
+	}
+
+	// Writers and readers share the pool without SQLITE_BUSY errors
+	const writers, readers, docsPerWriter = 8, 4, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*docsPerWriter+readers*docsPerWriter)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			tenantStore := store.ForTenant(fmt.Sprintf("tenant-%d", w%2))
+			for i := 0; i < docsPerWriter; i++ {
+				doc := &models.Document{
+					Title:     fmt.Sprintf("Doc %d-%d", w, i),
+					Content:   fmt.Sprintf("content %d %d", w, i),
+					Embedding: []float32{float32(w), float32(i), 1},
+				}
+				if err := tenantStore.AddDocument(doc); err != nil {
+					errs <- fmt.Errorf("add %s: %w", doc.Title, err)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < docsPerWriter; i++ {
+				_, err := store.ForTenant(fmt.Sprintf("tenant-%d", r%2)).SearchSimilarWithFilter([]float32{float32(r), float32(i), 1}, 5, func(*models.Document) bool { return true })
+				if err != nil {
+					errs <- fmt.Errorf("search: %w", err)
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	total := len(store.ForTenant("tenant-0").GetAllDocuments()) + len(store.ForTenant("tenant-1").GetAllDocuments())
+	if total != writers*docsPerWriter {
+		t.Errorf("Expected %d documents, got %d", writers*docsPerWriter, total)
+	}
+}
+
+func TestSQLiteVectorStoreTrash(t *testing.T) {
+	store := setupTestStore(t)
+	defer cleanupTestStore(store)
+
+	north := &models.Document{Title: "North", Content: "north refunds", Embedding: []float32{0, 1, 0}}
+	east := &models.Document{Title: "East", Content: "east", Embedding: []float32{1, 0, 0}}
+	_ = store.AddDocument(north)
+	_ = store.AddDocument(east)
+
+	if err := store.TrashDocument(north.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetDocument(north.ID); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound for a trashed document, got %v", err)
+	}
+	if err := store.UpdateDocument(&models.Document{ID: north.ID, Title: "Edited"}); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected updating a trashed document to fail, got %v", err)
+	}
+	if docs := store.GetAllDocuments(); len(docs) != 1 || docs[0].ID != east.ID {
+		t.Errorf("Expected only East to be listed, got %+v", docs)
+	}
+
+	// The trashed nearest neighbour does not use up the only result
+	results, err := store.SearchHybridWithBatchFilter([]float32{0, 1, 0}, "refunds", 1, perDocumentFilter(func(*models.Document) bool { return true }), DefaultHybridOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != east.ID {
+		t.Errorf("Expected East as the only live match, got %+v", results)
+	}
+
+	trash, err := store.ListTrash(ListOptions{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != north.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("Expected North in the trash, got %+v", trash)
+	}
+	if trash, _ := store.ForTenant("acme").(Trash).ListTrash(ListOptions{Limit: 10}); len(trash) != 0 {
+		t.Errorf("Expected another tenant's trash to be empty, got %+v", trash)
+	}
+
+	if err := store.RestoreDocument(north.ID); err != nil {
+		t.Fatal(err)
+	}
+	results, _ = store.SearchSimilarWithFilter([]float32{0, 1, 0}, 1, func(*models.Document) bool { return true })
+	if len(results) != 1 || results[0].ID != north.ID {
+		t.Errorf("Expected the restored document to be searchable, got %+v", results)
+	}
+	if err := store.RestoreDocument(north.ID); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected restoring a live document to fail, got %v", err)
+	}
+
+	// Purging only removes documents trashed before the cutoff
+	_ = store.TrashDocument(north.ID)
+	if purged, _ := store.PurgeTrash(time.Now().Add(-time.Hour)); len(purged) != 0 {
+		t.Errorf("Expected nothing to be purged yet, got %+v", purged)
+	}
+	purged, err := store.ForTenant("acme").(Trash).PurgeTrash(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purged) != 1 || purged[0].ID != north.ID || purged[0].TenantID != "default" {
+		t.Errorf("Expected North to be purged across tenants, got %+v", purged)
+	}
+	if err := store.RestoreDocument(north.ID); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected a purged document to be gone, got %v", err)
+	}
+	var vectors int
+	_ = store.db.QueryRow(`SELECT COUNT(*) FROM vec_documents`).Scan(&vectors)
+	if vectors != 1 {
+		t.Errorf("Expected the purged vector to be deleted, got %d vectors", vectors)
+	}
+}
+
+func TestSQLiteVectorStoreExpiredDocuments(t *testing.T) {
+	store := setupTestStore(t)
+	defer cleanupTestStore(store)
+
+	overdue := &models.Document{Title: "2019 return", Content: "a", Embedding: []float32{1, 0}, Metadata: map[string]interface{}{"expires_at": "2020-01-01"}}
+	held := &models.Document{Title: "Held", Content: "b", Embedding: []float32{0, 1}, Metadata: map[string]interface{}{"expires_at": "2999-01-01T00:00:00Z"}}
+	plain := &models.Document{Title: "Plain", Content: "c", Embedding: []float32{1, 1}}
+	_ = store.AddDocument(overdue)
+	_ = store.AddDocument(held)
+	_ = store.ForTenant("acme").AddDocument(plain)
+	_ = store.ForTenant("acme").(Trash).TrashDocument(plain.ID)
+
+	policy := RetentionPolicy{ExpiresAtKey: "expires_at"}
+	expired, err := store.ExpiredDocuments(policy, time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].ID != overdue.ID {
+		t.Fatalf("Expected only the overdue document without a max age, got %+v", expired)
+	}
+
+	// The metadata date takes precedence over the max age, and trashed
+	// documents of other tenants expire too
+	policy.MaxAge = 24 * time.Hour
+	expired, err = store.ExpiredDocuments(policy, time.Now().Add(48*time.Hour), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 2 {
+		t.Fatalf("Expected 2 expired documents, got %+v", expired)
+	}
+	for _, doc := range expired {
+		if doc.ID == held.ID {
+			t.Errorf("Expected the held document to be kept until its expiry date")
+		}
+		if doc.ID == plain.ID && (doc.TenantID != "acme" || doc.DeletedAt == nil) {
+			t.Errorf("Expected the trashed document with its tenant, got %+v", doc)
+		}
+	}
+	if expired, _ := store.ExpiredDocuments(policy, time.Now().Add(48*time.Hour), 1); len(expired) != 1 {
+		t.Errorf("Expected the limit to apply, got %d documents", len(expired))
+	}
+}
+
+func TestSQLiteVectorStoreEncryption(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "encrypted.db")
+	dsn := dbPath + "?_pragma_key=secret&_pragma_cipher_page_size=4096"
+
+	store, err := NewSQLiteVectorStore(dsn, WithEncryption())
+	if !EncryptionSupported {
+		// Drivers without SQLCipher ignore the key and would store plaintext
+		if !errors.Is(err, ErrEncryptionUnavailable) {
+			t.Fatalf("Expected ErrEncryptionUnavailable without SQLCipher, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Failed to open encrypted store: %v", err)
+	}
+	if err := store.AddDocument(&models.Document{Title: "Q3 report", Content: "Revenue grew", Embedding: make([]float32, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	if _, err := NewSQLiteVectorStore(dbPath); err == nil {
+		t.Error("Expected the encrypted database not to open without the key")
+	}
+	store, err = NewSQLiteVectorStore(dsn, WithEncryption())
+	if err != nil {
+		t.Fatalf("Failed to reopen encrypted store: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	if docs := store.GetAllDocuments(); len(docs) != 1 {
+		t.Errorf("Expected the document to be read back with the key, got %d", len(docs))
+	}
+
+	// A plaintext database opened with a key is not encrypted
+	plainPath := filepath.Join(t.TempDir(), "plain.db")
+	plain, err := NewSQLiteVectorStore(plainPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = plain.Close()
+	if _, err := NewSQLiteVectorStore(plainPath+"?_pragma_key=secret", WithEncryption()); err == nil {
+		t.Error("Expected opening a plaintext database as encrypted to fail")
+	}
+}
+
+func TestSQLiteVectorStoreRekey(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "encrypted.db")
+	if !EncryptionSupported {
+		store, err := NewSQLiteVectorStore(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = store.Close() }()
+		if err := store.Rekey(context.Background(), "new"); !errors.Is(err, ErrEncryptionUnavailable) {
+			t.Errorf("Expected ErrEncryptionUnavailable without SQLCipher, got %v", err)
+		}
+		return
+	}
+
+	store, err := NewSQLiteVectorStore(dbPath+"?_pragma_key=old", WithEncryption())
+	if err != nil {
+		t.Fatalf("Failed to open encrypted store: %v", err)
+	}
+	if err := store.AddDocument(&models.Document{Title: "Q3 report", Content: "Revenue grew", Embedding: make([]float32, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Rekey(context.Background(), "n3w'key"); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+
+	// The open store keeps serving with connections opened with the new key
+	if err := store.AddDocument(&models.Document{Title: "Q4 report", Content: "Costs fell", Embedding: make([]float32, 3)}); err != nil {
+		t.Fatalf("Failed to write after rekeying: %v", err)
+	}
+	if docs := store.ForTenant("acme").GetAllDocuments(); len(docs) != 0 {
+		t.Errorf("Expected no documents for another tenant, got %d", len(docs))
+	}
+	if docs := store.GetAllDocuments(); len(docs) != 2 {
+		t.Errorf("Expected 2 documents after rekeying, got %d", len(docs))
+	}
+	_ = store.Close()
+
+	if _, err := NewSQLiteVectorStore(dbPath+"?_pragma_key=old", WithEncryption()); err == nil {
+		t.Error("Expected the old key to be rejected after rekeying")
+	}
+	store, err = NewSQLiteVectorStore(dbPath+"?_pragma_key="+url.QueryEscape("n3w'key"), WithEncryption())
+	if err != nil {
+		t.Fatalf("Failed to open with the new key: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	if docs := store.GetAllDocuments(); len(docs) != 2 {
+		t.Errorf("Expected 2 documents with the new key, got %d", len(docs))
+	}
+}
+
+func TestWithoutKey(t *testing.T) {
+	tests := map[string]string{
+		"data/db.sqlite": "data/db.sqlite",
+		"data/db.sqlite?_pragma_key=secret&_pragma_cipher_page_size=4096":          "data/db.sqlite",
+		"data/db.sqlite?mode=rwc&_pragma_key=secret&_busy_timeout=5000":            "data/db.sqlite?_busy_timeout=5000&mode=rwc",
+		"file:data/db.sqlite?_pragma_key=x%27abc%27&_pragma_cipher_page_size=4096": "file:data/db.sqlite",
+	}
+	for dsn, expected := range tests {
+		if got := withoutKey(dsn); got != expected {
+			t.Errorf("withoutKey(%q) = %q, expected %q", dsn, got, expected)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunTrashPurge permanently deletes documents that have been in the trash
+// for longer than retention, immediately and then every interval until ctx
+// is done
+func RunTrashPurge(ctx context.Context, trash Trash, retention, interval time.Duration) {
+	for {
+		purged, err := trash.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Trash purge failed: %v", err)
+		} else if len(purged) > 0 {
+			log.Printf("Trash purge: deleted %d documents trashed more than %v ago", len(purged), retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
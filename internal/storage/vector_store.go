@@ -2,14 +2,405 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
 	"rerag-rbac-rag-llm/internal/models"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrDocumentNotFound is returned when a document does not exist in the tenant
+var ErrDocumentNotFound = errors.New("document not found")
+
+// EmbeddingMismatchError is returned when an embedding to store or search with
+// comes from another model, or has other dimensions, than the stored vectors.
+// Reindexing the documents resolves it.
+type EmbeddingMismatchError struct {
+	StoredModel      string // empty if unknown
+	StoredDimensions int
+	Model            string // empty if unknown
+	Dimensions       int
+}
+
+func (e *EmbeddingMismatchError) Error() string {
+	return fmt.Sprintf("embedding of model %q with %d dimensions does not match the stored vectors of model %q with %d dimensions; reindex the documents after changing the embedding model",
+		e.Model, e.Dimensions, e.StoredModel, e.StoredDimensions)
+}
+
+// Sort fields supported by ListDocuments
+const (
+	SortByTitle     = "title"
+	SortByCreatedAt = "created_at"
+)
+
+// metadataKeyPattern restricts metadata filter keys to simple identifiers
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+// ListOptions controls pagination, sorting, and metadata filtering when listing documents
+type ListOptions struct {
+	Limit    int
+	Offset   int
+	SortBy   string            // SortByTitle (default) or SortByCreatedAt
+	SortDesc bool              // sort descending instead of ascending
+	Metadata map[string]string // exact-match filters on top-level metadata keys
+}
+
+// Validate checks that the options are well-formed
+func (o ListOptions) Validate() error {
+	if o.Limit <= 0 {
+		return fmt.Errorf("limit must be positive")
+	}
+	if o.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
+	}
+	if o.SortBy != "" && o.SortBy != SortByTitle && o.SortBy != SortByCreatedAt {
+		return fmt.Errorf("unsupported sort field: %s", o.SortBy)
+	}
+	return ValidateMetadataFilter(o.Metadata)
+}
+
+// ValidateMetadataFilter checks that metadata filter keys are simple identifiers
+func ValidateMetadataFilter(metadata map[string]string) error {
+	for key := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid metadata filter key: %s", key)
+		}
+	}
+	return nil
+}
+
+// Similarity converts a vector distance into a relevance score in (0, 1],
+// where identical vectors score 1
+func Similarity(distance float64) float64 {
+	return 1 / (1 + max(distance, 0))
+}
+
+// HybridOptions weights the rankings merged by hybrid search
+type HybridOptions struct {
+	VectorWeight  float64 // weight of the vector similarity ranking
+	KeywordWeight float64 // weight of the keyword (full-text) ranking
+	RRFK          int     // reciprocal rank fusion constant; larger values flatten rank differences
+}
+
+// DefaultHybridOptions weights both rankings equally with the customary RRF constant
+var DefaultHybridOptions = HybridOptions{VectorWeight: 1, KeywordWeight: 1, RRFK: 60}
+
+// FuseRankings merges ranked result lists with weighted reciprocal rank fusion.
+// Each document scores weight/(k+rank) per list it appears in; ties keep the
+// vector ranking order.
+func FuseRankings(vectorHits, keywordHits []models.Document, opts HybridOptions) []models.Document {
+	k := float64(opts.RRFK)
+	if k <= 0 {
+		k = float64(DefaultHybridOptions.RRFK)
+	}
+
+	scores := make(map[uuid.UUID]float64, len(vectorHits)+len(keywordHits))
+	var fused []models.Document
+	add := func(hits []models.Document, weight float64) {
+		for rank, doc := range hits {
+			if _, seen := scores[doc.ID]; !seen {
+				fused = append(fused, doc)
+			}
+			scores[doc.ID] += weight / (k + float64(rank+1))
+		}
+	}
+	add(vectorHits, opts.VectorWeight)
+	add(keywordHits, opts.KeywordWeight)
+
+	sort.SliceStable(fused, func(i, j int) bool {
+		return scores[fused[i].ID] > scores[fused[j].ID]
+	})
+	return fused
+}
+
+// Chunk scores combine the distances of a document's chunks into the
+// document's distance
+const (
+	// ChunkScoreMax scores a document by its best chunk
+	ChunkScoreMax = "max"
+	// ChunkScoreMean scores a document by the mean distance of its chunks
+	ChunkScoreMean = "mean"
 )
 
+// ValidateChunks checks that the chunks of doc lie within its content and
+// are embedded like the document
+func ValidateChunks(doc *models.Document) error {
+	for i, chunk := range doc.Chunks {
+		if chunk.Start < 0 || chunk.Start >= chunk.End || chunk.End > len(doc.Content) {
+			return fmt.Errorf("chunk %d of document %s spans bytes %d to %d outside its content", i, doc.ID, chunk.Start, chunk.End)
+		}
+		if len(chunk.Embedding) != len(doc.Embedding) {
+			return fmt.Errorf("chunk %d of document %s has an embedding of %d dimensions, expected %d", i, doc.ID, len(chunk.Embedding), len(doc.Embedding))
+		}
+	}
+	return nil
+}
+
+// scoreChunks sets the distance of doc from the distances of its chunks,
+// aligned with spans, under mode, and its match to the closest chunk
+func scoreChunks(doc *models.Document, spans []models.Span, distances []float64, mode string) {
+	if len(distances) == 0 {
+		return
+	}
+	best, sum := 0, 0.0
+	for i, distance := range distances {
+		sum += distance
+		if distance < distances[best] {
+			best = i
+		}
+	}
+	doc.Distance = distances[best]
+	if mode == ChunkScoreMean {
+		doc.Distance = sum / float64(len(distances))
+	}
+	doc.Match = &models.Span{Start: spans[best].Start, End: spans[best].End}
+}
+
+// BatchFilter decides in a single call which candidate documents may be returned.
+// The returned slice is aligned with docs.
+type BatchFilter func(docs []models.Document) []bool
+
 // VectorStore defines the interface for vector-based document storage
 type VectorStore interface {
 	AddDocument(doc *models.Document) error
 	UpsertDocument(doc *models.Document) error
+	// GetDocument returns a single document or ErrDocumentNotFound
+	GetDocument(id uuid.UUID) (*models.Document, error)
+	// UpdateDocument updates an existing document or returns ErrDocumentNotFound.
+	// If doc.Embedding is empty the stored vector is kept.
+	UpdateDocument(doc *models.Document) error
+	// DeleteDocument removes a document and its vector or returns ErrDocumentNotFound
+	DeleteDocument(id uuid.UUID) error
 	SearchSimilarWithFilter(embedding []float32, topK int, filter func(*models.Document) bool) ([]models.Document, error)
+	SearchSimilarWithBatchFilter(embedding []float32, topK int, filter BatchFilter) ([]models.Document, error)
+	// SearchHybridWithBatchFilter fuses vector and keyword search over query
+	SearchHybridWithBatchFilter(embedding []float32, query string, topK int, filter BatchFilter, opts HybridOptions) ([]models.Document, error)
 	GetAllDocuments() []models.Document
+	// ListDocuments returns a page of documents matching opts
+	ListDocuments(opts ListOptions) ([]models.Document, error)
 	GetFilteredDocuments(filter func(*models.Document) bool) []models.Document
+	// ForTenant returns a view of the store whose reads and writes are restricted to tenantID
+	ForTenant(tenantID string) VectorStore
+}
+
+// Exporter is implemented by stores that can stream a tenant's documents
+// together with their embeddings
+type Exporter interface {
+	// ExportDocuments calls fn with every document of the tenant matching the
+	// exact-match metadata filters, oldest first, with Embedding set. It stops
+	// at the first error returned by fn.
+	ExportDocuments(metadata map[string]string, fn func(*models.Document) error) error
+}
+
+// Trash is implemented by stores that delete documents recoverably. Trashed
+// documents are hidden from reads, lists, and searches until they are
+// restored or purged.
+type Trash interface {
+	// TrashDocument moves a document of the tenant to the trash or returns ErrDocumentNotFound
+	TrashDocument(id uuid.UUID) error
+	// ListTrash returns a page of the tenant's trashed documents like ListDocuments
+	ListTrash(opts ListOptions) ([]models.Document, error)
+	// RestoreDocument moves a trashed document of the tenant back or returns ErrDocumentNotFound
+	RestoreDocument(id uuid.UUID) error
+	// PurgeTrash permanently deletes the documents of all tenants trashed
+	// before cutoff and returns them
+	PurgeTrash(cutoff time.Time) ([]models.Document, error)
+}
+
+// MetadataGrant grants read access to every document whose metadata Key
+// holds one of Values as a string, e.g. {Key: "taxpayer", Values: ["John
+// Doe"]} for a user who may read all of John Doe's documents
+type MetadataGrant struct {
+	Key    string
+	Values []string
+}
+
+// GrantSearcher is implemented by stores that can select the documents of
+// metadata grants in their search query instead of evaluating a filter on
+// them
+type GrantSearcher interface {
+	// SearchSimilarWithGrants behaves like SearchSimilarWithBatchFilter with
+	// WithFilters(filters, access), except that candidates a grant selects
+	// are accepted without evaluating access. Grants on keys that are not
+	// simple identifiers are ignored, leaving their documents to access.
+	SearchSimilarWithGrants(embedding []float32, topK int, grants []MetadataGrant, filters map[string]models.MetadataFilter, access BatchFilter) ([]models.Document, error)
+}
+
+// withoutTrashed wraps filter so that it rejects trashed candidates without
+// evaluating them. Searches keep trashed documents among their candidates so
+// that the candidate pool grows as if they were filtered by permissions.
+func withoutTrashed(filter BatchFilter) BatchFilter {
+	return prefilter(func(doc *models.Document) bool { return doc.DeletedAt == nil }, filter)
+}
+
+// prefilter wraps filter so that it only evaluates the candidates keep accepts
+// and rejects the others
+func prefilter(keep func(*models.Document) bool, filter BatchFilter) BatchFilter {
+	return func(docs []models.Document) []bool {
+		kept := make([]models.Document, 0, len(docs))
+		for i := range docs {
+			if keep(&docs[i]) {
+				kept = append(kept, docs[i])
+			}
+		}
+		allowed := make([]bool, len(docs))
+		if len(kept) == 0 {
+			return allowed
+		}
+		keptAllowed := filter(kept)
+
+		next := 0
+		for i := range docs {
+			if keep(&docs[i]) {
+				allowed[i] = keptAllowed[next]
+				next++
+			}
+		}
+		return allowed
+	}
+}
+
+// RetentionPolicy decides when documents expire
+type RetentionPolicy struct {
+	// MaxAge expires documents this long after their creation; 0 keeps them
+	// unless their metadata says otherwise. Documents without a creation time
+	// never expire by age.
+	MaxAge time.Duration
+	// ExpiresAtKey names the metadata field holding a document's own expiry
+	// date as YYYY-MM-DD or RFC 3339, which takes precedence over MaxAge
+	ExpiresAtKey string
+}
+
+// ExpiresAt returns when doc expires under the policy, or false if it never does
+func (p RetentionPolicy) ExpiresAt(doc *models.Document) (time.Time, bool) {
+	if value, ok := doc.Metadata[p.ExpiresAtKey].(string); ok && p.ExpiresAtKey != "" {
+		for _, layout := range []string{time.DateOnly, time.RFC3339} {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, true
+			}
+		}
+	}
+	if p.MaxAge > 0 && !doc.CreatedAt.IsZero() {
+		return doc.CreatedAt.Add(p.MaxAge), true
+	}
+	return time.Time{}, false
+}
+
+// Expirer is implemented by stores that can find the documents whose
+// retention has ended
+type Expirer interface {
+	// ExpiredDocuments returns up to limit documents of all tenants, including
+	// trashed ones, that expired under policy before now, with TenantID set
+	ExpiredDocuments(policy RetentionPolicy, now time.Time, limit int) ([]models.Document, error)
+}
+
+// TenantLister is implemented by stores that can list the tenants holding documents
+type TenantLister interface {
+	// Tenants returns the IDs of the tenants with live documents, sorted
+	Tenants() ([]string, error)
+}
+
+// Usage is the storage consumed by a set of documents
+type Usage struct {
+	Documents    int
+	ContentBytes int64
+}
+
+// UsageCounter is implemented by stores that can measure a tenant's storage
+// for quota enforcement
+type UsageCounter interface {
+	// Usage sums the live documents of the tenant matching the exact-match
+	// metadata filters; trashed documents do not count
+	Usage(metadata map[string]string) (Usage, error)
+}
+
+// AggregateOptions selects the documents Aggregate counts and what it computes
+type AggregateOptions struct {
+	// IDs lists the documents to aggregate, e.g. those a user may read;
+	// without IDs nothing is aggregated
+	IDs []uuid.UUID
+	// Metadata holds exact-match filters on top-level metadata keys
+	Metadata map[string]string
+	// GroupBy is the metadata key to group by; empty aggregates all documents
+	// in one group. A list value counts the document in the group of each
+	// element; an empty list counts like a missing value.
+	GroupBy string
+	// Sum lists the metadata keys whose numeric values are summed per group;
+	// values of other types are ignored
+	Sum []string
+	// DistinctKey is a metadata key whose documents count once per value, e.g.
+	// the source shared by the chunks of an upload; empty counts every document
+	DistinctKey string
+}
+
+// Validate checks that the options only name simple metadata keys
+func (o AggregateOptions) Validate() error {
+	keys := maps.Clone(o.Metadata)
+	if keys == nil {
+		keys = make(map[string]string)
+	}
+	for _, key := range append([]string{o.GroupBy, o.DistinctKey}, o.Sum...) {
+		if key != "" {
+			keys[key] = ""
+		}
+	}
+	return ValidateMetadataFilter(keys)
+}
+
+// AggregateGroup holds the aggregates of the documents sharing a metadata value
+type AggregateGroup struct {
+	// Value is the GroupBy value, or an element of a list value, as stored in
+	// the metadata; nil for documents without it, and for the single group
+	// without GroupBy
+	Value     interface{}
+	Documents int
+	// Sums holds the total of every Sum key
+	Sums map[string]float64
+}
+
+// Aggregator is implemented by stores that can aggregate document metadata
+// without loading the documents
+type Aggregator interface {
+	// Aggregate counts and sums the tenant's live documents matching opts by
+	// group, largest groups first. Nothing matching returns no groups.
+	Aggregate(opts AggregateOptions) ([]AggregateGroup, error)
+}
+
+// StoreStats describes the vectors of a store and its footprint
+type StoreStats struct {
+	// EmbeddingModel is the model of the stored vectors; empty if unknown
+	EmbeddingModel string
+	// EmbeddingDimensions is the length of the stored vectors; 0 while none are stored
+	EmbeddingDimensions int
+	// SizeBytes is the size of the store on disk, covering all tenants; 0
+	// if it is not persisted
+	SizeBytes int64
+}
+
+// StatsReporter is implemented by stores that can describe their vectors and size
+type StatsReporter interface {
+	Stats() (StoreStats, error)
+}
+
+// EmbedFunc computes the embedding of a document during reindexing
+type EmbedFunc func(ctx context.Context, doc *models.Document) ([]float32, error)
+
+// maxReindexPasses bounds how often reindexing catches up with documents
+// written while it ran before giving up
+const maxReindexPasses = 5
+
+// Reindexer is implemented by stores that can replace the vectors of all
+// documents, e.g. after switching embedding models
+type Reindexer interface {
+	// Reindex re-embeds the documents of every tenant with embed and swaps the
+	// new vectors in atomically once all documents are covered; until then
+	// searches use the old vectors. Documents written meanwhile are picked up
+	// in further passes. progress is called after each embedded document with
+	// the number of documents embedded and to embed.
+	Reindex(ctx context.Context, embed EmbedFunc, progress func(done, total int)) error
 }
@@ -0,0 +1,79 @@
+// Package tenant provides tenant identification and isolation helpers for
+// serving multiple customers from a single instance.
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"github.com/ory/herodot"
+)
+
+// Header is the HTTP header used to select the tenant
+const Header = "X-Tenant-ID"
+
+// Default is the tenant used when a request does not specify one
+const Default = "default"
+
+type contextKey string
+
+// ContextKey is the context key for storing the tenant ID
+const ContextKey contextKey = "tenant"
+
+// validID restricts tenant IDs to characters that are safe in Keto namespace names
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Middleware resolves the tenant for the request from the X-Tenant-ID header
+// and stores it in the context. A tenant already in the context takes
+// precedence. It runs before authentication, so authenticators binding the
+// caller to a tenant replace the header's tenant afterwards, see
+// auth.RequireUser. Invalid tenant IDs are rejected with errs.
+func Middleware(errs herodot.Writer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(ContextKey).(string); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := r.Header.Get(Header)
+		if id == "" {
+			id = Default
+		}
+
+		if !IsValid(id) {
+			errs.WriteError(w, r, herodot.ErrBadRequest.WithReason("Invalid tenant ID"))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// IsValid reports whether id is a well-formed tenant ID
+func IsValid(id string) bool {
+	return validID.MatchString(id)
+}
+
+// NewContext returns a copy of ctx carrying the given tenant ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ContextKey, id)
+}
+
+// FromContext returns the tenant ID stored in ctx, or Default if none is set
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(ContextKey).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
+
+// Namespace returns the tenant-scoped Keto namespace for base. The default
+// tenant uses base unchanged so single-tenant deployments keep working.
+func Namespace(ctx context.Context, base string) string {
+	id := FromContext(ctx)
+	if id == Default {
+		return base
+	}
+	return base + "_" + id
+}
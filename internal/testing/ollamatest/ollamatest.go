@@ -0,0 +1,242 @@
+// Package ollamatest provides a fake Ollama server for unit, integration, and
+// load tests. It answers /api/embeddings, /api/generate (streamed or not),
+// /api/show, and /api/tags, records the requests, and can delay responses or
+// fail a number of them with a given status.
+package ollamatest
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DefaultDimensions of the embeddings unless configured with WithEmbedding
+const DefaultDimensions = 32
+
+// DefaultAnswer is the generated answer unless configured with WithAnswer
+const DefaultAnswer = "Answer from the sources."
+
+// Request is a request the server received
+type Request struct {
+	Path   string
+	Model  string
+	Prompt string
+	System string
+	Stream bool
+}
+
+// Server is a fake Ollama listening on a local address. Close it when done.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	embed    func(text string) []float32
+	answer   func(prompt string) string
+	latency  time.Duration
+	failures map[string][]int
+	requests []Request
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithEmbedding sets how texts are embedded
+func WithEmbedding(embed func(text string) []float32) Option {
+	return func(s *Server) { s.embed = embed }
+}
+
+// WithAnswer sets how prompts are answered
+func WithAnswer(answer func(prompt string) string) Option {
+	return func(s *Server) { s.answer = answer }
+}
+
+// WithLatency delays every response by d
+func WithLatency(d time.Duration) Option {
+	return func(s *Server) { s.latency = d }
+}
+
+// NewServer starts a fake Ollama embedding texts with HashEmbedding and
+// answering every prompt with DefaultAnswer, adjusted by opts
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		embed:    HashEmbedding(DefaultDimensions),
+		answer:   func(string) string { return DefaultAnswer },
+		failures: make(map[string][]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// HashEmbedding returns an embedding of the normalized word counts of a text
+// hashed into dims buckets, so texts sharing words are similar
+func HashEmbedding(dims int) func(text string) []float32 {
+	return func(text string) []float32 {
+		vector := make([]float32, dims)
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(word))
+			vector[h.Sum32()%uint32(dims)]++
+		}
+		var norm float64
+		for _, v := range vector {
+			norm += float64(v * v)
+		}
+		if norm > 0 {
+			for i := range vector {
+				vector[i] /= float32(math.Sqrt(norm))
+			}
+		}
+		return vector
+	}
+}
+
+// SetLatency delays the responses to requests received afterwards by d
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// FailNext answers the next n requests to path with status
+func (s *Server) FailNext(path string, status, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range n {
+		s.failures[path] = append(s.failures[path], status)
+	}
+}
+
+// Requests returns the requests received so far, including failed ones
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Prompts returns the prompts of the generations received so far
+func (s *Server) Prompts() []string {
+	var prompts []string
+	for _, req := range s.Requests() {
+		if req.Path == "/api/generate" {
+			prompts = append(prompts, req.Prompt)
+		}
+	}
+	return prompts
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	req := Request{Path: r.URL.Path}
+	if r.Method == http.MethodPost {
+		var body struct {
+			Model  string `json:"model"`
+			Prompt string `json:"prompt"`
+			System string `json:"system"`
+			Stream bool   `json:"stream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		req.Model, req.Prompt, req.System, req.Stream = body.Model, body.Prompt, body.System, body.Stream
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	latency := s.latency
+	status := 0
+	if failures := s.failures[req.Path]; len(failures) > 0 {
+		status, s.failures[req.Path] = failures[0], failures[1:]
+	}
+	s.mu.Unlock()
+
+	if !sleep(r.Context(), latency) {
+		return
+	}
+	if status != 0 {
+		writeError(w, status, "injected failure")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch req.Path {
+	case "/api/embeddings":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"embedding": s.embed(req.Prompt)})
+	case "/api/generate":
+		s.generate(w, req)
+	case "/api/show":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"details": map[string]string{}})
+	case "/api/tags":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"models": []interface{}{}})
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// generate answers req like Ollama: one object, or with stream set one object
+// per word followed by a final object carrying the token counts
+func (s *Server) generate(w http.ResponseWriter, req Request) {
+	answer := s.answer(req.Prompt)
+	promptTokens := len(strings.Fields(req.System + " " + req.Prompt))
+	if !req.Stream {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"model":             req.Model,
+			"response":          answer,
+			"done":              true,
+			"prompt_eval_count": promptTokens,
+			"eval_count":        len(strings.Fields(answer)),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	pieces := strings.SplitAfter(answer, " ")
+	for _, piece := range pieces {
+		if piece == "" {
+			continue
+		}
+		_ = enc.Encode(map[string]interface{}{"model": req.Model, "response": piece, "done": false})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	_ = enc.Encode(map[string]interface{}{
+		"model":             req.Model,
+		"response":          "",
+		"done":              true,
+		"prompt_eval_count": promptTokens,
+		"eval_count":        len(strings.Fields(answer)),
+	})
+}
+
+// sleep waits for d and reports whether the request is still wanted
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
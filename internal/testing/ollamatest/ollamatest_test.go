@@ -0,0 +1,113 @@
+package ollamatest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func post(t *testing.T, url string, body map[string]interface{}) *http.Response {
+	t.Helper()
+	payload, _ := json.Marshal(body)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", url, err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestEmbeddingsAreSimilarForSharedWords(t *testing.T) {
+	embed := HashEmbedding(DefaultDimensions)
+	dot := func(a, b []float32) (sum float32) {
+		for i := range a {
+			sum += a[i] * b[i]
+		}
+		return sum
+	}
+	tax := embed("tax return deductions")
+	if dot(tax, embed("tax deductions")) <= dot(tax, embed("salary review")) {
+		t.Error("Expected texts sharing words to be more similar")
+	}
+
+	server := NewServer()
+	defer server.Close()
+	var result struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	_ = json.NewDecoder(post(t, server.URL+"/api/embeddings", map[string]interface{}{"prompt": "tax return deductions"}).Body).Decode(&result)
+	if !slices.Equal(result.Embedding, tax) {
+		t.Errorf("Expected the hashed embedding, got %v", result.Embedding)
+	}
+}
+
+func TestGenerateStreams(t *testing.T) {
+	server := NewServer(WithAnswer(func(prompt string) string { return "You asked: " + prompt }))
+	defer server.Close()
+
+	resp := post(t, server.URL+"/api/generate", map[string]interface{}{"model": "llama3.2:1b", "prompt": "why", "stream": true})
+	var answer strings.Builder
+	chunks := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var chunk struct {
+			Response  string `json:"response"`
+			Done      bool   `json:"done"`
+			EvalCount int    `json:"eval_count"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			t.Fatalf("Failed to decode chunk %q: %v", scanner.Text(), err)
+		}
+		answer.WriteString(chunk.Response)
+		chunks++
+		if chunk.Done && chunk.EvalCount != 3 {
+			t.Errorf("Expected 3 generated tokens, got %d", chunk.EvalCount)
+		}
+	}
+	if answer.String() != "You asked: why" || chunks != 4 {
+		t.Errorf("Expected 3 pieces and a final object, got %q in %d chunks", answer.String(), chunks)
+	}
+	if prompts := server.Prompts(); !slices.Equal(prompts, []string{"why"}) {
+		t.Errorf("Expected the prompt to be recorded, got %v", prompts)
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.FailNext("/api/generate", http.StatusServiceUnavailable, 2)
+
+	for i, want := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK} {
+		if resp := post(t, server.URL+"/api/generate", map[string]interface{}{"prompt": "why"}); resp.StatusCode != want {
+			t.Errorf("Request %d: expected status %d, got %d", i, want, resp.StatusCode)
+		}
+	}
+	if resp := post(t, server.URL+"/api/embeddings", map[string]interface{}{"prompt": "why"}); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected failures to apply to their path only, got %d", resp.StatusCode)
+	}
+	if n := len(server.Requests()); n != 4 {
+		t.Errorf("Expected 4 recorded requests, got %d", n)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	server := NewServer(WithLatency(50 * time.Millisecond))
+	defer server.Close()
+
+	start := time.Now()
+	post(t, server.URL+"/api/embeddings", map[string]interface{}{"prompt": "why"})
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected a delayed response, got one after %v", elapsed)
+	}
+
+	server.SetLatency(time.Minute)
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	if _, err := client.Get(server.URL + "/api/tags"); err == nil {
+		t.Error("Expected the client to time out")
+	}
+}
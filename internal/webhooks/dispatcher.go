@@ -0,0 +1,210 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers set on every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderID        = "X-Webhook-ID"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// HeaderSignature carries "sha256=" and the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the endpoint secret
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// defaultQueueSize is used when NewDispatcher is given a non-positive size
+const defaultQueueSize = 1000
+
+// Endpoint is a webhook receiver
+type Endpoint struct {
+	URL string
+	// Events the endpoint subscribes to; empty subscribes to all
+	Events []EventType
+	// Secret signs deliveries; empty sends them unsigned
+	Secret string
+}
+
+// delivery is a serialized event queued for one endpoint
+type delivery struct {
+	id        uuid.UUID
+	eventType EventType
+	body      []byte
+}
+
+// subscriber is an endpoint with its own queue, so a slow receiver does not
+// delay the others and events reach each receiver in order
+type subscriber struct {
+	endpoint Endpoint
+	queue    chan delivery
+}
+
+// Dispatcher delivers events asynchronously. Failed deliveries are retried by
+// the HTTP client; events that still cannot be delivered, or that do not fit
+// into a full queue, are written to the log as dead letters.
+type Dispatcher struct {
+	client      *httpclient.Client
+	subscribers []*subscriber
+	now         func() time.Time
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher starts delivering to endpoints. client applies timeouts and
+// retries; nil sends single attempts without a timeout. Each endpoint buffers
+// up to queueSize pending events.
+func NewDispatcher(endpoints []Endpoint, client *httpclient.Client, queueSize int) *Dispatcher {
+	if client == nil {
+		client = httpclient.New(httpclient.Options{})
+	}
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+
+	d := &Dispatcher{client: client, now: time.Now}
+	for _, endpoint := range endpoints {
+		sub := &subscriber{endpoint: endpoint, queue: make(chan delivery, queueSize)}
+		d.subscribers = append(d.subscribers, sub)
+		d.wg.Add(1)
+		go d.run(sub)
+	}
+	return d
+}
+
+// Notify queues an event for every endpoint subscribed to its type
+func (d *Dispatcher) Notify(ctx context.Context, eventType EventType, data interface{}) {
+	event := Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		Tenant:    tenant.FromContext(ctx),
+		CreatedAt: d.now().UTC(),
+		Data:      data,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Webhook event %s could not be encoded: %v", eventType, err)
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, sub := range d.subscribers {
+		if !sub.subscribes(eventType) {
+			continue
+		}
+		item := delivery{id: event.ID, eventType: eventType, body: body}
+		if d.closed {
+			deadLetter(sub.endpoint, item, fmt.Errorf("dispatcher closed"))
+			continue
+		}
+		select {
+		case sub.queue <- item:
+		default:
+			deadLetter(sub.endpoint, item, fmt.Errorf("queue full"))
+		}
+	}
+}
+
+// Close stops accepting events and waits until queued events are delivered or
+// ctx is done
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, sub := range d.subscribers {
+			close(sub.queue)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out delivering queued webhook events: %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) run(sub *subscriber) {
+	defer d.wg.Done()
+	for item := range sub.queue {
+		if err := d.deliver(sub.endpoint, item); err != nil {
+			deadLetter(sub.endpoint, item, err)
+		}
+	}
+}
+
+// deliver POSTs an event to an endpoint and succeeds on any 2xx response
+func (d *Dispatcher) deliver(endpoint Endpoint, item delivery) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(item.body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, string(item.eventType))
+	req.Header.Set(HeaderID, item.id.String())
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if endpoint.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, item.body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// subscribes reports whether the endpoint wants events of the given type
+func (s *subscriber) subscribes(eventType EventType) bool {
+	return len(s.endpoint.Events) == 0 || slices.Contains(s.endpoint.Events, eventType)
+}
+
+// Sign returns the signature header value of a delivery. Receivers recompute it
+// from the timestamp header and the raw body to verify the sender.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter logs an undeliverable event with its full body so it can be replayed
+func deadLetter(endpoint Endpoint, item delivery, err error) {
+	log.Printf("Webhook dead letter: %s event %s to %s failed: %v; body: %s", item.eventType, item.id, endpoint.URL, err, item.body)
+}
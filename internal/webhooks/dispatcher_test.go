@@ -0,0 +1,112 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// receiver records deliveries and answers with status
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	attempts int
+	events   []Event
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+		return
+	}
+	var event Event
+	_ = json.Unmarshal(body, &event)
+	r.events = append(r.events, event)
+	r.headers = append(r.headers, req.Header.Clone())
+	r.bodies = append(r.bodies, body)
+}
+
+func TestDispatcherDeliversSignedEvents(t *testing.T) {
+	docs, perms := &receiver{}, &receiver{}
+	docsServer, permsServer := httptest.NewServer(docs), httptest.NewServer(perms)
+	defer docsServer.Close()
+	defer permsServer.Close()
+
+	d := NewDispatcher([]Endpoint{
+		{URL: docsServer.URL, Events: []EventType{DocumentCreated}, Secret: "s3cret"},
+		{URL: permsServer.URL, Events: []EventType{PermissionRevoked}},
+	}, nil, 10)
+
+	ctx := tenant.NewContext(context.Background(), "acme")
+	docID := uuid.New()
+	d.Notify(ctx, DocumentCreated, DocumentData{ID: docID, Title: "Refunds"})
+	d.Notify(ctx, PermissionRevoked, PermissionData{Subject: "alice", Relation: "viewer", Object: docID.String()})
+	d.Notify(ctx, DocumentDeleted, DocumentData{ID: docID})
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if len(docs.events) != 1 || docs.events[0].Type != DocumentCreated || docs.events[0].Tenant != "acme" {
+		t.Fatalf("Expected only the document.created event, got %+v", docs.events)
+	}
+	header := docs.headers[0]
+	if header.Get(HeaderEvent) != string(DocumentCreated) || header.Get(HeaderID) != docs.events[0].ID.String() {
+		t.Errorf("Unexpected event headers: %v", header)
+	}
+	if want := Sign("s3cret", header.Get(HeaderTimestamp), docs.bodies[0]); header.Get(HeaderSignature) != want {
+		t.Errorf("Expected signature %s, got %s", want, header.Get(HeaderSignature))
+	}
+
+	if len(perms.events) != 1 || perms.events[0].Type != PermissionRevoked {
+		t.Fatalf("Expected only the permission.revoked event, got %+v", perms.events)
+	}
+	if perms.headers[0].Get(HeaderSignature) != "" {
+		t.Error("Expected deliveries without a secret to be unsigned")
+	}
+}
+
+func TestDispatcherDeadLettersFailedDeliveries(t *testing.T) {
+	failing := &receiver{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(failing)
+	defer server.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	d := NewDispatcher([]Endpoint{{URL: server.URL}}, httpclient.New(httpclient.Options{
+		MaxRetries: 2,
+		BaseDelay:  time.Millisecond,
+	}), 10)
+	d.Notify(context.Background(), DocumentUpdated, DocumentData{ID: uuid.New()})
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if failing.attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", failing.attempts)
+	}
+	if !strings.Contains(logs.String(), "Webhook dead letter: document.updated") || !strings.Contains(logs.String(), `"type":"document.updated"`) {
+		t.Errorf("Expected a dead letter with the event body, got %q", logs.String())
+	}
+}
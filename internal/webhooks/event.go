@@ -0,0 +1,81 @@
+// Package webhooks notifies downstream systems about document and permission
+// changes by POSTing signed JSON events to configured endpoints.
+package webhooks
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType names a kind of event
+type EventType string
+
+// Supported event types
+const (
+	DocumentCreated   EventType = "document.created"
+	DocumentUpdated   EventType = "document.updated"
+	DocumentDeleted   EventType = "document.deleted"
+	DocumentRestored  EventType = "document.restored"
+	PermissionGranted EventType = "permission.granted"
+	PermissionRevoked EventType = "permission.revoked"
+
+	GroupMemberAdded   EventType = "group.member_added"
+	GroupMemberRemoved EventType = "group.member_removed"
+)
+
+// EventTypes lists all supported event types
+var EventTypes = []EventType{
+	DocumentCreated, DocumentUpdated, DocumentDeleted, DocumentRestored,
+	PermissionGranted, PermissionRevoked,
+	GroupMemberAdded, GroupMemberRemoved,
+}
+
+// Event is the JSON body delivered to webhook endpoints
+type Event struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      EventType   `json:"type"`
+	Tenant    string      `json:"tenant"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// DocumentData describes the document of a document.* event. Content and
+// embeddings are not sent.
+type DocumentData struct {
+	ID       uuid.UUID              `json:"id"`
+	Title    string                 `json:"title,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// PermissionData describes the relation tuple of a permission.* event. Either
+// the subject or, for relations granted to a group's members, the group is set.
+type PermissionData struct {
+	Subject  string `json:"subject,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+}
+
+// GroupMemberData describes the membership of a group.* event
+type GroupMemberData struct {
+	Group string `json:"group"`
+	User  string `json:"user"`
+}
+
+// Notifier publishes events. Implementations must not block the caller on delivery.
+type Notifier interface {
+	// Notify publishes an event of the given type for the tenant carried by ctx
+	Notify(ctx context.Context, eventType EventType, data interface{})
+}
+
+// IsValid reports whether t is a supported event type
+func (t EventType) IsValid() bool {
+	for _, known := range EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
@@ -2,40 +2,78 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"rerag-rbac-rag-llm/internal/api"
+	"rerag-rbac-rag-llm/internal/auth"
+	"rerag-rbac-rag-llm/internal/blob"
+	"rerag-rbac-rag-llm/internal/clientip"
 	"rerag-rbac-rag-llm/internal/config"
+	"rerag-rbac-rag-llm/internal/connectors/gdrive"
+	"rerag-rbac-rag-llm/internal/connectors/mirror"
+	"rerag-rbac-rag-llm/internal/connectors/s3"
+	"rerag-rbac-rag-llm/internal/connectors/sharepoint"
+	"rerag-rbac-rag-llm/internal/directory"
 	"rerag-rbac-rag-llm/internal/embeddings"
+	apperrors "rerag-rbac-rag-llm/internal/errors"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"rerag-rbac-rag-llm/internal/ingest"
+	"rerag-rbac-rag-llm/internal/injection"
+	"rerag-rbac-rag-llm/internal/lifecycle"
 	"rerag-rbac-rag-llm/internal/llm"
+	"rerag-rbac-rag-llm/internal/logging"
+	"rerag-rbac-rag-llm/internal/metering"
+	"rerag-rbac-rag-llm/internal/moderation"
+	"rerag-rbac-rag-llm/internal/orphans"
 	"rerag-rbac-rag-llm/internal/permissions"
+	"rerag-rbac-rag-llm/internal/policy"
+	"rerag-rbac-rag-llm/internal/prompt"
+	"rerag-rbac-rag-llm/internal/querycache"
+	"rerag-rbac-rag-llm/internal/quota"
+	"rerag-rbac-rag-llm/internal/redact"
+	"rerag-rbac-rag-llm/internal/rerank"
+	"rerag-rbac-rag-llm/internal/retention"
+	"rerag-rbac-rag-llm/internal/scheduler"
+	"rerag-rbac-rag-llm/internal/share"
 	"rerag-rbac-rag-llm/internal/storage"
+	"rerag-rbac-rag-llm/internal/tenant"
+	"rerag-rbac-rag-llm/internal/webhooks"
 )
 
 func main() {
 	log.Println("Starting LLM RAG ReBAC OSS...")
 
-	// Load configuration
-	cfg, err := config.Load()
+	// Load configuration; safe settings are reloaded when the config file changes
+	live, err := config.LoadLive()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	cfg := live.Config()
+
+	// Filter the log by app.log_level, which changes on reload
+	logWriter := logging.NewWriter(os.Stderr, logLevel(cfg))
+	log.SetOutput(logWriter)
 
 	logConfig(cfg)
 
-	// Initialize components
-	vectorStore, server := initializeComponents(cfg)
-	defer func() {
-		if err := vectorStore.Close(); err != nil {
-			log.Printf("Error closing vector store: %v", err)
-		}
-	}()
+	// Initialize components and start them in dependency order
+	server, components := initializeComponents(live, logWriter)
+	startCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err = components.Start(startCtx)
+	cancel()
+	if err != nil {
+		log.Fatalf("Failed to start components: %v", err)
+	}
 
 	// Create and start HTTP server
 	httpServer := createHTTPServer(cfg, server)
@@ -43,8 +81,8 @@ func main() {
 
 	log.Println("Server started successfully")
 
-	// Wait for shutdown signal
-	waitForShutdown(server)
+	// Wait for shutdown signal; the server stops the components once drained
+	waitForShutdown(server, httpServer)
 }
 
 func logConfig(cfg *config.Config) {
@@ -54,9 +92,350 @@ func logConfig(cfg *config.Config) {
 	log.Printf("Database Encryption: %v", cfg.Database.Encryption.Enabled)
 }
 
-func initializeComponents(cfg *config.Config) (*storage.SQLiteVectorStore, *api.Server) {
+// initializeComponents creates the API server and a lifecycle manager with its
+// dependencies and background jobs registered in startup order. The server
+// stops them in reverse order on shutdown, closing the vector store last.
+func initializeComponents(live *config.Live, logWriter *logging.Writer) (*api.Server, *lifecycle.Manager) {
+	cfg := live.Config()
+	components := lifecycle.New()
+
+	vectorStore, sqliteStore := openVectorStore(cfg)
+	database := lifecycle.Component{Name: "database"}
+	if closer, ok := vectorStore.(io.Closer); ok {
+		database.Stop = func(context.Context) error { return closer.Close() }
+	}
+	if pinger, ok := vectorStore.(api.Pinger); ok {
+		database.HealthCheck = pinger.Ping
+	}
+	components.Register(database)
+
 	// Initialize embeddings client
-	embedder := embeddings.NewEmbedder()
+	embedder := embeddings.NewEmbedderFromConfig(cfg.Services.Ollama)
+	components.Register(lifecycle.Component{Name: "embedder", HealthCheck: embedder.Ping})
+
+	// Purge expired documents from the trash while the server runs
+	if trash, ok := vectorStore.(storage.Trash); ok && cfg.Database.Trash.RetentionDays > 0 {
+		retention := time.Duration(cfg.Database.Trash.RetentionDays) * 24 * time.Hour
+		log.Printf("Trash purge enabled (retention: %d days, interval: %ds)", cfg.Database.Trash.RetentionDays, cfg.Database.Trash.PurgeInterval)
+		components.Register(lifecycle.Background("trash purge", func(ctx context.Context) {
+			storage.RunTrashPurge(ctx, trash, retention, time.Duration(cfg.Database.Trash.PurgeInterval)*time.Second)
+		}))
+	}
+
+	// Initialize prompt templates; the watcher reloads them while the server runs
+	prompts, err := prompt.NewRegistry(cfg.Prompts.Dir)
+	if err != nil {
+		log.Fatalf("Failed to load prompt templates: %v", err)
+	}
+	components.Register(lifecycle.Background("prompt watcher", func(ctx context.Context) {
+		prompts.Watch(ctx, time.Duration(cfg.Prompts.ReloadInterval)*time.Second)
+	}))
+
+	// Initialize LLM client
+	ollama := llm.NewOllamaClient(cfg.Services.Ollama.BaseURL, cfg.Services.Ollama.LLMModel, prompts, llm.Budget{
+		ContextTokens:  cfg.Services.Ollama.ContextTokens,
+		ResponseTokens: cfg.Services.Ollama.ResponseTokens,
+		Tokenizer:      llm.WordPieceTokenizer{},
+	}, httpclient.New(httpclient.Options{
+		Timeout:          time.Duration(cfg.Services.Ollama.Timeout) * time.Second,
+		MaxRetries:       cfg.Services.Ollama.MaxRetries,
+		FailureThreshold: cfg.Services.Ollama.Breaker.FailureThreshold,
+		Cooldown:         time.Duration(cfg.Services.Ollama.Breaker.Cooldown) * time.Second,
+	}))
+	components.Register(lifecycle.Component{Name: "ollama", HealthCheck: ollama.Ping})
+
+	// Apply reloaded settings while the server runs
+	applied := cfg
+	live.OnReload(func(next *config.Config) {
+		applyReload(applied, next, logWriter, ollama, prompts)
+		applied = next
+	})
+	components.Register(lifecycle.Background("config watcher", live.Watch))
+
+	// Initialize permissions service
+	permService := newPermissionService(cfg, components)
+
+	// Reconcile the optional permission policy before serving requests
+	if policyCfg := cfg.Services.Keto.Policy; policyCfg.File != "" {
+		applyPolicyFile(policyCfg, permService, vectorStore)
+	}
+
+	opts := []api.Option{
+		api.WithHybridSearch(storage.HybridOptions{
+			VectorWeight:  cfg.Search.Hybrid.VectorWeight,
+			KeywordWeight: cfg.Search.Hybrid.KeywordWeight,
+			RRFK:          cfg.Search.Hybrid.RRFK,
+		}),
+		api.WithIngestion(cfg.Ingestion.ChunkSize, cfg.Ingestion.ChunkOverlap, int64(cfg.Ingestion.MaxUploadSize)<<20),
+	}
+	if len(cfg.Server.TrustedProxies) > 0 {
+		clientIPs, err := clientip.NewResolver(cfg.Server.TrustedProxies)
+		if err != nil {
+			log.Fatalf("Failed to parse trusted proxies: %v", err)
+		}
+		opts = append(opts, api.WithTrustedProxies(clientIPs))
+	}
+	if headers := cfg.Server.SecurityHeaders; headers.Enabled {
+		opts = append(opts, api.WithSecurityHeaders(api.SecurityHeaders{
+			HSTSMaxAge:            time.Duration(headers.HSTSMaxAge) * time.Second,
+			HSTSIncludeSubdomains: headers.HSTSIncludeSubdomains,
+			ContentSecurityPolicy: headers.ContentSecurityPolicy,
+		}))
+	}
+	if cfg.Server.TLS.ClientAuth.Enabled {
+		log.Printf("TLS client certificates required for ingestion (CA: %s)", cfg.Server.TLS.ClientAuth.CAFile)
+		opts = append(opts, api.WithClientCertificates())
+	}
+	if cfg.Ingestion.Originals.Backend != "" {
+		opts = append(opts, api.WithOriginals(newOriginalsStore(cfg.Ingestion.Originals)))
+	}
+	if cfg.Search.RequireSources {
+		opts = append(opts, api.WithRequireSources())
+	}
+	if cfg.Search.ReportHidden {
+		opts = append(opts, api.WithHiddenResultCounts())
+	}
+	if cfg.Search.Agent.MaxSearches > 0 {
+		opts = append(opts, api.WithAgent(cfg.Search.Agent.MaxSearches))
+	}
+	if routing := cfg.Search.Routing; routing.Enabled {
+		log.Printf("Query routing enabled (metadata keys: %v, max documents: %d)", routing.MetadataKeys, routing.MaxDocuments)
+		opts = append(opts, api.WithRouting(routing.MetadataKeys, routing.MaxDocuments))
+	}
+	opts = append(opts, api.WithMaxQuestionLength(cfg.Search.MaxQuestionLength))
+	if queryTimeout := cfg.Server.QueryTimeout; queryTimeout > 0 {
+		if writeTimeout := cfg.Server.WriteTimeout; writeTimeout > 0 && queryTimeout >= writeTimeout {
+			log.Printf("Warning: server.query_timeout (%ds) is not shorter than server.write_timeout (%ds), so slow answers may be cut off without a timeout error", queryTimeout, writeTimeout)
+		}
+		opts = append(opts, api.WithQueryTimeout(time.Duration(queryTimeout)*time.Second))
+	}
+	if cfg.Ingestion.ChunkVectors {
+		log.Printf("Chunk vectors enabled (chunk score: %s)", cfg.Search.ChunkScore)
+		opts = append(opts, api.WithChunkVectors())
+	}
+	if quotas := cfg.Ingestion.Quotas; quotas.Enabled() {
+		log.Printf("Ingestion quotas enabled (tenant: %+v, user: %+v)", quotas.Tenant, quotas.User)
+		opts = append(opts, api.WithQuotas(quota.NewEnforcer(quotas.Tenant.Limits(), quotas.User.Limits())))
+	}
+
+	// Initialize conversation persistence in the same database
+	if sqliteStore != nil {
+		conversations, err := storage.NewSQLiteConversationStore(sqliteStore)
+		if err != nil {
+			log.Fatalf("Failed to initialize conversation store: %v", err)
+		}
+		opts = append(opts, api.WithConversations(conversations, cfg.Conversations.HistoryTokens))
+	} else {
+		log.Println("Conversations are disabled with the memory database driver")
+	}
+
+	// Verify signed JWTs instead of trusting bearer usernames; reloads pick
+	// up rotated keys
+	if cfg.Security.AuthMode == "jwt" {
+		keys, err := auth.ParseJWTKeys(cfg.Security.JWTSecret, cfg.Security.JWTKeys)
+		if err != nil {
+			log.Fatalf("Failed to parse JWT keys: %v", err)
+		}
+		log.Printf("JWT authentication enabled (%d signing keys)", len(keys))
+		jwtAuth := auth.NewJWTAuthenticator(keys)
+		live.OnReload(func(next *config.Config) {
+			keys, err := auth.ParseJWTKeys(next.Security.JWTSecret, next.Security.JWTKeys)
+			if err != nil {
+				log.Printf("Warning: keeping previous JWT keys: %v", err)
+				return
+			}
+			jwtAuth.SetKeys(keys)
+		})
+		opts = append(opts, api.WithAuthenticator(jwtAuth))
+	}
+
+	// Authenticate users with Ory Kratos sessions instead of trusted bearer usernames
+	if kratosCfg := cfg.Security.Kratos; cfg.Security.AuthMode == "kratos" {
+		log.Printf("Kratos session authentication enabled (public URL: %s, username trait: %q, groups trait: %q, roles trait: %q)",
+			kratosCfg.PublicURL, kratosCfg.UsernameTrait, kratosCfg.GroupsTrait, kratosCfg.RolesTrait)
+		kratosAuth := auth.NewKratosAuthenticator(kratosCfg.PublicURL, kratosCfg.UsernameTrait, httpclient.New(httpclient.Options{
+			Timeout:    time.Duration(kratosCfg.Timeout) * time.Second,
+			MaxRetries: kratosCfg.MaxRetries,
+		}))
+		kratosAuth.SetClaimTraits(kratosCfg.GroupsTrait, kratosCfg.RolesTrait)
+		kratosAuth.SetTenantTrait(kratosCfg.TenantTrait)
+		opts = append(opts, api.WithAuthenticator(kratosAuth))
+	}
+
+	// Authenticate users with access tokens of an OIDC provider or Oathkeeper
+	if oidcCfg := cfg.Security.OIDC; cfg.Security.AuthMode == "oidc" {
+		log.Printf("OIDC token authentication enabled (issuer: %q, introspection: %t, username claim: %s, groups claim: %q, roles claim: %q)",
+			oidcCfg.IssuerURL, oidcCfg.IntrospectionURL != "", oidcCfg.UsernameClaim, oidcCfg.GroupsClaim, oidcCfg.RolesClaim)
+		opts = append(opts, api.WithAuthenticator(auth.NewOIDCAuthenticator(oidcCfg.Options(), httpclient.New(httpclient.Options{
+			Timeout:    time.Duration(oidcCfg.Timeout) * time.Second,
+			MaxRetries: oidcCfg.MaxRetries,
+		}))))
+	}
+
+	// Initialize optional API keys for services in the same database
+	if cfg.Security.APIKeys.Enabled {
+		apiKeys, err := storage.NewSQLiteAPIKeyStore(sqliteStore)
+		if err != nil {
+			log.Fatalf("Failed to initialize API key store: %v", err)
+		}
+		log.Println("API key authentication enabled")
+		opts = append(opts, api.WithAPIKeys(apiKeys))
+	}
+
+	// Admit only the users of the directory in the mock auth mode, with
+	// their groups and roles
+	if dirCfg := cfg.Security.Directory; dirCfg.Enabled {
+		opts = append(opts, newDirectory(dirCfg, sqliteStore, permService)...)
+	}
+
+	// Initialize optional share links in the same database; expired links
+	// lose their relations while the server runs
+	if linksCfg := cfg.Security.ShareLinks; linksCfg.Enabled {
+		option, expiry := newShareLinks(linksCfg, sqliteStore, permService)
+		components.Register(expiry)
+		opts = append(opts, option)
+	}
+
+	// Initialize optional webhook notifications; queued events are flushed
+	// after the jobs publishing them have stopped
+	var notifier webhooks.Notifier
+	if hooksCfg := cfg.Webhooks; len(hooksCfg.Endpoints) > 0 {
+		log.Printf("Webhooks enabled (endpoints: %d)", len(hooksCfg.Endpoints))
+		dispatcher := newWebhookDispatcher(hooksCfg)
+		components.Register(lifecycle.Component{Name: "webhooks", Stop: dispatcher.Close})
+		notifier = dispatcher
+		opts = append(opts, api.WithWebhooks(notifier))
+	}
+
+	// Expire documents under the retention policy while the server runs
+	if retentionCfg := cfg.Database.Retention; retentionCfg.Enabled {
+		components.Register(newRetention(retentionCfg, vectorStore, permService, notifier))
+	}
+
+	// Reap documents left without permission relations while the server runs
+	if orphansCfg := cfg.Database.Orphans; orphansCfg.Enabled {
+		components.Register(newOrphanReaper(orphansCfg, vectorStore, permService, notifier))
+	}
+
+	// Export the changes found by connector crawls when metrics are enabled
+	var crawlMetrics *scheduler.Metrics
+	if cfg.Metrics.Enabled {
+		crawlMetrics = scheduler.NewMetrics()
+		opts = append(opts, api.WithCrawlMetrics(crawlMetrics))
+	}
+	crawls := scheduler.New(crawlMetrics)
+	crawling := false
+
+	// Initialize optional bucket connector; it syncs on its schedule while the
+	// server runs
+	if s3Cfg := cfg.Ingestion.S3; s3Cfg.Enabled {
+		crawls.Add(newS3Connector(cfg, embedder, sqliteStore, notifier))
+		crawling = true
+	}
+
+	// Initialize optional drive connector; it syncs files and mirrors their
+	// sharing into relations on its schedule while the server runs
+	if driveCfg := cfg.Ingestion.Drive; driveCfg.Enabled {
+		crawls.Add(newDriveConnector(cfg, embedder, sqliteStore, permService, notifier))
+		crawling = true
+	}
+	if crawling {
+		components.Register(lifecycle.Background("crawl scheduler", crawls.Run))
+	}
+
+	// Initialize optional query cache
+	if cacheCfg := cfg.QueryCache; cacheCfg.Enabled {
+		log.Printf("Query cache enabled (ttl: %ds, max entries: %d)", cacheCfg.TTL, cacheCfg.MaxEntries)
+		opts = append(opts, api.WithQueryCache(querycache.New(time.Duration(cacheCfg.TTL)*time.Second, cacheCfg.MaxEntries)))
+	}
+
+	// Account query tokens and latency per user
+	if cfg.Metrics.Enabled {
+		opts = append(opts, api.WithMeter(metering.NewMeter()))
+	}
+
+	// Initialize optional redaction of sensitive values in prompts
+	if redactCfg := cfg.Redaction; redactCfg.Enabled {
+		detectors, err := redactCfg.Compile()
+		if err != nil {
+			log.Fatalf("Failed to initialize redaction: %v", err)
+		}
+		log.Printf("Redaction enabled (detectors: %d)", len(detectors))
+		opts = append(opts, api.WithRedaction(redact.New(detectors...)))
+	}
+
+	// Initialize optional prompt injection guard
+	if sanitizer := newSanitizer(cfg.InjectionGuard); sanitizer != nil {
+		log.Printf("Injection guard enabled (threshold: %.2f, exclude flagged: %t)", cfg.InjectionGuard.Threshold, cfg.InjectionGuard.ExcludeFlagged)
+		opts = append(opts, api.WithInjectionGuard(sanitizer, cfg.InjectionGuard.ExcludeFlagged))
+	}
+
+	// Initialize optional content moderation
+	if modCfg := cfg.Moderation; modCfg.Enabled {
+		moderator, err := newModerator(modCfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize moderation: %v", err)
+		}
+		log.Printf("Moderation enabled (provider: %s, questions: %s, answers: %s)", modCfg.Provider, modCfg.QuestionAction, modCfg.AnswerAction)
+		opts = append(opts, api.WithModeration(moderator, moderationAction(modCfg.QuestionAction), moderationAction(modCfg.AnswerAction)))
+	}
+
+	// Initialize optional reranker
+	if rerankCfg := cfg.Services.Reranker; rerankCfg.Enabled {
+		log.Printf("Reranker enabled (provider: %s, model: %s, candidates: %d)", rerankCfg.Provider, rerankCfg.Model, rerankCfg.Candidates)
+		timeout := time.Duration(rerankCfg.Timeout) * time.Second
+		var reranker rerank.Reranker = rerank.NewOllamaReranker(rerankCfg.BaseURL, rerankCfg.Model, timeout)
+		if rerankCfg.Provider == "http" {
+			reranker = rerank.NewHTTPReranker(rerankCfg.BaseURL, rerankCfg.Model, rerankCfg.APIKey, timeout)
+		}
+		opts = append(opts, api.WithReranker(reranker, rerankCfg.Candidates))
+	}
+
+	// Initialize API server
+	opts = append(opts, api.WithLifecycle(components), api.WithConfig(live))
+	server := api.NewServer(embedder, vectorStore, ollama, permService, apperrors.NewErrorHandler(cfg), opts...)
+
+	return server, components
+}
+
+// applyReload applies the reloadable settings changed from prev to next and
+// re-reads the prompt templates
+func applyReload(prev, next *config.Config, logWriter *logging.Writer, ollama *llm.OllamaClient, prompts *prompt.Registry) {
+	if next.App.LogLevel != prev.App.LogLevel {
+		logWriter.SetLevel(logLevel(next))
+		log.Printf("Log level changed from %s to %s", prev.App.LogLevel, next.App.LogLevel)
+	}
+	if model := next.Services.Ollama.LLMModel; model != prev.Services.Ollama.LLMModel {
+		log.Printf("LLM model changed from %s to %s", prev.Services.Ollama.LLMModel, model)
+		ollama.SetModel(model)
+	}
+	if err := prompts.Reload(); err != nil {
+		log.Printf("Warning: keeping previous prompt templates: %v", err)
+	}
+}
+
+// logLevel returns the configured log level, which validation limits to the
+// known names
+func logLevel(cfg *config.Config) logging.Level {
+	level, err := logging.ParseLevel(cfg.App.LogLevel)
+	if err != nil {
+		log.Fatalf("Invalid log level: %v", err)
+	}
+	return level
+}
+
+// openVectorStore opens the configured vector store. The SQLite store is also
+// returned for the stores sharing its database; it is nil with the memory driver.
+func openVectorStore(cfg *config.Config) (storage.VectorStore, *storage.SQLiteVectorStore) {
+	if cfg.Database.Driver == "memory" {
+		log.Printf("Initializing in-memory vector store (file: %q)", cfg.Database.MemoryFile)
+		store, err := storage.NewInMemoryVectorStore(cfg.Database.MemoryFile)
+		if err != nil {
+			log.Fatalf("Failed to initialize vector store: %v", err)
+		}
+		store.SetChunkScore(cfg.Search.ChunkScore)
+		return store, nil
+	}
 
 	// Initialize SQLite vector store with encryption support
 	dsn := cfg.GetDatabaseDSN()
@@ -65,33 +444,459 @@ func initializeComponents(cfg *config.Config) (*storage.SQLiteVectorStore, *api.
 		log.Println("Database encryption enabled")
 	}
 
-	vectorStore, err := storage.NewSQLiteVectorStore(dsn)
+	sqliteOpts := []storage.SQLiteOption{
+		storage.WithJournalMode(cfg.Database.JournalMode),
+		storage.WithBusyTimeout(time.Duration(cfg.Database.BusyTimeout) * time.Second),
+		storage.WithMaxOpenConns(cfg.Database.MaxOpenConns),
+		storage.WithIndexedMetadata(cfg.Database.IndexedMetadata...),
+		storage.WithChunkScore(cfg.Search.ChunkScore),
+		storage.WithVectorIndex(cfg.Database.Vector.Index()),
+		storage.WithRescore(cfg.Database.Vector.Rescore),
+	}
+	if cfg.Database.Encryption.Enabled {
+		sqliteOpts = append(sqliteOpts, storage.WithEncryption())
+	}
+	store, err := storage.NewSQLiteVectorStore(dsn, sqliteOpts...)
 	if err != nil {
 		log.Fatalf("Failed to initialize vector store: %v", err)
 	}
+	if err := store.SetEmbeddingModel(cfg.Services.Ollama.EmbeddingModel); err != nil {
+		log.Fatalf("Failed to initialize vector store: %v", err)
+	}
+	return store, store
+}
 
-	// Initialize LLM client
-	ollama := llm.NewOllamaClient(cfg.Services.Ollama.BaseURL, cfg.Services.Ollama.LLMModel)
+// newPermissionService creates the configured permission backend: Keto,
+// registered for health checks and optionally cached, or the embedded OPA
+// engine over a local tuple table
+func newPermissionService(cfg *config.Config, components *lifecycle.Manager) permissions.PermissionChecker {
+	if cfg.Security.PermissionBackend == "opa" {
+		opaCfg := cfg.Security.OPA
+		log.Printf("Initializing OPA permission backend (policy: %q, tuples: %q)", opaCfg.PolicyFile, opaCfg.TuplesFile)
+		opaService, err := permissions.NewOPAPermissionService(opaCfg.PolicyFile, opaCfg.TuplesFile)
+		if err != nil {
+			log.Fatalf("Failed to initialize permission backend: %v", err)
+		}
+		return opaService
+	}
 
-	// Initialize permissions service
-	permService := permissions.NewKetoPermissionService(
+	ketoService := permissions.NewKetoPermissionService(
 		cfg.Services.Keto.ReadURL,
 		cfg.Services.Keto.WriteURL,
+		httpclient.New(httpclient.Options{
+			Timeout:    time.Duration(cfg.Services.Keto.Timeout) * time.Second,
+			MaxRetries: cfg.Services.Keto.MaxRetries,
+		}),
+		permissions.FailurePolicy(cfg.Security.PermissionFailureMode),
 	)
+	ketoService.SetNaming(cfg.Services.Keto.Naming())
+	if ruleCfgs := cfg.Services.Keto.AttributeRules; len(ruleCfgs) > 0 {
+		rules := make([]permissions.AttributeRule, len(ruleCfgs))
+		for i, ruleCfg := range ruleCfgs {
+			rules[i] = ruleCfg.Rule()
+			log.Printf("Attribute rule enabled: %s on %s:<metadata.%s> grants read access", rules[i].Relation, rules[i].Namespace, rules[i].Attribute)
+		}
+		ketoService.SetAttributeRules(rules)
+	}
+	if strategy := permissions.CheckStrategy(cfg.Services.Keto.CheckStrategy); strategy == permissions.StrategyList {
+		log.Printf("Permission list strategy enabled (list limit: %d)", cfg.Services.Keto.ListLimit)
+		ketoService.SetCheckStrategy(strategy, cfg.Services.Keto.ListLimit)
+	}
+	checkKeto(ketoService, permissions.SelfCheckMode(cfg.Services.Keto.StartupCheck))
+	var permService permissions.PermissionChecker = ketoService
+	if cacheCfg := cfg.Services.Keto.Cache; cacheCfg.Enabled {
+		log.Printf("Permission cache enabled (ttl: %ds, max entries: %d)", cacheCfg.TTL, cacheCfg.MaxEntries)
+		permService = permissions.NewCachingPermissionService(
+			permService,
+			time.Duration(cacheCfg.TTL)*time.Second,
+			cacheCfg.MaxEntries,
+		)
+	}
+	components.Register(lifecycle.Component{Name: "keto", HealthCheck: ketoService.Ping})
+	return permService
+}
 
-	// Initialize API server
-	server := api.NewServer(embedder, vectorStore, ollama, permService)
+// checkKeto verifies that Keto is reachable and has the configured
+// namespaces. Under SelfCheckStrict a problem stops startup; under
+// SelfCheckWarn it is logged, since every check would be denied until it is
+// fixed.
+func checkKeto(ketoService *permissions.KetoPermissionService, mode permissions.SelfCheckMode) {
+	if mode == permissions.SelfCheckOff {
+		return
+	}
+	err := ketoService.SelfCheck(context.Background())
+	if err == nil {
+		log.Printf("Keto self-check passed")
+		return
+	}
+	problems := strings.ReplaceAll(err.Error(), "\n", "; ")
+	if mode == permissions.SelfCheckStrict {
+		log.Fatalf("Keto self-check failed: %s (set services.keto.startup_check to \"warn\" to start anyway)", problems)
+	}
+	log.Printf("Warning: Keto self-check failed, permission checks will deny access until this is fixed: %s", problems)
+}
 
-	return vectorStore, server
+// applyPolicyFile reconciles the policy file into Keto. An invalid file stops
+// startup; a failure to reach Keto is logged so the server can still start.
+func applyPolicyFile(cfg config.PolicyConfig, permService permissions.PermissionChecker, vectorStore storage.VectorStore) {
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		log.Fatalf("Failed to read permission policy: %v", err)
+	}
+	p, err := policy.Parse(data)
+	if err != nil {
+		log.Fatalf("Failed to load permission policy %s: %v", cfg.File, err)
+	}
+	manager, ok := permService.(permissions.PermissionManager)
+	if !ok {
+		log.Fatalf("Permission service cannot apply policy %s", cfg.File)
+	}
+
+	ctx := tenant.NewContext(context.Background(), cfg.Tenant)
+	plan, err := policy.NewPlan(ctx, p, vectorStore.ForTenant(cfg.Tenant), manager)
+	if err == nil {
+		err = plan.Apply(ctx, manager, nil)
+	}
+	if err != nil {
+		log.Printf("Failed to apply permission policy %s: %v", cfg.File, err)
+		return
+	}
+	log.Printf("Permission policy %s applied (tenant: %s, granted: %d, revoked: %d)", cfg.File, cfg.Tenant, len(plan.Grant), len(plan.Revoke))
+}
+
+// newDirectory returns the options serving the user directory in the same
+// database. The seed file is applied first; an invalid file stops startup
+// and a failure to reach Keto is logged so the server can still start.
+func newDirectory(cfg config.DirectoryConfig, sqliteStore *storage.SQLiteVectorStore, permService permissions.PermissionChecker) []api.Option {
+	store, err := storage.NewSQLiteDirectoryStore(sqliteStore)
+	if err != nil {
+		log.Fatalf("Failed to initialize user directory: %v", err)
+	}
+	users := directory.New(store, permService)
+
+	if cfg.SeedFile != "" {
+		data, err := os.ReadFile(cfg.SeedFile)
+		if err != nil {
+			log.Fatalf("Failed to read directory seed: %v", err)
+		}
+		seed, err := directory.ParseSeed(data)
+		if err != nil {
+			log.Fatalf("Failed to load directory seed %s: %v", cfg.SeedFile, err)
+		}
+		if err := users.Seed(tenant.NewContext(context.Background(), cfg.Tenant), seed); err != nil {
+			log.Printf("Failed to apply directory seed %s: %v", cfg.SeedFile, err)
+		} else {
+			log.Printf("Directory seed %s applied (tenant: %s, groups: %d, users: %d)", cfg.SeedFile, cfg.Tenant, len(seed.Groups), len(seed.Users))
+		}
+	}
+
+	log.Println("User directory enabled")
+	return []api.Option{
+		api.WithAuthenticator(auth.NewDirectoryAuthenticator(store)),
+		api.WithDirectory(users),
+	}
+}
+
+func newWebhookDispatcher(cfg config.WebhooksConfig) *webhooks.Dispatcher {
+	endpoints := make([]webhooks.Endpoint, len(cfg.Endpoints))
+	for i, endpoint := range cfg.Endpoints {
+		endpoints[i] = webhooks.Endpoint{URL: endpoint.URL, Secret: endpoint.Secret}
+		for _, event := range endpoint.Events {
+			endpoints[i].Events = append(endpoints[i].Events, webhooks.EventType(event))
+		}
+	}
+	return webhooks.NewDispatcher(endpoints, httpclient.New(httpclient.Options{
+		Timeout:    time.Duration(cfg.Timeout) * time.Second,
+		MaxRetries: cfg.MaxRetries,
+		BaseDelay:  time.Second,
+		MaxDelay:   30 * time.Second,
+	}), cfg.QueueSize)
+}
+
+// newRetention returns the retention scheduler as a background component,
+// deleting the relations of expired documents when the permission service
+// supports it
+func newRetention(cfg config.RetentionConfig, vectorStore storage.VectorStore, permService permissions.PermissionChecker, notifier webhooks.Notifier) lifecycle.Component {
+	expirer, ok := vectorStore.(storage.Expirer)
+	if !ok {
+		log.Fatalf("Retention requires a document store that supports expiry")
+	}
+	opts := []retention.Option{retention.WithNotifier(notifier)}
+	if remover, ok := permService.(permissions.RelationRemover); ok {
+		opts = append(opts, retention.WithRelationRemover(remover))
+	}
+	log.Printf("Retention enabled (max age: %d days, metadata key: %q, interval: %ds)", cfg.MaxAgeDays, cfg.MetadataKey, cfg.CheckInterval)
+	scheduler := retention.NewScheduler(vectorStore, expirer, cfg.Policy(), opts...)
+	return lifecycle.Background("retention", func(ctx context.Context) {
+		scheduler.Run(ctx, time.Duration(cfg.CheckInterval)*time.Second)
+	})
+}
+
+// newOrphanReaper returns the orphan reaper as a background component
+func newOrphanReaper(cfg config.OrphansConfig, vectorStore storage.VectorStore, permService permissions.PermissionChecker, notifier webhooks.Notifier) lifecycle.Component {
+	tenants, ok := vectorStore.(storage.TenantLister)
+	if !ok {
+		log.Fatalf("Orphan reaping requires a document store that can list tenants")
+	}
+	checker, ok := permService.(permissions.OrphanChecker)
+	if !ok {
+		log.Fatalf("Orphan reaping requires a permission service that can list document relations")
+	}
+	log.Printf("Orphan reaping enabled (policy: %s, min age: %d days, interval: %ds)", cfg.Policy, cfg.MinAgeDays, cfg.CheckInterval)
+	reaper := orphans.NewReaper(vectorStore, tenants, checker, orphans.Policy(cfg.Policy),
+		time.Duration(cfg.MinAgeDays)*24*time.Hour, orphans.WithNotifier(notifier))
+	return lifecycle.Background("orphan reaper", func(ctx context.Context) {
+		reaper.Run(ctx, time.Duration(cfg.CheckInterval)*time.Second)
+	})
+}
+
+// newShareLinks returns the option enabling share links on the API server and
+// the job revoking the relations of expired links as a background component
+func newShareLinks(cfg config.ShareLinksConfig, vectorStore *storage.SQLiteVectorStore, permService permissions.PermissionChecker) (api.Option, lifecycle.Component) {
+	links, err := storage.NewSQLiteShareLinkStore(vectorStore)
+	if err != nil {
+		log.Fatalf("Failed to initialize share link store: %v", err)
+	}
+	manager, ok := permService.(permissions.PermissionManager)
+	if !ok {
+		log.Fatalf("Share links require a permission service that can change relations")
+	}
+	log.Printf("Share links enabled (default ttl: %ds, max ttl: %ds, cleanup interval: %ds)", cfg.DefaultTTL, cfg.MaxTTL, cfg.CleanupInterval)
+	option := api.WithShareLinks(links, share.NewSigner(cfg.Secret), time.Duration(cfg.DefaultTTL)*time.Second, time.Duration(cfg.MaxTTL)*time.Second)
+	return option, lifecycle.Background("share link expiry", func(ctx context.Context) {
+		share.RunExpiry(ctx, links, manager, time.Duration(cfg.CleanupInterval)*time.Second)
+	})
+}
+
+// newS3Connector returns the bucket connector as a crawl job syncing into
+// the configured tenant
+func newS3Connector(cfg *config.Config, embedder *embeddings.Embedder, vectorStore *storage.SQLiteVectorStore, notifier webhooks.Notifier) scheduler.Job {
+	s3Cfg := cfg.Ingestion.S3
+	client, err := s3.NewClient(s3.ClientConfig{
+		Endpoint:  s3Cfg.Endpoint,
+		Region:    s3Cfg.Region,
+		Bucket:    s3Cfg.Bucket,
+		AccessKey: s3Cfg.AccessKey,
+		SecretKey: s3Cfg.SecretKey,
+		PathStyle: s3Cfg.PathStyle,
+	}, httpclient.New(httpclient.Options{
+		Timeout:    time.Duration(s3Cfg.Timeout) * time.Second,
+		MaxRetries: s3Cfg.MaxRetries,
+	}))
+	if err != nil {
+		log.Fatalf("Failed to initialize S3 connector: %v", err)
+	}
+
+	cursors, err := storage.NewSQLiteCursorStore(vectorStore)
+	if err != nil {
+		log.Fatalf("Failed to initialize ingestion cursor store: %v", err)
+	}
+
+	pipeline := ingest.NewPipeline(embedder, cfg.Ingestion.ChunkSize, cfg.Ingestion.ChunkOverlap)
+	pipeline.SetSanitizer(newSanitizer(cfg.InjectionGuard))
+	pipeline.SetChunkVectors(cfg.Ingestion.ChunkVectors)
+
+	schedule, err := s3Cfg.CrawlSchedule()
+	if err != nil {
+		log.Fatalf("Invalid S3 connector schedule: %v", err)
+	}
+
+	log.Printf("S3 connector enabled (bucket: %s, prefix: %q, tenant: %s, schedule: %s)", s3Cfg.Bucket, s3Cfg.Prefix, s3Cfg.Tenant, describeSchedule(s3Cfg.Schedule, s3Cfg.SyncInterval))
+	connector := s3.NewConnector(
+		client,
+		s3Cfg.Prefix,
+		int64(cfg.Ingestion.MaxUploadSize)<<20,
+		pipeline,
+		vectorStore.ForTenant(s3Cfg.Tenant),
+		cursors.ForTenant(s3Cfg.Tenant),
+		s3.WithNotifier(notifier),
+	)
+	return scheduler.Job{
+		Name:     "s3",
+		Tenant:   s3Cfg.Tenant,
+		Schedule: schedule,
+		Run: func(ctx context.Context) (scheduler.RunStats, error) {
+			stats, err := connector.Sync(tenant.NewContext(ctx, s3Cfg.Tenant))
+			return scheduler.RunStats{
+				Added:     stats.Added,
+				Updated:   stats.Updated,
+				Removed:   stats.Removed,
+				Unchanged: stats.Unchanged,
+				Skipped:   stats.Skipped,
+				Failed:    stats.Failed,
+			}, err
+		},
+	}
+}
+
+// newDriveConnector returns the Google Drive or SharePoint connector as a
+// crawl job syncing into the configured tenant
+func newDriveConnector(cfg *config.Config, embedder *embeddings.Embedder, vectorStore *storage.SQLiteVectorStore, permService permissions.PermissionChecker, notifier webhooks.Notifier) scheduler.Job {
+	driveCfg := cfg.Ingestion.Drive
+	manager, ok := permService.(permissions.PermissionManager)
+	if !ok {
+		log.Fatalf("The drive connector requires a permission service that can change relations")
+	}
+
+	httpClient := httpclient.New(httpclient.Options{
+		Timeout:    time.Duration(driveCfg.Timeout) * time.Second,
+		MaxRetries: driveCfg.MaxRetries,
+	})
+	var source mirror.Source
+	switch driveCfg.Provider {
+	case "google":
+		credentials, err := os.ReadFile(driveCfg.Google.CredentialsFile)
+		if err != nil {
+			log.Fatalf("Failed to read Google service account key: %v", err)
+		}
+		source, err = gdrive.NewClient(gdrive.Config{
+			Credentials: credentials,
+			Subject:     driveCfg.Google.Subject,
+			DriveID:     driveCfg.Google.DriveID,
+			FolderID:    driveCfg.Google.FolderID,
+		}, httpClient)
+		if err != nil {
+			log.Fatalf("Failed to initialize Google Drive connector: %v", err)
+		}
+	case "sharepoint":
+		sp := driveCfg.SharePoint
+		var err error
+		source, err = sharepoint.NewClient(sharepoint.Config{
+			TenantID:     sp.TenantID,
+			ClientID:     sp.ClientID,
+			ClientSecret: sp.ClientSecret,
+			DriveID:      sp.DriveID,
+			Folder:       sp.Folder,
+		}, httpClient)
+		if err != nil {
+			log.Fatalf("Failed to initialize SharePoint connector: %v", err)
+		}
+	}
+
+	cursors, err := storage.NewSQLiteCursorStore(vectorStore)
+	if err != nil {
+		log.Fatalf("Failed to initialize ingestion cursor store: %v", err)
+	}
+
+	pipeline := ingest.NewPipeline(embedder, cfg.Ingestion.ChunkSize, cfg.Ingestion.ChunkOverlap)
+	pipeline.SetSanitizer(newSanitizer(cfg.InjectionGuard))
+	pipeline.SetChunkVectors(cfg.Ingestion.ChunkVectors)
+
+	schedule, err := driveCfg.CrawlSchedule()
+	if err != nil {
+		log.Fatalf("Invalid drive connector schedule: %v", err)
+	}
+
+	log.Printf("Drive connector enabled (source: %s, tenant: %s, schedule: %s)", source.Name(), driveCfg.Tenant, describeSchedule(driveCfg.Schedule, driveCfg.SyncInterval))
+	connector := mirror.NewConnector(
+		source,
+		int64(cfg.Ingestion.MaxUploadSize)<<20,
+		pipeline,
+		vectorStore.ForTenant(driveCfg.Tenant),
+		cursors.ForTenant(driveCfg.Tenant),
+		manager,
+		driveCfg.Identities.Identities(),
+		mirror.WithNotifier(notifier),
+	)
+	return scheduler.Job{
+		Name:     "drive",
+		Tenant:   driveCfg.Tenant,
+		Schedule: schedule,
+		Run: func(ctx context.Context) (scheduler.RunStats, error) {
+			stats, err := connector.Sync(tenant.NewContext(ctx, driveCfg.Tenant))
+			// Files whose sharing changed count as updated
+			return scheduler.RunStats{
+				Added:     stats.Added,
+				Updated:   stats.Updated + stats.Permissions,
+				Removed:   stats.Removed,
+				Unchanged: stats.Unchanged,
+				Skipped:   stats.Skipped,
+				Failed:    stats.Failed,
+			}, err
+		},
+	}
+}
+
+// describeSchedule describes a connector's schedule for the startup log
+func describeSchedule(spec string, interval int) string {
+	switch {
+	case strings.TrimSpace(spec) != "":
+		return strconv.Quote(spec)
+	case interval == 0:
+		return "once at startup"
+	}
+	return fmt.Sprintf("every %ds", interval)
+}
+
+// newOriginalsStore returns the configured store of original uploaded files
+func newOriginalsStore(cfg config.OriginalsConfig) blob.Store {
+	if cfg.Backend == "filesystem" {
+		log.Printf("Keeping original files in %s", cfg.Dir)
+		store, err := blob.NewFileStore(cfg.Dir)
+		if err != nil {
+			log.Fatalf("Failed to initialize original file store: %v", err)
+		}
+		return store
+	}
+
+	client, err := s3.NewClient(s3.ClientConfig{
+		Endpoint:  cfg.S3.Endpoint,
+		Region:    cfg.S3.Region,
+		Bucket:    cfg.S3.Bucket,
+		AccessKey: cfg.S3.AccessKey,
+		SecretKey: cfg.S3.SecretKey,
+		PathStyle: cfg.S3.PathStyle,
+	}, httpclient.New(httpclient.Options{
+		Timeout:    time.Duration(cfg.S3.Timeout) * time.Second,
+		MaxRetries: cfg.S3.MaxRetries,
+	}))
+	if err != nil {
+		log.Fatalf("Failed to initialize original file store: %v", err)
+	}
+	log.Printf("Keeping original files in bucket %s (prefix: %q)", cfg.S3.Bucket, cfg.S3.Prefix)
+	return blob.NewS3Store(client, cfg.S3.Prefix)
+}
+
+// newModerator returns the moderator of the configured provider
+func newModerator(cfg config.ModerationConfig) (moderation.Moderator, error) {
+	if cfg.Provider == "http" {
+		return moderation.NewHTTPModerator(cfg.BaseURL, cfg.Model, cfg.APIKey, time.Duration(cfg.Timeout)*time.Second), nil
+	}
+	rules, err := cfg.CompileRules()
+	if err != nil {
+		return nil, err
+	}
+	return moderation.NewKeywordModerator(rules), nil
+}
+
+// moderationAction returns the moderation action of a configured one, which
+// is empty for "off"
+func moderationAction(action string) moderation.Action {
+	if action == "off" {
+		return ""
+	}
+	return moderation.Action(action)
+}
+
+// newSanitizer returns the prompt injection sanitizer, or nil if the guard is disabled
+func newSanitizer(cfg config.InjectionGuardConfig) *injection.Sanitizer {
+	if !cfg.Enabled {
+		return nil
+	}
+	return injection.NewSanitizer(cfg.Threshold, cfg.Strip)
 }
 
 func createHTTPServer(cfg *config.Config, server *api.Server) *http.Server {
+	tlsConfig, err := cfg.GetTLSConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
 	return &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
 		Handler:      server.GetHandler(),
 		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
-		TLSConfig:    cfg.GetTLSConfig(),
+		TLSConfig:    tlsConfig,
 	}
 }
 
@@ -121,7 +926,7 @@ func startHTTPServer(cfg *config.Config, httpServer *http.Server) {
 	}
 }
 
-func waitForShutdown(server *api.Server) {
+func waitForShutdown(server *api.Server, httpServer *http.Server) {
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -130,8 +935,10 @@ func waitForShutdown(server *api.Server) {
 	log.Println("Shutting down server...")
 
 	// Graceful shutdown with timeout
-	shutdownTimeout := 30 * time.Second
-	if err := server.Shutdown(shutdownTimeout); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx, httpServer); err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 	}
 
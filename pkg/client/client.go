@@ -0,0 +1,659 @@
+// Package client is a Go client for the RAG API. It covers document ingestion,
+// listing, querying (including streamed answers), and permission management.
+//
+//	c, err := client.New("http://localhost:8080", client.WithToken("alice"))
+//	if err != nil {
+//		return err
+//	}
+//	answer, err := c.Query(ctx, client.QueryRequest{Question: "What was John's refund?"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"rerag-rbac-rag-llm/internal/httpclient"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snapshotHeader carries the server's permission snapshot tokens
+const snapshotHeader = "X-Permissions-Snapshot"
+
+// Client calls the RAG API. It is safe for concurrent use.
+//
+// Responses to permission changes carry a snapshot token that the client sends
+// with its later requests, so a query right after a grant sees the granted
+// documents even if another server replica cached an older decision.
+type Client struct {
+	baseURL *url.URL
+	http    *httpclient.Client
+	token   TokenFunc
+	apiKey  string
+	tenant  string
+
+	mu       sync.Mutex
+	snapshot string // latest snapshot token received
+}
+
+// TokenFunc returns the bearer token for a request, e.g. from a refreshing token source
+type TokenFunc func(ctx context.Context) (string, error)
+
+// settings collect options before the HTTP client is built
+type settings struct {
+	httpOpts httpclient.Options
+	token    TokenFunc
+	apiKey   string
+	tenant   string
+}
+
+// Option configures a Client
+type Option func(*settings)
+
+// WithToken sends a fixed bearer token with every request
+func WithToken(token string) Option {
+	return WithTokenFunc(func(context.Context) (string, error) { return token, nil })
+}
+
+// WithTokenFunc obtains the bearer token for every request from fn
+func WithTokenFunc(fn TokenFunc) Option {
+	return func(s *settings) {
+		s.token = fn
+	}
+}
+
+// WithAPIKey authenticates as a service with an API key in the X-API-Key
+// header. The server only accepts keys on the endpoints of their scopes and
+// ignores WithTenant in favor of the key's tenant.
+func WithAPIKey(key string) Option {
+	return func(s *settings) {
+		s.apiKey = key
+	}
+}
+
+// WithTenant selects the tenant with the X-Tenant-ID header
+func WithTenant(tenantID string) Option {
+	return func(s *settings) {
+		s.tenant = tenantID
+	}
+}
+
+// WithTimeout bounds each attempt including reading the response; for
+// QueryStream it bounds the whole stream. The default is no timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(s *settings) {
+		s.httpOpts.Timeout = d
+	}
+}
+
+// WithRetries retries network errors and 429/5xx responses up to maxRetries
+// times with exponential backoff starting at baseDelay. The default is 2
+// retries starting at 200ms.
+func WithRetries(maxRetries int, baseDelay time.Duration) Option {
+	return func(s *settings) {
+		s.httpOpts.MaxRetries = maxRetries
+		s.httpOpts.BaseDelay = baseDelay
+	}
+}
+
+// WithHTTPClient sends requests with hc, e.g. for custom TLS settings.
+// WithTimeout is ignored in favor of hc.Timeout.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(s *settings) {
+		s.httpOpts.HTTPClient = hc
+	}
+}
+
+// New creates a client for the API at baseURL
+func New(baseURL string, opts ...Option) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+
+	s := settings{httpOpts: httpclient.Options{MaxRetries: 2, BaseDelay: 200 * time.Millisecond}}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return &Client{
+		baseURL: base,
+		http:    httpclient.New(s.httpOpts),
+		token:   s.token,
+		apiKey:  s.apiKey,
+		tenant:  s.tenant,
+	}, nil
+}
+
+// Error is a non-2xx API response
+type Error struct {
+	StatusCode int
+	Message    string
+	// Reason and Details give more context when the server provides them
+	Reason    string
+	Details   string
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("api returned status %d: %s", e.StatusCode, e.Message)
+	if e.Reason != "" {
+		msg += " (" + e.Reason + ")"
+	}
+	if e.Details != "" {
+		msg += ": " + e.Details
+	}
+	return msg
+}
+
+// IsStatus reports whether err is an API error with the given status code
+func IsStatus(err error, statusCode int) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// AddDocument embeds and stores a document. A document with an existing ID replaces it.
+func (c *Client) AddDocument(ctx context.Context, doc Document) (*DocumentResponse, error) {
+	var out DocumentResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/documents", nil, doc, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UploadDocument ingests a PDF, DOCX, HTML, Markdown, or text file. An empty
+// title uses the title found in the file or its name.
+func (c *Client) UploadDocument(ctx context.Context, filename string, content io.Reader, title string) (*UploadResponse, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(filename))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, content); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	if title != "" {
+		if err := form.WriteField("title", title); err != nil {
+			return nil, err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/documents/upload", nil, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var out UploadResponse
+	if err := c.send(req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetDocument returns the document with id if the user may access it
+func (c *Client) GetDocument(ctx context.Context, id string) (*Document, error) {
+	var out Document
+	if err := c.doJSON(ctx, http.MethodGet, "/documents/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetOriginal downloads the file the document with id was extracted from,
+// if the server keeps originals and the user may access the document
+func (c *Client) GetOriginal(ctx context.Context, id string) (*Original, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/documents/"+url.PathEscape(id)+"/original", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, decodeError(resp)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read original file: %w", err)
+	}
+	original := &Original{ContentType: resp.Header.Get("Content-Type"), Content: content}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		original.Filename = params["filename"]
+	}
+	return original, nil
+}
+
+// SharedDocument returns the document with id using the token of a share
+// link instead of the client's credentials
+func (c *Client) SharedDocument(ctx context.Context, id, token string) (*Document, error) {
+	var out Document
+	query := url.Values{"share_token": {token}}
+	if err := c.doJSON(ctx, http.MethodGet, "/documents/"+url.PathEscape(id), query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ShareDocument creates a link granting read access to the document with id
+// until it expires after expiresIn (0 for the server's default); it requires
+// the editor relation. The returned token cannot be retrieved again.
+func (c *Client) ShareDocument(ctx context.Context, id string, expiresIn time.Duration) (*ShareLink, error) {
+	in := map[string]interface{}{}
+	if expiresIn > 0 {
+		in["expires_in"] = int(expiresIn.Seconds())
+	}
+	var out ShareLink
+	if err := c.doJSON(ctx, http.MethodPost, "/documents/"+url.PathEscape(id)+"/share", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListDocuments returns a page of the documents the user may access
+func (c *Client) ListDocuments(ctx context.Context, opts ListOptions) (*DocumentList, error) {
+	query := url.Values{}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Offset > 0 {
+		query.Set("offset", strconv.Itoa(opts.Offset))
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Descending {
+		query.Set("order", "desc")
+	}
+	for key, value := range opts.Metadata {
+		query.Set("metadata."+key, value)
+	}
+
+	var out DocumentList
+	if err := c.doJSON(ctx, http.MethodGet, "/documents", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ExportDocuments streams documents with their embeddings to fn, oldest first
+// per tenant. It requires the write relation on the corpus of every exported
+// tenant and stops at the first error returned by fn.
+func (c *Client) ExportDocuments(ctx context.Context, opts ExportOptions, fn func(ExportedDocument) error) error {
+	query := url.Values{}
+	for _, tenantID := range opts.Tenants {
+		query.Add("tenant", tenantID)
+	}
+	for key, value := range opts.Metadata {
+		query.Set("metadata."+key, value)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, "/documents/export", query, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var doc ExportedDocument
+		if err := decoder.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to decode exported document: %w", err)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
+
+// StartReindex starts re-embedding all documents with the server's current
+// embedding model. Poll ReindexStatus for progress.
+func (c *Client) StartReindex(ctx context.Context) (*ReindexStatus, error) {
+	var out ReindexStatus
+	if err := c.doJSON(ctx, http.MethodPost, "/documents/reindex", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ReindexStatus returns the progress of the running or last reindex job
+func (c *Client) ReindexStatus(ctx context.Context) (*ReindexStatus, error) {
+	var out ReindexStatus
+	if err := c.doJSON(ctx, http.MethodGet, "/documents/reindex", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RekeyDatabase re-encrypts the server's database with key without
+// downtime. The server's configured key must be updated before it restarts.
+func (c *Client) RekeyDatabase(ctx context.Context, key string) (*RekeyDatabaseResponse, error) {
+	var out RekeyDatabaseResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/admin/database/rekey", nil, map[string]string{"key": key}, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Query answers a question from the documents the user may access
+func (c *Client) Query(ctx context.Context, q QueryRequest) (*QueryResponse, error) {
+	var out QueryResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/query", nil, q, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Compare asks how two or more readable documents differ
+func (c *Client) Compare(ctx context.Context, req CompareRequest) (*CompareResponse, error) {
+	var out CompareResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/compare", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Permissions lists the objects the authenticated user holds relations on
+func (c *Client) Permissions(ctx context.Context) (*Permissions, error) {
+	var out Permissions
+	if err := c.doJSON(ctx, http.MethodGet, "/permissions", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DocumentAccess returns the relations (viewer, editor) the authenticated
+// user holds on the document with id
+func (c *Client) DocumentAccess(ctx context.Context, id string) (*DocumentAccess, error) {
+	var out DocumentAccess
+	if err := c.doJSON(ctx, http.MethodGet, "/documents/"+url.PathEscape(id)+"/access", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DocumentPermissions returns a page of at most limit (0 for the server's
+// default) relations users and groups hold on the document with id; it
+// requires the editor relation. Pass the previous page's NextPageToken as
+// pageToken to continue, or "" for the first page.
+func (c *Client) DocumentPermissions(ctx context.Context, id string, limit int, pageToken string) (*DocumentPermissions, error) {
+	query := url.Values{}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	if pageToken != "" {
+		query.Set("page_token", pageToken)
+	}
+
+	var out DocumentPermissions
+	if err := c.doJSON(ctx, http.MethodGet, "/documents/"+url.PathEscape(id)+"/permissions", query, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Usage reports the storage the authenticated user consumes in the tenant,
+// the configured quotas, and the user's query totals
+func (c *Client) Usage(ctx context.Context) (*Usage, error) {
+	var out Usage
+	if err := c.doJSON(ctx, http.MethodGet, "/usage", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// CreateAPIKey creates an API key acting as user with the given scopes; it
+// requires the write relation. The returned key cannot be retrieved again.
+func (c *Client) CreateAPIKey(ctx context.Context, name, user string, scopes ...string) (*CreatedAPIKey, error) {
+	in := map[string]interface{}{"name": name, "user": user, "scopes": scopes}
+	var out CreatedAPIKey
+	if err := c.doJSON(ctx, http.MethodPost, "/api-keys", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListAPIKeys lists the tenant's API keys including revoked ones; it requires the write relation
+func (c *Client) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	var out struct {
+		Keys []APIKey `json:"keys"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/api-keys", nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Keys, nil
+}
+
+// RevokeAPIKey revokes an API key; it requires the write relation
+func (c *Client) RevokeAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	var out APIKey
+	if err := c.doJSON(ctx, http.MethodDelete, "/api-keys/"+url.PathEscape(id), nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GrantPermission gives a user or a group's members a relation; it requires the write relation
+func (c *Client) GrantPermission(ctx context.Context, change PermissionChange) (*PermissionChangeResponse, error) {
+	var out PermissionChangeResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/permissions", nil, change, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GrantPermissions grants up to 1000 relations in one request; it requires
+// the write relation. Grants that fail are listed in the result's Failed
+// while the others are made.
+func (c *Client) GrantPermissions(ctx context.Context, grants []PermissionChange) (*BulkGrantResult, error) {
+	in := struct {
+		Grants []PermissionChange `json:"grants"`
+	}{Grants: grants}
+	var out BulkGrantResult
+	if err := c.doJSON(ctx, http.MethodPost, "/permissions/bulk", nil, in, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RevokePermission removes a relation from a user or a group; it requires the write relation
+func (c *Client) RevokePermission(ctx context.Context, change PermissionChange) (*PermissionChangeResponse, error) {
+	var out PermissionChangeResponse
+	if err := c.doJSON(ctx, http.MethodDelete, "/permissions", nil, change, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Group returns a group's members and relations; it requires the write relation
+func (c *Client) Group(ctx context.Context, group string) (*Group, error) {
+	var out Group
+	if err := c.doJSON(ctx, http.MethodGet, "/groups/"+group, nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AddGroupMember adds a user to a group, giving them the group's relations;
+// it requires the write relation
+func (c *Client) AddGroupMember(ctx context.Context, group, user string) (*GroupMemberResponse, error) {
+	return c.changeGroupMember(ctx, http.MethodPut, group, user)
+}
+
+// RemoveGroupMember removes a user from a group; it requires the write relation
+func (c *Client) RemoveGroupMember(ctx context.Context, group, user string) (*GroupMemberResponse, error) {
+	return c.changeGroupMember(ctx, http.MethodDelete, group, user)
+}
+
+func (c *Client) changeGroupMember(ctx context.Context, method, group, user string) (*GroupMemberResponse, error) {
+	var out GroupMemberResponse
+	path := "/groups/" + group + "/members/" + user
+	if err := c.doJSON(ctx, method, path, nil, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ApplyPolicy reconciles a YAML permission policy into Keto; it requires the
+// write relation. With dryRun the changes are only planned.
+func (c *Client) ApplyPolicy(ctx context.Context, policy []byte, dryRun bool) (*PolicyResult, error) {
+	var query url.Values
+	if dryRun {
+		query = url.Values{"dry_run": {"true"}}
+	}
+	req, err := c.newRequest(ctx, http.MethodPut, "/permissions/policy", query, bytes.NewReader(policy))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/yaml")
+
+	var out PolicyResult
+	if err := c.send(req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// doJSON sends in as the JSON body, if not nil, and decodes the response into out
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req, out)
+}
+
+// newRequest builds a request with the auth token and tenant headers
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := *c.baseURL
+	target.Path += path
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != nil {
+		token, err := c.token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+	if snapshot := c.Snapshot(); snapshot != "" {
+		req.Header.Set(snapshotHeader, snapshot)
+	}
+	return req, nil
+}
+
+// Snapshot returns the latest permission snapshot token received, or "" if no
+// request changed permissions yet
+func (c *Client) Snapshot() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snapshot
+}
+
+// send performs req and decodes a 2xx JSON response into out
+func (c *Client) send(req *http.Request, out interface{}) error {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if snapshot := resp.Header.Get(snapshotHeader); snapshot != "" {
+		c.mu.Lock()
+		c.snapshot = snapshot
+		c.mu.Unlock()
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// decodeError reads the error formats the API responds with
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+		RequestID:  resp.Header.Get("X-Request-ID"),
+	}
+
+	var payload struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Details string          `json:"details"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		if text := strings.TrimSpace(string(body)); text != "" {
+			apiErr.Message = text
+		}
+		return apiErr
+	}
+
+	var nested struct {
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+		Debug   string `json:"debug"`
+	}
+	var plain string
+	switch {
+	case json.Unmarshal(payload.Error, &nested) == nil && nested.Message != "":
+		apiErr.Message, apiErr.Reason, apiErr.Details = nested.Message, nested.Reason, nested.Debug
+	case json.Unmarshal(payload.Error, &plain) == nil && plain != "":
+		apiErr.Message = plain
+	case payload.Message != "":
+		apiErr.Message, apiErr.Details = payload.Message, payload.Details
+	}
+	return apiErr
+}
@@ -0,0 +1,549 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	opts = append([]Option{WithRetries(2, time.Millisecond)}, opts...)
+	c, err := New(server.URL, opts...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return c
+}
+
+func TestNewRejectsInvalidBaseURL(t *testing.T) {
+	for _, baseURL := range []string{"", "localhost:8080", "://bad"} {
+		if _, err := New(baseURL); err == nil {
+			t.Errorf("expected error for base URL %q", baseURL)
+		}
+	}
+}
+
+func TestAddDocumentSendsTokenAndTenant(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/documents" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer alice" {
+			t.Errorf("expected bearer token, got %q", got)
+		}
+		if got := r.Header.Get("X-Tenant-ID"); got != "acme" {
+			t.Errorf("expected tenant header, got %q", got)
+		}
+		var doc Document
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			t.Fatalf("failed to decode document: %v", err)
+		}
+		if doc.Title != "Refunds" || doc.Metadata["team"] != "support" {
+			t.Errorf("unexpected document %+v", doc)
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(DocumentResponse{ID: "doc-1", Message: "Document added successfully"})
+	}, WithToken("alice"), WithTenant("acme"))
+
+	resp, err := c.AddDocument(context.Background(), Document{
+		Title:    "Refunds",
+		Content:  "John was refunded $50",
+		Metadata: map[string]interface{}{"team": "support"},
+	})
+	if err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	if resp.ID != "doc-1" {
+		t.Errorf("expected ID doc-1, got %q", resp.ID)
+	}
+}
+
+func TestTokenFuncIsCalledPerRequest(t *testing.T) {
+	var calls atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		want := fmt.Sprintf("Bearer token-%d", calls.Load())
+		if got := r.Header.Get("Authorization"); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+		_ = json.NewEncoder(w).Encode(Permissions{User: "alice"})
+	}, WithTokenFunc(func(context.Context) (string, error) {
+		return fmt.Sprintf("token-%d", calls.Add(1)), nil
+	}))
+
+	for range 2 {
+		if _, err := c.Permissions(context.Background()); err != nil {
+			t.Fatalf("Permissions failed: %v", err)
+		}
+	}
+
+	failing := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not be sent without a token")
+	}, WithTokenFunc(func(context.Context) (string, error) {
+		return "", errors.New("expired")
+	}))
+	if _, err := failing.Permissions(context.Background()); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected token error, got %v", err)
+	}
+}
+
+func TestListDocumentsEncodesOptions(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for key, want := range map[string]string{
+			"limit":         "10",
+			"offset":        "20",
+			"sort":          "title",
+			"order":         "desc",
+			"metadata.team": "support",
+		} {
+			if got := query.Get(key); got != want {
+				t.Errorf("expected %s=%q, got %q", key, want, got)
+			}
+		}
+		next := 30
+		_ = json.NewEncoder(w).Encode(DocumentList{
+			Documents:  []Document{{ID: "doc-1", Title: "Refunds"}},
+			Count:      1,
+			User:       "alice",
+			NextOffset: &next,
+		})
+	})
+
+	list, err := c.ListDocuments(context.Background(), ListOptions{
+		Limit:      10,
+		Offset:     20,
+		Sort:       "title",
+		Descending: true,
+		Metadata:   map[string]string{"team": "support"},
+	})
+	if err != nil {
+		t.Fatalf("ListDocuments failed: %v", err)
+	}
+	if list.Count != 1 || list.NextOffset == nil || *list.NextOffset != 30 {
+		t.Errorf("unexpected list %+v", list)
+	}
+}
+
+func TestExportDocumentsDecodesJSONL(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/documents/export" || !slices.Equal(r.URL.Query()["tenant"], []string{"acme", "globex"}) || r.URL.Query().Get("metadata.year") != "2023" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		_, _ = io.WriteString(w, `{"id":"doc-1","title":"Return","tenant_id":"acme","embedding":[0.1,0.2]}`+"\n")
+		_, _ = io.WriteString(w, `{"id":"doc-2","title":"Invoice","tenant_id":"globex"}`+"\n")
+	})
+
+	var docs []ExportedDocument
+	err := c.ExportDocuments(context.Background(), ExportOptions{
+		Tenants:  []string{"acme", "globex"},
+		Metadata: map[string]string{"year": "2023"},
+	}, func(doc ExportedDocument) error {
+		docs = append(docs, doc)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportDocuments failed: %v", err)
+	}
+	if len(docs) != 2 || docs[0].TenantID != "acme" || len(docs[0].Embedding) != 2 || docs[1].ID != "doc-2" {
+		t.Errorf("unexpected export %+v", docs)
+	}
+}
+
+func TestGetOriginalDownloadsFile(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/documents/doc-1/original" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error":{"code":404,"message":"Resource not found"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="Q3 report.pdf"`)
+		_, _ = io.WriteString(w, "%PDF-1.7")
+	})
+
+	original, err := c.GetOriginal(context.Background(), "doc-1")
+	if err != nil {
+		t.Fatalf("GetOriginal failed: %v", err)
+	}
+	if original.Filename != "Q3 report.pdf" || original.ContentType != "application/pdf" || string(original.Content) != "%PDF-1.7" {
+		t.Errorf("unexpected original %+v", original)
+	}
+	var apiErr *Error
+	if _, err := c.GetOriginal(context.Background(), "doc-2"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+}
+
+func TestQueryRetriesTransientFailures(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var q QueryRequest
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil || q.Question != "What was John's refund?" {
+			t.Errorf("attempt %d: unexpected body %+v (%v)", attempts.Load()+1, q, err)
+		}
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(QueryResponse{Answer: "$50", SourcesIncluded: 1})
+	})
+
+	resp, err := c.Query(context.Background(), QueryRequest{Question: "What was John's refund?"})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if resp.Answer != "$50" {
+		t.Errorf("expected answer $50, got %q", resp.Answer)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestErrorsAreDecoded(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		message string
+		reason  string
+	}{
+		{
+			name:    "herodot",
+			status:  http.StatusForbidden,
+			body:    `{"error":{"code":403,"status":"Forbidden","message":"The requested action was forbidden","reason":"User cannot write documents"}}`,
+			message: "The requested action was forbidden",
+			reason:  "User cannot write documents",
+		},
+		{
+			name:    "flat",
+			status:  http.StatusInternalServerError,
+			body:    `{"code":500,"status":"Internal Server Error","message":"Internal server error","request_id":"abc"}`,
+			message: "Internal server error",
+		},
+		{
+			name:    "plain",
+			status:  http.StatusUnauthorized,
+			body:    `{"error":"Missing authorization header"}`,
+			message: "Missing authorization header",
+		},
+		{
+			name:    "text",
+			status:  http.StatusNotFound,
+			body:    "404 page not found\n",
+			message: "404 page not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Request-ID", "req-1")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}, WithRetries(0, time.Millisecond))
+
+			_, err := c.Permissions(context.Background())
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected *Error, got %v", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Message != tt.message || apiErr.Reason != tt.reason {
+				t.Errorf("unexpected error %+v", apiErr)
+			}
+			if apiErr.RequestID != "req-1" {
+				t.Errorf("expected request ID req-1, got %q", apiErr.RequestID)
+			}
+			if !IsStatus(err, tt.status) {
+				t.Errorf("expected IsStatus(%d)", tt.status)
+			}
+		})
+	}
+}
+
+func TestGrantAndRevokePermission(t *testing.T) {
+	var methods []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		var change PermissionChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			t.Fatalf("failed to decode change: %v", err)
+		}
+		if change.User != "bob" || change.Relation != RelationViewer || change.DocumentID != "doc-1" {
+			t.Errorf("unexpected change %+v", change)
+		}
+		_ = json.NewEncoder(w).Encode(PermissionChangeResponse{User: change.User, Relation: change.Relation, Object: change.DocumentID})
+	})
+
+	change := PermissionChange{User: "bob", Relation: RelationViewer, DocumentID: "doc-1"}
+	if _, err := c.GrantPermission(context.Background(), change); err != nil {
+		t.Fatalf("GrantPermission failed: %v", err)
+	}
+	if _, err := c.RevokePermission(context.Background(), change); err != nil {
+		t.Fatalf("RevokePermission failed: %v", err)
+	}
+	if strings.Join(methods, ",") != "POST,DELETE" {
+		t.Errorf("expected POST then DELETE, got %v", methods)
+	}
+}
+
+func TestGrantPermissions(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Grants []PermissionChange `json:"grants"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || r.Method != http.MethodPost || r.URL.Path != "/permissions/bulk" {
+			t.Errorf("unexpected request %s %s: %v", r.Method, r.URL, err)
+		}
+		_ = json.NewEncoder(w).Encode(BulkGrantResult{
+			Granted: []RelationTuple{{User: req.Grants[0].User, Relation: RelationViewer, Object: "doc-1"}},
+			Failed:  []BulkGrantFailure{{Index: 1, Grant: req.Grants[1], Error: "relation viewer requires a document"}},
+		})
+	})
+
+	result, err := c.GrantPermissions(context.Background(), []PermissionChange{
+		{User: "bob", Relation: RelationViewer, DocumentID: "doc-1"},
+		{User: "carol", Relation: RelationViewer},
+	})
+	if err != nil {
+		t.Fatalf("GrantPermissions failed: %v", err)
+	}
+	if len(result.Granted) != 1 || len(result.Failed) != 1 || result.Failed[0].Grant.User != "carol" {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestSnapshotTokenIsSentAfterPermissionChanges(t *testing.T) {
+	var sent []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Header.Get("X-Permissions-Snapshot"))
+		if r.Method == http.MethodPost && r.URL.Path == "/permissions" {
+			w.Header().Set("X-Permissions-Snapshot", "snap-1")
+			_ = json.NewEncoder(w).Encode(PermissionChangeResponse{User: "bob"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(QueryResponse{Answer: "42"})
+	})
+
+	ctx := context.Background()
+	if _, err := c.Query(ctx, QueryRequest{Question: "q"}); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if _, err := c.GrantPermission(ctx, PermissionChange{User: "bob", Relation: RelationViewer, DocumentID: "doc-1"}); err != nil {
+		t.Fatalf("GrantPermission failed: %v", err)
+	}
+	if _, err := c.Query(ctx, QueryRequest{Question: "q"}); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if !slices.Equal(sent, []string{"", "", "snap-1"}) || c.Snapshot() != "snap-1" {
+		t.Errorf("Expected the grant's token on the following query, sent %q", sent)
+	}
+}
+
+func TestUploadDocumentSendsMultipartForm(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/documents/upload" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Fatalf("missing file: %v", err)
+		}
+		defer func() { _ = file.Close() }()
+		if header.Filename != "notes.md" || r.FormValue("title") != "Notes" {
+			t.Errorf("unexpected form: filename %q, title %q", header.Filename, r.FormValue("title"))
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(UploadResponse{Filename: header.Filename, DocumentIDs: []string{"doc-1"}})
+	})
+
+	resp, err := c.UploadDocument(context.Background(), "docs/notes.md", strings.NewReader("# Notes"), "Notes")
+	if err != nil {
+		t.Fatalf("UploadDocument failed: %v", err)
+	}
+	if len(resp.DocumentIDs) != 1 {
+		t.Errorf("expected one document, got %v", resp.DocumentIDs)
+	}
+}
+
+func TestQueryStream(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("expected event stream to be requested, got %q", r.Header.Get("Accept"))
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+		_, _ = fmt.Fprint(w, "event: delta\ndata: {\"text\":\"John was \"}\n\n")
+		_, _ = fmt.Fprint(w, "event: delta\ndata: {\"text\":\"refunded $50\"}\n\n")
+		_, _ = fmt.Fprint(w, "event: done\ndata: {\"answer\":\"John was refunded $50\",\"sources\":[],\"sources_included\":0}\n\n")
+	})
+
+	var streamed strings.Builder
+	resp, err := c.QueryStream(context.Background(), QueryRequest{Question: "refund?"}, func(text string) {
+		streamed.WriteString(text)
+	})
+	if err != nil {
+		t.Fatalf("QueryStream failed: %v", err)
+	}
+	if streamed.String() != "John was refunded $50" || resp.Answer != streamed.String() {
+		t.Errorf("streamed %q, answer %q", streamed.String(), resp.Answer)
+	}
+}
+
+func TestQueryStreamFailures(t *testing.T) {
+	t.Run("error event", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprint(w, "event: delta\ndata: {\"text\":\"John\"}\n\n")
+			_, _ = fmt.Fprint(w, "event: error\ndata: {\"error\":\"Failed to generate answer\"}\n\n")
+		})
+		_, err := c.QueryStream(context.Background(), QueryRequest{Question: "refund?"}, func(string) {})
+		var streamErr *StreamError
+		if !errors.As(err, &streamErr) || streamErr.Message != "Failed to generate answer" {
+			t.Errorf("expected stream error, got %v", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprint(w, "event: delta\ndata: {\"text\":\"John\"}\n\n")
+		})
+		_, err := c.QueryStream(context.Background(), QueryRequest{Question: "refund?"}, func(string) {})
+		if !errors.Is(err, ErrStreamIncomplete) {
+			t.Errorf("expected ErrStreamIncomplete, got %v", err)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"error":{"code":400,"message":"The request was malformed or contained invalid parameters","reason":"Question is required"}}`)
+		})
+		_, err := c.QueryStream(context.Background(), QueryRequest{}, func(string) {})
+		if !IsStatus(err, http.StatusBadRequest) {
+			t.Errorf("expected 400 error, got %v", err)
+		}
+	})
+}
+
+func TestApplyPolicySendsYAML(t *testing.T) {
+	policy := "users: [alice]\n"
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/permissions/policy" || r.URL.Query().Get("dry_run") != "true" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != policy {
+			t.Errorf("expected the policy as the body, got %q", body)
+		}
+		_ = json.NewEncoder(w).Encode(PolicyResult{Granted: []RelationTuple{{User: "alice", Relation: RelationWrite, Object: "corpus"}}, DryRun: true})
+	})
+
+	result, err := c.ApplyPolicy(context.Background(), []byte(policy), true)
+	if err != nil {
+		t.Fatalf("ApplyPolicy failed: %v", err)
+	}
+	if !result.DryRun || len(result.Granted) != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestGroupMembersAndGrants(t *testing.T) {
+	var requests []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/permissions":
+			var change PermissionChange
+			_ = json.NewDecoder(r.Body).Decode(&change)
+			if change.Group != "accounting-team" || change.User != "" {
+				t.Errorf("unexpected change %+v", change)
+			}
+			_ = json.NewEncoder(w).Encode(PermissionChangeResponse{Group: change.Group, Relation: change.Relation, Object: change.DocumentID})
+		case "/groups/accounting-team":
+			_, _ = fmt.Fprint(w, `{"group":"accounting-team","members":["alice"],"relations":[{"group":"accounting-team","relation":"viewer","object":"doc-1"}]}`)
+		default:
+			_, _ = fmt.Fprint(w, `{"group":"accounting-team","user":"alice","message":"ok"}`)
+		}
+	})
+
+	ctx := context.Background()
+	if _, err := c.AddGroupMember(ctx, "accounting-team", "alice"); err != nil {
+		t.Fatalf("AddGroupMember failed: %v", err)
+	}
+	if _, err := c.GrantPermission(ctx, PermissionChange{Group: "accounting-team", Relation: RelationViewer, DocumentID: "doc-1"}); err != nil {
+		t.Fatalf("GrantPermission failed: %v", err)
+	}
+	group, err := c.Group(ctx, "accounting-team")
+	if err != nil {
+		t.Fatalf("Group failed: %v", err)
+	}
+	if len(group.Members) != 1 || len(group.Relations) != 1 || group.Relations[0].Group != "accounting-team" {
+		t.Errorf("unexpected group %+v", group)
+	}
+	if _, err := c.RemoveGroupMember(ctx, "accounting-team", "alice"); err != nil {
+		t.Fatalf("RemoveGroupMember failed: %v", err)
+	}
+
+	want := "PUT /groups/accounting-team/members/alice,POST /permissions,GET /groups/accounting-team,DELETE /groups/accounting-team/members/alice"
+	if strings.Join(requests, ",") != want {
+		t.Errorf("expected %s, got %v", want, requests)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("X-API-Key"); got != "rrk_secret" {
+			t.Errorf("expected API key header, got %q", got)
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Error("expected no bearer token")
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api-keys":
+			var in CreatedAPIKey
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				t.Fatalf("failed to decode key: %v", err)
+			}
+			if in.Name != "import" || in.User != "bot" || !slices.Equal(in.Scopes, []string{ScopeIngest}) {
+				t.Errorf("unexpected key %+v", in)
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(CreatedAPIKey{APIKey: APIKey{ID: "key-1", Name: in.Name}, Key: "rrk_new"})
+		case "GET /api-keys":
+			_, _ = io.WriteString(w, `{"keys": [{"id": "key-1", "name": "import"}]}`)
+		case "DELETE /api-keys/key-1":
+			_ = json.NewEncoder(w).Encode(APIKey{ID: "key-1", RevokedAt: &time.Time{}})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}, WithAPIKey("rrk_secret"))
+
+	created, err := c.CreateAPIKey(context.Background(), "import", "bot", ScopeIngest)
+	if err != nil || created.Key != "rrk_new" {
+		t.Fatalf("CreateAPIKey failed: %+v (%v)", created, err)
+	}
+	keys, err := c.ListAPIKeys(context.Background())
+	if err != nil || len(keys) != 1 || keys[0].ID != "key-1" {
+		t.Fatalf("ListAPIKeys failed: %+v (%v)", keys, err)
+	}
+	revoked, err := c.RevokeAPIKey(context.Background(), "key-1")
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("RevokeAPIKey failed: %+v (%v)", revoked, err)
+	}
+}
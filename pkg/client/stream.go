@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrStreamIncomplete is returned when a stream ends without a final answer
+var ErrStreamIncomplete = errors.New("query stream ended before the answer was complete")
+
+// StreamError is an error the server reported after streaming had started
+type StreamError struct {
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return "query stream failed: " + e.Message
+}
+
+// QueryStream answers a question like Query, passing each piece of the answer
+// to onDelta as it is generated. It returns the complete response once the
+// answer is done. Failures before the first piece are returned as *Error like
+// any other request; failures after it as *StreamError.
+func (c *Client) QueryStream(ctx context.Context, q QueryRequest, onDelta func(text string)) (*QueryResponse, error) {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/query", nil, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, decodeError(resp)
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		// The server answered without streaming, e.g. over a connection that
+		// cannot be flushed
+		var out QueryResponse
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		onDelta(out.Answer)
+		return &out, nil
+	}
+
+	var event string
+	var payload strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			done, out, err := handleEvent(event, payload.String(), onDelta)
+			if err != nil || done {
+				return out, err
+			}
+			event = ""
+			payload.Reset()
+		case strings.HasPrefix(line, ":"):
+			// Comment
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if payload.Len() > 0 {
+				payload.WriteByte('\n')
+			}
+			payload.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, ErrStreamIncomplete
+}
+
+// handleEvent processes one server-sent event and reports whether the stream is done
+func handleEvent(event, data string, onDelta func(string)) (bool, *QueryResponse, error) {
+	switch event {
+	case "delta":
+		var delta struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal([]byte(data), &delta); err != nil {
+			return true, nil, fmt.Errorf("failed to decode delta event: %w", err)
+		}
+		onDelta(delta.Text)
+	case "done":
+		var out QueryResponse
+		if err := json.Unmarshal([]byte(data), &out); err != nil {
+			return true, nil, fmt.Errorf("failed to decode done event: %w", err)
+		}
+		return true, &out, nil
+	case "error":
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal([]byte(data), &failure)
+		return true, nil, &StreamError{Message: failure.Error}
+	}
+	// Unknown events are ignored so the server can add new ones
+	return false, nil, nil
+}
@@ -0,0 +1,428 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// Document is a document stored in the corpus
+type Document struct {
+	ID        string                 `json:"id,omitempty"`
+	Title     string                 `json:"title"`
+	Content   string                 `json:"content"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	CreatedAt time.Time              `json:"created_at,omitzero"`
+}
+
+// DocumentResponse is returned after adding a document
+type DocumentResponse struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// UploadResponse is returned after uploading a file
+type UploadResponse struct {
+	Filename    string   `json:"filename"`
+	ContentType string   `json:"content_type"`
+	SourceID    string   `json:"source_id"`
+	DocumentIDs []string `json:"document_ids"`
+	Message     string   `json:"message"`
+}
+
+// Original is the uploaded file documents were extracted from
+type Original struct {
+	Filename    string
+	ContentType string
+	Content     []byte
+}
+
+// ListOptions select a page of documents. Zero values use the server defaults.
+type ListOptions struct {
+	Limit  int
+	Offset int
+	// Sort is "title" or "created_at"
+	Sort string
+	// Descending reverses the sort order
+	Descending bool
+	// Metadata filters documents by exact metadata values
+	Metadata map[string]string
+}
+
+// DocumentList is a page of accessible documents
+type DocumentList struct {
+	Documents []Document `json:"documents"`
+	Count     int        `json:"count"`
+	User      string     `json:"user"`
+	// NextOffset is the offset of the next page; nil on the last page
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// ExportOptions selects the documents of an export
+type ExportOptions struct {
+	// Tenants to export; the client's tenant if empty
+	Tenants []string
+	// Metadata filters documents by exact metadata values
+	Metadata map[string]string
+}
+
+// ExportedDocument is a document of an export with its tenant and embedding
+type ExportedDocument struct {
+	Document
+	TenantID  string    `json:"tenant_id,omitempty"`
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// States of ReindexStatus.State
+const (
+	ReindexIdle      = "idle"
+	ReindexRunning   = "running"
+	ReindexCompleted = "completed"
+	ReindexFailed    = "failed"
+)
+
+// ReindexStatus reports the progress of the running or last reindex job
+type ReindexStatus struct {
+	State      string    `json:"state"`
+	Done       int       `json:"done"`
+	Total      int       `json:"total"`
+	StartedBy  string    `json:"started_by,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitzero"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// RekeyDatabaseResponse reports a completed database rekey
+type RekeyDatabaseResponse struct {
+	RekeyedAt time.Time `json:"rekeyed_at"`
+	Message   string    `json:"message"`
+}
+
+// Search modes of QueryRequest.SearchMode
+const (
+	SearchModeVector = "vector"
+	SearchModeHybrid = "hybrid"
+)
+
+// QueryRequest asks a question about the accessible documents
+type QueryRequest struct {
+	Question   string  `json:"question"`
+	TopK       int     `json:"top_k,omitempty"`
+	SearchMode string  `json:"search_mode,omitempty"`
+	MinScore   float64 `json:"min_score,omitempty"`
+	Template   string  `json:"template,omitempty"`
+	NoCache    bool    `json:"no_cache,omitempty"`
+	// Rehydrate restores values the server redacted before prompting; streamed
+	// deltas stay redacted and only the final response is restored
+	Rehydrate bool `json:"rehydrate,omitempty"`
+	// IncludeUsage asks for the tokens and stage timings of the query in the response
+	IncludeUsage bool `json:"include_usage,omitempty"`
+	// Excerpts asks for the passages of the sources matching the question
+	// instead of their full content
+	Excerpts bool `json:"excerpts,omitempty"`
+	// Filters restrict the search to documents whose metadata matches every condition
+	Filters map[string]MetadataFilter `json:"filters,omitempty"`
+	// DocumentIDs answers from exactly these readable documents instead of searching
+	DocumentIDs []string `json:"document_ids,omitempty"`
+	// Agent lets the LLM issue follow-up searches before answering, if the
+	// server enables agent mode
+	Agent bool `json:"agent,omitempty"`
+}
+
+// MetadataFilter is a condition on a top-level metadata field: a value to
+// match exactly or a range of numbers or strings
+type MetadataFilter struct {
+	Eq  interface{} `json:"eq,omitempty"`
+	Gt  interface{} `json:"gt,omitempty"`
+	Gte interface{} `json:"gte,omitempty"`
+	Lt  interface{} `json:"lt,omitempty"`
+	Lte interface{} `json:"lte,omitempty"`
+}
+
+// UnmarshalJSON accepts a condition object or a bare value to match exactly
+func (f *MetadataFilter) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		*f = MetadataFilter{}
+		return json.Unmarshal(data, &f.Eq)
+	}
+	type condition MetadataFilter
+	return json.Unmarshal(data, (*condition)(f))
+}
+
+// Source is a document retrieved for a question
+type Source struct {
+	Document
+	Score    float64 `json:"score"`
+	Distance float64 `json:"distance"`
+	// Included reports whether the document fit into the prompt
+	Included bool `json:"included"`
+	// Citation is the number the answer cites the source with, e.g. [1]; zero for excluded sources
+	Citation int `json:"citation,omitempty"`
+	// Cited reports whether the answer cites the source
+	Cited bool `json:"cited,omitempty"`
+	// Excerpts are the passages matching the question; only set if Excerpts
+	// was requested, and Content is then empty
+	Excerpts []Excerpt `json:"excerpts,omitempty"`
+}
+
+// Excerpt is a passage of a source. Start and End are byte offsets in the
+// text the document was ingested from; Highlights are byte offsets of the
+// matched question terms within Text.
+type Excerpt struct {
+	Text       string `json:"text"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	Highlights []Span `json:"highlights,omitempty"`
+}
+
+// Span is a range of byte offsets in a text
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// QueryResponse is the answer to a question
+type QueryResponse struct {
+	Answer          string   `json:"answer"`
+	Sources         []Source `json:"sources"`
+	SourcesIncluded int      `json:"sources_included"`
+	Cached          bool     `json:"cached,omitempty"`
+	// Filters echoes the metadata filters the search was restricted to
+	Filters map[string]MetadataFilter `json:"filters,omitempty"`
+	// StrippedCitations counts citation markers removed because they named no included source
+	StrippedCitations int `json:"stripped_citations,omitempty"`
+	// NoAccessibleDocuments reports that no document the user may access
+	// matched, so the answer is a standard response rather than generated
+	NoAccessibleDocuments bool `json:"no_accessible_documents,omitempty"`
+	// HiddenResults counts the top matches withheld because the user may not
+	// access them; nil unless the server reports hidden results
+	HiddenResults *int `json:"hidden_results,omitempty"`
+	// Searches lists the follow-up searches of agent queries
+	Searches []string `json:"searches,omitempty"`
+	// Usage reports the tokens and stage timings of the query; nil unless
+	// IncludeUsage was set
+	Usage *QueryUsage `json:"usage,omitempty"`
+}
+
+// QueryUsage is the cost and latency of answering a query. Embedding tokens
+// are estimated; LLM tokens are zero if the LLM was not called.
+type QueryUsage struct {
+	EmbeddingTokens int          `json:"embedding_tokens"`
+	PromptTokens    int          `json:"prompt_tokens"`
+	ResponseTokens  int          `json:"response_tokens"`
+	Timings         QueryTimings `json:"timings"`
+}
+
+// QueryTimings are the wall-clock durations of the query stages in
+// milliseconds; the search includes the permission checks
+type QueryTimings struct {
+	EmbedMs    float64 `json:"embed_ms"`
+	SearchMs   float64 `json:"search_ms"`
+	RerankMs   float64 `json:"rerank_ms,omitempty"`
+	GenerateMs float64 `json:"generate_ms,omitempty"`
+	TotalMs    float64 `json:"total_ms"`
+}
+
+// CompareRequest names the documents to compare and an optional focus
+type CompareRequest struct {
+	DocumentIDs []string `json:"document_ids"`
+	Focus       string   `json:"focus,omitempty"`
+}
+
+// CompareResponse is a structured comparison. Values of differences are
+// aligned with Documents.
+type CompareResponse struct {
+	Documents []ComparedDocument `json:"documents"`
+	// Metadata lists the fields whose values differ; nil where a document lacks one
+	Metadata []MetadataDifference `json:"metadata"`
+	Summary  string               `json:"summary"`
+	// Differences is empty if the model's answer was not structured; Summary
+	// then holds the whole answer
+	Differences []ContentDifference `json:"differences"`
+}
+
+// ComparedDocument identifies a compared document and whether it fit into
+// the model's context window
+type ComparedDocument struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Included bool   `json:"included"`
+}
+
+// MetadataDifference is a metadata field with its value in each compared document
+type MetadataDifference struct {
+	Key    string        `json:"key"`
+	Values []interface{} `json:"values"`
+}
+
+// ContentDifference is an aspect of the content with its value in each compared document
+type ContentDifference struct {
+	Aspect string   `json:"aspect"`
+	Values []string `json:"values"`
+}
+
+// Relations accepted by GrantPermission and RevokePermission
+const (
+	RelationViewer = "viewer" // read access to a document
+	RelationEditor = "editor" // modify a document
+	RelationWrite  = "write"  // ingest documents and change permissions; applies to the corpus
+)
+
+// PermissionChange grants or revokes a relation of a user or, if Group is set
+// instead of User, of a group's members. DocumentID is empty for RelationWrite.
+type PermissionChange struct {
+	User       string `json:"user,omitempty"`
+	Group      string `json:"group,omitempty"`
+	Relation   string `json:"relation"`
+	DocumentID string `json:"document_id,omitempty"`
+}
+
+// PermissionChangeResponse is returned after granting or revoking a relation
+type PermissionChangeResponse struct {
+	User     string `json:"user,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+	Message  string `json:"message"`
+}
+
+// Permissions lists the objects the authenticated user holds relations on
+type Permissions struct {
+	User        string   `json:"user"`
+	Permissions []string `json:"permissions"`
+}
+
+// DocumentAccess lists the relations the authenticated user holds on a document
+type DocumentAccess struct {
+	DocumentID string   `json:"document_id"`
+	User       string   `json:"user"`
+	Relations  []string `json:"relations"`
+}
+
+// Usage is the storage consumed by the authenticated user and, for users
+// with the write relation, the whole tenant
+type Usage struct {
+	User  string       `json:"user"`
+	Usage StorageUsage `json:"usage"`
+	// Limits are the user's quota; nil if unlimited
+	Limits       *QuotaLimits  `json:"limits,omitempty"`
+	TenantUsage  *StorageUsage `json:"tenant_usage,omitempty"`
+	TenantLimits *QuotaLimits  `json:"tenant_limits,omitempty"`
+	// Queries are the user's query totals since the server started; nil if
+	// the server does not account queries
+	Queries *QueryTotals `json:"queries,omitempty"`
+}
+
+// QueryTotals aggregate the usage of a user's queries
+type QueryTotals struct {
+	Queries         int64   `json:"queries"`
+	CachedQueries   int64   `json:"cached_queries"`
+	EmbeddingTokens int64   `json:"embedding_tokens"`
+	PromptTokens    int64   `json:"prompt_tokens"`
+	ResponseTokens  int64   `json:"response_tokens"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// StorageUsage counts documents and their content bytes
+type StorageUsage struct {
+	Documents    int   `json:"documents"`
+	ContentBytes int64 `json:"content_bytes"`
+}
+
+// QuotaLimits bound the storage of a user or tenant; zero fields are unlimited
+type QuotaLimits struct {
+	MaxDocuments    int   `json:"max_documents,omitempty"`
+	MaxContentBytes int64 `json:"max_content_bytes,omitempty"`
+}
+
+// API key scopes
+const (
+	ScopeIngest = "ingest"
+	ScopeQuery  = "query"
+)
+
+// APIKey authenticates a service as User on the endpoints of its Scopes
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	User      string     `json:"user"`
+	Scopes    []string   `json:"scopes"`
+	TenantID  string     `json:"tenant_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatedAPIKey is a new API key with its secret for WithAPIKey
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// ShareLink is a new share link with its token for SharedDocument
+type ShareLink struct {
+	ID         string    `json:"id"`
+	DocumentID string    `json:"document_id"`
+	CreatedBy  string    `json:"created_by"`
+	TenantID   string    `json:"tenant_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Token      string    `json:"token"`
+	// URL is the document's path with the token as query parameter
+	URL string `json:"url"`
+}
+
+// RelationTuple is a relation a user, or a group's members, hold on a document or the corpus
+type RelationTuple struct {
+	User     string `json:"user,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Relation string `json:"relation"`
+	// Object is a document ID or "corpus"
+	Object string `json:"object"`
+}
+
+// DocumentPermissions is a page of the relations held on a document
+type DocumentPermissions struct {
+	DocumentID string          `json:"document_id"`
+	Relations  []RelationTuple `json:"relations"`
+	// NextPageToken continues the listing; empty on the last page
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// BulkGrantFailure is a grant of GrantPermissions that was not made; Index
+// is its position in the request
+type BulkGrantFailure struct {
+	Index int              `json:"index"`
+	Grant PermissionChange `json:"grant"`
+	Error string           `json:"error"`
+}
+
+// BulkGrantResult reports the relations granted by GrantPermissions and the
+// grants that failed
+type BulkGrantResult struct {
+	Granted []RelationTuple    `json:"granted"`
+	Failed  []BulkGrantFailure `json:"failed"`
+	Message string             `json:"message"`
+}
+
+// PolicyResult reports the changes made, or planned on a dry run, to apply a policy
+type PolicyResult struct {
+	Granted []RelationTuple `json:"granted"`
+	Revoked []RelationTuple `json:"revoked"`
+	DryRun  bool            `json:"dry_run,omitempty"`
+	Message string          `json:"message"`
+}
+
+// Group lists a group's members and the relations granted to them
+type Group struct {
+	Group     string          `json:"group"`
+	Members   []string        `json:"members"`
+	Relations []RelationTuple `json:"relations"`
+}
+
+// GroupMemberResponse is returned after adding or removing a group member
+type GroupMemberResponse struct {
+	Group   string `json:"group"`
+	User    string `json:"user"`
+	Message string `json:"message"`
+}